@@ -0,0 +1,281 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// 6551 ACIA registers.
+const (
+	aciaData    = 0 // read: receive data, write: transmit data
+	aciaStatus  = 1 // read: status, write: programmed reset
+	aciaCommand = 2
+	aciaControl = 3
+)
+
+// 6551 status register bits.
+const (
+	aciaStatusParity  byte = 1 << 0 // parity error
+	aciaStatusFraming byte = 1 << 1 // framing error
+	aciaStatusOverrun byte = 1 << 2 // receiver overrun
+	aciaStatusRDRF    byte = 1 << 3 // receive data register full
+	aciaStatusTDRE    byte = 1 << 4 // transmit data register empty
+	aciaStatusDCD     byte = 1 << 5 // 0 = carrier detected
+	aciaStatusDSR     byte = 1 << 6 // 0 = data set ready
+	aciaStatusIRQ     byte = 1 << 7 // interrupt pending
+)
+
+// 6551 command register bits.
+const (
+	aciaCmdDTR      byte = 1 << 0 // 1 = enable receiver and transmitter
+	aciaCmdRxIRQDis byte = 1 << 1 // 1 = receiver interrupts disabled
+	aciaCmdTxMask   byte = 3 << 2 // transmitter interrupt and RTS control
+	aciaCmdTxIRQ    byte = 1 << 2 // transmitter control value enabling interrupts
+)
+
+// Baud rates selected by the low four bits of the control register. Rate 0
+// uses the external clock, which is treated as the fastest rate.
+var aciaBaudRates = [16]float64{
+	115200, 50, 75, 109.92, 134.58, 150, 300, 600,
+	1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200,
+}
+
+// An acia6551 emulates the 6551 Asynchronous Communications Interface
+// Adapter used by Apple serial ports. The serial line is connected to a
+// host stream, and characters move across it at the programmed baud
+// rate.
+type acia6551 struct {
+	apple2 *apple2
+
+	command byte
+	control byte
+	status  byte
+	rxData  byte
+	rxAt    uint64 // CPU cycle at which the next character may be received
+	txAt    uint64 // CPU cycle at which the transmitter becomes empty
+
+	// handshake holds received characters while the receive data register
+	// is full, as RTS/CTS flow control would, instead of overrunning it.
+	// pace is a delay after each character sent to the host, for slow
+	// receivers on the other end of the line.
+	handshake bool
+	pace      time.Duration
+
+	mu   sync.Mutex
+	conn io.ReadWriteCloser
+	rx   []byte      // characters received from the host
+	tx   chan []byte // characters waiting to be sent to the host
+}
+
+func newACIA6551(apple2 *apple2) *acia6551 {
+	a := &acia6551{apple2: apple2}
+	a.Reset()
+
+	// Characters arrive from the host at any time, so the interrupt line
+	// is updated on every scanline.
+	apple2.sched.Every(cyclesPerLine, 0, a.updateIRQ)
+	return a
+}
+
+// Reset performs a hardware reset.
+func (a *acia6551) Reset() {
+	a.command = 0
+	a.control = 0
+	a.status = aciaStatusTDRE
+}
+
+// Connect attaches the serial line to a host stream, replacing any
+// previous connection.
+func (a *acia6551) Connect(conn io.ReadWriteCloser) {
+	a.Disconnect()
+
+	tx := make(chan []byte, 64)
+	a.mu.Lock()
+	a.conn, a.tx = conn, tx
+	pace := a.pace
+	a.mu.Unlock()
+
+	go a.receive(conn)
+	go func() {
+		for b := range tx {
+			if _, err := conn.Write(b); err != nil {
+				break
+			}
+			if pace > 0 {
+				time.Sleep(pace)
+			}
+		}
+	}()
+}
+
+// SetFlowControl selects hardware handshaking on the receive side and a
+// delay after each character transmitted. The delay applies to
+// connections made afterwards.
+func (a *acia6551) SetFlowControl(handshake bool, pace time.Duration) {
+	a.mu.Lock()
+	a.handshake, a.pace = handshake, pace
+	a.mu.Unlock()
+}
+
+// Disconnect closes the host connection.
+func (a *acia6551) Disconnect() {
+	a.mu.Lock()
+	conn, tx := a.conn, a.tx
+	a.conn, a.tx, a.rx = nil, nil, nil
+	a.mu.Unlock()
+
+	if conn != nil {
+		close(tx)
+		conn.Close()
+	}
+}
+
+func (a *acia6551) receive(conn io.Reader) {
+	buf := make([]byte, 256)
+	for {
+		n, err := conn.Read(buf)
+		a.mu.Lock()
+		if a.conn != conn {
+			a.mu.Unlock()
+			return
+		}
+		a.rx = append(a.rx, buf[:n]...)
+		a.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// charCycles returns the number of CPU cycles needed to transfer one
+// character: a start bit, the data bits, an optional parity bit and the
+// stop bits.
+func (a *acia6551) charCycles() uint64 {
+	bits := 1 + 8 - int(a.control>>5&3) + 1
+	if a.command&0x20 != 0 {
+		bits++
+	}
+	if a.control&0x80 != 0 {
+		bits++
+	}
+	return uint64(float64(bits) * cpuClockRate / aciaBaudRates[a.control&0x0f])
+}
+
+// poll moves received characters into the receive data register and
+// updates the transmitter status.
+func (a *acia6551) poll() {
+	now := a.apple2.cpu.Cycles
+
+	if now >= a.txAt {
+		a.status |= aciaStatusTDRE
+	}
+
+	a.mu.Lock()
+	connected := a.conn != nil
+	held := a.handshake && a.status&aciaStatusRDRF != 0
+	if now >= a.rxAt && len(a.rx) > 0 && a.command&aciaCmdDTR != 0 && !held {
+		if a.status&aciaStatusRDRF != 0 {
+			a.status |= aciaStatusOverrun
+		}
+		a.rxData, a.rx = a.rx[0], a.rx[1:]
+		a.status |= aciaStatusRDRF
+		a.rxAt = now + a.charCycles()
+	}
+	a.mu.Unlock()
+
+	if connected {
+		a.status &^= aciaStatusDCD | aciaStatusDSR
+	} else {
+		a.status |= aciaStatusDCD | aciaStatusDSR
+	}
+}
+
+// updateIRQ drives the ACIA's interrupt line.
+func (a *acia6551) updateIRQ() {
+	a.apple2.ints.SetIRQ(a, a.IRQ())
+}
+
+// IRQ reports whether the ACIA is requesting an interrupt.
+func (a *acia6551) IRQ() bool {
+	a.poll()
+	rx := a.status&aciaStatusRDRF != 0 && a.command&aciaCmdRxIRQDis == 0
+	tx := a.status&aciaStatusTDRE != 0 && a.command&aciaCmdTxMask == aciaCmdTxIRQ
+	return rx || tx
+}
+
+// LoadByte reads an ACIA register.
+func (a *acia6551) LoadByte(reg uint16) byte {
+	a.poll()
+
+	switch reg & 3 {
+	case aciaData:
+		a.status &^= aciaStatusRDRF | aciaStatusOverrun | aciaStatusFraming | aciaStatusParity
+		return a.rxData
+	case aciaStatus:
+		v := a.status &^ aciaStatusIRQ
+		if a.IRQ() {
+			v |= aciaStatusIRQ
+		}
+		return v
+	case aciaCommand:
+		return a.command
+	default:
+		return a.control
+	}
+}
+
+// StoreByte writes an ACIA register.
+func (a *acia6551) StoreByte(reg uint16, v byte) {
+	a.poll()
+
+	switch reg & 3 {
+	case aciaData:
+		a.status &^= aciaStatusTDRE
+		a.txAt = a.apple2.cpu.Cycles + a.charCycles()
+		a.mu.Lock()
+		if a.tx != nil {
+			select {
+			case a.tx <- []byte{v}:
+			default:
+			}
+		}
+		a.mu.Unlock()
+	case aciaStatus:
+		// A programmed reset clears the low command bits and the overrun
+		// flag.
+		a.command &= 0xe0
+		a.status &^= aciaStatusOverrun
+	case aciaCommand:
+		a.command = v
+	default:
+		a.control = v
+	}
+}
+
+// saveState saves the ACIA's registers. The host connection is not part
+// of a snapshot, and characters in transit are discarded when one is
+// loaded.
+func (a *acia6551) saveState(sw *stateWriter) {
+	sw.Tag("ACIA")
+	sw.Byte(a.command)
+	sw.Byte(a.control)
+	sw.Byte(a.status)
+	sw.Byte(a.rxData)
+	sw.Uint64(a.rxAt)
+	sw.Uint64(a.txAt)
+}
+
+func (a *acia6551) loadState(sr *stateReader) {
+	sr.Tag("ACIA")
+	a.command = sr.Byte()
+	a.control = sr.Byte()
+	a.status = sr.Byte()
+	a.rxData = sr.Byte()
+	a.rxAt = sr.Uint64()
+	a.txAt = sr.Uint64()
+
+	a.mu.Lock()
+	a.rx = a.rx[:0]
+	a.mu.Unlock()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Defaults for ADTPro connections. ADTPro's SSC client talks at 115200
+// baud, which the Super Serial Card reaches through its external clock
+// setting.
+const (
+	adtproDefaultBaud = 115200
+	adtproDefaultSlot = 2
+)
+
+// adtproOptions holds the line settings of an ADTPro connection.
+type adtproOptions struct {
+	baud      int           // line speed of the emulated card and host port
+	handshake bool          // hold incoming characters until the last is read
+	pace      time.Duration // delay after each character sent to the host
+}
+
+// ConnectADTPro connects the Super Serial Card in a slot, or one of the
+// IIc's serial ports, to an ADTPro server for bootstrapping and disk
+// transfers. The endpoint is a host serial port, such as /dev/ttyUSB0,
+// which leads to a real Apple II or a machine running the server, or any
+// endpoint accepted by ConnectSerial, such as tcp:host:port for a server
+// on the network.
+//
+// The card's DIP switches are set for the baud rate, so that the
+// firmware and the ADTPro client come up at the server's speed. With the
+// handshake option, characters from the host wait while the receive
+// register is full rather than overrunning it, so that bootstrap text
+// arriving at full speed reaches the slower firmware intact. The pace
+// option delays each character sent to the host, for real hardware on
+// the other end that can't keep up.
+func (a *apple2) ConnectADTPro(slot int, endpoint string, opts adtproOptions) (string, io.Closer, error) {
+	acia := a.SerialCard(slot)
+	if acia == nil {
+		return "", nil, fmt.Errorf("no serial card in slot %d", slot)
+	}
+	if c, ok := a.sm.Card(slot).(*superSerialCard); ok {
+		code, ok := aciaBaudCode(opts.baud)
+		if !ok {
+			return "", nil, fmt.Errorf("the Super Serial Card does not support %d baud", opts.baud)
+		}
+		c.sw1 = code<<4 | sscModeCommunications
+	}
+	acia.SetFlowControl(opts.handshake, opts.pace)
+
+	if !strings.HasPrefix(endpoint, "/") && !strings.HasPrefix(strings.ToUpper(endpoint), "COM") {
+		return a.ConnectSerial(slot, endpoint)
+	}
+	conn, err := openSerialDevice(endpoint, opts.baud, opts.handshake)
+	if err != nil {
+		return "", nil, err
+	}
+	acia.Connect(conn)
+	return fmt.Sprintf("%s at %d baud", endpoint, opts.baud), closerFunc(acia.Disconnect), nil
+}
+
+// aciaBaudCode returns the 6551 control register code selecting a baud
+// rate.
+func aciaBaudCode(baud int) (byte, bool) {
+	for i, r := range aciaBaudRates {
+		if int(r) == baud {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
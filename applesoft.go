@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Applesoft program memory and the zero page pointers that describe it.
+const (
+	applesoftStart   = uint16(0x0801) // default start of a program (TXTTAB)
+	applesoftMaxLine = 63999          // highest valid line number
+
+	zpTXTTAB = 0x67 // start of the program
+	zpVARTAB = 0x69 // start of simple variables
+	zpARYTAB = 0x6b // start of array variables
+	zpSTREND = 0x6d // end of array variables
+	zpFRETOP = 0x6f // bottom of string storage
+	zpMEMSIZ = 0x73 // HIMEM
+	zpPRGEND = 0xaf // end of the program
+)
+
+// applesoftTokens holds the Applesoft keywords, in token order starting at
+// $80. The tokenizer tries them in this order.
+var applesoftTokens = []string{
+	"END", "FOR", "NEXT", "DATA", "INPUT", "DEL", "DIM", "READ",
+	"GR", "TEXT", "PR#", "IN#", "CALL", "PLOT", "HLIN", "VLIN",
+	"HGR2", "HGR", "HCOLOR=", "HPLOT", "DRAW", "XDRAW", "HTAB", "HOME",
+	"ROT=", "SCALE=", "SHLOAD", "TRACE", "NOTRACE", "NORMAL", "INVERSE", "FLASH",
+	"COLOR=", "POP", "VTAB", "HIMEM:", "LOMEM:", "ONERR", "RESUME", "RECALL",
+	"STORE", "SPEED=", "LET", "GOTO", "RUN", "IF", "RESTORE", "&",
+	"GOSUB", "RETURN", "REM", "STOP", "ON", "WAIT", "LOAD", "SAVE",
+	"DEF", "POKE", "PRINT", "CONT", "LIST", "CLEAR", "GET", "NEW",
+	"TAB(", "TO", "FN", "SPC(", "THEN", "AT", "NOT", "STEP",
+	"+", "-", "*", "/", "^", "AND", "OR", ">",
+	"=", "<", "SGN", "INT", "ABS", "USR", "FRE", "SCRN(",
+	"PDL", "POS", "SQR", "RND", "LOG", "EXP", "COS", "SIN",
+	"TAN", "ATN", "PEEK", "LEN", "STR$", "VAL", "ASC", "CHR$",
+	"LEFT$", "RIGHT$", "MID$",
+}
+
+// Tokens given special treatment by the tokenizer.
+const (
+	tokenDATA  = 0x83
+	tokenREM   = 0xb2
+	tokenPRINT = 0xba
+	tokenAT    = 0xc5
+	tokenATN   = 0xe1
+)
+
+var errApplesoftLineNumber = errors.New("missing or invalid line number")
+
+// An applesoftLine is a tokenized line of a program.
+type applesoftLine struct {
+	num    int
+	tokens []byte
+}
+
+// tokenizeApplesoft converts a program listing into the Applesoft memory
+// format for loading at addr: a linked list of lines, each starting with
+// the address of the next line and the line number, and ending with a
+// zero byte. Two zero bytes end the program. As when a program is typed
+// in, lines may appear in any order, and a line replaces an earlier line
+// with the same number.
+func tokenizeApplesoft(r io.Reader, addr uint16) ([]byte, error) {
+	lines := make(map[int]applesoftLine)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		l, err := tokenizeApplesoftLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		lines[l.num] = l
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	nums := make([]int, 0, len(lines))
+	for num := range lines {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	var prog []byte
+	for _, num := range nums {
+		l := lines[num]
+		next := int(addr) + len(prog) + 4 + len(l.tokens) + 1
+		if next > 0xffff {
+			return nil, errors.New("program too large")
+		}
+		prog = append(prog, byte(next), byte(next>>8), byte(num), byte(num>>8))
+		prog = append(prog, l.tokens...)
+		prog = append(prog, 0)
+	}
+	return append(prog, 0, 0), nil
+}
+
+// tokenizeApplesoftLine tokenizes a numbered line the way the Applesoft
+// input parser does. Outside of strings, REM comments and DATA
+// statements, spaces are dropped, letters are converted to uppercase, and
+// keywords are recognized even when spaces appear within them.
+func tokenizeApplesoftLine(text string) (applesoftLine, error) {
+	i := 0
+	for i < len(text) && text[i] >= '0' && text[i] <= '9' {
+		i++
+	}
+	num, err := strconv.Atoi(text[:i])
+	if err != nil || num > applesoftMaxLine {
+		return applesoftLine{}, errApplesoftLineNumber
+	}
+
+	src := text[i:]
+	var out []byte
+	data := false
+	for p := 0; p < len(src); {
+		c := src[p]
+		switch {
+		case c == ' ' && !data:
+			p++
+			continue
+		case c == '"':
+			end := strings.IndexByte(src[p+1:], '"')
+			if end < 0 {
+				out = append(out, src[p:]...)
+				p = len(src)
+			} else {
+				out = append(out, src[p:p+end+2]...)
+				p += end + 2
+			}
+			continue
+		case data:
+			if c == ':' {
+				data = false
+			}
+			out = append(out, c)
+			p++
+			continue
+		case c >= '0' && c <= ';':
+			out = append(out, c)
+			p++
+			continue
+		case c == '?':
+			out = append(out, tokenPRINT)
+			p++
+			continue
+		}
+
+		token, n := matchApplesoftToken(src[p:])
+		if token == tokenAT {
+			// "AT" followed by N is ATN, and followed by O is A TO.
+			rest := src[p+n:]
+			j := len(rest) - len(strings.TrimLeft(rest, " "))
+			if j < len(rest) {
+				switch upper(rest[j]) {
+				case 'N':
+					token, n = tokenATN, n+j+1
+				case 'O':
+					token = 0
+				}
+			}
+		}
+		switch {
+		case token == tokenREM:
+			out = append(out, token)
+			out = append(out, src[p+n:]...)
+			p = len(src)
+		case token != 0:
+			out = append(out, token)
+			p += n
+			data = token == tokenDATA
+		default:
+			out = append(out, upper(c))
+			p++
+		}
+	}
+	return applesoftLine{num: num, tokens: out}, nil
+}
+
+// matchApplesoftToken returns the first keyword at the start of s,
+// ignoring spaces and case, and the number of characters it spans. It
+// returns 0 if no keyword matches.
+func matchApplesoftToken(s string) (token byte, n int) {
+	for t, kw := range applesoftTokens {
+		i, k := 0, 0
+		for k < len(kw) && i < len(s) {
+			if s[i] == ' ' {
+				i++
+				continue
+			}
+			if upper(s[i]) != kw[k] {
+				break
+			}
+			i++
+			k++
+		}
+		if k == len(kw) {
+			return byte(0x80 + t), i
+		}
+	}
+	return 0, 0
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// LoadApplesoft tokenizes an Applesoft BASIC program listing and loads
+// it into memory, replacing the current program, as though it had been
+// typed in. The zero page pointers to the program and variables are set
+// as the NEW and CLEAR commands leave them, so the program can be run or
+// listed immediately.
+func (a *apple2) LoadApplesoft(r io.Reader) error {
+	m := a.mmu
+	start := m.LoadAddress(zpTXTTAB)
+	if start < 0x0801 || start >= 0xc000 {
+		start = applesoftStart
+	}
+	prog, err := tokenizeApplesoft(r, start)
+	if err != nil {
+		return err
+	}
+
+	himem := m.LoadAddress(zpMEMSIZ)
+	if himem == 0 || himem > 0xc000 {
+		himem = 0x9600
+	}
+	end := int(start) + len(prog)
+	if end > int(himem) {
+		return errors.New("program too large")
+	}
+
+	m.StoreByte(start-1, 0)
+	m.StoreBytes(start, prog)
+	storeZPAddress(m, zpTXTTAB, start)
+	for _, zp := range []byte{zpVARTAB, zpARYTAB, zpSTREND, zpPRGEND} {
+		storeZPAddress(m, zp, uint16(end))
+	}
+	storeZPAddress(m, zpFRETOP, himem)
+	storeZPAddress(m, zpMEMSIZ, himem)
+	return nil
+}
+
+// LoadApplesoftFile loads an Applesoft BASIC program listing from a file.
+func (a *apple2) LoadApplesoftFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return a.LoadApplesoft(file)
+}
+
+func storeZPAddress(m *mmu, zp byte, addr uint16) {
+	m.StoreByte(uint16(zp), byte(addr))
+	m.StoreByte(uint16(zp)+1, byte(addr>>8))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestApplesoftTokenize(t *testing.T) {
+	if n := len(applesoftTokens); n != 0xeb-0x80 {
+		t.Fatalf("Expected tokens $80..$EA, got %d tokens\n", n)
+	}
+
+	cases := []struct {
+		line     string
+		expected string
+	}{
+		{`10 HOME`, "\x97"},
+		{`20 print "Hi there":GOTO 10`, "\xba\"Hi there\":\xab10"},
+		{`30 REM  keep Case`, "\xb2  keep Case"},
+		{`40 DATA 1, two ,3:x=atn(1)`, "\x83 1, two ,3:X\xd0\xe1(1)"},
+		{`50 FOR I = A TO B`, "\x81I\xd0A\xc1B"},
+		{`60 ? P R I N T`, "\xba\xba"},
+		{`70 HCOLOR= 3: HPLOT 0,0`, "\x923:\x930,0"},
+	}
+	for _, c := range cases {
+		l, err := tokenizeApplesoftLine(c.line)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v\n", c.line, err)
+			continue
+		}
+		if string(l.tokens) != c.expected {
+			t.Errorf("%s: expected % X, got % X\n", c.line, c.expected, l.tokens)
+		}
+	}
+
+	if _, err := tokenizeApplesoftLine("PRINT"); err == nil {
+		t.Errorf("Expected an error for a missing line number\n")
+	}
+}
+
+func TestApplesoftLoad(t *testing.T) {
+	a := newApple2()
+	src := "20 END\n10 HOME\n\n20 GOTO 10\n"
+	if err := a.LoadApplesoft(strings.NewReader(src)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	expected := []byte{
+		0x00,
+		0x07, 0x08, 0x0a, 0x00, 0x97, 0x00, // 0801: 10 HOME
+		0x0f, 0x08, 0x14, 0x00, 0xab, '1', '0', 0x00, // 0807: 20 GOTO 10
+		0x00, 0x00,
+	}
+	mem := make([]byte, len(expected))
+	a.mmu.LoadBytes(0x0800, mem)
+	if !bytes.Equal(mem, expected) {
+		t.Errorf("Unexpected program % X\n", mem)
+	}
+
+	end := uint16(0x0801 + len(expected) - 1)
+	for _, zp := range []uint16{zpTXTTAB, zpVARTAB, zpARYTAB, zpSTREND, zpPRGEND, zpFRETOP} {
+		want := end
+		switch zp {
+		case zpTXTTAB:
+			want = 0x0801
+		case zpFRETOP:
+			want = 0x9600
+		}
+		if v := a.mmu.LoadAddress(zp); v != want {
+			t.Errorf("Zero page $%02X: expected $%04X, got $%04X\n", zp, want, v)
+		}
+	}
+}
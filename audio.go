@@ -0,0 +1,263 @@
+package main
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	cpuClockRate    = 1020484.0                      // NTSC Apple2 CPU clock rate in Hz
+	audioSampleRate = 44100                          // output sample rate in Hz
+	cyclesPerSample = cpuClockRate / audioSampleRate // CPU cycles per output sample
+
+	defaultAudioLatency = 100 * time.Millisecond
+)
+
+// An audioSource is a device that produces sound. Sources render their
+// output by mixing it into a buffer of samples, each of which spans
+// cyclesPerSample CPU cycles. Sample values are nominally in the range
+// -1..1.
+type audioSource interface {
+	RenderSamples(buf []float32, start float64)
+}
+
+// The audio engine periodically collects samples from all audio sources,
+// mixes them, and writes the result into a buffer from which the front end
+// audio device reads.
+type audio struct {
+	apple2 *apple2
+
+	sources []audioSource
+	buf     *audioBuffer
+	cycle   float64    // CPU cycle at which the next sample begins
+	mix     []float32  // scratch buffer for mixing sources
+	out     []int16    // scratch buffer for converted samples
+	volume  float64    // master volume (0..1)
+	wav     *wavWriter // active WAV recording, if any
+	discard bool       // true = rendered samples are dropped
+}
+
+func newAudio(apple2 *apple2) *audio {
+	return &audio{
+		apple2: apple2,
+	}
+}
+
+func (au *audio) Init() {
+	au.buf = newAudioBuffer(defaultAudioLatency)
+	au.cycle = float64(au.apple2.cpu.Cycles)
+	au.volume = 0.5
+	au.AddSource(au.apple2.sp)
+}
+
+// AddSource adds a sound-producing device to the audio mix.
+func (au *audio) AddSource(src audioSource) {
+	au.sources = append(au.sources, src)
+}
+
+// RemoveSource removes a sound-producing device from the audio mix.
+func (au *audio) RemoveSource(src audioSource) {
+	for i, s := range au.sources {
+		if s == src {
+			au.sources = append(au.sources[:i], au.sources[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetVolume sets the master volume (0..1).
+func (au *audio) SetVolume(v float64) {
+	au.volume = clampLevel(v)
+}
+
+// SetLatency sets the maximum amount of audio buffered for the front end.
+// Lower latencies make audio more responsive but increase the likelihood
+// of underruns.
+func (au *audio) SetLatency(d time.Duration) {
+	au.buf.SetLatency(d)
+}
+
+// ReadSamples fills p with 16-bit mono samples at audioSampleRate. It is
+// called by the front end audio device and is safe to call from another
+// goroutine. If not enough audio has been generated, the remainder of p is
+// filled with silence.
+func (au *audio) ReadSamples(p []int16) {
+	if n := au.buf.Read(p); n > 0 {
+		au.apple2.log.Logger(logAudio).Debug("audio underrun", "samples", n)
+	}
+}
+
+// Update renders all samples covering the CPU cycles executed since the
+// last update.
+func (au *audio) Update() {
+	now := float64(au.apple2.cpu.Cycles)
+	n := int((now - au.cycle) / cyclesPerSample)
+	if n <= 0 {
+		return
+	}
+
+	if cap(au.mix) < n {
+		au.mix = make([]float32, n)
+		au.out = make([]int16, n)
+	}
+	mix, out := au.mix[:n], au.out[:n]
+	for i := range mix {
+		mix[i] = 0
+	}
+
+	for _, src := range au.sources {
+		src.RenderSamples(mix, au.cycle)
+	}
+	au.cycle += float64(n) * cyclesPerSample
+	if au.discard {
+		return
+	}
+
+	for i, v := range mix {
+		s := math.Max(-1, math.Min(1, float64(v)*au.volume))
+		out[i] = int16(s * math.MaxInt16)
+	}
+	au.buf.Write(out)
+
+	if au.wav != nil {
+		au.wav.WriteSamples(out)
+	}
+}
+
+// StartRecording begins capturing the mixed audio output to w in WAV
+// format. Any recording already in progress is stopped first.
+func (au *audio) StartRecording(w io.WriteSeeker) error {
+	if au.wav != nil {
+		au.StopRecording()
+	}
+
+	wav, err := newWAVWriter(w, audioSampleRate)
+	if err != nil {
+		return err
+	}
+	au.wav = wav
+	return nil
+}
+
+// StopRecording ends the current audio recording and finalizes the WAV
+// data. It does not close the writer passed to StartRecording.
+func (au *audio) StopRecording() error {
+	if au.wav == nil {
+		return errNotRecording
+	}
+	err := au.wav.Close()
+	au.wav = nil
+	return err
+}
+
+// An audioBuffer is a ring buffer of samples shared between the emulator,
+// which writes to it, and the front end, which reads from it. The amount of
+// buffered audio is limited to the configured latency; when the emulator
+// gets too far ahead, the oldest samples are discarded.
+type audioBuffer struct {
+	mu        sync.Mutex
+	samples   []int16 // ring buffer storage
+	r         int     // read position
+	n         int     // number of buffered samples
+	latency   int     // maximum number of buffered samples
+	underruns uint64  // number of reads that ran out of samples
+}
+
+func newAudioBuffer(latency time.Duration) *audioBuffer {
+	b := &audioBuffer{samples: make([]int16, audioSampleRate)}
+	b.SetLatency(latency)
+	return b
+}
+
+func (b *audioBuffer) SetLatency(d time.Duration) {
+	n := int(d.Seconds() * audioSampleRate)
+	if n < 1 {
+		n = 1
+	}
+	if n > len(b.samples) {
+		n = len(b.samples)
+	}
+
+	b.mu.Lock()
+	b.latency = n
+	b.mu.Unlock()
+}
+
+func (b *audioBuffer) Write(p []int16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range p {
+		if b.n == b.latency {
+			b.r = (b.r + 1) % len(b.samples)
+			b.n--
+		}
+		b.samples[(b.r+b.n)%len(b.samples)] = s
+		b.n++
+	}
+}
+
+// Read fills p with buffered samples, and the remainder of p with silence.
+// It returns the number of silent samples.
+func (b *audioBuffer) Read(p []int16) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := 0
+	for ; i < len(p) && b.n > 0; i++ {
+		p[i] = b.samples[b.r]
+		b.r = (b.r + 1) % len(b.samples)
+		b.n--
+	}
+	n := len(p) - i
+	if n > 0 {
+		b.underruns++
+		for ; i < len(p); i++ {
+			p[i] = 0
+		}
+	}
+	return n
+}
+
+// Underruns returns the number of reads that ran out of samples.
+func (au *audio) Underruns() uint64 {
+	b := au.buf
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.underruns
+}
+
+// Buffered returns the number of samples waiting to be read.
+func (b *audioBuffer) Buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+// A highPassFilter is a one-pole high-pass filter used to remove the DC
+// offset from a device's output.
+type highPassFilter struct {
+	alpha float64
+	in    float64 // previous input
+	out   float64 // previous output
+}
+
+func newHighPassFilter(cutoff float64) highPassFilter {
+	return highPassFilter{alpha: 1 - onePoleAlpha(cutoff)}
+}
+
+func (f *highPassFilter) Filter(v float64) float64 {
+	f.out = f.alpha * (f.out + v - f.in)
+	f.in = v
+	return f.out
+}
+
+// onePoleAlpha returns the coefficient of a one-pole low-pass filter with
+// the given cutoff frequency at the audio sample rate.
+func onePoleAlpha(cutoff float64) float64 {
+	rc := 1 / (2 * math.Pi * cutoff)
+	dt := 1.0 / audioSampleRate
+	return dt / (rc + dt)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAudio(t *testing.T) {
+	a := newApple2()
+
+	filename := filepath.Join(t.TempDir(), "out.wav")
+	stop, err := a.RecordAudio(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Toggle the speaker at roughly 1kHz for one frame.
+	for i := 0; i < 34; i++ {
+		a.cpu.Cycles += 500
+		a.mmu.LoadByte(0xc030)
+	}
+	a.au.Update()
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := int(binary.LittleEndian.Uint32(b[40:]))
+	if n != len(b)-wavHeaderSize {
+		t.Errorf("Expected data size %d, got %d\n", len(b)-wavHeaderSize, n)
+	}
+
+	cycles := float64(a.cpu.Cycles)
+	expected := int(cycles/cyclesPerSample) * 2
+	if n != expected {
+		t.Errorf("Expected %d bytes of samples, got %d\n", expected, n)
+	}
+
+	var peak int16
+	for i := wavHeaderSize; i < len(b); i += 2 {
+		s := int16(binary.LittleEndian.Uint16(b[i:]))
+		if s > peak {
+			peak = s
+		}
+	}
+	if peak == 0 {
+		t.Errorf("Expected non-silent audio\n")
+	}
+}
+
+func TestDiskSound(t *testing.T) {
+	a := newApple2()
+	d := newDiskII(a, nil)
+	a.sm.Insert(6, d)
+
+	// Returns the peak level of the drive sounds over one frame, during
+	// which the head steps out one half track.
+	peak := func() float64 {
+		a.mmu.LoadByte(0xc0e3)
+		a.mmu.LoadByte(0xc0e2)
+		buf := make([]float32, 700)
+		d.RenderSamples(buf, float64(a.cpu.Cycles))
+		a.cpu.Cycles += cyclesPerFrame
+		var max float64
+		for _, v := range buf {
+			max = math.Max(max, math.Abs(float64(v)))
+		}
+		return max
+	}
+
+	if v := peak(); v < clunkLevel/4 {
+		t.Errorf("Expected a clunk, got a peak of %f\n", v)
+	}
+	d.SetSound(false)
+	if v := peak(); v != 0 {
+		t.Errorf("Expected silence, got a peak of %f\n", v)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	// a2auditBinary is the assembled a2audit program, which runs from
+	// a2auditOrg, as DOS's BRUN would load it.
+	a2auditBinary = "audit.bin"
+	a2auditOrg    = 0x6000
+
+	// a2auditPassed is the text the a2audit suite shows when every test
+	// passes.
+	a2auditPassed = "ALL TESTS PASSED"
+
+	// a2auditEnv makes TestA2Audit fail, rather than skip, when the
+	// program or a model's ROM is missing, for builds that provide them.
+	a2auditEnv = "APPLE2GO_A2AUDIT"
+)
+
+// TestA2Audit runs the a2audit suite of soft switch and memory tests
+// (github.com/zellyn/a2audit) on each model, as an independent check of
+// the IOU and MMU. Each model boots its ROM from resources/ to the
+// BASIC prompt, then runs resources/audit.bin from memory, so no disk
+// controller is needed. A failing test shows its error code and
+// description on the screen. The tree doesn't include the program or
+// every model's ROM, so TestAuditLanguageCard and TestAuditAuxMemory
+// check the same tables of switch settings without them.
+func TestA2Audit(t *testing.T) {
+	required := os.Getenv(a2auditEnv) != ""
+	for _, model := range []machineModel{modelIIPlus, modelIIe, modelIIeEnhanced} {
+		t.Run(model.String(), func(t *testing.T) {
+			a := newApple2Model(model)
+			rs, err := loadROMSet("resources")
+			if err == nil {
+				err = rs.Validate(model, false)
+			}
+			if err == nil {
+				err = a.LoadROMSet(rs)
+			}
+			if err == nil {
+				_, err = os.Stat(filepath.Join("resources", a2auditBinary))
+			}
+			if err != nil {
+				if required {
+					t.Fatalf("%v (%s is set)\n", err, a2auditEnv)
+				}
+				t.Skipf("%v (set %s to require it)", err, a2auditEnv)
+			}
+
+			a.Reset()
+			if _, err := a.RunUntil(runGoal{text: "]", addr: -1}); err != nil {
+				t.Fatalf("ROM didn't boot to BASIC: %v\n%s", err, a.ds.Text())
+			}
+			if err := a.LoadBinaryFile(filepath.Join("resources", a2auditBinary), a2auditOrg, true); err != nil {
+				t.Fatal(err)
+			}
+
+			a.TrapBRK(func(pc uint16) bool { return true })
+			stop, err := a.RunUntil(runGoal{text: a2auditPassed, addr: 0xff69, cycles: 60 * cpuClockRate})
+			if err != nil || stop != runStopText {
+				t.Errorf("a2audit failed at %04X (%v):\n%s", a.cpu.Reg.PC, err, a.ds.Text())
+			}
+		})
+	}
+}
+
+// auditAccess is one access to a soft switch in an audit sequence, which
+// is a read unless write is set.
+type auditAccess struct {
+	addr  uint16
+	write bool
+}
+
+func (c auditAccess) String() string {
+	if c.write {
+		return fmt.Sprintf("W%04X", c.addr)
+	}
+	return fmt.Sprintf("R%04X", c.addr)
+}
+
+// Where an audited location is read from or written to.
+const (
+	auditROM   = "ROM"
+	auditMain  = "main"
+	auditAux   = "aux"
+	auditBank1 = "bank 1"
+	auditBank2 = "bank 2"
+	auditNone  = "none"
+)
+
+// TestAuditLanguageCard checks a2audit's language card sequences on each
+// model: after a sequence of switch accesses, where $D17B and $FE1F are
+// read from and where writes to them go. Writing is enabled only by two
+// reads of odd switches in a row.
+func TestAuditLanguageCard(t *testing.T) {
+	r := func(addrs ...uint16) []auditAccess {
+		seq := make([]auditAccess, len(addrs))
+		for i, addr := range addrs {
+			seq[i] = auditAccess{addr: addr}
+		}
+		return seq
+	}
+	w := func(addr uint16) auditAccess { return auditAccess{addr: addr, write: true} }
+
+	cases := []struct {
+		seq            []auditAccess
+		readD, readF   string
+		writeD, writeF string
+	}{
+		{r(0xc088), auditBank1, auditMain, auditNone, auditNone},
+		{r(0xc080), auditBank2, auditMain, auditNone, auditNone},
+		{r(0xc08a), auditROM, auditROM, auditNone, auditNone},
+		{r(0xc082), auditROM, auditROM, auditNone, auditNone},
+		{r(0xc089), auditROM, auditROM, auditNone, auditNone},
+		{r(0xc089, 0xc089), auditROM, auditROM, auditBank1, auditMain},
+		{r(0xc081, 0xc081), auditROM, auditROM, auditBank2, auditMain},
+		{r(0xc08b), auditBank1, auditMain, auditNone, auditNone},
+		{r(0xc08b, 0xc08b), auditBank1, auditMain, auditBank1, auditMain},
+		{r(0xc083, 0xc083), auditBank2, auditMain, auditBank2, auditMain},
+		{r(0xc083, 0xc08b), auditBank1, auditMain, auditBank1, auditMain},
+		{r(0xc08b, 0xc083), auditBank2, auditMain, auditBank2, auditMain},
+		{r(0xc083, 0xc083, 0xc080), auditBank2, auditMain, auditNone, auditNone},
+		{r(0xc083, 0xc083, 0xc08b), auditBank1, auditMain, auditBank1, auditMain},
+		{r(0xc083, 0xc083, 0xc088, 0xc08b), auditBank1, auditMain, auditNone, auditNone},
+		{[]auditAccess{w(0xc08b), w(0xc08b)}, auditBank1, auditMain, auditNone, auditNone},
+		{[]auditAccess{{addr: 0xc08b}, w(0xc08b), {addr: 0xc08b}}, auditBank1, auditMain, auditNone, auditNone},
+		{[]auditAccess{{addr: 0xc08b}, {addr: 0xc08b}, w(0xc08b)}, auditBank1, auditMain, auditBank1, auditMain},
+		{[]auditAccess{{addr: 0xc08b}, {addr: 0xc08b}, w(0xc080)}, auditBank2, auditMain, auditNone, auditNone},
+	}
+
+	for _, model := range []machineModel{modelIIPlus, modelIIe, modelIIeEnhanced} {
+		t.Run(model.String(), func(t *testing.T) {
+			a := newApple2Model(model)
+			m := a.mmu
+			markers := map[string]byte{auditROM: 0x53, auditMain: 0x33, auditBank1: 0x11, auditBank2: 0x22}
+			m.systemROM[0x117b] = markers[auditROM]
+			m.systemROM[0x3e1f] = markers[auditROM]
+
+			for _, c := range cases {
+				m.mainRAM[0xc17b] = markers[auditBank1]
+				m.mainRAM[0xd17b] = markers[auditBank2]
+				m.mainRAM[0xfe1f] = markers[auditMain]
+
+				// Start from ROM with writing disabled, as a2audit does.
+				m.LoadByte(0xc082)
+				for _, acc := range c.seq {
+					if acc.write {
+						m.StoreByte(acc.addr, 0)
+					} else {
+						m.LoadByte(acc.addr)
+					}
+				}
+				seq := fmt.Sprint(c.seq)
+
+				if v := m.LoadByte(0xd17b); v != markers[c.readD] {
+					t.Errorf("%s: expected $D17B read from %s, got %02X\n", seq, c.readD, v)
+				}
+				if v := m.LoadByte(0xfe1f); v != markers[c.readF] {
+					t.Errorf("%s: expected $FE1F read from %s, got %02X\n", seq, c.readF, v)
+				}
+
+				m.StoreByte(0xd17b, 0x44)
+				m.StoreByte(0xfe1f, 0x55)
+				wroteD := auditNone
+				switch {
+				case m.mainRAM[0xc17b] == 0x44:
+					wroteD = auditBank1
+				case m.mainRAM[0xd17b] == 0x44:
+					wroteD = auditBank2
+				}
+				wroteF := auditNone
+				if m.mainRAM[0xfe1f] == 0x55 {
+					wroteF = auditMain
+				}
+				if wroteD != c.writeD || wroteF != c.writeF {
+					t.Errorf("%s: expected writes to %s and %s, got %s and %s\n", seq, c.writeD, c.writeF, wroteD, wroteF)
+				}
+			}
+		})
+	}
+}
+
+// TestAuditAuxMemory checks a2audit's aux memory tests on the IIe models:
+// for every setting of RAMRD, RAMWRT, ALTZP, 80STORE, PAGE2 and HIRES,
+// whether locations across the address space, including the language
+// card's $E000-$FFFF RAM, are read from and written to main or aux memory.
+func TestAuditAuxMemory(t *testing.T) {
+	switches := []struct {
+		name    string
+		off, on uint16
+		write   bool
+	}{
+		{"RAMRD", 0xc002, 0xc003, true},
+		{"RAMWRT", 0xc004, 0xc005, true},
+		{"ALTZP", 0xc008, 0xc009, true},
+		{"80STORE", 0xc000, 0xc001, true},
+		{"PAGE2", 0xc054, 0xc055, false},
+		{"HIRES", 0xc056, 0xc057, false},
+	}
+	const (
+		ramrd = 1 << iota
+		ramwrt
+		altzp
+		store80
+		page2
+		hires
+	)
+	addrs := []uint16{0x00ff, 0x0100, 0x01ff, 0x0200, 0x03ff, 0x0400, 0x07ff, 0x0800, 0x0bff, 0x1fff, 0x2000, 0x3fff, 0x4000, 0x5fff, 0xbfff, 0xe000, 0xffff}
+
+	// expected returns where a location is read from and written to,
+	// following the IIe's memory management rules.
+	expected := func(addr uint16, set int) (rd, wr string) {
+		bank := func(aux bool) string {
+			if aux {
+				return auditAux
+			}
+			return auditMain
+		}
+		switch {
+		case addr < 0x0200, addr >= 0xd000:
+			return bank(set&altzp != 0), bank(set&altzp != 0)
+		case set&store80 != 0 && addr >= 0x0400 && addr < 0x0800,
+			set&store80 != 0 && set&hires != 0 && addr >= 0x2000 && addr < 0x4000:
+			return bank(set&page2 != 0), bank(set&page2 != 0)
+		}
+		return bank(set&ramrd != 0), bank(set&ramwrt != 0)
+	}
+
+	for _, model := range []machineModel{modelIIe, modelIIeEnhanced} {
+		t.Run(model.String(), func(t *testing.T) {
+			a := newApple2Model(model)
+			m := a.mmu
+			m.LoadByte(0xc08b)
+			m.LoadByte(0xc08b)
+			for set := 0; set < 1<<len(switches); set++ {
+				var names []string
+				for i, sw := range switches {
+					addr := sw.off
+					if set&(1<<i) != 0 {
+						addr = sw.on
+						names = append(names, sw.name)
+					}
+					if sw.write {
+						m.StoreByte(addr, 0)
+					} else {
+						m.LoadByte(addr)
+					}
+				}
+				desc := strings.Join(names, "+")
+				if desc == "" {
+					desc = "none"
+				}
+
+				for _, addr := range addrs {
+					m.mainRAM[addr], m.auxRAM[addr] = 0x01, 0x02
+					rd, wr := expected(addr, set)
+					got := map[byte]string{0x01: auditMain, 0x02: auditAux}[m.LoadByte(addr)]
+					if got != rd {
+						t.Errorf("%s: expected $%04X read from %s, got %s\n", desc, addr, rd, got)
+					}
+					m.StoreByte(addr, 0x03)
+					switch {
+					case m.mainRAM[addr] == 0x03:
+						got = auditMain
+					case m.auxRAM[addr] == 0x03:
+						got = auditAux
+					default:
+						got = auditNone
+					}
+					if got != wr {
+						t.Errorf("%s: expected $%04X written to %s, got %s\n", desc, addr, wr, got)
+					}
+				}
+			}
+		})
+	}
+}
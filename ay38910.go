@@ -0,0 +1,243 @@
+package main
+
+const (
+	ayTickCycles = 8.0 // CPU cycles per internal AY-3-8910 tick
+
+	ayRegEnvFine   = 11
+	ayRegEnvCoarse = 12
+	ayRegEnvShape  = 13
+)
+
+// Output level for each of the AY-3-8910's 16 logarithmic volume steps.
+var ayVolumes = [16]float64{
+	0.0000, 0.0106, 0.0150, 0.0222, 0.0320, 0.0466, 0.0665, 0.1039,
+	0.1237, 0.1986, 0.2803, 0.3548, 0.4702, 0.5900, 0.7747, 1.0000,
+}
+
+// An ayWrite is a register write waiting to be applied to the sound
+// generator at a particular CPU cycle.
+type ayWrite struct {
+	cycle uint64
+	reg   byte
+	value byte
+}
+
+// An ay38910 emulates the General Instrument AY-3-8910 programmable sound
+// generator. The chip is driven through its bus control pins by the ports
+// of a 6522: port A carries data, and the low 3 bits of port B select the
+// bus function. Register writes are time-stamped and replayed during
+// sample rendering so that changes take effect at the correct moment.
+type ay38910 struct {
+	clock func() uint64 // returns the current CPU cycle
+
+	regs    [16]byte  // register values as seen by the CPU
+	latch   byte      // currently selected register
+	bus     byte      // value on the data bus
+	reading bool      // true when the chip drives the data bus
+	writes  []ayWrite // register writes not yet rendered
+
+	// Sound generation state.
+	sregs      [16]byte // register values as seen by the sound generator
+	toneCount  [3]int
+	toneOut    [3]bool
+	noiseCount int
+	noiseLFSR  uint32
+	noiseOut   bool
+	envCount   int
+	envStep    int
+	envAttack  bool
+	envHolding bool
+	ticks      float64 // fractional ticks carried between samples
+}
+
+func newAY38910(clock func() uint64) *ay38910 {
+	return &ay38910{clock: clock, noiseLFSR: 1}
+}
+
+func (ay *ay38910) ReadPortA() byte {
+	if ay.reading {
+		return ay.regs[ay.latch]
+	}
+	return 0xff
+}
+
+func (ay *ay38910) ReadPortB() byte {
+	return 0xff
+}
+
+func (ay *ay38910) WritePortA(v byte) {
+	ay.bus = v
+}
+
+// WritePortB performs the bus function selected by the /RESET, BDIR and BC1
+// pins, which are connected to bits 2, 1 and 0 of the VIA's port B.
+func (ay *ay38910) WritePortB(v byte) {
+	ay.reading = false
+
+	switch v & 0x07 {
+	case 0, 1, 2, 3: // reset
+		for r := byte(0); r < 16; r++ {
+			ay.write(r, 0)
+		}
+	case 5: // read register
+		ay.reading = true
+	case 6: // write register
+		ay.write(ay.latch, ay.bus)
+	case 7: // latch register address
+		ay.latch = ay.bus & 0x0f
+	}
+}
+
+func (ay *ay38910) write(reg, v byte) {
+	ay.regs[reg] = v
+	ay.writes = append(ay.writes, ayWrite{cycle: ay.clock(), reg: reg, value: v})
+}
+
+// render fills out with the mixed output of the three channels for the
+// span of cycles starting at 'start'. Output values range from 0 to 1.
+func (ay *ay38910) render(out []float32, start float64) {
+	w := 0
+	t := start
+	for n := range out {
+		t += cyclesPerSample
+		for ; w < len(ay.writes) && float64(ay.writes[w].cycle) < t; w++ {
+			ay.apply(ay.writes[w].reg, ay.writes[w].value)
+		}
+
+		var sum float64
+		var count int
+		for ay.ticks += cyclesPerSample / ayTickCycles; ay.ticks >= 1; ay.ticks-- {
+			ay.tick()
+			sum += ay.output()
+			count++
+		}
+		if count > 0 {
+			out[n] = float32(sum / float64(count*3))
+		} else {
+			out[n] = float32(ay.output() / 3)
+		}
+	}
+
+	ay.writes = ay.writes[:copy(ay.writes, ay.writes[w:])]
+}
+
+// apply updates a register used by the sound generator.
+func (ay *ay38910) apply(reg, v byte) {
+	ay.sregs[reg] = v
+	if reg == ayRegEnvShape {
+		ay.envStep = 0
+		ay.envCount = 0
+		ay.envHolding = false
+		ay.envAttack = (v & 0x04) != 0
+	}
+}
+
+// tick advances the tone, noise and envelope generators by one internal
+// clock period.
+func (ay *ay38910) tick() {
+	for ch := 0; ch < 3; ch++ {
+		period := int(ay.sregs[ch*2]) | int(ay.sregs[ch*2+1]&0x0f)<<8
+		ay.toneCount[ch]++
+		if ay.toneCount[ch] >= period {
+			ay.toneCount[ch] = 0
+			ay.toneOut[ch] = !ay.toneOut[ch]
+		}
+	}
+
+	ay.noiseCount++
+	if ay.noiseCount >= 2*int(ay.sregs[6]&0x1f) {
+		ay.noiseCount = 0
+		bit := (ay.noiseLFSR ^ (ay.noiseLFSR >> 3)) & 1
+		ay.noiseLFSR = ay.noiseLFSR>>1 | bit<<16
+		ay.noiseOut = (ay.noiseLFSR & 1) != 0
+	}
+
+	ay.envCount++
+	period := int(ay.sregs[ayRegEnvFine]) | int(ay.sregs[ayRegEnvCoarse])<<8
+	if ay.envCount >= 2*period {
+		ay.envCount = 0
+		ay.stepEnvelope()
+	}
+}
+
+// stepEnvelope advances the envelope generator according to the shape
+// register's CONTINUE, ATTACK, ALTERNATE and HOLD bits.
+func (ay *ay38910) stepEnvelope() {
+	if ay.envHolding {
+		return
+	}
+
+	ay.envStep++
+	if ay.envStep < 16 {
+		return
+	}
+
+	shape := ay.sregs[ayRegEnvShape]
+	switch {
+	case (shape & 0x08) == 0: // !CONTINUE
+		ay.envHolding = true
+		ay.envStep = 15
+		ay.envAttack = false
+	case (shape & 0x01) != 0: // HOLD
+		ay.envHolding = true
+		ay.envStep = 15
+		if (shape & 0x02) != 0 {
+			ay.envAttack = !ay.envAttack
+		}
+	default:
+		ay.envStep = 0
+		if (shape & 0x02) != 0 {
+			ay.envAttack = !ay.envAttack
+		}
+	}
+}
+
+func (ay *ay38910) envLevel() int {
+	if ay.envAttack {
+		return ay.envStep
+	}
+	return 15 - ay.envStep
+}
+
+// output returns the sum of the three channel outputs.
+func (ay *ay38910) output() float64 {
+	mixer := ay.sregs[7]
+
+	var sum float64
+	for ch := uint(0); ch < 3; ch++ {
+		tone := ay.toneOut[ch] || (mixer&(1<<ch)) != 0
+		noise := ay.noiseOut || (mixer&(8<<ch)) != 0
+		if !tone || !noise {
+			continue
+		}
+
+		amp := ay.sregs[8+ch]
+		if (amp & 0x10) != 0 {
+			sum += ayVolumes[ay.envLevel()]
+		} else {
+			sum += ayVolumes[amp&0x0f]
+		}
+	}
+	return sum
+}
+
+// saveState saves the registers of the chip. Sound generation state is
+// not saved; it resumes from the register values when a snapshot is
+// loaded.
+func (ay *ay38910) saveState(sw *stateWriter) {
+	sw.Tag("AY  ")
+	sw.FixedBytes(ay.regs[:])
+	sw.Byte(ay.latch)
+	sw.Byte(ay.bus)
+	sw.Bool(ay.reading)
+}
+
+func (ay *ay38910) loadState(sr *stateReader) {
+	sr.Tag("AY  ")
+	sr.FixedBytes(ay.regs[:])
+	ay.latch = sr.Byte()
+	ay.bus = sr.Byte()
+	ay.reading = sr.Bool()
+	ay.sregs = ay.regs
+	ay.writes = ay.writes[:0]
+}
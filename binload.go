@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errBinaryRange = errors.New("binary does not fit in memory below $C000")
+
+// LoadBinary copies data into memory starting at addr, through the
+// current memory configuration. Data may not extend into the I/O space at
+// $C000.
+func (a *apple2) LoadBinary(addr uint16, data []byte) error {
+	if int(addr)+len(data) > 0xc000 {
+		return errBinaryRange
+	}
+	a.mmu.StoreBytes(addr, data)
+	return nil
+}
+
+// LoadBinaryFile copies the contents of a file into memory starting at
+// addr. If run is true, the CPU continues execution at addr.
+func (a *apple2) LoadBinaryFile(filename string, addr uint16, run bool) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := a.LoadBinary(addr, data); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if run {
+		a.cpu.SetPC(addr)
+	}
+	return nil
+}
+
+// A binaryLoad describes a file to be loaded into memory at startup.
+type binaryLoad struct {
+	filename string
+	addr     uint16
+	run      bool // true = start execution at addr
+}
+
+// parseBinaryLoad parses a load specification of the form file,addr or
+// file,addr,run, where addr is hexadecimal.
+func parseBinaryLoad(s string) (binaryLoad, error) {
+	f := strings.Split(s, ",")
+	if len(f) < 2 || len(f) > 3 || f[0] == "" || (len(f) == 3 && f[2] != "run") {
+		return binaryLoad{}, fmt.Errorf("invalid load %q; expected file,addr[,run]", s)
+	}
+	addr, err := parseDebugValue(strings.TrimPrefix(f[1], "0x"), 0xffff)
+	if err != nil {
+		return binaryLoad{}, err
+	}
+	return binaryLoad{filename: f[0], addr: uint16(addr), run: len(f) == 3}, nil
+}
+
+// binaryLoads is a flag value holding the binaries given by repeated
+// -load flags.
+type binaryLoads []binaryLoad
+
+func (l *binaryLoads) String() string {
+	s := make([]string, len(*l))
+	for i, b := range *l {
+		s[i] = fmt.Sprintf("%s,%04X", b.filename, b.addr)
+		if b.run {
+			s[i] += ",run"
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func (l *binaryLoads) Set(s string) error {
+	b, err := parseBinaryLoad(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, b)
+	return nil
+}
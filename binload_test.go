@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBinary(t *testing.T) {
+	a := newApple2()
+	file := filepath.Join(t.TempDir(), "prog.bin")
+	if err := os.WriteFile(file, []byte{0xa9, 0x01, 0x60}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var loads binaryLoads
+	if err := loads.Set(file + ",$6000,run"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := loads.Set(file + ",0x0300"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	for _, s := range []string{file, file + ",zz", file + ",300,go"} {
+		if err := loads.Set(s); err == nil {
+			t.Errorf("%s: expected an error\n", s)
+		}
+	}
+	if len(loads) != 2 || loads[0].addr != 0x6000 || !loads[0].run || loads[1].addr != 0x0300 || loads[1].run {
+		t.Fatalf("Unexpected loads %v\n", loads.String())
+	}
+
+	for _, l := range loads {
+		if err := a.LoadBinaryFile(l.filename, l.addr, l.run); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+	if a.cpu.Reg.PC != 0x6000 {
+		t.Errorf("Expected PC 6000, got %04X\n", a.cpu.Reg.PC)
+	}
+	for _, addr := range []uint16{0x6000, 0x0300} {
+		if v := a.mmu.LoadByte(addr + 1); v != 0x01 {
+			t.Errorf("Expected binary at %04X\n", addr)
+		}
+	}
+
+	if err := a.LoadBinary(0xbfff, []byte{1, 2}); err == nil {
+		t.Errorf("Expected an error loading into the I/O space\n")
+	}
+}
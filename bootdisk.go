@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the system disks in a ROM set from which boot disks are
+// synthesized. A ProDOS system disk must hold PRODOS and BASIC.SYSTEM; a
+// DOS 3.3 system disk must have been initialized with a greeting program
+// named HELLO, as the DOS 3.3 System Master is.
+var (
+	prodosSystemDisks = []string{"prodos.po", "prodos.dsk", "prodos.do"}
+	dos33SystemDisks  = []string{"dos33.dsk", "dos33.do", "dos33.po"}
+)
+
+const (
+	defaultRunAddress = 0x2000  // load address of binaries booted with run
+	dos33Greeting     = "HELLO" // program run when DOS 3.3 boots
+	prodosStartup     = "STARTUP"
+)
+
+var errNoSystemDisk = fmt.Errorf("the ROM set has no system disk to boot from; add a ProDOS (%s) or DOS 3.3 (%s) disk image",
+	strings.Join(prodosSystemDisks, ", "), strings.Join(dos33SystemDisks, ", "))
+
+// File returns the contents of a file in the set, matching its name
+// without regard to case.
+func (rs *romSet) File(name string) ([]byte, bool) {
+	for _, img := range rs.images {
+		if strings.EqualFold(img.name, name) {
+			return img.data, true
+		}
+	}
+	return nil, false
+}
+
+// systemDisk finds a system disk in the ROM set and returns its sectors in
+// the given order.
+func (rs *romSet) systemDisk(names []string, order *[diskSectors]int) ([]byte, bool) {
+	for _, name := range names {
+		data, ok := rs.File(name)
+		if !ok {
+			continue
+		}
+		format, _ := diskFormatFromName(name)
+		disk, err := loadDiskImage(bytes.NewReader(data), format)
+		if err != nil {
+			continue
+		}
+		if sectors, err := disk.ReadSectors(order); err == nil {
+			return sectors, true
+		}
+	}
+	return nil, false
+}
+
+// A bootFile is a program to be booted from a synthesized disk.
+type bootFile struct {
+	name string // base name of the host file, without its extension
+	kind string // "bas" (Applesoft listing), "sys" (ProDOS system program) or "bin"
+	data []byte // tokenized program or binary
+	addr uint16 // load address of a binary
+}
+
+// loadBootFile reads a host file to be booted. Files with a .bas
+// extension are Applesoft listings, files with a .sys or .system
+// extension are ProDOS system programs, and all others are binaries
+// loaded at addr.
+func loadBootFile(filename string, addr uint16) (*bootFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	f := &bootFile{
+		name: strings.ToUpper(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))),
+		kind: "bin",
+		data: data,
+		addr: addr,
+	}
+	switch ext {
+	case ".bas":
+		f.kind = "bas"
+		f.data, err = tokenizeApplesoft(bytes.NewReader(data), applesoftStart)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	case ".sys", ".system":
+		f.kind, f.addr = "sys", 0x2000
+	}
+	return f, nil
+}
+
+// parseRunFile parses a program to boot of the form file or file,addr,
+// where addr is the hexadecimal load address of a binary.
+func parseRunFile(s string) (filename string, addr uint16, err error) {
+	f := strings.Split(s, ",")
+	if len(f) > 2 || f[0] == "" {
+		return "", 0, fmt.Errorf("invalid program %q; expected file[,addr]", s)
+	}
+	addr = defaultRunAddress
+	if len(f) == 2 {
+		v, err := parseDebugValue(strings.TrimPrefix(f[1], "0x"), 0xffff)
+		if err != nil {
+			return "", 0, err
+		}
+		addr = uint16(v)
+	}
+	return f[0], addr, nil
+}
+
+// brunProgram returns a tokenized Applesoft program that runs a binary
+// through the operating system's BRUN command.
+func brunProgram(name string) []byte {
+	prog, _ := tokenizeApplesoft(strings.NewReader(`10 PRINT CHR$(4)"BRUN `+name+`"`), applesoftStart)
+	return prog
+}
+
+// synthesizeBootDisk builds a 5.25" disk that boots into a program,
+// using the operating system of a system disk in the ROM set. ProDOS is
+// used if the set has a ProDOS system disk, and otherwise DOS 3.3.
+func synthesizeBootDisk(rs *romSet, f *bootFile) (*diskImage, error) {
+	var data []byte
+	var order *[diskSectors]int
+	if sys, ok := rs.systemDisk(prodosSystemDisks, &prodosSectorOrder); ok {
+		blocks, err := prodosBootVolume(sys, f)
+		if err != nil {
+			return nil, err
+		}
+		data, order = blocks, &prodosSectorOrder
+	} else if f.kind == "sys" {
+		return nil, errors.New("ProDOS system programs need a ProDOS system disk")
+	} else if sys, ok := rs.systemDisk(dos33SystemDisks, &dosSectorOrder); ok {
+		sectors, err := dos33BootDisk(sys, f)
+		if err != nil {
+			return nil, err
+		}
+		data, order = sectors, &dosSectorOrder
+	} else {
+		return nil, errNoSystemDisk
+	}
+
+	disk := &diskImage{format: diskFormatDOS, volume: defaultVolume}
+	if err := disk.WriteSectors(data, order); err != nil {
+		return nil, err
+	}
+	disk.dirty = false
+	return disk, nil
+}
+
+// prodosBootVolume returns the blocks of a ProDOS volume holding the boot
+// blocks and system files of a system disk and the program. Applesoft
+// programs are named STARTUP, which BASIC.SYSTEM runs, and binaries are
+// started by a STARTUP program. System programs are named so that ProDOS
+// runs them instead of BASIC.SYSTEM.
+func prodosBootVolume(sys []byte, f *bootFile) ([]byte, error) {
+	src, err := openProDOS(sys)
+	if err != nil {
+		return nil, fmt.Errorf("ProDOS system disk: %w", err)
+	}
+	name := prodosFileName(f.name)
+	blocks, err := formatProDOS(name, diskImageSize/prodosBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := openProDOS(blocks)
+	copy(v.block(0), src.block(0))
+	copy(v.block(1), src.block(1))
+
+	copyFile := func(path string) error {
+		data, e, err := src.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("ProDOS system disk: %s: %w", path, err)
+		}
+		return v.WriteFile(path, e.fileType, e.auxType, data)
+	}
+	if err := copyFile("PRODOS"); err != nil {
+		return nil, err
+	}
+
+	switch f.kind {
+	case "sys":
+		if len(name) > prodosNameLength-len(".SYSTEM") {
+			name = name[:prodosNameLength-len(".SYSTEM")]
+		}
+		err = v.WriteFile(name+".SYSTEM", prodosTypeSYS, f.addr, f.data)
+	case "bas":
+		if err = copyFile("BASIC.SYSTEM"); err == nil {
+			err = v.WriteFile(prodosStartup, prodosTypeBAS, applesoftStart, f.data)
+		}
+	default:
+		if err = copyFile("BASIC.SYSTEM"); err == nil {
+			err = v.WriteFile(name, prodosTypeBIN, f.addr, f.data)
+		}
+		if err == nil {
+			err = v.WriteFile(prodosStartup, prodosTypeBAS, applesoftStart, brunProgram(name))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// dos33BootDisk returns the sectors of a DOS 3.3 disk holding the DOS
+// image of a system disk and the program. Applesoft programs become the
+// greeting program, and binaries are started by one.
+func dos33BootDisk(sys []byte, f *bootFile) ([]byte, error) {
+	src, err := openDOS33(sys)
+	if err != nil {
+		return nil, fmt.Errorf("DOS 3.3 system disk: %w", err)
+	}
+	sectors := formatDOS33(src.Volume())
+	copy(sectors, sys[:3*diskTrackSize])
+	v, _ := openDOS33(sectors)
+
+	if f.kind == "bas" {
+		err = v.WriteFile(dos33Greeting, dos33Applesoft, dos33File(dos33Applesoft, 0, f.data))
+	} else {
+		name := dos33FileName(f.name)
+		err = v.WriteFile(name, dos33Binary, dos33File(dos33Binary, f.addr, f.data))
+		if err == nil {
+			prog := brunProgram(name)
+			err = v.WriteFile(dos33Greeting, dos33Applesoft, dos33File(dos33Applesoft, 0, prog))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sectors, nil
+}
+
+// prodosFileName converts a host file name into a valid ProDOS name.
+func prodosFileName(s string) string {
+	b := []byte(strings.ToUpper(s))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '.'
+		}
+	}
+	if len(b) == 0 || b[0] < 'A' || b[0] > 'Z' {
+		b = append([]byte{'A'}, b...)
+	}
+	if len(b) > prodosNameLength {
+		b = b[:prodosNameLength]
+	}
+	return string(b)
+}
+
+// dos33FileName converts a host file name into a valid DOS 3.3 name.
+func dos33FileName(s string) string {
+	s = strings.Replace(strings.ToUpper(s), ",", ".", -1)
+	if s == "" || s == dos33Greeting {
+		s = "PROGRAM"
+	}
+	if len(s) > dos33NameLength {
+		s = s[:dos33NameLength]
+	}
+	return s
+}
+
+// BootFile synthesizes a disk that boots into a program, inserts it into
+// drive 1 and restarts the machine through its reset vector, so that the
+// disk boots. Binaries are loaded at addr. The synthesized disk is never
+// saved.
+func (a *apple2) BootFile(rs *romSet, filename string, addr uint16) error {
+	f, err := loadBootFile(filename, addr)
+	if err != nil {
+		return err
+	}
+	disk, err := synthesizeBootDisk(rs, f)
+	if err != nil {
+		return err
+	}
+	d, err := a.diskController()
+	if err != nil {
+		return err
+	}
+	if err := d.insert(0, disk, "", diskModeWrite); err != nil {
+		return err
+	}
+	a.cpu.SetPC(a.mmu.LoadAddress(0xfffc))
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSystemDisks returns ROM sets holding a ProDOS and a DOS 3.3 system
+// disk, with marked boot sectors.
+func testSystemDisks(t *testing.T) (prodos, dos *romSet) {
+	blocks, err := formatProDOS("SYSTEM", diskImageSize/prodosBlockSize)
+	if err != nil {
+		t.Fatalf("Unable to format: %v\n", err)
+	}
+	v, _ := openProDOS(blocks)
+	v.block(0)[0], v.block(1)[0] = 0x01, 0x02
+	if err := v.WriteFile("PRODOS", prodosTypeSYS, 0x2000, []byte("kernel")); err != nil {
+		t.Fatalf("Unable to write PRODOS: %v\n", err)
+	}
+	if err := v.WriteFile("BASIC.SYSTEM", prodosTypeSYS, 0x2000, []byte("basic")); err != nil {
+		t.Fatalf("Unable to write BASIC.SYSTEM: %v\n", err)
+	}
+
+	sectors := formatDOS33(100)
+	sectors[0], sectors[2*diskTrackSize] = 0x01, 0x03
+
+	prodos = &romSet{images: []*romImage{{name: "ProDOS.po", data: blocks}}}
+	dos = &romSet{images: []*romImage{{name: "dos33.dsk", data: sectors}}}
+	return prodos, dos
+}
+
+func TestBootDiskProDOS(t *testing.T) {
+	rs, _ := testSystemDisks(t)
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "my game.bin")
+	os.WriteFile(bin, []byte{0xa9, 0x01, 0x60}, 0644)
+
+	a := newApple2()
+	a.sm.Insert(6, newDiskII(a, nil))
+	if err := a.BootFile(rs, bin, 0x6000); err != nil {
+		t.Fatalf("Unable to boot: %v\n", err)
+	}
+	disk, err := a.MountedDisk(1)
+	if err != nil {
+		t.Fatalf("No disk mounted: %v\n", err)
+	}
+	if disk.dirty {
+		t.Errorf("Synthesized disk is marked dirty\n")
+	}
+	data, _ := disk.ReadSectors(&prodosSectorOrder)
+	v, err := openProDOS(data)
+	if err != nil {
+		t.Fatalf("Synthesized disk isn't a ProDOS volume: %v\n", err)
+	}
+	if v.block(0)[0] != 0x01 || v.block(1)[0] != 0x02 {
+		t.Errorf("Boot blocks not copied\n")
+	}
+	if v.Name() != "MY.GAME" {
+		t.Errorf("Volume name %s, expected MY.GAME\n", v.Name())
+	}
+
+	files := []struct {
+		name string
+		typ  byte
+		aux  uint16
+		data []byte
+	}{
+		{"PRODOS", prodosTypeSYS, 0x2000, []byte("kernel")},
+		{"BASIC.SYSTEM", prodosTypeSYS, 0x2000, []byte("basic")},
+		{"MY.GAME", prodosTypeBIN, 0x6000, []byte{0xa9, 0x01, 0x60}},
+		{"STARTUP", prodosTypeBAS, applesoftStart, brunProgram("MY.GAME")},
+	}
+	for _, f := range files {
+		data, e, err := v.ReadFile(f.name)
+		if err != nil {
+			t.Errorf("%s: %v\n", f.name, err)
+			continue
+		}
+		if e.fileType != f.typ || e.auxType != f.aux || !bytes.Equal(data, f.data) {
+			t.Errorf("%s: type $%02X aux $%04X data % X\n", f.name, e.fileType, e.auxType, data)
+		}
+	}
+
+	// System programs replace BASIC.SYSTEM.
+	sys := filepath.Join(dir, "tool.system")
+	os.WriteFile(sys, []byte("tool"), 0644)
+	f, err := loadBootFile(sys, defaultRunAddress)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v\n", sys, err)
+	}
+	disk, err = synthesizeBootDisk(rs, f)
+	if err != nil {
+		t.Fatalf("Unable to synthesize: %v\n", err)
+	}
+	data, _ = disk.ReadSectors(&prodosSectorOrder)
+	v, _ = openProDOS(data)
+	entries, _ := v.ReadDir("")
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	if len(names) != 2 || names[0] != "PRODOS" || names[1] != "TOOL.SYSTEM" {
+		t.Errorf("Unexpected files %v\n", names)
+	}
+}
+
+func TestBootDiskDOS33(t *testing.T) {
+	_, rs := testSystemDisks(t)
+	dir := t.TempDir()
+	bas := filepath.Join(dir, "hello.bas")
+	os.WriteFile(bas, []byte("10 PRINT \"HI\"\n"), 0644)
+	bin := filepath.Join(dir, "hello.bin")
+	os.WriteFile(bin, []byte{0x60}, 0644)
+
+	f, err := loadBootFile(bas, defaultRunAddress)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v\n", bas, err)
+	}
+	disk, err := synthesizeBootDisk(rs, f)
+	if err != nil {
+		t.Fatalf("Unable to synthesize: %v\n", err)
+	}
+	data, _ := disk.ReadSectors(&dosSectorOrder)
+	v, err := openDOS33(data)
+	if err != nil {
+		t.Fatalf("Synthesized disk isn't a DOS 3.3 disk: %v\n", err)
+	}
+	if v.Volume() != 100 || data[0] != 0x01 || data[2*diskTrackSize] != 0x03 {
+		t.Errorf("DOS image not copied\n")
+	}
+	prog, e, err := v.ReadFile("HELLO")
+	if err != nil || e.fileType != dos33Applesoft {
+		t.Fatalf("No greeting program: %v\n", err)
+	}
+	if _, p := dos33Payload(e.fileType, prog); !bytes.Equal(p, f.data) {
+		t.Errorf("Greeting program % X, expected % X\n", p, f.data)
+	}
+
+	// A binary named HELLO mustn't replace the greeting program.
+	f, _ = loadBootFile(bin, 0x0300)
+	disk, err = synthesizeBootDisk(rs, f)
+	if err != nil {
+		t.Fatalf("Unable to synthesize: %v\n", err)
+	}
+	data, _ = disk.ReadSectors(&dosSectorOrder)
+	v, _ = openDOS33(data)
+	prog, e, err = v.ReadFile("PROGRAM")
+	if err != nil || e.fileType != dos33Binary {
+		t.Fatalf("No binary: %v\n", err)
+	}
+	if addr, p := dos33Payload(e.fileType, prog); addr != 0x0300 || !bytes.Equal(p, []byte{0x60}) {
+		t.Errorf("Binary at $%04X: % X\n", addr, p)
+	}
+	prog, e, _ = v.ReadFile("HELLO")
+	if _, p := dos33Payload(e.fileType, prog); !bytes.Equal(p, brunProgram("PROGRAM")) {
+		t.Errorf("Greeting program doesn't run the binary\n")
+	}
+
+	// ProDOS system programs can't be booted under DOS 3.3.
+	sys := filepath.Join(dir, "tool.sys")
+	os.WriteFile(sys, []byte("tool"), 0644)
+	f, _ = loadBootFile(sys, defaultRunAddress)
+	if _, err := synthesizeBootDisk(rs, f); err == nil {
+		t.Errorf("Expected an error booting a system program under DOS 3.3\n")
+	}
+	if _, err := synthesizeBootDisk(&romSet{}, f); err == nil {
+		t.Errorf("Expected an error without a system disk\n")
+	}
+}
+
+func TestParseRunFile(t *testing.T) {
+	cases := []struct {
+		s    string
+		file string
+		addr uint16
+		ok   bool
+	}{
+		{"game.bin", "game.bin", defaultRunAddress, true},
+		{"game.bin,0x0800", "game.bin", 0x0800, true},
+		{"game.bin,$4000", "game.bin", 0x4000, true},
+		{",2000", "", 0, false},
+		{"game.bin,zz", "", 0, false},
+		{"game.bin,1,2", "", 0, false},
+	}
+	for _, c := range cases {
+		file, addr, err := parseRunFile(c.s)
+		if (err == nil) != c.ok || file != c.file || addr != c.addr {
+			t.Errorf("parseRunFile(%q) = %q, $%04X, %v\n", c.s, file, addr, err)
+		}
+	}
+}
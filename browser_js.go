@@ -0,0 +1,191 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"syscall/js"
+	"time"
+)
+
+// The browser front end runs the machine compiled to WebAssembly, in the
+// page in the web directory, which draws the display on a canvas and
+// plays the audio with WebAudio. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o web/apple2go.wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" web/
+//
+// and serve the web directory, along with a zip file of ROM images named
+// roms.zip, from any static web server. (Before Go 1.24, wasm_exec.js is
+// in misc/wasm.)
+//
+// The page calls the functions of the apple2go object that main installs.
+// Functions that can fail return an error message, or null.
+
+var errNotStarted = errors.New("the machine hasn't been started")
+
+// browserMaxFrames is the most frames run for one animation frame of the
+// page, as the machine catches up after the page was slow to draw.
+const browserMaxFrames = 4
+
+// A browser runs a machine for the page.
+type browser struct {
+	apple2  *apple2
+	next    time.Time // host time at which the next frame is due
+	samples []int16
+	buf     []byte
+}
+
+func main() {
+	b := &browser{}
+	js.Global().Set("apple2go", js.ValueOf(map[string]any{
+		"width":      displayWidth,
+		"height":     displayHeight * 2,
+		"start":      js.FuncOf(b.start),
+		"frame":      js.FuncOf(b.frame),
+		"screen":     js.FuncOf(b.screen),
+		"audio":      js.FuncOf(b.audio),
+		"key":        js.FuncOf(b.key),
+		"insertDisk": js.FuncOf(b.insertDisk),
+		"reset":      js.FuncOf(b.reset),
+	}))
+	select {}
+}
+
+// jsBytes copies the contents of a Uint8Array.
+func jsBytes(v js.Value) []byte {
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b
+}
+
+// jsError returns an error's message for the page, or null.
+func jsError(err error) any {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
+
+// start(roms, model) builds a machine of a model, or the enhanced IIe if
+// model is "", from a Uint8Array holding a zip file of ROM images.
+func (b *browser) start(this js.Value, args []js.Value) any {
+	data := jsBytes(args[0])
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return jsError(err)
+	}
+	roms, err := readROMSet("roms.zip", zr, true)
+	if err != nil {
+		return jsError(err)
+	}
+
+	m := modelIIeEnhanced
+	if len(args) > 1 && args[1].String() != "" {
+		var ok bool
+		if m, ok = parseMachineModel(args[1].String()); !ok {
+			return "unknown machine model '" + args[1].String() + "'"
+		}
+	}
+	a := newApple2Model(m)
+	if err := roms.Validate(m, true); err != nil {
+		return jsError(err)
+	}
+	if err := a.LoadROMSet(roms); err != nil {
+		return jsError(err)
+	}
+	if a.cfg.slots {
+		rom, err := roms.DiskIIROM()
+		if err != nil {
+			return jsError(err)
+		}
+		a.sm.Insert(6, newDiskII(a, rom))
+	}
+	a.FillRAM()
+	b.apple2 = a
+	return nil
+}
+
+// frame() runs the frames that have come due since it was last called,
+// which the page does before drawing each of its animation frames.
+func (b *browser) frame(this js.Value, args []js.Value) any {
+	if b.apple2 == nil {
+		return nil
+	}
+	now := time.Now()
+	if b.next.IsZero() || now.Sub(b.next) > maxPacingLag {
+		b.next = now
+	}
+	for n := 0; !now.Before(b.next) && n < browserMaxFrames; n++ {
+		b.apple2.RunFrame()
+		b.next = b.next.Add(framePeriod)
+	}
+	return nil
+}
+
+// screen(pixels) copies the display, as the RGBA pixels of an image
+// width by height, into a Uint8Array.
+func (b *browser) screen(this js.Value, args []js.Value) any {
+	if b.apple2 != nil {
+		js.CopyBytesToJS(args[0], b.apple2.ds.Frame().Pix)
+	}
+	return nil
+}
+
+// audio(samples) fills a Uint8Array with 16-bit little-endian audio
+// samples at 44100 Hz.
+func (b *browser) audio(this js.Value, args []js.Value) any {
+	n := args[0].Get("length").Int() / 2
+	if len(b.samples) != n {
+		b.samples, b.buf = make([]int16, n), make([]byte, 2*n)
+	}
+	if b.apple2 != nil {
+		b.apple2.au.ReadSamples(b.samples)
+	}
+	for i, s := range b.samples {
+		binary.LittleEndian.PutUint16(b.buf[2*i:], uint16(s))
+	}
+	js.CopyBytesToJS(args[0], b.buf)
+	return nil
+}
+
+// key(code, down) presses or releases the key with a KeyboardEvent code.
+// It returns true if the key is one the machine uses, so that the page
+// can keep the browser from acting on it too.
+func (b *browser) key(this js.Value, args []js.Value) any {
+	k, ok := browserKeys[args[0].String()]
+	if ok && b.apple2 != nil {
+		b.apple2.im.KeyEvent(k, args[1].Bool())
+	}
+	return ok
+}
+
+// insertDisk(drive, name, data) inserts a disk image held in a Uint8Array
+// into drive 1 or 2. The name's extension gives the image's format.
+func (b *browser) insertDisk(this js.Value, args []js.Value) any {
+	if b.apple2 == nil {
+		return jsError(errNotStarted)
+	}
+	return jsError(b.apple2.InsertDiskData(args[0].Int(), args[1].String(), jsBytes(args[2])))
+}
+
+// reset(kind) resets the machine, or with kind "cold" forces a cold
+// start, or with "power" power-cycles it.
+func (b *browser) reset(this js.Value, args []js.Value) any {
+	a := b.apple2
+	if a == nil {
+		return jsError(errNotStarted)
+	}
+	switch args[0].String() {
+	case "cold":
+		a.ColdReset()
+	case "power":
+		a.PowerCycle()
+	default:
+		a.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errMachineStopped = errors.New("the machine has stopped")
+
+// The machine's state belongs to the goroutine that runs it, and isn't
+// locked. Only the inputs that front ends feed the machine while it runs
+// are safe to use from any goroutine: the keyboard's PushKeyEvent and
+// PasteText, the game I/O's SetPaddle, SetAxis and SetButton, the mouse's
+// Move and SetButton, and the speed control's settings. Other goroutines,
+// such as those serving a UI, an HTTP API or a debugger, access the
+// machine by passing a function to Do, which runs it on the machine's
+// goroutine between frames.
+//
+// A callQueue holds the functions passed to Do that are waiting to run.
+type callQueue struct {
+	calls  chan func()
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newCallQueue() *callQueue {
+	return &callQueue{
+		calls:  make(chan func()),
+		closed: make(chan struct{}),
+	}
+}
+
+// Do runs fn on the goroutine that runs the machine, at the start of its
+// next call to RunFrame or RunPaced, and waits for it to return. It
+// returns the context's error if the context is done before fn starts
+// running, and errMachineStopped if CloseCalls has been called. Do must
+// not be called from the machine's goroutine.
+func (a *apple2) Do(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	call := func() {
+		defer close(done)
+		fn()
+	}
+	select {
+	case a.calls.calls <- call:
+		<-done
+		return nil
+	case <-a.calls.closed:
+		return errMachineStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseCalls makes the calls to Do that are waiting, and any made later,
+// fail. The goroutine that runs the machine calls it when it stops
+// running it for good.
+func (a *apple2) CloseCalls() {
+	a.calls.once.Do(func() { close(a.calls.closed) })
+}
+
+// runCalls runs the functions passed to Do that are waiting.
+func (a *apple2) runCalls() {
+	for {
+		select {
+		case fn := <-a.calls.calls:
+			fn()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMachineDo(t *testing.T) {
+	a := newApple2()
+	a.speed.SetSpeed(speedWarp)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				a.CloseCalls()
+				return
+			default:
+				a.RunPaced()
+			}
+		}
+	}()
+
+	// Functions run on the machine's goroutine, between frames, so they
+	// can use any of its state.
+	for i := 0; i < 10; i++ {
+		var before, after uint64
+		err := a.Do(context.Background(), func() {
+			before = a.cpu.Cycles
+			a.mmu.StoreByte(0x300, byte(i))
+			after = a.cpu.Cycles
+		})
+		if err != nil || before != after {
+			t.Fatalf("Expected the call to run between frames, got %v, cycles %d and %d\n", err, before, after)
+		}
+	}
+	var b byte
+	a.Do(context.Background(), func() { b = a.mmu.LoadByte(0x300) })
+	if b != 9 {
+		t.Errorf("Expected $09 at $0300, got $%02X\n", b)
+	}
+
+	close(stop)
+	<-stopped
+	if err := a.Do(context.Background(), func() {}); err != errMachineStopped {
+		t.Errorf("Expected a call to a stopped machine to fail, got %v\n", err)
+	}
+
+	// A call that isn't run fails when its context is done.
+	b2 := newApple2()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b2.Do(ctx, func() {}); err != context.DeadlineExceeded {
+		t.Errorf("Expected the call to time out, got %v\n", err)
+	}
+}
+
+// TestMachineDoRunFrame checks that calls are served by loops that run the
+// machine with RunFrame, such as scripts and the GDB server, and not only
+// by RunPaced.
+func TestMachineDoRunFrame(t *testing.T) {
+	a := newApple2()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				a.CloseCalls()
+				return
+			default:
+				a.RunFrame()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var b byte
+	err := a.Do(ctx, func() {
+		a.mmu.StoreByte(0x300, 0x42)
+		b = a.mmu.LoadByte(0x300)
+	})
+	if err != nil || b != 0x42 {
+		t.Errorf("Expected the call to run, got %v and $%02X\n", err, b)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"io"
+	"math"
+)
+
+// Apple2 cassette tape encoding. Each value is the duration in
+// microseconds of one half-cycle of the recorded square wave.
+const (
+	tapeHeaderHalf = 650  // 770Hz header tone
+	tapeSync1Half  = 200  // first half of the sync bit
+	tapeSync2Half  = 250  // second half of the sync bit
+	tapeZeroHalf   = 250  // 2000Hz cycle encodes a 0 bit
+	tapeOneHalf    = 500  // 1000Hz cycle encodes a 1 bit
+	tapeHeaderSecs = 10.0 // length of the header tone written by the monitor
+)
+
+// The cassette interface consists of an output toggled by accessing $C020
+// and a comparator input read through bit 7 of $C060. A tape recording may
+// be played into the input, and the output may be captured to a WAV file.
+type cassette struct {
+	apple2 *apple2
+
+	// Input (tape playback)
+	tape    []float32 // tape samples with DC offset removed
+	rate    float64   // tape sample rate in Hz
+	start   uint64    // CPU cycle at which playback started
+	playing bool
+
+	// Output (tape recording)
+	level   bool       // current output level
+	toggles []uint64   // CPU cycles of output toggles not yet recorded
+	wav     *wavWriter // active output recording, if any
+	cycle   float64    // CPU cycle at which the next recorded sample begins
+}
+
+func newCassette(apple2 *apple2) *cassette {
+	return &cassette{
+		apple2: apple2,
+	}
+}
+
+func (c *cassette) Init() {
+}
+
+// LoadTape reads a WAV recording of a cassette tape and prepares it for
+// playback. Playback begins when Play is called.
+func (c *cassette) LoadTape(r io.Reader) error {
+	samples, rate, err := readWAV(r)
+	if err != nil {
+		return err
+	}
+
+	var mean float64
+	for _, s := range samples {
+		mean += float64(s)
+	}
+	if len(samples) > 0 {
+		mean /= float64(len(samples))
+	}
+	for i := range samples {
+		samples[i] -= float32(mean)
+	}
+
+	c.tape = samples
+	c.rate = float64(rate)
+	c.playing = false
+	return nil
+}
+
+// Play starts playing the loaded tape into the cassette input.
+func (c *cassette) Play() {
+	c.start = c.apple2.cpu.Cycles
+	c.playing = c.tape != nil
+}
+
+// Stop stops tape playback.
+func (c *cassette) Stop() {
+	c.playing = false
+}
+
+// InputBit returns 0x80 if the cassette input comparator is high.
+func (c *cassette) InputBit() byte {
+	if !c.playing {
+		return 0
+	}
+
+	i := int(float64(c.apple2.cpu.Cycles-c.start) * c.rate / cpuClockRate)
+	if i >= len(c.tape) {
+		c.playing = false
+		return 0
+	}
+	if c.tape[i] >= 0 {
+		return 0x80
+	}
+	return 0
+}
+
+// ToggleOutput flips the level of the cassette output.
+func (c *cassette) ToggleOutput() {
+	if c.wav != nil {
+		c.toggles = append(c.toggles, c.apple2.cpu.Cycles)
+	}
+}
+
+// StartRecording begins capturing the cassette output to w in WAV format.
+func (c *cassette) StartRecording(w io.WriteSeeker) error {
+	if c.wav != nil {
+		c.StopRecording()
+	}
+
+	wav, err := newWAVWriter(w, audioSampleRate)
+	if err != nil {
+		return err
+	}
+	c.wav = wav
+	c.cycle = float64(c.apple2.cpu.Cycles)
+	c.toggles = c.toggles[:0]
+	return nil
+}
+
+// StopRecording ends the cassette output recording and finalizes the WAV
+// data.
+func (c *cassette) StopRecording() error {
+	if c.wav == nil {
+		return errNotRecording
+	}
+	c.Update()
+	err := c.wav.Close()
+	c.wav = nil
+	return err
+}
+
+// Update writes the cassette output generated since the last update to
+// the active recording.
+func (c *cassette) Update() {
+	if c.wav == nil {
+		return
+	}
+
+	now := float64(c.apple2.cpu.Cycles)
+	n := int((now - c.cycle) / cyclesPerSample)
+	if n <= 0 {
+		return
+	}
+
+	out := make([]int16, n)
+	i := 0
+	for s := range out {
+		t1 := c.cycle + cyclesPerSample
+		for ; i < len(c.toggles) && float64(c.toggles[i]) < t1; i++ {
+			c.level = !c.level
+		}
+		if c.level {
+			out[s] = math.MaxInt16 / 2
+		} else {
+			out[s] = -math.MaxInt16 / 2
+		}
+		c.cycle = t1
+	}
+	c.toggles = c.toggles[:copy(c.toggles, c.toggles[i:])]
+
+	c.wav.WriteSamples(out)
+}
+
+// writeTape encodes data in the Apple2 cassette format, as written by the
+// monitor's W command, and writes it to w as a WAV file. The encoded data
+// is followed by a checksum byte.
+func writeTape(w io.WriteSeeker, data []byte) error {
+	wav, err := newWAVWriter(w, audioSampleRate)
+	if err != nil {
+		return err
+	}
+
+	var enc tapeEncoder
+	for t := 0.0; t < tapeHeaderSecs; t += 2 * tapeHeaderHalf / 1e6 {
+		enc.cycle(tapeHeaderHalf, tapeHeaderHalf)
+	}
+	enc.cycle(tapeSync1Half, tapeSync2Half)
+
+	checksum := byte(0xff)
+	for _, b := range data {
+		enc.byte(b)
+		checksum ^= b
+	}
+	enc.byte(checksum)
+
+	if err := wav.WriteSamples(enc.samples); err != nil {
+		return err
+	}
+	return wav.Close()
+}
+
+// A tapeEncoder generates square wave samples for tape data.
+type tapeEncoder struct {
+	samples []int16
+	level   bool
+	t       float64 // fractional sample time carried between half-cycles
+}
+
+func (e *tapeEncoder) byte(b byte) {
+	for bit := 7; bit >= 0; bit-- {
+		if (b & (1 << uint(bit))) != 0 {
+			e.cycle(tapeOneHalf, tapeOneHalf)
+		} else {
+			e.cycle(tapeZeroHalf, tapeZeroHalf)
+		}
+	}
+}
+
+func (e *tapeEncoder) cycle(half1, half2 float64) {
+	e.half(half1)
+	e.half(half2)
+}
+
+func (e *tapeEncoder) half(usec float64) {
+	v := int16(math.MaxInt16 / 2)
+	if !e.level {
+		v = -v
+	}
+	e.t += usec * audioSampleRate / 1e6
+	for ; e.t >= 1; e.t-- {
+		e.samples = append(e.samples, v)
+	}
+	e.level = !e.level
+}
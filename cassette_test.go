@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestTapeRoundTrip(t *testing.T) {
+	a := newApple2()
+	data := []byte{0x12, 0x34, 0xa5, 0xff, 0x00}
+
+	filename := filepath.Join(t.TempDir(), "tape.wav")
+	a.mmu.StoreBytes(0x0800, data)
+	if err := a.SaveTape(filename, 0x0800, 0x0804); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LoadTape(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// Measure the length of each half-cycle by polling $C060 the way the
+	// monitor's tape read routine does.
+	var halves []uint64
+	last := a.mmu.LoadByte(0xc060) & 0x80
+	edge := a.cpu.Cycles
+	for a.cas.playing {
+		a.cpu.Cycles += 4
+		v := a.mmu.LoadByte(0xc060) & 0x80
+		if v != last {
+			halves = append(halves, a.cpu.Cycles-edge)
+			edge, last = a.cpu.Cycles, v
+		}
+	}
+
+	// Skip the header tone and the sync bit, then decode full cycles.
+	i := 0
+	for i < len(halves) && halves[i] > 450 {
+		i++
+	}
+	i += 2
+
+	var got []byte
+	for ; i+16 <= len(halves); i += 16 {
+		var b byte
+		for bit := 0; bit < 8; bit++ {
+			b <<= 1
+			if halves[i+bit*2]+halves[i+bit*2+1] > 750 {
+				b |= 1
+			}
+		}
+		got = append(got, b)
+	}
+
+	checksum := byte(0xff)
+	for _, b := range data {
+		checksum ^= b
+	}
+	expected := append(data, checksum)
+
+	if len(got) < len(expected) {
+		t.Fatalf("Expected %d bytes, decoded %d\n", len(expected), len(got))
+	}
+	for i, b := range expected {
+		if got[i] != b {
+			t.Errorf("Byte %d: expected %02x, got %02x\n", i, b, got[i])
+		}
+	}
+}
+
+func TestReadWAVMalformed(t *testing.T) {
+	chunk := func(id string, size uint32, body ...byte) []byte {
+		b := append([]byte(id), byte(size), byte(size>>8), byte(size>>16), byte(size>>24))
+		return append(b, body...)
+	}
+	fmtPCM := func(rate uint32) []byte {
+		return chunk("fmt ", 16,
+			1, 0, 1, 0, // PCM, mono
+			byte(rate), byte(rate>>8), byte(rate>>16), byte(rate>>24),
+			0, 0, 0, 0, 1, 0, 8, 0) // byte rate, block align, 8 bits
+	}
+	wav := func(chunks ...[]byte) []byte {
+		b := []byte("RIFF\x00\x00\x00\x00WAVE")
+		for _, c := range chunks {
+			b = append(b, c...)
+		}
+		return b
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"truncated header", []byte("RIFF\x00\x00"), io.ErrUnexpectedEOF},
+		{"not wave", []byte("RIFF\x00\x00\x00\x00AVI "), errWAVFormat},
+		{"oversized chunk", wav(chunk("LIST", 0xffffffff, 1, 2, 3)), io.ErrUnexpectedEOF},
+		{"oversized fmt", wav(chunk("fmt ", 0xffffffff)), errWAVFormat},
+		{"oversized data", wav(fmtPCM(22050), chunk("data", 0xffffffff, 0x80)), io.ErrUnexpectedEOF},
+		{"truncated fmt", wav(chunk("fmt ", 16, 1, 0, 1, 0)), io.ErrUnexpectedEOF},
+		{"zero rate", wav(fmtPCM(0), chunk("data", 1, 0x80)), errWAVUnsupported},
+		{"data before fmt", wav(chunk("data", 1, 0x80)), errWAVFormat},
+	}
+
+	for _, c := range cases {
+		if _, _, err := readWAV(bytes.NewReader(c.data)); !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v\n", c.name, c.err, err)
+		}
+	}
+
+	samples, rate, err := readWAV(bytes.NewReader(wav(chunk("LIST", 3, 1, 2, 3, 0), fmtPCM(22050), chunk("data", 2, 0x80, 0xc0))))
+	if err != nil || rate != 22050 || len(samples) != 2 || samples[1] != 0.5 {
+		t.Errorf("Unexpected %d samples at %d Hz: %v\n", len(samples), rate, err)
+	}
+}
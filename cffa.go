@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CFFA I/O offsets. The card connects an ATA (IDE) device, such as a
+// CompactFlash card, to the Apple's bus. The device's task file registers
+// are at offsets 8..15; its data register is 16 bits wide, so the card
+// latches the high byte of each word at offset 0.
+const (
+	cffaDataHigh   = 0x0 // high byte of the last data word read, or of the next written
+	cffaSetCSMask  = 0x1 // ignore the device's chip select, so false reads don't reach it
+	cffaClearCS    = 0x2 // stop ignoring the chip select
+	cffaAltStatus  = 0x6 // read: alternate status; write: device control
+	cffaData       = 0x8 // low byte of the data register
+	cffaError      = 0x9 // read: error; write: features
+	cffaSectors    = 0xa // sector count
+	cffaLBA0       = 0xb // LBA bits 0..7
+	cffaLBA8       = 0xc // LBA bits 8..15
+	cffaLBA16      = 0xd // LBA bits 16..23
+	cffaDriveHead  = 0xe // LBA bits 24..27, drive select and LBA mode
+	cffaStatusCmd  = 0xf // read: status; write: command
+	cffaSectorSize = 512
+)
+
+// ATA status and error bits, and the commands the device understands.
+const (
+	ataStatusBusy  = 0x80
+	ataStatusReady = 0x40
+	ataStatusSeek  = 0x10
+	ataStatusDRQ   = 0x08 // data request: data can be transferred
+	ataStatusError = 0x01
+	ataErrorAbort  = 0x04
+	ataErrorRange  = 0x10 // sector not found
+
+	ataCmdRead        = 0x20
+	ataCmdReadNoRetry = 0x21
+	ataCmdWrite       = 0x30
+	ataCmdWriteNR     = 0x31
+	ataCmdIdentify    = 0xec
+	ataCmdSetFeatures = 0xef
+)
+
+// The CFFA firmware divides a device into partitions of 32MB, the size
+// of the largest ProDOS volume, each of which ProDOS sees as a drive.
+const (
+	cfPartitionBlocks = 0x10000
+	cfMaxPartitions   = 8
+)
+
+var errCFImageSize = errors.New("CompactFlash image size is not a multiple of 512 bytes")
+
+// A cfImage is an image of a CompactFlash card or other ATA device,
+// loaded from a file and saved back to it when flushed.
+type cfImage struct {
+	file  string
+	data  []byte
+	dirty bool
+}
+
+// loadCFImage loads a raw image of an ATA device.
+func loadCFImage(file string) (*cfImage, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%cffaSectorSize != 0 {
+		return nil, fmt.Errorf("%s: %w", file, errCFImageSize)
+	}
+	return &cfImage{file: file, data: data}, nil
+}
+
+// Sectors returns the number of 512-byte sectors on the device.
+func (img *cfImage) Sectors() int {
+	return len(img.data) / cffaSectorSize
+}
+
+// Flush saves the image if it was written.
+func (img *cfImage) Flush() error {
+	if !img.dirty {
+		return nil
+	}
+	img.dirty = false
+	return os.WriteFile(img.file, img.data, 0644)
+}
+
+// A cfPartition is one of the partitions of a CompactFlash image.
+type cfPartition struct {
+	index  int // partition number, from 0
+	start  int // first block
+	blocks int
+	volume string // name of the ProDOS volume on the partition, or ""
+}
+
+func (p cfPartition) String() string {
+	s := fmt.Sprintf("partition %d: blocks %d-%d", p.index+1, p.start, p.start+p.blocks-1)
+	if p.volume != "" {
+		s += ", ProDOS volume /" + p.volume
+	} else {
+		s += ", no ProDOS volume"
+	}
+	return s
+}
+
+// Partitions enumerates the partitions of the image as the CFFA firmware
+// sees them. The last partition may be smaller than the others.
+func (img *cfImage) Partitions() []cfPartition {
+	var parts []cfPartition
+	for i := 0; i < cfMaxPartitions; i++ {
+		start := i * cfPartitionBlocks
+		if start >= img.Sectors() {
+			break
+		}
+		blocks := img.Sectors() - start
+		if blocks > cfPartitionBlocks-1 {
+			blocks = cfPartitionBlocks - 1
+		}
+		p := cfPartition{index: i, start: start, blocks: blocks}
+		data := img.data[start*cffaSectorSize : (start+blocks)*cffaSectorSize]
+		if v, err := openProDOS(data); err == nil {
+			p.volume = v.Name()
+		}
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+// A cffaCard emulates a CFFA CompactFlash interface card at the level of
+// its ATA registers, so that the card's own firmware, loaded from the ROM
+// set, drives it. The firmware's 4K EPROM holds a 256-byte $Cn00 page for
+// each slot in its first 2K, and the $C800 expansion ROM in its second.
+type cffaCard struct {
+	apple2 *apple2
+	slot   int
+	rom    []byte
+	img    *cfImage
+
+	csMask  bool // true = the chip select is ignored
+	latch   byte // high byte of the data register
+	status  byte
+	err     byte
+	feature byte
+	sectors byte // sector count register
+	lba     [4]byte
+	control byte
+
+	buf     [cffaSectorSize]byte // sector being transferred
+	pos     int                  // next byte of buf to transfer
+	writing bool                 // true = the host is writing buf
+	left    int                  // sectors left in the command
+}
+
+func newCFFACard(apple2 *apple2, slot int, rom []byte, img *cfImage) *cffaCard {
+	c := &cffaCard{apple2: apple2, slot: slot, rom: rom, img: img}
+	c.Reset()
+	return c
+}
+
+// Reset puts the device in its idle state.
+func (c *cffaCard) Reset() {
+	c.csMask = false
+	c.status = ataStatusReady | ataStatusSeek
+	c.err = 0
+	c.left = 0
+	c.writing = false
+}
+
+// Close saves the image if it was written.
+func (c *cffaCard) Close() error {
+	return c.img.Flush()
+}
+
+func (c *cffaCard) LoadROM(addr uint16) byte {
+	return c.rom[c.slot*0x100+int(addr)]
+}
+
+func (c *cffaCard) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+func (c *cffaCard) LoadExpansionROM(addr uint16) byte {
+	return c.rom[0x800+int(addr)]
+}
+
+func (c *cffaCard) StoreExpansionROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+func (c *cffaCard) LoadIO(addr uint16) byte {
+	switch addr {
+	case cffaDataHigh:
+		return c.latch
+	case cffaSetCSMask:
+		c.csMask = true
+		return 0
+	case cffaClearCS:
+		c.csMask = false
+		return 0
+	case cffaAltStatus:
+		return c.status
+	}
+	if addr < cffaData || c.csMask {
+		return c.apple2.vs.FloatingBus()
+	}
+
+	switch addr {
+	case cffaData:
+		if c.status&ataStatusDRQ == 0 || c.writing {
+			return 0xff
+		}
+		lo, hi := c.buf[c.pos], c.buf[c.pos+1]
+		c.latch = hi
+		c.pos += 2
+		if c.pos == len(c.buf) {
+			c.nextSector()
+		}
+		return lo
+	case cffaError:
+		return c.err
+	case cffaSectors:
+		return c.sectors
+	case cffaLBA0, cffaLBA8, cffaLBA16, cffaDriveHead:
+		return c.lba[addr-cffaLBA0]
+	}
+	return c.status
+}
+
+func (c *cffaCard) StoreIO(addr uint16, v byte) {
+	switch addr {
+	case cffaDataHigh:
+		c.latch = v
+		return
+	case cffaSetCSMask:
+		c.csMask = true
+		return
+	case cffaClearCS:
+		c.csMask = false
+		return
+	case cffaAltStatus:
+		if v&0x04 != 0 && c.control&0x04 == 0 {
+			c.Reset()
+		}
+		c.control = v
+		return
+	}
+	if addr < cffaData || c.csMask {
+		return
+	}
+
+	switch addr {
+	case cffaData:
+		if c.status&ataStatusDRQ == 0 || !c.writing {
+			return
+		}
+		c.buf[c.pos], c.buf[c.pos+1] = v, c.latch
+		c.pos += 2
+		if c.pos == len(c.buf) {
+			c.writeSector()
+		}
+	case cffaError:
+		c.feature = v
+	case cffaSectors:
+		c.sectors = v
+	case cffaLBA0, cffaLBA8, cffaLBA16, cffaDriveHead:
+		c.lba[addr-cffaLBA0] = v
+	case cffaStatusCmd:
+		c.command(v)
+	}
+}
+
+// sector returns the LBA sector number in the task file registers.
+func (c *cffaCard) sector() int {
+	return int(c.lba[0]) | int(c.lba[1])<<8 | int(c.lba[2])<<16 | int(c.lba[3]&0x0f)<<24
+}
+
+// setSector stores an LBA sector number in the task file registers.
+func (c *cffaCard) setSector(n int) {
+	c.lba[0], c.lba[1], c.lba[2] = byte(n), byte(n>>8), byte(n>>16)
+	c.lba[3] = c.lba[3]&0xf0 | byte(n>>24)&0x0f
+}
+
+// command starts an ATA command.
+func (c *cffaCard) command(cmd byte) {
+	c.err = 0
+	c.status = ataStatusReady | ataStatusSeek
+	c.left = int(c.sectors)
+	if c.left == 0 {
+		c.left = 256
+	}
+
+	// Only the master device is present.
+	if c.lba[3]&0x10 != 0 {
+		c.abort(ataErrorAbort)
+		return
+	}
+
+	switch cmd {
+	case ataCmdIdentify:
+		c.identify()
+		c.left = 1
+		c.startTransfer(false)
+	case ataCmdRead, ataCmdReadNoRetry:
+		if c.readSector() {
+			c.startTransfer(false)
+		}
+	case ataCmdWrite, ataCmdWriteNR:
+		if c.sector() >= c.img.Sectors() {
+			c.abort(ataErrorRange)
+			return
+		}
+		c.startTransfer(true)
+	case ataCmdSetFeatures:
+	default:
+		c.abort(ataErrorAbort)
+	}
+}
+
+func (c *cffaCard) abort(err byte) {
+	c.err = err
+	c.status = ataStatusReady | ataStatusSeek | ataStatusError
+}
+
+func (c *cffaCard) startTransfer(writing bool) {
+	c.pos = 0
+	c.writing = writing
+	c.status |= ataStatusDRQ
+}
+
+// readSector fills the buffer from the current sector, or aborts the
+// command if it's past the end of the device.
+func (c *cffaCard) readSector() bool {
+	n := c.sector()
+	if n >= c.img.Sectors() {
+		c.abort(ataErrorRange)
+		return false
+	}
+	copy(c.buf[:], c.img.data[n*cffaSectorSize:])
+	return true
+}
+
+// nextSector moves on once the host has read the buffer.
+func (c *cffaCard) nextSector() {
+	c.left--
+	c.status &^= ataStatusDRQ
+	if c.left == 0 {
+		return
+	}
+	c.setSector(c.sector() + 1)
+	if c.readSector() {
+		c.startTransfer(false)
+	}
+}
+
+// writeSector stores the buffer once the host has filled it.
+func (c *cffaCard) writeSector() {
+	n := c.sector()
+	copy(c.img.data[n*cffaSectorSize:], c.buf[:])
+	c.img.dirty = true
+	c.left--
+	c.status &^= ataStatusDRQ
+	if c.left == 0 {
+		return
+	}
+	c.setSector(n + 1)
+	if c.sector() >= c.img.Sectors() {
+		c.abort(ataErrorRange)
+		return
+	}
+	c.startTransfer(true)
+}
+
+// identify fills the buffer with the device's identification, as a
+// CompactFlash card in LBA mode reports it.
+func (c *cffaCard) identify() {
+	for i := range c.buf {
+		c.buf[i] = 0
+	}
+	word := func(i int, v uint16) {
+		c.buf[2*i], c.buf[2*i+1] = byte(v), byte(v>>8)
+	}
+	text := func(i, n int, s string) {
+		s += strings.Repeat(" ", 2*n)
+		for j := 0; j < n; j++ {
+			// ATA strings hold the first character of each pair in the
+			// high byte.
+			word(i+j, uint16(s[2*j])<<8|uint16(s[2*j+1]))
+		}
+	}
+
+	sectors := c.img.Sectors()
+	const heads, perTrack = 16, 63
+	cylinders := sectors / (heads * perTrack)
+	if cylinders > 0xffff {
+		cylinders = 0xffff
+	}
+	word(0, 0x848a) // CompactFlash signature
+	word(1, uint16(cylinders))
+	word(3, heads)
+	word(6, perTrack)
+	word(7, uint16(sectors>>16))
+	word(8, uint16(sectors))
+	text(10, 10, "A2GO0001")
+	text(23, 4, "1.0")
+	text(27, 20, "APPLE2GO COMPACTFLASH")
+	word(47, 1)     // sectors per interrupt for READ/WRITE MULTIPLE
+	word(49, 0x200) // LBA supported
+	word(60, uint16(sectors))
+	word(61, uint16(sectors>>16))
+}
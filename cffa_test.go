@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCFFA(t *testing.T) {
+	// An image with a full first partition and a small second one, each
+	// holding a ProDOS volume.
+	data := make([]byte, (cfPartitionBlocks+256)*cffaSectorSize)
+	for i, name := range []string{"HARD1", "HARD2"} {
+		blocks := 256
+		if i == 0 {
+			blocks = cfPartitionBlocks - 1
+		}
+		vol, err := formatProDOS(name, blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(data[i*cfPartitionBlocks*cffaSectorSize:], vol)
+	}
+	data[5*cffaSectorSize] = 0xa5
+	file := filepath.Join(t.TempDir(), "cf.img")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := loadCFImage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := img.Partitions()
+	if len(parts) != 2 || parts[0].volume != "HARD1" || parts[1].volume != "HARD2" ||
+		parts[1].start != cfPartitionBlocks || parts[1].blocks != 256 {
+		t.Fatalf("Unexpected partitions %v\n", parts)
+	}
+
+	rom := make([]byte, 4096)
+	copy(rom[0x700:], "CFFA")
+	rom[0x7ff] = 0x77
+	rom[0x800] = 0x88
+	a := newApple2()
+	c := newCFFACard(a, 7, rom, img)
+	a.sm.Insert(7, c)
+	if a.mmu.LoadByte(0xc7ff) != 0x77 || a.mmu.LoadByte(0xc800) != 0x88 {
+		t.Errorf("Unexpected firmware\n")
+	}
+
+	const io = 0xc0f0
+	reg := func(r uint16) byte { return a.mmu.LoadByte(io + r) }
+	set := func(r uint16, v byte) { a.mmu.StoreByte(io+r, v) }
+
+	// IDENTIFY reports the number of sectors.
+	set(cffaDriveHead, 0xe0)
+	set(cffaStatusCmd, ataCmdIdentify)
+	if reg(cffaStatusCmd)&ataStatusDRQ == 0 {
+		t.Fatalf("IDENTIFY returned no data\n")
+	}
+	var words [256]int
+	for i := range words {
+		lo := reg(cffaData)
+		words[i] = int(reg(cffaDataHigh))<<8 | int(lo)
+	}
+	if words[0] != 0x848a || words[60]|words[61]<<16 != img.Sectors() {
+		t.Errorf("Unexpected IDENTIFY data %04X %04X %04X\n", words[0], words[60], words[61])
+	}
+
+	// Read sectors 4 and 5, then write sector 6.
+	set(cffaSectors, 2)
+	set(cffaLBA0, 4)
+	set(cffaLBA8, 0)
+	set(cffaLBA16, 0)
+	set(cffaStatusCmd, ataCmdRead)
+	var got []byte
+	for reg(cffaStatusCmd)&ataStatusDRQ != 0 {
+		got = append(got, reg(cffaData), reg(cffaDataHigh))
+	}
+	if len(got) != 2*cffaSectorSize || got[cffaSectorSize] != 0xa5 {
+		t.Errorf("Read %d bytes\n", len(got))
+	}
+	set(cffaSectors, 1)
+	set(cffaLBA0, 6)
+	set(cffaStatusCmd, ataCmdWrite)
+	for i := 0; i < cffaSectorSize/2; i++ {
+		set(cffaDataHigh, 0x5a)
+		set(cffaData, byte(i))
+	}
+	if reg(cffaStatusCmd) != ataStatusReady|ataStatusSeek || img.data[6*cffaSectorSize+3] != 0x5a {
+		t.Errorf("Write failed\n")
+	}
+
+	// Reads past the end of the device fail.
+	set(cffaLBA16, 0xff)
+	set(cffaStatusCmd, ataCmdRead)
+	if reg(cffaStatusCmd)&ataStatusError == 0 || reg(cffaError) != ataErrorRange {
+		t.Errorf("Expected an error\n")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img, err = loadCFImage(file)
+	if err != nil || img.data[6*cffaSectorSize+1] != 0x5a {
+		t.Errorf("Image not saved: %v\n", err)
+	}
+}
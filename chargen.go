@@ -0,0 +1,299 @@
+package main
+
+import "errors"
+
+// A glyph is an 8-scanline character cell. Each row holds 7 dots, with
+// bit 6 the leftmost dot and set bits lit.
+type glyph [8]byte
+
+// A charGen is a video character generator. It holds the glyph displayed
+// for every screen code in the primary and alternate character sets.
+// Glyphs are stored as they are displayed; only flashing is applied at
+// render time.
+type charGen struct {
+	sets [2][256]glyph // primary and alternate (ALTCHARSET) sets
+	rom  bool          // true = glyphs were loaded from a character ROM
+}
+
+var errCharROMSize = errors.New("character ROM has an unsupported size")
+
+// newFallbackCharGen builds a character generator from the built-in font,
+// for use when no character ROM is available.
+func newFallbackCharGen(cfg *machineConfig) *charGen {
+	cg := &charGen{}
+	for set := range cg.sets {
+		for code := range cg.sets[set] {
+			ch, attr := textGlyphFor(cfg, set == 1, byte(code))
+			var g glyph
+			if attr == textMouse {
+				g = mouseTextFont[ch]
+			} else {
+				g = fallbackGlyph(ch)
+			}
+			if attr == textInverse {
+				g = g.inverse()
+			}
+			cg.sets[set][code] = g
+		}
+	}
+	return cg
+}
+
+// newCharGenROM builds a character generator from a character ROM image.
+//
+// A 4K IIe-style ROM holds the glyph of every screen code as displayed,
+// with the primary set in the lower 2K and the alternate set in the upper
+// 2K. The 2K and 512-byte ROMs of earlier models hold 64 normal glyphs,
+// which the video hardware inverts or flashes according to the top two
+// bits of the screen code.
+//
+// Dumps differ in dot polarity and bit order, so both are detected: the
+// normal space character must be blank, and the vertical stroke of the
+// normal 'L' must be the leftmost dot.
+func newCharGenROM(cfg *machineConfig, rom []byte) (*charGen, error) {
+	var raw func(set int, code byte) glyph
+	switch len(rom) {
+	case 4 * 1024:
+		raw = func(set int, code byte) (g glyph) {
+			copy(g[:], rom[set*0x800+int(code)*8:])
+			return g
+		}
+	case 2 * 1024, 512:
+		raw = func(set int, code byte) (g glyph) {
+			copy(g[:], rom[int(code&0x3f)*8:])
+			return g
+		}
+	default:
+		return nil, errCharROMSize
+	}
+
+	var invert byte
+	if space := raw(0, 0xa0); space[0]&0x7f == 0x7f {
+		invert = 0xff
+	}
+
+	// Find the dot column holding the stroke of the 'L'.
+	l := raw(0, 0xcc)
+	var counts [8]int
+	for _, row := range l[:7] {
+		row ^= invert
+		for b := uint(0); b < 8; b++ {
+			if row&(1<<b) != 0 {
+				counts[b]++
+			}
+		}
+	}
+	stroke := 0
+	for b := range counts {
+		if counts[b] > counts[stroke] {
+			stroke = b
+		}
+	}
+	reversed := stroke < 4
+
+	normalize := func(g glyph) glyph {
+		for i, row := range g {
+			row ^= invert
+			if reversed {
+				row = reverse7(row)
+			}
+			g[i] = row & 0x7f
+		}
+		return g
+	}
+
+	cg := &charGen{rom: true}
+	for set := range cg.sets {
+		for code := range cg.sets[set] {
+			g := normalize(raw(set, byte(code)))
+			if len(rom) < 4*1024 && code < 0x40 {
+				g = g.inverse()
+			}
+			cg.sets[set][code] = g
+		}
+	}
+	return cg, nil
+}
+
+// Glyph returns the glyph displayed for a screen code. Flashing
+// characters are shown inverted during the flash phase.
+func (cg *charGen) Glyph(cfg *machineConfig, alt bool, code byte, flash bool) glyph {
+	set := 0
+	if alt && cfg.iie {
+		set = 1
+	}
+	g := cg.sets[set][code]
+	if flash {
+		if _, attr := textGlyphFor(cfg, alt, code); attr == textFlash {
+			g = g.inverse()
+		}
+	}
+	return g
+}
+
+func (g glyph) inverse() glyph {
+	for i := range g {
+		g[i] ^= 0x7f
+	}
+	return g
+}
+
+// reverse7 reverses the order of the low 7 bits of v.
+func reverse7(v byte) byte {
+	var r byte
+	for i := 0; i < 7; i++ {
+		r = r<<1 | (v>>uint(i))&1
+	}
+	return r
+}
+
+// fallbackGlyph returns the built-in glyph for a printable ASCII
+// character. The 5-dot wide font is centered in the 7-dot cell.
+func fallbackGlyph(ch byte) glyph {
+	var g glyph
+	if ch < 0x20 || ch > 0x7f {
+		return g
+	}
+	for i, row := range fallbackFont[ch-0x20] {
+		g[i] = row << 1
+	}
+	return g
+}
+
+// fallbackFont holds 5x7 glyphs for ASCII $20..$7F. Each row holds 5 dots
+// with bit 4 the leftmost.
+var fallbackFont = [96][7]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // space
+	{0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04}, // !
+	{0x0a, 0x0a, 0x0a, 0x00, 0x00, 0x00, 0x00}, // "
+	{0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a}, // #
+	{0x04, 0x0f, 0x14, 0x0e, 0x05, 0x1e, 0x04}, // $
+	{0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03}, // %
+	{0x08, 0x14, 0x14, 0x08, 0x15, 0x12, 0x0d}, // &
+	{0x04, 0x04, 0x04, 0x00, 0x00, 0x00, 0x00}, // '
+	{0x04, 0x08, 0x10, 0x10, 0x10, 0x08, 0x04}, // (
+	{0x04, 0x02, 0x01, 0x01, 0x01, 0x02, 0x04}, // )
+	{0x04, 0x15, 0x0e, 0x04, 0x0e, 0x15, 0x04}, // *
+	{0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00}, // +
+	{0x00, 0x00, 0x00, 0x00, 0x04, 0x04, 0x08}, // ,
+	{0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00}, // -
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04}, // .
+	{0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00}, // /
+	{0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e}, // 0
+	{0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e}, // 1
+	{0x0e, 0x11, 0x01, 0x06, 0x08, 0x10, 0x1f}, // 2
+	{0x1f, 0x01, 0x02, 0x06, 0x01, 0x11, 0x0e}, // 3
+	{0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02}, // 4
+	{0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e}, // 5
+	{0x07, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e}, // 6
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08}, // 7
+	{0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e}, // 8
+	{0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x1c}, // 9
+	{0x00, 0x00, 0x04, 0x00, 0x04, 0x00, 0x00}, // :
+	{0x00, 0x00, 0x04, 0x00, 0x04, 0x04, 0x08}, // ;
+	{0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02}, // <
+	{0x00, 0x00, 0x1f, 0x00, 0x1f, 0x00, 0x00}, // =
+	{0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08}, // >
+	{0x0e, 0x11, 0x02, 0x04, 0x04, 0x00, 0x04}, // ?
+	{0x0e, 0x11, 0x15, 0x17, 0x16, 0x10, 0x0f}, // @
+	{0x04, 0x0a, 0x11, 0x11, 0x1f, 0x11, 0x11}, // A
+	{0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e}, // B
+	{0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e}, // C
+	{0x1e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x1e}, // D
+	{0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f}, // E
+	{0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10}, // F
+	{0x0f, 0x10, 0x10, 0x10, 0x13, 0x11, 0x0f}, // G
+	{0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11}, // H
+	{0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e}, // I
+	{0x01, 0x01, 0x01, 0x01, 0x01, 0x11, 0x0e}, // J
+	{0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11}, // K
+	{0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f}, // L
+	{0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11}, // M
+	{0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11}, // N
+	{0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e}, // O
+	{0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10}, // P
+	{0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d}, // Q
+	{0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11}, // R
+	{0x0e, 0x11, 0x10, 0x0e, 0x01, 0x11, 0x0e}, // S
+	{0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04}, // T
+	{0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e}, // U
+	{0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04}, // V
+	{0x11, 0x11, 0x11, 0x15, 0x15, 0x1b, 0x11}, // W
+	{0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11}, // X
+	{0x11, 0x11, 0x0a, 0x04, 0x04, 0x04, 0x04}, // Y
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f}, // Z
+	{0x1f, 0x18, 0x18, 0x18, 0x18, 0x18, 0x1f}, // [
+	{0x00, 0x10, 0x08, 0x04, 0x02, 0x01, 0x00}, // backslash
+	{0x1f, 0x03, 0x03, 0x03, 0x03, 0x03, 0x1f}, // ]
+	{0x00, 0x00, 0x04, 0x0a, 0x11, 0x00, 0x00}, // ^
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1f}, // _
+	{0x08, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00}, // `
+	{0x00, 0x00, 0x0e, 0x01, 0x0f, 0x11, 0x0f}, // a
+	{0x10, 0x10, 0x1e, 0x11, 0x11, 0x11, 0x1e}, // b
+	{0x00, 0x00, 0x0f, 0x10, 0x10, 0x10, 0x0f}, // c
+	{0x01, 0x01, 0x0f, 0x11, 0x11, 0x11, 0x0f}, // d
+	{0x00, 0x00, 0x0e, 0x11, 0x1f, 0x10, 0x0f}, // e
+	{0x06, 0x09, 0x08, 0x1e, 0x08, 0x08, 0x08}, // f
+	{0x00, 0x00, 0x0e, 0x11, 0x0f, 0x01, 0x0e}, // g
+	{0x10, 0x10, 0x1e, 0x11, 0x11, 0x11, 0x11}, // h
+	{0x04, 0x00, 0x0c, 0x04, 0x04, 0x04, 0x0e}, // i
+	{0x02, 0x00, 0x06, 0x02, 0x02, 0x12, 0x0c}, // j
+	{0x10, 0x10, 0x11, 0x12, 0x1c, 0x12, 0x11}, // k
+	{0x0c, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e}, // l
+	{0x00, 0x00, 0x1b, 0x15, 0x15, 0x15, 0x11}, // m
+	{0x00, 0x00, 0x1e, 0x11, 0x11, 0x11, 0x11}, // n
+	{0x00, 0x00, 0x0e, 0x11, 0x11, 0x11, 0x0e}, // o
+	{0x00, 0x00, 0x1e, 0x11, 0x1e, 0x10, 0x10}, // p
+	{0x00, 0x00, 0x0f, 0x11, 0x0f, 0x01, 0x01}, // q
+	{0x00, 0x00, 0x17, 0x18, 0x10, 0x10, 0x10}, // r
+	{0x00, 0x00, 0x0f, 0x10, 0x0e, 0x01, 0x1e}, // s
+	{0x08, 0x08, 0x1e, 0x08, 0x08, 0x09, 0x06}, // t
+	{0x00, 0x00, 0x11, 0x11, 0x11, 0x13, 0x0d}, // u
+	{0x00, 0x00, 0x11, 0x11, 0x11, 0x0a, 0x04}, // v
+	{0x00, 0x00, 0x11, 0x11, 0x15, 0x15, 0x1b}, // w
+	{0x00, 0x00, 0x11, 0x0a, 0x04, 0x0a, 0x11}, // x
+	{0x00, 0x00, 0x11, 0x11, 0x0f, 0x01, 0x0e}, // y
+	{0x00, 0x00, 0x1f, 0x02, 0x04, 0x08, 0x1f}, // z
+	{0x07, 0x0c, 0x0c, 0x18, 0x0c, 0x0c, 0x07}, // {
+	{0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04}, // |
+	{0x1c, 0x06, 0x06, 0x03, 0x06, 0x06, 0x1c}, // }
+	{0x0d, 0x16, 0x00, 0x00, 0x00, 0x00, 0x00}, // ~
+	{0x15, 0x0a, 0x15, 0x0a, 0x15, 0x0a, 0x15}, // DEL
+}
+
+// mouseTextFont approximates the 32 MouseText glyphs of the enhanced IIe
+// and the IIc. MouseText glyphs span the full 7-dot cell.
+var mouseTextFont = [32]glyph{
+	{0x08, 0x10, 0x36, 0x7f, 0x3f, 0x3f, 0x7e, 0x36}, // closed apple
+	{0x08, 0x10, 0x36, 0x49, 0x41, 0x41, 0x49, 0x36}, // open apple
+	{0x00, 0x00, 0x02, 0x06, 0x4e, 0x7e, 0x0e, 0x02}, // pointer
+	{0x7f, 0x22, 0x14, 0x08, 0x08, 0x14, 0x2a, 0x7f}, // hourglass
+	{0x00, 0x01, 0x02, 0x04, 0x48, 0x50, 0x60, 0x40}, // checkmark
+	{0x7f, 0x7e, 0x7d, 0x7b, 0x37, 0x2f, 0x1f, 0x3f}, // inverse checkmark
+	{0x70, 0x70, 0x70, 0x7f, 0x7f, 0x70, 0x70, 0x70}, // left scroll bar
+	{0x07, 0x07, 0x07, 0x7f, 0x7f, 0x07, 0x07, 0x07}, // right scroll bar
+	{0x00, 0x08, 0x10, 0x3f, 0x10, 0x08, 0x00, 0x00}, // left arrow
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2a}, // ellipsis
+	{0x08, 0x08, 0x08, 0x08, 0x49, 0x2a, 0x1c, 0x08}, // down arrow
+	{0x08, 0x1c, 0x2a, 0x49, 0x08, 0x08, 0x08, 0x08}, // up arrow
+	{0x7f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // overbar
+	{0x01, 0x01, 0x11, 0x21, 0x7f, 0x20, 0x10, 0x00}, // return
+	{0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f}, // solid block
+	{0x04, 0x0c, 0x1c, 0x3c, 0x3c, 0x1c, 0x0c, 0x04}, // scroll left
+	{0x10, 0x18, 0x1c, 0x1e, 0x1e, 0x1c, 0x18, 0x10}, // scroll right
+	{0x00, 0x00, 0x00, 0x7f, 0x3e, 0x1c, 0x08, 0x00}, // scroll down
+	{0x00, 0x08, 0x1c, 0x3e, 0x7f, 0x00, 0x00, 0x00}, // scroll up
+	{0x00, 0x00, 0x00, 0x7f, 0x00, 0x00, 0x00, 0x00}, // horizontal line
+	{0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x7f}, // lower left corner
+	{0x00, 0x08, 0x04, 0x7e, 0x04, 0x08, 0x00, 0x00}, // right arrow
+	{0x2a, 0x55, 0x2a, 0x55, 0x2a, 0x55, 0x2a, 0x55}, // checkerboard
+	{0x55, 0x2a, 0x55, 0x2a, 0x55, 0x2a, 0x55, 0x2a}, // checkerboard
+	{0x00, 0x3c, 0x42, 0x7f, 0x41, 0x41, 0x7f, 0x00}, // folder left
+	{0x00, 0x00, 0x00, 0x7f, 0x01, 0x01, 0x7f, 0x00}, // folder right
+	{0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01}, // right vertical bar
+	{0x08, 0x1c, 0x3e, 0x7f, 0x3e, 0x1c, 0x08, 0x00}, // diamond
+	{0x7f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7f}, // double horizontal
+	{0x14, 0x14, 0x77, 0x00, 0x77, 0x14, 0x14, 0x00}, // cross
+	{0x7f, 0x40, 0x40, 0x4e, 0x4e, 0x40, 0x40, 0x7f}, // left box
+	{0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40}, // left vertical bar
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	errCheatSyntax = errors.New("invalid cheat; expected addr=value[,once][,if=value]")
+	errSearchOff   = errors.New("no memory search in progress; start one with cs start")
+)
+
+// A cheat changes a byte of memory to give a program's player an
+// advantage, such as more lives. A frozen cheat stores its value at the
+// end of every frame, so that the program can't change it, and a patch
+// stores it once. A cheat with a condition waits for the byte to hold an
+// expected value before storing its own, so that a patch can wait for the
+// program it changes to be loaded.
+type cheat struct {
+	view   memView
+	addr   uint16
+	value  byte
+	freeze bool // true = store the value every frame
+	cond   int  // value the byte must hold before the cheat applies, or -1
+	done   bool // true = the patch has been applied
+}
+
+// parseCheat parses a cheat of the form addr=value, which freezes the
+// byte at addr, optionally followed by ",once" to patch it once, and
+// ",if=value" to wait until it holds a value. The address may be in a
+// physical memory, as in aux.1:0300=05 (see parseMemAddr); all numbers
+// are hexadecimal.
+func parseCheat(s string) (*cheat, error) {
+	fields := strings.Split(s, ",")
+	addr, value, ok := strings.Cut(fields[0], "=")
+	if !ok {
+		return nil, errCheatSyntax
+	}
+	c := &cheat{freeze: true, cond: -1}
+	var err error
+	if c.view, addr, err = parseMemAddr(addr); err != nil {
+		return nil, err
+	}
+	a, err := parseDebugValue(addr, 0xffff)
+	if err != nil {
+		return nil, err
+	}
+	c.addr = uint16(a)
+	if !c.view.phys && c.addr >= 0xc000 && c.addr < 0xc100 {
+		return nil, errMemIO
+	}
+	v, err := parseDebugValue(value, 0xff)
+	if err != nil {
+		return nil, err
+	}
+	c.value = byte(v)
+	for _, f := range fields[1:] {
+		switch {
+		case f == "once":
+			c.freeze = false
+		case strings.HasPrefix(f, "if="):
+			v, err := parseDebugValue(f[3:], 0xff)
+			if err != nil {
+				return nil, err
+			}
+			c.cond = v
+		default:
+			return nil, errCheatSyntax
+		}
+	}
+	return c, nil
+}
+
+func (c *cheat) String() string {
+	s := fmt.Sprintf("%s=%02X", memViewString(c.view, c.addr), c.value)
+	if !c.freeze {
+		s += ",once"
+	}
+	if c.cond >= 0 {
+		s += fmt.Sprintf(",if=%02X", c.cond)
+	}
+	if c.done {
+		s += " (applied)"
+	}
+	return s
+}
+
+// apply stores the cheat's value if it applies.
+func (c *cheat) apply(a *apple2) {
+	if c.done {
+		return
+	}
+	if c.cond >= 0 {
+		v, err := a.ReadMemory(c.view, c.addr, 1)
+		if err != nil || int(v[0]) != c.cond {
+			return
+		}
+		if c.freeze {
+			// A frozen cheat's condition holds only until it first applies.
+			c.cond = -1
+		}
+	}
+	if a.WriteMemory(c.view, c.addr, []byte{c.value}) == nil && !c.freeze {
+		c.done = true
+	}
+}
+
+// cheatSpecs is a flag value holding the cheats given by repeated -cheat
+// flags.
+type cheatSpecs []*cheat
+
+func (l *cheatSpecs) String() string {
+	s := make([]string, len(*l))
+	for i, c := range *l {
+		s[i] = c.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func (l *cheatSpecs) Set(s string) error {
+	c, err := parseCheat(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, c)
+	return nil
+}
+
+// AddCheat adds a cheat, which applies from the end of the current frame.
+func (a *apple2) AddCheat(c *cheat) {
+	a.cheats = append(a.cheats, c)
+}
+
+// RemoveCheat removes the cheat at an index in the list of cheats.
+func (a *apple2) RemoveCheat(i int) {
+	a.cheats = append(a.cheats[:i], a.cheats[i+1:]...)
+}
+
+// applyCheats applies the cheats at the end of a frame.
+func (a *apple2) applyCheats() {
+	for _, c := range a.cheats {
+		c.apply(a)
+	}
+}
+
+// A memSearch finds the addresses of a program's variables, such as its
+// count of lives, for cheats. A search starts with every byte of a memory
+// as a candidate and narrows them down by comparing each byte with a
+// value or with its value when last compared.
+type memSearch struct {
+	view  memView // the memory searched
+	addrs []uint16
+	last  []byte // the value of each candidate when last compared
+}
+
+// newMemSearch starts a search of a memory. The I/O page and the parts of
+// a physical memory that don't exist aren't searched.
+func newMemSearch(a *apple2, v memView) *memSearch {
+	s := &memSearch{view: v}
+	for addr := 0; addr < 0x10000; addr++ {
+		if !v.phys && addr >= 0xc000 && addr < 0xc100 {
+			continue
+		}
+		if b, err := a.ReadMemory(v, uint16(addr), 1); err == nil {
+			s.addrs = append(s.addrs, uint16(addr))
+			s.last = append(s.last, b[0])
+		}
+	}
+	return s
+}
+
+// Filter keeps the candidates whose current value and value when last
+// compared satisfy keep, and returns the number kept.
+func (s *memSearch) Filter(a *apple2, keep func(old, cur byte) bool) int {
+	n := 0
+	for i, addr := range s.addrs {
+		b, err := a.ReadMemory(s.view, addr, 1)
+		if err != nil {
+			continue
+		}
+		cur := b[0]
+		if !keep(s.last[i], cur) {
+			continue
+		}
+		s.addrs[n], s.last[n] = addr, cur
+		n++
+	}
+	s.addrs, s.last = s.addrs[:n], s.last[:n]
+	return n
+}
+
+// List writes up to max candidates and their values.
+func (s *memSearch) List(w io.Writer, max int) {
+	for i, addr := range s.addrs {
+		if i == max {
+			fmt.Fprintf(w, "... and %d more\n", len(s.addrs)-max)
+			break
+		}
+		fmt.Fprintf(w, "%s = %02X\n", memViewString(s.view, addr), s.last[i])
+	}
+}
+
+// memSearchFilters are the comparisons by which a search narrows down its
+// candidates, other than with a value.
+var memSearchFilters = map[string]func(old, cur byte) bool{
+	"changed":   func(old, cur byte) bool { return cur != old },
+	"unchanged": func(old, cur byte) bool { return cur == old },
+	"inc":       func(old, cur byte) bool { return cur > old },
+	"dec":       func(old, cur byte) bool { return cur < old },
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseCheat(t *testing.T) {
+	for _, s := range []string{"0300=05", "aux.1:0300=FF,once", "0010=03,if=00", "C100=00,once,if=4C"} {
+		c, err := parseCheat(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v\n", s, err)
+			continue
+		}
+		if c.String() != s {
+			t.Errorf("Expected %s, got %s\n", s, c)
+		}
+	}
+	for _, s := range []string{"0300", "0300=100", "0300=05,twice", "C030=00"} {
+		if _, err := parseCheat(s); err == nil {
+			t.Errorf("%s: expected an error\n", s)
+		}
+	}
+}
+
+func TestCheats(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x300, []byte{
+		0xe6, 0x10, // INC $10
+		0xe6, 0x11, // INC $11
+		0x4c, 0x00, 0x03, // JMP $0300
+	})
+	a.mmu.StoreByte(0x12, 0x01)
+	a.cpu.SetPC(0x300)
+	for _, s := range []string{"0010=05", "0012=AA,once,if=02", "main:0013=77,once"} {
+		c, _ := parseCheat(s)
+		a.AddCheat(c)
+	}
+
+	a.RunFrame()
+	if v := a.mmu.Peek(0x10); v != 0x05 {
+		t.Errorf("Expected the frozen byte to hold 05, got %02X\n", v)
+	}
+	if v := a.mmu.Peek(0x13); v != 0x77 {
+		t.Errorf("Expected the patch to apply, got %02X\n", v)
+	}
+	if v := a.mmu.Peek(0x12); v != 0x01 || a.cheats[1].done {
+		t.Errorf("Expected the patch to wait for its condition, got %02X\n", v)
+	}
+	a.mmu.StoreByte(0x12, 0x02)
+	a.mmu.StoreByte(0x13, 0x00)
+	a.RunFrame()
+	if v := a.mmu.Peek(0x12); v != 0xaa || !a.cheats[1].done {
+		t.Errorf("Expected the patch to apply once its condition held, got %02X\n", v)
+	}
+	if v := a.mmu.Peek(0x13); v != 0x00 {
+		t.Errorf("Expected a patch to apply only once, got %02X\n", v)
+	}
+
+	// A search finds the counter the program increments.
+	d := newDebugger(a)
+	var out bytes.Buffer
+	for _, cmd := range []string{"cs start", "ch", "chc 1"} {
+		if err := d.Exec(cmd, &out); err != nil {
+			t.Fatalf("%s: unexpected error %v\n", cmd, err)
+		}
+	}
+	if !strings.Contains(out.String(), "1: 0010=05\n") || len(a.cheats) != 2 {
+		t.Errorf("Unexpected cheats:\n%s", out.String())
+	}
+	a.RunFrame()
+	d.Exec("cs changed", &out)
+	a.RunFrame()
+	d.Exec("cs changed", &out)
+	d.Exec(fmt.Sprintf("cs %02X", a.mmu.Peek(0x11)), &out)
+	found := false
+	for _, addr := range d.search.addrs {
+		found = found || addr == 0x11
+	}
+	if !found || len(d.search.addrs) > 8 {
+		t.Errorf("Expected the search to narrow to the counter, got %04X\n", d.search.addrs)
+	}
+}
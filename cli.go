@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// commandUsage describes the subcommands.
+const commandUsage = `usage: apple2go [command] [flags] [args]
+
+commands:
+  run [file[,addr]]     run the machine, booting a program if one is given (default)
+  debug                 run the machine under the debugger console
+  screenshot file.png   run headlessly for -frames frames, then save a screenshot
+  record file.gif       run headlessly for -frames frames, recording the display
+  snapshot save file    run headlessly for -frames frames, then save a snapshot
+  snapshot load file    restore a snapshot and run the machine
+  disk ls|get|put ...   manage the files on a disk image
+  selftest              run the built-in self-test of the IIe or IIc
+  farm                  run many machines at once, controlled over HTTP
+  help [command]        describe a command and its flags
+
+The commands that run the machine share its flags, such as -model, -romdir,
+-disk1, -speed and -display; "apple2go help run" lists them.
+`
+
+// machineCommands maps the commands that run the machine to the usage of
+// their arguments.
+var machineCommands = map[string]string{
+	"run":           "[file[,addr]]",
+	"debug":         "",
+	"screenshot":    "file",
+	"record":        "file",
+	"snapshot save": "file",
+	"snapshot load": "file",
+}
+
+// defaultCaptureFrames is the number of frames the capture commands run
+// when -frames isn't given: long enough for the ROM to finish booting.
+const defaultCaptureFrames = 5 * 60
+
+// runCommand runs the command named by the command line arguments and
+// returns its exit status. With no command, or only flags, it runs the
+// machine. The disk commands are also accepted without "disk".
+func runCommand(args []string, w io.Writer) int {
+	cmd := ""
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "run", "debug", "screenshot", "record":
+		return runMachine(cmd, args[1:])
+	case "snapshot":
+		if len(args) > 1 && (args[1] == "save" || args[1] == "load") {
+			return runMachine(cmd+" "+args[1], args[2:])
+		}
+		fmt.Fprint(w, commandUsage)
+		return 2
+	case "disk":
+		if status, ok := runDiskCommand(args[1:], w); ok {
+			return status
+		}
+		fmt.Fprintln(w, diskCommandUsage)
+		return 2
+	case "selftest":
+		return runSelfTestCommand(args[1:], w)
+	case "farm":
+		return runFarmCommand(args[1:], w)
+	case "help":
+		return runHelpCommand(args[1:], w)
+	}
+
+	if status, ok := runDiskCommand(args, w); ok {
+		return status
+	}
+	if cmd != "" && !strings.HasPrefix(cmd, "-") {
+		fmt.Fprintf(w, "ERROR: unknown command '%s'\n", cmd)
+		fmt.Fprint(w, commandUsage)
+		return 2
+	}
+	return runMachine("run", args)
+}
+
+// runHelpCommand runs the "help" command, which describes a command.
+func runHelpCommand(args []string, w io.Writer) int {
+	cmd := strings.Join(args, " ")
+	switch {
+	case cmd == "":
+		fmt.Fprint(w, commandUsage)
+	case cmd == "disk":
+		fmt.Fprintln(w, diskCommandUsage)
+	case cmd == "selftest":
+		return runSelfTestCommand([]string{"-h"}, w)
+	case cmd == "farm":
+		return runFarmCommand([]string{"-h"}, w)
+	case cmd == "snapshot":
+		return runMachine("snapshot save", []string{"-h"})
+	case machineCommands[cmd] != "" || cmd == "debug":
+		return runMachine(cmd, []string{"-h"})
+	default:
+		fmt.Fprintf(w, "ERROR: unknown command '%s'\n", cmd)
+		return 2
+	}
+	return 0
+}
+
+// isDisplayBackend returns true if s names a display backend.
+func isDisplayBackend(s string) bool {
+	return s == "none" || s == "text" || s == "tui"
+}
+
+// runHeadless runs the machine for a number of frames, or if frames is 0,
+// until the process is interrupted. It runs in real time if paced is
+// true, and otherwise as fast as it can. The text display backend shows
+// the text screen and the drive lights on w whenever they change.
+func (a *apple2) runHeadless(display string, frames int, paced bool, w io.Writer) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	var lights *driveLights
+	if d, err := a.diskController(); err == nil && display == "text" {
+		lights = &driveLights{}
+		d.AddObserver(lights)
+		defer d.RemoveObserver(lights)
+	}
+
+	end := a.cpu.Cycles + uint64(frames)*cyclesPerFrame
+	shown := ""
+	for frames == 0 || a.cpu.Cycles < end {
+		select {
+		case <-interrupt:
+			return
+		default:
+		}
+
+		if paced {
+			a.RunPaced()
+		} else {
+			a.RunFrame()
+		}
+
+		if display == "text" {
+			text := a.ds.Text()
+			if lights != nil {
+				text += "\n" + lights.String()
+			}
+			if text != shown {
+				fmt.Fprintf(w, "\x1b[H\x1b[2J%s\n", text)
+				shown = text
+			}
+		}
+	}
+}
+
+// driveLights shows the status of the disk drives in a line of text.
+type driveLights [2]driveStatus
+
+func (l *driveLights) DriveChanged(s driveStatus) {
+	l[s.drive-1] = s
+}
+
+func (l *driveLights) String() string {
+	var parts []string
+	for i, s := range l {
+		light := "off"
+		switch {
+		case s.writing:
+			light = fmt.Sprintf("writing track %d", s.Track())
+		case s.motorOn:
+			light = fmt.Sprintf("on, track %d", s.Track())
+		}
+		parts = append(parts, fmt.Sprintf("drive %d: %s", i+1, light))
+	}
+	return strings.Join(parts, "   ")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommandUsage(t *testing.T) {
+	cases := []struct {
+		args   []string
+		status int
+		text   string
+	}{
+		{[]string{"help"}, 0, "snapshot save file"},
+		{[]string{"help", "disk"}, 0, "apple2go disk ls"},
+		{[]string{"help", "bogus"}, 2, "unknown command 'bogus'"},
+		{[]string{"bogus"}, 2, "unknown command 'bogus'"},
+		{[]string{"snapshot", "take"}, 2, "usage: apple2go"},
+		{[]string{"disk", "format"}, 2, "apple2go disk put"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		status := runCommand(c.args, &out)
+		if status != c.status || !strings.Contains(out.String(), c.text) {
+			t.Errorf("%v: status %d, output:\n%s\n", c.args, status, out.String())
+		}
+	}
+}
+
+func TestCaptureCommands(t *testing.T) {
+	if _, err := os.Stat("resources/apple2e.rom"); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	png := filepath.Join(dir, "boot.png")
+	state := filepath.Join(dir, "boot.state")
+
+	for _, args := range [][]string{
+		{"screenshot", "-config", "", "-frames", "60", png},
+		{"snapshot", "save", "-config", "", "-frames", "60", state},
+	} {
+		if status := runCommand(args, os.Stdout); status != 0 {
+			t.Errorf("%v: exit status %d\n", args, status)
+		}
+	}
+	for _, file := range []string{png, state} {
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			t.Errorf("%s not written: %v\n", file, err)
+		}
+	}
+
+	if status := runCommand([]string{"screenshot", "-config", ""}, os.Stdout); status != 2 {
+		t.Errorf("Expected status 2 for a missing file, got %d\n", status)
+	}
+}
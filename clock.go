@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ThunderClock time formats, selected by writing the format character
+// through the firmware's write entry point.
+const (
+	clockFormatNumeric = '#' // mo,dw,dt,hr,mn,sc
+	clockFormat24Hour  = '%' // DOW MON DT HR:MN:SC
+	clockFormat12Hour  = '&' // DOW MON DT HR:MN:SC AM
+)
+
+// clockInputBuffer is the address of the monitor's input buffer, where
+// the ThunderClock firmware returns the time.
+const clockInputBuffer = 0x0200
+
+// A thunderClock emulates a ThunderClock Plus real-time clock card, which
+// ProDOS recognizes by the signature bytes at $Cn00, $Cn02, $Cn04 and
+// $Cn06 and reads through the firmware. In place of the card's uPD1990
+// clock chip and its firmware, the card has built-in firmware that hands
+// calls to the emulation, which reads the host time.
+//
+// Calling $Cn08 stores the time in the input buffer at $0200 as a string
+// of characters with the high bit set, ending in a carriage return.
+// Calling $Cn0B with a format character in the accumulator selects the
+// format of the string.
+type thunderClock struct {
+	apple2   *apple2
+	format   byte
+	now      func() time.Time // returns the host time
+	firmware [256]byte
+}
+
+func newThunderClock(apple2 *apple2, slot int) *thunderClock {
+	c := &thunderClock{
+		apple2: apple2,
+		format: clockFormatNumeric,
+		now:    time.Now,
+	}
+	io := byte(0x80 + slot<<4)
+	page := byte(0xc0 + slot)
+	// $Cn00: PHP; SEI; PLP; BIT $FF58; BVS $Cn0E
+	// $Cn08: JMP $Cn0F (read)
+	// $Cn0B: JMP $Cn13 (write)
+	// $Cn0E: RTS (PR#n and IN#n are not supported)
+	// $Cn0F: STA $C0n1; RTS
+	// $Cn13: STA $C0n2; RTS
+	copy(c.firmware[:], []byte{
+		0x08, 0x78, 0x28, 0x2c, 0x58, 0xff, 0x70, 0x06,
+		0x4c, 0x0f, page,
+		0x4c, 0x13, page,
+		0x60,
+		0x8d, io + 1, 0xc0, 0x60,
+		0x8d, io + 2, 0xc0, 0x60,
+	})
+	return c
+}
+
+func (c *thunderClock) LoadIO(addr uint16) byte {
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *thunderClock) StoreIO(addr uint16, v byte) {
+	switch addr {
+	case 1:
+		c.read()
+	case 2:
+		switch v & 0x7f {
+		case clockFormatNumeric, clockFormat24Hour, clockFormat12Hour:
+			c.format = v & 0x7f
+		}
+	}
+}
+
+func (c *thunderClock) LoadROM(addr uint16) byte {
+	return c.firmware[addr]
+}
+
+func (c *thunderClock) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// read stores the time in the input buffer.
+func (c *thunderClock) read() {
+	s := c.Time()
+	for i := 0; i < len(s); i++ {
+		c.apple2.mmu.StoreByte(clockInputBuffer+uint16(i), s[i]|0x80)
+	}
+	c.apple2.mmu.StoreByte(clockInputBuffer+uint16(len(s)), '\r'|0x80)
+}
+
+// Time returns the time as the card reports it in its current format.
+func (c *thunderClock) Time() string {
+	t := c.now()
+	switch c.format {
+	case clockFormat24Hour:
+		return strings.ToUpper(t.Format("Mon Jan 02 15:04:05"))
+	case clockFormat12Hour:
+		return strings.ToUpper(t.Format("Mon Jan 02 03:04:05 PM"))
+	}
+	return fmt.Sprintf("%02d,%02d,%02d,%02d,%02d,%02d",
+		int(t.Month()), int(t.Weekday()), t.Day(), t.Hour(), t.Minute(), t.Second())
+}
+
+func (c *thunderClock) saveState(sw *stateWriter) {
+	sw.Tag("TCLK")
+	sw.Byte(c.format)
+}
+
+func (c *thunderClock) loadState(sr *stateReader) {
+	sr.Tag("TCLK")
+	c.format = sr.Byte()
+}
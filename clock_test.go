@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThunderClock(t *testing.T) {
+	a := newApple2()
+	c := newThunderClock(a, 4)
+	c.now = func() time.Time {
+		return time.Date(2024, time.March, 5, 14, 7, 9, 0, time.Local)
+	}
+	a.sm.Insert(4, c)
+
+	// ProDOS recognizes the card by its signature bytes.
+	for addr, v := range map[uint16]byte{0xc400: 0x08, 0xc402: 0x28, 0xc404: 0x58, 0xc406: 0x70} {
+		if b := a.mmu.LoadByte(addr); b != v {
+			t.Errorf("Expected %02X at $%04X, got %02X\n", v, addr, b)
+		}
+	}
+
+	a.mmu.StoreByte(0xc0c2, '#'|0x80)
+	a.mmu.StoreByte(0xc0c1, 0)
+	var s []byte
+	for addr := uint16(0x200); ; addr++ {
+		v := a.mmu.LoadByte(addr)
+		if v&0x80 == 0 {
+			t.Fatalf("Expected high-bit characters in the input buffer\n")
+		}
+		if v == 0x8d {
+			break
+		}
+		s = append(s, v&0x7f)
+	}
+	if string(s) != "03,02,05,14,07,09" {
+		t.Errorf("Unexpected numeric time %q\n", s)
+	}
+
+	a.mmu.StoreByte(0xc0c2, '&'|0x80)
+	if s := c.Time(); s != "TUE MAR 05 02:07:09 PM" {
+		t.Errorf("Unexpected 12-hour time %q\n", s)
+	}
+	a.mmu.StoreByte(0xc0c2, '%'|0x80)
+	if s := c.Time(); s != "TUE MAR 05 14:07:09" {
+		t.Errorf("Unexpected 24-hour time %q\n", s)
+	}
+}
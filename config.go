@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A configuration file describes a machine in a subset of TOML: sections,
+// and settings whose values are strings, numbers, booleans or one-line
+// arrays of them. For example:
+//
+//	[machine]
+//	model = "iie-enhanced"
+//	romdir = "roms"
+//
+//	[slots]
+//	4 = "mockingboard"
+//	6 = "diskii"
+//
+//	[disks]
+//	drive1 = "games.dsk"
+//	smartport = ["hd1.po", "hd2.po"]
+//
+//	[display]
+//	scale = 2
+//
+//	[keys]
+//	joystick = "arrows"
+//	leftalt = "button0"
+//
+// Each setting stands for a command line flag, which overrides it.
+// Relative file names are relative to the directory of the file.
+
+var (
+	errConfigSyntax  = errors.New("syntax error")
+	errConfigString  = errors.New("unterminated string")
+	errConfigSection = errors.New("unknown section")
+	errConfigKey     = errors.New("unknown setting")
+	errConfigCard    = errors.New("unknown card")
+	errConfigDiskII  = errors.New("the Disk II controller must be in slot 6")
+)
+
+// A configEntry is a setting read from a configuration file.
+type configEntry struct {
+	section string
+	key     string
+	values  []string // more than one for an array
+	line    int
+}
+
+// readConfig reads the settings of a configuration file.
+func readConfig(r io.Reader) ([]configEntry, error) {
+	var entries []configEntry
+	section := ""
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 || !isConfigComment(line[end+1:]) {
+				return nil, fmt.Errorf("line %d: %w", n, errConfigSyntax)
+			}
+			section = strings.TrimSpace(line[1:end])
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: %w", n, errConfigSyntax)
+		}
+		key := strings.TrimSpace(line[:i])
+		if k, err := strconv.Unquote(key); err == nil {
+			key = k
+		}
+		values, err := parseConfigValue(strings.TrimSpace(line[i+1:]))
+		if err != nil || key == "" {
+			if err == nil {
+				err = errConfigSyntax
+			}
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, configEntry{section, key, values, n})
+	}
+	return entries, sc.Err()
+}
+
+// parseConfigValue parses a value, or an array of values, followed by an
+// optional comment.
+func parseConfigValue(s string) ([]string, error) {
+	if s == "" || s[0] != '[' {
+		v, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		if !isConfigComment(rest) {
+			return nil, errConfigSyntax
+		}
+		return []string{v}, nil
+	}
+
+	var values []string
+	s = strings.TrimSpace(s[1:])
+	for {
+		if s != "" && s[0] == ']' {
+			if !isConfigComment(s[1:]) {
+				return nil, errConfigSyntax
+			}
+			return values, nil
+		}
+		v, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		s = strings.TrimSpace(rest)
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = strings.TrimSpace(s[1:])
+		case !strings.HasPrefix(s, "]"):
+			return nil, errConfigSyntax
+		}
+	}
+}
+
+// parseConfigScalar parses a string, number or boolean at the start of s,
+// returning it and the rest of s.
+func parseConfigScalar(s string) (v, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", errConfigSyntax
+				}
+				return v, s[i+1:], nil
+			}
+		}
+		return "", "", errConfigString
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errConfigString
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	v = s[:end]
+	if v != "true" && v != "false" {
+		if _, err := strconv.ParseFloat(strings.Replace(v, "_", "", -1), 64); err != nil {
+			return "", "", errConfigSyntax
+		}
+		v = strings.Replace(v, "_", "", -1)
+	}
+	return v, s[end:], nil
+}
+
+// isConfigComment returns true if s is blank or a comment.
+func isConfigComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// A configSetting maps a setting to the flag it stands for.
+type configSetting struct {
+	flag string
+	path bool // the value is a file name
+}
+
+// configSettings maps the sections and settings of a configuration file
+// to flags. The slots and keys sections are handled separately.
+var configSettings = map[string]map[string]configSetting{
+	"machine": {
+		"model":    {flag: "model"},
+		"romdir":   {flag: "romdir", path: true},
+		"aux":      {flag: "aux"},
+		"auxbanks": {flag: "auxbanks"},
+		"speed":    {flag: "speed"},
+		"autowarp": {flag: "autowarp"},
+		"zipchip":  {flag: "zipchip"},
+		"ramfill":  {flag: "ramfill"},
+		"ramseed":  {flag: "ramseed"},
+	},
+	"disks": {
+		"drive1":    {flag: "disk1", path: true},
+		"drive2":    {flag: "disk2", path: true},
+		"smartport": {flag: "spimage", path: true},
+		"sound":     {flag: "disksound"},
+	},
+	"display": {
+		"scale": {flag: "scale"},
+		"mono":  {flag: "mono"},
+	},
+	"printer": {
+		"file": {flag: "printfile", path: true},
+	},
+	"serial": {
+		"endpoint": {flag: "serial"},
+	},
+}
+
+// configCards are the cards that can be installed in the slots section.
+// Each is installed by the flag of the same name, which takes the slot,
+// except for the Disk II controller, which is always in slot 6; diskii13
+// is a Disk II controller with the 13-sector boot ROM.
+var configCards = []string{
+	"mockingboard", "ssc", "mouse", "smartport", "uthernet", "clock", "printer", "diskii", "diskii13",
+}
+
+// configFlags converts the entries of a configuration file in dir into
+// flag names and values.
+func configFlags(entries []configEntry, dir string) ([][2]string, error) {
+	var flags [][2]string
+	for _, e := range entries {
+		fail := func(err error) ([][2]string, error) {
+			return nil, fmt.Errorf("line %d: [%s] %s: %w", e.line, e.section, e.key, err)
+		}
+		switch e.section {
+		case "slots":
+			slot, err := strconv.Atoi(e.key)
+			if err != nil || slot < 1 || slot >= numSlots || len(e.values) != 1 {
+				return fail(errConfigKey)
+			}
+			card := e.values[0]
+			switch {
+			case !containsString(configCards, card):
+				return fail(fmt.Errorf("%w %q", errConfigCard, card))
+			case strings.HasPrefix(card, "diskii") && slot != 6:
+				return fail(errConfigDiskII)
+			case strings.HasPrefix(card, "diskii"):
+				flags = append(flags, [2]string{card, "true"})
+			default:
+				flags = append(flags, [2]string{card, e.key})
+			}
+		case "keys":
+			name := "keybutton"
+			value := e.key + "=" + strings.Join(e.values, "")
+			if e.key == "joystick" {
+				name, value = "joykeys", strings.Join(e.values, "")
+			}
+			flags = append(flags, [2]string{name, value})
+		default:
+			settings, ok := configSettings[e.section]
+			if !ok {
+				return fail(errConfigSection)
+			}
+			s, ok := settings[e.key]
+			if !ok {
+				return fail(errConfigKey)
+			}
+			for _, v := range e.values {
+				if s.path && v != "" && !filepath.IsAbs(v) && !strings.Contains(v, "://") {
+					v = filepath.Join(dir, v)
+				}
+				flags = append(flags, [2]string{s.flag, v})
+			}
+		}
+	}
+	return flags, nil
+}
+
+// containsString returns true if list contains s.
+func containsString(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultConfigFile returns the configuration file read when none is
+// given on the command line.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "apple2go", "config.toml")
+}
+
+// applyConfigFile sets the flags of fs from a configuration file, except
+// for the flags already set on the command line. A missing file is an
+// error only if it's required.
+func applyConfigFile(fs *flag.FlagSet, filename string, required bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	entries, err := readConfig(file)
+	if err == nil {
+		err = applyConfig(fs, entries, filepath.Dir(filename))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// applyConfig sets the flags of fs from the entries of a configuration
+// file in dir, except for the flags already set.
+func applyConfig(fs *flag.FlagSet, entries []configEntry, dir string) error {
+	flags, err := configFlags(entries, dir)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, f := range flags {
+		if set[f[0]] {
+			continue
+		}
+		if err := fs.Set(f[0], f[1]); err != nil {
+			return fmt.Errorf("%s: %w", f[0], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	const text = `
+# A IIe with a few cards.
+[machine]
+model = "iie"   # unenhanced
+ramseed = 1_000
+zipchip = true
+
+[slots]
+"4" = 'mockingboard'
+
+[disks]
+smartport = ["a.po", "b \"2\".po" ]
+`
+	entries, err := readConfig(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []configEntry{
+		{"machine", "model", []string{"iie"}, 4},
+		{"machine", "ramseed", []string{"1000"}, 5},
+		{"machine", "zipchip", []string{"true"}, 6},
+		{"slots", "4", []string{"mockingboard"}, 9},
+		{"disks", "smartport", []string{"a.po", `b "2".po`}, 12},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, entries)
+	}
+
+	bad := []struct {
+		text string
+		err  error
+	}{
+		{"[machine", errConfigSyntax},
+		{"model", errConfigSyntax},
+		{`model = "iie`, errConfigString},
+		{"model = iie", errConfigSyntax},
+		{`model = "iie" x`, errConfigSyntax},
+		{`a = ["x" "y"]`, errConfigSyntax},
+	}
+	for _, c := range bad {
+		if _, err := readConfig(strings.NewReader(c.text)); !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v\n", c.text, c.err, err)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	model := fs.String("model", "iie-enhanced", "")
+	romDir := fs.String("romdir", "./resources", "")
+	mb := fs.Int("mockingboard", 0, "")
+	diskII := fs.Bool("diskii", false, "")
+	joyKeys := fs.String("joykeys", "", "")
+	var spImages smartPortImages
+	fs.Var(&spImages, "spimage", "")
+	var keyButtons keyButtonMaps
+	fs.Var(&keyButtons, "keybutton", "")
+	fs.Parse([]string{"-model", "iic"})
+
+	const text = `
+[machine]
+model = "iie"
+romdir = "roms"
+[slots]
+4 = "mockingboard"
+6 = "diskii"
+[disks]
+smartport = ["/abs/a.po", "b.po", "http://host/c.po"]
+[keys]
+joystick = "wasd"
+leftalt = "button0"
+`
+	entries, err := readConfig(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join("home", "config")
+	if err := applyConfig(fs, entries, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if *model != "iic" {
+		t.Errorf("Command line model overridden by %s\n", *model)
+	}
+	if *romDir != filepath.Join(dir, "roms") {
+		t.Errorf("Unexpected ROM directory %s\n", *romDir)
+	}
+	if *mb != 4 || !*diskII || *joyKeys != "wasd" {
+		t.Errorf("Unexpected cards or keys: %d %v %s\n", *mb, *diskII, *joyKeys)
+	}
+	images := smartPortImages{"/abs/a.po", filepath.Join(dir, "b.po"), "http://host/c.po"}
+	if !reflect.DeepEqual(spImages, images) {
+		t.Errorf("Expected images %v, got %v\n", images, spImages)
+	}
+	if len(keyButtons) != 1 || keyButtons[0] != (keyButtonMap{hostKeyLeftAlt, 0}) {
+		t.Errorf("Unexpected key mappings %v\n", keyButtons)
+	}
+
+	bad := []struct {
+		text string
+		err  error
+	}{
+		{"[video]\nscale = 2", errConfigSection},
+		{"[machine]\ncolor = true", errConfigKey},
+		{"[slots]\n8 = \"ssc\"", errConfigKey},
+		{"[slots]\n2 = \"modem\"", errConfigCard},
+		{"[slots]\n5 = \"diskii\"", errConfigDiskII},
+	}
+	for _, c := range bad {
+		entries, _ := readConfig(strings.NewReader(c.text))
+		if err := applyConfig(fs, entries, dir); !errors.Is(err, c.err) {
+			t.Errorf("%q: expected %v, got %v\n", c.text, c.err, err)
+		}
+	}
+
+	for _, s := range []string{"leftalt", "hyper=button0", "a=button9", "a=fire"} {
+		if _, err := parseKeyButtonMap(s); err == nil {
+			t.Errorf("%s: expected an error\n", s)
+		}
+	}
+}
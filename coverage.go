@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Code/data log flags, one byte per byte of memory, as in the .cdl files
+// of Mesen and FCEUX that 6502 coverage viewers and disassemblers read.
+const (
+	coverCode     = 0x01 // part of an executed instruction
+	coverJump     = 0x04 // the target of a branch or jump that was taken
+	coverSubEntry = 0x08 // executed as the target of a JSR
+)
+
+// A coverage records the code the CPU executes, so that the code paths a
+// session exercised can be told from those it didn't. Code is recorded by
+// the physical memory and bank it was fetched from, since the same
+// address can hold different code in the language card, aux memory or a
+// banked ROM. Code in the I/O page and card ROMs is recorded by its
+// address in the CPU's address space.
+type coverage struct {
+	apple2 *apple2
+	logs   map[memView]*[0x10000]byte // flags of each covered memory
+
+	next uint16 // address of the instruction following the last one
+	jsr  bool   // true = the last instruction was a JSR
+	jump bool   // true = the last instruction was a branch or JMP
+}
+
+// EnableCoverage starts recording code coverage, returning the coverage.
+func (a *apple2) EnableCoverage() *coverage {
+	if a.cover == nil {
+		a.cover = &coverage{apple2: a, logs: make(map[memView]*[0x10000]byte)}
+	}
+	return a.cover
+}
+
+// DisableCoverage stops recording code coverage and discards it.
+func (a *apple2) DisableCoverage() {
+	a.cover = nil
+}
+
+// Clear discards the coverage recorded so far.
+func (c *coverage) Clear() {
+	c.logs = make(map[memView]*[0x10000]byte)
+}
+
+// exec records the execution of the instruction at pc.
+func (c *coverage) exec(pc uint16) {
+	m := c.apple2.mmu
+	inst := c.apple2.cpu.InstSet.Lookup(m.Peek(pc))
+	for i := uint16(0); i < uint16(inst.Length); i++ {
+		*c.flags(pc + i) |= coverCode
+	}
+	switch {
+	case c.jsr:
+		*c.flags(pc) |= coverSubEntry
+	case c.jump && pc != c.next:
+		*c.flags(pc) |= coverJump
+	}
+	c.next = pc + uint16(inst.Length)
+	c.jsr = inst.Opcode == opcodeJSR
+	switch inst.Opcode {
+	case 0x4c, 0x6c, 0x7c, 0x80: // JMP, JMP (ind), JMP (ind,X), BRA
+		c.jump = true
+	default:
+		c.jump = inst.Opcode&0x1f == 0x10 // conditional branches
+	}
+}
+
+// flags returns the flags of the memory the CPU reads at addr.
+func (c *coverage) flags(addr uint16) *byte {
+	var v memView
+	if p, ok := c.apple2.mmu.physReadAddr(addr); ok {
+		v = memView{phys: true, space: p.space, bank: p.bank}
+	}
+	log := c.logs[v]
+	if log == nil {
+		log = new([0x10000]byte)
+		c.logs[v] = log
+	}
+	return &log[addr]
+}
+
+// views returns the covered memories in order, with the CPU's address
+// space first.
+func (c *coverage) views() []memView {
+	views := make([]memView, 0, len(c.logs))
+	for v := range c.logs {
+		views = append(views, v)
+	}
+	sort.Slice(views, func(i, j int) bool {
+		a, b := views[i], views[j]
+		switch {
+		case a.phys != b.phys:
+			return !a.phys
+		case a.space != b.space:
+			return a.space < b.space
+		}
+		return a.bank < b.bank
+	})
+	return views
+}
+
+// coverName returns the name of a covered memory, as in parsePhysAddr.
+func coverName(v memView) string {
+	switch {
+	case !v.phys:
+		return "cpu"
+	case v.bank != 0:
+		return fmt.Sprintf("%s.%d", v.space, v.bank)
+	}
+	return v.space.String()
+}
+
+// WriteSummary writes the number of bytes of code executed in each
+// covered memory.
+func (c *coverage) WriteSummary(w io.Writer) {
+	for _, v := range c.views() {
+		code, entries := 0, 0
+		for _, f := range c.logs[v] {
+			if f&coverCode != 0 {
+				code++
+			}
+			if f&coverSubEntry != 0 {
+				entries++
+			}
+		}
+		fmt.Fprintf(w, "%-8s %5d bytes of code, %d subroutines\n", coverName(v), code, entries)
+	}
+}
+
+// WriteRanges writes the ranges of executed code, one per line, as in
+// main:0300-03FF or C600-C6FF for the CPU's address space.
+func (c *coverage) WriteRanges(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range c.views() {
+		log := c.logs[v]
+		start := -1
+		for addr := 0; addr <= len(log); addr++ {
+			code := addr < len(log) && log[addr]&coverCode != 0
+			switch {
+			case code && start < 0:
+				start = addr
+			case !code && start >= 0:
+				fmt.Fprint(bw, memViewString(v, uint16(start)))
+				if addr-1 > start {
+					fmt.Fprintf(bw, "-%04X", addr-1)
+				}
+				fmt.Fprintln(bw)
+				start = -1
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Save writes the coverage to a file. A file with a .cdl extension is
+// written as a code/data log for each covered memory, with the memory's
+// name added to the file name, as in cov-main.cdl and cov-aux.1.cdl. Each
+// holds a byte of flags for each of the memory's 64K addresses. Any other
+// file is written as a list of the ranges of executed code.
+func (c *coverage) Save(filename string) error {
+	ext := filepath.Ext(filename)
+	if !strings.EqualFold(ext, ".cdl") {
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		err = c.WriteRanges(file)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+
+	base := strings.TrimSuffix(filename, ext)
+	for _, v := range c.views() {
+		if err := os.WriteFile(base+"-"+coverName(v)+ext, c.logs[v][:], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
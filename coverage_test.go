@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	a := newApple2()
+	prog := []byte{
+		0x20, 0x10, 0x03, // JSR $0310
+		0x4c, 0x00, 0x03, // JMP $0300
+	}
+	a.mmu.StoreBytes(0x300, prog)
+	a.mmu.StoreByte(0x310, 0x60) // RTS
+	a.WriteMemory(memView{phys: true, space: memSpaceAux}, 0x300, prog)
+	a.WriteMemory(memView{phys: true, space: memSpaceAux}, 0x310, []byte{0xea, 0x60}) // NOP, RTS
+	a.cpu.SetPC(0x300)
+
+	c := a.EnableCoverage()
+	a.RunFrame()
+	a.mmu.StoreByte(0xc003, 0) // RAMRD: aux
+	a.RunFrame()
+	a.mmu.StoreByte(0xc002, 0) // RAMRD: main
+
+	var out bytes.Buffer
+	c.WriteRanges(&out)
+	want := "main:0300-0305\nmain:0310\naux:0300-0305\naux:0310-0311\n"
+	if out.String() != want {
+		t.Errorf("Expected ranges:\n%sgot:\n%s", want, out.String())
+	}
+	main := c.logs[memView{phys: true, space: memSpaceMain}]
+	if main[0x310] != coverCode|coverSubEntry || main[0x300]&coverJump == 0 || main[0x303] != coverCode {
+		t.Errorf("Unexpected flags %02X %02X %02X\n", main[0x310], main[0x300], main[0x303])
+	}
+
+	dir := t.TempDir()
+	if err := c.Save(filepath.Join(dir, "cov.cdl")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cov-main.cdl", "cov-aux.cdl"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || len(data) != 0x10000 || data[0x300]&coverCode == 0 {
+			t.Errorf("%s: unexpected code/data log (%v)\n", name, err)
+		}
+	}
+
+	out.Reset()
+	d := newDebugger(a)
+	if err := d.Exec("cov", &out); err != nil || !bytes.Contains(out.Bytes(), []byte("aux          8 bytes of code, 1 subroutines")) {
+		t.Errorf("Unexpected summary %q (%v)\n", out.String(), err)
+	}
+	d.Exec("cov off", &out)
+	if err := d.Exec("cov", &out); err != errCoverageOff {
+		t.Errorf("Expected coverage to be off, got %v\n", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// A crashGuard writes a crash report when the emulator panics, so that a
+// bug report can include the state of the machine at the time. The report
+// is a new directory holding report.txt, with the panic, the Go stack, the
+// CPU registers, the soft switches that are on, and the last instructions
+// executed if the tracer keeps them; memory.bin, with the 64K of main RAM
+// followed by the 64K of aux RAM, if present, each holding language card
+// bank 1 at $C000..$CFFF; and machine.state, a snapshot of the machine, if
+// one can be taken.
+type crashGuard struct {
+	apple2 *apple2
+	dir    string // directory in which reports are created
+}
+
+// Recover writes a crash report if the goroutine is panicking, and then
+// continues panicking. It must be deferred by the function that runs the
+// machine.
+func (g crashGuard) Recover() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	stack := debug.Stack()
+	if dir, err := g.write(v, stack); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: writing the crash report failed: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "The emulator crashed. Please include %s in a bug report.\n", dir)
+	}
+	panic(v)
+}
+
+// write writes a crash report for a panic and returns its directory.
+func (g crashGuard) write(v any, stack []byte) (string, error) {
+	dir := filepath.Join(g.dir, "apple2go-crash-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	a := g.apple2
+
+	var report bytes.Buffer
+	fmt.Fprintf(&report, "panic: %v\n\nmodel: %s\n\n", v, a.cfg.desc)
+	crashSection(&report, "registers", func() {
+		fmt.Fprintln(&report, newDebugger(a).Registers())
+	})
+	crashSection(&report, "soft switches", func() {
+		for sw := ioSwitch(0); sw < ioSwitchINVALID; sw++ {
+			if a.iou.switches&(1<<sw) != 0 {
+				fmt.Fprintf(&report, "%s ", sw)
+			}
+		}
+		fmt.Fprintln(&report)
+	})
+	if a.trace != nil {
+		crashSection(&report, "last instructions", func() {
+			a.trace.Dump(&report, 0)
+		})
+	}
+	fmt.Fprintf(&report, "\n%s", stack)
+	if err := os.WriteFile(filepath.Join(dir, "report.txt"), report.Bytes(), 0644); err != nil {
+		return dir, err
+	}
+
+	mem := append([]byte(nil), a.mmu.mainRAM...)
+	if len(a.mmu.auxBanks) > 0 {
+		mem = append(mem, a.mmu.auxBanks[0]...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "memory.bin"), mem, 0644); err != nil {
+		return dir, err
+	}
+
+	// The machine may be too broken to take a snapshot of.
+	func() {
+		defer func() { recover() }()
+		var state bytes.Buffer
+		if a.SaveState(&state) == nil {
+			os.WriteFile(filepath.Join(dir, "machine.state"), state.Bytes(), 0644)
+		}
+	}()
+	return dir, nil
+}
+
+// crashSection writes a heading and then runs fn to write a section of a
+// crash report. If fn panics, the panic is noted in the report instead.
+func crashSection(report *bytes.Buffer, heading string, fn func()) {
+	fmt.Fprintf(report, "%s:\n", heading)
+	defer func() {
+		if v := recover(); v != nil {
+			fmt.Fprintf(report, "(failed: %v)\n", v)
+		}
+		fmt.Fprintln(report)
+	}()
+	fn()
+}
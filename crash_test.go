@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrashGuard(t *testing.T) {
+	a := newApple2()
+	a.EnableTrace().SetRingSize(16)
+	a.mmu.StoreBytes(0x300, benchmarkProgram)
+	a.cpu.SetPC(0x300)
+	a.RunFrame()
+
+	dir := t.TempDir()
+	var repanic any
+	func() {
+		defer func() { repanic = recover() }()
+		defer crashGuard{apple2: a, dir: dir}.Recover()
+		panic("device exploded")
+	}()
+	if repanic != "device exploded" {
+		t.Errorf("Expected the panic to continue, got %v\n", repanic)
+	}
+
+	reports, _ := filepath.Glob(filepath.Join(dir, "apple2go-crash-*"))
+	if len(reports) != 1 {
+		t.Fatalf("Expected one crash report, got %v\n", reports)
+	}
+	report, err := os.ReadFile(filepath.Join(reports[0], "report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"panic: device exploded", "registers:\nA=", "soft switches:\n", "last instructions:\n", "TestCrashGuard"} {
+		if !strings.Contains(string(report), s) {
+			t.Errorf("Expected the report to contain %q\n", s)
+		}
+	}
+	if mem, err := os.ReadFile(filepath.Join(reports[0], "memory.bin")); err != nil || len(mem) != 0x20000 || mem[0x300] != benchmarkProgram[0] {
+		t.Errorf("Unexpected memory dump of %d bytes: %v\n", len(mem), err)
+	}
+	if _, err := os.Stat(filepath.Join(reports[0], "machine.state")); err != nil {
+		t.Errorf("Expected a snapshot: %v\n", err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// crtOptions control the CRT-style post-processing applied to the display
+// framebuffer. Each effect is disabled when its level is zero.
+type crtOptions struct {
+	scanlines float64 // darkness of the gaps between scanlines (0..1)
+	bloom     float64 // strength of light bleeding from bright pixels (0..1)
+	barrel    float64 // amount of barrel distortion (0..1)
+	aspect    bool    // true = correct output to the 4:3 aspect ratio of a monitor
+}
+
+func defaultCRTOptions() crtOptions {
+	return crtOptions{aspect: true}
+}
+
+// clamp returns a copy of the options with all levels limited to their
+// valid ranges.
+func (o crtOptions) clamp() crtOptions {
+	o.scanlines = clampLevel(o.scanlines)
+	o.bloom = clampLevel(o.bloom)
+	o.barrel = clampLevel(o.barrel)
+	return o
+}
+
+// outputSize returns the dimensions of the post-processed image. Each
+// scanline is always drawn at least twice so that scanline gaps can be
+// rendered.
+func (o crtOptions) outputSize() (w, h int) {
+	w, h = displayWidth, displayHeight*2
+	if o.aspect {
+		h = w * 3 / 4
+	}
+	return w, h
+}
+
+// A crtFilter applies CRT post-processing to a framebuffer. It retains its
+// working buffers between frames to avoid per-frame allocations.
+type crtFilter struct {
+	opts   crtOptions
+	scaled *image.RGBA // framebuffer scaled to output size with scanlines and bloom
+	warped *image.RGBA // scaled image after barrel distortion
+	blur   []float64   // blurred RGB values of the row being bloomed
+}
+
+// Apply post-processes the source framebuffer and returns the resulting
+// image.
+func (f *crtFilter) Apply(src *image.RGBA) *image.RGBA {
+	w, h := f.opts.outputSize()
+	f.scaled = reuseRGBA(f.scaled, w, h)
+	f.scale(f.scaled, src)
+
+	if f.opts.bloom > 0 {
+		f.applyBloom(f.scaled)
+	}
+
+	if f.opts.barrel == 0 {
+		return f.scaled
+	}
+
+	f.warped = reuseRGBA(f.warped, w, h)
+	f.applyBarrel(f.warped, f.scaled)
+	return f.warped
+}
+
+// scale stretches the source vertically to the size of dst, darkening the
+// lower half of each source scanline to produce scanline gaps.
+func (f *crtFilter) scale(dst, src *image.RGBA) {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	gap := 1 - f.opts.scanlines
+
+	for y := 0; y < dh; y++ {
+		sy := y * sh / dh
+		frac := float64(y*sh-sy*dh) / float64(dh)
+		scale := 1.0
+		if frac >= 0.5 {
+			scale = gap
+		}
+
+		srow := src.Pix[sy*src.Stride:]
+		drow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			si := (x * sw / dw) * 4
+			di := x * 4
+			drow[di+0] = byte(float64(srow[si+0]) * scale)
+			drow[di+1] = byte(float64(srow[si+1]) * scale)
+			drow[di+2] = byte(float64(srow[si+2]) * scale)
+			drow[di+3] = 0xff
+		}
+	}
+}
+
+// applyBloom adds a horizontally blurred copy of each row back into the
+// image, causing bright pixels to bleed into their neighbors.
+func (f *crtFilter) applyBloom(img *image.RGBA) {
+	const radius = 2
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if len(f.blur) != w*3 {
+		f.blur = make([]float64, w*3)
+	}
+	blur := f.blur
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			var r, g, b float64
+			for dx := -radius; dx <= radius; dx++ {
+				sx := x + dx
+				if sx < 0 || sx >= w {
+					continue
+				}
+				r += float64(row[sx*4+0])
+				g += float64(row[sx*4+1])
+				b += float64(row[sx*4+2])
+			}
+			n := float64(2*radius + 1)
+			blur[x*3+0], blur[x*3+1], blur[x*3+2] = r/n, g/n, b/n
+		}
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				v := float64(row[x*4+c]) + blur[x*3+c]*f.opts.bloom
+				row[x*4+c] = byte(math.Min(v, 255))
+			}
+		}
+	}
+}
+
+// applyBarrel remaps src into dst using a radial distortion that mimics
+// the curvature of a CRT tube. Pixels that fall outside the source image
+// are drawn black.
+func (f *crtFilter) applyBarrel(dst, src *image.RGBA) {
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	k := f.opts.barrel * 0.25
+
+	for y := 0; y < h; y++ {
+		ny := 2*float64(y)/float64(h-1) - 1
+		drow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			nx := 2*float64(x)/float64(w-1) - 1
+			d := 1 + k*(nx*nx+ny*ny)
+			sx := int(math.Round((nx*d + 1) * float64(w-1) / 2))
+			sy := int(math.Round((ny*d + 1) * float64(h-1) / 2))
+
+			di := x * 4
+			if sx < 0 || sx >= w || sy < 0 || sy >= h {
+				drow[di+0], drow[di+1], drow[di+2], drow[di+3] = 0, 0, 0, 0xff
+				continue
+			}
+			si := sy*src.Stride + sx*4
+			copy(drow[di:di+4], src.Pix[si:si+4])
+		}
+	}
+}
+
+// reuseRGBA returns img if it has the requested dimensions, or a newly
+// allocated image otherwise.
+func reuseRGBA(img *image.RGBA, w, h int) *image.RGBA {
+	if img != nil && img.Rect.Dx() == w && img.Rect.Dy() == h {
+		return img
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+func clampLevel(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
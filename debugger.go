@@ -0,0 +1,1224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const opcodeJSR = 0x20
+
+var (
+	errDebugSyntax = errors.New("syntax error; type ? for help")
+	errTraceRing   = errors.New("no trace buffer; use tb to create one")
+	errHeatmapOff  = errors.New("heatmap is off; use hm on to start it")
+	errCoverageOff = errors.New("coverage is off; use cov on to start it")
+	errProfileOff  = errors.New("profiler is off; use pf on to start it")
+)
+
+// A debugStop is a temporary breakpoint used to step over a subroutine
+// call or run to an address.
+type debugStop struct {
+	addr    uint16
+	sp      byte
+	checkSP bool // true = stop only when the stack pointer equals sp
+}
+
+// A debugger controls the execution of the machine's CPU. While the
+// machine is stopped, RunFrame runs the rest of the machine but no CPU
+// instructions. Commands are executed with Exec, which must be called
+// from the goroutine running the machine; Console runs an interactive
+// session on a terminal.
+type debugger struct {
+	apple2 *apple2
+
+	breakpoints map[uint16]bool
+	stopped     bool
+	reason      string     // why the machine last stopped, until reported
+	temp        *debugStop // temporary breakpoint, if any
+	resume      bool       // true = skip breakpoint checks for one instruction
+	lastCmd     string     // command repeated by an empty line
+	symbols     *symbolTable
+	stopTrace   func() error // stops the trace started by the t command
+	search      *memSearch   // memory search started by the cs command
+}
+
+func newDebugger(apple2 *apple2) *debugger {
+	return &debugger{
+		apple2:      apple2,
+		breakpoints: make(map[uint16]bool),
+		stopped:     true,
+		reason:      "stopped",
+		symbols:     newSymbolTable(),
+	}
+}
+
+// AttachDebugger attaches a debugger to the machine, stopping the CPU.
+func (a *apple2) AttachDebugger() *debugger {
+	if a.dbg == nil {
+		a.dbg = newDebugger(a)
+	}
+	return a.dbg
+}
+
+// DetachDebugger removes the debugger, letting the CPU run freely.
+func (a *apple2) DetachDebugger() {
+	a.dbg = nil
+}
+
+// Stopped returns true if the CPU is stopped.
+func (d *debugger) Stopped() bool {
+	return d.stopped
+}
+
+// Stop stops the CPU at the next instruction boundary.
+func (d *debugger) Stop() {
+	d.stop("stopped")
+}
+
+// Continue resumes execution until a breakpoint is reached.
+func (d *debugger) Continue() {
+	d.temp = nil
+	d.run()
+}
+
+// RunTo resumes execution until the CPU reaches an address or a
+// breakpoint.
+func (d *debugger) RunTo(addr uint16) {
+	d.temp = &debugStop{addr: addr}
+	d.run()
+}
+
+// Step executes a single instruction.
+func (d *debugger) Step() {
+	d.apple2.step()
+	d.stop("")
+}
+
+// StepOver executes a single instruction, running a called subroutine to
+// completion.
+func (d *debugger) StepOver() {
+	cpu := d.apple2.cpu
+	if d.peek(cpu.Reg.PC) != opcodeJSR {
+		d.Step()
+		return
+	}
+	d.temp = &debugStop{addr: cpu.Reg.PC + 3, sp: cpu.Reg.SP, checkSP: true}
+	d.run()
+}
+
+// SetBreakpoint sets or clears a breakpoint.
+func (d *debugger) SetBreakpoint(addr uint16, set bool) {
+	if set {
+		d.breakpoints[addr] = true
+	} else {
+		delete(d.breakpoints, addr)
+	}
+}
+
+func (d *debugger) run() {
+	d.stopped = false
+	d.resume = true
+	d.reason = ""
+}
+
+func (d *debugger) stop(reason string) {
+	d.stopped = true
+	d.temp = nil
+	d.reason = reason
+}
+
+// check is called before each instruction the machine executes, and
+// returns true if the CPU must stop.
+func (d *debugger) check() bool {
+	if d.stopped {
+		return true
+	}
+	if d.resume {
+		d.resume = false
+		return false
+	}
+
+	cpu := d.apple2.cpu
+	pc := cpu.Reg.PC
+	if wl := d.apple2.mmu.watch; wl != nil {
+		if wl.hit != nil || wl.check(pc, watchExec, 0) {
+			d.stop("watchpoint: " + wl.hit.String())
+			d.breakEvent()
+			wl.hit = nil
+			return true
+		}
+	}
+	if t := d.temp; t != nil && pc == t.addr && (!t.checkSP || cpu.Reg.SP == t.sp) {
+		d.stop("")
+		return true
+	}
+	if d.breakpoints[pc] {
+		d.stop(fmt.Sprintf("breakpoint at %04X", pc))
+		d.breakEvent()
+		return true
+	}
+	return false
+}
+
+// breakEvent tells the event observers that the debugger stopped at a
+// breakpoint or watchpoint.
+func (d *debugger) breakEvent() {
+	if ev := d.apple2.ev; ev.active() {
+		ev.send(machineEvent{kind: eventBreakpoint, reason: d.reason})
+	}
+}
+
+// peek reads memory without triggering soft switches or watchpoints. It
+// returns 0 for addresses in the I/O page.
+func (d *debugger) peek(addr uint16) byte {
+	return d.apple2.mmu.Peek(addr)
+}
+
+// poke writes memory without triggering watchpoints or counting the
+// access in the heatmap.
+func (d *debugger) poke(addr uint16, v byte) {
+	m := d.apple2.mmu
+	w, h := m.watch, m.heat
+	m.watch, m.heat = nil, nil
+	m.StoreByte(addr, v)
+	m.watch, m.heat = w, h
+}
+
+// Registers returns a description of the CPU registers and the next
+// instruction.
+func (d *debugger) Registers() string {
+	cpu := d.apple2.cpu
+	r := &cpu.Reg
+
+	const flagNames = "NV-BDIZC"
+	ps := r.SavePS(false)
+	flags := []byte(strings.ToLower(flagNames))
+	for i := range flags {
+		if ps&(0x80>>uint(i)) != 0 {
+			flags[i] = flagNames[i]
+		}
+	}
+
+	line, _ := d.disassemble(r.PC)
+	return fmt.Sprintf("A=%02X X=%02X Y=%02X SP=%02X P=%02X %s CYC=%d\n%s", r.A, r.X, r.Y, r.SP, ps, flags, cpu.Cycles, line)
+}
+
+var debugHelp = `s [n]          step n instructions
+n              step over a subroutine call
+g [addr]       continue, or run to an address
+b addr         set a breakpoint
+bc addr|*      clear one or all breakpoints
+bl             list breakpoints
+r              display registers
+r reg=value    set A, X, Y, SP, PC or P
+m addr [n]     examine n bytes of memory
+e addr b...    modify memory
+               an addr of the form space[.bank]:addr accesses physical
+               memory (main, lc1, aux, auxlc1 or rom) whether mapped or not
+ms range file [hex]
+               save memory to a binary or hex dump file
+ml addr file [hex]
+               load a binary or hex dump file into memory
+l [addr] [n]   disassemble n instructions, by default around the PC
+sl file        load symbols from a file or assembler listing
+sy name|addr   look up a symbol
+t file|off     start or stop writing an instruction trace to a file
+tf range|*     trace only addresses in a range, or clear the ranges
+tb n           keep the last n traced instructions
+tr [n]         show the last n traced instructions
+w addr[-end] [rwx] [log]
+               watch reads, writes or execution of addresses; with log,
+               log accesses instead of stopping
+wc addr|*      clear the watchpoints on an address, or all watchpoints
+wl             list watchpoints
+ch [addr=value[,once][,if=value]]
+               list the cheats, or add one that freezes a byte of memory,
+               or patches it once, optionally waiting for it to hold a value
+chc n|*        remove cheat n, or all cheats
+cs start [space[.bank]]
+               start searching the CPU's address space or a physical memory
+               for a variable, such as a count of lives
+cs value|changed|unchanged|inc|dec
+               keep the candidates that hold a value, or that changed,
+               didn't change, increased or decreased since the last cs
+cs list        list the candidates
+hm [on|off|clear|unused]
+               show a map of the memory pages read, written or executed;
+               start, stop or clear counting, or list unused pages
+hm save file [bytes]
+               save access counts per page, or per byte, to a CSV file,
+               or an image of them to a PNG file
+cov [on|off|clear|ranges]
+               show the bytes of code executed in each memory and bank;
+               start, stop or clear recording, or list the code's ranges
+cov save file  save the ranges of code executed, or with a .cdl extension,
+               a code/data log for each memory and bank
+pf [on|off|clear|n]
+               show the n (default 20) routines, named by symbols, the CPU
+               spent the most cycles in; start, stop or clear profiling
+pf ranges r[,r...]
+               show the cycles spent in address ranges, such as 0300-03FF
+pf save file   save the cycles spent in every routine to a file
+dg [rom|smc|all|off] [n]
+               report writes to ROM or to code executed within the last n
+               cycles (default one frame), or stop reporting
+ss file [scale] [mono]
+               save a screenshot to a PNG file
+sp [speed|auto]
+               show or set the speed (1x, 2x, 4x or warp), or toggle
+               automatic warp during disk access
+disk [n file [ro|cow]]
+               list the disks in the drives, or insert a disk into drive n,
+               discarding changes to it or saving them to an overlay file
+protect n on|off
+               cover or uncover the write-protect notch of the disk in drive n
+eject n        eject the disk in drive n
+swap           swap the disks in drives 1 and 2
+card [n on|off]
+               list the cards in the slots, or pull out or put back the
+               card in slot n
+reset [cold|power]
+               reset the machine, force a cold start, or power-cycle it
+perf           show the performance counters
+log [levels]   show or set the levels of logged messages, given as a level
+               for all components or as settings such as disk=debug,iou=trace
+q              quit the console`
+
+// Exec executes a debugger command and writes its output to w. An empty
+// command repeats the last step command.
+func (d *debugger) Exec(line string, w io.Writer) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		if d.lastCmd == "" {
+			return nil
+		}
+		fields = strings.Fields(d.lastCmd)
+	}
+	cmd, args := strings.ToLower(fields[0]), fields[1:]
+	d.lastCmd = ""
+
+	switch cmd {
+	case "?", "h", "help":
+		fmt.Fprintln(w, debugHelp)
+
+	case "s", "step":
+		n := 1
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v < 1 {
+				return errDebugSyntax
+			}
+			n = v
+		}
+		for i := 0; i < n; i++ {
+			d.Step()
+		}
+		d.lastCmd = "s"
+		fmt.Fprintln(w, d.Registers())
+
+	case "n", "next":
+		d.StepOver()
+		d.lastCmd = "n"
+		if d.stopped {
+			fmt.Fprintln(w, d.Registers())
+		}
+
+	case "g", "go":
+		if len(args) == 0 {
+			d.Continue()
+			break
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.RunTo(uint16(addr))
+
+	case "b", "bp":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.SetBreakpoint(uint16(addr), true)
+
+	case "bc":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		if args[0] == "*" {
+			d.breakpoints = make(map[uint16]bool)
+			break
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.SetBreakpoint(uint16(addr), false)
+
+	case "bl":
+		addrs := make([]int, 0, len(d.breakpoints))
+		for addr := range d.breakpoints {
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			fmt.Fprintf(w, "%04X\n", addr)
+		}
+
+	case "r", "regs":
+		for _, arg := range args {
+			if err := d.setRegister(arg); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(w, d.Registers())
+
+	case "m", "mem":
+		if len(args) < 1 || len(args) > 2 {
+			return errDebugSyntax
+		}
+		n := 0x40
+		if len(args) > 1 {
+			var err error
+			if n, err = parseDebugValue(args[1], 0x10000); err != nil {
+				return err
+			}
+		}
+		if strings.Contains(args[0], ":") {
+			p, err := parsePhysAddr(args[0])
+			if err != nil {
+				return err
+			}
+			d.dump(w, p.addr, n, func(addr uint16) (byte, bool) {
+				p.addr = addr
+				v, err := d.apple2.mmu.PeekPhys(p)
+				return v, err == nil
+			})
+			break
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.dump(w, uint16(addr), n, func(addr uint16) (byte, bool) {
+			if addr >= 0xc000 && addr < 0xc100 {
+				return 0, false
+			}
+			return d.peek(addr), true
+		})
+
+	case "e", "enter":
+		if len(args) < 2 {
+			return errDebugSyntax
+		}
+		values := make([]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			v, err := parseDebugValue(arg, 0xff)
+			if err != nil {
+				return err
+			}
+			values[i] = byte(v)
+		}
+		if strings.Contains(args[0], ":") {
+			p, err := parsePhysAddr(args[0])
+			if err != nil {
+				return err
+			}
+			for _, v := range values {
+				if err := d.apple2.mmu.PokePhys(p, v); err != nil {
+					return err
+				}
+				p.addr++
+			}
+			break
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			d.poke(uint16(addr+i), v)
+		}
+
+	case "ms":
+		if len(args) < 2 || len(args) > 3 {
+			return errDebugSyntax
+		}
+		v, r, err := parseMemAddr(args[0])
+		if err != nil {
+			return err
+		}
+		start, end, err := d.parseRange(r)
+		if err != nil {
+			return err
+		}
+		hexDump, err := parseHexOption(args[2:])
+		if err != nil {
+			return err
+		}
+		n := int(end) - int(start) + 1
+		if err := d.apple2.SaveMemoryFile(args[1], v, start, n, hexDump); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d bytes saved\n", n)
+
+	case "ml":
+		if len(args) < 2 || len(args) > 3 {
+			return errDebugSyntax
+		}
+		v, s, err := parseMemAddr(args[0])
+		if err != nil {
+			return err
+		}
+		addr, err := d.parseAddress(s)
+		if err != nil {
+			return err
+		}
+		hexDump, err := parseHexOption(args[2:])
+		if err != nil {
+			return err
+		}
+		n, err := d.apple2.LoadMemoryFile(args[1], v, uint16(addr), hexDump)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d bytes loaded\n", n)
+
+	case "w", "watch":
+		if len(args) < 1 || len(args) > 3 {
+			return errDebugSyntax
+		}
+		wp, err := d.parseWatchpoint(args)
+		if err != nil {
+			return err
+		}
+		wl := d.apple2.mmu.Watchpoints()
+		wl.SetLog(w)
+		wl.Add(wp)
+
+	case "wc":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		wl := d.apple2.mmu.Watchpoints()
+		if args[0] == "*" {
+			wl.Clear()
+			break
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		wl.Remove(uint16(addr))
+
+	case "wl":
+		for _, wp := range d.apple2.mmu.Watchpoints().points {
+			fmt.Fprintln(w, wp.String())
+		}
+
+	case "l", "list":
+		if len(args) > 2 {
+			return errDebugSyntax
+		}
+		n := 16
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v < 1 {
+				return errDebugSyntax
+			}
+			n = v
+		}
+		var addr uint16
+		if len(args) > 0 {
+			v, err := d.parseAddress(args[0])
+			if err != nil {
+				return err
+			}
+			addr = uint16(v)
+		} else {
+			addr = d.disassemblyStart(d.apple2.cpu.Reg.PC, n/2)
+		}
+		next := d.list(w, addr, n)
+		d.lastCmd = fmt.Sprintf("l %04X %d", next, n)
+
+	case "sl":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		n, err := d.symbols.LoadFile(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d symbols loaded\n", n)
+
+	case "sy":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		if addr, ok := d.symbols.Addr(args[0]); ok {
+			name, _ := d.symbols.Name(addr)
+			fmt.Fprintf(w, "%04X %s\n", addr, name)
+			break
+		}
+		addr, err := parseDebugValue(args[0], 0xffff)
+		if err != nil {
+			return fmt.Errorf("unknown symbol %q", args[0])
+		}
+		name, ok := d.symbols.Name(uint16(addr))
+		if !ok {
+			return fmt.Errorf("no symbol at %04X", addr)
+		}
+		fmt.Fprintf(w, "%04X %s\n", addr, name)
+
+	case "t", "trace":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		if d.stopTrace != nil {
+			d.stopTrace()
+			d.stopTrace = nil
+		}
+		if args[0] == "off" {
+			break
+		}
+		stop, err := d.apple2.TraceToFile(args[0])
+		if err != nil {
+			return err
+		}
+		d.stopTrace = stop
+
+	case "tf":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		t := d.apple2.EnableTrace()
+		if args[0] == "*" {
+			t.ClearRanges()
+			break
+		}
+		start, end, err := d.parseRange(args[0])
+		if err != nil {
+			return err
+		}
+		t.AddRange(start, end)
+
+	case "tb":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return errDebugSyntax
+		}
+		d.apple2.EnableTrace().SetRingSize(n)
+
+	case "tr":
+		n := 0
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v < 1 {
+				return errDebugSyntax
+			}
+			n = v
+		}
+		t := d.apple2.trace
+		if t == nil || len(t.ring) == 0 {
+			return errTraceRing
+		}
+		t.Dump(w, n)
+
+	case "ch":
+		a := d.apple2
+		switch len(args) {
+		case 0:
+			for i, c := range a.cheats {
+				fmt.Fprintf(w, "%d: %s\n", i+1, c)
+			}
+		case 1:
+			c, err := parseCheat(args[0])
+			if err != nil {
+				return err
+			}
+			a.AddCheat(c)
+		default:
+			return errDebugSyntax
+		}
+
+	case "chc":
+		a := d.apple2
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		if args[0] == "*" {
+			a.cheats = nil
+			break
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(a.cheats) {
+			return errDebugSyntax
+		}
+		a.RemoveCheat(n - 1)
+
+	case "cs":
+		if len(args) == 0 {
+			return errDebugSyntax
+		}
+		if args[0] == "start" {
+			var v memView
+			switch len(args) {
+			case 1:
+			case 2:
+				var err error
+				if v, _, err = parseMemAddr(args[1] + ":"); err != nil {
+					return err
+				}
+			default:
+				return errDebugSyntax
+			}
+			d.search = newMemSearch(d.apple2, v)
+			fmt.Fprintf(w, "%d candidates\n", len(d.search.addrs))
+			break
+		}
+		if d.search == nil {
+			return errSearchOff
+		}
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		if args[0] == "list" {
+			d.search.List(w, 50)
+			break
+		}
+		keep := memSearchFilters[args[0]]
+		if keep == nil {
+			v, err := parseDebugValue(args[0], 0xff)
+			if err != nil {
+				return err
+			}
+			keep = func(old, cur byte) bool { return cur == byte(v) }
+		}
+		fmt.Fprintf(w, "%d candidates\n", d.search.Filter(d.apple2, keep))
+
+	case "hm":
+		m := d.apple2.mmu
+		if len(args) > 0 {
+			switch args[0] {
+			case "on":
+				m.EnableHeatmap()
+				return nil
+			case "off":
+				m.DisableHeatmap()
+				return nil
+			}
+		}
+		h := m.Heatmap()
+		if h == nil {
+			return errHeatmapOff
+		}
+		switch {
+		case len(args) == 0:
+			h.WriteMap(w)
+		case args[0] == "clear" && len(args) == 1:
+			h.Clear()
+		case args[0] == "unused" && len(args) == 1:
+			for _, r := range h.UnusedRanges() {
+				fmt.Fprintf(w, "%04X-%04X\n", r[0], r[1])
+			}
+		case args[0] == "save" && len(args) == 2:
+			return h.Save(args[1], false)
+		case args[0] == "save" && len(args) == 3 && args[2] == "bytes":
+			return h.Save(args[1], true)
+		default:
+			return errDebugSyntax
+		}
+
+	case "cov":
+		a := d.apple2
+		if len(args) > 0 {
+			switch args[0] {
+			case "on":
+				a.EnableCoverage()
+				return nil
+			case "off":
+				a.DisableCoverage()
+				return nil
+			}
+		}
+		c := a.cover
+		if c == nil {
+			return errCoverageOff
+		}
+		switch {
+		case len(args) == 0:
+			c.WriteSummary(w)
+		case args[0] == "clear" && len(args) == 1:
+			c.Clear()
+		case args[0] == "ranges" && len(args) == 1:
+			return c.WriteRanges(w)
+		case args[0] == "save" && len(args) == 2:
+			return c.Save(args[1])
+		default:
+			return errDebugSyntax
+		}
+
+	case "pf":
+		a := d.apple2
+		if len(args) > 0 {
+			switch args[0] {
+			case "on":
+				a.EnableProfile()
+				return nil
+			case "off":
+				a.DisableProfile()
+				return nil
+			}
+		}
+		p := a.prof
+		if p == nil {
+			return errProfileOff
+		}
+		switch {
+		case len(args) == 0:
+			p.Write(w, p.Routines(d.symbols), 20)
+		case args[0] == "clear" && len(args) == 1:
+			p.Clear()
+		case args[0] == "ranges" && len(args) == 2:
+			ranges, err := parseTraceRanges(args[1])
+			if err != nil {
+				return err
+			}
+			p.Write(w, p.Ranges(ranges), 0)
+		case args[0] == "save" && len(args) == 2:
+			return p.Save(args[1], d.symbols)
+		case len(args) == 1:
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return errDebugSyntax
+			}
+			p.Write(w, p.Routines(d.symbols), n)
+		default:
+			return errDebugSyntax
+		}
+
+	case "dg":
+		m := d.apple2.mmu
+		switch {
+		case len(args) == 0:
+			if m.diag == nil {
+				fmt.Fprintln(w, "diagnostics off")
+			} else {
+				fmt.Fprintf(w, "diagnosing %s, window %d cycles\n", m.diag.kinds, m.diag.window)
+			}
+		case len(args) == 1 && args[0] == "off":
+			m.StopDiagnostics()
+		case len(args) <= 2:
+			kinds, ok := parseDiagKind(args[0])
+			if !ok {
+				return errDebugSyntax
+			}
+			window := 0
+			if len(args) > 1 {
+				v, err := strconv.Atoi(args[1])
+				if err != nil || v < 1 {
+					return errDebugSyntax
+				}
+				window = v
+			}
+			m.StartDiagnostics(w, kinds, uint64(window))
+		default:
+			return errDebugSyntax
+		}
+
+	case "ss":
+		if len(args) < 1 || len(args) > 3 {
+			return errDebugSyntax
+		}
+		opts, err := parseScreenshotOptions(args[1:])
+		if err != nil {
+			return err
+		}
+		return d.apple2.SaveScreenshot(args[0], opts)
+
+	case "sp":
+		sc := d.apple2.speed
+		switch {
+		case len(args) > 1:
+			return errDebugSyntax
+		case len(args) == 1 && args[0] == "auto":
+			sc.SetAutoWarp(!sc.AutoWarp())
+		case len(args) == 1:
+			m, ok := parseSpeedMode(args[0])
+			if !ok {
+				return errDebugSyntax
+			}
+			sc.SetSpeed(m)
+		}
+		auto := "off"
+		if sc.AutoWarp() {
+			auto = "on"
+		}
+		fmt.Fprintf(w, "speed %v, automatic warp %s\n", sc.Speed(), auto)
+
+	case "disk":
+		switch len(args) {
+		case 0:
+		case 2, 3:
+			drive, err := strconv.Atoi(args[0])
+			if err != nil {
+				return errDebugSyntax
+			}
+			mode := diskModeWrite
+			if len(args) > 2 {
+				if mode, err = parseDiskMode(args[2]); err != nil {
+					return err
+				}
+			}
+			if err := d.apple2.InsertDiskMode(drive, args[1], mode); err != nil {
+				return err
+			}
+		default:
+			return errDebugSyntax
+		}
+		return d.listDisks(w)
+
+	case "protect":
+		if len(args) != 2 {
+			return errDebugSyntax
+		}
+		drive, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errDebugSyntax
+		}
+		switch strings.ToLower(args[1]) {
+		case "on":
+			err = d.apple2.SetWriteProtect(drive, true)
+		case "off":
+			err = d.apple2.SetWriteProtect(drive, false)
+		default:
+			return errDebugSyntax
+		}
+		if err != nil {
+			return err
+		}
+		return d.listDisks(w)
+
+	case "eject":
+		if len(args) != 1 {
+			return errDebugSyntax
+		}
+		drive, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errDebugSyntax
+		}
+		if err := d.apple2.EjectDisk(drive); err != nil {
+			return err
+		}
+		return d.listDisks(w)
+
+	case "swap":
+		if len(args) != 0 {
+			return errDebugSyntax
+		}
+		if err := d.apple2.SwapDisks(); err != nil {
+			return err
+		}
+		return d.listDisks(w)
+
+	case "card":
+		switch len(args) {
+		case 0:
+		case 2:
+			slot, err := strconv.Atoi(args[0])
+			if err != nil {
+				return errDebugSyntax
+			}
+			switch strings.ToLower(args[1]) {
+			case "on":
+				err = d.apple2.EnableCard(slot)
+			case "off":
+				err = d.apple2.DisableCard(slot)
+			default:
+				return errDebugSyntax
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			return errDebugSyntax
+		}
+		d.listCards(w)
+
+	case "perf":
+		if len(args) != 0 {
+			return errDebugSyntax
+		}
+		fmt.Fprintln(w, d.apple2.Metrics())
+
+	case "log":
+		if len(args) > 1 {
+			return errDebugSyntax
+		}
+		if len(args) == 1 {
+			if err := d.apple2.log.Configure(args[0]); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(w, d.apple2.log)
+
+	case "reset":
+		switch {
+		case len(args) == 0:
+			d.apple2.Reset()
+		case len(args) == 1 && args[0] == "cold":
+			d.apple2.ColdReset()
+		case len(args) == 1 && args[0] == "power":
+			d.apple2.PowerCycle()
+		default:
+			return errDebugSyntax
+		}
+		fmt.Fprintln(w, d.Registers())
+
+	default:
+		return errDebugSyntax
+	}
+	return nil
+}
+
+// listDisks writes the disks in the drives of the disk controller.
+func (d *debugger) listDisks(w io.Writer) error {
+	dc, err := d.apple2.diskController()
+	if err != nil {
+		return err
+	}
+	for i, dr := range dc.drives {
+		if dr.disk == nil {
+			fmt.Fprintf(w, "drive %d: empty\n", i+1)
+			continue
+		}
+		name := dr.filename
+		if name == "" {
+			name = "(no file)"
+		}
+		var notes []string
+		switch dr.mode {
+		case diskModeReadOnly:
+			notes = append(notes, "read-only")
+		case diskModeOverlay:
+			notes = append(notes, "overlay")
+		}
+		if dr.disk.dirty {
+			notes = append(notes, "modified")
+		}
+		if dr.disk.writeProtected {
+			notes = append(notes, "write-protected")
+		}
+		if s := dc.Status(i); s.motorOn {
+			notes = append(notes, fmt.Sprintf("running on track %d", s.Track()))
+		}
+		if len(notes) > 0 {
+			name += " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Fprintf(w, "drive %d: %s\n", i+1, name)
+	}
+	if len(dc.pending) > 0 {
+		fmt.Fprintf(w, "%d changes waiting for a disk write to finish\n", len(dc.pending))
+	}
+	return nil
+}
+
+// listCards writes the cards in the slots, including pulled-out cards.
+func (d *debugger) listCards(w io.Writer) {
+	sm := d.apple2.sm
+	for slot := 1; slot < numSlots; slot++ {
+		switch {
+		case sm.Card(slot) != nil:
+			fmt.Fprintf(w, "slot %d: %s\n", slot, cardName(sm.Card(slot)))
+		case sm.Disabled(slot) != nil:
+			fmt.Fprintf(w, "slot %d: %s (off)\n", slot, cardName(sm.Disabled(slot)))
+		}
+	}
+}
+
+// parseRange parses an address or an address range of the form
+// start-end.
+func (d *debugger) parseRange(s string) (start, end uint16, err error) {
+	from, to := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	v0, err := d.parseAddress(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	v1, err := d.parseAddress(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if v1 < v0 {
+		return 0, 0, errDebugSyntax
+	}
+	return uint16(v0), uint16(v1), nil
+}
+
+// parseAddress parses an address given as a symbol or a hexadecimal value.
+func (d *debugger) parseAddress(s string) (int, error) {
+	if addr, ok := d.symbols.Addr(s); ok {
+		return int(addr), nil
+	}
+	return parseDebugValue(s, 0xffff)
+}
+
+// parseWatchpoint parses the arguments of a watch command: an address or
+// range, optional access kinds (rw by default), and an optional "log".
+func (d *debugger) parseWatchpoint(args []string) (watchpoint, error) {
+	wp := watchpoint{kind: watchRead | watchWrite}
+	start, end, err := d.parseRange(args[0])
+	if err != nil {
+		return wp, err
+	}
+	wp.start, wp.end = start, end
+
+	for _, arg := range args[1:] {
+		if strings.EqualFold(arg, "log") {
+			wp.log = true
+			continue
+		}
+		k, ok := parseWatchKind(arg)
+		if !ok {
+			return wp, errDebugSyntax
+		}
+		wp.kind = k
+	}
+	return wp, nil
+}
+
+// setRegister handles a "reg=value" register assignment.
+func (d *debugger) setRegister(arg string) error {
+	i := strings.IndexByte(arg, '=')
+	if i < 0 {
+		return errDebugSyntax
+	}
+	name := strings.ToUpper(arg[:i])
+	max := 0xff
+	if name == "PC" {
+		max = 0xffff
+	}
+	v, err := parseDebugValue(arg[i+1:], max)
+	if err != nil {
+		return err
+	}
+
+	r := &d.apple2.cpu.Reg
+	switch name {
+	case "A":
+		r.A = byte(v)
+	case "X":
+		r.X = byte(v)
+	case "Y":
+		r.Y = byte(v)
+	case "SP":
+		r.SP = byte(v)
+	case "PC":
+		r.PC = uint16(v)
+	case "P":
+		r.RestorePS(byte(v))
+	default:
+		return errDebugSyntax
+	}
+	return nil
+}
+
+// dump writes a hex and ASCII dump of n bytes of memory starting at addr.
+// Bytes that peek can't read are shown as dashes.
+func (d *debugger) dump(w io.Writer, addr uint16, n int, peek func(uint16) (byte, bool)) {
+	for row := 0; row < n; row += 16 {
+		start := addr + uint16(row)
+		fmt.Fprintf(w, "%04X-", start)
+		var text [16]byte
+		cols := 16
+		if n-row < cols {
+			cols = n - row
+		}
+		for i := 0; i < cols; i++ {
+			v, ok := peek(start + uint16(i))
+			if !ok {
+				fmt.Fprint(w, " --")
+				text[i] = ' '
+				continue
+			}
+			fmt.Fprintf(w, " %02X", v)
+			c := v & 0x7f
+			if c < 0x20 || c == 0x7f {
+				c = '.'
+			}
+			text[i] = c
+		}
+		fmt.Fprintf(w, "%*s  %s\n", 3*(16-cols), "", text[:cols])
+	}
+}
+
+// parseDebugValue parses a hexadecimal value, optionally prefixed with $,
+// no greater than max.
+func parseDebugValue(s string, max int) (int, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 32)
+	if err != nil || int(v) > max {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return int(v), nil
+}
+
+// Console runs an interactive debugging session, reading commands from r
+// and writing output to w, until the q command is entered or r reaches
+// end of file. The console runs the machine whenever it isn't stopped;
+// entering any line while it runs stops it.
+func (d *debugger) Console(r io.Reader, w io.Writer) {
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+
+	fmt.Fprintln(w, d.Registers())
+	for {
+		if d.stopped {
+			if d.reason != "" {
+				fmt.Fprintf(w, "%s\n%s\n", d.reason, d.Registers())
+				d.reason = ""
+			}
+			fmt.Fprint(w, "> ")
+			line, ok := <-lines
+			if !ok || strings.TrimSpace(line) == "q" {
+				return
+			}
+			if err := d.Exec(line, w); err != nil {
+				fmt.Fprintln(w, err)
+			}
+			continue
+		}
+
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				return
+			}
+			d.Stop()
+		default:
+			d.apple2.RunPaced()
+			if d.stopped && d.reason == "" {
+				// A step over or run to address completed.
+				fmt.Fprintln(w, d.Registers())
+			}
+		}
+	}
+}
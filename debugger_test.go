@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDebugger(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0x20, 0x10, 0x03, // 0300: JSR $0310
+		0xa9, 0x42, //       0303: LDA #$42
+		0x4c, 0x00, 0x03, // 0305: JMP $0300
+	})
+	a.mmu.StoreBytes(0x0310, []byte{
+		0xe8, //             0310: INX
+		0x60, //             0311: RTS
+	})
+	a.cpu.SetPC(0x0300)
+
+	d := a.AttachDebugger()
+	var out bytes.Buffer
+	exec := func(cmd string) string {
+		out.Reset()
+		if err := d.Exec(cmd, &out); err != nil {
+			t.Fatalf("%s: %v\n", cmd, err)
+		}
+		return out.String()
+	}
+
+	// The CPU doesn't run while stopped.
+	a.RunFrame()
+	if a.cpu.Reg.PC != 0x0300 {
+		t.Fatalf("CPU ran while stopped\n")
+	}
+
+	exec("s")
+	if a.cpu.Reg.PC != 0x0310 {
+		t.Errorf("Step: expected PC 0310, got %04X\n", a.cpu.Reg.PC)
+	}
+	exec("s 2")
+	if a.cpu.Reg.PC != 0x0303 || a.cpu.Reg.X != 1 {
+		t.Errorf("Step 2: expected PC 0303 X 01, got %04X %02X\n", a.cpu.Reg.PC, a.cpu.Reg.X)
+	}
+
+	// Step over the JSR.
+	exec("r pc=300")
+	exec("n")
+	a.RunFrame()
+	if !d.Stopped() || a.cpu.Reg.PC != 0x0303 || a.cpu.Reg.X != 2 {
+		t.Errorf("Step over: expected PC 0303 X 02, got %04X %02X\n", a.cpu.Reg.PC, a.cpu.Reg.X)
+	}
+
+	// Continue to a breakpoint, then continue past it to the next hit.
+	exec("b 310")
+	exec("g")
+	a.RunFrame()
+	if !d.Stopped() || a.cpu.Reg.PC != 0x0310 || d.reason != "breakpoint at 0310" {
+		t.Errorf("Breakpoint: expected stop at 0310, got %04X %q\n", a.cpu.Reg.PC, d.reason)
+	}
+	exec("g")
+	a.RunFrame()
+	if a.cpu.Reg.PC != 0x0310 || a.cpu.Reg.X != 3 {
+		t.Errorf("Continue: expected stop at 0310 with X 03, got %04X %02X\n", a.cpu.Reg.PC, a.cpu.Reg.X)
+	}
+
+	exec("bc *")
+	exec("g 305")
+	a.RunFrame()
+	if a.cpu.Reg.PC != 0x0305 || a.cpu.Reg.A != 0x42 {
+		t.Errorf("Run to: expected PC 0305 A 42, got %04X %02X\n", a.cpu.Reg.PC, a.cpu.Reg.A)
+	}
+
+	regs := exec("r a=ff p=81")
+	if !strings.HasPrefix(regs, "A=FF X=04 Y=00 SP=FF P=A1 Nv-bdizC") {
+		t.Errorf("Unexpected register display %q\n", regs)
+	}
+	if !strings.Contains(regs, "0305- 4C 00 03  JMP") {
+		t.Errorf("Unexpected instruction display %q\n", regs)
+	}
+
+	exec("e 2000 c1 c2 00")
+	if m := exec("m 2000 3"); m != "2000- C1 C2 00"+strings.Repeat(" ", 39)+"  AB.\n" {
+		t.Errorf("Unexpected memory dump %q\n", m)
+	}
+	exec("e aux:2000 c1")
+	if m := exec("m aux:2000 1"); m != "2000- C1"+strings.Repeat(" ", 45)+"  A\n" {
+		t.Errorf("Unexpected physical memory dump %q\n", m)
+	}
+	if a.mmu.LoadByte(0x2000) != 0xc1 || a.mmu.auxRAM[0x2000] != 0xc1 {
+		t.Errorf("Expected main and aux memory written separately\n")
+	}
+
+	if err := d.Exec("x", &out); err != errDebugSyntax {
+		t.Errorf("Expected a syntax error, got %v\n", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A diagKind is a bit mask of the kinds of suspicious memory access that
+// code diagnostics report.
+type diagKind byte
+
+const (
+	diagROMWrite diagKind = 1 << iota // writes to ROM, which are ignored
+	diagSMC                           // writes to recently executed code
+)
+
+const diagAll = diagROMWrite | diagSMC
+
+var diagKindNames = []string{"rom", "smc"}
+
+func (k diagKind) String() string {
+	var names []string
+	for i, name := range diagKindNames {
+		if k&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// parseDiagKind converts a comma-separated list of diagnostic names, such
+// as "rom,smc", or "all" into a diagKind.
+func parseDiagKind(s string) (diagKind, bool) {
+	if s == "all" {
+		return diagAll, true
+	}
+	var k diagKind
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for i, n := range diagKindNames {
+			if name == n {
+				k |= 1 << uint(i)
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return k, true
+}
+
+// defaultDiagWindow is the number of cycles, about one video frame, for
+// which executed code counts as recently executed.
+const defaultDiagWindow = 17030
+
+// codeDiagnostics reports memory writes that are likely bugs in the
+// running program: writes to ROM, which the hardware silently ignores,
+// and writes to code that was executed within the last window cycles.
+// Self-modifying code is common in 6502 programs, so only recent
+// execution counts. Each instruction is reported once per address it
+// writes.
+type codeDiagnostics struct {
+	apple2   *apple2
+	w        io.Writer
+	kinds    diagKind
+	window   uint64             // cycles for which code counts as recently executed
+	executed [0x10000]uint64    // 1 + the cycle each address was last executed
+	reported map[[2]uint16]bool // instruction and address pairs already reported
+}
+
+// StartDiagnostics starts reporting the kinds of suspicious writes to w.
+// A window of 0 selects the default.
+func (m *mmu) StartDiagnostics(w io.Writer, kinds diagKind, window uint64) *codeDiagnostics {
+	if window == 0 {
+		window = defaultDiagWindow
+	}
+	m.diag = &codeDiagnostics{
+		apple2:   m.apple2,
+		w:        w,
+		kinds:    kinds,
+		window:   window,
+		reported: make(map[[2]uint16]bool),
+	}
+	m.updatePages()
+	return m.diag
+}
+
+// StopDiagnostics stops reporting suspicious writes.
+func (m *mmu) StopDiagnostics() {
+	if m.diag != nil {
+		m.diag = nil
+		m.updatePages()
+	}
+}
+
+// exec records the execution of the instruction at pc.
+func (dg *codeDiagnostics) exec(pc uint16) {
+	if dg.kinds&diagSMC == 0 {
+		return
+	}
+	c := dg.apple2.cpu
+	n := c.InstSet.Lookup(dg.apple2.mmu.Peek(pc)).Length
+	for i := uint16(0); i < uint16(n); i++ {
+		dg.executed[pc+i] = c.Cycles + 1
+	}
+}
+
+// write checks a write of v to addr. The rom flag is true if ROM is
+// mapped for writing at addr.
+func (dg *codeDiagnostics) write(addr uint16, v byte, rom bool) {
+	c := dg.apple2.cpu
+	switch {
+	case rom && dg.kinds&diagROMWrite != 0:
+		if dg.report(c.LastPC, addr) {
+			fmt.Fprintf(dg.w, "%d: $%04X wrote $%02X to ROM at $%04X\n", c.Cycles, c.LastPC, v, addr)
+		}
+	case dg.kinds&diagSMC != 0:
+		e := dg.executed[addr]
+		if e == 0 || c.Cycles-(e-1) > dg.window {
+			return
+		}
+		if dg.report(c.LastPC, addr) {
+			fmt.Fprintf(dg.w, "%d: $%04X wrote $%02X to code at $%04X executed %d cycles ago\n",
+				c.Cycles, c.LastPC, v, addr, c.Cycles-(e-1))
+		}
+	}
+}
+
+// report returns true the first time an instruction writes an address.
+func (dg *codeDiagnostics) report(pc, addr uint16) bool {
+	key := [2]uint16{pc, addr}
+	if dg.reported[key] {
+		return false
+	}
+	dg.reported[key] = true
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCodeDiagnostics(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0x8d, 0x00, 0xe0, // 0300: STA $E000
+		0x8d, 0x01, 0x03, // 0303: STA $0301
+		0x8d, 0x00, 0x40, // 0306: STA $4000
+		0x4c, 0x00, 0x03, // 0309: JMP $0300
+	})
+	a.cpu.SetPC(0x0300)
+	a.mmu.LoadByte(0xc082) // ROM read, no language card writes
+
+	var out bytes.Buffer
+	d := a.AttachDebugger()
+	if err := d.Exec("dg all", &out); err != nil {
+		t.Fatalf("dg: %v\n", err)
+	}
+	for i := 0; i < 8; i++ {
+		a.step()
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected each write reported once, got %q\n", out.String())
+	}
+	if !strings.HasSuffix(lines[0], "$0300 wrote $00 to ROM at $E000") {
+		t.Errorf("Unexpected ROM write report %q\n", lines[0])
+	}
+	if !strings.Contains(lines[1], "$0303 wrote $00 to code at $0301 executed") {
+		t.Errorf("Unexpected code write report %q\n", lines[1])
+	}
+
+	// Code executed outside the window doesn't count.
+	out.Reset()
+	d.Exec("dg smc 2", &out)
+	a.cpu.SetPC(0x0300)
+	for i := 0; i < 4; i++ {
+		a.step()
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no reports, got %q\n", out.String())
+	}
+
+	d.Exec("dg off", &out)
+	d.Exec("dg", &out)
+	if a.mmu.diag != nil || !strings.HasSuffix(out.String(), "diagnostics off\n") {
+		t.Errorf("Expected diagnostics off\n")
+	}
+	if a.mmu.pages[0x03].wmem == nil {
+		t.Errorf("Expected direct writes restored\n")
+	}
+}
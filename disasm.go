@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// formatInstruction returns a line of disassembly for an instruction at
+// addr whose bytes are b. Operand addresses are shown as symbols where the
+// symbol table, if any, has them.
+func formatInstruction(inst *cpu.Instruction, addr uint16, b [3]byte, syms *symbolTable) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%04X-", addr)
+	for i := 0; i < 3; i++ {
+		if i < int(inst.Length) {
+			fmt.Fprintf(&sb, " %02X", b[i])
+		} else {
+			sb.WriteString("   ")
+		}
+	}
+	fmt.Fprintf(&sb, "  %s", inst.Name)
+
+	op8 := b[1]
+	op16 := uint16(b[1]) | uint16(b[2])<<8
+	switch inst.Mode {
+	case cpu.IMM:
+		fmt.Fprintf(&sb, "   #$%02X", op8)
+	case cpu.ZPG:
+		fmt.Fprintf(&sb, "   %s", syms.Format(uint16(op8), 2))
+	case cpu.ZPX:
+		fmt.Fprintf(&sb, "   %s,X", syms.Format(uint16(op8), 2))
+	case cpu.ZPY:
+		fmt.Fprintf(&sb, "   %s,Y", syms.Format(uint16(op8), 2))
+	case cpu.ABS:
+		fmt.Fprintf(&sb, "   %s", syms.Format(op16, 4))
+	case cpu.ABX:
+		fmt.Fprintf(&sb, "   %s,X", syms.Format(op16, 4))
+	case cpu.ABY:
+		fmt.Fprintf(&sb, "   %s,Y", syms.Format(op16, 4))
+	case cpu.IND:
+		fmt.Fprintf(&sb, "   (%s)", syms.Format(op16, 4))
+	case cpu.IDX:
+		fmt.Fprintf(&sb, "   (%s,X)", syms.Format(uint16(op8), 2))
+	case cpu.IDY:
+		fmt.Fprintf(&sb, "   (%s),Y", syms.Format(uint16(op8), 2))
+	case cpu.REL:
+		target := addr + 2 + uint16(int8(op8))
+		fmt.Fprintf(&sb, "   %s", syms.Format(target, 4))
+	case cpu.ACC:
+		sb.WriteString("   A")
+	}
+	return sb.String()
+}
+
+// disassemble returns a line of disassembly for the instruction at addr,
+// along with the address of the next instruction. A call to the ProDOS MLI
+// is followed by a second line holding the command and the address of its
+// parameter list, which the next instruction follows.
+func (d *debugger) disassemble(addr uint16) (line string, next uint16) {
+	bytes := [3]byte{d.peek(addr), d.peek(addr + 1), d.peek(addr + 2)}
+	inst := d.apple2.cpu.InstSet.Lookup(bytes[0])
+	op16 := uint16(bytes[1]) | uint16(bytes[2])<<8
+
+	var b strings.Builder
+	b.WriteString(formatInstruction(inst, addr, bytes, d.symbols))
+	next = addr + uint16(inst.Length)
+
+	if inst.Opcode == opcodeJSR && op16 == prodosMLI {
+		call := d.peek(next)
+		params := uint16(d.peek(next+1)) | uint16(d.peek(next+2))<<8
+		name, ok := prodosCalls[call]
+		if !ok {
+			name = fmt.Sprintf("$%02X", call)
+		}
+		fmt.Fprintf(&b, "\n%04X- %02X %02X %02X  MLI   %s,%s", next, call, byte(params), byte(params>>8), name, d.symbols.Format(params, 4))
+		next += 3
+	}
+	return b.String(), next
+}
+
+// disassemblyStart returns an address before addr from which disassembly
+// runs into the instruction at addr, so that a listing can show the code
+// leading up to it. Up to n instructions are included.
+func (d *debugger) disassemblyStart(addr uint16, n int) uint16 {
+	for back := uint16(3 * n); back > 0; back-- {
+		start := addr - back
+		a, count := start, 0
+		for a-start < back {
+			_, a = d.disassemble(a)
+			count++
+		}
+		if a == addr && count <= n {
+			return start
+		}
+	}
+	return addr
+}
+
+// list writes n lines of disassembly starting at addr, and returns the
+// address following the last instruction listed. Addresses with symbols
+// are preceded by a label line, and the instruction at the PC is marked.
+func (d *debugger) list(w io.Writer, addr uint16, n int) uint16 {
+	pc := d.apple2.cpu.Reg.PC
+	for i := 0; i < n; i++ {
+		if name, ok := d.symbols.Name(addr); ok {
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+		line, next := d.disassemble(addr)
+		mark := " "
+		if addr == pc {
+			mark = ">"
+		}
+		fmt.Fprintf(w, "%s%s\n", mark, strings.ReplaceAll(line, "\n", "\n "))
+		addr = next
+	}
+	return addr
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDisassembler(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0xa9, 0xc1, //       0300: LDA #$C1
+		0x20, 0xed, 0xfd, // 0302: JSR COUT
+		0x20, 0x00, 0xbf, // 0305: JSR MLI
+		0xc8, 0x20, 0x03, // 0308: OPEN,$0320
+		0xd0, 0xf3, //       030B: BNE $0300
+	})
+	a.cpu.SetPC(0x0305)
+	d := a.AttachDebugger()
+
+	var out bytes.Buffer
+	d.list(&out, 0x0300, 4)
+	expected := ` 0300- A9 C1     LDA   #$C1
+ 0302- 20 ED FD  JSR   COUT
+>0305- 20 00 BF  JSR   MLI
+ 0308- C8 20 03  MLI   OPEN,$0320
+ 030B- D0 F3     BNE   $0300
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected listing:\n%s", out.String())
+	}
+
+	if start := d.disassemblyStart(0x0305, 2); start != 0x0300 {
+		t.Errorf("Expected listing to start at 0300, got %04X\n", start)
+	}
+
+	symbols := `
+* Merlin listing
+     1  =0300            START    EQU   $0300
+     2  0300: A9 C1               LDA   #$C1   ; not a symbol
+        PARMS   =0320    ?UNUSED  =0330
+0310 LOOP
+DONE  031F
+        JMP   $0340
+`
+	n, err := d.symbols.Load(strings.NewReader(symbols))
+	if err != nil || n != 5 {
+		t.Fatalf("Expected 5 symbols, got %d (%v)\n", n, err)
+	}
+	for name, addr := range map[string]uint16{"START": 0x300, "PARMS": 0x320, "UNUSED": 0x330, "LOOP": 0x310, "done": 0x31f} {
+		if got, ok := d.symbols.Addr(name); !ok || got != addr {
+			t.Errorf("Symbol %s: expected %04X, got %04X\n", name, addr, got)
+		}
+	}
+
+	out.Reset()
+	d.list(&out, 0x0300, 1)
+	if out.String() != "START:\n 0300- A9 C1     LDA   #$C1\n" {
+		t.Errorf("Unexpected label %q\n", out.String())
+	}
+
+	// Symbols can be used in place of addresses.
+	if err := d.Exec("b loop", &out); err != nil || !d.breakpoints[0x0310] {
+		t.Errorf("Breakpoint at symbol not set (%v)\n", err)
+	}
+}
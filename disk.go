@@ -0,0 +1,605 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 5.25" floppy disk geometry.
+const (
+	diskTracks      = 35                                // tracks per disk
+	diskSectors     = 16                                // sectors per track
+	diskSectorSize  = 256                               // bytes per sector
+	diskTrackSize   = diskSectors * diskSectorSize      // bytes per track
+	diskImageSize   = diskTracks * diskTrackSize        // bytes in a sector image
+	nibTrackSize    = 6656                              // nibbles per track
+	nibImageSize    = diskTracks * nibTrackSize         // bytes in a .nib image
+	defaultVolume   = 254                               // DOS 3.3 default volume number
+	nibSectorLength = 14 + 6 + 3 + 343 + 3 + 27         // nibbles per encoded sector
+	nibGap1Length   = nibTrackSize - 16*nibSectorLength // sync nibbles before sector 0
+)
+
+// 13-sector disk geometry, as written by DOS 3.1 and 3.2.
+const (
+	diskSectors13     = 13
+	diskTrackSize13   = diskSectors13 * diskSectorSize
+	diskImageSize13   = diskTracks * diskTrackSize13
+	nibSectorLength13 = 14 + 6 + 3 + 411 + 3 + 27
+	nibGap1Length13   = nibTrackSize - diskSectors13*nibSectorLength13
+)
+
+// A diskFormat identifies the layout of a disk image file.
+type diskFormat byte
+
+const (
+	diskFormatDOS    diskFormat = iota // sectors in DOS 3.3 order (.dsk, .do)
+	diskFormatProDOS                   // sectors in ProDOS order (.po)
+	diskFormatNIB                      // raw nibbles (.nib)
+	diskFormatD13                      // 13 sectors per track in physical order (.d13)
+)
+
+var (
+	errDiskFormat = errors.New("unrecognized disk image format")
+	errDiskSize   = errors.New("disk image has the wrong size")
+)
+
+// Physical to logical sector mappings. The entry for each physical sector
+// is the index of the sector within a track of the image file.
+var (
+	dosSectorOrder    = [diskSectors]int{0, 7, 14, 6, 13, 5, 12, 4, 11, 3, 10, 2, 9, 1, 8, 15}
+	prodosSectorOrder = [diskSectors]int{0, 8, 1, 9, 2, 10, 3, 11, 4, 12, 5, 13, 6, 14, 7, 15}
+)
+
+// diskFormatFromName determines a disk image format from a filename
+// extension.
+func diskFormatFromName(filename string) (diskFormat, bool) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".dsk", ".do":
+		return diskFormatDOS, true
+	case ".po":
+		return diskFormatProDOS, true
+	case ".nib":
+		return diskFormatNIB, true
+	case ".d13":
+		return diskFormatD13, true
+	}
+	return 0, false
+}
+
+// A diskImage holds the contents of a 5.25" floppy disk as the stream of
+// nibbles recorded on each track, which is what the Disk II controller
+// reads and writes.
+type diskImage struct {
+	format         diskFormat
+	volume         byte
+	tracks         [diskTracks][]byte
+	quarters       map[int][]byte // tracks recorded between the whole tracks, by quarter track
+	writeProtected bool           // true = the disk's write-protect notch is covered
+	dirty          bool           // true = the disk was written since it was loaded
+}
+
+// Tracks are recorded at whole track positions, which are every fourth
+// quarter track. Some copy-protected disks also have tracks recorded at
+// half or quarter track positions, which image formats that record only
+// whole tracks can't hold; these are kept in the quarters map while the
+// disk is in use, and in snapshots, but aren't saved to image files or
+// overlays.
+
+// trackAt returns the nibbles the head can read at a quarter track
+// position, or nil if nothing can be read there. The head reads a track
+// recorded exactly under it, or failing that, one recorded a quarter
+// track to either side. Between two recorded tracks, as at the half
+// track positions of a standard disk, it picks up a mix of both that
+// can't be read.
+func (d *diskImage) trackAt(qt int) []byte {
+	if t := d.recordedAt(qt); t != nil {
+		return t
+	}
+	below, above := d.recordedAt(qt-1), d.recordedAt(qt+1)
+	switch {
+	case below != nil && above == nil:
+		return below
+	case above != nil && below == nil:
+		return above
+	}
+	return nil
+}
+
+// recordedAt returns the track recorded at a quarter track position, or
+// nil if none is.
+func (d *diskImage) recordedAt(qt int) []byte {
+	if qt < 0 {
+		return nil
+	}
+	if qt%4 == 0 && qt/4 < diskTracks {
+		return d.tracks[qt/4]
+	}
+	return d.quarters[qt]
+}
+
+// recordAt returns the track to write at a quarter track position,
+// recording a new track of sync nibbles there if nothing can be read
+// there.
+func (d *diskImage) recordAt(qt int) []byte {
+	if t := d.trackAt(qt); t != nil {
+		return t
+	}
+	if d.quarters == nil {
+		d.quarters = make(map[int][]byte)
+	}
+	t := appendSync(make([]byte, 0, nibTrackSize), nibTrackSize)
+	d.quarters[qt] = t
+	return t
+}
+
+// quarterTracks returns the quarter track positions of the tracks
+// recorded between whole tracks, in order.
+func (d *diskImage) quarterTracks() []int {
+	var qts []int
+	for qt := range d.quarters {
+		qts = append(qts, qt)
+	}
+	sort.Ints(qts)
+	return qts
+}
+
+// loadDiskImage reads a disk image in the given format.
+func loadDiskImage(r io.Reader, format diskFormat) (*diskImage, error) {
+	d := &diskImage{format: format, volume: defaultVolume}
+
+	switch format {
+	case diskFormatDOS, diskFormatProDOS:
+		data := make([]byte, diskImageSize)
+		if err := readImage(r, data); err != nil {
+			return nil, err
+		}
+		for t := range d.tracks {
+			d.tracks[t] = d.nibblizeTrack(t, data[t*diskTrackSize:(t+1)*diskTrackSize])
+		}
+
+	case diskFormatD13:
+		data := make([]byte, diskImageSize13)
+		if err := readImage(r, data); err != nil {
+			return nil, err
+		}
+		for t := range d.tracks {
+			d.tracks[t] = d.encodeTrack13(t, data[t*diskTrackSize13:(t+1)*diskTrackSize13])
+		}
+
+	case diskFormatNIB:
+		data := make([]byte, nibImageSize)
+		if err := readImage(r, data); err != nil {
+			return nil, err
+		}
+		for t := range d.tracks {
+			d.tracks[t] = data[t*nibTrackSize : (t+1)*nibTrackSize]
+		}
+
+	default:
+		return nil, errDiskFormat
+	}
+
+	return d, nil
+}
+
+// readImage fills data from r, failing unless r holds exactly len(data)
+// bytes.
+func readImage(r io.Reader, data []byte) error {
+	_, err := io.ReadFull(r, data)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return errDiskSize
+	}
+	if err != nil {
+		return err
+	}
+	var extra [1]byte
+	if n, _ := r.Read(extra[:]); n > 0 {
+		return errDiskSize
+	}
+	return nil
+}
+
+// Save writes the disk image in its original format. Sector images are
+// decoded from the nibbles on each track, so sectors the controller has
+// corrupted cause an error.
+func (d *diskImage) Save(w io.Writer) error {
+	for t, track := range d.tracks {
+		if d.format == diskFormatNIB {
+			if _, err := w.Write(track); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var data []byte
+		var err error
+		if d.format == diskFormatD13 {
+			data, err = decodeTrack13(t, track)
+		} else {
+			data, err = d.denibblizeTrack(t, track)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sectorOrder returns the physical to logical sector mapping of the
+// image's format.
+func (d *diskImage) sectorOrder() *[diskSectors]int {
+	if d.format == diskFormatProDOS {
+		return &prodosSectorOrder
+	}
+	return &dosSectorOrder
+}
+
+// ReadSectors decodes the disk into a sector image with the sectors of
+// each track in the given order, which need not be the order of the
+// image's format. File system code uses this to view any disk in the
+// order it expects.
+func (d *diskImage) ReadSectors(order *[diskSectors]int) ([]byte, error) {
+	data := make([]byte, 0, diskImageSize)
+	for t, track := range d.tracks {
+		sectors, err := decodeTrack(t, track, order)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sectors...)
+	}
+	return data, nil
+}
+
+// WriteSectors re-encodes every track of the disk from a sector image with
+// the sectors of each track in the given order, and marks the disk as
+// written.
+func (d *diskImage) WriteSectors(data []byte, order *[diskSectors]int) error {
+	if len(data) != diskImageSize {
+		return errDiskSize
+	}
+	for t := range d.tracks {
+		d.tracks[t] = d.encodeTrack(t, data[t*diskTrackSize:(t+1)*diskTrackSize], order)
+	}
+	d.dirty = true
+	return nil
+}
+
+// nibblizeTrack encodes one track of sector data into nibbles using the
+// standard 16-sector format.
+func (d *diskImage) nibblizeTrack(t int, data []byte) []byte {
+	return d.encodeTrack(t, data, d.sectorOrder())
+}
+
+// encodeTrack encodes one track of sector data, whose sectors are in the
+// given order, into nibbles.
+func (d *diskImage) encodeTrack(t int, data []byte, order *[diskSectors]int) []byte {
+	nib := make([]byte, 0, nibTrackSize)
+
+	nib = appendSync(nib, nibGap1Length)
+	for s := 0; s < diskSectors; s++ {
+		// Address field
+		nib = append(nib, 0xd5, 0xaa, 0x96)
+		nib = append4and4(nib, d.volume)
+		nib = append4and4(nib, byte(t))
+		nib = append4and4(nib, byte(s))
+		nib = append4and4(nib, d.volume^byte(t)^byte(s))
+		nib = append(nib, 0xde, 0xaa, 0xeb)
+		nib = appendSync(nib, 6)
+
+		// Data field
+		nib = append(nib, 0xd5, 0xaa, 0xad)
+		off := order[s] * diskSectorSize
+		nib = append6and2(nib, data[off:off+diskSectorSize])
+		nib = append(nib, 0xde, 0xaa, 0xeb)
+		nib = appendSync(nib, 27)
+	}
+	return nib
+}
+
+// encodeTrack13 encodes one track of 13-sector data, whose sectors are
+// in physical order, into nibbles. Address fields begin with D5 AA B5
+// rather than D5 AA 96, and data fields use the 5-and-3 encoding.
+func (d *diskImage) encodeTrack13(t int, data []byte) []byte {
+	nib := make([]byte, 0, nibTrackSize)
+
+	nib = appendSync(nib, nibGap1Length13)
+	for s := 0; s < diskSectors13; s++ {
+		nib = append(nib, 0xd5, 0xaa, 0xb5)
+		nib = append4and4(nib, d.volume)
+		nib = append4and4(nib, byte(t))
+		nib = append4and4(nib, byte(s))
+		nib = append4and4(nib, d.volume^byte(t)^byte(s))
+		nib = append(nib, 0xde, 0xaa, 0xeb)
+		nib = appendSync(nib, 6)
+
+		nib = append(nib, 0xd5, 0xaa, 0xad)
+		nib = append5and3(nib, data[s*diskSectorSize:(s+1)*diskSectorSize])
+		nib = append(nib, 0xde, 0xaa, 0xeb)
+		nib = appendSync(nib, 27)
+	}
+	return nib
+}
+
+var errDiskSector = errors.New("disk sector could not be decoded")
+
+// denibblizeTrack decodes the sectors of a nibblized track.
+func (d *diskImage) denibblizeTrack(t int, nib []byte) ([]byte, error) {
+	return decodeTrack(t, nib, d.sectorOrder())
+}
+
+// decodeTrack decodes the sectors of a nibblized track into the given
+// sector order.
+func decodeTrack(t int, nib []byte, order *[diskSectors]int) ([]byte, error) {
+	data := make([]byte, diskTrackSize)
+
+	var found [diskSectors]bool
+	n := len(nib)
+
+	// Scan the track twice so that sectors that wrap around the end of
+	// the track are found.
+	for i := 0; i < 2*n; i++ {
+		if !matchNibbles(nib, i, 0xd5, 0xaa, 0x96) {
+			continue
+		}
+		hdr := i + 3
+		trk := decode4and4(nib[(hdr+2)%n], nib[(hdr+3)%n])
+		sec := int(decode4and4(nib[(hdr+4)%n], nib[(hdr+5)%n]))
+		if int(trk) != t || sec >= diskSectors || found[sec] {
+			continue
+		}
+
+		// The data field follows within a few dozen nibbles.
+		for j := hdr + 8; j < hdr+8+48; j++ {
+			if !matchNibbles(nib, j, 0xd5, 0xaa, 0xad) {
+				continue
+			}
+			off := order[sec] * diskSectorSize
+			if decode6and2(data[off:off+diskSectorSize], nib, j+3) {
+				found[sec] = true
+			}
+			break
+		}
+	}
+
+	for _, f := range found {
+		if !f {
+			return nil, errDiskSector
+		}
+	}
+	return data, nil
+}
+
+// decodeTrack13 decodes the sectors of a 13-sector track, in physical
+// order.
+func decodeTrack13(t int, nib []byte) ([]byte, error) {
+	data := make([]byte, diskTrackSize13)
+
+	var found [diskSectors13]bool
+	n := len(nib)
+	for i := 0; i < 2*n; i++ {
+		if !matchNibbles(nib, i, 0xd5, 0xaa, 0xb5) {
+			continue
+		}
+		hdr := i + 3
+		trk := decode4and4(nib[(hdr+2)%n], nib[(hdr+3)%n])
+		sec := int(decode4and4(nib[(hdr+4)%n], nib[(hdr+5)%n]))
+		if int(trk) != t || sec >= diskSectors13 || found[sec] {
+			continue
+		}
+
+		for j := hdr + 8; j < hdr+8+48; j++ {
+			if !matchNibbles(nib, j, 0xd5, 0xaa, 0xad) {
+				continue
+			}
+			if decode5and3(data[sec*diskSectorSize:(sec+1)*diskSectorSize], nib, j+3) {
+				found[sec] = true
+			}
+			break
+		}
+	}
+
+	for _, f := range found {
+		if !f {
+			return nil, errDiskSector
+		}
+	}
+	return data, nil
+}
+
+// matchNibbles reports whether the nibbles starting at index i of a
+// circular track match a prologue.
+func matchNibbles(nib []byte, i int, p0, p1, p2 byte) bool {
+	n := len(nib)
+	return nib[i%n] == p0 && nib[(i+1)%n] == p1 && nib[(i+2)%n] == p2
+}
+
+func appendSync(nib []byte, count int) []byte {
+	for i := 0; i < count; i++ {
+		nib = append(nib, 0xff)
+	}
+	return nib
+}
+
+func append4and4(nib []byte, v byte) []byte {
+	return append(nib, (v>>1)|0xaa, v|0xaa)
+}
+
+func decode4and4(odd, even byte) byte {
+	return ((odd << 1) | 1) & even
+}
+
+// Disk nibbles for each 6-bit value in the 6-and-2 encoding.
+var nibbles62 = [64]byte{
+	0x96, 0x97, 0x9a, 0x9b, 0x9d, 0x9e, 0x9f, 0xa6,
+	0xa7, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb2, 0xb3,
+	0xb4, 0xb5, 0xb6, 0xb7, 0xb9, 0xba, 0xbb, 0xbc,
+	0xbd, 0xbe, 0xbf, 0xcb, 0xcd, 0xce, 0xcf, 0xd3,
+	0xd6, 0xd7, 0xd9, 0xda, 0xdb, 0xdc, 0xdd, 0xde,
+	0xdf, 0xe5, 0xe6, 0xe7, 0xe9, 0xea, 0xeb, 0xec,
+	0xed, 0xee, 0xef, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6,
+	0xf7, 0xf9, 0xfa, 0xfb, 0xfc, 0xfd, 0xfe, 0xff,
+}
+
+// values62 maps disk nibbles back to 6-bit values. Invalid nibbles map to
+// 0xff.
+var values62 = func() (t [256]byte) {
+	for i := range t {
+		t[i] = 0xff
+	}
+	for v, n := range nibbles62 {
+		t[n] = byte(v)
+	}
+	return t
+}()
+
+// swap2 reverses the two low bits of v.
+func swap2(v byte) byte {
+	return (v&1)<<1 | (v&2)>>1
+}
+
+// append6and2 encodes a 256-byte sector as 342 6-bit values plus a
+// checksum. The first 86 values hold the low two bits of each byte, and
+// the remaining 256 hold the high six bits. Each value is written
+// exclusive-ored with the previous one.
+func append6and2(nib []byte, data []byte) []byte {
+	var buf [342]byte
+	for i := 0; i < 86; i++ {
+		v := swap2(data[i]) | swap2(data[i+86])<<2
+		if i+172 < 256 {
+			v |= swap2(data[i+172]) << 4
+		}
+		buf[i] = v
+	}
+	for i := 0; i < 256; i++ {
+		buf[86+i] = data[i] >> 2
+	}
+
+	var last byte
+	for _, v := range buf {
+		nib = append(nib, nibbles62[v^last])
+		last = v
+	}
+	return append(nib, nibbles62[last])
+}
+
+// decode6and2 decodes a sector from the 343 nibbles of a data field that
+// start at index i of a circular track. It returns false if the data
+// field holds invalid nibbles or a bad checksum.
+func decode6and2(data []byte, nib []byte, i int) bool {
+	var buf [342]byte
+	var last byte
+	for j := range buf {
+		v := values62[nib[(i+j)%len(nib)]]
+		if v == 0xff {
+			return false
+		}
+		last ^= v
+		buf[j] = last
+	}
+	if values62[nib[(i+342)%len(nib)]] != last {
+		return false
+	}
+
+	for j := 0; j < 256; j++ {
+		aux := buf[j%86] >> (2 * uint(j/86))
+		data[j] = buf[86+j]<<2 | swap2(aux&3)
+	}
+	return true
+}
+
+// Disk nibbles for each 5-bit value in the 5-and-3 encoding.
+var nibbles53 = [32]byte{
+	0xab, 0xad, 0xae, 0xaf, 0xb5, 0xb6, 0xb7, 0xba,
+	0xbb, 0xbd, 0xbe, 0xbf, 0xd6, 0xd7, 0xda, 0xdb,
+	0xdd, 0xde, 0xdf, 0xea, 0xeb, 0xed, 0xee, 0xef,
+	0xf5, 0xf6, 0xf7, 0xfa, 0xfb, 0xfd, 0xfe, 0xff,
+}
+
+// values53 maps disk nibbles back to 5-bit values. Invalid nibbles map to
+// 0xff.
+var values53 = func() (t [256]byte) {
+	for i := range t {
+		t[i] = 0xff
+	}
+	for v, n := range nibbles53 {
+		t[n] = byte(v)
+	}
+	return t
+}()
+
+// append5and3 encodes a 256-byte sector as 410 5-bit values plus a
+// checksum, as DOS 3.2 does. The sector is split into 51 groups of five
+// bytes and a final byte. The high five bits of each byte go in 256 "top"
+// values; the low three bits of the groups are spread over 154 "threes"
+// values, which are written first, in reverse order. Each value is written
+// exclusive-ored with the previous one.
+func append5and3(nib []byte, data []byte) []byte {
+	var top [256]byte
+	var threes [154]byte
+	for g := 0; g < 51; g++ {
+		b := data[g*5 : g*5+5]
+		c := 50 - g
+		for k := 0; k < 5; k++ {
+			top[c+51*k] = b[k] >> 3
+		}
+		threes[c] = (b[0]&7)<<2 | (b[3]&4)>>1 | (b[4]&4)>>2
+		threes[c+51] = (b[1]&7)<<2 | (b[3] & 2) | (b[4]&2)>>1
+		threes[c+102] = (b[2]&7)<<2 | (b[3]&1)<<1 | (b[4] & 1)
+	}
+	top[255] = data[255] >> 3
+	threes[153] = data[255] & 7
+
+	var last byte
+	for i := len(threes) - 1; i >= 0; i-- {
+		nib = append(nib, nibbles53[threes[i]^last])
+		last = threes[i]
+	}
+	for _, v := range top {
+		nib = append(nib, nibbles53[v^last])
+		last = v
+	}
+	return append(nib, nibbles53[last])
+}
+
+// decode5and3 decodes a sector from the 411 nibbles of a 5-and-3 data
+// field that start at index i of a circular track. It returns false if
+// the data field holds invalid nibbles or a bad checksum.
+func decode5and3(data []byte, nib []byte, i int) bool {
+	var buf [410]byte
+	var last byte
+	for j := range buf {
+		v := values53[nib[(i+j)%len(nib)]]
+		if v == 0xff {
+			return false
+		}
+		last ^= v
+		buf[j] = last
+	}
+	if values53[nib[(i+410)%len(nib)]] != last {
+		return false
+	}
+
+	var threes [154]byte
+	for j := range threes {
+		threes[153-j] = buf[j]
+	}
+	top := buf[154:]
+	for g := 0; g < 51; g++ {
+		c := 50 - g
+		t0, t1, t2 := threes[c], threes[c+51], threes[c+102]
+		b := data[g*5 : g*5+5]
+		b[0] = top[c]<<3 | t0>>2
+		b[1] = top[c+51]<<3 | t1>>2
+		b[2] = top[c+102]<<3 | t2>>2
+		b[3] = top[c+153]<<3 | (t0&2)<<1 | (t1 & 2) | (t2&2)>>1
+		b[4] = top[c+204]<<3 | (t0&1)<<2 | (t1&1)<<1 | (t2 & 1)
+	}
+	data[255] = top[255]<<3 | threes[153]&7
+	return true
+}
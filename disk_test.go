@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testDiskData() []byte {
+	data := make([]byte, diskImageSize)
+	for i := range data {
+		data[i] = byte(i*7 + i>>8)
+	}
+	return data
+}
+
+func TestDiskRoundTrip(t *testing.T) {
+	data := testDiskData()
+
+	for _, format := range []diskFormat{diskFormatDOS, diskFormatProDOS} {
+		d, err := loadDiskImage(bytes.NewReader(data), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, track := range d.tracks {
+			if len(track) != nibTrackSize {
+				t.Fatalf("Format %d track %d: expected %d nibbles, got %d\n", format, i, nibTrackSize, len(track))
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := d.Save(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("Format %d: saved image differs from original\n", format)
+		}
+	}
+
+	if _, err := loadDiskImage(bytes.NewReader(data[:1000]), diskFormatDOS); err != errDiskSize {
+		t.Errorf("Expected errDiskSize for a short image, got %v\n", err)
+	}
+}
+
+// BenchmarkDecodeTrack decodes a nibblized track into sector data, as is
+// done for each track when a disk is saved.
+func BenchmarkDecodeTrack(b *testing.B) {
+	data := testDiskData()
+	b.Run("16-sector", func(b *testing.B) {
+		d, err := loadDiskImage(bytes.NewReader(data), diskFormatDOS)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(diskTrackSize)
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeTrack(17, d.tracks[17], d.sectorOrder()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("13-sector", func(b *testing.B) {
+		d, err := loadDiskImage(bytes.NewReader(data[:diskImageSize13]), diskFormatD13)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(diskTrackSize13)
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeTrack13(17, d.tracks[17]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDisk13RoundTrip(t *testing.T) {
+	data := testDiskData()[:diskImageSize13]
+	d, err := loadDiskImage(bytes.NewReader(data), diskFormatD13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, track := range d.tracks {
+		if len(track) != nibTrackSize {
+			t.Fatalf("Track %d: expected %d nibbles, got %d\n", i, nibTrackSize, len(track))
+		}
+	}
+	if !bytes.Contains(d.tracks[0], []byte{0xd5, 0xaa, 0xb5}) || bytes.Contains(d.tracks[0], []byte{0xd5, 0xaa, 0x96}) {
+		t.Errorf("Expected 13-sector address fields\n")
+	}
+
+	var buf bytes.Buffer
+	if err := d.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Saved 13-sector image differs from original\n")
+	}
+	if format, _ := diskFormatFromName("dos32.D13"); format != diskFormatD13 {
+		t.Errorf("Expected .d13 images to be 13-sector\n")
+	}
+}
+
+func TestDiskIIRead(t *testing.T) {
+	a := newApple2()
+	filename := filepath.Join(t.TempDir(), "test.dsk")
+	if err := os.WriteFile(filename, testDiskData(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDiskII(a, nil)
+	a.sm.Insert(6, d)
+	if err := a.InsertDisk(1, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn on the motor, select drive 1 and enter read mode.
+	a.mmu.LoadByte(0xc0e9)
+	a.mmu.LoadByte(0xc0ea)
+	a.mmu.LoadByte(0xc0ee)
+
+	// Poll the latch the way RWTS does and collect one revolution of
+	// nibbles, starting at the beginning of the track.
+	a.cpu.Cycles = 0
+	var nibs []byte
+	for len(nibs) < nibTrackSize {
+		if v := a.mmu.LoadByte(0xc0ec); v&0x80 != 0 {
+			nibs = append(nibs, v)
+			a.cpu.Cycles += 20
+		}
+		a.cpu.Cycles += 7
+	}
+	if !bytes.Equal(nibs, d.drives[0].disk.tracks[0]) {
+		t.Errorf("Nibbles read from track 0 don't match the track\n")
+	}
+
+	// Step to track 1 with phases 1 and 2.
+	for _, addr := range []uint16{0xc0e3, 0xc0e2, 0xc0e5, 0xc0e4} {
+		a.mmu.LoadByte(addr)
+	}
+	if d.drives[0].quarterTrack != 4 {
+		t.Errorf("Expected head at quarter track 4, got %d\n", d.drives[0].quarterTrack)
+	}
+}
+
+func TestDiskHotSwap(t *testing.T) {
+	a := newApple2()
+	dir := t.TempDir()
+	file1, file2 := filepath.Join(dir, "one.dsk"), filepath.Join(dir, "two.dsk")
+	for _, f := range []string{file1, file2} {
+		if err := os.WriteFile(f, testDiskData(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := newDiskII(a, nil)
+	a.sm.Insert(6, d)
+	if err := a.InsertDisk(1, file1); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SwapDisks(); err != nil {
+		t.Fatal(err)
+	}
+	if d.drives[0].disk != nil || d.drives[1].filename != file1 {
+		t.Errorf("Disks not swapped\n")
+	}
+	if err := a.EjectDisk(2); err != nil || d.drives[1].disk != nil {
+		t.Errorf("Disk not ejected: %v\n", err)
+	}
+	if err := a.InsertDisk(3, file1); err != errNoDrive {
+		t.Errorf("Expected %v, got %v\n", errNoDrive, err)
+	}
+
+	// Start writing a nibble to the disk in drive 1: turn on the motor,
+	// then load the latch in write mode.
+	a.InsertDisk(1, file1)
+	a.mmu.LoadByte(0xc0e9)
+	a.mmu.LoadByte(0xc0ef)
+	a.mmu.StoreByte(0xc0ed, 0xd5)
+	written := d.drives[0].disk
+
+	// A disk inserted during the write waits for it to finish, so that
+	// the disk being written is saved whole.
+	if err := a.InsertDisk(1, file2); err != nil {
+		t.Fatal(err)
+	}
+	a.in.BeginFrame()
+	if d.drives[0].filename != file1 {
+		t.Errorf("Disk changed during a write\n")
+	}
+	a.mmu.LoadByte(0xc0ee)
+	a.in.BeginFrame()
+	if d.drives[0].filename != file2 {
+		t.Errorf("Disk not changed after the write\n")
+	}
+	if written.dirty {
+		t.Errorf("Written disk not saved when ejected\n")
+	}
+}
+
+func TestDiskModes(t *testing.T) {
+	a := newApple2()
+	filename := filepath.Join(t.TempDir(), "test.dsk")
+	data := testDiskData()
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := newDiskII(a, nil)
+	a.sm.Insert(6, d)
+
+	// The write-protect switch is sensed with Q6 on and Q7 off.
+	sense := func() bool {
+		a.mmu.LoadByte(0xc0ed)
+		return a.mmu.LoadByte(0xc0ee)&0x80 != 0
+	}
+	a.InsertDisk(1, filename)
+	if sense() {
+		t.Errorf("Disk write-protected\n")
+	}
+	a.SetWriteProtect(1, true)
+	if !sense() {
+		t.Errorf("Write protection not sensed\n")
+	}
+	if err := a.SetWriteProtect(2, true); err != errNoDisk {
+		t.Errorf("Expected %v, got %v\n", errNoDisk, err)
+	}
+
+	change := func() []byte {
+		track := d.drives[0].disk.tracks[3]
+		track[0] ^= 0xff
+		d.drives[0].disk.dirty = true
+		return append([]byte(nil), track...)
+	}
+
+	// Changes to a read-only disk are discarded.
+	if err := a.InsertDiskMode(1, filename, diskModeReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	change()
+	if err := a.EjectDisk(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to a copy-on-write disk go to its overlay, and come back
+	// when the disk is inserted again.
+	a.InsertDiskMode(1, filename, diskModeOverlay)
+	changed := change()
+	if err := a.EjectDisk(1); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := os.ReadFile(filename); !bytes.Equal(saved, data) {
+		t.Errorf("Disk image changed\n")
+	}
+	if _, err := os.Stat(overlayFile(filename)); err != nil {
+		t.Errorf("Overlay not saved: %v\n", err)
+	}
+	a.InsertDiskMode(1, filename, diskModeOverlay)
+	if !bytes.Equal(d.drives[0].disk.tracks[3], changed) {
+		t.Errorf("Overlay not applied\n")
+	}
+
+	// Undoing the change removes the overlay.
+	change()
+	a.EjectDisk(1)
+	if _, err := os.Stat(overlayFile(filename)); !os.IsNotExist(err) {
+		t.Errorf("Overlay not removed: %v\n", err)
+	}
+
+	// Disks held in memory are inserted read-only.
+	if err := a.InsertDiskData(2, "mem.dsk", data); err != nil || d.drives[1].mode != diskModeReadOnly {
+		t.Errorf("Disk data not inserted: %v\n", err)
+	}
+	if err := a.InsertDiskData(2, "mem.txt", data); !errors.Is(err, errDiskFormat) {
+		t.Errorf("Expected %v, got %v\n", errDiskFormat, err)
+	}
+
+	for _, c := range []struct {
+		spec, filename string
+		mode           diskMode
+	}{
+		{"a.dsk", "a.dsk", diskModeWrite},
+		{"a.dsk,ro", "a.dsk", diskModeReadOnly},
+		{"a,b.dsk,COW", "a,b.dsk", diskModeOverlay},
+		{"a.dsk,rx", "a.dsk,rx", diskModeWrite},
+	} {
+		if f, m := parseDiskSpec(c.spec); f != c.filename || m != c.mode {
+			t.Errorf("%s: expected %s %v, got %s %v\n", c.spec, c.filename, c.mode, f, m)
+		}
+	}
+}
+
+type driveEvents []driveStatus
+
+func (e *driveEvents) DriveChanged(s driveStatus) {
+	*e = append(*e, s)
+}
+
+func TestDriveStatus(t *testing.T) {
+	a := newApple2()
+	filename := filepath.Join(t.TempDir(), "test.dsk")
+	if err := os.WriteFile(filename, testDiskData(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := newDiskII(a, nil)
+	a.sm.Insert(6, d)
+	var events driveEvents
+	d.AddObserver(&events)
+
+	a.InsertDisk(1, filename)
+	d.EndFrame()
+	d.EndFrame()
+	if len(events) != 1 || !events[0].loaded || events[0].drive != 1 || events[0].motorOn {
+		t.Errorf("Unexpected events after inserting a disk: %v\n", events)
+	}
+
+	// Start the motor and read a nibble from track 17.
+	events = nil
+	d.drives[0].quarterTrack = 68
+	a.mmu.LoadByte(0xc0e9)
+	a.mmu.LoadByte(0xc0ec)
+	d.EndFrame()
+	d.EndFrame()
+	if len(events) != 2 || !events[0].motorOn || !events[0].reading || events[0].Track() != 17 || events[1].reading {
+		t.Errorf("Unexpected events while reading: %v\n", events)
+	}
+	if a.DisksIdle() {
+		t.Errorf("Disks idle while the motor runs\n")
+	}
+
+	// The motor keeps spinning for a second after it's switched off.
+	// Switching it off reads the latch, too.
+	events = nil
+	a.mmu.LoadByte(0xc0e8)
+	d.EndFrame()
+	d.EndFrame()
+	if len(events) == 0 || !events[len(events)-1].motorOn || a.DisksIdle() {
+		t.Errorf("Motor stopped early: %v\n", events)
+	}
+	events = nil
+	a.cpu.Cycles += motorOffDelay
+	d.EndFrame()
+	if len(events) != 1 || events[0].motorOn || !a.DisksIdle() {
+		t.Errorf("Motor didn't stop: %v\n", events)
+	}
+
+	if s, err := a.DriveStatus(2); err != nil || s.loaded || s.drive != 2 {
+		t.Errorf("Unexpected status of drive 2: %v %v\n", s, err)
+	}
+}
+
+func TestDiskQuarterTracks(t *testing.T) {
+	a := newApple2()
+	d := newDiskII(a, nil)
+	a.sm.Insert(6, d)
+	disk, err := loadDiskImage(bytes.NewReader(testDiskData()), diskFormatDOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.insert(0, disk, "", diskModeWrite)
+
+	// Energizing two adjacent phases holds the head between them.
+	steps := []struct {
+		addr uint16
+		qt   int
+	}{
+		{0xc0e1, 0}, // phase 0 on
+		{0xc0e3, 1}, // phase 1 on
+		{0xc0e0, 2}, // phase 0 off
+		{0xc0e5, 3}, // phase 2 on
+		{0xc0e2, 4}, // phase 1 off
+		{0xc0e1, 4}, // phase 0 on, opposite phase 2
+		{0xc0e4, 4}, // phase 2 off, leaving only the opposite phase
+	}
+	for i, s := range steps {
+		a.mmu.LoadByte(s.addr)
+		if qt := d.drives[0].quarterTrack; qt != s.qt {
+			t.Errorf("Step %d: expected quarter track %d, got %d\n", i, s.qt, qt)
+		}
+	}
+
+	// A track can be read a quarter track to either side, but not from
+	// half way between two tracks.
+	for qt, track := range map[int][]byte{3: disk.tracks[1], 4: disk.tracks[1], 5: disk.tracks[1], 6: nil} {
+		if got := disk.trackAt(qt); !bytes.Equal(got, track) || (got == nil) != (track == nil) {
+			t.Errorf("Quarter track %d: read the wrong track\n", qt)
+		}
+	}
+
+	// Writing at a half track records a new track there, which makes the
+	// quarter tracks between it and the whole tracks unreadable.
+	d.drives[0].quarterTrack = 6
+	a.mmu.LoadByte(0xc0e9)
+	a.mmu.LoadByte(0xc0ef)
+	a.mmu.StoreByte(0xc0ed, 0xd5)
+	a.mmu.LoadByte(0xc0ee)
+	if disk.quarters[6] == nil || !bytes.Contains(disk.quarters[6], []byte{0xd5}) {
+		t.Errorf("Half track not recorded\n")
+	}
+	if disk.trackAt(5) != nil || disk.trackAt(7) != nil {
+		t.Errorf("Expected cross-talk between tracks\n")
+	}
+}
+
+// FuzzDecodeTrack checks that decoding a track of any nibbles fails
+// rather than panicking or looping.
+func FuzzDecodeTrack(f *testing.F) {
+	data := testDiskData()
+	d, _ := loadDiskImage(bytes.NewReader(data), diskFormatDOS)
+	f.Add(d.tracks[1])
+	d13, _ := loadDiskImage(bytes.NewReader(data[:diskImageSize13]), diskFormatD13)
+	f.Add(d13.tracks[1])
+	f.Add([]byte{0xd5, 0xaa, 0x96, 0xff, 0xfe, 0xd5, 0xaa, 0xad, 0x96})
+
+	f.Fuzz(func(t *testing.T, nib []byte) {
+		decodeTrack(1, nib, &dosSectorOrder)
+		decodeTrack13(1, nib)
+		checkTrack(1, nib, false)
+		checkTrack(1, nib, true)
+	})
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// diskCommandUsage describes the disk image subcommands.
+const diskCommandUsage = `usage:
+  apple2go disk ls image [dir]
+  apple2go disk get [-raw] image name [file]
+  apple2go disk put [-t type] [-a addr] image file [name]
+  apple2go disk parts image
+  apple2go disk create [-fs none|dos33|prodos] [-size size] [-name name] [-volume n] image
+  apple2go disk convert image newimage
+  apple2go disk verify image
+  apple2go disk compare [-nib] image1 image2`
+
+// runDiskCommand runs a subcommand that manages the files on a disk
+// image, if args names one. It returns the command's exit status, and
+// false if args isn't a disk command.
+func runDiskCommand(args []string, w io.Writer) (status int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	var err error
+	switch args[0] {
+	case "ls":
+		err = diskList(args[1:], w)
+	case "get":
+		err = diskGet(args[1:], w)
+	case "put":
+		err = diskPut(args[1:])
+	case "parts":
+		err = diskParts(args[1:], w)
+	case "create":
+		err = diskCreate(args[1:], w)
+	case "convert":
+		err = diskConvert(args[1:], w)
+	case "verify":
+		err = diskVerify(args[1:], w)
+	case "compare":
+		err = diskCompare(args[1:], w)
+	default:
+		return 0, false
+	}
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %v\n", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+var errDiskCommandUsage = fmt.Errorf("wrong arguments\n%s", diskCommandUsage)
+
+func openDiskFile(filename string) (*diskImage, error) {
+	format, ok := diskFormatFromName(filename)
+	if !ok {
+		return nil, errDiskFormat
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return loadDiskImage(file, format)
+}
+
+func saveDiskFile(filename string, disk *diskImage) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = disk.Save(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// A diskVolume is the file system of a disk image file: either DOS 3.3 or
+// ProDOS.
+type diskVolume struct {
+	dos    *dos33Volume
+	prodos *prodosVolume
+	save   func() error // writes changes back to the file
+}
+
+// openDiskVolume opens the file system of a disk image file. ProDOS
+// images in block order (.po and .hdv files) may be any size; all other
+// images are 5.25" disks.
+func openDiskVolume(filename string) (*diskVolume, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".po" || ext == ".hdv" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if v, err := openProDOS(data); err == nil {
+			save := func() error { return os.WriteFile(filename, data, 0644) }
+			return &diskVolume{prodos: v, save: save}, nil
+		}
+		if ext == ".hdv" {
+			return nil, errProDOSVolume
+		}
+	}
+
+	disk, err := openDiskFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	saver := func(data []byte, order *[diskSectors]int) func() error {
+		return func() error {
+			if err := disk.WriteSectors(data, order); err != nil {
+				return err
+			}
+			return saveDiskFile(filename, disk)
+		}
+	}
+
+	data, err := disk.ReadSectors(&dosSectorOrder)
+	if err != nil {
+		return nil, err
+	}
+	if v, err := openDOS33(data); err == nil {
+		return &diskVolume{dos: v, save: saver(data, &dosSectorOrder)}, nil
+	}
+	data, err = disk.ReadSectors(&prodosSectorOrder)
+	if err != nil {
+		return nil, err
+	}
+	if v, err := openProDOS(data); err == nil {
+		return &diskVolume{prodos: v, save: saver(data, &prodosSectorOrder)}, nil
+	}
+	return nil, fmt.Errorf("%s: no DOS 3.3 or ProDOS file system", filename)
+}
+
+// diskParts lists the partitions of a CompactFlash image.
+func diskParts(args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errDiskCommandUsage
+	}
+	img, err := loadCFImage(args[0])
+	if err != nil {
+		return err
+	}
+	for _, p := range img.Partitions() {
+		fmt.Fprintln(w, p)
+	}
+	return nil
+}
+
+func diskList(args []string, w io.Writer) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errDiskCommandUsage
+	}
+	vol, err := openDiskVolume(args[0])
+	if err != nil {
+		return err
+	}
+
+	if v := vol.prodos; v != nil {
+		dir := ""
+		if len(args) > 1 {
+			dir = args[1]
+		}
+		entries, err := v.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "/%s\n\n NAME            TYPE BLOCKS  MODIFIED            EOF  AUX\n\n", v.Name())
+		for _, e := range entries {
+			fmt.Fprintln(w, e)
+		}
+		free := v.FreeBlocks()
+		fmt.Fprintf(w, "\nBLOCKS FREE: %d  USED: %d  TOTAL: %d\n", free, v.TotalBlocks()-free, v.TotalBlocks())
+		return nil
+	}
+
+	if len(args) > 1 {
+		return errDiskCommandUsage
+	}
+	v := vol.dos
+	entries, err := v.Catalog()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "DISK VOLUME %d\n\n", v.Volume())
+	for _, e := range entries {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprintf(w, "\n%d SECTORS FREE\n", v.FreeSectors())
+	return nil
+}
+
+func diskGet(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.SetOutput(w)
+	raw := fs.Bool("raw", false, "keep the DOS 3.3 header of binary and BASIC files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) < 2 || len(args) > 3 {
+		return errDiskCommandUsage
+	}
+	vol, err := openDiskVolume(args[0])
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if v := vol.prodos; v != nil {
+		var e prodosEntry
+		data, e, err = v.ReadFile(args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		if e.fileType == prodosTypeBIN {
+			fmt.Fprintf(w, "%s: load address $%04X, length $%04X\n", e.name, e.auxType, len(data))
+		}
+	} else {
+		var e dos33Entry
+		data, e, err = vol.dos.ReadFile(args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		if !*raw && dos33HeaderSize(e.fileType) > 0 {
+			var addr uint16
+			addr, data = dos33Payload(e.fileType, data)
+			if e.fileType == dos33Binary {
+				fmt.Fprintf(w, "%s: load address $%04X, length $%04X\n", e.name, addr, len(data))
+			}
+		}
+	}
+
+	out := filepath.Base(args[1])
+	if len(args) > 2 {
+		out = args[2]
+	}
+	return os.WriteFile(out, data, 0644)
+}
+
+func diskPut(args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	typ := fs.String("t", "", "file `type`: a DOS 3.3 letter or ProDOS type name; BASIC for .bas listings, otherwise binary")
+	addr := fs.String("a", "2000", "hexadecimal load `address` of a binary file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) < 2 || len(args) > 3 {
+		return errDiskCommandUsage
+	}
+
+	src := args[1]
+	listing := strings.EqualFold(filepath.Ext(src), ".bas")
+	name := strings.ToUpper(strings.TrimSuffix(filepath.Base(src), filepath.Ext(src)))
+	if len(args) > 2 {
+		name = args[2]
+	}
+	load, err := parseDebugValue(*addr, 0xffff)
+	if err != nil {
+		return err
+	}
+	payload, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if listing {
+		payload, err = tokenizeApplesoft(strings.NewReader(string(payload)), applesoftStart)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+	}
+
+	vol, err := openDiskVolume(args[0])
+	if err != nil {
+		return err
+	}
+
+	if v := vol.prodos; v != nil {
+		t, aux := byte(prodosTypeBIN), uint16(load)
+		if listing {
+			t, aux = prodosTypeBAS, applesoftStart
+		}
+		if *typ != "" {
+			var ok bool
+			if t, ok = parseProDOSType(*typ); !ok {
+				return fmt.Errorf("invalid file type %q", *typ)
+			}
+		}
+		err = v.WriteFile(name, t, aux, payload)
+	} else {
+		t := dos33Binary
+		if listing {
+			t = dos33Applesoft
+		}
+		if *typ != "" {
+			var ok bool
+			if t, ok = parseDOS33FileType(*typ); !ok {
+				return fmt.Errorf("invalid file type %q", *typ)
+			}
+		}
+		err = vol.dos.WriteFile(name, t, dos33File(t, uint16(load), payload))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return vol.save()
+}
+
+// diskCreate creates a new disk image, optionally formatted.
+func diskCreate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fsName := fs.String("fs", "none", "`file system` to format the disk with: none, dos33 or prodos")
+	size := fs.String("size", "", "disk `size` in K, or M with an M suffix; 140K unless the image is an .hdv (32M) or a larger .po")
+	name := fs.String("name", "", "ProDOS volume `name`; the image file's name if not given")
+	volume := fs.Int("volume", defaultVolume, "DOS 3.3 volume `number` (1-254)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) != 1 {
+		return errDiskCommandUsage
+	}
+
+	opts := newDiskOptions{name: *name, volume: byte(*volume)}
+	var err error
+	if opts.fs, err = parseDiskFileSystem(*fsName); err != nil {
+		return err
+	}
+	if *size != "" {
+		if opts.blocks, err = parseDiskSize(*size); err != nil {
+			return err
+		}
+	}
+	if *volume < 1 || *volume > 254 {
+		return fmt.Errorf("invalid volume number %d", *volume)
+	}
+	return createDiskFile(args[0], opts)
+}
+
+// diskConvert converts a disk image to the format of the new image's file
+// name extension.
+func diskConvert(args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return errDiskCommandUsage
+	}
+	return convertDiskFile(args[0], args[1], func(s string) {
+		fmt.Fprintf(w, "WARNING: %s\n", s)
+	})
+}
+
+// diskVerify checks the checksums of every sector of a 5.25" disk image.
+func diskVerify(args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errDiskCommandUsage
+	}
+	c, err := readDiskContents(args[0])
+	if err != nil {
+		return err
+	}
+	disk, err := c.floppy()
+	if err != nil {
+		return err
+	}
+	sectors, errs := verifyDisk(disk)
+	for _, e := range errs {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprintf(w, "%d of %d sectors OK\n", sectors-len(errs), sectors)
+	if len(errs) > 0 {
+		return fmt.Errorf("%d bad sectors", len(errs))
+	}
+	return nil
+}
+
+// diskCompare compares two disk images, sector by sector or nibble by
+// nibble.
+func diskCompare(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.SetOutput(w)
+	nibbles := fs.Bool("nib", false, "compare the nibbles of each track rather than the sectors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) != 2 {
+		return errDiskCommandUsage
+	}
+	a, err := readDiskContents(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := readDiskContents(args[1])
+	if err != nil {
+		return err
+	}
+	diffs, err := compareDisks(a, b, *nibbles)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		fmt.Fprintln(w, d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d differences", len(diffs))
+	}
+	fmt.Fprintln(w, "The disks are the same")
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A diskContents holds the contents of a disk image file being converted
+// to another format: the nibbles of a 5.25" disk, or the blocks of a
+// ProDOS volume of another size.
+type diskContents struct {
+	disk   *diskImage
+	blocks []byte
+	woz    bool // the disk was read from the bits of a WOZ image
+}
+
+// readDiskContents reads a disk image file in any format the emulator
+// knows: .dsk, .do, .po, .nib, .d13, .woz, .hdv or .2mg.
+func readDiskContents(filename string) (diskContents, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return diskContents{}, err
+	}
+
+	var c diskContents
+	format, ok := diskFormatFromName(filename)
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".woz":
+		c.disk, err = loadWOZ(data)
+		c.woz = true
+	case ext == ".2mg":
+		c, err = read2MG(data)
+	case ext == ".hdv" || ext == ".po" && len(data) != diskImageSize:
+		if len(data) == 0 || len(data)%prodosBlockSize != 0 {
+			err = errBlockImageSize
+		}
+		c.blocks = data
+	case ok:
+		c.disk, err = loadDiskImage(bytes.NewReader(data), format)
+	default:
+		err = errDiskFormat
+	}
+	if err != nil {
+		return diskContents{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// read2MG reads the contents of a 2IMG image: a 5.25" disk in DOS or
+// ProDOS sector order or as nibbles, or a ProDOS volume of another size.
+func read2MG(data []byte) (diskContents, error) {
+	if len(data) < twoMGHeaderSize || string(data[:4]) != "2IMG" {
+		return diskContents{}, errDiskFormat
+	}
+	offset := binary.LittleEndian.Uint32(data[twoMGDataOffset:])
+	length := binary.LittleEndian.Uint32(data[twoMGDataLength:])
+	if uint64(offset)+uint64(length) > uint64(len(data)) {
+		return diskContents{}, errDiskSize
+	}
+	body := data[offset : offset+length]
+
+	var c diskContents
+	var err error
+	switch binary.LittleEndian.Uint32(data[twoMGFormat:]) {
+	case 0:
+		c.disk, err = loadDiskImage(bytes.NewReader(body), diskFormatDOS)
+	case 1:
+		if len(body) != diskImageSize {
+			c.blocks = body
+			break
+		}
+		c.disk, err = loadDiskImage(bytes.NewReader(body), diskFormatProDOS)
+	case 2:
+		c.disk, err = loadDiskImage(bytes.NewReader(body), diskFormatNIB)
+	default:
+		err = errDiskFormat
+	}
+	return c, err
+}
+
+// convertDiskFile converts a disk image file to another format, given by
+// the extension of the new file's name. Conversions that lose nibble
+// data, such as copy protection, half tracks or the bit timing of a WOZ
+// image, are made anyway, and warn describes what was lost. Conversions
+// that would lose the disk's contents fail.
+func convertDiskFile(src, dst string, warn func(string)) error {
+	c, err := readDiskContents(src)
+	if err != nil {
+		return err
+	}
+	data, err := c.encode(dst, warn)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// encode returns the contents as a disk image file of the format given
+// by the file name's extension.
+func (c diskContents) encode(filename string, warn func(string)) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	format, ok := diskFormatFromName(filename)
+	switch {
+	case ext == ".hdv" || ext == ".po" && c.disk == nil && len(c.blocks) != diskImageSize:
+		return c.volumeBlocks()
+	case ext == ".2mg":
+		blocks, err := c.volumeBlocks()
+		if err != nil {
+			return nil, err
+		}
+		return twoMGImage(blocks), nil
+	case ext != ".woz" && !ok:
+		return nil, errDiskFormat
+	}
+
+	disk, err := c.floppy()
+	if err != nil {
+		return nil, err
+	}
+	if n := len(disk.quarters); n > 0 && ext != ".woz" {
+		warn(fmt.Sprintf("%d tracks between whole tracks will be lost", n))
+	}
+	if c.woz && ext != ".woz" {
+		warn("the bit timing of the WOZ image will be lost")
+	}
+
+	switch {
+	case ext == ".woz":
+		return wozImage(disk), nil
+	case format == diskFormatNIB:
+		return nibImage(disk, warn), nil
+	case (format == diskFormatD13) != sectors13(disk):
+		return nil, errSectorCount
+	}
+
+	n, err := nonstandardTracks(disk)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		warn(fmt.Sprintf("%d tracks hold nibble data, such as copy protection or unusual gaps, that a sector image can't keep", n))
+	}
+	out := &diskImage{format: format, volume: disk.volume, tracks: disk.tracks}
+	var buf bytes.Buffer
+	if err := out.Save(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var errSectorCount = errors.New("can't convert between 13- and 16-sector disks")
+
+// floppy returns the contents as a 5.25" disk.
+func (c diskContents) floppy() (*diskImage, error) {
+	if c.disk != nil {
+		return c.disk, nil
+	}
+	if len(c.blocks) != diskImageSize {
+		return nil, fmt.Errorf("a %dK volume isn't a 5.25\" disk", len(c.blocks)/1024)
+	}
+	disk := &diskImage{format: diskFormatProDOS, volume: defaultVolume}
+	disk.WriteSectors(c.blocks, &prodosSectorOrder)
+	return disk, nil
+}
+
+// volumeBlocks returns the contents as the blocks of a ProDOS volume.
+func (c diskContents) volumeBlocks() ([]byte, error) {
+	if c.disk == nil {
+		return c.blocks, nil
+	}
+	if sectors13(c.disk) {
+		return nil, errSectorCount
+	}
+	n, err := nonstandardTracks(c.disk)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, fmt.Errorf("%d tracks hold nibble data that a block image can't keep", n)
+	}
+	return c.disk.ReadSectors(&prodosSectorOrder)
+}
+
+// nonstandardTracks returns the number of tracks of a disk that aren't
+// exactly as the emulator would format them, and so would change if the
+// disk were saved as sectors and loaded again. It fails if any track's
+// sectors can't be read.
+func nonstandardTracks(disk *diskImage) (int, error) {
+	n := 0
+	is13 := sectors13(disk)
+	for t, nib := range disk.tracks {
+		volume, _ := addressField(nib)
+		d := &diskImage{format: disk.format, volume: volume}
+		var std []byte
+		var data []byte
+		var err error
+		if is13 {
+			if data, err = decodeTrack13(t, nib); err == nil {
+				std = d.encodeTrack13(t, data)
+			}
+		} else {
+			if data, err = decodeTrack(t, nib, &dosSectorOrder); err == nil {
+				std = d.encodeTrack(t, data, &dosSectorOrder)
+			}
+		}
+		if err != nil {
+			return 0, fmt.Errorf("track %d: %w", t, err)
+		}
+		if !bytes.Equal(std, nib) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// sectors13 reports whether a disk has 13 sectors per track, judging by
+// the address fields of track 0 when the disk's format doesn't say.
+func sectors13(disk *diskImage) bool {
+	if disk.format != diskFormatNIB {
+		return disk.format == diskFormatD13
+	}
+	_, is13 := addressField(disk.tracks[0])
+	return is13
+}
+
+// addressField returns the volume number in the first address field of a
+// track, and whether it's the address field of a 13-sector disk.
+func addressField(nib []byte) (volume byte, is13 bool) {
+	for i := 0; i+5 < len(nib); i++ {
+		if matchNibbles(nib, i, 0xd5, 0xaa, 0x96) || matchNibbles(nib, i, 0xd5, 0xaa, 0xb5) {
+			return decode4and4(nib[i+3], nib[i+4]), nib[i+2] == 0xb5
+		}
+	}
+	return defaultVolume, false
+}
+
+// nibImage returns the .nib image of a disk. Each track of a .nib image
+// holds exactly 6656 nibbles, so shorter tracks are padded with sync
+// nibbles and longer ones cut short.
+func nibImage(disk *diskImage, warn func(string)) []byte {
+	var out []byte
+	cut := 0
+	for _, nib := range disk.tracks {
+		if len(nib) > nibTrackSize {
+			nib = nib[:nibTrackSize]
+			cut++
+		}
+		out = append(out, nib...)
+		out = appendSync(out, nibTrackSize-len(nib))
+	}
+	if cut > 0 {
+		warn(fmt.Sprintf("%d tracks are longer than a .nib track and will be cut short", cut))
+	}
+	return out
+}
+
+// twoMGImage returns a 2IMG image of a ProDOS volume, in ProDOS order.
+func twoMGImage(blocks []byte) []byte {
+	hdr := make([]byte, twoMGHeaderSize)
+	copy(hdr, "2IMGA2GO")
+	binary.LittleEndian.PutUint16(hdr[0x08:], twoMGHeaderSize)
+	binary.LittleEndian.PutUint16(hdr[0x0a:], 1) // version
+	binary.LittleEndian.PutUint32(hdr[twoMGFormat:], 1)
+	binary.LittleEndian.PutUint32(hdr[0x14:], uint32(len(blocks)/prodosBlockSize))
+	binary.LittleEndian.PutUint32(hdr[twoMGDataOffset:], twoMGHeaderSize)
+	binary.LittleEndian.PutUint32(hdr[twoMGDataLength:], uint32(len(blocks)))
+	return append(hdr, blocks...)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskConvert(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	var warnings []string
+	convert := func(src, dst string) error {
+		warnings = nil
+		return convertDiskFile(path(src), path(dst), func(s string) { warnings = append(warnings, s) })
+	}
+
+	// A DOS 3.3 disk survives a trip through every format.
+	if err := createDiskFile(path("a.dsk"), newDiskOptions{fs: diskFSDOS33, volume: 17}); err != nil {
+		t.Fatal(err)
+	}
+	vol, _ := openDiskVolume(path("a.dsk"))
+	if err := vol.dos.WriteFile("HELLO", dos33Text, []byte("HI\r")); err != nil {
+		t.Fatal(err)
+	}
+	if err := vol.save(); err != nil {
+		t.Fatal(err)
+	}
+	chain := []string{"a.dsk", "b.woz", "c.nib", "d.po", "e.2mg", "f.hdv", "g.do"}
+	for i := 1; i < len(chain); i++ {
+		if err := convert(chain[i-1], chain[i]); err != nil {
+			t.Fatalf("%s to %s: %v\n", chain[i-1], chain[i], err)
+		}
+		if len(warnings) > 0 && chain[i-1] != "b.woz" {
+			t.Errorf("%s to %s: unexpected warnings %v\n", chain[i-1], chain[i], warnings)
+		}
+	}
+	a, _ := os.ReadFile(path("a.dsk"))
+	g, _ := os.ReadFile(path("g.do"))
+	if !bytes.Equal(a, g) {
+		t.Errorf("Disk changed by conversion\n")
+	}
+	if c, err := readDiskContents(path("b.woz")); err != nil || !nibbleEqual(t, c.disk, path("c.nib")) {
+		t.Errorf("Unexpected WOZ contents: %v\n", err)
+	}
+
+	// WOZ images keep half tracks, which other formats lose.
+	disk, _ := openDiskFile(path("a.dsk"))
+	copy(disk.recordAt(10), []byte{0xd5, 0xaa, 0xad})
+	disk2, err := loadWOZ(wozImage(disk))
+	if err != nil || len(disk2.quarters) != 1 || !bytes.Equal(disk2.quarters[10], disk.quarters[10]) {
+		t.Errorf("Half track not kept: %v\n", err)
+	}
+	if _, err := (diskContents{disk: disk2}).encode("q.dsk", func(s string) { warnings = append(warnings, s) }); err != nil || len(warnings) != 1 {
+		t.Errorf("Expected a warning: %v %v\n", err, warnings)
+	}
+
+	// 13-sector disks convert only to other 13-sector formats.
+	if err := createDiskFile(path("old.d13"), newDiskOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := convert("old.d13", "old.woz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := convert("old.woz", "old2.d13"); err != nil {
+		t.Fatal(err)
+	}
+	if err := convert("old.woz", "old.dsk"); !errors.Is(err, errSectorCount) {
+		t.Errorf("Expected %v, got %v\n", errSectorCount, err)
+	}
+
+	// Nibble data that doesn't fit in sectors brings a warning.
+	nib, _ := os.ReadFile(path("c.nib"))
+	nib[3*nibTrackSize+2] = 0x96
+	os.WriteFile(path("p.nib"), nib, 0644)
+	if err := convert("p.nib", "p.dsk"); err != nil || len(warnings) != 1 {
+		t.Errorf("Expected a warning: %v %v\n", err, warnings)
+	}
+	if err := convert("p.nib", "p.hdv"); err == nil {
+		t.Errorf("Expected an error\n")
+	}
+
+	// Volumes larger than a floppy convert only to block images.
+	if err := createDiskFile(path("big.po"), newDiskOptions{fs: diskFSProDOS, blocks: 1600}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dst := range []string{"big.2mg", "big.hdv"} {
+		if err := convert("big.po", dst); err != nil {
+			t.Errorf("%s: %v\n", dst, err)
+		}
+	}
+	if err := convert("big.2mg", "big2.po"); err != nil {
+		t.Fatal(err)
+	}
+	big, _ := os.ReadFile(path("big.po"))
+	big2, _ := os.ReadFile(path("big2.po"))
+	if !bytes.Equal(big, big2) {
+		t.Errorf("Volume changed by conversion\n")
+	}
+	if err := convert("big.po", "big.woz"); err == nil {
+		t.Errorf("Expected an error\n")
+	}
+}
+
+// nibbleEqual reports whether a disk's nibbles match a .nib image file.
+func nibbleEqual(t *testing.T, disk *diskImage, file string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, track := range disk.tracks {
+		if !bytes.Equal(track, data[i*nibTrackSize:(i+1)*nibTrackSize]) {
+			return false
+		}
+	}
+	return true
+}
+
+// FuzzDiskImageFiles checks that reading WOZ and 2MG images fails rather
+// than panicking or looping when they're malformed.
+func FuzzDiskImageFiles(f *testing.F) {
+	d, _ := loadDiskImage(bytes.NewReader(testDiskData()), diskFormatDOS)
+	f.Add(wozImage(d))
+	blocks, _ := formatProDOS("FUZZ", 16)
+	f.Add(twoMGImage(blocks))
+	f.Add([]byte("WOZ2\xff\x0a\x0d\x0a"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if disk, err := loadWOZ(data); err == nil {
+			for _, qt := range disk.quarterTracks() {
+				checkTrack(qt/4, disk.trackAt(qt), false)
+			}
+			disk.ReadSectors(&dosSectorOrder)
+		}
+		if c, err := read2MG(data); err == nil && c.disk != nil {
+			c.disk.ReadSectors(&dosSectorOrder)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Standard disk sizes, in 512-byte blocks.
+const (
+	floppyBlocks   = diskImageSize / prodosBlockSize // 5.25" floppy
+	hardDiskBlocks = 0xffff                          // largest ProDOS volume
+)
+
+// A diskFileSystem is the file system written to a new disk image.
+type diskFileSystem byte
+
+const (
+	diskFSNone   diskFileSystem = iota // blank: every sector is zero
+	diskFSDOS33                        // an empty DOS 3.3 catalog
+	diskFSProDOS                       // an empty ProDOS volume directory
+)
+
+var diskFileSystemNames = []string{"none", "dos33", "prodos"}
+
+var (
+	errDiskFileSystem = errors.New("file system must be none, dos33 or prodos")
+	errDiskBlocks     = errors.New("disk size must be from 8K to 32M")
+	errDOS33Size      = errors.New("DOS 3.3 needs a 140K 5.25\" disk")
+)
+
+func (fs diskFileSystem) String() string {
+	return diskFileSystemNames[fs]
+}
+
+// parseDiskFileSystem parses a file system name.
+func parseDiskFileSystem(s string) (diskFileSystem, error) {
+	for i, name := range diskFileSystemNames {
+		if strings.EqualFold(s, name) {
+			return diskFileSystem(i), nil
+		}
+	}
+	return 0, errDiskFileSystem
+}
+
+// parseDiskSize parses a disk size in kilobytes, or in megabytes with an M
+// suffix, as in "140", "800K" or "32M", and returns it in blocks. 32M is
+// one block more than the largest ProDOS volume, so it is trimmed to fit.
+func parseDiskSize(s string) (int, error) {
+	size, unit := s, 2
+	switch {
+	case strings.HasSuffix(strings.ToUpper(size), "M"):
+		size, unit = size[:len(size)-1], 2048
+	case strings.HasSuffix(strings.ToUpper(size), "K"):
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid disk size %q", s)
+	}
+	blocks := n * unit
+	if blocks == hardDiskBlocks+1 {
+		blocks = hardDiskBlocks
+	}
+	return blocks, nil
+}
+
+// newDiskOptions describe a new disk image.
+type newDiskOptions struct {
+	fs     diskFileSystem
+	blocks int    // size, or 0 for the usual size of the image's format
+	name   string // ProDOS volume name, or "" to name it after the file
+	volume byte   // DOS 3.3 volume number
+}
+
+// diskBootBlock is the boot code written to the first sector of a newly
+// formatted disk, which both the Disk II boot ROM and ProDOS block device
+// firmware load at $0800 and run from $0801. A new disk has no operating
+// system to load, so the code says so and enters the monitor:
+//
+//	$0800        DFB 1      ; number of sectors to load
+//	$0801        JSR HOME
+//	$0804        LDY #0
+//	$0806 LOOP   LDA MSG,Y
+//	$0809        BEQ DONE
+//	$080B        JSR COUT
+//	$080E        INY
+//	$080F        BNE LOOP
+//	$0811 DONE   JMP MONZ
+//	$0814 MSG    ASC "NO OPERATING SYSTEM ON THIS DISK",0D,00
+var diskBootBlock = append([]byte{
+	0x01, 0x20, 0x58, 0xfc, 0xa0, 0x00, 0xb9, 0x14, 0x08, 0xf0, 0x06,
+	0x20, 0xed, 0xfd, 0xc8, 0xd0, 0xf5, 0x4c, 0x69, 0xff,
+}, append(highASCII("NO OPERATING SYSTEM ON THIS DISK\r"), 0)...)
+
+func highASCII(s string) []byte {
+	b := []byte(s)
+	for i := range b {
+		b[i] |= 0x80
+	}
+	return b
+}
+
+// newDiskData returns the contents of a new disk image file whose format
+// is given by the file name's extension: a 5.25" disk image (.dsk, .do,
+// .po, .nib, .d13 or .woz), or a ProDOS block image (.po or .hdv) of any
+// size. A .po image is a 5.25" disk unless a different size is given.
+func newDiskData(filename string, opts newDiskOptions) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	blocks := opts.blocks
+	if blocks == 0 {
+		blocks = floppyBlocks
+		if ext == ".hdv" {
+			blocks = hardDiskBlocks
+		}
+	}
+	if blocks < 16 || blocks > hardDiskBlocks {
+		return nil, errDiskBlocks
+	}
+	name := opts.name
+	if name == "" {
+		name = prodosFileName(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	}
+
+	// Block images hold the volume's blocks as they are.
+	if ext == ".hdv" || ext == ".po" && blocks != floppyBlocks {
+		switch opts.fs {
+		case diskFSDOS33:
+			return nil, errDOS33Size
+		case diskFSProDOS:
+			data, err := formatProDOS(name, blocks)
+			if err == nil {
+				copy(data, diskBootBlock)
+			}
+			return data, err
+		}
+		return make([]byte, blocks*prodosBlockSize), nil
+	}
+
+	var format diskFormat
+	switch f, ok := diskFormatFromName(filename); {
+	case ok:
+		format = f
+	case ext == ".woz":
+		format = diskFormatNIB
+	default:
+		return nil, errDiskFormat
+	}
+	if blocks != floppyBlocks {
+		return nil, fmt.Errorf("%s: %w", filename, errDiskSize)
+	}
+
+	// DOS 3.2's file system isn't supported, so 13-sector disks can
+	// only be blank.
+	disk := &diskImage{format: format, volume: defaultVolume}
+	if format == diskFormatD13 {
+		if opts.fs != diskFSNone {
+			return nil, fmt.Errorf("%s: no %v file system for 13-sector disks", filename, opts.fs)
+		}
+		for t := range disk.tracks {
+			disk.tracks[t] = disk.encodeTrack13(t, make([]byte, diskTrackSize13))
+		}
+	} else {
+		data, order := make([]byte, diskImageSize), &dosSectorOrder
+		switch opts.fs {
+		case diskFSDOS33:
+			data, disk.volume = formatDOS33(opts.volume), opts.volume
+		case diskFSProDOS:
+			var err error
+			if data, err = formatProDOS(name, blocks); err != nil {
+				return nil, err
+			}
+			order = &prodosSectorOrder
+		}
+		if opts.fs != diskFSNone {
+			copy(data, diskBootBlock)
+		}
+		disk.WriteSectors(data, order)
+	}
+
+	if ext == ".woz" {
+		return wozImage(disk), nil
+	}
+	var buf bytes.Buffer
+	if err := disk.Save(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// createDiskFile creates a new disk image file. It won't replace a file
+// that already exists.
+func createDiskFile(filename string, opts newDiskOptions) error {
+	data, err := newDiskData(filename, opts)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskCreate(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) (string, int) {
+		var out bytes.Buffer
+		status, ok := runDiskCommand(append([]string{"create"}, args...), &out)
+		if !ok {
+			t.Fatalf("%v: not a disk command\n", args)
+		}
+		return out.String(), status
+	}
+	create := func(args ...string) string {
+		if out, status := run(args...); status != 0 {
+			t.Fatalf("%v: status %d: %s\n", args, status, out)
+		}
+		return args[len(args)-1]
+	}
+
+	// Formatted disks hold empty file systems and boot code.
+	for _, c := range []struct {
+		args   []string
+		prodos bool
+		size   int
+	}{
+		{[]string{"-fs", "dos33", "-volume", "17", "dos.dsk"}, false, diskImageSize},
+		{[]string{"-fs", "dos33", "dos.po"}, false, diskImageSize},
+		{[]string{"-fs", "prodos", "games.dsk"}, true, diskImageSize},
+		{[]string{"-fs", "prodos", "-size", "800K", "-name", "work", "work.po"}, true, 800 * 1024},
+		{[]string{"-fs", "prodos", "-size", "1M", "hard.hdv"}, true, 1024 * 1024},
+	} {
+		c.args[len(c.args)-1] = filepath.Join(dir, c.args[len(c.args)-1])
+		file := create(c.args...)
+		if fi, err := os.Stat(file); err != nil || fi.Size() != int64(c.size) {
+			t.Errorf("%s: unexpected size\n", file)
+		}
+		vol, err := openDiskVolume(file)
+		if err != nil {
+			t.Errorf("%s: %v\n", file, err)
+			continue
+		}
+		var boot []byte
+		if c.prodos {
+			v := vol.prodos
+			if v == nil || v.FreeBlocks() == 0 {
+				t.Errorf("%s: expected an empty ProDOS volume\n", file)
+				continue
+			}
+			boot = v.block(0)
+		} else {
+			v := vol.dos
+			if v == nil || v.FreeSectors() != 496 {
+				t.Errorf("%s: expected an empty DOS 3.3 disk\n", file)
+				continue
+			}
+			boot = v.sector(0, 0)
+		}
+		if !bytes.HasPrefix(boot, diskBootBlock) {
+			t.Errorf("%s: no boot code\n", file)
+		}
+	}
+	vol, _ := openDiskVolume(filepath.Join(dir, "dos.dsk"))
+	if vol.dos.Volume() != 17 {
+		t.Errorf("Expected volume 17, got %d\n", vol.dos.Volume())
+	}
+	vol, _ = openDiskVolume(filepath.Join(dir, "work.po"))
+	if vol.prodos.Name() != "WORK" {
+		t.Errorf("Expected /WORK, got /%s\n", vol.prodos.Name())
+	}
+
+	// Blank disks can be read, and a file is never replaced.
+	for _, name := range []string{"blank.dsk", "blank.nib", "blank.d13"} {
+		file := create(filepath.Join(dir, name))
+		disk, err := openDiskFile(file)
+		if err != nil {
+			t.Errorf("%s: %v\n", name, err)
+			continue
+		}
+		if disk.format != diskFormatD13 {
+			if data, err := disk.ReadSectors(&dosSectorOrder); err != nil || bytes.Count(data, []byte{0}) != diskImageSize {
+				t.Errorf("%s: expected zeroed sectors: %v\n", name, err)
+			}
+		}
+	}
+	for _, args := range [][]string{
+		{filepath.Join(dir, "blank.dsk")},
+		{"-fs", "dos33", filepath.Join(dir, "big.hdv")},
+		{"-fs", "dos33", filepath.Join(dir, "old.d13")},
+		{"-size", "800K", filepath.Join(dir, "big.dsk")},
+		{"-fs", "cpm", filepath.Join(dir, "cpm.dsk")},
+		{filepath.Join(dir, "disk.txt")},
+	} {
+		if out, status := run(args...); status == 0 || !strings.HasPrefix(out, "ERROR") {
+			t.Errorf("%v: expected an error\n", args)
+		}
+	}
+
+	// WOZ images have a valid header, quarter track map and track data.
+	data, _ := os.ReadFile(create("-fs", "prodos", filepath.Join(dir, "disk.woz")))
+	if len(data) < 1536 || string(data[:8]) != wozHeader ||
+		binary.LittleEndian.Uint32(data[8:]) != crc32.ChecksumIEEE(data[12:]) {
+		t.Fatalf("Invalid WOZ header\n")
+	}
+	if string(data[12:16]) != "INFO" || string(data[80:84]) != "TMAP" || string(data[248:252]) != "TRKS" {
+		t.Fatalf("Unexpected WOZ chunks\n")
+	}
+	tmap := data[88:248]
+	if tmap[0] != 0 || tmap[1] != 0 || tmap[2] != 0xff || tmap[3] != 1 || tmap[4] != 1 || tmap[140] != 0xff {
+		t.Errorf("Unexpected quarter track map % X\n", tmap[:8])
+	}
+	trk := data[256:]
+	if start := binary.LittleEndian.Uint16(trk); start != wozTrackBlock {
+		t.Errorf("Track 0 starts at block %d\n", start)
+	}
+	bits := binary.LittleEndian.Uint32(trk[4:])
+	blocks := binary.LittleEndian.Uint16(trk[2:])
+	if bits < 50000 || int(bits) > int(blocks)*4096 {
+		t.Errorf("Track 0 has %d bits in %d blocks\n", bits, blocks)
+	}
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Disk II timing.
+const (
+	cyclesPerNibble   = 32           // CPU cycles to rotate one nibble past the head
+	nibbleValidCycles = 8            // cycles a complete nibble remains in the latch
+	motorOffDelay     = cpuClockRate // cycles the motor keeps spinning after being turned off
+	maxQuarterTrack   = 4*diskTracks - 1
+)
+
+var (
+	errNoDiskController = errors.New("no disk controller installed")
+	errNoDisk           = errors.New("no disk in drive")
+	errNoDrive          = errors.New("no such drive")
+	errDiskMode         = errors.New("unknown disk mode")
+)
+
+// A diskMode says what happens to the changes written to a disk loaded
+// from a file.
+type diskMode byte
+
+const (
+	diskModeWrite    diskMode = iota // changes are saved to the file
+	diskModeReadOnly                 // changes are discarded when the disk is ejected
+	diskModeOverlay                  // changes are saved to an overlay file beside the file
+)
+
+var diskModeNames = []string{"rw", "ro", "cow"}
+
+func (m diskMode) String() string {
+	if int(m) < len(diskModeNames) {
+		return diskModeNames[m]
+	}
+	return "?"
+}
+
+// parseDiskMode parses the name of a disk mode.
+func parseDiskMode(s string) (diskMode, error) {
+	for i, name := range diskModeNames {
+		if strings.EqualFold(s, name) {
+			return diskMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", errDiskMode, s)
+}
+
+// parseDiskSpec parses a disk image file name with an optional mode
+// suffix, as in "games.dsk,ro" or "games.dsk,cow".
+func parseDiskSpec(s string) (filename string, mode diskMode) {
+	if i := strings.LastIndexByte(s, ','); i >= 0 {
+		if m, err := parseDiskMode(s[i+1:]); err == nil {
+			return s[:i], m
+		}
+	}
+	return s, diskModeWrite
+}
+
+// A diskDrive is one of the two 5.25" drives attached to a Disk II
+// controller.
+type diskDrive struct {
+	disk         *diskImage
+	filename     string   // file the disk was loaded from, or "" if none
+	mode         diskMode // what happens to changes to the disk
+	quarterTrack int      // head position in quarter tracks
+	writePos     int      // next nibble written in the current write session
+	read         bool     // nibbles were read during the current frame
+	written      bool     // nibbles were written during the current frame
+}
+
+// A diskII is a Disk II controller card with two drives. The controller
+// is modeled at the nibble level: reading the data latch returns the
+// nibble currently passing under the head, and writes replace nibbles on
+// the track.
+type diskII struct {
+	apple2 *apple2
+
+	rom        []byte // P5 boot ROM
+	drives     [2]diskDrive
+	active     int    // selected drive
+	phases     byte   // bitmask of energized stepper phases
+	motorOn    bool   // true = motor switched on
+	motorOffAt uint64 // CPU cycle at which a switched-off motor stops
+	q6, q7     bool   // sequencer mode switches
+	latch      byte   // value loaded for writing
+	writing    bool   // true = a write session is in progress
+
+	pending   []func() error // host disk changes waiting for a write session to end
+	changeErr error          // error from a pending change, returned by Flush
+
+	observers []driveObserver
+	status    [2]driveStatus // status last sent to the observers
+	sound     diskSound
+}
+
+func newDiskII(apple2 *apple2, rom []byte) *diskII {
+	d := &diskII{
+		apple2: apple2,
+		rom:    rom,
+		sound:  newDiskSound(),
+	}
+	for i := range d.status {
+		d.status[i].drive = i + 1
+	}
+	return d
+}
+
+// InsertDisk loads a disk image file into a drive. Changes written to the
+// disk are saved back to the file when the disk is ejected.
+func (d *diskII) InsertDisk(drive int, filename string) error {
+	disk, err := loadDiskFile(filename, diskModeWrite)
+	if err != nil {
+		return err
+	}
+	return d.insert(drive, disk, filename, diskModeWrite)
+}
+
+// loadDiskFile loads a disk image file to be used in the given mode. In
+// overlay mode, the changes saved in the file's overlay are applied to
+// the disk. Otherwise, files the host won't let us write are loaded as
+// write-protected disks.
+func loadDiskFile(filename string, mode diskMode) (*diskImage, error) {
+	format, ok := diskFormatFromName(filename)
+	if !ok {
+		return nil, errDiskFormat
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	disk, err := loadDiskImage(file, format)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case diskModeOverlay:
+		if err := loadDiskOverlay(disk, filename); err != nil {
+			return nil, err
+		}
+	case diskModeWrite:
+		if fi, err := file.Stat(); err == nil && fi.Mode().Perm()&0222 == 0 {
+			disk.writeProtected = true
+		}
+	}
+	return disk, nil
+}
+
+// insert places a disk image into a drive. Changes to the disk are saved
+// to the named file, as the mode says, unless filename is "".
+func (d *diskII) insert(drive int, disk *diskImage, filename string, mode diskMode) error {
+	if err := d.EjectDisk(drive); err != nil {
+		return err
+	}
+	d.drives[drive].disk = disk
+	d.drives[drive].filename = filename
+	d.drives[drive].mode = mode
+	d.apple2.log.Logger(logDisk).Debug("disk inserted", "drive", drive+1, "file", filename, "mode", mode.String())
+	if ev := d.apple2.ev; ev.active() {
+		ev.send(machineEvent{kind: eventDiskInserted, drive: drive + 1, file: filename})
+	}
+	return nil
+}
+
+// EjectDisk removes the disk from a drive, saving it first if it was
+// modified. If the disk can't be saved, it stays in the drive.
+func (d *diskII) EjectDisk(drive int) error {
+	dr := &d.drives[drive]
+	if dr.disk == nil {
+		return nil
+	}
+
+	if err := d.flush(dr); err != nil {
+		return err
+	}
+	d.apple2.log.Logger(logDisk).Debug("disk ejected", "drive", drive+1, "file", dr.filename)
+	if ev := d.apple2.ev; ev.active() {
+		ev.send(machineEvent{kind: eventDiskEjected, drive: drive + 1, file: dr.filename})
+	}
+	dr.disk, dr.filename = nil, ""
+	return nil
+}
+
+// SwapDisks exchanges the disks in the two drives. The heads stay where
+// they are.
+func (d *diskII) SwapDisks() {
+	a, b := &d.drives[0], &d.drives[1]
+	a.disk, b.disk = b.disk, a.disk
+	a.filename, b.filename = b.filename, a.filename
+	a.mode, b.mode = b.mode, a.mode
+}
+
+// SetWriteProtect covers or uncovers the write-protect notch of the disk
+// in a drive.
+func (d *diskII) SetWriteProtect(drive int, on bool) error {
+	disk := d.drives[drive].disk
+	if disk == nil {
+		return errNoDisk
+	}
+	disk.writeProtected = on
+	return nil
+}
+
+// change makes a host change to the disks in the drives. A change made
+// while a write session is in progress waits until the session ends, so
+// that the sector being written isn't cut short; its error is returned
+// by the next call to Flush.
+func (d *diskII) change(fn func() error) error {
+	if d.writing {
+		d.pending = append(d.pending, fn)
+		return nil
+	}
+	return fn()
+}
+
+// applyChanges makes the pending disk changes if no write session is in
+// progress. It is called at the start of each frame.
+func (d *diskII) applyChanges() {
+	if d.writing || len(d.pending) == 0 {
+		return
+	}
+	for _, fn := range d.pending {
+		err := fn()
+		if err != nil {
+			d.apple2.log.Logger(logDisk).Warn("disk change failed", "err", err)
+		}
+		if err != nil && d.changeErr == nil {
+			d.changeErr = err
+		}
+	}
+	d.pending = nil
+}
+
+// Flush saves modified disks to their files, or to their overlays.
+func (d *diskII) Flush() error {
+	if err := d.changeErr; err != nil {
+		d.changeErr = nil
+		return err
+	}
+	for i := range d.drives {
+		if err := d.flush(&d.drives[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *diskII) flush(dr *diskDrive) error {
+	if dr.disk == nil || !dr.disk.dirty || dr.filename == "" {
+		return nil
+	}
+
+	switch dr.mode {
+	case diskModeReadOnly:
+		return nil
+	case diskModeOverlay:
+		err := saveDiskOverlay(dr.disk, dr.filename)
+		if err == nil {
+			dr.disk.dirty = false
+		}
+		return err
+	}
+
+	file, err := os.Create(dr.filename)
+	if err != nil {
+		return err
+	}
+	err = dr.disk.Save(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		dr.disk.dirty = false
+	}
+	return err
+}
+
+// Reset clears the controller's switches, as the RESET line does: the
+// motor stops, the stepper phases are released and the sequencer returns
+// to reading.
+func (d *diskII) Reset() {
+	d.motorOn, d.motorOffAt = false, 0
+	d.phases = 0
+	d.q6, d.q7 = false, false
+	d.writing = false
+}
+
+// MotorOn reports whether the drive motor is spinning.
+func (d *diskII) MotorOn() bool {
+	return d.motorOn || d.apple2.sched.Now() < d.motorOffAt
+}
+
+func (d *diskII) LoadIO(addr uint16) byte {
+	d.access(addr)
+
+	// Only even addresses drive the data bus with the latch.
+	if addr&1 != 0 {
+		return d.apple2.vs.FloatingBus()
+	}
+
+	switch {
+	case !d.q6 && !d.q7:
+		return d.readNibble()
+	case d.q6 && !d.q7:
+		// Sense the write-protect switch.
+		if disk := d.drives[d.active].disk; disk != nil && disk.writeProtected {
+			return 0x80
+		}
+		return 0x00
+	}
+	return d.latch
+}
+
+func (d *diskII) StoreIO(addr uint16, v byte) {
+	d.access(addr)
+
+	if d.q6 && d.q7 {
+		d.latch = v
+		d.writeNibble()
+	}
+}
+
+func (d *diskII) LoadROM(addr uint16) byte {
+	if d.rom == nil {
+		return d.apple2.vs.FloatingBus()
+	}
+	return d.rom[addr]
+}
+
+func (d *diskII) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// access updates the controller switches addressed by a read or write
+// of $C0n0..$C0nF.
+func (d *diskII) access(addr uint16) {
+	on := addr&1 != 0
+	switch addr >> 1 {
+	case 0, 1, 2, 3:
+		d.step(int(addr>>1), on)
+	case 4:
+		if d.motorOn && !on {
+			d.motorOffAt = d.apple2.sched.Now() + motorOffDelay
+		}
+		if d.motorOn != on && d.apple2.log.Enabled(logDisk, slog.LevelDebug) {
+			d.apple2.log.Logger(logDisk).Debug("motor switched", "drive", d.active+1, "on", on)
+		}
+		d.motorOn = on
+	case 5:
+		d.active = int(addr & 1)
+	case 6:
+		d.q6 = on
+	case 7:
+		d.q7 = on
+		if !on {
+			d.writing = false
+		}
+	}
+}
+
+// The four stepper phases lie around a circle that the head travels once
+// every two tracks. Phase n pulls the head toward half track n modulo 4,
+// and two adjacent phases together hold it at the quarter track between
+// them. phaseDirections gives the quarter track modulo 8 toward which
+// the energized magnets pull, indexed by the sum of their unit vectors
+// around the circle; the pull of opposite phases cancels out.
+var (
+	phaseVectors    = [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+	phaseDirections = [3][3]int{
+		{5, 4, 3},  // x = -1
+		{6, -1, 2}, // x = 0
+		{7, 0, 1},  // x = 1
+	}
+)
+
+// step energizes or releases a stepper motor phase, and lets the magnets
+// pull the head of the active drive.
+func (d *diskII) step(phase int, on bool) {
+	if on {
+		d.phases |= 1 << uint(phase)
+	} else {
+		d.phases &^= 1 << uint(phase)
+	}
+
+	var x, y int
+	for p, v := range phaseVectors {
+		if d.phases&(1<<uint(p)) != 0 {
+			x, y = x+v[0], y+v[1]
+		}
+	}
+	dir := phaseDirections[x+1][y+1]
+	if dir < 0 {
+		return
+	}
+
+	// The head moves up to three quarter tracks toward the pull; a pull
+	// from directly opposite leaves it where it is.
+	dr := &d.drives[d.active]
+	delta := (dir - dr.quarterTrack) & 7
+	if delta > 4 {
+		delta -= 8
+	}
+	if delta == 0 || delta == 4 {
+		return
+	}
+	dr.quarterTrack += delta
+	d.sound.Step(d.apple2.sched.Now())
+	if dr.quarterTrack < 0 {
+		dr.quarterTrack = 0
+	}
+	if dr.quarterTrack > maxQuarterTrack {
+		dr.quarterTrack = maxQuarterTrack
+	}
+	if d.apple2.log.Enabled(logDisk, levelTrace) {
+		d.apple2.log.Logger(logDisk).Log(context.Background(), levelTrace, "head moved",
+			"drive", d.active+1, "track", float64(dr.quarterTrack)/4)
+	}
+}
+
+// spinning returns the drive whose disk is spinning under the head, or
+// nil if none is.
+func (d *diskII) spinning() *diskDrive {
+	dr := &d.drives[d.active]
+	if dr.disk == nil || !d.MotorOn() {
+		return nil
+	}
+	return dr
+}
+
+// noiseNibble returns what the controller reads where nothing is
+// recorded: the read amplifier turns noise into random bits.
+func noiseNibble(cycles uint64) byte {
+	x := (cycles / 4) * 0x9e3779b97f4a7c15
+	return byte(x >> 56)
+}
+
+// readNibble returns the contents of the data latch in read mode. The
+// disk rotates continuously, so the nibble under the head depends on the
+// current CPU cycle. A nibble has its high bit set only briefly after it
+// has been shifted in completely.
+func (d *diskII) readNibble() byte {
+	dr := d.spinning()
+	if dr == nil {
+		return 0
+	}
+
+	dr.read = true
+	cycles := d.apple2.sched.Now()
+	track := dr.disk.trackAt(dr.quarterTrack)
+	if track == nil {
+		return noiseNibble(cycles)
+	}
+	n := track[(cycles/cyclesPerNibble)%uint64(len(track))]
+	if cycles%cyclesPerNibble >= nibbleValidCycles {
+		n &= 0x7f
+	}
+	return n
+}
+
+// writeNibble writes the latch to the track. The first nibble of a write
+// session is placed under the head, and subsequent nibbles follow it.
+// Writing where nothing can be read records a new track there.
+func (d *diskII) writeNibble() {
+	dr := d.spinning()
+	if dr == nil || dr.disk.writeProtected {
+		return
+	}
+	track := dr.disk.recordAt(dr.quarterTrack)
+
+	if !d.writing {
+		d.writing = true
+		dr.writePos = int((d.apple2.sched.Now() / cyclesPerNibble) % uint64(len(track)))
+	}
+	track[dr.writePos] = d.latch
+	dr.writePos = (dr.writePos + 1) % len(track)
+	dr.disk.dirty = true
+	dr.written = true
+}
+
+// diskController returns the Disk II controller in slot 6, or the IIc's
+// built-in controller.
+func (a *apple2) diskController() (*diskII, error) {
+	if d, ok := a.sm.Card(6).(*diskII); ok {
+		return d, nil
+	}
+	return nil, errNoDiskController
+}
+
+// hostDiskController returns the disk controller for a host change to
+// the disk in drive 1 or 2. Host disk changes are ignored while recorded
+// input is being replayed, in which case it returns nil.
+func (a *apple2) hostDiskController(drive int) (*diskII, error) {
+	if a.in.Replaying() {
+		return nil, nil
+	}
+	d, err := a.diskController()
+	if err != nil {
+		return nil, err
+	}
+	if drive < 1 || drive > len(d.drives) {
+		return nil, errNoDrive
+	}
+	return d, nil
+}
+
+// InsertDisk loads a disk image file into drive 1 or 2 of the disk
+// controller in slot 6, ejecting the disk already there. Disks can be
+// changed while the machine runs; if the controller is writing, the
+// change waits for the write to finish.
+func (a *apple2) InsertDisk(drive int, filename string) error {
+	return a.InsertDiskMode(drive, filename, diskModeWrite)
+}
+
+// InsertDiskMode is like InsertDisk, but says what happens to the changes
+// written to the disk: they can be saved to the file, discarded, or saved
+// to an overlay file so that the file stays as it was.
+func (a *apple2) InsertDiskMode(drive int, filename string, mode diskMode) error {
+	d, err := a.hostDiskController(drive)
+	if d == nil || err != nil {
+		return err
+	}
+	disk, err := loadDiskFile(filename, mode)
+	if err != nil {
+		return err
+	}
+	return a.changeDisk(d, drive, disk, filename, mode)
+}
+
+// InsertDiskData is like InsertDisk, but for a disk image held in memory
+// rather than a file, in the format given by the extension of its name.
+// It serves front ends with no host file system, such as the browser's.
+// Changes written to the disk are lost when it's ejected.
+func (a *apple2) InsertDiskData(drive int, name string, data []byte) error {
+	d, err := a.hostDiskController(drive)
+	if d == nil || err != nil {
+		return err
+	}
+	var disk *diskImage
+	if strings.EqualFold(filepath.Ext(name), ".woz") {
+		disk, err = loadWOZ(data)
+	} else if format, ok := diskFormatFromName(name); ok {
+		disk, err = loadDiskImage(bytes.NewReader(data), format)
+	} else {
+		err = errDiskFormat
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return a.changeDisk(d, drive, disk, name, diskModeReadOnly)
+}
+
+// changeDisk inserts a disk into drive 1 or 2 once the controller isn't
+// writing.
+func (a *apple2) changeDisk(d *diskII, drive int, disk *diskImage, filename string, mode diskMode) error {
+	return d.change(func() error {
+		if err := d.insert(drive-1, disk, filename, mode); err != nil {
+			return err
+		}
+		a.in.RecordDiskInsert(drive, disk)
+		return nil
+	})
+}
+
+// EjectDisk removes the disk from drive 1 or 2 of the disk controller in
+// slot 6, saving it first if it was modified.
+func (a *apple2) EjectDisk(drive int) error {
+	d, err := a.hostDiskController(drive)
+	if d == nil || err != nil {
+		return err
+	}
+	return d.change(func() error {
+		if err := d.EjectDisk(drive - 1); err != nil {
+			return err
+		}
+		a.in.RecordDiskEject(drive)
+		return nil
+	})
+}
+
+// SwapDisks exchanges the disks in drives 1 and 2 of the disk controller
+// in slot 6, for programs that expect a disk in drive 1 that was inserted
+// in drive 2.
+func (a *apple2) SwapDisks() error {
+	d, err := a.hostDiskController(1)
+	if d == nil || err != nil {
+		return err
+	}
+	return d.change(func() error {
+		d.SwapDisks()
+		for i, dr := range d.drives {
+			if dr.disk != nil {
+				a.in.RecordDiskInsert(i+1, dr.disk)
+			} else {
+				a.in.RecordDiskEject(i + 1)
+			}
+		}
+		return nil
+	})
+}
+
+// SetWriteProtect covers or uncovers the write-protect notch of the disk
+// in drive 1 or 2 of the disk controller in slot 6.
+func (a *apple2) SetWriteProtect(drive int, on bool) error {
+	d, err := a.hostDiskController(drive)
+	if d == nil || err != nil {
+		return err
+	}
+	return d.change(func() error {
+		if err := d.SetWriteProtect(drive-1, on); err != nil {
+			return err
+		}
+		a.in.RecordDiskInsert(drive, d.drives[drive-1].disk)
+		return nil
+	})
+}
+
+// MountedDisk returns the disk in drive 1 or 2 of the disk controller in
+// slot 6, so that its files can be examined and changed with ReadSectors
+// and WriteSectors.
+func (a *apple2) MountedDisk(drive int) (*diskImage, error) {
+	d, err := a.diskController()
+	if err != nil {
+		return nil, err
+	}
+	if drive < 1 || drive > len(d.drives) || d.drives[drive-1].disk == nil {
+		return nil, errNoDisk
+	}
+	return d.drives[drive-1].disk, nil
+}
+
+func (d *diskII) saveState(sw *stateWriter) {
+	sw.Tag("DSK2")
+	sw.Int(d.active)
+	sw.Byte(d.phases)
+	sw.Bool(d.motorOn)
+	sw.Uint64(d.motorOffAt)
+	sw.Bool(d.q6)
+	sw.Bool(d.q7)
+	sw.Byte(d.latch)
+	sw.Bool(d.writing)
+
+	for i := range d.drives {
+		dr := &d.drives[i]
+		sw.Int(dr.quarterTrack)
+		sw.Int(dr.writePos)
+		sw.Bool(dr.disk != nil)
+		if dr.disk == nil {
+			continue
+		}
+		sw.String(dr.filename)
+		sw.Byte(byte(dr.mode))
+		sw.Byte(byte(dr.disk.format))
+		sw.Byte(dr.disk.volume)
+		sw.Bool(dr.disk.writeProtected)
+		sw.Bool(dr.disk.dirty)
+		for _, track := range dr.disk.tracks {
+			sw.Bytes(track)
+		}
+		quarters := dr.disk.quarterTracks()
+		sw.Int(len(quarters))
+		for _, qt := range quarters {
+			sw.Int(qt)
+			sw.Bytes(dr.disk.quarters[qt])
+		}
+	}
+}
+
+// loadState restores the controller and the disks in its drives. Disks
+// currently in the drives are saved to their files first, and the disks
+// from the snapshot replace them.
+func (d *diskII) loadState(sr *stateReader) {
+	sr.Tag("DSK2")
+	d.active = sr.Int() & 1
+	d.phases = sr.Byte()
+	d.motorOn = sr.Bool()
+	d.motorOffAt = sr.Uint64()
+	d.q6 = sr.Bool()
+	d.q7 = sr.Bool()
+	d.latch = sr.Byte()
+	d.writing = sr.Bool()
+
+	for i := range d.drives {
+		quarterTrack, writePos := sr.Int(), sr.Int()
+		var disk *diskImage
+		var filename string
+		var mode diskMode
+		if sr.Bool() {
+			filename = sr.String()
+			mode = diskMode(sr.Byte())
+			disk = &diskImage{
+				format:         diskFormat(sr.Byte()),
+				volume:         sr.Byte(),
+				writeProtected: sr.Bool(),
+				dirty:          sr.Bool(),
+			}
+			for t := range disk.tracks {
+				disk.tracks[t] = sr.Bytes()
+				if sr.Err() == nil && len(disk.tracks[t]) == 0 {
+					sr.Fail(errStateCorrupt)
+				}
+			}
+			n := sr.Int()
+			if sr.Err() == nil && (n < 0 || n > maxQuarterTrack) {
+				sr.Fail(errStateCorrupt)
+			}
+			for j := 0; j < n && sr.Err() == nil; j++ {
+				if disk.quarters == nil {
+					disk.quarters = make(map[int][]byte)
+				}
+				qt, track := sr.Int(), sr.Bytes()
+				if sr.Err() == nil && (qt <= 0 || qt > maxQuarterTrack || qt%4 == 0 || len(track) == 0) {
+					sr.Fail(errStateCorrupt)
+				}
+				disk.quarters[qt] = track
+			}
+		}
+		if sr.Err() != nil {
+			return
+		}
+		if quarterTrack < 0 || quarterTrack > maxQuarterTrack || writePos < 0 || int(mode) >= len(diskModeNames) {
+			sr.Fail(errStateCorrupt)
+			return
+		}
+
+		if err := d.EjectDisk(i); err != nil {
+			sr.Fail(err)
+			return
+		}
+		dr := &d.drives[i]
+		dr.disk, dr.filename, dr.mode = disk, filename, mode
+		dr.quarterTrack = quarterTrack
+		dr.writePos = writePos
+		if disk != nil {
+			if track := disk.trackAt(quarterTrack); track != nil {
+				dr.writePos %= len(track)
+			} else {
+				dr.writePos = 0
+			}
+		}
+	}
+}
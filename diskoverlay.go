@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A disk overlay holds the changes written to a disk that was inserted in
+// overlay (copy-on-write) mode, so that the disk image file itself is
+// never written. The overlay is kept beside the image, in a file named
+// after it with an ".overlay" suffix. It begins with a magic string and a
+// version, and then holds the nibbles of each track that differs from the
+// image: the track number as a byte, the number of nibbles as a 16-bit
+// little-endian value, and the nibbles.
+const (
+	overlayMagic   = "A2GOOVLY"
+	overlayVersion = 1
+	overlaySuffix  = ".overlay"
+)
+
+var errDiskOverlay = errors.New("disk overlay is corrupt")
+
+// overlayFile returns the name of the overlay of a disk image file.
+func overlayFile(filename string) string {
+	return filename + overlaySuffix
+}
+
+// loadDiskOverlay applies the overlay of a disk image file, if it has
+// one, to the disk loaded from it.
+func loadDiskOverlay(disk *diskImage, filename string) error {
+	file, err := os.Open(overlayFile(filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var hdr [len(overlayMagic) + 2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[:len(overlayMagic)]) != overlayMagic {
+		return fmt.Errorf("%s: %w", file.Name(), errDiskOverlay)
+	}
+	if v := binary.LittleEndian.Uint16(hdr[len(overlayMagic):]); v != overlayVersion {
+		return fmt.Errorf("%s: unsupported overlay version %d", file.Name(), v)
+	}
+
+	for {
+		t, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		var n uint16
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, &n)
+		}
+		if err != nil || int(t) >= diskTracks || n == 0 {
+			return fmt.Errorf("%s: %w", file.Name(), errDiskOverlay)
+		}
+		track := make([]byte, n)
+		if _, err := io.ReadFull(r, track); err != nil {
+			return fmt.Errorf("%s: %w", file.Name(), errDiskOverlay)
+		}
+		disk.tracks[t] = track
+	}
+}
+
+// saveDiskOverlay saves the tracks of a disk that differ from the disk
+// image file it was loaded from to the file's overlay. If no track
+// differs, the overlay is removed.
+func saveDiskOverlay(disk *diskImage, filename string) error {
+	base, err := loadDiskFile(filename, diskModeReadOnly)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(overlayMagic)
+	binary.Write(&buf, binary.LittleEndian, uint16(overlayVersion))
+	changed := false
+	for t, track := range disk.tracks {
+		if bytes.Equal(track, base.tracks[t]) {
+			continue
+		}
+		buf.WriteByte(byte(t))
+		binary.Write(&buf, binary.LittleEndian, uint16(len(track)))
+		buf.Write(track)
+		changed = true
+	}
+
+	if !changed {
+		err := os.Remove(overlayFile(filename))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	return os.WriteFile(overlayFile(filename), buf.Bytes(), 0644)
+}
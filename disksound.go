@@ -0,0 +1,115 @@
+package main
+
+import "math"
+
+// Disk drive sounds. Each time a stepper phase pulls the head, the drive
+// clunks: a short, decaying thump with some rattle. When the head is
+// pulled against the stop at track 0, as it is while DOS recalibrates,
+// the clunks run together into the familiar grinding. While the motor
+// runs, the drive hums quietly.
+const (
+	clunkCycles = cpuClockRate / 40  // length of a clunk
+	clunkDecay  = cpuClockRate / 400 // time constant of a clunk's decay
+	clunkHz     = 180.0              // pitch of a clunk's thump
+	clunkLevel  = 0.25
+	humHz       = 60.0   // pitch of the motor hum
+	humLevel    = 0.03   // level of the motor hum
+	humRampHz   = 8.0    // rate at which the motor spins up and down
+	diskSoundHP = 40.0   // high-pass cutoff that removes the DC offset
+	noiseSeed   = 0x1234 // initial state of the noise generator
+)
+
+// A diskSound synthesizes the sounds of a Disk II drive from the stepper
+// and motor activity of the controller.
+type diskSound struct {
+	enabled bool
+	steps   []uint64 // CPU cycles at which the head was pulled
+	clunk   float64  // CPU cycle at which the last clunk began, or -1
+	hum     float64  // current level of the motor hum (0..1)
+	phase   float64  // phase of the hum (0..1)
+	noise   uint32   // state of the noise generator
+	hp      highPassFilter
+}
+
+func newDiskSound() diskSound {
+	return diskSound{
+		enabled: true,
+		clunk:   -1,
+		noise:   noiseSeed,
+		hp:      newHighPassFilter(diskSoundHP),
+	}
+}
+
+// Step records the head being pulled by a stepper phase.
+func (s *diskSound) Step(cycle uint64) {
+	if s.enabled {
+		s.steps = append(s.steps, cycle)
+	}
+}
+
+// render mixes the drive sounds for the span of cycles starting at start
+// into buf. The motor is on or off for the whole span.
+func (s *diskSound) render(buf []float32, start float64, motorOn bool) {
+	if !s.enabled {
+		s.steps = s.steps[:0]
+		return
+	}
+
+	target := 0.0
+	if motorOn {
+		target = 1
+	}
+	rampAlpha := onePoleAlpha(humRampHz)
+
+	i := 0
+	t0 := start
+	for n := range buf {
+		t1 := t0 + cyclesPerSample
+		for ; i < len(s.steps) && float64(s.steps[i]) < t1; i++ {
+			s.clunk = float64(s.steps[i])
+		}
+
+		var v float64
+		if s.clunk >= 0 {
+			age := t1 - s.clunk
+			if age < clunkCycles {
+				thump := math.Sin(2 * math.Pi * clunkHz * age / cpuClockRate)
+				v += clunkLevel * math.Exp(-age/clunkDecay) * (0.7*thump + 0.3*s.nextNoise())
+			} else {
+				s.clunk = -1
+			}
+		}
+
+		s.hum += rampAlpha * (target - s.hum)
+		if s.hum > 1e-4 {
+			s.phase += humHz / audioSampleRate
+			s.phase -= math.Floor(s.phase)
+			hum := 0.6*math.Sin(2*math.Pi*s.phase) + 0.4*s.nextNoise()
+			v += humLevel * s.hum * hum
+		}
+
+		buf[n] += float32(s.hp.Filter(v))
+		t0 = t1
+	}
+
+	s.steps = s.steps[:copy(s.steps, s.steps[i:])]
+}
+
+// nextNoise returns the next value of a white noise generator, in the
+// range -1..1.
+func (s *diskSound) nextNoise() float64 {
+	s.noise ^= s.noise << 13
+	s.noise ^= s.noise >> 17
+	s.noise ^= s.noise << 5
+	return float64(s.noise)/(1<<31) - 1
+}
+
+// RenderSamples mixes the sounds of the drives into buf.
+func (d *diskII) RenderSamples(buf []float32, start float64) {
+	d.sound.render(buf, start, d.MotorOn())
+}
+
+// SetSound turns the drive sounds on or off.
+func (d *diskII) SetSound(on bool) {
+	d.sound.enabled = on
+}
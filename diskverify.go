@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A sectorStatus is the result of checking a sector on a 5.25" disk the
+// way a disk copier's verify pass does: by finding its address field and
+// checking the checksums of both fields.
+type sectorStatus byte
+
+const (
+	sectorOK         sectorStatus = iota
+	sectorMissing                 // no address field for the sector
+	sectorBadAddress              // the address field's checksum is wrong
+	sectorNoData                  // no data field follows the address field
+	sectorBadData                 // the data field's checksum is wrong
+)
+
+var sectorStatusNames = []string{
+	/* sectorOK         */ "OK",
+	/* sectorMissing    */ "address field not found",
+	/* sectorBadAddress */ "bad address field checksum",
+	/* sectorNoData     */ "data field not found",
+	/* sectorBadData    */ "bad data field checksum",
+}
+
+func (s sectorStatus) String() string {
+	return sectorStatusNames[s]
+}
+
+// A trackCheck holds the status and contents of each sector of a track,
+// in the physical order of the sector numbers in the address fields.
+type trackCheck struct {
+	status []sectorStatus
+	data   [][]byte
+}
+
+// checkTrack checks the sectors of a 16- or 13-sector track. A sector
+// that appears more than once is checked at its first good copy.
+func checkTrack(t int, nib []byte, is13 bool) trackCheck {
+	sectors, prologue := diskSectors, byte(0x96)
+	if is13 {
+		sectors, prologue = diskSectors13, 0xb5
+	}
+	c := trackCheck{
+		status: make([]sectorStatus, sectors),
+		data:   make([][]byte, sectors),
+	}
+	for s := range c.status {
+		c.status[s] = sectorMissing
+	}
+
+	n := len(nib)
+	for i := 0; i < n; i++ {
+		if !matchNibbles(nib, i, 0xd5, 0xaa, prologue) {
+			continue
+		}
+		hdr := i + 3
+		field := func(k int) byte { return decode4and4(nib[(hdr+2*k)%n], nib[(hdr+2*k+1)%n]) }
+		vol, trk, sec, sum := field(0), field(1), int(field(2)), field(3)
+		if sec >= sectors || c.status[sec] == sectorOK {
+			continue
+		}
+		if vol^trk^byte(sec) != sum || int(trk) != t {
+			if c.status[sec] == sectorMissing {
+				c.status[sec] = sectorBadAddress
+			}
+			continue
+		}
+
+		status := sectorNoData
+		for j := hdr + 8; j < hdr+8+48; j++ {
+			if !matchNibbles(nib, j, 0xd5, 0xaa, 0xad) {
+				continue
+			}
+			data := make([]byte, diskSectorSize)
+			ok := false
+			if is13 {
+				ok = decode5and3(data, nib, j+3)
+			} else {
+				ok = decode6and2(data, nib, j+3)
+			}
+			status = sectorBadData
+			if ok {
+				status = sectorOK
+				c.data[sec] = data
+			}
+			break
+		}
+		if status > c.status[sec] || status == sectorOK {
+			c.status[sec] = status
+		}
+	}
+	return c
+}
+
+// A sectorError is a sector that failed verification.
+type sectorError struct {
+	track, sector int
+	status        sectorStatus
+}
+
+func (e sectorError) String() string {
+	return fmt.Sprintf("track %d sector %d: %v", e.track, e.sector, e.status)
+}
+
+// verifyDisk checks every sector of a disk, and returns the number of
+// sectors and those that failed.
+func verifyDisk(disk *diskImage) (sectors int, errs []sectorError) {
+	is13 := sectors13(disk)
+	for t, nib := range disk.tracks {
+		c := checkTrack(t, nib, is13)
+		for s, status := range c.status {
+			if status != sectorOK {
+				errs = append(errs, sectorError{t, s, status})
+			}
+		}
+		sectors += len(c.status)
+	}
+	return sectors, errs
+}
+
+// A diskDiff is a difference between two disks.
+type diskDiff struct {
+	where string // "track 3 sector 5", "track 3" or "block 12"
+	what  string
+}
+
+func (d diskDiff) String() string {
+	return d.where + ": " + d.what
+}
+
+// compareDisks compares the sectors, or with nibbles set, the nibbles of
+// two disks, and returns their differences. Larger volumes are compared
+// block by block.
+func compareDisks(a, b diskContents, nibbles bool) ([]diskDiff, error) {
+	if a.disk == nil || b.disk == nil {
+		x, err := a.volumeBlocks()
+		if err != nil {
+			return nil, err
+		}
+		y, err := b.volumeBlocks()
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != len(y) {
+			return []diskDiff{{"volume", fmt.Sprintf("%d blocks in the first disk, %d in the second", len(x)/prodosBlockSize, len(y)/prodosBlockSize)}}, nil
+		}
+		var diffs []diskDiff
+		for i := 0; i < len(x); i += prodosBlockSize {
+			if d := compareBytes(x[i:i+prodosBlockSize], y[i:i+prodosBlockSize]); d != "" {
+				diffs = append(diffs, diskDiff{fmt.Sprintf("block %d", i/prodosBlockSize), d})
+			}
+		}
+		return diffs, nil
+	}
+
+	var diffs []diskDiff
+	x, y := a.disk, b.disk
+	if nibbles {
+		for t := range x.tracks {
+			if d := compareBytes(x.tracks[t], y.tracks[t]); d != "" {
+				diffs = append(diffs, diskDiff{fmt.Sprintf("track %d", t), d})
+			}
+		}
+		return diffs, nil
+	}
+
+	is13 := sectors13(x)
+	if sectors13(y) != is13 {
+		return nil, errSectorCount
+	}
+	for t := range x.tracks {
+		cx, cy := checkTrack(t, x.tracks[t], is13), checkTrack(t, y.tracks[t], is13)
+		for s := range cx.status {
+			where := fmt.Sprintf("track %d sector %d", t, s)
+			switch sx, sy := cx.status[s], cy.status[s]; {
+			case sx != sectorOK || sy != sectorOK:
+				if sx != sy {
+					diffs = append(diffs, diskDiff{where, fmt.Sprintf("%v in the first disk, %v in the second", sx, sy)})
+				}
+			default:
+				if d := compareBytes(cx.data[s], cy.data[s]); d != "" {
+					diffs = append(diffs, diskDiff{where, d})
+				}
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// compareBytes describes the differences between two byte slices, or
+// returns "" if they're equal.
+func compareBytes(x, y []byte) string {
+	if bytes.Equal(x, y) {
+		return ""
+	}
+	count, first := 0, -1
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			if first < 0 {
+				first = i
+			}
+			count++
+		}
+	}
+	if len(x) != len(y) {
+		if first < 0 {
+			first = len(x)
+			if len(y) < first {
+				first = len(y)
+			}
+		}
+		return fmt.Sprintf("lengths %d and %d, first difference at $%04X", len(x), len(y), first)
+	}
+	return fmt.Sprintf("%d bytes differ, first at $%04X", count, first)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskVerify(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.nib")
+	if err := createDiskFile(file, newDiskOptions{fs: diskFSDOS33, volume: defaultVolume}); err != nil {
+		t.Fatal(err)
+	}
+	disk, _ := openDiskFile(file)
+	if sectors, errs := verifyDisk(disk); sectors != diskTracks*diskSectors || len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v\n", errs)
+	}
+
+	// Corrupt the data field of one sector and the address field of
+	// another, as a faulty write would.
+	nib := disk.tracks[2]
+	var fields []int
+	for i := 0; i+2 < len(nib); i++ {
+		if matchNibbles(nib, i, 0xd5, 0xaa, 0x96) || matchNibbles(nib, i, 0xd5, 0xaa, 0xad) {
+			fields = append(fields, i)
+		}
+	}
+	nib[fields[1]+100] ^= 0x01 // data field of sector 0
+	nib[fields[2]+9] = 0xaa    // address field checksum of sector 1
+	_, errs := verifyDisk(disk)
+	want := []sectorError{{2, 0, sectorBadData}, {2, 1, sectorBadAddress}}
+	if len(errs) != 2 || errs[0] != want[0] || errs[1] != want[1] {
+		t.Errorf("Expected %v, got %v\n", want, errs)
+	}
+	bad := filepath.Join(dir, "b.nib")
+	var buf bytes.Buffer
+	disk.Save(&buf)
+	os.WriteFile(bad, buf.Bytes(), 0644)
+
+	var out bytes.Buffer
+	if status, _ := runDiskCommand([]string{"verify", bad}, &out); status != 1 || !strings.Contains(out.String(), "558 of 560 sectors OK") {
+		t.Errorf("Unexpected output %q\n", out.String())
+	}
+
+	a, _ := readDiskContents(file)
+	b, _ := readDiskContents(bad)
+	diffs, err := compareDisks(a, b, false)
+	if err != nil || len(diffs) != 2 || diffs[0].where != "track 2 sector 0" {
+		t.Errorf("Unexpected sector differences %v %v\n", diffs, err)
+	}
+	diffs, err = compareDisks(a, b, true)
+	if err != nil || len(diffs) != 1 || diffs[0].String() != fmt.Sprintf("track 2: 2 bytes differ, first at $%04X", fields[1]+100) {
+		t.Errorf("Unexpected nibble differences %v %v\n", diffs, err)
+	}
+
+	// The same disk in another format has the same sectors.
+	dsk := filepath.Join(dir, "a.dsk")
+	if err := convertDiskFile(file, dsk, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if status, _ := runDiskCommand([]string{"compare", file, dsk}, &out); status != 0 {
+		t.Errorf("Unexpected output %q\n", out.String())
+	}
+}
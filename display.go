@@ -1,7 +1,470 @@
 package main
 
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+const (
+	displayWidth  = 560 // framebuffer width in pixels (80-column resolution)
+	displayHeight = 192 // framebuffer height in pixels (one per scanline)
+	textRows      = 24  // rows of text on the screen
+	flashFrames   = 16  // video frames between flashing character changes
+)
+
+var textColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+// A display represents the Apple2 video output. Video rendering draws into
+// an unfiltered framebuffer, which is then post-processed into the image
+// presented to front ends.
+type display struct {
+	apple2 *apple2
+
+	fb  *image.RGBA // raw framebuffer, one pixel per dot and scanline
+	crt crtFilter   // CRT post-processing filter
+	cg  *charGen    // character generator used for text
+
+	mono bool // true = render graphics without NTSC color
+
+	dirty videoDirty // video memory written since the last render
+	drawn renderKey  // display state the framebuffer was drawn with
+}
+
+func newDisplay(apple2 *apple2) *display {
+	return &display{
+		apple2: apple2,
+	}
+}
+
+func (d *display) Init() {
+	d.fb = image.NewRGBA(image.Rect(0, 0, displayWidth, displayHeight))
+	d.crt.opts = defaultCRTOptions()
+	d.cg = newFallbackCharGen(&d.apple2.cfg)
+	d.dirty.all = true
+}
+
+// LoadCharROM replaces the built-in font with the glyphs of a character
+// generator ROM.
+func (d *display) LoadCharROM(rom []byte) error {
+	cg, err := newCharGenROM(&d.apple2.cfg, rom)
+	if err != nil {
+		return err
+	}
+	d.cg = cg
+	return nil
+}
+
+// Render draws the current video display into the framebuffer. It is
+// called at the end of every frame. Only the rows and scanlines whose
+// memory has been written since the last frame are redrawn, unless a
+// change to the display mode requires redrawing everything.
+func (d *display) Render() {
+	key := d.renderKey()
+	if d.dirty.all || key != d.drawn {
+		d.render(d.fb, d.mono)
+	} else {
+		d.renderDirty()
+	}
+	d.drawn = key
+	d.dirty = videoDirty{}
+}
+
+// Invalidate makes the next call to Render redraw the whole display. It is
+// needed when video memory changes other than through the CPU, such as
+// when a snapshot is loaded.
+func (d *display) Invalidate() {
+	d.dirty.all = true
+}
+
+// A renderKey holds the display state that affects every row drawn. A
+// change to any of it requires the whole display to be redrawn.
+type renderKey struct {
+	switches uint32   // video soft switches
+	flash    bool     // flashing characters are inverse
+	mono     bool     // graphics are drawn without color
+	cg       *charGen // character generator
+}
+
+// videoSwitches are the soft switches that select what is displayed.
+const videoSwitches = 1<<ioSwitchTEXT | 1<<ioSwitchMIXED | 1<<ioSwitchPAGE2 |
+	1<<ioSwitchHIRES | 1<<ioSwitch80COL | 1<<ioSwitch80STORE |
+	1<<ioSwitchDHIRES | 1<<ioSwitchALTCHARSET
+
+func (d *display) renderKey() renderKey {
+	key := renderKey{
+		switches: d.apple2.iou.switches & videoSwitches,
+		mono:     d.mono,
+		cg:       d.cg,
+	}
+
+	// The flash phase matters only while text is shown.
+	if d.firstTextRow() < textRows {
+		key.flash = d.flashPhase()
+	}
+	return key
+}
+
+// renderDirty redraws the rows of text and the scanlines of graphics whose
+// memory in the displayed page has been written.
+func (d *display) renderDirty() {
+	iou := d.apple2.iou
+	text := &d.dirty.text[d.textPage()>>11]
+	hires := &d.dirty.hires[d.hiResPage()>>14]
+
+	first := d.firstTextRow()
+	for y := 0; y < first*8; y++ {
+		if iou.testSoftSwitch(ioSwitchHIRES) && hires[y] || !iou.testSoftSwitch(ioSwitchHIRES) && text[y/8] {
+			d.renderGraphics(d.fb, y, y+1, d.mono)
+		}
+	}
+	for row := first; row < textRows; row++ {
+		if text[row] {
+			d.renderTextRow(d.fb, row)
+		}
+	}
+}
+
+// SetMonochrome selects whether graphics are rendered in color, as on a
+// color monitor, or as the monochrome dots a green or white screen shows.
+func (d *display) SetMonochrome(mono bool) {
+	d.mono = mono
+}
+
+// render draws the current video display into a 560x192 image.
+func (d *display) render(fb *image.RGBA, mono bool) {
+	first := d.firstTextRow()
+	d.renderGraphics(fb, 0, first*8, mono)
+	for row := first; row < textRows; row++ {
+		d.renderTextRow(fb, row)
+	}
+}
+
+// A displayMode is the combination of soft switches that selects what
+// the display shows.
+type displayMode struct {
+	text   bool // text only
+	mixed  bool // four lines of text below graphics
+	hires  bool // hi-res rather than lo-res graphics
+	double bool // double-width (80-column) text or double graphics
+	page2  bool // the second display page is shown
+}
+
+func (m displayMode) String() string {
+	var s string
+	switch {
+	case m.text && m.double:
+		s = "TEXT80"
+	case m.text:
+		s = "TEXT40"
+	case m.hires:
+		s = "HIRES"
+	default:
+		s = "LORES"
+	}
+	if !m.text && m.double {
+		s = "D" + s
+	}
+	if !m.text && m.mixed {
+		s += "+MIXED"
+	}
+	if m.page2 {
+		s += " PAGE2"
+	}
+	return s
+}
+
+// DisplayMode returns the display mode selected by the soft switches.
+func (a *apple2) DisplayMode() displayMode {
+	iou := a.iou
+	m := displayMode{
+		text:  iou.testSoftSwitch(ioSwitchTEXT),
+		mixed: iou.testSoftSwitch(ioSwitchMIXED),
+		hires: iou.testSoftSwitch(ioSwitchHIRES),
+		page2: iou.testSoftSwitch(ioSwitchPAGE2) && !iou.testSoftSwitch(ioSwitch80STORE),
+	}
+	if a.cfg.iie && iou.testSoftSwitch(ioSwitch80COL) {
+		m.double = m.text || iou.testSoftSwitch(ioSwitchDHIRES)
+	}
+	return m
+}
+
+// firstTextRow returns the first row of text displayed: 0 in text mode,
+// 20 in mixed mode, and 24 when graphics fill the screen.
+func (d *display) firstTextRow() int {
+	iou := d.apple2.iou
+	switch {
+	case iou.testSoftSwitch(ioSwitchTEXT):
+		return 0
+	case iou.testSoftSwitch(ioSwitchMIXED):
+		return textRows - 4
+	}
+	return textRows
+}
+
+// flashPhase returns true while flashing characters are shown inverse.
+func (d *display) flashPhase() bool {
+	return (d.apple2.cpu.Cycles/(flashFrames*cyclesPerFrame))&1 != 0
+}
+
+// textPage returns the address of the displayed text page.
+func (d *display) textPage() uint16 {
+	iou := d.apple2.iou
+	if iou.testSoftSwitch(ioSwitchPAGE2) && !iou.testSoftSwitch(ioSwitch80STORE) {
+		return 0x0800
+	}
+	return 0x0400
+}
+
+// textRowAddress returns the address of the first character of a text
+// row. Rows are interleaved in groups of eight.
+func textRowAddress(page uint16, row int) uint16 {
+	return page + uint16(row&7)<<7 + uint16(row>>3)*40
+}
+
+// renderTextRow draws one row of 40- or 80-column text. In 80-column
+// mode, each column of main memory is preceded by a column of aux memory.
+func (d *display) renderTextRow(fb *image.RGBA, row int) {
+	cfg := &d.apple2.cfg
+	iou := d.apple2.iou
+
+	addr := textRowAddress(d.textPage(), row)
+	main := d.apple2.mmu.mainRAM[addr : addr+40]
+	aux := d.apple2.mmu.AuxVideoRAM()[addr : addr+40]
+	col80 := cfg.iie && iou.testSoftSwitch(ioSwitch80COL)
+	alt := iou.testSoftSwitch(ioSwitchALTCHARSET)
+	flash := d.flashPhase()
+
+	for y := 0; y < 8; y++ {
+		pix := fb.Pix[(row*8+y)*fb.Stride:]
+		x := 0
+		for c := 0; c < 40; c++ {
+			if col80 {
+				x = d.drawDots(pix, x, 1, d.cg.Glyph(cfg, alt, aux[c], flash)[y])
+				x = d.drawDots(pix, x, 1, d.cg.Glyph(cfg, alt, main[c], flash)[y])
+			} else {
+				x = d.drawDots(pix, x, 2, d.cg.Glyph(cfg, alt, main[c], flash)[y])
+			}
+		}
+	}
+}
+
+// drawDots draws the 7 dots of a glyph row starting at pixel x, each dot
+// width pixels wide, and returns the pixel following the last dot.
+func (d *display) drawDots(pix []byte, x, width int, dots byte) int {
+	for bit := 6; bit >= 0; bit-- {
+		c := color.RGBA{0, 0, 0, 0xff}
+		if dots&(1<<uint(bit)) != 0 {
+			c = textColor
+		}
+		for i := 0; i < width; i++ {
+			p := pix[x*4 : x*4+4]
+			p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+			x++
+		}
+	}
+	return x
+}
+
+// screenshotOptions control how a screenshot is rendered.
+type screenshotOptions struct {
+	scale int  // pixel scale factor; 1 = 560x384
+	mono  bool // true = render graphics in monochrome
+}
+
+// Screenshot renders the current video display into a new image, without
+// CRT post-processing. Each scanline is drawn twice so that the image has
+// the screen's 4:3 proportions, and the result is then scaled up by the
+// scale factor.
+func (d *display) Screenshot(opts screenshotOptions) *image.RGBA {
+	if opts.scale < 1 {
+		opts.scale = 1
+	}
+	fb := image.NewRGBA(image.Rect(0, 0, displayWidth, displayHeight))
+	d.render(fb, opts.mono)
+	return scaleFrame(fb, opts.scale)
+}
+
+// scaleFrame scales a framebuffer up by a factor, drawing each scanline
+// twice.
+func scaleFrame(fb *image.RGBA, scale int) *image.RGBA {
+	sx, sy := scale, scale*2
+	img := image.NewRGBA(image.Rect(0, 0, displayWidth*sx, displayHeight*sy))
+	for y := 0; y < img.Rect.Dy(); y++ {
+		src := fb.Pix[(y/sy)*fb.Stride:]
+		dst := img.Pix[y*img.Stride:]
+		for x := 0; x < img.Rect.Dx(); x++ {
+			copy(dst[x*4:x*4+4], src[(x/sx)*4:])
+		}
+	}
+	return img
+}
+
+// Framebuffer returns the raw, unfiltered framebuffer.
+func (d *display) Framebuffer() *image.RGBA {
+	return d.fb
+}
+
+// Frame returns the framebuffer after applying CRT post-processing. The
+// returned image is reused by subsequent calls.
+func (d *display) Frame() *image.RGBA {
+	return d.crt.Apply(d.fb)
+}
+
+// CRTOptions returns the current CRT post-processing options.
+func (d *display) CRTOptions() crtOptions {
+	return d.crt.opts
+}
+
+// SetCRTOptions updates the CRT post-processing options. It may be called
+// at any time, and the new options take effect on the next call to Frame.
+func (d *display) SetCRTOptions(opts crtOptions) {
+	d.crt.opts = opts.clamp()
+}
+
+// A textAttr describes how a character on the text screen is displayed.
+type textAttr byte
+
+const (
+	textNormal  textAttr = iota // white on black
+	textInverse                 // black on white
+	textFlash                   // alternates between normal and inverse
+	textMouse                   // MouseText glyph, displayed normally
+)
+
+// TextGlyph decodes a byte of text page memory into the character it
+// displays and its attribute. Characters are returned as ASCII codes, or
+// for the textMouse attribute, as an index into the 32 MouseText glyphs.
+// The result depends on the ALTCHARSET switch and the model's character
+// generator.
+func (d *display) TextGlyph(code byte) (ch byte, attr textAttr) {
+	alt := d.apple2.iou.testSoftSwitch(ioSwitchALTCHARSET)
+	return textGlyphFor(&d.apple2.cfg, alt, code)
+}
+
+// mouseTextRune is the first of the Unicode private use characters that
+// stand for the 32 MouseText glyphs in decoded screen text.
+const mouseTextRune = 0xe000
+
+// TextColumns returns the number of columns of text displayed: 80 if the
+// 80COL switch is on, and otherwise 40.
+func (d *display) TextColumns() int {
+	if d.apple2.cfg.iie && d.apple2.iou.testSoftSwitch(ioSwitch80COL) {
+		return 80
+	}
+	return 40
+}
+
+// TextRunes decodes the displayed text page into rows of characters, 40
+// or 80 to a row. Inverse and flashing characters are decoded as their
+// normal equivalents, and MouseText glyphs as private use characters
+// starting at U+E000. Rows showing graphics rather than text are blank.
+func (d *display) TextRunes() [][]rune {
+	first := d.firstTextRow()
+	cols := d.TextColumns()
+	page := d.textPage()
+	rows := make([][]rune, textRows)
+	for row := range rows {
+		line := make([]rune, cols)
+		rows[row] = line
+		if row < first {
+			for i := range line {
+				line[i] = ' '
+			}
+			continue
+		}
+
+		addr := textRowAddress(page, row)
+		main := d.apple2.mmu.mainRAM[addr : addr+40]
+		aux := d.apple2.mmu.AuxVideoRAM()[addr : addr+40]
+		for c := 0; c < 40; c++ {
+			if cols == 80 {
+				line[c*2] = d.textRune(aux[c])
+				line[c*2+1] = d.textRune(main[c])
+			} else {
+				line[c] = d.textRune(main[c])
+			}
+		}
+	}
+	return rows
+}
+
+// Text returns the displayed text as a string, with the rows separated by
+// newlines and trailing spaces removed from each row. See TextRunes.
+func (d *display) Text() string {
+	var b strings.Builder
+	for i, row := range d.TextRunes() {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(strings.TrimRight(string(row), " "))
+	}
+	return b.String()
+}
+
+func (d *display) textRune(code byte) rune {
+	ch, attr := d.TextGlyph(code)
+	if attr == textMouse {
+		return mouseTextRune + rune(ch)
+	}
+	return rune(ch)
+}
+
+func textGlyphFor(cfg *machineConfig, alt bool, code byte) (ch byte, attr textAttr) {
+	switch {
+	case code >= 0x80:
+		ch, attr = code&0x7f, textNormal
+	case code < 0x40:
+		ch, attr = code, textInverse
+	case !cfg.iie || !alt:
+		ch, attr = code&0x3f, textFlash
+	case code < 0x60 && cfg.enhanced:
+		return code - 0x40, textMouse
+	default:
+		ch, attr = code, textInverse
+	}
+
+	// Models before the IIe have no lowercase characters.
+	if !cfg.lowercase {
+		ch &= 0x3f
+	}
+	if ch < 0x20 {
+		ch += 0x40
+	}
+	return ch, attr
+}
+
+// videoDirty records the rows of the text and lo-res pages and the
+// scanlines of the hi-res pages whose memory has been written, in main or
+// aux memory, since the display was last rendered.
+type videoDirty struct {
+	text  [2][textRows]bool      // text and lo-res pages 1 and 2
+	hires [2][displayHeight]bool // hi-res pages 1 and 2
+	all   bool                   // everything must be redrawn
+}
+
+// markText marks the row of a text page holding an offset into the page.
+// The screen holes at the end of each 128-byte group aren't displayed.
+func (v *videoDirty) markText(page int, offset uint16) {
+	if g := offset & 0x7f; g < 120 {
+		v.text[page][int(offset>>7&7)+8*int(g/40)] = true
+	}
+}
+
+// markHiRes marks the scanline of a hi-res page holding an offset into
+// the page.
+func (v *videoDirty) markHiRes(page int, offset uint16) {
+	if g := offset & 0x7f; g < 120 {
+		v.hires[page][int(offset>>10&7)+8*int(offset>>7&7)+64*int(g/40)] = true
+	}
+}
+
+// A displayBankAccessor accesses a text and lo-res page of video memory,
+// marking the rows written so the display redraws them.
 type displayBankAccessor struct {
-	mem []byte
+	mem   []byte
+	dirty *videoDirty
+	page  int // 0 = page 1, 1 = page 2
 }
 
 func (a *displayBankAccessor) LoadByte(addr uint16) byte {
@@ -9,15 +472,23 @@ func (a *displayBankAccessor) LoadByte(addr uint16) byte {
 }
 
 func (a *displayBankAccessor) StoreByte(addr uint16, v byte) {
-	a.mem[addr] = v
+	if a.mem[addr] != v {
+		a.mem[addr] = v
+		a.dirty.markText(a.page, addr)
+	}
 }
 
 func (a *displayBankAccessor) CopyBytes(b []byte) {
 	copy(a.mem, b)
+	a.dirty.all = true
 }
 
+// A hiResBankAccessor accesses a hi-res page of video memory, marking the
+// scanlines written so the display redraws them.
 type hiResBankAccessor struct {
-	mem []byte
+	mem   []byte
+	dirty *videoDirty
+	page  int // 0 = page 1, 1 = page 2
 }
 
 func (a *hiResBankAccessor) LoadByte(addr uint16) byte {
@@ -25,9 +496,13 @@ func (a *hiResBankAccessor) LoadByte(addr uint16) byte {
 }
 
 func (a *hiResBankAccessor) StoreByte(addr uint16, v byte) {
-	a.mem[addr] = v
+	if a.mem[addr] != v {
+		a.mem[addr] = v
+		a.dirty.markHiRes(a.page, addr)
+	}
 }
 
 func (a *hiResBankAccessor) CopyBytes(b []byte) {
 	copy(a.mem, b)
+	a.dirty.all = true
 }
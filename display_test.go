@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// glyphAt reads back the glyph drawn in the 40-column text cell at the
+// given row and column.
+func glyphAt(d *display, row, col int) glyph {
+	var g glyph
+	for y := range g {
+		pix := d.fb.Pix[(row*8+y)*d.fb.Stride:]
+		for dot := 0; dot < 7; dot++ {
+			if pix[(col*14+dot*2)*4] != 0 {
+				g[y] |= 0x40 >> uint(dot)
+			}
+		}
+	}
+	return g
+}
+
+func TestTextRendering(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc051)        // TEXT on
+	a.mmu.StoreByte(0x0400, 0xc1) // normal 'A'
+	a.mmu.StoreByte(0x0401, 0x01) // inverse 'A'
+	a.mmu.StoreByte(0x0402, 0x41) // flashing 'A' or MouseText
+	a.mmu.StoreByte(0x0480, 0xe1) // normal 'a' on row 1
+
+	a.cpu.Cycles = 0
+	a.ds.Render()
+
+	normal := fallbackGlyph('A')
+	cases := []struct {
+		row, col int
+		expected glyph
+	}{
+		{0, 0, normal},
+		{0, 1, normal.inverse()},
+		{0, 2, normal},
+		{1, 0, fallbackGlyph('a')},
+	}
+	for _, c := range cases {
+		if g := glyphAt(a.ds, c.row, c.col); g != c.expected {
+			t.Errorf("Row %d col %d: expected %v, got %v\n", c.row, c.col, c.expected, g)
+		}
+	}
+
+	// Flashing characters invert during the flash phase.
+	a.cpu.Cycles = flashFrames * cyclesPerFrame
+	a.ds.Render()
+	if g := glyphAt(a.ds, 0, 2); g != normal.inverse() {
+		t.Errorf("Expected flashing character to be inverted\n")
+	}
+
+	// The alternate character set shows MouseText instead.
+	a.mmu.StoreByte(0xc00f, 0)
+	a.ds.Render()
+	if g := glyphAt(a.ds, 0, 2); g != mouseTextFont[1] {
+		t.Errorf("Expected MouseText glyph with ALTCHARSET on\n")
+	}
+}
+
+func TestCharROM(t *testing.T) {
+	a := newApple2()
+	fallback := newFallbackCharGen(&a.cfg)
+
+	// Build a ROM with inverted dots and bit 0 as the leftmost dot.
+	rom := make([]byte, 4096)
+	for set := 0; set < 2; set++ {
+		for code := 0; code < 256; code++ {
+			g := fallback.sets[set][code]
+			for row, dots := range g {
+				rom[set*0x800+code*8+row] = ^reverse7(dots)
+			}
+		}
+	}
+
+	if err := a.ds.LoadCharROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	for set := 0; set < 2; set++ {
+		for code := 0; code < 256; code++ {
+			if a.ds.cg.sets[set][code] != fallback.sets[set][code] {
+				t.Fatalf("Set %d code %02x: glyph differs after loading ROM\n", set, code)
+			}
+		}
+	}
+
+	if err := a.ds.LoadCharROM(make([]byte, 1000)); err != errCharROMSize {
+		t.Errorf("Expected errCharROMSize, got %v\n", err)
+	}
+}
+
+func TestScreenText(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc051) // TEXT on
+	for addr := 0x0400; addr < 0x0800; addr++ {
+		a.mmu.mainRAM[addr] = 0xa0
+		a.mmu.AuxVideoRAM()[addr] = 0xa0
+	}
+	for i, c := range []byte("HELLO") {
+		a.mmu.StoreByte(0x0400+uint16(i), c|0x80)
+	}
+	a.mmu.StoreByte(0x0405, 0x01)    // inverse 'A'
+	a.mmu.StoreByte(0x0480, 0xe1)    // 'a' on row 1
+	a.mmu.StoreByte(0x07d0+39, 0xda) // 'Z' at the end of row 23
+
+	if cols := a.ds.TextColumns(); cols != 40 {
+		t.Errorf("Expected 40 columns, got %d\n", cols)
+	}
+	rows := a.ds.TextRunes()
+	if len(rows) != textRows || len(rows[0]) != 40 || rows[23][39] != 'Z' {
+		t.Errorf("Unexpected text rows\n")
+	}
+	text := a.ds.Text()
+	if !strings.HasPrefix(text, "HELLOA\na\n\n") || !strings.HasSuffix(text, "\n"+strings.Repeat(" ", 39)+"Z") {
+		t.Errorf("Unexpected text %q\n", text)
+	}
+
+	// Page 2 is shown unless 80STORE repurposes PAGE2.
+	a.mmu.StoreByte(0x0800, 0xd0)
+	a.mmu.LoadByte(0xc055)
+	if rows := a.ds.TextRunes(); rows[0][0] != 'P' {
+		t.Errorf("Expected page 2, got %q\n", rows[0][0])
+	}
+	a.mmu.StoreByte(0xc001, 0) // 80STORE on
+	if rows := a.ds.TextRunes(); rows[0][0] != 'H' {
+		t.Errorf("Expected page 1 with 80STORE, got %q\n", rows[0][0])
+	}
+
+	// In 80 columns, aux memory holds the even columns. With 80STORE and
+	// PAGE2 on, stores to the text page go to aux memory.
+	a.mmu.StoreByte(0xc00d, 0) // 80COL on
+	a.mmu.StoreByte(0x0400, 0xc2)
+	a.mmu.LoadByte(0xc054)
+	rows = a.ds.TextRunes()
+	if len(rows[0]) != 80 || string(rows[0][:6]) != "BH E L" {
+		t.Errorf("Unexpected 80-column row %q\n", string(rows[0][:10]))
+	}
+
+	// Rows showing graphics are blank.
+	a.mmu.LoadByte(0xc050) // TEXT off
+	a.mmu.LoadByte(0xc053) // MIXED on
+	rows = a.ds.TextRunes()
+	if rows[0][1] != ' ' || rows[23][79] != 'Z' {
+		t.Errorf("Expected only the bottom four rows in mixed mode\n")
+	}
+}
+
+// BenchmarkRender redraws the whole display in each video mode, as happens
+// once a frame when the display changes throughout.
+func BenchmarkRender(b *testing.B) {
+	modes := []struct {
+		name     string
+		switches []uint16
+	}{
+		{"text40", []uint16{0xc051}},
+		{"text80", []uint16{0xc051, 0xc00d}},
+		{"lores", []uint16{0xc050, 0xc052, 0xc056}},
+		{"hires", []uint16{0xc050, 0xc052, 0xc057}},
+	}
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			a := newApple2()
+			for addr := uint16(0x0400); addr < 0x4000; addr++ {
+				a.mmu.StoreByte(addr, byte(addr*7+addr>>8))
+			}
+			for _, sw := range mode.switches {
+				if sw < 0xc010 {
+					a.mmu.StoreByte(sw, 0)
+				} else {
+					a.mmu.LoadByte(sw)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				a.ds.Invalidate()
+				a.ds.Render()
+			}
+		})
+	}
+}
+
+func TestGraphicsRendering(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc050) // TEXT off
+	a.mmu.LoadByte(0xc052) // MIXED off
+
+	// Lo-res: the low nibble is the upper block of a text cell.
+	a.mmu.StoreByte(0x0400, 0x9d) // yellow over orange
+	a.ds.Render()
+	fb := a.ds.Framebuffer()
+	if c := fb.RGBAAt(0, 0); c != loResColors[0xd] {
+		t.Errorf("Expected yellow, got %v\n", c)
+	}
+	if c := fb.RGBAAt(13, 7); c != loResColors[0x9] {
+		t.Errorf("Expected orange, got %v\n", c)
+	}
+	if c := fb.RGBAAt(14, 0); c != loResColors[0] {
+		t.Errorf("Expected black, got %v\n", c)
+	}
+
+	// Hi-res: isolated dots are colored by column and palette bit, and
+	// adjacent dots are white.
+	a.mmu.LoadByte(0xc057) // HIRES on
+	a.mmu.StoreByte(0x2000, 0x01)
+	a.mmu.StoreByte(0x2001, 0x82)
+	a.mmu.StoreByte(0x2400, 0x03) // scanline 1
+	a.ds.Render()
+	cases := []struct {
+		x, y     int
+		expected color.RGBA
+	}{
+		{0, 0, hiResColors[0][0]},
+		{2, 0, black},
+		{16, 0, hiResColors[1][0]},
+		{0, 1, loResColors[15]},
+		{2, 1, loResColors[15]},
+	}
+	for _, c := range cases {
+		if p := fb.RGBAAt(c.x, c.y); p != c.expected {
+			t.Errorf("Pixel (%d,%d): expected %v, got %v\n", c.x, c.y, c.expected, p)
+		}
+	}
+
+	// Mixed mode shows text on the bottom four rows.
+	a.mmu.LoadByte(0xc053)
+	a.mmu.StoreByte(0x0650, 0x00) // inverse '@' on row 20
+	a.ds.Render()
+	if c := fb.RGBAAt(0, 160); c != textColor {
+		t.Errorf("Expected text in mixed mode, got %v\n", c)
+	}
+
+	// Monochrome draws dots in the text color, delayed by the palette bit.
+	a.ds.SetMonochrome(true)
+	a.ds.Render()
+	if c := fb.RGBAAt(0, 0); c != textColor {
+		t.Errorf("Expected a monochrome dot, got %v\n", c)
+	}
+	if c := fb.RGBAAt(16, 0); c != black {
+		t.Errorf("Expected a delayed dot, got %v\n", c)
+	}
+	if c := fb.RGBAAt(17, 0); c != textColor {
+		t.Errorf("Expected a delayed dot, got %v\n", c)
+	}
+}
+
+func TestScreenshot(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc050)        // TEXT off
+	a.mmu.StoreByte(0x0400, 0x0f) // white block
+
+	for _, scale := range []int{0, 1, 3} {
+		img := a.ds.Screenshot(screenshotOptions{scale: scale})
+		s := scale
+		if s < 1 {
+			s = 1
+		}
+		if b := img.Bounds(); b.Dx() != displayWidth*s || b.Dy() != displayHeight*2*s {
+			t.Errorf("Scale %d: unexpected size %v\n", scale, b)
+		}
+		if c := img.RGBAAt(14*s-1, 8*s-1); c != loResColors[15] {
+			t.Errorf("Scale %d: expected white, got %v\n", scale, c)
+		}
+		if c := img.RGBAAt(14*s, 0); c != loResColors[0] {
+			t.Errorf("Scale %d: expected black, got %v\n", scale, c)
+		}
+	}
+
+	opts, err := parseScreenshotOptions([]string{"2", "mono"})
+	if err != nil || opts != (screenshotOptions{scale: 2, mono: true}) {
+		t.Errorf("Unexpected options %+v (%v)\n", opts, err)
+	}
+	if _, err := parseScreenshotOptions([]string{"big"}); err == nil {
+		t.Errorf("Expected an error for an invalid option\n")
+	}
+}
+
+func TestDirtyRendering(t *testing.T) {
+	// Every displayed byte marks the row or scanline that shows it.
+	var v videoDirty
+	for row := 0; row < textRows; row++ {
+		v = videoDirty{}
+		v.markText(1, textRowAddress(0, row)+39)
+		if !v.text[1][row] {
+			t.Errorf("Expected text row %d marked\n", row)
+		}
+	}
+	for y := 0; y < displayHeight; y++ {
+		v = videoDirty{}
+		v.markHiRes(0, hiResLineAddress(0, y))
+		if !v.hires[0][y] {
+			t.Errorf("Expected scanline %d marked\n", y)
+		}
+	}
+	v = videoDirty{}
+	v.markText(0, 0x78) // screen hole
+	if v.text[0] != [textRows]bool{} {
+		t.Errorf("Expected screen holes not marked\n")
+	}
+
+	a := newApple2()
+	d := a.ds
+	a.mmu.LoadByte(0xc051) // TEXT on
+	d.Render()
+
+	// scribble marks a scanline, and scribbled reports whether the mark
+	// survived rendering.
+	scribble := func(y int) { d.fb.Pix[y*d.fb.Stride] = 0x12 }
+	scribbled := func(y int) bool { return d.fb.Pix[y*d.fb.Stride] == 0x12 }
+
+	// Only the written text row is redrawn.
+	scribble(5 * 8)
+	scribble(10 * 8)
+	a.mmu.StoreByte(textRowAddress(0x400, 5), 0xc1)
+	d.Render()
+	if scribbled(5*8) || !scribbled(10*8) {
+		t.Errorf("Expected only the written text row redrawn\n")
+	}
+
+	// Writing the value already there doesn't redraw.
+	scribble(5 * 8)
+	a.mmu.StoreByte(textRowAddress(0x400, 5), 0xc1)
+	d.Render()
+	if !scribbled(5 * 8) {
+		t.Errorf("Expected an unchanged row not redrawn\n")
+	}
+
+	// Writes to aux memory through 80STORE mark the row too.
+	a.mmu.StoreByte(0xc001, 0) // 80STORE on
+	a.mmu.LoadByte(0xc055)     // PAGE2: aux text page
+	d.Render()
+	scribble(7 * 8)
+	a.mmu.StoreByte(textRowAddress(0x400, 7), 0xc2)
+	d.Render()
+	if scribbled(7 * 8) {
+		t.Errorf("Expected the row written in aux memory redrawn\n")
+	}
+
+	// Full-screen hi-res redraws only the written scanlines.
+	a.mmu.StoreByte(0xc000, 0) // 80STORE off
+	a.mmu.LoadByte(0xc054)     // PAGE1
+	a.mmu.LoadByte(0xc050)     // graphics
+	a.mmu.LoadByte(0xc057)     // HIRES
+	d.Render()
+	scribble(100)
+	scribble(101)
+	a.mmu.StoreByte(hiResLineAddress(0x2000, 100)+3, 0x7f)
+	d.Render()
+	if scribbled(100) || !scribbled(101) {
+		t.Errorf("Expected only the written scanline redrawn\n")
+	}
+
+	// Writes to the undisplayed page aren't drawn, but switching modes
+	// redraws everything.
+	a.mmu.StoreByte(hiResLineAddress(0x4000, 101), 0x7f)
+	d.Render()
+	if !scribbled(101) {
+		t.Errorf("Expected writes to the undisplayed page not drawn\n")
+	}
+	a.mmu.LoadByte(0xc055) // PAGE2
+	d.Render()
+	if scribbled(101) {
+		t.Errorf("Expected a page flip to redraw everything\n")
+	}
+
+	// Invalidate redraws everything.
+	scribble(150)
+	d.Invalidate()
+	d.Render()
+	if scribbled(150) {
+		t.Errorf("Expected Invalidate to redraw everything\n")
+	}
+}
+
+func TestCRTOptions(t *testing.T) {
+	cases := []struct {
+		opts crtOptions
+		want crtOptions
+		w, h int
+	}{
+		{crtOptions{}, crtOptions{}, displayWidth, displayHeight * 2},
+		{crtOptions{aspect: true}, crtOptions{aspect: true}, displayWidth, displayWidth * 3 / 4},
+		{crtOptions{scanlines: -1, bloom: 2, barrel: 0.5}, crtOptions{scanlines: 0, bloom: 1, barrel: 0.5}, displayWidth, displayHeight * 2},
+		{crtOptions{scanlines: 1.5, bloom: -0.1, barrel: 3, aspect: true}, crtOptions{scanlines: 1, bloom: 0, barrel: 1, aspect: true}, displayWidth, displayWidth * 3 / 4},
+	}
+	for _, c := range cases {
+		if got := c.opts.clamp(); got != c.want {
+			t.Errorf("%+v: expected clamped %+v, got %+v\n", c.opts, c.want, got)
+		}
+		if w, h := c.opts.outputSize(); w != c.w || h != c.h {
+			t.Errorf("%+v: expected %dx%d output, got %dx%d\n", c.opts, c.w, c.h, w, h)
+		}
+	}
+}
+
+func TestCRTFilter(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, displayWidth, displayHeight))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i*31) | 0x80
+	}
+
+	// Scanlines darken the odd rows, which fall between source scanlines,
+	// by their level, and leave the even rows as they are.
+	for _, level := range []float64{0, 0.5, 1} {
+		f := &crtFilter{opts: crtOptions{scanlines: level}}
+		dst := f.Apply(src)
+		for y := 0; y < 4; y++ {
+			scale := 1.0
+			if y%2 == 1 {
+				scale = 1 - level
+			}
+			sp := src.Pix[(y/2)*src.Stride:]
+			dp := dst.Pix[y*dst.Stride:]
+			for x := 0; x < displayWidth; x++ {
+				if want := byte(float64(sp[x*4]) * scale); dp[x*4] != want {
+					t.Fatalf("Scanlines %v: row %d pixel %d is %d, expected %d\n", level, y, x, dp[x*4], want)
+				}
+			}
+		}
+	}
+
+	// Barrel distortion of strength 0 leaves the image unchanged, and any
+	// other strength bends it.
+	f := &crtFilter{opts: crtOptions{aspect: true}}
+	scaled := f.Apply(src)
+	for _, barrel := range []float64{0, 0.5} {
+		f.opts.barrel = barrel
+		warped := image.NewRGBA(scaled.Rect)
+		f.applyBarrel(warped, scaled)
+		if same := bytes.Equal(warped.Pix, scaled.Pix); same != (barrel == 0) {
+			t.Errorf("Barrel %v: expected unchanged %v, got %v\n", barrel, barrel == 0, same)
+		}
+	}
+	if f.opts.barrel = 0; f.Apply(src) != f.scaled {
+		t.Errorf("Expected no barrel pass at strength 0\n")
+	}
+
+	// Once its buffers are sized, the filter doesn't allocate per frame.
+	f = &crtFilter{opts: crtOptions{scanlines: 0.5, bloom: 0.5, barrel: 0.5, aspect: true}}
+	f.Apply(src)
+	if n := testing.AllocsPerRun(10, func() { f.Apply(src) }); n != 0 {
+		t.Errorf("Expected no allocations per frame, got %v\n", n)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DOS 3.3 disk layout.
+const (
+	dos33VTOCTrack     = 17   // track holding the VTOC and catalog
+	dos33MaxTSPairs    = 122  // track/sector pairs per T/S list sector
+	dos33EntrySize     = 35   // bytes per catalog entry
+	dos33EntriesStart  = 0x0b // offset of the first catalog entry
+	dos33EntriesPerSec = 7    // catalog entries per sector
+	dos33NameLength    = 30   // characters in a file name
+	dos33Deleted       = 0xff // T/S list track of a deleted file
+)
+
+// A dos33FileType is the type of a file in a DOS 3.3 catalog.
+type dos33FileType byte
+
+const (
+	dos33Text        dos33FileType = 0x00 // T: text
+	dos33Integer     dos33FileType = 0x01 // I: Integer BASIC program
+	dos33Applesoft   dos33FileType = 0x02 // A: Applesoft BASIC program
+	dos33Binary      dos33FileType = 0x04 // B: binary
+	dos33SType       dos33FileType = 0x08 // S: special
+	dos33Relocatable dos33FileType = 0x10 // R: relocatable object
+	dos33AType       dos33FileType = 0x20 // new A
+	dos33BType       dos33FileType = 0x40 // new B
+	dos33Locked      dos33FileType = 0x80 // flag set on locked files
+)
+
+var dos33TypeLetters = []struct {
+	t dos33FileType
+	c byte
+}{
+	{dos33Text, 'T'}, {dos33Integer, 'I'}, {dos33Applesoft, 'A'}, {dos33Binary, 'B'},
+	{dos33SType, 'S'}, {dos33Relocatable, 'R'}, {dos33AType, 'a'}, {dos33BType, 'b'},
+}
+
+func (t dos33FileType) String() string {
+	for _, l := range dos33TypeLetters {
+		if t&^dos33Locked == l.t {
+			return string(l.c)
+		}
+	}
+	return "?"
+}
+
+// parseDOS33FileType parses a file type letter, as shown in a catalog.
+func parseDOS33FileType(s string) (dos33FileType, bool) {
+	for _, l := range dos33TypeLetters {
+		if s == string(l.c) || (l.c >= 'A' && strings.EqualFold(s, string(l.c))) {
+			return l.t, true
+		}
+	}
+	return 0, false
+}
+
+var (
+	errDOS33Volume      = errors.New("not a DOS 3.3 disk")
+	errDOS33NotFound    = errors.New("file not found")
+	errDOS33Exists      = errors.New("file already exists")
+	errDOS33Locked      = errors.New("file is locked")
+	errDOS33DiskFull    = errors.New("disk full")
+	errDOS33CatalogFull = errors.New("catalog full")
+	errDOS33Name        = errors.New("invalid file name")
+	errDOS33Damaged     = errors.New("damaged file or catalog")
+)
+
+// A dos33Entry describes a file in a DOS 3.3 catalog.
+type dos33Entry struct {
+	name     string
+	fileType dos33FileType // file type, without the locked flag
+	locked   bool
+	sectors  int // sectors used, including T/S lists
+
+	tsTrack, tsSector byte // first T/S list sector
+	catTrack, catSec  byte // catalog sector holding the entry
+	catOffset         int  // offset of the entry within its sector
+}
+
+// A dos33Volume provides access to the files on a DOS 3.3 disk. It works
+// on a 140K sector image in DOS 3.3 sector order, such as one returned by
+// diskImage.ReadSectors, and changes are made to the image in place.
+type dos33Volume struct {
+	data []byte
+}
+
+// openDOS33 checks that a sector image holds a DOS 3.3 file system.
+func openDOS33(data []byte) (*dos33Volume, error) {
+	if len(data) != diskImageSize {
+		return nil, errDiskSize
+	}
+	v := &dos33Volume{data: data}
+	vtoc := v.sector(dos33VTOCTrack, 0)
+	if vtoc[0x34] != diskTracks || vtoc[0x35] != diskSectors || vtoc[0x36] != 0 || vtoc[0x37] != 1 ||
+		vtoc[0x01] >= diskTracks || vtoc[0x02] >= diskSectors || vtoc[0x27] != dos33MaxTSPairs {
+		return nil, errDOS33Volume
+	}
+	return v, nil
+}
+
+// formatDOS33 returns a sector image of a newly initialized DOS 3.3 disk
+// with an empty catalog. Tracks 0 through 2, which hold DOS on a bootable
+// disk, are marked in use.
+func formatDOS33(volume byte) []byte {
+	v := &dos33Volume{data: make([]byte, diskImageSize)}
+	vtoc := v.sector(dos33VTOCTrack, 0)
+	vtoc[0x01], vtoc[0x02] = dos33VTOCTrack, diskSectors-1
+	vtoc[0x03] = 3
+	vtoc[0x06] = volume
+	vtoc[0x27] = dos33MaxTSPairs
+	vtoc[0x30], vtoc[0x31] = dos33VTOCTrack+1, 1
+	vtoc[0x34], vtoc[0x35] = diskTracks, diskSectors
+	vtoc[0x36], vtoc[0x37] = 0x00, 0x01
+	for t := 3; t < diskTracks; t++ {
+		if t != dos33VTOCTrack {
+			vtoc[0x38+t*4], vtoc[0x39+t*4] = 0xff, 0xff
+		}
+	}
+	for s := diskSectors - 1; s > 1; s-- {
+		cat := v.sector(dos33VTOCTrack, byte(s))
+		cat[0x01], cat[0x02] = dos33VTOCTrack, byte(s-1)
+	}
+	return v.data
+}
+
+func (v *dos33Volume) sector(t, s byte) []byte {
+	off := (int(t)*diskSectors + int(s)) * diskSectorSize
+	return v.data[off : off+diskSectorSize]
+}
+
+// Volume returns the disk's volume number.
+func (v *dos33Volume) Volume() byte {
+	return v.sector(dos33VTOCTrack, 0)[0x06]
+}
+
+func (v *dos33Volume) isFree(t, s byte) bool {
+	vtoc := v.sector(dos33VTOCTrack, 0)
+	bits := uint16(vtoc[0x38+int(t)*4])<<8 | uint16(vtoc[0x39+int(t)*4])
+	return bits&(1<<s) != 0
+}
+
+func (v *dos33Volume) setFree(t, s byte, free bool) {
+	vtoc := v.sector(dos33VTOCTrack, 0)
+	i := 0x38 + int(t)*4
+	if s < 8 {
+		i++
+	}
+	if free {
+		vtoc[i] |= 1 << (s & 7)
+	} else {
+		vtoc[i] &^= 1 << (s & 7)
+	}
+}
+
+// FreeSectors returns the number of unused sectors on the disk.
+func (v *dos33Volume) FreeSectors() int {
+	n := 0
+	for t := byte(0); t < diskTracks; t++ {
+		for s := byte(0); s < diskSectors; s++ {
+			if v.isFree(t, s) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// catalogSectors calls fn for each sector of the catalog, in order, until
+// fn returns false.
+func (v *dos33Volume) catalogSectors(fn func(t, s byte, sec []byte) bool) error {
+	vtoc := v.sector(dos33VTOCTrack, 0)
+	t, s := vtoc[0x01], vtoc[0x02]
+	for n := 0; t != 0; n++ {
+		if t >= diskTracks || s >= diskSectors || n >= diskTracks*diskSectors {
+			return errDOS33Damaged
+		}
+		sec := v.sector(t, s)
+		if !fn(t, s, sec) {
+			return nil
+		}
+		t, s = sec[0x01], sec[0x02]
+	}
+	return nil
+}
+
+// Catalog returns the files on the disk, in catalog order.
+func (v *dos33Volume) Catalog() ([]dos33Entry, error) {
+	var entries []dos33Entry
+	end := false
+	err := v.catalogSectors(func(t, s byte, sec []byte) bool {
+		for i := 0; i < dos33EntriesPerSec; i++ {
+			off := dos33EntriesStart + i*dos33EntrySize
+			e := sec[off : off+dos33EntrySize]
+			switch e[0] {
+			case 0:
+				end = true
+				return false
+			case dos33Deleted:
+				continue
+			}
+			entries = append(entries, dos33Entry{
+				name:      decodeDOS33Name(e[3:33]),
+				fileType:  dos33FileType(e[2]) &^ dos33Locked,
+				locked:    e[2]&byte(dos33Locked) != 0,
+				sectors:   int(e[33]) | int(e[34])<<8,
+				tsTrack:   e[0],
+				tsSector:  e[1],
+				catTrack:  t,
+				catSec:    s,
+				catOffset: off,
+			})
+		}
+		return !end
+	})
+	return entries, err
+}
+
+func decodeDOS33Name(b []byte) string {
+	name := make([]byte, len(b))
+	for i, c := range b {
+		name[i] = c & 0x7f
+	}
+	return strings.TrimRight(string(name), " ")
+}
+
+func encodeDOS33Name(name string) ([]byte, error) {
+	if name == "" || len(name) > dos33NameLength || strings.ContainsRune(name, ',') {
+		return nil, errDOS33Name
+	}
+	b := make([]byte, dos33NameLength)
+	for i := range b {
+		b[i] = ' ' | 0x80
+		if i < len(name) {
+			if name[i] < 0x20 || name[i] >= 0x7f {
+				return nil, errDOS33Name
+			}
+			b[i] = name[i] | 0x80
+		}
+	}
+	return b, nil
+}
+
+// Lookup returns the catalog entry of a file.
+func (v *dos33Volume) Lookup(name string) (dos33Entry, error) {
+	entries, err := v.Catalog()
+	if err != nil {
+		return dos33Entry{}, err
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return e, nil
+		}
+	}
+	return dos33Entry{}, errDOS33NotFound
+}
+
+// tsList returns the data sectors of a file from its T/S lists, and the
+// T/S list sectors themselves. Unallocated sectors in a sparse random
+// access file appear as track 0, sector 0.
+func (v *dos33Volume) tsList(e dos33Entry) (data, lists [][2]byte, err error) {
+	t, s := e.tsTrack, e.tsSector
+	for t != 0 {
+		if t >= diskTracks || s >= diskSectors || len(lists) > diskTracks*diskSectors {
+			return nil, nil, errDOS33Damaged
+		}
+		lists = append(lists, [2]byte{t, s})
+		sec := v.sector(t, s)
+		for i := 0; i < dos33MaxTSPairs; i++ {
+			data = append(data, [2]byte{sec[0x0c+i*2], sec[0x0d+i*2]})
+		}
+		t, s = sec[0x01], sec[0x02]
+	}
+
+	// Drop the unused pairs at the end of the last list.
+	for len(data) > 0 && data[len(data)-1] == [2]byte{} {
+		data = data[:len(data)-1]
+	}
+	return data, lists, nil
+}
+
+// ReadFile returns the contents of a file as stored on disk. Binary,
+// Applesoft and Integer BASIC files begin with the header DOS writes
+// before their data (see dos33Payload), and the contents end where the
+// header's length says they do. Other files contain every sector of the
+// file, with zeros in place of the unallocated sectors of a sparse file.
+func (v *dos33Volume) ReadFile(name string) ([]byte, dos33Entry, error) {
+	e, err := v.Lookup(name)
+	if err != nil {
+		return nil, e, err
+	}
+	pairs, _, err := v.tsList(e)
+	if err != nil {
+		return nil, e, err
+	}
+
+	data := make([]byte, 0, len(pairs)*diskSectorSize)
+	for _, p := range pairs {
+		if p[0] >= diskTracks || p[1] >= diskSectors {
+			return nil, e, errDOS33Damaged
+		}
+		if p == [2]byte{} {
+			data = append(data, make([]byte, diskSectorSize)...)
+			continue
+		}
+		data = append(data, v.sector(p[0], p[1])...)
+	}
+
+	if n := dos33HeaderSize(e.fileType); n > 0 && len(data) >= n {
+		length := n + (int(data[n-2]) | int(data[n-1])<<8)
+		if length <= len(data) {
+			data = data[:length]
+		}
+	}
+	return data, e, nil
+}
+
+// dos33HeaderSize returns the size of the header DOS writes at the start
+// of a file of the given type.
+func dos33HeaderSize(t dos33FileType) int {
+	switch t {
+	case dos33Binary:
+		return 4 // load address and length
+	case dos33Applesoft, dos33Integer:
+		return 2 // length
+	}
+	return 0
+}
+
+// dos33Payload splits the contents of a file into its header's load
+// address, if it has one, and the data following the header.
+func dos33Payload(t dos33FileType, data []byte) (addr uint16, payload []byte) {
+	n := dos33HeaderSize(t)
+	if len(data) < n {
+		return 0, nil
+	}
+	if t == dos33Binary {
+		addr = uint16(data[0]) | uint16(data[1])<<8
+	}
+	return addr, data[n:]
+}
+
+// dos33File returns the contents of a file of the given type holding the
+// payload, with the header DOS expects.
+func dos33File(t dos33FileType, addr uint16, payload []byte) []byte {
+	n := len(payload)
+	switch t {
+	case dos33Binary:
+		return append([]byte{byte(addr), byte(addr >> 8), byte(n), byte(n >> 8)}, payload...)
+	case dos33Applesoft, dos33Integer:
+		return append([]byte{byte(n), byte(n >> 8)}, payload...)
+	}
+	return payload
+}
+
+// allocate finds and reserves a free sector. Like DOS, it prefers the
+// tracks nearest the catalog, so that the head moves as little as
+// possible.
+func (v *dos33Volume) allocate() (t, s byte, err error) {
+	for d := 1; d < diskTracks; d++ {
+		for _, tt := range []int{dos33VTOCTrack + d, dos33VTOCTrack - d} {
+			if tt < 0 || tt >= diskTracks {
+				continue
+			}
+			for ss := diskSectors - 1; ss >= 0; ss-- {
+				if v.isFree(byte(tt), byte(ss)) {
+					v.setFree(byte(tt), byte(ss), false)
+					return byte(tt), byte(ss), nil
+				}
+			}
+		}
+	}
+	return 0, 0, errDOS33DiskFull
+}
+
+// WriteFile creates a file holding data, which for binary and BASIC files
+// must begin with a DOS header (see dos33File).
+func (v *dos33Volume) WriteFile(name string, t dos33FileType, data []byte) error {
+	encoded, err := encodeDOS33Name(name)
+	if err != nil {
+		return err
+	}
+	if _, err := v.Lookup(name); err == nil {
+		return errDOS33Exists
+	} else if err != errDOS33NotFound {
+		return err
+	}
+
+	// Find an unused catalog entry.
+	var entry []byte
+	err = v.catalogSectors(func(_, _ byte, sec []byte) bool {
+		for i := 0; i < dos33EntriesPerSec; i++ {
+			off := dos33EntriesStart + i*dos33EntrySize
+			if e := sec[off : off+dos33EntrySize]; e[0] == 0 || e[0] == dos33Deleted {
+				entry = e
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return errDOS33CatalogFull
+	}
+
+	nData := (len(data) + diskSectorSize - 1) / diskSectorSize
+	if nData == 0 {
+		nData = 1
+	}
+	nLists := (nData + dos33MaxTSPairs - 1) / dos33MaxTSPairs
+	if nData+nLists > v.FreeSectors() {
+		return errDOS33DiskFull
+	}
+
+	var prev []byte
+	var firstTS [2]byte
+	for i := 0; i < nData; i++ {
+		pair := i % dos33MaxTSPairs
+		if pair == 0 {
+			t, s, _ := v.allocate()
+			list := v.sector(t, s)
+			for j := range list {
+				list[j] = 0
+			}
+			list[0x05], list[0x06] = byte(i), byte(i>>8)
+			if prev != nil {
+				prev[0x01], prev[0x02] = t, s
+			} else {
+				firstTS = [2]byte{t, s}
+			}
+			prev = list
+		}
+
+		t, s, _ := v.allocate()
+		sec := v.sector(t, s)
+		for j := range sec {
+			sec[j] = 0
+		}
+		if i*diskSectorSize < len(data) {
+			copy(sec, data[i*diskSectorSize:])
+		}
+		prev[0x0c+pair*2], prev[0x0d+pair*2] = t, s
+	}
+
+	size := nData + nLists
+	entry[0], entry[1] = firstTS[0], firstTS[1]
+	entry[2] = byte(t)
+	copy(entry[3:33], encoded)
+	entry[33], entry[34] = byte(size), byte(size>>8)
+	return nil
+}
+
+// DeleteFile deletes a file and frees its sectors.
+func (v *dos33Volume) DeleteFile(name string) error {
+	e, err := v.Lookup(name)
+	if err != nil {
+		return err
+	}
+	if e.locked {
+		return errDOS33Locked
+	}
+	pairs, lists, err := v.tsList(e)
+	if err != nil {
+		return err
+	}
+	for _, p := range append(pairs, lists...) {
+		if p != [2]byte{} && p[0] < diskTracks && p[1] < diskSectors {
+			v.setFree(p[0], p[1], true)
+		}
+	}
+
+	entry := v.sector(e.catTrack, e.catSec)[e.catOffset:]
+	entry[0x20] = entry[0]
+	entry[0] = dos33Deleted
+	return nil
+}
+
+// String returns a catalog line for the entry in the format of the DOS
+// CATALOG command.
+func (e dos33Entry) String() string {
+	lock := ' '
+	if e.locked {
+		lock = '*'
+	}
+	return fmt.Sprintf("%c%v %03d %s", lock, e.fileType, e.sectors%1000, e.name)
+}
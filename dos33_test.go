@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDOS33Files(t *testing.T) {
+	v, err := openDOS33(formatDOS33(254))
+	if err != nil {
+		t.Fatalf("Unable to open a formatted disk: %v\n", err)
+	}
+	if n := v.FreeSectors(); n != 496 {
+		t.Errorf("Expected 496 free sectors, got %d\n", n)
+	}
+	if _, err := openDOS33(make([]byte, diskImageSize)); err != errDOS33Volume {
+		t.Errorf("Expected an error opening a blank disk, got %v\n", err)
+	}
+
+	// A binary file large enough to need two T/S lists.
+	payload := make([]byte, 130*diskSectorSize)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	bin := dos33File(dos33Binary, 0x2000, payload)
+	if err := v.WriteFile("BIG FILE", dos33Binary, bin); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	text := []byte("HELLO\r")
+	if err := v.WriteFile("NOTES", dos33Text, text); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := v.WriteFile("NOTES", dos33Text, text); err != errDOS33Exists {
+		t.Errorf("Expected an existing file error, got %v\n", err)
+	}
+
+	entries, err := v.Catalog()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected 2 catalog entries, got %d (%v)\n", len(entries), err)
+	}
+	if s := entries[0].String(); s != " B 133 BIG FILE" {
+		t.Errorf("Unexpected catalog line %q\n", s)
+	}
+	if s := entries[1].String(); s != " T 002 NOTES" {
+		t.Errorf("Unexpected catalog line %q\n", s)
+	}
+	if n := v.FreeSectors(); n != 496-135 {
+		t.Errorf("Expected %d free sectors, got %d\n", 496-135, n)
+	}
+
+	data, e, err := v.ReadFile("BIG FILE")
+	if err != nil || !bytes.Equal(data, bin) {
+		t.Fatalf("Binary file read back incorrectly (%v)\n", err)
+	}
+	if addr, p := dos33Payload(e.fileType, data); addr != 0x2000 || !bytes.Equal(p, payload) {
+		t.Errorf("Unexpected payload at %04X\n", addr)
+	}
+	data, _, err = v.ReadFile("NOTES")
+	if err != nil || len(data) != diskSectorSize || !bytes.HasPrefix(data, text) {
+		t.Errorf("Text file read back incorrectly (%v)\n", err)
+	}
+
+	if err := v.DeleteFile("BIG FILE"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if _, err := v.Lookup("BIG FILE"); err != errDOS33NotFound {
+		t.Errorf("Expected deleted file to be gone, got %v\n", err)
+	}
+	if n := v.FreeSectors(); n != 496-2 {
+		t.Errorf("Expected %d free sectors, got %d\n", 496-2, n)
+	}
+	if err := v.WriteFile("AGAIN", dos33Binary, bin); err != nil {
+		t.Errorf("Unable to reuse a deleted entry: %v\n", err)
+	}
+}
+
+func TestDiskCommands(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "test.po")
+	disk := &diskImage{format: diskFormatProDOS, volume: defaultVolume}
+	if err := disk.WriteSectors(formatDOS33(defaultVolume), &dosSectorOrder); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDiskFile(image, disk); err != nil {
+		t.Fatal(err)
+	}
+
+	bas := filepath.Join(dir, "hello.bas")
+	bin := filepath.Join(dir, "prog.bin")
+	os.WriteFile(bas, []byte("10 PRINT \"HI\"\n"), 0644)
+	os.WriteFile(bin, []byte{0xa9, 0x00, 0x60}, 0644)
+
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		status, ok := runDiskCommand(args, &out)
+		if !ok || status != 0 {
+			t.Fatalf("%v: status %d: %s\n", args, status, out.String())
+		}
+		return out.String()
+	}
+	run("put", image, bas)
+	run("put", "-a", "6000", image, bin, "PROG")
+	if out := run("ls", image); !strings.Contains(out, " A 002 HELLO\n B 002 PROG\n") {
+		t.Errorf("Unexpected catalog:\n%s", out)
+	}
+
+	out := filepath.Join(dir, "prog.out")
+	if s := run("get", image, "PROG", out); !strings.Contains(s, "$6000") {
+		t.Errorf("Expected the load address, got %q\n", s)
+	}
+	if data, _ := os.ReadFile(out); !bytes.Equal(data, []byte{0xa9, 0x00, 0x60}) {
+		t.Errorf("Unexpected file contents % X\n", data)
+	}
+	run("get", "-raw", image, "HELLO", out)
+	if data, _ := os.ReadFile(out); len(data) < 4 || data[6] != 0xba {
+		t.Errorf("Expected a tokenized program, got % X\n", data)
+	}
+
+	if _, ok := runDiskCommand([]string{"-debug"}, os.Stdout); ok {
+		t.Errorf("Expected flags not to be a disk command\n")
+	}
+}
+
+// FuzzDOS33 checks that reading a DOS 3.3 disk whose catalog, and the
+// files' track and sector lists, hold anything fails rather than
+// panicking or looping. The fuzzed data replaces tracks $11 and $12 of a
+// disk holding a file.
+func FuzzDOS33(f *testing.F) {
+	v, _ := openDOS33(formatDOS33(254))
+	if err := v.WriteFile("HELLO", dos33Text, bytes.Repeat([]byte("HELLO\r"), 200)); err != nil {
+		f.Fatal(err)
+	}
+	tracks := v.data[dos33VTOCTrack*diskTrackSize : (dos33VTOCTrack+2)*diskTrackSize]
+	f.Add(append([]byte(nil), tracks...))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		img := append([]byte(nil), v.data...)
+		copy(img[dos33VTOCTrack*diskTrackSize:(dos33VTOCTrack+2)*diskTrackSize], data)
+		v, err := openDOS33(img)
+		if err != nil {
+			return
+		}
+		v.FreeSectors()
+		entries, err := v.Catalog()
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			v.ReadFile(e.name)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+// A driveStatus describes what a Disk II drive is doing, for front ends
+// that show drive lights and track numbers, and for scripts that wait for
+// the disks to go quiet.
+type driveStatus struct {
+	drive        int  // 1 or 2
+	loaded       bool // a disk is in the drive
+	motorOn      bool // the drive is selected and its motor is spinning
+	quarterTrack int  // head position in quarter tracks
+	phases       byte // bitmask of energized stepper phases
+	reading      bool // nibbles were read during the last frame
+	writing      bool // nibbles were written during the last frame
+	dirty        bool // the disk has changes that haven't been saved
+}
+
+// Track returns the whole track nearest the head.
+func (s driveStatus) Track() int {
+	return (s.quarterTrack + 1) / 4
+}
+
+// A driveObserver is notified when the status of a drive changes. It is
+// called at the end of a frame, on the goroutine running the machine, and
+// must not block.
+type driveObserver interface {
+	DriveChanged(s driveStatus)
+}
+
+// AddObserver starts notifying an observer of changes to the status of
+// the drives.
+func (d *diskII) AddObserver(o driveObserver) {
+	d.observers = append(d.observers, o)
+}
+
+// RemoveObserver stops notifying an observer.
+func (d *diskII) RemoveObserver(o driveObserver) {
+	for i, oo := range d.observers {
+		if oo == o {
+			d.observers = append(d.observers[:i], d.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Status returns the status of drive 0 or 1, including the activity of
+// the frame in progress.
+func (d *diskII) Status(drive int) driveStatus {
+	dr := &d.drives[drive]
+	s := driveStatus{
+		drive:        drive + 1,
+		loaded:       dr.disk != nil,
+		motorOn:      drive == d.active && d.MotorOn(),
+		quarterTrack: dr.quarterTrack,
+		phases:       d.phases,
+		reading:      dr.read,
+		writing:      dr.written,
+	}
+	if drive != d.active {
+		s.phases = 0
+	}
+	if dr.disk != nil {
+		s.dirty = dr.disk.dirty
+	}
+	return s
+}
+
+// Idle returns true if neither drive's motor is spinning and no disk
+// change is waiting for a write to finish.
+func (d *diskII) Idle() bool {
+	return !d.MotorOn() && len(d.pending) == 0
+}
+
+// EndFrame notifies the observers of the drives whose status changed
+// during the frame, and starts tracking the activity of the next one.
+func (d *diskII) EndFrame() {
+	for i := range d.drives {
+		s := d.Status(i)
+		if s != d.status[i] {
+			d.status[i] = s
+			for _, o := range d.observers {
+				o.DriveChanged(s)
+			}
+		}
+		d.drives[i].read, d.drives[i].written = false, false
+	}
+}
+
+// DriveStatus returns the status of drive 1 or 2 of the disk controller
+// in slot 6.
+func (a *apple2) DriveStatus(drive int) (driveStatus, error) {
+	d, err := a.diskController()
+	if err != nil {
+		return driveStatus{}, err
+	}
+	if drive < 1 || drive > len(d.drives) {
+		return driveStatus{}, errNoDrive
+	}
+	return d.Status(drive - 1), nil
+}
+
+// DisksIdle returns true if no disk drive is running. A machine without
+// a disk controller is always idle.
+func (a *apple2) DisksIdle() bool {
+	d, err := a.diskController()
+	return err != nil || d.Idle()
+}
@@ -0,0 +1,170 @@
+package main
+
+import "fmt"
+
+// A machineEventKind identifies something that happens to the machine
+// that front ends and tools may want to react to.
+type machineEventKind int
+
+const (
+	eventReset        machineEventKind = iota // the RESET line was asserted
+	eventSpeed                                // the speed mode in effect changed
+	eventDiskInserted                         // a disk was inserted in a drive
+	eventDiskEjected                          // a disk was removed from a drive
+	eventBreakpoint                           // the debugger stopped at a breakpoint or watchpoint
+	eventDisplayMode                          // the soft switches selected another display mode
+	eventKeyStrobe                            // software cleared the strobe of a waiting key
+
+	machineEventKinds
+)
+
+var machineEventNames = [machineEventKinds]string{
+	"reset", "speed", "disk inserted", "disk ejected", "breakpoint", "display mode", "key strobe",
+}
+
+func (k machineEventKind) String() string {
+	return machineEventNames[k]
+}
+
+// A resetKind tells how the machine was reset.
+type resetKind int
+
+const (
+	resetWarm  resetKind = iota // Ctrl-Reset
+	resetCold                   // open-Apple-Ctrl-Reset, forcing a cold start
+	resetPower                  // power cycle
+)
+
+// A machineEvent describes something that happened to the machine. Only
+// the fields that apply to the event's kind are set.
+type machineEvent struct {
+	kind  machineEventKind
+	cycle uint64 // CPU cycle at which the event happened
+
+	reset  resetKind   // eventReset
+	speed  speedMode   // eventSpeed: the speed now in effect
+	drive  int         // eventDiskInserted, eventDiskEjected: 1 or 2
+	file   string      // eventDiskInserted, eventDiskEjected: the disk's image file
+	reason string      // eventBreakpoint: why the debugger stopped
+	mode   displayMode // eventDisplayMode: the new mode
+	key    byte        // eventKeyStrobe: the key read, without its strobe bit
+}
+
+func (e machineEvent) String() string {
+	s := fmt.Sprintf("%d: %v", e.cycle, e.kind)
+	switch e.kind {
+	case eventReset:
+		s += [...]string{" (warm)", " (cold)", " (power cycle)"}[e.reset]
+	case eventSpeed:
+		s += " " + e.speed.String()
+	case eventDiskInserted, eventDiskEjected:
+		s += fmt.Sprintf(" drive %d %s", e.drive, e.file)
+	case eventBreakpoint:
+		s += " " + e.reason
+	case eventDisplayMode:
+		s += " " + e.mode.String()
+	case eventKeyStrobe:
+		s += fmt.Sprintf(" $%02X", e.key)
+	}
+	return s
+}
+
+// A machineObserver is notified of machine events. It is called on the
+// goroutine running the machine, as the events happen, and must not
+// block or call back into the machine.
+type machineObserver interface {
+	MachineEvent(e machineEvent)
+}
+
+// A machineEventFunc is a machineObserver that calls a function for
+// events of the kinds it selects, or for all events if it selects none.
+type machineEventFunc struct {
+	fn    func(e machineEvent)
+	kinds uint32 // bitmask of selected kinds
+}
+
+// newMachineEventFunc returns an observer that calls fn for events of
+// the given kinds.
+func newMachineEventFunc(fn func(e machineEvent), kinds ...machineEventKind) *machineEventFunc {
+	f := &machineEventFunc{fn: fn}
+	for _, k := range kinds {
+		f.kinds |= 1 << uint(k)
+	}
+	return f
+}
+
+func (f *machineEventFunc) MachineEvent(e machineEvent) {
+	if f.kinds == 0 || f.kinds&(1<<uint(e.kind)) != 0 {
+		f.fn(e)
+	}
+}
+
+// An eventBus sends machine events to observers. Events that other
+// goroutines can cause, such as speed changes, are noticed at the end of
+// each frame so that they too are sent from the machine's goroutine.
+type eventBus struct {
+	apple2    *apple2
+	observers []machineObserver
+	speed     speedMode   // speed in effect at the last speed event
+	mode      displayMode // display mode at the last display mode event
+}
+
+func newEventBus(apple2 *apple2) *eventBus {
+	return &eventBus{apple2: apple2}
+}
+
+// AddObserver starts sending machine events to an observer.
+func (b *eventBus) AddObserver(o machineObserver) {
+	if len(b.observers) == 0 {
+		b.speed = b.apple2.speed.Effective()
+		b.mode = b.apple2.DisplayMode()
+	}
+	b.observers = append(b.observers, o)
+}
+
+// RemoveObserver stops sending machine events to an observer.
+func (b *eventBus) RemoveObserver(o machineObserver) {
+	for i, oo := range b.observers {
+		if oo == o {
+			b.observers = append(b.observers[:i], b.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// active returns true if any observer is listening, so that callers can
+// avoid the work of describing events nobody receives.
+func (b *eventBus) active() bool {
+	return len(b.observers) > 0
+}
+
+// send stamps an event with the current cycle and sends it to the
+// observers.
+func (b *eventBus) send(e machineEvent) {
+	e.cycle = b.apple2.cpu.Cycles
+	for _, o := range b.observers {
+		o.MachineEvent(e)
+	}
+}
+
+// checkDisplayMode sends a display mode event if the mode has changed.
+func (b *eventBus) checkDisplayMode() {
+	if !b.active() {
+		return
+	}
+	if m := b.apple2.DisplayMode(); m != b.mode {
+		b.mode = m
+		b.send(machineEvent{kind: eventDisplayMode, mode: m})
+	}
+}
+
+// EndFrame sends the events noticed at the end of a frame.
+func (b *eventBus) EndFrame() {
+	if !b.active() {
+		return
+	}
+	if s := b.apple2.speed.Effective(); s != b.speed {
+		b.speed = s
+		b.send(machineEvent{kind: eventSpeed, speed: s})
+	}
+}
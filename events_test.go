@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMachineEvents(t *testing.T) {
+	a := newApple2()
+	a.sm.Insert(6, newDiskII(a, nil))
+	a.mmu.StoreBytes(0x0300, []byte{
+		0xad, 0x10, 0xc0, // 0300: LDA $C010
+		0xad, 0x50, 0xc0, // 0303: LDA $C050
+		0x4c, 0x06, 0x03, // 0306: JMP $0306
+	})
+	a.cpu.SetPC(0x0300)
+	a.mmu.LoadByte(0xc051) // TEXT
+
+	var events []string
+	o := newMachineEventFunc(func(e machineEvent) {
+		events = append(events, e.String()[strings.Index(e.String(), ":")+2:])
+	})
+	a.ev.AddObserver(o)
+
+	a.kb.SetKey('A')
+	d := a.AttachDebugger()
+	d.SetBreakpoint(0x0306, true)
+	d.Continue()
+	a.RunFrame()
+	a.speed.SetSpeed(speedWarp)
+	a.RunFrame()
+
+	dc, _ := a.diskController()
+	dc.insert(0, &diskImage{}, "game.dsk", diskModeWrite)
+	dc.EjectDisk(0)
+	a.ColdReset()
+
+	want := []string{
+		"key strobe $41",
+		"display mode LORES",
+		"breakpoint breakpoint at 0306",
+		"speed warp",
+		"disk inserted drive 1 game.dsk",
+		"disk ejected drive 1 game.dsk",
+		"reset (cold)",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected events:\n%s\ngot:\n%s\n", strings.Join(want, "\n"), strings.Join(events, "\n"))
+	}
+
+	// Observers may select the kinds of events they receive.
+	a.ev.RemoveObserver(o)
+	events = nil
+	var resets int
+	a.ev.AddObserver(newMachineEventFunc(func(e machineEvent) { resets++ }, eventReset))
+	a.speed.SetSpeed(speedNormal)
+	a.RunFrame()
+	a.PowerCycle()
+	if len(events) != 0 || resets != 1 {
+		t.Errorf("Expected only a reset event, got %d events and %d resets\n", len(events), resets)
+	}
+}
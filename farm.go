@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errFarmName    = errors.New("machine names are made of letters, digits, - and _")
+	errFarmExists  = errors.New("a machine with that name exists")
+	errFarmMachine = errors.New("no machine with that name")
+)
+
+// A machineSpec describes a machine to create in a farm.
+type machineSpec struct {
+	Name  string `json:"name"`
+	Model string `json:"model"` // default iie-enhanced
+	Disk1 string `json:"disk1"` // disk image files, with ,ro or ,cow as for -disk1
+	Disk2 string `json:"disk2"`
+	Speed string `json:"speed"` // default warp
+}
+
+// A machineFarm runs any number of named machines at once, each on its
+// own goroutine, for running many disks through automated tests in
+// parallel. The machines share nothing but the ROM images they're built
+// from. Each one is controlled through its own remote control, which the
+// farm's HTTP API routes requests to:
+//
+//	GET    /machines               the names of the machines
+//	POST   /machines               create a machine from a JSON machineSpec
+//	DELETE /machines/name          stop a machine, saving its disks
+//	ANY    /machines/name/request  a remote control request for a machine,
+//	                               such as GET /machines/name/screen
+type machineFarm struct {
+	roms *romSet
+	log  slog.Handler // handler of the machines' messages, each tagged with its name
+
+	mu       sync.Mutex
+	machines map[string]*farmMachine
+}
+
+// A farmMachine is a machine running in a farm.
+type farmMachine struct {
+	name   string
+	apple2 *apple2
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newMachineFarm(roms *romSet, log slog.Handler) *machineFarm {
+	return &machineFarm{
+		roms:     roms,
+		log:      log,
+		machines: make(map[string]*farmMachine),
+	}
+}
+
+// validFarmName returns true if a name can name a machine, and be part of
+// a URL path unescaped.
+func validFarmName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Create builds a machine and starts running it.
+func (f *machineFarm) Create(spec machineSpec) (*farmMachine, error) {
+	if !validFarmName(spec.Name) {
+		return nil, errFarmName
+	}
+	a, err := f.build(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.machines[spec.Name] != nil {
+		return nil, errFarmExists
+	}
+	m := &farmMachine{
+		name:   spec.Name,
+		apple2: a,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	f.machines[spec.Name] = m
+	go m.run()
+	return m, nil
+}
+
+// build builds the machine a spec describes, with a Disk II controller
+// in slot 6 if the model has slots.
+func (f *machineFarm) build(spec machineSpec) (*apple2, error) {
+	model := modelIIeEnhanced
+	if spec.Model != "" {
+		var ok bool
+		if model, ok = parseMachineModel(spec.Model); !ok {
+			return nil, fmt.Errorf("unknown machine model '%s'", spec.Model)
+		}
+	}
+	speed := speedWarp
+	if spec.Speed != "" {
+		var ok bool
+		if speed, ok = parseSpeedMode(spec.Speed); !ok {
+			return nil, fmt.Errorf("unknown speed '%s'", spec.Speed)
+		}
+	}
+
+	a := newApple2Model(model)
+	a.log.SetHandler(f.log.WithAttrs([]slog.Attr{slog.String("machine", spec.Name)}))
+	if err := f.roms.Validate(model, a.cfg.slots); err != nil {
+		return nil, err
+	}
+	if err := a.LoadROMSet(f.roms); err != nil {
+		return nil, err
+	}
+	if a.cfg.slots {
+		rom, err := f.roms.DiskIIROM()
+		if err != nil {
+			return nil, err
+		}
+		a.sm.Insert(6, newDiskII(a, rom))
+	}
+	a.FillRAM()
+	for i, disk := range []string{spec.Disk1, spec.Disk2} {
+		if disk == "" {
+			continue
+		}
+		file, mode := parseDiskSpec(disk)
+		if err := a.InsertDiskMode(i+1, file, mode); err != nil {
+			return nil, err
+		}
+	}
+	a.speed.SetSpeed(speed)
+	a.remote = newRemoteControl(a)
+	return a, nil
+}
+
+// run runs the machine until it's stopped, and then saves its disks.
+func (m *farmMachine) run() {
+	defer close(m.done)
+	a := m.apple2
+	for {
+		select {
+		case <-m.stop:
+			a.CloseCalls()
+			if d, err := a.diskController(); err == nil {
+				if err := d.Flush(); err != nil {
+					a.log.Logger(logDisk).Error("saving disks failed", "error", err)
+				}
+			}
+			return
+		default:
+			a.RunPaced()
+		}
+	}
+}
+
+// Remove stops a machine, once the request it's running, if any, is done,
+// and removes it from the farm.
+func (f *machineFarm) Remove(name string) error {
+	f.mu.Lock()
+	m := f.machines[name]
+	delete(f.machines, name)
+	f.mu.Unlock()
+	if m == nil {
+		return errFarmMachine
+	}
+	close(m.stop)
+	<-m.done
+	return nil
+}
+
+// Machine returns the machine with a name, or nil.
+func (f *machineFarm) Machine(name string) *farmMachine {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.machines[name]
+}
+
+// Names returns the names of the machines, sorted.
+func (f *machineFarm) Names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.machines))
+	for name := range f.machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close stops all the machines.
+func (f *machineFarm) Close() {
+	for _, name := range f.Names() {
+		f.Remove(name)
+	}
+}
+
+// ServeHTTP serves the farm's API.
+func (f *machineFarm) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/machines")
+	switch {
+	case !ok:
+		http.NotFound(w, r)
+	case rest == "" && r.Method == http.MethodGet:
+		replyJSON(w, http.StatusOK, f.Names())
+	case rest == "" && r.Method == http.MethodPost:
+		var spec machineSpec
+		if err := json.NewDecoder(io.LimitReader(r.Body, remoteMaxBody)).Decode(&spec); err != nil {
+			replyJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if _, err := f.Create(spec); err != nil {
+			replyJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		replyJSON(w, http.StatusCreated, map[string]string{"name": spec.Name})
+	case strings.HasPrefix(rest, "/"):
+		name, req, _ := strings.Cut(rest[1:], "/")
+		switch m := f.Machine(name); {
+		case m == nil:
+			replyJSON(w, http.StatusNotFound, map[string]string{"error": errFarmMachine.Error()})
+		case req == "" && r.Method == http.MethodDelete:
+			if err := f.Remove(name); err != nil {
+				replyJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+			replyJSON(w, http.StatusOK, map[string]string{"name": name})
+		default:
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = "/" + req
+			m.apple2.remote.ServeHTTP(w, r2)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// runFarmCommand runs the "farm" command, which serves a farm's API until
+// the process is interrupted.
+func runFarmCommand(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("farm", flag.ContinueOnError)
+	fs.SetOutput(w)
+	romDir := fs.String("romdir", "./resources", "`directory` or zip file containing ROM images")
+	addr := fs.String("addr", "localhost:6502", "serve the API on TCP `address`")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fmt.Fprintf(w, "usage: apple2go farm [-romdir dir] [-addr address]\n")
+		return 2
+	}
+
+	roms, err := loadROMSet(*romDir)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %v\n", err)
+		return 1
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %v\n", err)
+		return 1
+	}
+	defer l.Close()
+	f := newMachineFarm(roms, newLogHandler(os.Stderr, true))
+	defer f.Close()
+	go http.Serve(l, f)
+	fmt.Fprintf(w, "Serving the machine farm on %s\n", l.Addr())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	<-interrupt
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMachineFarm(t *testing.T) {
+	rs := &romSet{}
+	rs.add("a.rom", testSystemROM(16*1024, 0x06, 0xe0))
+	rs.add("p5.bin", testBootROM())
+	f := newMachineFarm(rs, slog.NewTextHandler(io.Discard, nil))
+	defer f.Close()
+
+	for _, name := range []string{"one", "two"} {
+		if _, err := f.Create(machineSpec{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Create(machineSpec{Name: "one"}); err != errFarmExists {
+		t.Errorf("Expected a duplicate name to fail, got %v\n", err)
+	}
+	if _, err := f.Create(machineSpec{Name: "a/b"}); err != errFarmName {
+		t.Errorf("Expected a bad name to fail, got %v\n", err)
+	}
+	if f.Machine("one").apple2 == f.Machine("two").apple2 {
+		t.Errorf("Expected separate machines\n")
+	}
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	get := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, body := get("POST", "/machines", `{"name": "three", "model": "iie-enhanced"}`); code != http.StatusCreated {
+		t.Errorf("Expected machine three to be created, got %d %s\n", code, body)
+	}
+	var names []string
+	if _, body := get("GET", "/machines", ""); json.Unmarshal([]byte(body), &names) != nil || strings.Join(names, " ") != "one three two" {
+		t.Errorf("Unexpected machine names %s\n", body)
+	}
+
+	// Requests are routed to each machine's remote control.
+	if code, body := get("POST", "/machines/two/pause", ""); code != http.StatusOK || !strings.Contains(body, `"paused":true`) {
+		t.Errorf("Expected machine two to pause, got %d %s\n", code, body)
+	}
+	if _, body := get("GET", "/machines/one/status", ""); !strings.Contains(body, `"paused":false`) {
+		t.Errorf("Expected machine one to be running, got %s\n", body)
+	}
+	if code, _ := get("GET", "/machines/four/status", ""); code != http.StatusNotFound {
+		t.Errorf("Expected no machine four, got %d\n", code)
+	}
+
+	if code, body := get("DELETE", "/machines/one", ""); code != http.StatusOK {
+		t.Errorf("Expected machine one to be removed, got %d %s\n", code, body)
+	}
+	if names := f.Names(); strings.Join(names, " ") != "three two" {
+		t.Errorf("Unexpected machine names %v\n", names)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import "bytes"
+
+// A fastTrap runs a well-known routine instantly in Go instead of
+// emulating it, for users who prefer speed to accuracy. A trap applies
+// only when the routine's code is in memory where expected, so a program
+// that replaces it, or a language card holding other code, runs as usual.
+type fastTrap struct {
+	name string
+	addr uint16
+	rom  bool   // true = the routine is in the system ROM
+	sig  []byte // the routine's first bytes
+	run  func(a *apple2) bool
+}
+
+var fastTraps = []fastTrap{
+	{"WAIT", 0xfca8, true, []byte{0x38, 0x48, 0xe9, 0x01, 0xd0, 0xfc, 0x68, 0xe9, 0x01, 0xd0, 0xf6, 0x60}, fastWAIT},
+	{"RWTS", 0xbd00, false, []byte{0x84, 0x48, 0x85, 0x49, 0xa0, 0x02, 0x8c, 0xf8, 0x06}, fastRWTS},
+}
+
+// EnableFastTraps sets traps that run the monitor's WAIT delay and the
+// DOS 3.3 RWTS instantly. They replace any traps set on the same
+// addresses.
+func (a *apple2) EnableFastTraps() {
+	for _, ft := range fastTraps {
+		ft := ft
+		a.TrapAddr(ft.addr, func(pc uint16) bool {
+			if ft.matches(a) && ft.run(a) {
+				a.fastReturn()
+			}
+			return false
+		})
+	}
+}
+
+// matches returns true if the routine is mapped at its address.
+func (ft *fastTrap) matches(a *apple2) bool {
+	if ft.rom {
+		if p, ok := a.mmu.physReadAddr(ft.addr); !ok || p.space != memSpaceROM {
+			return false
+		}
+	}
+	code := make([]byte, len(ft.sig))
+	for i := range code {
+		code[i] = a.mmu.Peek(ft.addr + uint16(i))
+	}
+	return bytes.Equal(code, ft.sig)
+}
+
+// fastReturn returns from a routine run by a trap, as its RTS would.
+func (a *apple2) fastReturn() {
+	r := &a.cpu.Reg
+	lo := a.mmu.LoadByte(0x100 | uint16(r.SP+1))
+	hi := a.mmu.LoadByte(0x100 | uint16(r.SP+2))
+	r.SP += 2
+	r.PC = (uint16(hi)<<8 | uint16(lo)) + 1
+}
+
+// fastWAIT skips the monitor's delay loop, leaving the registers as it
+// does.
+func fastWAIT(a *apple2) bool {
+	r := &a.cpu.Reg
+	r.A = 0
+	r.Carry, r.Zero, r.Sign = true, true, false
+	return true
+}
+
+// DOS 3.3 RWTS commands and return codes, held in its I/O block (IOB).
+const (
+	rwtsSeek  = 0x00
+	rwtsRead  = 0x01
+	rwtsWrite = 0x02
+
+	rwtsOK           = 0x00
+	rwtsWriteProtect = 0x10
+	rwtsVolMismatch  = 0x20
+	rwtsDriveError   = 0x40
+)
+
+// fastRWTS seeks, reads or writes a sector of a 16-sector disk as DOS
+// 3.3's RWTS does, given the address of an IOB in A and Y. The drive's
+// head is left on the track, as the routine leaves it. Other commands,
+// controllers other than a Disk II, and tracks that don't decode run the
+// routine itself, and return false.
+func fastRWTS(a *apple2) bool {
+	m := a.mmu
+	r := &a.cpu.Reg
+	iob := uint16(r.A)<<8 | uint16(r.Y)
+	at := func(i uint16) byte { return m.Peek(iob + i) }
+	slot, drive, vol := int(at(1)>>4), int(at(2)), at(3)
+	track, sector, cmd := int(at(4)), int(at(5)), at(0x0c)
+	buf := uint16(at(8)) | uint16(at(9))<<8
+
+	d, ok := a.sm.Card(slot).(*diskII)
+	if !ok || d.writing || drive < 1 || drive > len(d.drives) ||
+		track >= diskTracks || sector >= diskSectors || cmd > rwtsWrite {
+		return false
+	}
+	dr := &d.drives[drive-1]
+	if dr.disk == nil {
+		return false
+	}
+	data, err := decodeTrack(track, dr.disk.tracks[track], &dosSectorOrder)
+	if err != nil {
+		return false
+	}
+
+	dr.quarterTrack = track * 4
+	code := byte(rwtsOK)
+	sec := data[sector*256 : (sector+1)*256]
+	switch {
+	case vol != 0 && vol != dr.disk.volume:
+		code = rwtsVolMismatch
+	case cmd == rwtsRead:
+		if a.WriteMemory(memView{}, buf, sec) != nil {
+			code = rwtsDriveError
+		}
+		dr.read = true
+	case cmd == rwtsWrite && dr.disk.writeProtected:
+		code = rwtsWriteProtect
+	case cmd == rwtsWrite:
+		b, err := a.ReadMemory(memView{}, buf, len(sec))
+		if err != nil {
+			code = rwtsDriveError
+			break
+		}
+		copy(sec, b)
+		dr.disk.tracks[track] = dr.disk.encodeTrack(track, data, &dosSectorOrder)
+		dr.disk.dirty = true
+		dr.written = true
+	}
+
+	a.WriteMemory(memView{}, iob+0x0d, []byte{code, dr.disk.volume, byte(slot << 4), byte(drive)})
+	r.A = code
+	r.Carry = code != rwtsOK
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFastTraps(t *testing.T) {
+	a := newApple2()
+	for i, b := range fastTraps[0].sig {
+		a.mmu.PokePhys(physAddr{memSpaceROM, 0, 0xfca8 + uint16(i)}, b)
+	}
+	a.mmu.StoreBytes(0xbd00, fastTraps[1].sig)
+	a.sm.Insert(6, newDiskII(a, nil))
+	sectors := formatDOS33(100)
+	if err := a.InsertDiskData(1, "test.dsk", sectors); err != nil {
+		t.Fatal(err)
+	}
+	a.EnableFastTraps()
+	a.TrapBRK(func(pc uint16) bool { return true })
+
+	// WAIT returns at once, as if it had run.
+	a.mmu.StoreBytes(0x300, []byte{
+		0xa9, 0xff, // LDA #$FF
+		0x20, 0xa8, 0xfc, // JSR WAIT
+		0x00, // BRK
+	})
+	a.cpu.SetPC(0x300)
+	start := a.cpu.Cycles
+	a.RunFrame()
+	if r := a.cpu.Reg; r.PC != 0x305 || r.A != 0 || !r.Carry || a.cpu.Cycles-start > 20 {
+		t.Errorf("Unexpected WAIT return to %04X with A=%02X after %d cycles\n", r.PC, r.A, a.cpu.Cycles-start)
+	}
+
+	// RWTS reads and writes sectors through an IOB at $0380.
+	a.mmu.StoreBytes(0x300, []byte{
+		0xa0, 0x80, // LDY #$80
+		0xa9, 0x03, // LDA #$03
+		0x20, 0x00, 0xbd, // JSR RWTS
+		0x00, // BRK
+	})
+	rwts := func(cmd, track, sector, vol byte) byte {
+		a.mmu.StoreBytes(0x380, []byte{0x01, 0x60, 0x01, vol, track, sector, 0, 0, 0x00, 0x40, 0, 0, cmd, 0xff})
+		a.cpu.SetPC(0x300)
+		a.RunFrame()
+		if a.cpu.Reg.PC != 0x307 {
+			t.Fatalf("Expected RWTS to return, stopped at %04X\n", a.cpu.Reg.PC)
+		}
+		code := a.mmu.Peek(0x38d)
+		if a.cpu.Reg.Carry != (code != 0) || a.cpu.Reg.A != code {
+			t.Errorf("Unexpected RWTS exit with code %02X\n", code)
+		}
+		return code
+	}
+	if code := rwts(rwtsRead, 17, 0, 0); code != rwtsOK || a.mmu.Peek(0x38e) != defaultVolume {
+		t.Errorf("Unexpected read with code %02X\n", code)
+	}
+	vtoc := sectors[17*diskTrackSize : 17*diskTrackSize+256]
+	if got, _ := a.ReadMemory(memView{}, 0x4000, 256); !bytes.Equal(got, vtoc) {
+		t.Errorf("Unexpected VTOC read\n")
+	}
+
+	data := bytes.Repeat([]byte{0xa5}, 256)
+	a.WriteMemory(memView{}, 0x4000, data)
+	if code := rwts(rwtsWrite, 3, 5, defaultVolume); code != rwtsOK {
+		t.Errorf("Unexpected write with code %02X\n", code)
+	}
+	disk, _ := a.MountedDisk(1)
+	written, _ := disk.ReadSectors(&dosSectorOrder)
+	if !bytes.Equal(written[3*diskTrackSize+5*256:][:256], data) || !disk.dirty {
+		t.Errorf("Sector not written\n")
+	}
+	if code := rwts(rwtsRead, 3, 5, 7); code != rwtsVolMismatch {
+		t.Errorf("Expected a volume mismatch, got %02X\n", code)
+	}
+}
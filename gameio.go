@@ -1,7 +1,30 @@
 package main
 
+import "sync"
+
+const (
+	numPaddles = 4
+	numButtons = 3
+
+	// The 558 timer's one-shot period grows with the paddle resistance.
+	// Each step of paddle position adds the time taken by one iteration
+	// of the monitor's PREAD loop.
+	paddleCyclesPerStep = 11
+)
+
+// The gameIO module emulates the game controller connector: four paddle
+// inputs timed by a 558 quad timer, three push button inputs, four
+// annunciator outputs, and the utility strobe. Front ends feed controller
+// state through SetPaddle, SetAxis and SetButton, which are safe to call
+// from any goroutine.
 type gameIO struct {
 	apple2 *apple2
+
+	mu      sync.Mutex
+	paddles [numPaddles]byte // paddle positions (0..255)
+	buttons [numButtons]bool // push button states
+
+	trigger uint64 // CPU cycle at which the paddle timers were last triggered
 }
 
 func newGameIO(apple2 *apple2) *gameIO {
@@ -11,8 +34,90 @@ func newGameIO(apple2 *apple2) *gameIO {
 }
 
 func (g *gameIO) Init() {
+	for i := range g.paddles {
+		g.paddles[i] = 0x80
+	}
 }
 
 func (g *gameIO) GetStrobe() byte {
 	return 0
 }
+
+// SetPaddle sets the position (0..255) of paddle n.
+func (g *gameIO) SetPaddle(n int, v byte) {
+	if g.apple2.in.Replaying() {
+		return
+	}
+	g.mu.Lock()
+	g.paddles[n] = v
+	g.mu.Unlock()
+}
+
+// SetAxis sets the position of paddle n from a joystick axis value in the
+// range -1..1.
+func (g *gameIO) SetAxis(n int, v float64) {
+	v = (clampAxis(v) + 1) * 127.5
+	g.SetPaddle(n, byte(v+0.5))
+}
+
+// SetButton sets the state of push button n.
+func (g *gameIO) SetButton(n int, down bool) {
+	if g.apple2.in.Replaying() {
+		return
+	}
+	g.mu.Lock()
+	g.buttons[n] = down
+	g.mu.Unlock()
+}
+
+// TriggerPaddles starts all four paddle timers, as happens when software
+// accesses $C070.
+func (g *gameIO) TriggerPaddles() {
+	g.trigger = g.apple2.cpu.Cycles
+}
+
+// PaddleBit returns 0x80 if paddle n's timer is still running.
+func (g *gameIO) PaddleBit(n int) byte {
+	g.mu.Lock()
+	duration := uint64(g.paddles[n]) * paddleCyclesPerStep
+	g.mu.Unlock()
+
+	if g.apple2.cpu.Cycles-g.trigger < duration {
+		return 0x80
+	}
+	return 0
+}
+
+// ButtonBit returns 0x80 if push button n is pressed. On the IIe and IIc,
+// buttons 0 and 1 are wired in parallel with the open-Apple and
+// closed-Apple keys; earlier models have no Apple keys.
+func (g *gameIO) ButtonBit(n int) byte {
+	g.mu.Lock()
+	down := g.buttons[n]
+	g.mu.Unlock()
+
+	if down {
+		return 0x80
+	}
+	if kb := g.apple2.kb; g.apple2.cfg.iie {
+		if (n == 0 && kb.OpenApple()) || (n == 1 && kb.ClosedApple()) {
+			return 0x80
+		}
+	}
+	return 0
+}
+
+// Annunciator returns the state of annunciator output n.
+func (g *gameIO) Annunciator(n int) bool {
+	return g.apple2.iou.testSoftSwitch(ioSwitchANNUNCIATOR0 + ioSwitch(n))
+}
+
+func clampAxis(v float64) float64 {
+	if v < -1 {
+		return -1
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,112 @@
+package main
+
+import "testing"
+
+func TestPaddleTimer(t *testing.T) {
+	a := newApple2()
+	a.gi.SetPaddle(0, 0)
+	a.gi.SetPaddle(1, 100)
+	a.gi.SetAxis(2, 1)
+
+	a.mmu.LoadByte(0xc070)
+	start := a.cpu.Cycles
+
+	cases := []struct {
+		addr  uint16
+		cycle uint64
+		value byte
+	}{
+		{0xc064, 0, 0x00},
+		{0xc065, 0, 0x80},
+		{0xc065, 100*paddleCyclesPerStep - 1, 0x80},
+		{0xc065, 100 * paddleCyclesPerStep, 0x00},
+		{0xc066, 255*paddleCyclesPerStep - 1, 0x80},
+		{0xc066, 255 * paddleCyclesPerStep, 0x00},
+	}
+
+	for _, c := range cases {
+		a.cpu.Cycles = start + c.cycle
+		v := a.mmu.LoadByte(c.addr) & 0x80
+		if v != c.value {
+			t.Errorf("Read %04x at cycle %d: expected %02x, got %02x\n", c.addr, c.cycle, c.value, v)
+		}
+	}
+}
+
+func TestButtons(t *testing.T) {
+	a := newApple2()
+
+	a.gi.SetButton(2, true)
+	a.kb.PushKeyEvent(hostKeyLeftAlt, true)
+	a.kb.Update()
+
+	cases := []struct {
+		addr  uint16
+		value byte
+	}{
+		{0xc061, 0x80},
+		{0xc062, 0x00},
+		{0xc063, 0x80},
+	}
+
+	for _, c := range cases {
+		v := a.mmu.LoadByte(c.addr) & 0x80
+		if v != c.value {
+			t.Errorf("Read %04x: expected %02x, got %02x\n", c.addr, c.value, v)
+		}
+	}
+
+	// The II+ has no Apple keys, and its inputs mirror at $C068-$C06F.
+	a = newApple2Model(modelIIPlus)
+	a.gi.SetButton(1, true)
+	a.kb.PushKeyEvent(hostKeyLeftAlt, true)
+	a.kb.Update()
+	if v := a.mmu.LoadByte(0xc061) & 0x80; v != 0 {
+		t.Errorf("Expected no open-Apple key on the II+, got %02x\n", v)
+	}
+	if v := a.mmu.LoadByte(0xc06a) & 0x80; v != 0x80 {
+		t.Errorf("Read c06a: expected 80, got %02x\n", v)
+	}
+}
+
+func TestKeyJoystick(t *testing.T) {
+	a := newApple2()
+	im := a.im
+	im.MapArrowKeysToJoystick()
+	im.MapKeyToButton(hostKeySpace, 0)
+	im.EnableKeyJoystick(true)
+	im.SetRampSpeed(2)
+
+	im.KeyEvent(hostKeyRight, true)
+	im.KeyEvent(hostKeySpace, true)
+	im.KeyEvent(hostKeyA, true)
+
+	// Half a second at 2 units/second ramps the axis fully to the right.
+	a.cpu.Cycles += uint64(cpuClockRate) / 4
+	im.Update()
+	if v := a.gi.paddles[0]; v < 0xbc || v > 0xc4 {
+		t.Errorf("Expected paddle 0 near c0 after 1/4 second, got %02x\n", v)
+	}
+	a.cpu.Cycles += uint64(cpuClockRate) / 4
+	im.Update()
+	if v := a.gi.paddles[0]; v != 0xff {
+		t.Errorf("Expected paddle 0 at ff, got %02x\n", v)
+	}
+	if v := a.mmu.LoadByte(0xc061) & 0x80; v != 0x80 {
+		t.Errorf("Expected button 0 pressed\n")
+	}
+
+	// Unmapped keys still reach the keyboard.
+	a.kb.Update()
+	if v := a.mmu.LoadByte(0xc000); v != 0x80|'A' {
+		t.Errorf("Expected key C1, got %02x\n", v)
+	}
+
+	// Releasing the key recenters the axis.
+	im.KeyEvent(hostKeyRight, false)
+	a.cpu.Cycles += uint64(cpuClockRate)
+	im.Update()
+	if v := a.gi.paddles[0]; v != 0x80 {
+		t.Errorf("Expected paddle 0 centered, got %02x\n", v)
+	}
+}
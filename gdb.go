@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The GDB remote serial protocol has no standard register layout for the
+// 6502, so the stub uses its own: A, X, Y, P and SP are 8 bits each,
+// followed by the 16-bit PC, all in target (little-endian) byte order.
+// Register numbers for the p and P packets follow the same order.
+const (
+	gdbRegA = iota
+	gdbRegX
+	gdbRegY
+	gdbRegP
+	gdbRegSP
+	gdbRegPC
+	gdbNumRegs
+)
+
+// Stop signals reported to the client.
+const (
+	gdbSigINT  = 2 // stopped by an interrupt from the client
+	gdbSigTRAP = 5 // stopped by a breakpoint, watchpoint or step
+)
+
+var errGDBPacket = errors.New("gdb: malformed packet")
+
+// A gdbPacket is a message received from a GDB client: either a command,
+// or an interrupt request (Ctrl-C) if interrupt is true.
+type gdbPacket struct {
+	data      string
+	interrupt bool
+}
+
+// A gdbStub serves the GDB remote serial protocol on a connection,
+// controlling the machine through a debugger. Breakpoints and watchpoints
+// set by the client are the debugger's breakpoints and the mmu's
+// watchpoints, and memory is read and written through the mmu.
+type gdbStub struct {
+	d *debugger
+	w *bufio.Writer
+}
+
+// ServeGDB serves a GDB client on a connection until the client detaches,
+// kills the session, or closes the connection. Like Console, it runs the
+// machine whenever the client lets the CPU run, and must be called from
+// the goroutine that runs the machine.
+func (d *debugger) ServeGDB(conn io.ReadWriter) error {
+	s := &gdbStub{d: d, w: bufio.NewWriter(conn)}
+
+	packets := make(chan gdbPacket)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- readGDBPackets(bufio.NewReader(conn), packets)
+		close(packets)
+	}()
+
+	d.Stop()
+	running := false
+	for {
+		if !running || d.stopped {
+			if running {
+				running = false
+				s.reply(fmt.Sprintf("S%02X", gdbSigTRAP))
+			}
+			p, ok := <-packets
+			if !ok {
+				return <-errs
+			}
+			if p.interrupt {
+				continue
+			}
+			done, err := s.handle(p.data)
+			if err != nil || done {
+				return err
+			}
+			running = !d.stopped
+			continue
+		}
+
+		select {
+		case p, ok := <-packets:
+			if !ok {
+				return <-errs
+			}
+			if p.interrupt {
+				d.Stop()
+				running = false
+				s.reply(fmt.Sprintf("S%02X", gdbSigINT))
+			}
+		default:
+			d.apple2.RunFrame()
+		}
+	}
+}
+
+// serveGDB waits for a GDB client to connect on a TCP address and serves
+// it.
+func serveGDB(a *apple2, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Waiting for a GDB connection on %s\n", l.Addr())
+	conn, err := l.Accept()
+	l.Close()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return a.AttachDebugger().ServeGDB(conn)
+}
+
+// readGDBPackets reads packets from a client and sends them on a channel.
+// Packets with bad checksums are ignored; the client resends them.
+func readGDBPackets(r *bufio.Reader, packets chan<- gdbPacket) error {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch c {
+		case 0x03:
+			packets <- gdbPacket{interrupt: true}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return err
+			}
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return err
+			}
+			data = data[:len(data)-1]
+			if cs, err := strconv.ParseUint(string(sum[:]), 16, 8); err == nil && byte(cs) == gdbChecksum(data) {
+				packets <- gdbPacket{data: data}
+			}
+		}
+	}
+}
+
+func gdbChecksum(s string) byte {
+	var sum byte
+	for i := 0; i < len(s); i++ {
+		sum += s[i]
+	}
+	return sum
+}
+
+// reply sends a response packet.
+func (s *gdbStub) reply(data string) error {
+	fmt.Fprintf(s.w, "$%s#%02x", data, gdbChecksum(data))
+	return s.w.Flush()
+}
+
+// handle acknowledges and executes a client command. It returns true
+// when the session ends.
+func (s *gdbStub) handle(data string) (done bool, err error) {
+	if _, err := s.w.WriteString("+"); err != nil {
+		return true, err
+	}
+
+	if data == "" {
+		return false, s.reply("")
+	}
+
+	d := s.d
+	cmd, args := data[:1], data[1:]
+	var resp string
+	switch cmd {
+	case "?":
+		resp = fmt.Sprintf("S%02X", gdbSigTRAP)
+	case "g":
+		resp = s.readRegisters()
+	case "G":
+		resp = s.writeRegisters(args)
+	case "p":
+		resp = s.readRegister(args)
+	case "P":
+		resp = s.writeRegister(args)
+	case "m":
+		resp = s.readMemory(args)
+	case "M":
+		resp = s.writeMemory(args)
+	case "c", "s":
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				resp = "E01"
+				break
+			}
+			d.apple2.cpu.Reg.PC = uint16(addr)
+		}
+		if cmd == "c" {
+			d.Continue()
+			return false, s.w.Flush()
+		}
+		d.Step()
+		resp = fmt.Sprintf("S%02X", gdbSigTRAP)
+	case "Z", "z":
+		resp = s.breakpoint(cmd == "Z", args)
+	case "H":
+		resp = "OK"
+	case "q":
+		switch {
+		case strings.HasPrefix(args, "Supported"):
+			resp = "PacketSize=1000"
+		case args == "Attached":
+			resp = "1"
+		case args == "C":
+			resp = "QC1"
+		case args == "fThreadInfo":
+			resp = "m1"
+		case args == "sThreadInfo":
+			resp = "l"
+		}
+	case "D":
+		d.Continue()
+		s.reply("OK")
+		return true, nil
+	case "k":
+		return true, s.w.Flush()
+	}
+	return false, s.reply(resp)
+}
+
+func (s *gdbStub) registers() [gdbNumRegs]uint16 {
+	r := &s.d.apple2.cpu.Reg
+	return [gdbNumRegs]uint16{
+		gdbRegA:  uint16(r.A),
+		gdbRegX:  uint16(r.X),
+		gdbRegY:  uint16(r.Y),
+		gdbRegP:  uint16(r.SavePS(false)),
+		gdbRegSP: uint16(r.SP),
+		gdbRegPC: r.PC,
+	}
+}
+
+func (s *gdbStub) setRegister(n int, v uint16) {
+	r := &s.d.apple2.cpu.Reg
+	switch n {
+	case gdbRegA:
+		r.A = byte(v)
+	case gdbRegX:
+		r.X = byte(v)
+	case gdbRegY:
+		r.Y = byte(v)
+	case gdbRegP:
+		r.RestorePS(byte(v))
+	case gdbRegSP:
+		r.SP = byte(v)
+	case gdbRegPC:
+		r.PC = v
+	}
+}
+
+// formatGDBRegister returns a register value in hex, in target byte order.
+func formatGDBRegister(n int, v uint16) string {
+	if n == gdbRegPC {
+		return fmt.Sprintf("%02x%02x", byte(v), byte(v>>8))
+	}
+	return fmt.Sprintf("%02x", byte(v))
+}
+
+// parseGDBRegister parses a register value from the start of s, returning
+// the value and the number of characters consumed.
+func parseGDBRegister(n int, s string) (uint16, int, error) {
+	size := 2
+	if n == gdbRegPC {
+		size = 4
+	}
+	if len(s) < size {
+		return 0, 0, errGDBPacket
+	}
+	b, err := hex.DecodeString(s[:size])
+	if err != nil {
+		return 0, 0, errGDBPacket
+	}
+	if size == 4 {
+		return uint16(b[0]) | uint16(b[1])<<8, size, nil
+	}
+	return uint16(b[0]), size, nil
+}
+
+func (s *gdbStub) readRegisters() string {
+	var b strings.Builder
+	for n, v := range s.registers() {
+		b.WriteString(formatGDBRegister(n, v))
+	}
+	return b.String()
+}
+
+func (s *gdbStub) writeRegisters(args string) string {
+	var values [gdbNumRegs]uint16
+	for n := range values {
+		v, size, err := parseGDBRegister(n, args)
+		if err != nil {
+			return "E01"
+		}
+		values[n] = v
+		args = args[size:]
+	}
+	for n, v := range values {
+		s.setRegister(n, v)
+	}
+	return "OK"
+}
+
+func (s *gdbStub) readRegister(args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || n >= gdbNumRegs {
+		return "E01"
+	}
+	return formatGDBRegister(int(n), s.registers()[n])
+}
+
+func (s *gdbStub) writeRegister(args string) string {
+	i := strings.IndexByte(args, '=')
+	if i < 0 {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(args[:i], 16, 8)
+	if err != nil || n >= gdbNumRegs {
+		return "E01"
+	}
+	v, _, err := parseGDBRegister(int(n), args[i+1:])
+	if err != nil {
+		return "E01"
+	}
+	s.setRegister(int(n), v)
+	return "OK"
+}
+
+// parseGDBRange parses the "addr,length" arguments of a memory packet.
+func parseGDBRange(args string) (addr uint16, n int, err error) {
+	i := strings.IndexByte(args, ',')
+	if i < 0 {
+		return 0, 0, errGDBPacket
+	}
+	a, err := strconv.ParseUint(args[:i], 16, 16)
+	if err != nil {
+		return 0, 0, errGDBPacket
+	}
+	l, err := strconv.ParseUint(args[i+1:], 16, 16)
+	if err != nil {
+		return 0, 0, errGDBPacket
+	}
+	return uint16(a), int(l), nil
+}
+
+func (s *gdbStub) readMemory(args string) string {
+	addr, n, err := parseGDBRange(args)
+	if err != nil {
+		return "E01"
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = s.d.peek(addr + uint16(i))
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s *gdbStub) writeMemory(args string) string {
+	i := strings.IndexByte(args, ':')
+	if i < 0 {
+		return "E01"
+	}
+	addr, n, err := parseGDBRange(args[:i])
+	if err != nil {
+		return "E01"
+	}
+	b, err := hex.DecodeString(args[i+1:])
+	if err != nil || len(b) != n {
+		return "E01"
+	}
+	for i, v := range b {
+		s.d.poke(addr+uint16(i), v)
+	}
+	return "OK"
+}
+
+// breakpoint handles the Z and z packets. Type 0 and 1 breakpoints are
+// debugger breakpoints; types 2, 3 and 4 are write, read and access
+// watchpoints.
+func (s *gdbStub) breakpoint(set bool, args string) string {
+	f := strings.Split(args, ",")
+	if len(f) != 3 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(f[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	length, err := strconv.ParseUint(f[2], 16, 16)
+	if err != nil || length == 0 {
+		length = 1
+	}
+
+	var kind watchKind
+	switch f[0] {
+	case "0", "1":
+		s.d.SetBreakpoint(uint16(addr), set)
+		return "OK"
+	case "2":
+		kind = watchWrite
+	case "3":
+		kind = watchRead
+	case "4":
+		kind = watchRead | watchWrite
+	default:
+		return ""
+	}
+
+	wl := s.d.apple2.mmu.Watchpoints()
+	wp := watchpoint{start: uint16(addr), end: uint16(addr + length - 1), kind: kind}
+	if set {
+		wl.Add(wp)
+		return "OK"
+	}
+	wl.Delete(wp)
+	return "OK"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestGDBStub(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0xa9, 0x42, //       0300: LDA #$42
+		0x8d, 0x00, 0x04, // 0302: STA $0400
+		0x4c, 0x00, 0x03, // 0305: JMP $0300
+	})
+	a.cpu.SetPC(0x0300)
+
+	client, server := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- a.AttachDebugger().ServeGDB(server)
+		server.Close()
+	}()
+
+	r := bufio.NewReader(client)
+	send := func(data string) {
+		fmt.Fprintf(client, "$%s#%02x", data, gdbChecksum(data))
+	}
+	recv := func() string {
+		c, _ := r.ReadByte()
+		for c == '+' {
+			c, _ = r.ReadByte()
+		}
+		if c != '$' {
+			t.Fatalf("Expected packet, got %q\n", c)
+		}
+		data, _ := r.ReadString('#')
+		r.Discard(2)
+		return data[:len(data)-1]
+	}
+	exchange := func(cmd, expected string) {
+		send(cmd)
+		if resp := recv(); resp != expected {
+			t.Errorf("%s: expected %q, got %q\n", cmd, expected, resp)
+		}
+	}
+
+	exchange("?", "S05")
+	exchange("m300,3", "a9428d")
+	exchange("p5", "0003")
+	exchange("s", "S05")
+	exchange("p0", "42")
+	exchange("Z2,400,1", "OK")
+	exchange("Z0,305,1", "OK")
+
+	// Continuing stops after the watched store.
+	send("c")
+	if resp := recv(); resp != "S05" || a.cpu.Reg.PC != 0x0305 {
+		t.Errorf("Expected watchpoint stop at 0305, got %q at %04X\n", resp, a.cpu.Reg.PC)
+	}
+	exchange("z2,400,1", "OK")
+	exchange("M400,2:1234", "OK")
+	exchange("m400,2", "1234")
+	exchange("P0=07", "OK")
+	exchange("g", "07"+"00"+"00"+fmt.Sprintf("%02x", a.cpu.Reg.SavePS(false))+"ff"+"0503")
+
+	// Interrupt a running CPU.
+	exchange("z0,305,1", "OK")
+	send("c")
+	client.Write([]byte{0x03})
+	if resp := recv(); resp != "S02" {
+		t.Errorf("Expected interrupt stop, got %q\n", resp)
+	}
+
+	send("k")
+	if c, _ := r.ReadByte(); c != '+' {
+		t.Errorf("Expected acknowledgement, got %q\n", c)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("ServeGDB: %v\n", err)
+	}
+	client.Close()
+}
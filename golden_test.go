@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The golden screen tests run programs and ROM routines for a fixed number
+// of frames and compare the screen with files in testdata/golden. After an
+// intended change to the display, regenerate the files with
+//
+//	go test -run TestGoldenScreens -update
+//
+// and review the differences before committing them.
+var updateGolden = flag.Bool("update", false, "regenerate the golden screen files")
+
+const goldenDir = "testdata/golden"
+
+// A goldenCase describes a golden screen test. Cases that need ROMs or
+// disk images load them from the resources directory, and are skipped if
+// they aren't there.
+type goldenCase struct {
+	name    string
+	model   machineModel
+	rom     bool   // load the model's ROMs and reset
+	disk    string // disk image in resources to boot from slot 6
+	program []byte // code loaded and started at $0300
+	frames  int
+	run     func(t *testing.T, a *apple2) // replaces running frames, if set
+	image   bool                          // compare the framebuffer as well as the text
+}
+
+var goldenCases = []goldenCase{
+	{
+		// Fill the low-resolution screen with every color pair.
+		name:  "lores",
+		model: modelIIPlus,
+		program: []byte{
+			0xad, 0x50, 0xc0, // LDA $C050  TEXT off
+			0xad, 0x56, 0xc0, // LDA $C056  LORES
+			0xad, 0x52, 0xc0, // LDA $C052  MIXED off
+			0xa2, 0x00, //       LDX #$00
+			0x8a,             // TXA
+			0x9d, 0x00, 0x04, // STA $0400,X
+			0x9d, 0x00, 0x05, // STA $0500,X
+			0x9d, 0x00, 0x06, // STA $0600,X
+			0x9d, 0x00, 0x07, // STA $0700,X
+			0xe8,       //       INX
+			0xd0, 0xf0, //       BNE $030B
+			0x4c, 0x1b, 0x03, // JMP $031B
+		},
+		frames: 2,
+		image:  true,
+	},
+	{
+		// Fill the high-resolution screen with a pattern that varies by
+		// byte and by page.
+		name:  "hires",
+		model: modelIIeEnhanced,
+		program: []byte{
+			0xad, 0x50, 0xc0, // LDA $C050  TEXT off
+			0xad, 0x57, 0xc0, // LDA $C057  HIRES
+			0xad, 0x52, 0xc0, // LDA $C052  MIXED off
+			0xa9, 0x00, //       LDA #$00
+			0x85, 0x06, //       STA $06
+			0xa9, 0x20, //       LDA #$20
+			0x85, 0x07, //       STA $07
+			0xa0, 0x00, //       LDY #$00
+			0x98,       //       TYA
+			0x45, 0x07, //       EOR $07
+			0x91, 0x06, //       STA ($06),Y
+			0xc8,       //       INY
+			0xd0, 0xf8, //       BNE $0313
+			0xe6, 0x07, //       INC $07
+			0xa5, 0x07, //       LDA $07
+			0xc9, 0x40, //       CMP #$40
+			0xd0, 0xf0, //       BNE $0313
+			0x4c, 0x23, 0x03, // JMP $0323
+		},
+		frames: 10,
+		image:  true,
+	},
+	{
+		// Fill the 80-column screen through 80STORE and PAGE2, with the
+		// alternate character set showing MouseText.
+		name:  "text80",
+		model: modelIIeEnhanced,
+		program: []byte{
+			0xad, 0x51, 0xc0, // LDA $C051  TEXT on
+			0x8d, 0x01, 0xc0, // STA $C001  80STORE on
+			0x8d, 0x0d, 0xc0, // STA $C00D  80COL on
+			0x8d, 0x0f, 0xc0, // STA $C00F  ALTCHARSET on
+			0xa2, 0x00, //       LDX #$00
+			0x8a,             // TXA
+			0x9d, 0x00, 0x04, // STA $0400,X
+			0x8d, 0x55, 0xc0, // STA $C055  PAGE2 on
+			0x49, 0x80, //       EOR #$80
+			0x9d, 0x00, 0x04, // STA $0400,X
+			0x8d, 0x54, 0xc0, // STA $C054  PAGE2 off
+			0xe8,       //       INX
+			0xd0, 0xee, //       BNE $030E
+			0x4c, 0x20, 0x03, // JMP $0320
+		},
+		frames: 2,
+		image:  true,
+	},
+	{
+		name:   "boot-iie",
+		model:  modelIIeEnhanced,
+		rom:    true,
+		frames: 120,
+		image:  true,
+	},
+	{
+		name:  "selftest-iie",
+		model: modelIIeEnhanced,
+		rom:   true,
+		run: func(t *testing.T, a *apple2) {
+			if _, err := a.RunSelfTest(); err != nil {
+				t.Fatal(err)
+			}
+		},
+	},
+	{
+		name:   "boot-dos33",
+		model:  modelIIeEnhanced,
+		rom:    true,
+		disk:   "dos33.dsk",
+		frames: 600,
+	},
+}
+
+func TestGoldenScreens(t *testing.T) {
+	for _, c := range goldenCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			a := newGoldenMachine(t, c)
+			if c.run != nil {
+				c.run(t, a)
+			} else {
+				for i := 0; i < c.frames; i++ {
+					a.RunFrame()
+				}
+			}
+
+			checkGolden(t, c.name+".txt", []byte(a.ds.Text()+"\n"), func(want []byte) string {
+				return fmt.Sprintf("text screen differs:\n%s\nexpected:\n%s", a.ds.Text(), want)
+			})
+			if c.image {
+				var buf bytes.Buffer
+				if err := png.Encode(&buf, a.ds.Framebuffer()); err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, c.name+".png", buf.Bytes(), func(want []byte) string {
+					return compareImages(a.ds.Framebuffer(), want)
+				})
+			}
+		})
+	}
+}
+
+// newGoldenMachine creates the machine for a case, skipping the test if
+// its ROMs or disk image are missing.
+func newGoldenMachine(t *testing.T, c goldenCase) *apple2 {
+	a := newApple2Model(c.model)
+	if c.rom {
+		rs, err := loadROMSet("resources")
+		if err == nil {
+			err = rs.Validate(c.model, c.disk != "")
+		}
+		if err == nil {
+			err = a.LoadROMSet(rs)
+		}
+		if err == nil && c.disk != "" {
+			var rom []byte
+			if rom, err = rs.DiskIIROM(); err == nil {
+				a.sm.Insert(6, newDiskII(a, rom))
+				err = a.InsertDisk(1, filepath.Join("resources", c.disk))
+			}
+		}
+		if err != nil {
+			t.Skip(err)
+		}
+		a.Reset()
+	}
+	if c.program != nil {
+		a.mmu.StoreBytes(0x0300, c.program)
+		a.cpu.SetPC(0x0300)
+	}
+	return a
+}
+
+// checkGolden compares data with a golden file, or replaces the file if
+// the -update flag is set. The diff function describes a mismatch.
+func checkGolden(t *testing.T, name string, data []byte, diff func(want []byte) string) {
+	t.Helper()
+	filename := filepath.Join(goldenDir, name)
+	if *updateGolden {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)\n", err)
+	}
+	if !bytes.Equal(data, want) {
+		if msg := diff(want); msg != "" {
+			t.Errorf("%s: %s\n", name, msg)
+		}
+	}
+}
+
+// compareImages describes the differences between a framebuffer and a
+// golden PNG image, or returns "" if their pixels are the same.
+func compareImages(got *image.RGBA, want []byte) string {
+	img, err := png.Decode(bytes.NewReader(want))
+	if err != nil {
+		return err.Error()
+	}
+	if img.Bounds() != got.Bounds() {
+		return fmt.Sprintf("size %v, expected %v", got.Bounds().Size(), img.Bounds().Size())
+	}
+
+	n, first := 0, image.Point{}
+	b := got.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r1, g1, b1, a1 := got.At(x, y).RGBA()
+			r2, g2, b2, a2 := img.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				if n == 0 {
+					first = image.Pt(x, y)
+				}
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d pixels differ, the first at %v", n, first)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// loResColors is the 16-color palette of lo-res and double hi-res
+// graphics.
+var loResColors = [16]color.RGBA{
+	{0x00, 0x00, 0x00, 0xff}, // black
+	{0xdd, 0x00, 0x33, 0xff}, // magenta
+	{0x00, 0x00, 0x99, 0xff}, // dark blue
+	{0xdd, 0x22, 0xdd, 0xff}, // purple
+	{0x00, 0x77, 0x22, 0xff}, // dark green
+	{0x55, 0x55, 0x55, 0xff}, // grey 1
+	{0x22, 0x22, 0xff, 0xff}, // medium blue
+	{0x66, 0xaa, 0xff, 0xff}, // light blue
+	{0x88, 0x55, 0x00, 0xff}, // brown
+	{0xff, 0x66, 0x00, 0xff}, // orange
+	{0xaa, 0xaa, 0xaa, 0xff}, // grey 2
+	{0xff, 0x99, 0x88, 0xff}, // pink
+	{0x11, 0xdd, 0x00, 0xff}, // green
+	{0xff, 0xff, 0x00, 0xff}, // yellow
+	{0x44, 0xff, 0x99, 0xff}, // aqua
+	{0xff, 0xff, 0xff, 0xff}, // white
+}
+
+// hiResColors holds the colors of isolated hi-res dots, indexed by the
+// palette bit and the parity of the dot's column.
+var hiResColors = [2][2]color.RGBA{
+	{loResColors[3], loResColors[12]}, // violet, green
+	{loResColors[6], loResColors[9]},  // blue, orange
+}
+
+var black = color.RGBA{0, 0, 0, 0xff}
+
+// renderGraphics draws the scanlines in the range [start, end) in the
+// graphics mode selected by the soft switches. In monochrome, the dots
+// generated by the video hardware are drawn without NTSC color.
+func (d *display) renderGraphics(fb *image.RGBA, start, end int, mono bool) {
+	iou := d.apple2.iou
+	dhires := d.apple2.cfg.iie && iou.testSoftSwitch(ioSwitchDHIRES) && iou.testSoftSwitch(ioSwitch80COL)
+	for y := start; y < end; y++ {
+		pix := fb.Pix[y*fb.Stride : y*fb.Stride+displayWidth*4]
+		switch {
+		case iou.testSoftSwitch(ioSwitchHIRES) && dhires:
+			d.renderDoubleHiResLine(pix, y, mono)
+		case iou.testSoftSwitch(ioSwitchHIRES):
+			d.renderHiResLine(pix, y, mono)
+		default:
+			d.renderLoResLine(pix, y, mono)
+		}
+	}
+}
+
+// hiResPage returns the address of the displayed hi-res page.
+func (d *display) hiResPage() uint16 {
+	iou := d.apple2.iou
+	if iou.testSoftSwitch(ioSwitchPAGE2) && !iou.testSoftSwitch(ioSwitch80STORE) {
+		return 0x4000
+	}
+	return 0x2000
+}
+
+// hiResLineAddress returns the address of the first byte of a hi-res
+// scanline.
+func hiResLineAddress(page uint16, y int) uint16 {
+	return page + uint16(y&7)<<10 + uint16((y>>3)&7)<<7 + uint16(y>>6)*40
+}
+
+func setPixel(pix []byte, x int, c color.RGBA) {
+	p := pix[x*4 : x*4+4]
+	p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+}
+
+// renderLoResLine draws a scanline of lo-res graphics. Each byte of the
+// text page holds two 14-dot wide blocks, the low nibble above the high
+// nibble. The video hardware shifts a block's color nibble out repeatedly,
+// starting at the bit given by the dot's position.
+func (d *display) renderLoResLine(pix []byte, y int, mono bool) {
+	addr := textRowAddress(d.textPage(), y/8)
+	shift := uint(0)
+	if y&4 != 0 {
+		shift = 4
+	}
+	for c, v := range d.apple2.mmu.mainRAM[addr : addr+40] {
+		nibble := (v >> shift) & 0x0f
+		for i := 0; i < 14; i++ {
+			x := c*14 + i
+			switch {
+			case !mono:
+				setPixel(pix, x, loResColors[nibble])
+			case nibble&(1<<uint(x&3)) != 0:
+				setPixel(pix, x, textColor)
+			default:
+				setPixel(pix, x, black)
+			}
+		}
+	}
+}
+
+// renderHiResLine draws a scanline of hi-res graphics. Each byte holds 7
+// dots, the least significant bit leftmost, and each dot is two pixels
+// wide. Setting the high bit of a byte delays its dots by one pixel, which
+// shifts their colors from violet and green to blue and orange.
+func (d *display) renderHiResLine(pix []byte, y int, mono bool) {
+	addr := hiResLineAddress(d.hiResPage(), y)
+	mem := d.apple2.mmu.mainRAM[addr : addr+40]
+
+	var dots [280 + 2]bool // one spare dot on each side
+	var pal [280]byte
+	for c, v := range mem {
+		for b := 0; b < 7; b++ {
+			dots[1+c*7+b] = v&(1<<uint(b)) != 0
+			pal[c*7+b] = v >> 7
+		}
+	}
+
+	if mono {
+		for i := range pix[:displayWidth*4] {
+			if i&3 == 3 {
+				pix[i] = 0xff
+			} else {
+				pix[i] = 0
+			}
+		}
+		for x := 0; x < 280; x++ {
+			if !dots[1+x] {
+				continue
+			}
+			px := x*2 + int(pal[x])
+			setPixel(pix, px, textColor)
+			if px+1 < displayWidth {
+				setPixel(pix, px+1, textColor)
+			}
+		}
+		return
+	}
+
+	for x := 0; x < 280; x++ {
+		left, on, right := dots[x], dots[1+x], dots[2+x]
+		var c color.RGBA
+		switch {
+		case on && (left || right):
+			c = loResColors[15]
+		case on:
+			c = hiResColors[pal[x]][x&1]
+		case left && right:
+			// A gap between two dots of the same color is filled in.
+			c = hiResColors[pal[x-1]][(x-1)&1]
+		default:
+			c = black
+		}
+		setPixel(pix, x*2, c)
+		setPixel(pix, x*2+1, c)
+	}
+}
+
+// renderDoubleHiResLine draws a scanline of double hi-res graphics. Each
+// column of 14 dots is drawn from a byte of aux memory followed by a byte
+// of main memory, 7 dots from each. In color, each group of 4 dots forms
+// the index of one of the 16 lo-res colors.
+func (d *display) renderDoubleHiResLine(pix []byte, y int, mono bool) {
+	addr := hiResLineAddress(d.hiResPage()&^0x4000|0x2000, y)
+	main := d.apple2.mmu.mainRAM[addr : addr+40]
+	aux := d.apple2.mmu.AuxVideoRAM()[addr : addr+40]
+
+	var dots [displayWidth]bool
+	for c := 0; c < 40; c++ {
+		for b := 0; b < 7; b++ {
+			dots[c*14+b] = aux[c]&(1<<uint(b)) != 0
+			dots[c*14+7+b] = main[c]&(1<<uint(b)) != 0
+		}
+	}
+
+	for x := 0; x < displayWidth; x += 4 {
+		var index byte
+		for i := 0; i < 4; i++ {
+			if dots[x+i] {
+				index |= 1 << uint(i)
+			}
+		}
+		for i := 0; i < 4; i++ {
+			switch {
+			case !mono:
+				setPixel(pix, x+i, loResColors[index])
+			case dots[x+i]:
+				setPixel(pix, x+i, textColor)
+			default:
+				setPixel(pix, x+i, black)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errRunTimeout = errors.New("timed out")
+
+// A runGoal is what RunUntil runs the machine until: text appearing on
+// the screen, the CPU reaching an address, or a number of cycles passing,
+// whichever comes first.
+type runGoal struct {
+	text   string // text to wait for on the screen, or ""
+	addr   int    // address of an instruction to stop before, or -1
+	cycles uint64 // cycles to run before giving up; 0 = runDefaultCycles
+}
+
+// runDefaultCycles is the number of cycles a goal without a limit runs
+// for: 10 seconds.
+const runDefaultCycles = 10 * cpuClockRate
+
+// A runStop tells which part of a goal stopped RunUntil.
+type runStop int
+
+const (
+	runStopText   runStop = iota // the text appeared on the screen
+	runStopAddr                  // the CPU reached the address
+	runStopCycles                // the cycles ran out
+)
+
+// RunUntil runs the machine until it reaches a goal, and returns which
+// part of the goal it reached. A goal with text or an address times out
+// when its cycles run out, returning errRunTimeout. The machine stops at
+// the start of the instruction at the address, but checks for text only
+// at the end of each frame; runs are repeatable, so a test of a whole
+// program can check where the program gets to and what it shows.
+func (a *apple2) RunUntil(g runGoal) (runStop, error) {
+	limit := g.cycles
+	if limit == 0 {
+		limit = runDefaultCycles
+	}
+
+	reached := false
+	if g.addr >= 0 {
+		addr := uint16(g.addr)
+		old := a.enableTraps().addrs[addr]
+		a.TrapAddr(addr, func(pc uint16) bool {
+			if old != nil {
+				old(pc)
+			}
+			reached = true
+			return true
+		})
+		defer a.TrapAddr(addr, old)
+	}
+
+	end := a.cpu.Cycles + limit
+	for {
+		switch {
+		case reached:
+			return runStopAddr, nil
+		case g.text != "" && a.ScreenContains(g.text):
+			return runStopText, nil
+		case a.cpu.Cycles >= end:
+			if g.text != "" || g.addr >= 0 {
+				return runStopCycles, fmt.Errorf("%w after %d cycles waiting for %s", errRunTimeout, limit, g)
+			}
+			return runStopCycles, nil
+		}
+		a.RunFrame()
+	}
+}
+
+func (g runGoal) String() string {
+	var s []string
+	if g.text != "" {
+		s = append(s, fmt.Sprintf("%q", g.text))
+	}
+	if g.addr >= 0 {
+		s = append(s, fmt.Sprintf("$%04X", g.addr))
+	}
+	return strings.Join(s, " or ")
+}
+
+// ScreenContains returns true if text appears on a row of the screen.
+func (a *apple2) ScreenContains(text string) bool {
+	for _, row := range a.ds.TextRunes() {
+		if strings.Contains(string(row), text) {
+			return true
+		}
+	}
+	return false
+}
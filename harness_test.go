@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunUntil(t *testing.T) {
+	newMachine := func() *apple2 {
+		a := newApple2()
+		a.mmu.StoreBytes(0x300, []byte{
+			0xe6, 0x10, // INC $10
+			0xd0, 0xfc, // BNE $0300
+			0xe6, 0x11, // INC $11
+			0xa5, 0x11, // LDA $11
+			0xc9, 0x40, // CMP #$40
+			0xd0, 0xf4, // BNE $0300
+			0xa9, 0xc4, // LDA #'D'
+			0x8d, 0x00, 0x04, // STA $0400
+			0xa9, 0xcf, // LDA #'O'
+			0x8d, 0x01, 0x04, // STA $0401
+			0x4c, 0x16, 0x03, // JMP $0316
+		})
+		a.mmu.StoreByte(0xc051, 0) // TEXT on
+		a.cpu.SetPC(0x300)
+		return a
+	}
+
+	a := newMachine()
+	if stop, err := a.RunUntil(runGoal{text: "DO", addr: 0x30c}); stop != runStopAddr || err != nil || a.cpu.Reg.PC != 0x30c {
+		t.Fatalf("Expected to stop at 030C, stopped at %04X (%d, %v)\n", a.cpu.Reg.PC, stop, err)
+	}
+	at := a.cpu.Cycles
+	if stop, err := a.RunUntil(runGoal{text: "DO", addr: -1}); stop != runStopText || err != nil {
+		t.Errorf("Expected the text, got %d (%v)\n", stop, err)
+	}
+	if stop, err := a.RunUntil(runGoal{text: "DONE", addr: 0x300, cycles: 100000}); stop != runStopCycles || !errors.Is(err, errRunTimeout) {
+		t.Errorf("Expected a timeout, got %d (%v)\n", stop, err)
+	}
+	if stop, err := a.RunUntil(runGoal{addr: -1, cycles: 1000}); stop != runStopCycles || err != nil {
+		t.Errorf("Expected to run for the cycles, got %d (%v)\n", stop, err)
+	}
+	if len(a.traps.addrs) != 0 {
+		t.Errorf("Expected the address traps to be removed\n")
+	}
+
+	// Runs are repeatable.
+	b := newMachine()
+	b.RunUntil(runGoal{addr: 0x30c})
+	if b.cpu.Cycles != at {
+		t.Errorf("Expected to reach 030C after %d cycles again, took %d\n", at, b.cpu.Cycles)
+	}
+}
+
+// TestRunUntilBoot shows how a test runs real software: it boots the
+// machine and checks that Applesoft's prompt appears.
+func TestRunUntilBoot(t *testing.T) {
+	a := newGoldenMachine(t, goldenCase{model: modelIIeEnhanced, rom: true})
+	if _, err := a.RunUntil(runGoal{text: "]", addr: 0xff69, cycles: 5 * cpuClockRate}); err != nil {
+		t.Fatal(err)
+	}
+	if a.cpu.Reg.PC == 0xff69 {
+		t.Errorf("Expected Applesoft, entered the monitor\n")
+	}
+}
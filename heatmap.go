@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A heatmap counts the reads, writes and executions of each address in
+// the CPU's address space. Counts are by address as currently mapped, so
+// main and aux memory at the same address share a count. Reads include
+// the CPU's instruction fetches; executions count only the first byte of
+// each instruction.
+type heatmap struct {
+	reads  [0x10000]uint32
+	writes [0x10000]uint32
+	execs  [0x10000]uint32
+}
+
+// EnableHeatmap starts counting memory accesses, returning the heatmap.
+// Counting disables direct page access, so the machine runs slower while
+// it's enabled.
+func (m *mmu) EnableHeatmap() *heatmap {
+	if m.heat == nil {
+		m.heat = &heatmap{}
+		m.updatePages()
+	}
+	return m.heat
+}
+
+// DisableHeatmap stops counting memory accesses and discards the counts.
+func (m *mmu) DisableHeatmap() {
+	if m.heat != nil {
+		m.heat = nil
+		m.updatePages()
+	}
+}
+
+// Heatmap returns the heatmap, or nil if counting is disabled.
+func (m *mmu) Heatmap() *heatmap {
+	return m.heat
+}
+
+// Clear resets all counts to zero.
+func (h *heatmap) Clear() {
+	*h = heatmap{}
+}
+
+// count returns the accesses of a kind to addresses start..end.
+func (h *heatmap) count(kind watchKind, start, end int) uint64 {
+	counts := &h.reads
+	switch kind {
+	case watchWrite:
+		counts = &h.writes
+	case watchExec:
+		counts = &h.execs
+	}
+	var n uint64
+	for addr := start; addr <= end; addr++ {
+		n += uint64(counts[addr])
+	}
+	return n
+}
+
+// pageKind returns the kinds of access made to a page.
+func (h *heatmap) pageKind(p int) watchKind {
+	var k watchKind
+	for _, kind := range []watchKind{watchRead, watchWrite, watchExec} {
+		if h.count(kind, p<<8, p<<8|0xff) != 0 {
+			k |= kind
+		}
+	}
+	return k
+}
+
+// WriteMap writes a map of the 256 pages of memory, one row for each 16
+// pages, marking each page with x if it was executed, w if it was written,
+// r if it was only read, or . if it wasn't accessed.
+func (h *heatmap) WriteMap(w io.Writer) {
+	fmt.Fprintln(w, "   0123456789ABCDEF")
+	for row := 0; row < 16; row++ {
+		var line [16]byte
+		for col := range line {
+			k := h.pageKind(row<<4 | col)
+			switch {
+			case k&watchExec != 0:
+				line[col] = 'x'
+			case k&watchWrite != 0:
+				line[col] = 'w'
+			case k&watchRead != 0:
+				line[col] = 'r'
+			default:
+				line[col] = '.'
+			}
+		}
+		fmt.Fprintf(w, "%X0 %s\n", row, line[:])
+	}
+}
+
+// UnusedRanges returns the ranges of pages that weren't accessed at all,
+// as inclusive address ranges.
+func (h *heatmap) UnusedRanges() [][2]uint16 {
+	var ranges [][2]uint16
+	start := -1
+	for p := 0; p <= 0x100; p++ {
+		unused := p < 0x100 && h.pageKind(p) == 0
+		switch {
+		case unused && start < 0:
+			start = p
+		case !unused && start >= 0:
+			ranges = append(ranges, [2]uint16{uint16(start << 8), uint16(p<<8 - 1)})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// WriteCSV writes the counts as CSV, with a row for each page, or for
+// each address if perByte is true. Rows with no accesses are omitted.
+func (h *heatmap) WriteCSV(w io.Writer, perByte bool) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "addr,reads,writes,execs")
+	size := 0x100
+	if perByte {
+		size = 1
+	}
+	for addr := 0; addr < 0x10000; addr += size {
+		end := addr + size - 1
+		r, wr, x := h.count(watchRead, addr, end), h.count(watchWrite, addr, end), h.count(watchExec, addr, end)
+		if r|wr|x != 0 {
+			fmt.Fprintf(bw, "%04X,%d,%d,%d\n", addr, r, wr, x)
+		}
+	}
+	return bw.Flush()
+}
+
+// Image returns a 256x256 image of the counts, with a row for each page
+// and a pixel for each address. Reads are shown in green, writes in red
+// and executions in blue, on a logarithmic scale.
+func (h *heatmap) Image() *image.RGBA {
+	var max uint32
+	for addr := range h.reads {
+		for _, c := range []uint32{h.reads[addr], h.writes[addr], h.execs[addr]} {
+			if c > max {
+				max = c
+			}
+		}
+	}
+	scale := func(c uint32) uint8 {
+		if c == 0 {
+			return 0
+		}
+		return uint8(64 + 191*math.Log(float64(c))/math.Log(float64(max)+1))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for addr := range h.reads {
+		img.SetRGBA(addr&0xff, addr>>8, color.RGBA{
+			R: scale(h.writes[addr]),
+			G: scale(h.reads[addr]),
+			B: scale(h.execs[addr]),
+			A: 0xff,
+		})
+	}
+	return img
+}
+
+// Save writes the heatmap to a PNG image file if the file has a .png
+// extension, or to a CSV file otherwise.
+func (h *heatmap) Save(filename string, perByte bool) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".png") {
+		err = png.Encode(file, h.Image())
+	} else {
+		err = h.WriteCSV(file, perByte)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeatmap(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0xad, 0x00, 0x20, // 0300: LDA $2000
+		0x8d, 0x00, 0x60, // 0303: STA $6000
+		0x4c, 0x00, 0x03, // 0306: JMP $0300
+	})
+	a.cpu.SetPC(0x0300)
+
+	d := a.AttachDebugger()
+	var out bytes.Buffer
+	if err := d.Exec("hm", &out); err != errHeatmapOff {
+		t.Errorf("Expected the heatmap off, got %v\n", err)
+	}
+	h := a.mmu.EnableHeatmap()
+	for i := 0; i < 30; i++ {
+		a.step()
+	}
+	a.mmu.Peek(0x9000)
+
+	if n := h.count(watchExec, 0x0300, 0x03ff); n != 30 {
+		t.Errorf("Expected 30 executions, got %d\n", n)
+	}
+	if h.reads[0x2000] != 10 || h.writes[0x6000] != 10 || h.execs[0x0303] != 10 {
+		t.Errorf("Expected 10 accesses each, got %d reads, %d writes, %d executions\n",
+			h.reads[0x2000], h.writes[0x6000], h.execs[0x0303])
+	}
+
+	out.Reset()
+	d.Exec("hm", &out)
+	lines := strings.Split(out.String(), "\n")
+	if lines[1] != "00 ...x............" || lines[3] != "20 r..............." || lines[7] != "60 w..............." {
+		t.Errorf("Unexpected map %q\n", out.String())
+	}
+	out.Reset()
+	d.Exec("hm unused", &out)
+	if !strings.HasPrefix(out.String(), "0000-02FF\n0400-1FFF\n2100-5FFF\n6100-FFFF\n") {
+		t.Errorf("Unexpected unused ranges %q\n", out.String())
+	}
+
+	dir := t.TempDir()
+	csv, img := filepath.Join(dir, "heat.csv"), filepath.Join(dir, "heat.png")
+	if err := d.Exec("hm save "+csv, &out); err != nil {
+		t.Fatalf("hm save: %v\n", err)
+	}
+	if b, _ := os.ReadFile(csv); !strings.HasPrefix(string(b), "addr,reads,writes,execs\n0300,") ||
+		!strings.HasSuffix(string(b), ",0,30\n2000,10,0,0\n6000,0,10,0\n") {
+		t.Errorf("Unexpected CSV %q\n", b)
+	}
+	d.Exec("hm save "+img, &out)
+	f, err := os.Open(img)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	defer f.Close()
+	if m, err := png.Decode(f); err != nil || m.Bounds().Dx() != 256 {
+		t.Errorf("Expected a 256x256 image (%v)\n", err)
+	}
+
+	d.Exec("hm clear", &out)
+	if h.count(watchRead, 0, 0xffff) != 0 {
+		t.Errorf("Expected counts cleared\n")
+	}
+	d.Exec("hm off", &out)
+	if a.mmu.Heatmap() != nil || a.mmu.pages[0x03].rmem == nil {
+		t.Errorf("Expected counting off and direct pages restored\n")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+// The IIc has no expansion slots. Its serial ports, mouse and disk port
+// are built in, with firmware in the internal ROM and I/O registers at
+// the addresses the equivalent cards would occupy in slots 1, 2, 4 and 6.
+// The built-in devices are installed into the slot manager so that their
+// I/O is dispatched the same way as on other models; the internal ROM
+// always shadows their slot ROM space.
+
+// An iicSerialPort is one of the IIc's two built-in serial ports. Its
+// 6551 ACIA registers appear at offsets 8..B of the slot I/O space.
+type iicSerialPort struct {
+	apple2 *apple2
+	acia   *acia6551
+}
+
+func newIICSerialPort(apple2 *apple2) *iicSerialPort {
+	return &iicSerialPort{
+		apple2: apple2,
+		acia:   newACIA6551(apple2),
+	}
+}
+
+func (p *iicSerialPort) LoadIO(addr uint16) byte {
+	if addr < 8 || addr > 0xb {
+		return p.apple2.vs.FloatingBus()
+	}
+	return p.acia.LoadByte(addr - 8)
+}
+
+func (p *iicSerialPort) StoreIO(addr uint16, v byte) {
+	if addr >= 8 && addr <= 0xb {
+		p.acia.StoreByte(addr-8, v)
+	}
+}
+
+func (p *iicSerialPort) LoadROM(addr uint16) byte {
+	return p.apple2.vs.FloatingBus()
+}
+
+func (p *iicSerialPort) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// IRQ reports whether the port's ACIA is requesting an interrupt.
+func (p *iicSerialPort) IRQ() bool {
+	return p.acia.IRQ()
+}
+
+// installBuiltinPorts installs the IIc's built-in devices.
+func (a *apple2) installBuiltinPorts() {
+	a.sm.Insert(1, newIICSerialPort(a))
+	a.sm.Insert(2, newIICSerialPort(a))
+	a.sm.Insert(6, newDiskII(a, nil))
+	a.mouse = newIICMouse(a)
+}
+
+// SerialPort returns the ACIA of built-in serial port 1 or 2, or nil if
+// the model has no built-in serial ports.
+func (a *apple2) SerialPort(port int) *acia6551 {
+	if p, ok := a.sm.Card(port).(*iicSerialPort); ok {
+		return p.acia
+	}
+	return nil
+}
+
+func (p *iicSerialPort) saveState(sw *stateWriter) { p.acia.saveState(sw) }
+func (p *iicSerialPort) loadState(sr *stateReader) { p.acia.loadState(sr) }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIIcROMBank(t *testing.T) {
+	a := newApple2Model(modelIIc)
+
+	rom := make([]byte, 32*1024)
+	rom[0x3fff] = 0x11
+	rom[0x7fff] = 0x22
+	if err := a.mmu.LoadSystemROM(bytes.NewReader(rom)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []byte{0x11, 0x22, 0x11} {
+		if v := a.mmu.LoadByte(0xffff); v != expected {
+			t.Errorf("Access %d: expected %02x, got %02x\n", i, expected, v)
+		}
+		a.mmu.LoadByte(0xc028)
+	}
+
+	// A 16K ROM has a single bank, so $C028 has no effect.
+	if err := a.mmu.LoadSystemROM(bytes.NewReader(rom[:16*1024])); err != nil {
+		t.Fatal(err)
+	}
+	a.mmu.LoadByte(0xc028)
+	if v := a.mmu.LoadByte(0xffff); v != 0x11 {
+		t.Errorf("Single bank ROM: expected 11, got %02x\n", v)
+	}
+}
+
+func TestIIcMouse(t *testing.T) {
+	a := newApple2Model(modelIIc)
+
+	a.mmu.LoadByte(0xc059) // enable X0/Y0 interrupts
+	a.mouse.Move(-2, 0)
+	a.mouse.Update()
+
+	for i := 0; i < 2; i++ {
+		if !a.mouse.IRQ() {
+			t.Fatalf("Edge %d: expected a mouse interrupt\n", i)
+		}
+		if v := a.mmu.LoadByte(0xc066) & 0x80; v != 0 {
+			t.Errorf("Edge %d: expected X1 to indicate leftward motion\n", i)
+		}
+		if v := a.mmu.LoadByte(0xc015) & 0x80; v == 0 {
+			t.Errorf("Edge %d: expected X0 interrupt flag\n", i)
+		}
+	}
+	if a.mouse.IRQ() {
+		t.Errorf("Unexpected interrupt after motion was delivered\n")
+	}
+
+	a.mouse.SetButton(true)
+	if v := a.mmu.LoadByte(0xc063) & 0x80; v != 0 {
+		t.Errorf("Expected button pressed\n")
+	}
+}
+
+func TestIIcSerialPort(t *testing.T) {
+	a := newApple2Model(modelIIc)
+	host, conn := net.Pipe()
+	acia := a.SerialPort(2)
+	acia.Connect(conn)
+	defer acia.Disconnect()
+
+	a.mmu.StoreByte(0xc0aa, 0x0b) // DTR on, receiver interrupts off
+	a.mmu.StoreByte(0xc0ab, 0x1e) // 9600 baud
+
+	go host.Write([]byte("A"))
+	deadline := time.Now().Add(time.Second)
+	for a.mmu.LoadByte(0xc0a9)&aciaStatusRDRF == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for serial data")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v := a.mmu.LoadByte(0xc0a8); v != 'A' {
+		t.Errorf("Expected 'A', got %02x\n", v)
+	}
+
+	a.mmu.StoreByte(0xc0a8, 'B')
+	buf := make([]byte, 1)
+	host.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := host.Read(buf); err != nil || buf[0] != 'B' {
+		t.Errorf("Expected 'B' from serial port, got %q (%v)\n", buf, err)
+	}
+}
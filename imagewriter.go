@@ -0,0 +1,296 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// Printed pages are rendered at 144 dots per inch, the ImageWriter's
+// vertical resolution with half-dot line feeds, on 8.5" x 11" paper.
+const (
+	printerDPI        = 144
+	printerPageWidth  = printerDPI * 17 / 2
+	printerPageHeight = printerDPI * 11
+	printerDotHeight  = 2 // height of a print head dot (1/72")
+)
+
+// ImageWriter pitches, selected by ESC followed by the key.
+var imageWriterPitches = map[byte]float64{
+	'n': 9,    // extended
+	'N': 10,   // pica
+	'p': 10,   // pica proportional, printed as pica
+	'E': 12,   // elite
+	'P': 12,   // elite proportional, printed as elite
+	'e': 13.4, // semicondensed
+	'q': 15,   // condensed
+	'Q': 17,   // ultracondensed
+}
+
+// imageWriterArgs holds the number of argument bytes that follow each
+// ImageWriter escape sequence that takes arguments.
+var imageWriterArgs = map[byte]int{
+	'T': 2, // line spacing in 1/144"
+	'L': 3, // left margin in characters
+	'F': 4, // head position in dots
+	'G': 4, // graphics columns
+	'S': 4, // graphics columns
+	'g': 3, // graphics columns / 8
+	'R': 4, // repeat count and character
+	'V': 5, // graphics repeat count and column
+	'a': 1, // font
+	'D': 2, // set switches
+	'Z': 2, // clear switches
+	'l': 1, // select-in
+	'K': 1, // color
+	's': 1, // intercharacter space
+}
+
+// An imageWriter emulates an Apple ImageWriter printer, rendering its
+// text and dot graphics into page images. It interprets the common
+// escape sequences for pitch, bold and underlined text, line spacing,
+// margins, head positioning, repeats and 8-dot graphics; others are
+// ignored. A carriage return also feeds a line, as with the printer's
+// automatic line feed switch on, and a line feed that follows it is
+// ignored.
+type imageWriter struct {
+	pages  []*image.Gray
+	page   *image.Gray
+	inked  bool    // the current page has been printed on
+	x      float64 // head position in dots from the left edge
+	y      int     // top of the current line in dots
+	cpi    float64 // characters per inch
+	lineH  int     // line spacing in dots
+	margin int     // left margin in characters
+	bold   bool
+	under  bool
+	rev    bool // line feeds move up the page
+	cr     bool // last character was a carriage return
+
+	esc      []byte // escape sequence being collected
+	graphics int    // graphics bytes still to come
+	finish   func(pages []*image.Gray) error
+}
+
+func newImageWriter(finish func(pages []*image.Gray) error) *imageWriter {
+	w := &imageWriter{finish: finish}
+	w.reset()
+	w.newPage()
+	return w
+}
+
+// reset restores the power-on settings.
+func (w *imageWriter) reset() {
+	w.cpi = 10
+	w.lineH = printerDPI / 6
+	w.margin = 0
+	w.bold, w.under, w.rev = false, false, false
+}
+
+func (w *imageWriter) Write(b []byte) (int, error) {
+	for _, c := range b {
+		w.print(c)
+	}
+	return len(b), nil
+}
+
+// Close ejects the last page and finishes the printout.
+func (w *imageWriter) Close() error {
+	if w.inked {
+		w.pages = append(w.pages, w.page)
+	}
+	return w.finish(w.pages)
+}
+
+func (w *imageWriter) print(c byte) {
+	switch {
+	case w.graphics > 0:
+		w.graphics--
+		w.column(c)
+		return
+	case w.esc != nil:
+		w.escape(c)
+		return
+	}
+
+	c &= 0x7f
+	cr := w.cr
+	w.cr = false
+	switch c {
+	case 0x1b:
+		w.esc = []byte{}
+	case '\r':
+		w.x = w.left()
+		w.lineFeed(w.lineH)
+		w.cr = true
+	case '\n':
+		if !cr {
+			w.lineFeed(w.lineH)
+		}
+	case '\f':
+		w.formFeed()
+	case '\b':
+		if w.x -= w.cellWidth(); w.x < w.left() {
+			w.x = w.left()
+		}
+	case '\t':
+		n := float64(int((w.x-w.left())/w.cellWidth())/8+1) * 8
+		w.x = w.left() + n*w.cellWidth()
+	default:
+		if c >= 0x20 && c < 0x7f {
+			w.char(c)
+		}
+	}
+}
+
+// escape collects an escape sequence and executes it once complete.
+func (w *imageWriter) escape(c byte) {
+	w.esc = append(w.esc, c)
+	key := w.esc[0]
+	if len(w.esc) < 1+imageWriterArgs[key] {
+		return
+	}
+	// Numeric arguments are decimal digits. The repeat sequences are
+	// followed by the character or graphics column to repeat.
+	digits := w.esc[1:]
+	if key == 'R' || key == 'V' {
+		digits = digits[:len(digits)-1]
+	}
+	arg, _ := strconv.Atoi(string(digits))
+	last := w.esc[len(w.esc)-1]
+	w.esc = nil
+
+	if cpi, ok := imageWriterPitches[key]; ok {
+		w.cpi = cpi
+		return
+	}
+	switch key {
+	case '!':
+		w.bold = true
+	case '"':
+		w.bold = false
+	case 'X':
+		w.under = true
+	case 'Y':
+		w.under = false
+	case 'A':
+		w.lineH = printerDPI / 6
+	case 'B':
+		w.lineH = printerDPI / 8
+	case 'T':
+		w.lineH = arg
+	case 'f':
+		w.rev = false
+	case 'r':
+		w.rev = true
+	case 'L':
+		w.margin = arg
+	case 'F':
+		w.x = w.left() + float64(arg)*w.dotWidth()
+	case 'G', 'S':
+		w.graphics = arg
+	case 'g':
+		w.graphics = arg * 8
+	case 'R':
+		for i := 0; i < arg; i++ {
+			w.print(last)
+		}
+	case 'V':
+		for i := 0; i < arg; i++ {
+			w.column(last)
+		}
+	case 'c':
+		w.reset()
+	}
+}
+
+// left returns the position of the left margin in dots.
+func (w *imageWriter) left() float64 {
+	return float64(w.margin) * w.cellWidth()
+}
+
+// cellWidth returns the width of a character in dots.
+func (w *imageWriter) cellWidth() float64 {
+	return printerDPI / w.cpi
+}
+
+// dotWidth returns the width of a graphics column in dots. Graphics print
+// eight columns per character.
+func (w *imageWriter) dotWidth() float64 {
+	return w.cellWidth() / 8
+}
+
+func (w *imageWriter) lineFeed(n int) {
+	if w.rev {
+		if w.y -= n; w.y < 0 {
+			w.y = 0
+		}
+		return
+	}
+	if w.y += n; w.y+w.lineH > printerPageHeight {
+		w.formFeed()
+	}
+}
+
+func (w *imageWriter) formFeed() {
+	w.pages = append(w.pages, w.page)
+	w.newPage()
+}
+
+func (w *imageWriter) newPage() {
+	w.page = image.NewGray(image.Rect(0, 0, printerPageWidth, printerPageHeight))
+	for i := range w.page.Pix {
+		w.page.Pix[i] = 0xff
+	}
+	w.inked = false
+	w.y = 0
+}
+
+// dot prints a dot at a head position and row of dots.
+func (w *imageWriter) dot(x float64, row int) {
+	x0, x1 := int(x), int(x+w.dotWidth()+0.5)
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if w.bold {
+		x1++
+	}
+	for y := w.y + row*printerDotHeight; y < w.y+(row+1)*printerDotHeight; y++ {
+		for x := x0; x < x1; x++ {
+			if x < printerPageWidth && y < printerPageHeight {
+				w.page.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+	w.inked = true
+}
+
+// char prints a character in the built-in font, which is 5 dots wide
+// centered in 8 graphics columns, and advances the head.
+func (w *imageWriter) char(c byte) {
+	g := fallbackGlyph(c)
+	for row, dots := range g {
+		for col := 0; col < 7; col++ {
+			if dots&(0x40>>uint(col)) != 0 {
+				w.dot(w.x+float64(col)*w.dotWidth(), row)
+			}
+		}
+	}
+	if w.under {
+		for col := 0; col < 8; col++ {
+			w.dot(w.x+float64(col)*w.dotWidth(), 8)
+		}
+	}
+	w.x += w.cellWidth()
+}
+
+// column prints a column of graphics dots, with bit 0 the top dot, and
+// advances the head.
+func (w *imageWriter) column(c byte) {
+	for row := 0; row < 8; row++ {
+		if c&(1<<uint(row)) != 0 {
+			w.dot(w.x, row)
+		}
+	}
+	w.x += w.dotWidth()
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// An inputKind identifies the type of an inputEvent.
+type inputKind byte
+
+const (
+	inputKey         inputKind = iota // host key press (v = 1) or release (v = 0) of key n
+	inputPaste                        // key codes of pasted text
+	inputPaddle                       // paddle n moved to position v
+	inputButton                       // push button n pressed (v = 1) or released (v = 0)
+	inputMouseMove                    // mouse moved n units horizontally and v vertically
+	inputMouseButton                  // mouse button pressed (v = 1) or released (v = 0)
+	inputDiskInsert                   // disk image data in format v inserted into drive n
+	inputDiskEject                    // disk ejected from drive n
+)
+
+// inputDiskWriteProtected is set in the value of an inputDiskInsert event
+// when the disk is write-protected.
+const inputDiskWriteProtected = 0x100
+
+// An inputEvent is a change to the machine's input, stamped with the CPU
+// cycle at which it took effect.
+type inputEvent struct {
+	cycle uint64
+	kind  inputKind
+	n, v  int
+	text  string // pasted key codes
+	data  []byte // inserted disk image
+}
+
+// An inputRecorder receives every input event applied to the machine,
+// including replayed events.
+type inputRecorder interface {
+	RecordInput(e inputEvent)
+}
+
+// The inputLog tracks host input as it is applied to the machine, so
+// that a run of the emulator can be reproduced by loading a snapshot and
+// replaying the input recorded after it. Key presses take effect when the
+// keyboard processes them at the end of a frame. All other input is
+// sampled at the start of each frame; input supplied between calls to
+// RunFrame therefore replays exactly.
+//
+// While a replay is in progress, host input is ignored.
+type inputLog struct {
+	apple2 *apple2
+
+	recorders []inputRecorder
+	replay    []inputEvent // events being replayed
+	replayPos int          // index of the next event to replay
+	replayEnd uint64       // CPU cycle at which the replay ends
+	replaying atomic.Bool  // true while a replay is in progress
+
+	// Input state as of the last sample.
+	paddles     [numPaddles]byte
+	buttons     [numButtons]bool
+	mouseButton bool
+}
+
+func newInputLog(apple2 *apple2) *inputLog {
+	return &inputLog{
+		apple2: apple2,
+	}
+}
+
+func (l *inputLog) Init() {
+	l.sync()
+}
+
+// AddRecorder starts sending host input events to a recorder.
+func (l *inputLog) AddRecorder(r inputRecorder) {
+	l.recorders = append(l.recorders, r)
+}
+
+// RemoveRecorder stops sending host input events to a recorder.
+func (l *inputLog) RemoveRecorder(r inputRecorder) {
+	for i, rr := range l.recorders {
+		if rr == r {
+			l.recorders = append(l.recorders[:i], l.recorders[i+1:]...)
+			return
+		}
+	}
+}
+
+// Replaying returns true while recorded input is being replayed. It is
+// safe to call from any goroutine.
+func (l *inputLog) Replaying() bool {
+	return l.replaying.Load()
+}
+
+// StartReplay begins replaying recorded events in place of host input.
+// Events are applied when the CPU reaches the cycles at which they were
+// recorded. The replay ends at the first frame to start on or after the
+// end cycle.
+func (l *inputLog) StartReplay(events []inputEvent, end uint64) {
+	kb := l.apple2.kb
+	kb.mu.Lock()
+	kb.events = kb.events[:0]
+	kb.mu.Unlock()
+
+	l.replay = events
+	l.replayPos = 0
+	l.replayEnd = end
+	l.replaying.Store(true)
+}
+
+// StopReplay ends a replay. Host input resumes from the machine's current
+// input state.
+func (l *inputLog) StopReplay() {
+	l.replay, l.replayPos = nil, 0
+	l.replaying.Store(false)
+	l.sync()
+}
+
+// sync updates the sampled input state to match the machine, so that
+// changes made other than by host input aren't recorded.
+func (l *inputLog) sync() {
+	g := l.apple2.gi
+	g.mu.Lock()
+	l.paddles, l.buttons = g.paddles, g.buttons
+	g.mu.Unlock()
+
+	if m := l.apple2.MouseInput(); m != nil {
+		m.mu.Lock()
+		l.mouseButton = m.button
+		m.hostDX, m.hostDY = 0, 0
+		m.mu.Unlock()
+	}
+
+	kb := l.apple2.kb
+	kb.mu.Lock()
+	kb.pasted = kb.pasted[:0]
+	kb.mu.Unlock()
+	kb.processed = kb.processed[:0]
+}
+
+// BeginFrame records the host input supplied since the previous frame or,
+// during a replay, applies the recorded input due at the current cycle.
+func (l *inputLog) BeginFrame() {
+	if l.Replaying() && l.apple2.cpu.Cycles >= l.replayEnd {
+		l.StopReplay()
+	}
+	if l.Replaying() {
+		l.replayDue(false)
+		return
+	}
+
+	now := l.apple2.cpu.Cycles
+
+	kb := l.apple2.kb
+	kb.mu.Lock()
+	if len(kb.pasted) > 0 {
+		l.record(inputEvent{cycle: now, kind: inputPaste, text: string(kb.pasted)})
+		kb.pasted = kb.pasted[:0]
+	}
+	kb.mu.Unlock()
+
+	g := l.apple2.gi
+	g.mu.Lock()
+	paddles, buttons := g.paddles, g.buttons
+	g.mu.Unlock()
+	for i, v := range paddles {
+		if v != l.paddles[i] {
+			l.record(inputEvent{cycle: now, kind: inputPaddle, n: i, v: int(v)})
+		}
+	}
+	for i, v := range buttons {
+		if v != l.buttons[i] {
+			l.record(inputEvent{cycle: now, kind: inputButton, n: i, v: boolInt(v)})
+		}
+	}
+	l.paddles, l.buttons = paddles, buttons
+
+	if m := l.apple2.MouseInput(); m != nil {
+		m.mu.Lock()
+		dx, dy, button := m.hostDX, m.hostDY, m.button
+		m.hostDX, m.hostDY = 0, 0
+		m.mu.Unlock()
+		if dx != 0 || dy != 0 {
+			l.record(inputEvent{cycle: now, kind: inputMouseMove, n: dx, v: dy})
+		}
+		if button != l.mouseButton {
+			l.record(inputEvent{cycle: now, kind: inputMouseButton, v: boolInt(button)})
+			l.mouseButton = button
+		}
+	}
+
+	// Disk changes that waited for a write to finish are recorded as
+	// they're made.
+	if d, err := l.apple2.diskController(); err == nil {
+		d.applyChanges()
+	}
+}
+
+// UpdateKeyboard processes key events and records the ones the keyboard
+// accepted. During a replay, the recorded key events due at the current
+// cycle are processed in place of host key events.
+func (l *inputLog) UpdateKeyboard() {
+	kb := l.apple2.kb
+	if l.Replaying() {
+		l.replayDue(true)
+	}
+
+	kb.Update()
+	now := l.apple2.cpu.Cycles
+	for _, e := range kb.processed {
+		l.record(inputEvent{cycle: now, kind: inputKey, n: int(e.key), v: boolInt(e.down)})
+	}
+	kb.processed = kb.processed[:0]
+}
+
+// RecordDiskInsert records a disk inserted into a drive by the host.
+// Recorders receive a copy of the disk's nibbles, so that the disk replays
+// the same way even if its file later changes.
+func (l *inputLog) RecordDiskInsert(drive int, disk *diskImage) {
+	if len(l.recorders) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, track := range disk.tracks {
+		buf.Write(track)
+	}
+	v := int(diskFormatNIB)
+	if disk.writeProtected {
+		v |= inputDiskWriteProtected
+	}
+	l.record(inputEvent{cycle: l.apple2.cpu.Cycles, kind: inputDiskInsert, n: drive, v: v, data: buf.Bytes()})
+}
+
+// RecordDiskEject records a disk ejected from a drive by the host.
+func (l *inputLog) RecordDiskEject(drive int) {
+	l.record(inputEvent{cycle: l.apple2.cpu.Cycles, kind: inputDiskEject, n: drive})
+}
+
+func (l *inputLog) record(e inputEvent) {
+	for _, r := range l.recorders {
+		r.RecordInput(e)
+	}
+}
+
+// replayDue applies the leading replay events that are due at the current
+// cycle. Key events are applied only when keys is true; other events only
+// when it is false. Key events are recorded once the keyboard processes
+// them.
+func (l *inputLog) replayDue(keys bool) {
+	now := l.apple2.cpu.Cycles
+	for ; l.replayPos < len(l.replay); l.replayPos++ {
+		e := l.replay[l.replayPos]
+		if e.cycle > now || (e.kind == inputKey) != keys {
+			break
+		}
+		l.apply(e)
+		if !keys {
+			l.record(e)
+		}
+	}
+}
+
+// apply applies an input event to the machine, bypassing the host input
+// methods.
+func (l *inputLog) apply(e inputEvent) {
+	a := l.apple2
+	switch e.kind {
+	case inputKey:
+		a.kb.mu.Lock()
+		a.kb.events = append(a.kb.events, keyEvent{key: hostKey(e.n), down: e.v != 0})
+		a.kb.mu.Unlock()
+	case inputPaste:
+		a.kb.mu.Lock()
+		a.kb.paste.codes = append(a.kb.paste.codes, e.text...)
+		a.kb.mu.Unlock()
+	case inputPaddle:
+		a.gi.mu.Lock()
+		a.gi.paddles[e.n] = byte(e.v)
+		a.gi.mu.Unlock()
+	case inputButton:
+		a.gi.mu.Lock()
+		a.gi.buttons[e.n] = e.v != 0
+		a.gi.mu.Unlock()
+	case inputMouseMove:
+		if m := a.MouseInput(); m != nil {
+			m.mu.Lock()
+			m.dx += e.n
+			m.dy += e.v
+			m.mu.Unlock()
+		}
+	case inputMouseButton:
+		if m := a.MouseInput(); m != nil {
+			m.mu.Lock()
+			m.button = e.v != 0
+			m.mu.Unlock()
+		}
+	case inputDiskInsert:
+		// Replayed disks aren't saved to files.
+		d, err := a.diskController()
+		if err != nil || e.n < 1 || e.n > 2 {
+			return
+		}
+		disk, err := loadDiskImage(bytes.NewReader(e.data), diskFormat(e.v&0xff))
+		if err != nil {
+			return
+		}
+		disk.writeProtected = e.v&inputDiskWriteProtected != 0
+		d.insert(e.n-1, disk, "", diskModeWrite)
+	case inputDiskEject:
+		// Replayed changes aren't saved to files either.
+		d, err := a.diskController()
+		if err != nil || e.n < 1 || e.n > 2 {
+			return
+		}
+		d.drives[e.n-1].disk, d.drives[e.n-1].filename = nil, ""
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const defaultAxisRampSpeed = 4.0 // axis units (-1..1 range) per second
+
+// An axisBinding maps a host key to a direction along a joystick axis.
+type axisBinding struct {
+	axis int     // paddle number (0 = X, 1 = Y)
+	dir  float64 // -1 or 1
+}
+
+// The inputMapper sits between front end input devices and the keyboard
+// and game controller modules. It lets host gamepad buttons generate Apple2
+// keypresses, and lets host keys emulate joystick axes and buttons, for
+// software that supports only one kind of input. Front ends should route
+// all host key and gamepad button events through the mapper. Event methods
+// are safe to call from any goroutine.
+type inputMapper struct {
+	apple2 *apple2
+
+	mu          sync.Mutex
+	buttonKeys  map[int]hostKey         // gamepad button -> host key
+	keyAxes     map[hostKey]axisBinding // host key -> joystick axis
+	keyButtons  map[hostKey]int         // host key -> joystick button
+	keyJoystick bool                    // true = key-to-joystick mappings active
+	rampSpeed   float64                 // axis movement speed in units per second
+	held        map[hostKey]bool        // mapped keys currently held
+	axisPos     [2]float64              // current emulated axis positions
+	axisActive  [2]bool                 // true if an axis is being driven by keys
+	lastUpdate  uint64                  // CPU cycle of the last update
+	swapDisks   bool                    // the swap disks hotkey was pressed
+}
+
+func newInputMapper(apple2 *apple2) *inputMapper {
+	return &inputMapper{
+		apple2: apple2,
+	}
+}
+
+func (im *inputMapper) Init() {
+	im.buttonKeys = make(map[int]hostKey)
+	im.keyAxes = make(map[hostKey]axisBinding)
+	im.keyButtons = make(map[hostKey]int)
+	im.held = make(map[hostKey]bool)
+	im.rampSpeed = defaultAxisRampSpeed
+}
+
+// MapButtonToKey makes a host gamepad button type a key instead of
+// pressing a joystick button.
+func (im *inputMapper) MapButtonToKey(button int, key hostKey) {
+	im.mu.Lock()
+	im.buttonKeys[button] = key
+	im.mu.Unlock()
+}
+
+// MapKeyToAxis makes a host key push joystick axis 0 (X) or 1 (Y) in the
+// given direction (-1 or 1) while key-to-joystick mapping is enabled.
+func (im *inputMapper) MapKeyToAxis(key hostKey, axis int, dir float64) {
+	im.mu.Lock()
+	im.keyAxes[key] = axisBinding{axis: axis, dir: dir}
+	im.mu.Unlock()
+}
+
+// MapKeyToButton makes a host key press a joystick button while
+// key-to-joystick mapping is enabled.
+func (im *inputMapper) MapKeyToButton(key hostKey, button int) {
+	im.mu.Lock()
+	im.keyButtons[key] = button
+	im.mu.Unlock()
+}
+
+// MapArrowKeysToJoystick binds the arrow keys to the joystick axes.
+func (im *inputMapper) MapArrowKeysToJoystick() {
+	im.MapKeyToAxis(hostKeyLeft, 0, -1)
+	im.MapKeyToAxis(hostKeyRight, 0, 1)
+	im.MapKeyToAxis(hostKeyUp, 1, -1)
+	im.MapKeyToAxis(hostKeyDown, 1, 1)
+}
+
+// MapWASDToJoystick binds the W, A, S and D keys to the joystick axes.
+func (im *inputMapper) MapWASDToJoystick() {
+	im.MapKeyToAxis(hostKeyLetter('A'), 0, -1)
+	im.MapKeyToAxis(hostKeyLetter('D'), 0, 1)
+	im.MapKeyToAxis(hostKeyLetter('W'), 1, -1)
+	im.MapKeyToAxis(hostKeyLetter('S'), 1, 1)
+}
+
+// A keyButtonMap maps a host key to a joystick button.
+type keyButtonMap struct {
+	key    hostKey
+	button int
+}
+
+// parseKeyButtonMap parses a mapping of the form "key=buttonN", such as
+// "leftalt=button0".
+func parseKeyButtonMap(s string) (keyButtonMap, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return keyButtonMap{}, fmt.Errorf("%q is not of the form key=buttonN", s)
+	}
+	key, ok := parseHostKey(s[:i])
+	if !ok {
+		return keyButtonMap{}, fmt.Errorf("unknown key %q", s[:i])
+	}
+	var button int
+	if _, err := fmt.Sscanf(s[i+1:], "button%d", &button); err != nil || button < 0 || button >= numButtons {
+		return keyButtonMap{}, fmt.Errorf("unknown joystick button %q", s[i+1:])
+	}
+	return keyButtonMap{key, button}, nil
+}
+
+// keyButtonMaps is a flag value holding key-to-button mappings.
+type keyButtonMaps []keyButtonMap
+
+func (l *keyButtonMaps) String() string {
+	s := make([]string, len(*l))
+	for i, m := range *l {
+		s[i] = fmt.Sprintf("%s=button%d", hostKeyName(m.key), m.button)
+	}
+	return strings.Join(s, " ")
+}
+
+func (l *keyButtonMaps) Set(s string) error {
+	m, err := parseKeyButtonMap(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+// MapKeysToJoystick binds a set of keys, "arrows" or "wasd", to the
+// joystick axes.
+func (im *inputMapper) MapKeysToJoystick(set string) bool {
+	switch set {
+	case "arrows":
+		im.MapArrowKeysToJoystick()
+	case "wasd":
+		im.MapWASDToJoystick()
+	default:
+		return false
+	}
+	return true
+}
+
+// EnableKeyJoystick turns key-to-joystick mapping on or off.
+func (im *inputMapper) EnableKeyJoystick(enable bool) {
+	im.mu.Lock()
+	im.keyJoystick = enable
+	if !enable {
+		im.held = make(map[hostKey]bool)
+	}
+	im.mu.Unlock()
+}
+
+// SetRampSpeed sets how quickly key-driven axes move, in full-scale
+// deflections (from center to edge) per second. Zero or negative values
+// make axes move instantly.
+func (im *inputMapper) SetRampSpeed(speed float64) {
+	im.mu.Lock()
+	im.rampSpeed = speed
+	im.mu.Unlock()
+}
+
+// Hotkeys handled by the emulator rather than passed to the machine.
+const (
+	speedHotkey     = hostKeyScrollLock // cycles through the speed modes
+	swapDisksHotkey = hostKeyF11        // swaps the disks in drives 1 and 2
+)
+
+// KeyEvent handles a host key press or release.
+func (im *inputMapper) KeyEvent(key hostKey, down bool) {
+	switch key {
+	case speedHotkey:
+		if down {
+			im.apple2.speed.CycleSpeed()
+		}
+		return
+	case swapDisksHotkey:
+		// The disks are swapped by the next update, between frames.
+		if down {
+			im.mu.Lock()
+			im.swapDisks = true
+			im.mu.Unlock()
+		}
+		return
+	}
+
+	im.mu.Lock()
+	_, isAxis := im.keyAxes[key]
+	button, isButton := im.keyButtons[key]
+	mapped := im.keyJoystick && (isAxis || isButton)
+	if mapped {
+		if down {
+			im.held[key] = true
+		} else {
+			delete(im.held, key)
+		}
+	}
+	im.mu.Unlock()
+
+	switch {
+	case !mapped:
+		im.apple2.kb.PushKeyEvent(key, down)
+	case isButton:
+		im.apple2.gi.SetButton(button, down)
+	}
+}
+
+// GamepadButton handles a host gamepad button press or release.
+func (im *inputMapper) GamepadButton(button int, down bool) {
+	im.mu.Lock()
+	key, ok := im.buttonKeys[button]
+	im.mu.Unlock()
+
+	switch {
+	case ok:
+		im.apple2.kb.PushKeyEvent(key, down)
+	case button < numButtons:
+		im.apple2.gi.SetButton(button, down)
+	}
+}
+
+// Update swaps the disks if the hotkey was pressed, and moves the
+// key-driven joystick axes toward the positions selected by the held
+// keys.
+func (im *inputMapper) Update() {
+	now := im.apple2.cpu.Cycles
+	dt := float64(now-im.lastUpdate) / cpuClockRate
+	im.lastUpdate = now
+
+	im.mu.Lock()
+	swap := im.swapDisks
+	im.swapDisks = false
+	im.mu.Unlock()
+	if swap {
+		im.apple2.SwapDisks()
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if !im.keyJoystick {
+		return
+	}
+
+	var target [2]float64
+	var active [2]bool
+	for key := range im.held {
+		if b, ok := im.keyAxes[key]; ok {
+			target[b.axis] += b.dir
+			active[b.axis] = true
+		}
+	}
+
+	for axis := range im.axisPos {
+		if !active[axis] && !im.axisActive[axis] {
+			continue
+		}
+
+		t := clampAxis(target[axis])
+		pos := im.axisPos[axis]
+		step := im.rampSpeed * dt
+		switch {
+		case im.rampSpeed <= 0:
+			pos = t
+		case pos < t:
+			pos = clampAxis(pos + step)
+			if pos > t {
+				pos = t
+			}
+		case pos > t:
+			pos = clampAxis(pos - step)
+			if pos < t {
+				pos = t
+			}
+		}
+
+		im.axisPos[axis] = pos
+		im.axisActive[axis] = active[axis] || pos != 0
+		im.apple2.gi.SetAxis(axis, pos)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Input recordings are stored in the same way as machine snapshots: an
+// uncompressed header holding a magic string and the format version,
+// followed by a gzip-compressed body. The body holds the snapshot the
+// recording starts from, the cycle at which it ends, and the recorded
+// input events.
+const (
+	inputRecMagic   = "A2GOINPT"
+	inputRecVersion = 1
+)
+
+var (
+	errInputRecMagic     = errors.New("not an apple2go input recording")
+	errInputRecVersion   = errors.New("unsupported input recording version")
+	errNotRecordingInput = errors.New("input is not being recorded")
+)
+
+// An inputRecording holds the host input applied to the machine over a
+// period of emulation, along with a snapshot of the machine from the
+// moment the recording started. Replaying the input after loading the
+// snapshot reproduces the emulation exactly.
+type inputRecording struct {
+	snapshot []byte       // machine snapshot written by SaveState
+	events   []inputEvent // input events in the order they were applied
+	end      uint64       // CPU cycle at which recording stopped
+}
+
+// RecordInput adds an input event to the recording.
+func (rec *inputRecording) RecordInput(e inputEvent) {
+	rec.events = append(rec.events, e)
+}
+
+// Save writes the recording.
+func (rec *inputRecording) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	hdr := &stateWriter{w: bw}
+	hdr.FixedBytes([]byte(inputRecMagic))
+	hdr.Uint16(inputRecVersion)
+	if hdr.Err() != nil {
+		return hdr.Err()
+	}
+
+	zw := gzip.NewWriter(bw)
+	sw := &stateWriter{w: zw}
+	sw.Bytes(rec.snapshot)
+	sw.Uint64(rec.end)
+	sw.Int(len(rec.events))
+	for _, e := range rec.events {
+		sw.Uint64(e.cycle)
+		sw.Byte(byte(e.kind))
+		sw.Int(e.n)
+		sw.Int(e.v)
+		sw.String(e.text)
+		sw.Bytes(e.data)
+	}
+	if sw.Err() != nil {
+		return sw.Err()
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// loadInputRecording reads a recording written by Save.
+func loadInputRecording(r io.Reader) (*inputRecording, error) {
+	br := bufio.NewReader(r)
+	hdr := &stateReader{r: br}
+	magic := make([]byte, len(inputRecMagic))
+	hdr.FixedBytes(magic)
+	if hdr.Err() != nil || string(magic) != inputRecMagic {
+		return nil, errInputRecMagic
+	}
+	if v := hdr.Uint16(); hdr.Err() != nil || v != inputRecVersion {
+		return nil, fmt.Errorf("%w %d", errInputRecVersion, v)
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, errStateCorrupt
+	}
+	sr := &stateReader{r: zr}
+	rec := &inputRecording{
+		snapshot: sr.Bytes(),
+		end:      sr.Uint64(),
+	}
+	n := sr.Int()
+	for i := 0; i < n && sr.Err() == nil; i++ {
+		e := inputEvent{
+			cycle: sr.Uint64(),
+			kind:  inputKind(sr.Byte()),
+			n:     sr.Int(),
+			v:     sr.Int(),
+			text:  sr.String(),
+			data:  sr.Bytes(),
+		}
+		if len(rec.events) > 0 && e.cycle < rec.events[len(rec.events)-1].cycle {
+			sr.Fail(errStateCorrupt)
+		}
+		rec.events = append(rec.events, e)
+	}
+	if sr.Err() != nil {
+		return nil, sr.Err()
+	}
+	return rec, nil
+}
+
+// StartInputRecording takes a snapshot of the machine and begins
+// recording host input. Any recording already in progress is discarded.
+func (a *apple2) StartInputRecording() error {
+	var buf bytes.Buffer
+	if err := a.SaveState(&buf); err != nil {
+		return err
+	}
+
+	if a.inputRec != nil {
+		a.in.RemoveRecorder(a.inputRec)
+	}
+	a.inputRec = &inputRecording{snapshot: buf.Bytes()}
+	a.in.AddRecorder(a.inputRec)
+	return nil
+}
+
+// StopInputRecording stops recording host input and returns the
+// recording.
+func (a *apple2) StopInputRecording() (*inputRecording, error) {
+	rec := a.inputRec
+	if rec == nil {
+		return nil, errNotRecordingInput
+	}
+	a.in.RemoveRecorder(rec)
+	a.inputRec = nil
+	rec.end = a.cpu.Cycles
+	return rec, nil
+}
+
+// ReplayInput loads the snapshot a recording starts from and replays its
+// input. Host input is ignored until the machine reaches the end of the
+// recording.
+func (a *apple2) ReplayInput(rec *inputRecording) error {
+	if err := a.LoadState(bytes.NewReader(rec.snapshot)); err != nil {
+		return err
+	}
+	a.in.StartReplay(rec.events, rec.end)
+	return nil
+}
+
+// SaveInputRecordingFile stops recording host input and writes the
+// recording to a file.
+func (a *apple2) SaveInputRecordingFile(filename string) error {
+	rec, err := a.StopInputRecording()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = rec.Save(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReplayInputFile replays an input recording from a file.
+func (a *apple2) ReplayInputFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rec, err := loadInputRecording(file)
+	if err != nil {
+		return err
+	}
+	return a.ReplayInput(rec)
+}
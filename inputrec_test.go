@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInputRecording(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.dsk")
+	if err := os.WriteFile(filename, testDiskData(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newMachine := func() *apple2 {
+		a := newApple2()
+		a.sm.Insert(6, newDiskII(a, nil))
+		return a
+	}
+
+	type frameState struct {
+		cycles  uint64
+		paddle  byte
+		button  bool
+		keydata byte
+		disk    bool
+	}
+	state := func(a *apple2) frameState {
+		d, _ := a.diskController()
+		return frameState{a.cpu.Cycles, a.gi.paddles[1], a.gi.buttons[0], a.kb.keydata, d.drives[1].disk != nil}
+	}
+
+	a := newMachine()
+	a.RunFrame()
+	if err := a.StartInputRecording(); err != nil {
+		t.Fatal(err)
+	}
+	var states []frameState
+	for f := 0; f < 40; f++ {
+		switch f {
+		case 5:
+			a.gi.SetPaddle(1, 33)
+		case 10:
+			a.kb.PushKeyEvent(hostKeyA+1, true)
+			a.gi.SetButton(0, true)
+		case 12:
+			a.kb.PushKeyEvent(hostKeyA+1, false)
+		case 20:
+			if err := a.InsertDisk(2, filename); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a.RunFrame()
+		states = append(states, state(a))
+	}
+	rec, err := a.StopInputRecording()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rec.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = loadInputRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newMachine()
+	if err := b.ReplayInput(rec); err != nil {
+		t.Fatal(err)
+	}
+	for f, expected := range states {
+		// Host input is ignored during the replay.
+		b.gi.SetPaddle(1, 99)
+		b.RunFrame()
+		if s := state(b); s != expected {
+			t.Fatalf("Frame %d: expected %+v, got %+v\n", f, expected, s)
+		}
+	}
+
+	b.RunFrame()
+	if b.in.Replaying() {
+		t.Errorf("Replay didn't end with the recording\n")
+	}
+	b.gi.SetPaddle(1, 99)
+	if b.gi.paddles[1] != 99 {
+		t.Errorf("Host input ignored after the replay ended\n")
+	}
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
 type ioSwitch uint8
 
 const (
@@ -20,11 +26,12 @@ const (
 	ioSwitchLCBANK2                      // 1 = LC RAM bank 2 enabled, 0 = LC RAM bank 1 enabled
 	ioSwitchCXROM                        // 1 = using internal slot ROM, 0 = not using
 	ioSwitchC3ROM                        // 1 = using slot 3 ROM, 0 = not using
-	ioSwitchVBLINT                       // read vertical blanking
+	ioSwitchVBLINT                       // 1 = vertical blanking in progress, 0 = scanning visible lines
 	ioSwitchANNUNCIATOR0                 // if IOUDIS is 0: 1 = hand control annunciator 0 on, 0 = off
 	ioSwitchANNUNCIATOR1                 // if IOUDIS is 0: 1 = hand control annunciator 1 on, 0 = off
 	ioSwitchANNUNCIATOR2                 // if IOUDIS is 0: 1 = hand control annunciator 2 on, 0 = off
 	ioSwitchANNUNCIATOR3                 // if IOUDIS is 0: 1 = hand control annunciator 3 on, 0 = off
+	ioSwitchLCPREWRITE                   // 1 = an odd $C08x switch was read, so another read enables LC RAM writes
 
 	ioSwitchINVALID
 )
@@ -33,11 +40,12 @@ const (
 	updateSystemRAM uint32 = 1 << iota // update lower 48K memory banks (except ZPS)
 	updateZPSRAM                       // update zero and stack pages
 	updateLCRAM                        // update upper 16K memory banks
+	updateSlotROM                      // update $C100..$CFFF ROM banks
 )
 
 var switchUpdates = []uint32{
-	/* ioSwitchAUXRAMRD     */ updateSystemRAM | updateLCRAM,
-	/* ioSwitchAUXRAMWRT    */ updateSystemRAM | updateLCRAM,
+	/* ioSwitchAUXRAMRD     */ updateSystemRAM,
+	/* ioSwitchAUXRAMWRT    */ updateSystemRAM,
 	/* ioSwitchALTCHARSET   */ 0,
 	/* ioSwitchTEXT         */ 0,
 	/* ioSwitchMIXED        */ 0,
@@ -47,17 +55,18 @@ var switchUpdates = []uint32{
 	/* ioSwitchHIRES        */ updateSystemRAM,
 	/* ioSwitchDHIRES       */ 0,
 	/* ioSwitchIOUDIS       */ 0,
-	/* ioSwitchALTZP        */ updateZPSRAM,
+	/* ioSwitchALTZP        */ updateZPSRAM | updateLCRAM,
 	/* ioSwitchLCRAMRD      */ updateLCRAM,
 	/* ioSwitchLCRAMWRT     */ updateLCRAM,
 	/* ioSwitchLCBANK2      */ updateLCRAM,
-	/* ioSwitchCXROM        */ 0,
-	/* ioSwitchC3ROM        */ 0,
+	/* ioSwitchCXROM        */ updateSlotROM,
+	/* ioSwitchC3ROM        */ updateSlotROM,
 	/* ioSwitchVBLINT       */ 0,
 	/* ioSwitchANNUNCIATOR0 */ 0,
 	/* ioSwitchANNUNCIATOR1 */ 0,
 	/* ioSwitchANNUNCIATOR2 */ 0,
 	/* ioSwitchANNUNCIATOR3 */ 0,
+	/* ioSwitchLCPREWRITE   */ 0,
 }
 
 type iou struct {
@@ -66,9 +75,12 @@ type iou struct {
 	// apple2 convenience accessors
 	kb  *keyboard
 	mmu *mmu
+	vs  *videoScanner
+	sm  *slotManager
 
-	switches uint32 // bitmask of current switch settings
-	updates  uint32 // pending updates required
+	switches uint32     // bitmask of current switch settings
+	updates  uint32     // pending updates required
+	log      *switchLog // I/O page access log, if enabled
 }
 
 func newIOU(apple2 *apple2) *iou {
@@ -80,9 +92,29 @@ func newIOU(apple2 *apple2) *iou {
 func (iou *iou) Init() {
 	iou.kb = iou.apple2.kb
 	iou.mmu = iou.apple2.mmu
+	iou.vs = iou.apple2.vs
+	iou.sm = iou.apple2.sm
 
 	b := iou.mmu.GetBank(bankIOSwitches, bankTypeMain)
-	b.accessor = &ioSwitchBankAccessor{iou: iou}
+	b.setAccessor(&ioSwitchBankAccessor{iou: iou})
+
+	iou.updates |= updateSlotROM
+	iou.applySwitchUpdates()
+}
+
+// resetSwitches are the switches unaffected by the RESET line. The video
+// mode switches are left for the firmware to set.
+const resetSwitches = 1<<ioSwitchTEXT | 1<<ioSwitchMIXED | 1<<ioSwitchPAGE2 |
+	1<<ioSwitchHIRES | 1<<ioSwitchVBLINT
+
+// Reset clears the soft switches, as the RESET line does. Main memory is
+// selected throughout, the internal $C3 ROM and the slot ROMs are mapped,
+// and the language card reads ROM and writes RAM bank 2.
+func (iou *iou) Reset() {
+	iou.switches &= resetSwitches
+	iou.switches |= 1<<ioSwitchLCRAMWRT | 1<<ioSwitchLCBANK2
+	iou.updates |= updateSystemRAM | updateZPSRAM | updateLCRAM | updateSlotROM
+	iou.applySwitchUpdates()
 }
 
 func (iou *iou) testSoftSwitch(sw ioSwitch) bool {
@@ -107,6 +139,17 @@ func (iou *iou) setSoftSwitch(sw ioSwitch, v bool) {
 
 	if orig != iou.switches {
 		iou.updates |= switchUpdates[sw]
+		log := iou.apple2.log
+		if log.Enabled(logIOU, levelTrace) {
+			log.Logger(logIOU).Log(context.Background(), levelTrace, "soft switch changed",
+				"switch", sw.String(), "on", v, "pc", fmt.Sprintf("%04X", iou.apple2.cpu.LastPC))
+		}
+		if (orig^iou.switches)&videoSwitches != 0 {
+			iou.apple2.ev.checkDisplayMode()
+			if log.Enabled(logVideo, slog.LevelDebug) {
+				log.Logger(logVideo).Debug("display mode changed", "mode", iou.apple2.DisplayMode().String())
+			}
+		}
 	}
 }
 
@@ -116,13 +159,13 @@ var switchBank = []struct {
 }{
 	/* c00x */ {read: (*iou).onSwitchReadC00x, write: (*iou).onSwitchWriteC00x},
 	/* c01x */ {read: (*iou).onSwitchReadC01x, write: (*iou).onSwitchWriteC01x},
-	/* c02x */ {},
-	/* c03x */ {read: (*iou).onSwitchReadC03x},
-	/* c04x */ {read: (*iou).onSwitchReadC04x},
+	/* c02x */ {read: (*iou).onSwitchReadC02x, write: (*iou).onSwitchWriteC02x},
+	/* c03x */ {read: (*iou).onSwitchReadC03x, write: (*iou).onSwitchWriteC03x},
+	/* c04x */ {read: (*iou).onSwitchReadC04x, write: (*iou).onSwitchWriteC04x},
 	/* c05x */ {read: (*iou).onSwitchReadC05x, write: (*iou).onSwitchWriteC05x},
-	/* c06x */ {},
-	/* c07x */ {write: (*iou).onSwitchWriteC07x},
-	/* c08x */ {read: (*iou).onSwitchReadC08x},
+	/* c06x */ {read: (*iou).onSwitchReadC06x, write: (*iou).onSwitchWriteC06x},
+	/* c07x */ {read: (*iou).onSwitchReadC07x, write: (*iou).onSwitchWriteC07x},
+	/* c08x */ {read: (*iou).onSwitchReadC08x, write: (*iou).onSwitchWriteC08x},
 }
 
 var switchWriteC00x = []ioSwitch{
@@ -137,13 +180,8 @@ var switchWriteC00x = []ioSwitch{
 }
 
 func (iou *iou) onSwitchReadC00x(addr uint16) byte {
-	switch addr {
-	case 0x00:
-		return iou.kb.GetKeyData()
-
-	default:
-		return 0
-	}
+	// The keyboard data register is mirrored throughout $C000..$C00F.
+	return iou.kb.ReadKeyData()
 }
 
 func (iou *iou) onSwitchWriteC00x(addr uint16, v byte) {
@@ -154,6 +192,10 @@ func (iou *iou) onSwitchWriteC00x(addr uint16, v byte) {
 	//  addr3: switch2 ON
 	//  ...etc.
 
+	if !iou.apple2.cfg.iie {
+		return
+	}
+
 	sw := switchWriteC00x[addr>>1]
 	on := (addr & 1) == 1
 	iou.setSoftSwitch(sw, on)
@@ -179,42 +221,104 @@ var switchReadC01x = []ioSwitch{
 }
 
 func (iou *iou) onSwitchReadC01x(addr uint16) byte {
+	// Models before the IIe have only the keyboard strobe reset here,
+	// which any address in the range clears.
+	if !iou.apple2.cfg.iie {
+		iou.clearKeyStrobe()
+		return iou.vs.FloatingBus()
+	}
+
+	if v, ok := iou.readMouseSwitch(addr); ok {
+		return v
+	}
+
 	switch addr {
 	case 0x10:
 		kb := iou.kb
 		keyDown := kb.IsKeyDown()
-		kb.ResetKeyStrobe()
+		iou.clearKeyStrobe()
 		if keyDown {
 			return 0x80 | (kb.GetKeyData() & ^keyStrobe)
 		}
 		return 0
 
+	case 0x19:
+		// The IIe reports vertical blanking active low (RDVBLBAR).
+		iou.setSoftSwitch(ioSwitchVBLINT, iou.vs.InVBL())
+		return iou.getSoftSwitchBit7(ioSwitchVBLINT) ^ 0x80
+
 	default:
 		sw := switchReadC01x[addr-0x10]
 		return iou.getSoftSwitchBit7(sw)
 	}
 }
 
+// clearKeyStrobe clears the keyboard strobe on behalf of software, and
+// tells the event observers if a key was waiting.
+func (iou *iou) clearKeyStrobe() {
+	kb := iou.kb
+	if ev := iou.apple2.ev; ev.active() && kb.GetKeyData()&keyStrobe != 0 {
+		ev.send(machineEvent{kind: eventKeyStrobe, key: kb.GetKeyData() &^ keyStrobe})
+	}
+	kb.ResetKeyStrobe()
+}
+
 func (iou *iou) onSwitchWriteC01x(addr uint16, v byte) {
-	if addr == 0x10 {
-		_ = iou.onSwitchReadC01x(addr)
+	if addr == 0x10 || !iou.apple2.cfg.iie {
+		iou.clearKeyStrobe()
+	}
+}
+
+func (iou *iou) onSwitchReadC02x(addr uint16) byte {
+	// On the IIc, any access to $C028 (ROMBANK) toggles between the
+	// system ROM banks.
+	if addr == 0x28 && iou.apple2.cfg.romBanks > 1 && iou.mmu.ROMBanks() > 1 {
+		iou.mmu.SelectROMBank(iou.mmu.romBank ^ 1)
 	}
+	if iou.apple2.cfg.cassette {
+		iou.apple2.cas.ToggleOutput()
+	}
+	return iou.vs.FloatingBus()
+}
+
+func (iou *iou) onSwitchWriteC02x(addr uint16, v byte) {
+	// The cassette output and ROMBANK toggle on any access.
+	_ = iou.onSwitchReadC02x(addr)
 }
 
 func (iou *iou) onSwitchReadC03x(addr uint16) byte {
 	switch addr {
 	case 0x30:
 		iou.apple2.sp.Toggle()
+		if z := iou.apple2.zip; z != nil {
+			z.SpeakerAccess()
+		}
 	}
-	return 0
+	return iou.vs.FloatingBus()
+}
+
+func (iou *iou) onSwitchWriteC03x(addr uint16, v byte) {
+	// The speaker toggles on any access. A write toggles it once, as the
+	// CPU's store puts the address on the bus once.
+	_ = iou.onSwitchReadC03x(addr)
 }
 
 func (iou *iou) onSwitchReadC04x(addr uint16) byte {
+	if v, ok := iou.readMouseSwitch(addr); ok {
+		return v | (iou.vs.FloatingBus() & 0x7f)
+	}
+
 	switch addr {
 	case 0x40:
 		return iou.apple2.gi.GetStrobe()
 	}
-	return 0
+	return iou.vs.FloatingBus()
+}
+
+func (iou *iou) onSwitchWriteC04x(addr uint16, v byte) {
+	// The game port strobe and the IIc's mouse switches respond to any
+	// access.
+	_ = iou.onSwitchReadC04x(addr)
 }
 
 func (iou *iou) onSwitchReadC05x(addr uint16) byte {
@@ -235,6 +339,29 @@ func (iou *iou) onSwitchReadC05x(addr uint16) byte {
 		iou.setSoftSwitch(ioSwitchHIRES, false)
 	case 0x57:
 		iou.setSoftSwitch(ioSwitchHIRES, true)
+	case 0x58, 0x59, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f:
+		if z := iou.apple2.zip; z != nil {
+			if v, ok := z.LoadSwitch(addr); ok {
+				return v
+			}
+		}
+		iou.onSwitchAnnunciator(addr)
+	}
+
+	// The c05x switches don't drive the data bus.
+	return iou.vs.FloatingBus()
+}
+
+// onSwitchAnnunciator handles $C058..$C05F, which control the game port
+// annunciators, or the mouse interrupts on the IIc. With IOUDIS on,
+// $C05E and $C05F instead control double hires.
+func (iou *iou) onSwitchAnnunciator(addr uint16) {
+	if m := iou.apple2.mouse; m != nil && !iou.testSoftSwitch(ioSwitchIOUDIS) {
+		m.WriteSwitch(addr)
+		return
+	}
+
+	switch addr {
 	case 0x58:
 		if !iou.testSoftSwitch(ioSwitchIOUDIS) {
 			iou.setSoftSwitch(ioSwitchANNUNCIATOR0, false)
@@ -272,38 +399,103 @@ func (iou *iou) onSwitchReadC05x(addr uint16) byte {
 			iou.setSoftSwitch(ioSwitchANNUNCIATOR3, true)
 		}
 	}
-	return 0xa0
 }
 
 func (iou *iou) onSwitchWriteC05x(addr uint16, v byte) {
+	// An unlocked ZIP CHIP claims writes to $C058..$C05F.
+	if z := iou.apple2.zip; z != nil && addr >= 0x58 && z.StoreSwitch(addr, v) {
+		return
+	}
+
 	// write does the same as read for the c05x bank of switches.
 	_ = iou.onSwitchReadC05x(addr)
 }
 
+func (iou *iou) onSwitchReadC06x(addr uint16) byte {
+	var bit byte
+
+	if v, ok := iou.readMouseSwitch(addr); ok {
+		return v | (iou.vs.FloatingBus() & 0x7f)
+	}
+
+	gi := iou.apple2.gi
+	switch addr & 0x07 {
+	case 0x0:
+		bit = iou.apple2.cas.InputBit()
+	case 0x1, 0x2, 0x3:
+		bit = gi.ButtonBit(int(addr&0x07) - 1)
+	case 0x4, 0x5, 0x6, 0x7:
+		bit = gi.PaddleBit(int(addr&0x07) - 4)
+	}
+
+	// Only bit 7 is driven; the rest of the bus floats.
+	return bit | (iou.vs.FloatingBus() & 0x7f)
+}
+
+func (iou *iou) onSwitchWriteC06x(addr uint16, v byte) {
+	// The c06x inputs only drive the bus on reads, but a write decodes
+	// the same address, so the IIc's mouse switches respond to it.
+	_ = iou.onSwitchReadC06x(addr)
+}
+
 func (iou *iou) onSwitchReadC07x(addr uint16) byte {
 	var ret byte
 
-	switch addr {
-	case 0x7e:
+	iie := iou.apple2.cfg.iie
+	switch {
+	case addr == 0x7e && iie:
 		ret = iou.getSoftSwitchBit7(ioSwitchIOUDIS)
-	case 0x7f:
+	case addr == 0x7f && iie:
 		ret = iou.getSoftSwitchBit7(ioSwitchDHIRES)
+	default:
+		ret = iou.vs.FloatingBus()
 	}
 
+	// Any access to $C07x triggers the paddle timers.
+	iou.triggerPaddles()
 	iou.setSoftSwitch(ioSwitchVBLINT, false)
+	iou.readMouseSwitch(addr)
 
 	return ret
 }
 
 func (iou *iou) onSwitchWriteC07x(addr uint16, v byte) {
+	iou.triggerPaddles()
+
 	switch addr {
+	case 0x73:
+		if iou.mmu.auxCard == auxCardRamWorks {
+			iou.mmu.SelectAuxBank(int(v))
+		}
 	case 0x7e:
-		iou.setSoftSwitch(ioSwitchIOUDIS, false)
+		if iou.apple2.cfg.iie {
+			iou.setSoftSwitch(ioSwitchIOUDIS, false)
+		}
 	case 0x7f:
-		iou.setSoftSwitch(ioSwitchIOUDIS, true)
+		if iou.apple2.cfg.iie {
+			iou.setSoftSwitch(ioSwitchIOUDIS, true)
+		}
+	}
+}
+
+// triggerPaddles starts the paddle timers, which slows an accelerator
+// down so that software times them at 1 MHz.
+func (iou *iou) triggerPaddles() {
+	iou.apple2.gi.TriggerPaddles()
+	if z := iou.apple2.zip; z != nil {
+		z.PaddleAccess()
 	}
 }
 
+// readMouseSwitch handles reads of the IIc's mouse soft switches. It
+// returns false on other models or for other addresses.
+func (iou *iou) readMouseSwitch(addr uint16) (byte, bool) {
+	if m := iou.apple2.mouse; m != nil {
+		return m.ReadSwitch(addr)
+	}
+	return 0, false
+}
+
 func (iou *iou) onSwitchReadC08x(addr uint16) byte {
 	// addr (least significant 4 bits, ignore 'z' bit)
 	// 0z00 = LCRAMRD=1 LCRAMWRT=0 LCBANK2=1
@@ -316,21 +508,49 @@ func (iou *iou) onSwitchReadC08x(addr uint16) byte {
 	// 1z11 = LCRAMRD=1 LCRAMWRT=1 LCBANK2=0 (RR)
 	// ----
 	// LCRAMRD  = !(bit0 ^ bit1)
-	// LCRAMWRT = bit 0
+	// LCRAMWRT = bit 0, on the second consecutive read
 	// LCBANK2  = !(bit 3)
 
-	iou.setSoftSwitch(ioSwitchLCRAMRD, !bitTest16(addr^(addr>>1), 1<<0))
-	iou.setSoftSwitch(ioSwitchLCRAMWRT, bitTest16(addr, 1<<0))
-	iou.setSoftSwitch(ioSwitchLCBANK2, !bitTest16(addr, 1<<3))
+	iou.accessC08x(addr)
+	if bitTest16(addr, 1<<0) {
+		if iou.testSoftSwitch(ioSwitchLCPREWRITE) {
+			iou.setSoftSwitch(ioSwitchLCRAMWRT, true)
+		}
+		iou.setSoftSwitch(ioSwitchLCPREWRITE, true)
+	}
 
 	return 0xa0
 }
 
+// onSwitchWriteC08x handles a write to the language card switches. A
+// write selects the bank and read source like a read, but resets the
+// pre-write latch, so it never enables writing. Software must read an odd
+// switch twice in a row to write-enable the RAM.
+func (iou *iou) onSwitchWriteC08x(addr uint16, v byte) {
+	iou.accessC08x(addr)
+	iou.setSoftSwitch(ioSwitchLCPREWRITE, false)
+}
+
+// accessC08x handles the effects common to reads and writes of the
+// language card switches. Even switches disable writing and reset the
+// pre-write latch.
+func (iou *iou) accessC08x(addr uint16) {
+	iou.setSoftSwitch(ioSwitchLCRAMRD, !bitTest16(addr^(addr>>1), 1<<0))
+	iou.setSoftSwitch(ioSwitchLCBANK2, !bitTest16(addr, 1<<3))
+	if !bitTest16(addr, 1<<0) {
+		iou.setSoftSwitch(ioSwitchLCRAMWRT, false)
+		iou.setSoftSwitch(ioSwitchLCPREWRITE, false)
+	}
+}
+
 func (iou *iou) applySwitchUpdates() {
 	if iou.updates == 0 {
 		return
 	}
 
+	iou.mmu.BeginRemap()
+	defer iou.mmu.EndRemap()
+
 	if (iou.updates & updateZPSRAM) != 0 {
 		iou.applyZPSRAMSwitches()
 	}
@@ -340,6 +560,9 @@ func (iou *iou) applySwitchUpdates() {
 	if (iou.updates & updateLCRAM) != 0 {
 		iou.applyLCRAMSwitches()
 	}
+	if (iou.updates & updateSlotROM) != 0 {
+		iou.applySlotROMSwitches()
+	}
 
 	iou.updates = 0
 }
@@ -360,8 +583,14 @@ func (iou *iou) applySystemRAMSwitches() {
 	btr := iou.selectBankType(ioSwitchAUXRAMRD, bankTypeAux, bankTypeMain)
 	btw := iou.selectBankType(ioSwitchAUXRAMWRT, bankTypeAux, bankTypeMain)
 
-	mmu.ActivateBank(bankMainRAM, btr, read)
-	mmu.ActivateBank(bankMainRAM, btw, write)
+	// Main RAM spans most pages, so it's mapped in one pass when reads and
+	// writes go to the same memory.
+	if btr == btw {
+		mmu.ActivateBank(bankMainRAM, btr, read|write)
+	} else {
+		mmu.ActivateBank(bankMainRAM, btr, read)
+		mmu.ActivateBank(bankMainRAM, btw, write)
+	}
 
 	if iou.testSoftSwitch(ioSwitch80STORE) {
 		bt := iou.selectBankType(ioSwitchPAGE2, bankTypeAux, bankTypeMain)
@@ -370,37 +599,83 @@ func (iou *iou) applySystemRAMSwitches() {
 			mmu.ActivateBank(bankHiRes1, bt, read|write)
 		}
 	} else {
+		// Without 80STORE, the display pages follow RAMRD and RAMWRT like
+		// the rest of the lower 48K.
 		dp := iou.selectBank(ioSwitchPAGE2, bankDisplayPage2, bankDisplayPage1)
-		mmu.ActivateBank(dp, bankTypeMain, read|write)
+		mmu.ActivateBank(dp, btr, read)
+		mmu.ActivateBank(dp, btw, write)
 		if iou.testSoftSwitch(ioSwitchHIRES) {
 			hi := iou.selectBank(ioSwitchPAGE2, bankHiRes2, bankHiRes1)
-			mmu.ActivateBank(hi, bankTypeMain, read|write)
+			mmu.ActivateBank(hi, btr, read)
+			mmu.ActivateBank(hi, btw, write)
 		}
 	}
 }
 
+// applyLCRAMSwitches maps the language card RAM or the ROM into
+// $D000..$FFFF. ALTZP, not RAMRD and RAMWRT, selects whether the RAM is
+// main or aux memory.
 func (iou *iou) applyLCRAMSwitches() {
 	mmu := iou.mmu
 
-	btr := iou.selectBankType(ioSwitchAUXRAMRD, bankTypeAux, bankTypeMain)
-	btw := iou.selectBankType(ioSwitchAUXRAMWRT, bankTypeAux, bankTypeMain)
+	bt := iou.selectBankType(ioSwitchALTZP, bankTypeAux, bankTypeMain)
 	lcbank := iou.selectBank(ioSwitchLCBANK2, bankLangCardDX2RAM, bankLangCardDX1RAM)
 
 	if iou.testSoftSwitch(ioSwitchLCRAMRD) {
-		mmu.ActivateBank(bankLangCardEFRAM, btr, read)
-		mmu.ActivateBank(lcbank, btr, read)
+		mmu.ActivateBank(bankLangCardEFRAM, bt, read)
+		mmu.ActivateBank(lcbank, bt, read)
 	} else {
 		mmu.ActivateBank(bankSystemDEFROM, bankTypeMain, read)
 	}
 
 	if iou.testSoftSwitch(ioSwitchLCRAMWRT) {
-		mmu.ActivateBank(bankLangCardEFRAM, btw, write)
-		mmu.ActivateBank(lcbank, btw, write)
+		mmu.ActivateBank(bankLangCardEFRAM, bt, write)
+		mmu.ActivateBank(lcbank, bt, write)
 	} else {
 		mmu.ActivateBank(bankSystemDEFROM, bankTypeMain, write)
 	}
 }
 
+func (iou *iou) applySlotROMSwitches() {
+	mmu := iou.mmu
+
+	// Models before the IIe have no internal $C100..$CFFF ROM, and the IIc
+	// has no slots.
+	mmu.ActivateBank(bankSystemCXROM, bankTypeMain, read)
+	if !iou.apple2.cfg.iie {
+		mmu.ActivateBank(bankSlotROM, bankTypeMain, read|write)
+		mmu.ActivateBank(bankExpansionROM, bankTypeMain, read|write)
+		return
+	}
+	if !iou.apple2.cfg.slots {
+		mmu.DeactivateBank(bankSlotROM, bankTypeMain, write)
+		mmu.DeactivateBank(bankExpansionROM, bankTypeMain, write)
+		return
+	}
+
+	// INTCXROM maps the internal ROM over all of $C100..$CFFF, including
+	// $C3XX whatever the setting of SLOTC3ROM. Otherwise the slots are
+	// mapped, and the expansion ROM bank selects between card expansion
+	// ROMs and the internal ROM at $C800..$CFFF itself, since the
+	// selection changes with every access to a card's I/O ROM.
+	if iou.testSoftSwitch(ioSwitchCXROM) {
+		mmu.DeactivateBank(bankSlotROM, bankTypeMain, write)
+		mmu.DeactivateBank(bankExpansionROM, bankTypeMain, write)
+	} else {
+		mmu.ActivateBank(bankSlotROM, bankTypeMain, read|write)
+		mmu.ActivateBank(bankExpansionROM, bankTypeMain, read|write)
+	}
+
+	// With SLOTC3ROM off, the internal $C3 ROM replaces slot 3's ROM, and
+	// any access to it selects the internal ROM at $C800..$CFFF, which
+	// holds the rest of the 80-column firmware.
+	if !iou.testSoftSwitch(ioSwitchC3ROM) {
+		mmu.ActivateBank(bankSystemC3ROM, bankTypeMain, read|write)
+	} else if iou.testSoftSwitch(ioSwitchCXROM) {
+		mmu.DeactivateBank(bankSystemC3ROM, bankTypeMain, write)
+	}
+}
+
 func (iou *iou) selectBankType(sw ioSwitch, onResult, offResult bankType) bankType {
 	if iou.testSoftSwitch(sw) {
 		return onResult
@@ -420,14 +695,26 @@ type ioSwitchBankAccessor struct {
 }
 
 func (a *ioSwitchBankAccessor) LoadByte(addr uint16) byte {
+	if l := a.iou.log; l != nil {
+		before := a.iou.switches
+		v := a.loadByte(addr)
+		l.access(a.iou, addr, false, v, before)
+		return v
+	}
+	return a.loadByte(addr)
+}
+
+func (a *ioSwitchBankAccessor) loadByte(addr uint16) byte {
+	// Unmapped locations don't drive the data bus, so reads return
+	// whatever the video scanner last fetched.
 	index := addr >> 4
 	if index > 8 {
-		return 0
+		return a.iou.sm.LoadIO(addr)
 	}
 
 	fn := switchBank[index].read
 	if fn == nil {
-		return 0
+		return a.iou.vs.FloatingBus()
 	}
 
 	ret := fn(a.iou, addr)
@@ -436,8 +723,19 @@ func (a *ioSwitchBankAccessor) LoadByte(addr uint16) byte {
 }
 
 func (a *ioSwitchBankAccessor) StoreByte(addr uint16, v byte) {
+	if l := a.iou.log; l != nil {
+		before := a.iou.switches
+		a.storeByte(addr, v)
+		l.access(a.iou, addr, true, v, before)
+		return
+	}
+	a.storeByte(addr, v)
+}
+
+func (a *ioSwitchBankAccessor) storeByte(addr uint16, v byte) {
 	index := addr >> 4
 	if index > 8 {
+		a.iou.sm.StoreIO(addr, v)
 		return
 	}
 
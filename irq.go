@@ -0,0 +1,97 @@
+package main
+
+import "github.com/beevik/go6502/cpu"
+
+// 6502 interrupt vectors.
+const (
+	vectorNMI = 0xfffa
+	vectorIRQ = 0xfffe
+)
+
+// cyclesPerInterrupt is the number of cycles the CPU takes to push its
+// state and fetch an interrupt vector.
+const cyclesPerInterrupt = 7
+
+// interrupts tracks the interrupt request lines of the machine's devices
+// and delivers interrupts to the CPU. The IRQ line is wired-OR: it stays
+// asserted while any device asserts it, and the CPU takes an interrupt
+// after each instruction while it is asserted and interrupts are enabled.
+// NMI is edge-triggered, and is taken after the next instruction
+// regardless of the interrupt disable flag.
+type interrupts struct {
+	apple2 *apple2
+
+	irq map[interface{}]bool // devices asserting IRQ
+	nmi bool                 // NMI edge pending
+}
+
+func newInterrupts(apple2 *apple2) *interrupts {
+	return &interrupts{
+		apple2: apple2,
+		irq:    make(map[interface{}]bool),
+	}
+}
+
+// SetIRQ asserts or releases a device's IRQ line. The device is any value
+// that identifies it, usually the device itself.
+func (in *interrupts) SetIRQ(dev interface{}, asserted bool) {
+	if asserted {
+		in.irq[dev] = true
+	} else {
+		delete(in.irq, dev)
+	}
+}
+
+// IRQ reports whether any device is asserting IRQ.
+func (in *interrupts) IRQ() bool {
+	return len(in.irq) > 0
+}
+
+// Asserting reports whether a device is asserting IRQ.
+func (in *interrupts) Asserting(dev interface{}) bool {
+	return in.irq[dev]
+}
+
+// TriggerNMI signals an edge on the NMI line.
+func (in *interrupts) TriggerNMI() {
+	in.nmi = true
+}
+
+// Reset releases all interrupt lines. Devices assert them again as their
+// state is restored.
+func (in *interrupts) Reset() {
+	in.irq = make(map[interface{}]bool)
+	in.nmi = false
+}
+
+// service takes a pending interrupt, if any, by pushing the program
+// counter and status register and jumping through the interrupt's
+// vector. It is called between instructions.
+func (in *interrupts) service() {
+	c := in.apple2.cpu
+	var vector uint16
+	switch {
+	case in.nmi:
+		in.nmi = false
+		vector = vectorNMI
+	case len(in.irq) > 0 && !c.Reg.InterruptDisable:
+		vector = vectorIRQ
+	default:
+		return
+	}
+
+	mmu := in.apple2.mmu
+	for _, b := range []byte{byte(c.Reg.PC >> 8), byte(c.Reg.PC), c.Reg.SavePS(false)} {
+		mmu.StoreByte(0x100|uint16(c.Reg.SP), b)
+		c.Reg.SP--
+	}
+
+	// Further interrupts are disabled, and the 65C02 also clears decimal
+	// mode.
+	c.Reg.InterruptDisable = true
+	if in.apple2.cfg.arch == cpu.CMOS {
+		c.Reg.Decimal = false
+	}
+	c.Reg.PC = mmu.LoadAddress(vector)
+	c.Cycles += cyclesPerInterrupt
+}
@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestInterruptLines(t *testing.T) {
+	a := newApple2()
+	in := a.ints
+	x, y := new(int), new(int)
+
+	in.SetIRQ(x, true)
+	in.SetIRQ(y, true)
+	in.SetIRQ(x, false)
+	if !in.IRQ() || in.Asserting(x) || !in.Asserting(y) {
+		t.Errorf("Expected IRQ asserted by the second device only\n")
+	}
+	in.SetIRQ(y, false)
+	in.SetIRQ(y, false)
+	if in.IRQ() {
+		t.Errorf("Expected IRQ released\n")
+	}
+}
+
+func TestInterruptDelivery(t *testing.T) {
+	a := newApple2()
+	mb := newMockingboard(a)
+	a.sm.Insert(4, mb)
+
+	// Spin at $0300 with interrupts disabled.
+	for i, b := range []byte{0x4c, 0x00, 0x03} {
+		a.mmu.StoreByte(0x300+uint16(i), b)
+	}
+	a.cpu.SetPC(0x300)
+	a.cpu.Reg.SP = 0xff
+	a.cpu.Reg.InterruptDisable = true
+	a.cpu.Reg.Decimal = true
+
+	// Timer 1 interrupts after 0x100 cycles.
+	a.mmu.StoreByte(0xc40e, 0xc0)
+	a.mmu.StoreByte(0xc404, 0x00)
+	a.mmu.StoreByte(0xc405, 0x01)
+	for i := 0; i < 200; i++ {
+		a.step()
+	}
+	if !a.ints.Asserting(mb) || a.cpu.Reg.PC != 0x300 {
+		t.Fatalf("Expected a masked IRQ from the Mockingboard\n")
+	}
+
+	// Enabling interrupts takes the IRQ after the next instruction.
+	a.cpu.Reg.InterruptDisable = false
+	a.step()
+	if pc := a.mmu.LoadAddress(vectorIRQ); a.cpu.Reg.PC != pc {
+		t.Fatalf("Expected a jump through the IRQ vector to %04X, got %04X\n", pc, a.cpu.Reg.PC)
+	}
+	if a.cpu.Reg.SP != 0xfc || a.mmu.LoadByte(0x1ff) != 0x03 || a.mmu.LoadByte(0x1fe) != 0x00 {
+		t.Errorf("Expected the return address 0300 on the stack\n")
+	}
+	if ps := a.mmu.LoadByte(0x1fd); ps&0x10 != 0 || ps&0x04 != 0 {
+		t.Errorf("Expected the pushed status without B or I, got %02X\n", ps)
+	}
+	if !a.cpu.Reg.InterruptDisable || a.cpu.Reg.Decimal {
+		t.Errorf("Expected interrupts disabled and decimal mode cleared\n")
+	}
+
+	// Acknowledging the interrupt releases the line.
+	a.mmu.LoadByte(0xc404)
+	if a.ints.IRQ() {
+		t.Errorf("Expected the IRQ line released\n")
+	}
+
+	// NMI is taken even with interrupts disabled.
+	a.cpu.SetPC(0x300)
+	a.ints.TriggerNMI()
+	a.step()
+	if pc := a.mmu.LoadAddress(vectorNMI); a.cpu.Reg.PC != pc || a.cpu.Reg.SP != 0xf9 {
+		t.Errorf("Expected a jump through the NMI vector to %04X, got %04X\n", pc, a.cpu.Reg.PC)
+	}
+}
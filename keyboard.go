@@ -1,9 +1,261 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A hostKey identifies a physical key on the host keyboard. Key codes are
+// USB HID keyboard usage IDs, which front ends can derive from the
+// scancodes reported by their windowing or terminal libraries.
+type hostKey uint16
+
+// Host key codes.
+const (
+	hostKeyA            hostKey = 0x04 // A..Z are sequential
+	hostKeyZ            hostKey = 0x1d
+	hostKey1            hostKey = 0x1e // 1..9 are sequential
+	hostKey0            hostKey = 0x27
+	hostKeyReturn       hostKey = 0x28
+	hostKeyEscape       hostKey = 0x29
+	hostKeyBackspace    hostKey = 0x2a
+	hostKeyTab          hostKey = 0x2b
+	hostKeySpace        hostKey = 0x2c
+	hostKeyMinus        hostKey = 0x2d
+	hostKeyEqual        hostKey = 0x2e
+	hostKeyLeftBracket  hostKey = 0x2f
+	hostKeyRightBracket hostKey = 0x30
+	hostKeyBackslash    hostKey = 0x31
+	hostKeySemicolon    hostKey = 0x33
+	hostKeyApostrophe   hostKey = 0x34
+	hostKeyGrave        hostKey = 0x35
+	hostKeyComma        hostKey = 0x36
+	hostKeyPeriod       hostKey = 0x37
+	hostKeySlash        hostKey = 0x38
+	hostKeyCapsLock     hostKey = 0x39
+	hostKeyF11          hostKey = 0x44
+	hostKeyF12          hostKey = 0x45
+	hostKeyScrollLock   hostKey = 0x47
+	hostKeyDelete       hostKey = 0x4c
+	hostKeyRight        hostKey = 0x4f
+	hostKeyLeft         hostKey = 0x50
+	hostKeyDown         hostKey = 0x51
+	hostKeyUp           hostKey = 0x52
+	hostKeyLeftCtrl     hostKey = 0xe0
+	hostKeyLeftShift    hostKey = 0xe1
+	hostKeyLeftAlt      hostKey = 0xe2
+	hostKeyLeftGUI      hostKey = 0xe3
+	hostKeyRightCtrl    hostKey = 0xe4
+	hostKeyRightShift   hostKey = 0xe5
+	hostKeyRightAlt     hostKey = 0xe6
+	hostKeyRightGUI     hostKey = 0xe7
+)
+
+// hostKeyLetter returns the host key for an uppercase letter.
+func hostKeyLetter(c byte) hostKey {
+	return hostKeyA + hostKey(c-'A')
+}
+
+// hostKeyNames names the host keys other than letters and digits, for
+// configuration files and flags.
+var hostKeyNames = map[string]hostKey{
+	"return":     hostKeyReturn,
+	"escape":     hostKeyEscape,
+	"backspace":  hostKeyBackspace,
+	"tab":        hostKeyTab,
+	"space":      hostKeySpace,
+	"delete":     hostKeyDelete,
+	"left":       hostKeyLeft,
+	"right":      hostKeyRight,
+	"up":         hostKeyUp,
+	"down":       hostKeyDown,
+	"leftctrl":   hostKeyLeftCtrl,
+	"leftshift":  hostKeyLeftShift,
+	"leftalt":    hostKeyLeftAlt,
+	"leftgui":    hostKeyLeftGUI,
+	"rightctrl":  hostKeyRightCtrl,
+	"rightshift": hostKeyRightShift,
+	"rightalt":   hostKeyRightAlt,
+	"rightgui":   hostKeyRightGUI,
+}
+
+// parseHostKey converts a key name, or a single letter or digit, into a
+// host key.
+func parseHostKey(s string) (hostKey, bool) {
+	if k, ok := hostKeyNames[strings.ToLower(s)]; ok {
+		return k, true
+	}
+	if len(s) == 1 {
+		c := strings.ToUpper(s)[0]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			return hostKeyLetter(c), true
+		case c == '0':
+			return hostKey0, true
+		case c >= '1' && c <= '9':
+			return hostKey1 + hostKey(c-'1'), true
+		}
+	}
+	return 0, false
+}
+
+// hostKeyName returns the name of a host key as parsed by parseHostKey.
+func hostKeyName(k hostKey) string {
+	switch {
+	case k >= hostKeyA && k <= hostKeyZ:
+		return string(rune('a' + k - hostKeyA))
+	case k == hostKey0:
+		return "0"
+	case k >= hostKey1 && k < hostKey0:
+		return string(rune('1' + k - hostKey1))
+	}
+	for name, key := range hostKeyNames {
+		if key == k {
+			return name
+		}
+	}
+	return fmt.Sprintf("$%02X", int(k))
+}
+
+// Auto-repeat timing of the IIe keyboard encoder.
+const (
+	keyRepeatDelay  = 32 * cyclesPerFrame // cycles before a held key repeats
+	keyRepeatPeriod = 4 * cyclesPerFrame  // cycles between repeats
+)
+
+// Apple2 key codes for non-printing keys.
+const (
+	keyCodeLeft   byte = 0x08
+	keyCodeTab    byte = 0x09
+	keyCodeDown   byte = 0x0a
+	keyCodeUp     byte = 0x0b
+	keyCodeReturn byte = 0x0d
+	keyCodeRight  byte = 0x15
+	keyCodeEscape byte = 0x1b
+	keyCodeDelete byte = 0x7f
+)
+
+// Unshifted and shifted Apple2 codes for host keys that produce characters.
+var hostKeyCodes = map[hostKey][2]byte{
+	hostKey1:            {'1', '!'},
+	hostKey1 + 1:        {'2', '@'},
+	hostKey1 + 2:        {'3', '#'},
+	hostKey1 + 3:        {'4', '$'},
+	hostKey1 + 4:        {'5', '%'},
+	hostKey1 + 5:        {'6', '^'},
+	hostKey1 + 6:        {'7', '&'},
+	hostKey1 + 7:        {'8', '*'},
+	hostKey1 + 8:        {'9', '('},
+	hostKey0:            {'0', ')'},
+	hostKeyReturn:       {keyCodeReturn, keyCodeReturn},
+	hostKeyEscape:       {keyCodeEscape, keyCodeEscape},
+	hostKeyBackspace:    {keyCodeLeft, keyCodeLeft},
+	hostKeyTab:          {keyCodeTab, keyCodeTab},
+	hostKeySpace:        {' ', ' '},
+	hostKeyMinus:        {'-', '_'},
+	hostKeyEqual:        {'=', '+'},
+	hostKeyLeftBracket:  {'[', '{'},
+	hostKeyRightBracket: {']', '}'},
+	hostKeyBackslash:    {'\\', '|'},
+	hostKeySemicolon:    {';', ':'},
+	hostKeyApostrophe:   {'\'', '"'},
+	hostKeyGrave:        {'`', '~'},
+	hostKeyComma:        {',', '<'},
+	hostKeyPeriod:       {'.', '>'},
+	hostKeySlash:        {'/', '?'},
+	hostKeyDelete:       {keyCodeDelete, keyCodeDelete},
+	hostKeyRight:        {keyCodeRight, keyCodeRight},
+	hostKeyLeft:         {keyCodeLeft, keyCodeLeft},
+	hostKeyDown:         {keyCodeDown, keyCodeDown},
+	hostKeyUp:           {keyCodeUp, keyCodeUp},
+}
+
+// browserKeys maps the KeyboardEvent codes of a browser's keys to host
+// keys, for the front ends that run in a browser.
+var browserKeys = func() map[string]hostKey {
+	m := map[string]hostKey{
+		"Enter":        hostKeyReturn,
+		"Escape":       hostKeyEscape,
+		"Backspace":    hostKeyBackspace,
+		"Tab":          hostKeyTab,
+		"Space":        hostKeySpace,
+		"Minus":        hostKeyMinus,
+		"Equal":        hostKeyEqual,
+		"BracketLeft":  hostKeyLeftBracket,
+		"BracketRight": hostKeyRightBracket,
+		"Backslash":    hostKeyBackslash,
+		"Semicolon":    hostKeySemicolon,
+		"Quote":        hostKeyApostrophe,
+		"Backquote":    hostKeyGrave,
+		"Comma":        hostKeyComma,
+		"Period":       hostKeyPeriod,
+		"Slash":        hostKeySlash,
+		"CapsLock":     hostKeyCapsLock,
+		"F11":          hostKeyF11,
+		"F12":          hostKeyF12,
+		"ScrollLock":   hostKeyScrollLock,
+		"Delete":       hostKeyDelete,
+		"ArrowRight":   hostKeyRight,
+		"ArrowLeft":    hostKeyLeft,
+		"ArrowDown":    hostKeyDown,
+		"ArrowUp":      hostKeyUp,
+		"ControlLeft":  hostKeyLeftCtrl,
+		"ShiftLeft":    hostKeyLeftShift,
+		"AltLeft":      hostKeyLeftAlt,
+		"MetaLeft":     hostKeyLeftGUI,
+		"ControlRight": hostKeyRightCtrl,
+		"ShiftRight":   hostKeyRightShift,
+		"AltRight":     hostKeyRightAlt,
+		"MetaRight":    hostKeyRightGUI,
+		"Digit0":       hostKey0,
+	}
+	for c := byte('A'); c <= 'Z'; c++ {
+		m["Key"+string(c)] = hostKeyLetter(c)
+	}
+	for c := byte('1'); c <= '9'; c++ {
+		m["Digit"+string(c)] = hostKey1 + hostKey(c-'1')
+	}
+	return m
+}()
+
+// A keyEvent is a host key press or release waiting to be processed.
+type keyEvent struct {
+	key  hostKey
+	down bool
+}
+
+// keyModifiers tracks the state of the modifier keys.
+type keyModifiers struct {
+	shift       bool
+	ctrl        bool
+	capsLock    bool
+	openApple   bool
+	closedApple bool
+}
+
+// The keyboard translates host key events into Apple2 key codes. Front
+// ends push events into a queue from any goroutine, and the emulator
+// drains the queue between frames. A key press is held in the queue until
+// software has read the previous key, so fast typing isn't lost.
 type keyboard struct {
 	apple2  *apple2
 	keydata byte
 	keydown bool
+
+	mu     sync.Mutex
+	events []keyEvent
+	paste  pasteState
+	pasted []byte // key codes pasted since the input log last sampled them
+
+	processed []keyEvent // events processed by the last Update
+
+	mods        keyModifiers
+	held        map[hostKey]bool // non-modifier keys currently held
+	repeatKey   hostKey          // key being auto-repeated
+	repeatAt    uint64           // CPU cycle of the next auto-repeat
+	openApple   hostKey          // host key mapped to the open-Apple key
+	closedApple hostKey          // host key mapped to the closed-Apple key
 }
 
 const (
@@ -17,6 +269,11 @@ func newKeyboard(apple2 *apple2) *keyboard {
 }
 
 func (kb *keyboard) Init() {
+	kb.held = make(map[hostKey]bool)
+	kb.mods.capsLock = true
+	kb.openApple = hostKeyLeftAlt
+	kb.closedApple = hostKeyRightAlt
+	kb.paste.opts = defaultPasteOptions()
 }
 
 func (kb *keyboard) IsKeyDown() bool {
@@ -27,6 +284,13 @@ func (kb *keyboard) GetKeyData() byte {
 	return kb.keydata
 }
 
+// ReadKeyData returns the keyboard data on behalf of software reading the
+// keyboard, giving pending pasted text a chance to be typed first.
+func (kb *keyboard) ReadKeyData() byte {
+	kb.updatePaste(true)
+	return kb.keydata
+}
+
 func (kb *keyboard) SetKey(v byte) {
 	kb.keydata = v | keyStrobe
 }
@@ -34,3 +298,132 @@ func (kb *keyboard) SetKey(v byte) {
 func (kb *keyboard) ResetKeyStrobe() {
 	kb.keydata &= ^keyStrobe
 }
+
+// OpenApple returns true if the open-Apple key is held.
+func (kb *keyboard) OpenApple() bool {
+	return kb.mods.openApple
+}
+
+// ClosedApple returns true if the closed-Apple key is held.
+func (kb *keyboard) ClosedApple() bool {
+	return kb.mods.closedApple
+}
+
+// SetAppleKeys selects the host keys that act as the open-Apple and
+// closed-Apple keys.
+func (kb *keyboard) SetAppleKeys(openApple, closedApple hostKey) {
+	kb.openApple = openApple
+	kb.closedApple = closedApple
+}
+
+// PushKeyEvent queues a host key press or release. It is safe to call from
+// any goroutine.
+func (kb *keyboard) PushKeyEvent(key hostKey, down bool) {
+	if kb.apple2.in.Replaying() {
+		return
+	}
+	kb.mu.Lock()
+	kb.events = append(kb.events, keyEvent{key: key, down: down})
+	kb.mu.Unlock()
+}
+
+// Update processes queued key events and handles auto-repeat.
+func (kb *keyboard) Update() {
+	kb.mu.Lock()
+	n := 0
+	for ; n < len(kb.events); n++ {
+		e := kb.events[n]
+		if e.down && kb.isCharKey(e.key) && (kb.keydata&keyStrobe) != 0 {
+			break
+		}
+		kb.processEvent(e)
+	}
+	kb.processed = append(kb.processed, kb.events[:n]...)
+	kb.events = kb.events[:copy(kb.events, kb.events[n:])]
+	kb.mu.Unlock()
+
+	kb.updatePaste(false)
+
+	if kb.apple2.cfg.autoRepeat && kb.held[kb.repeatKey] && kb.apple2.cpu.Cycles >= kb.repeatAt {
+		if v, ok := kb.translate(kb.repeatKey); ok {
+			kb.SetKey(v)
+		}
+		kb.repeatAt += keyRepeatPeriod
+	}
+}
+
+func (kb *keyboard) processEvent(e keyEvent) {
+	switch e.key {
+	case hostKeyLeftShift, hostKeyRightShift:
+		kb.mods.shift = e.down
+		return
+	case hostKeyLeftCtrl, hostKeyRightCtrl:
+		kb.mods.ctrl = e.down
+		return
+	case hostKeyCapsLock:
+		if e.down {
+			kb.mods.capsLock = !kb.mods.capsLock
+		}
+		return
+	case kb.openApple:
+		kb.mods.openApple = e.down
+		return
+	case kb.closedApple:
+		kb.mods.closedApple = e.down
+		return
+	case resetHotkey:
+		if e.down {
+			kb.apple2.resetKey(kb.mods)
+		}
+		return
+	}
+
+	if e.down {
+		v, ok := kb.translate(e.key)
+		if !ok {
+			return
+		}
+		kb.held[e.key] = true
+		kb.SetKey(v)
+		kb.repeatKey = e.key
+		kb.repeatAt = kb.apple2.cpu.Cycles + keyRepeatDelay
+	} else {
+		delete(kb.held, e.key)
+	}
+	kb.keydown = len(kb.held) > 0
+}
+
+// isCharKey returns true if the host key produces an Apple2 key code.
+func (kb *keyboard) isCharKey(key hostKey) bool {
+	_, ok := kb.translate(key)
+	return ok
+}
+
+// translate converts a host key into an Apple2 key code using the current
+// modifier state.
+func (kb *keyboard) translate(key hostKey) (byte, bool) {
+	if key >= hostKeyA && key <= hostKeyZ {
+		v := byte('a') + byte(key-hostKeyA)
+		switch {
+		case kb.mods.ctrl:
+			return v & 0x1f, true
+		case kb.mods.shift || kb.mods.capsLock || !kb.apple2.cfg.lowercase:
+			return v - 0x20, true
+		}
+		return v, true
+	}
+
+	codes, ok := hostKeyCodes[key]
+	if !ok {
+		return 0, false
+	}
+
+	v := codes[0]
+	if kb.mods.shift {
+		v = codes[1]
+	}
+	if kb.mods.ctrl && v >= '@' && v <= '_' {
+		v &= 0x1f
+	}
+	return v, true
+}
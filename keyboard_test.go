@@ -0,0 +1,157 @@
+package main
+
+import "testing"
+
+func TestKeyTranslation(t *testing.T) {
+	a := newApple2()
+	kb := a.kb
+
+	cases := []struct {
+		mods keyModifiers
+		key  hostKey
+		code byte
+	}{
+		{keyModifiers{}, hostKeyA, 'a'},
+		{keyModifiers{capsLock: true}, hostKeyA, 'A'},
+		{keyModifiers{shift: true}, hostKeyZ, 'Z'},
+		{keyModifiers{ctrl: true}, hostKeyA + 2, 0x03},
+		{keyModifiers{}, hostKey1, '1'},
+		{keyModifiers{shift: true}, hostKey1, '!'},
+		{keyModifiers{capsLock: true}, hostKey1, '1'},
+		{keyModifiers{shift: true, ctrl: true}, hostKey1 + 1, 0x00},
+		{keyModifiers{}, hostKeyLeft, keyCodeLeft},
+		{keyModifiers{}, hostKeyReturn, keyCodeReturn},
+	}
+
+	for _, c := range cases {
+		kb.mods = c.mods
+		code, ok := kb.translate(c.key)
+		if !ok || code != c.code {
+			t.Errorf("Key %02x with %+v: expected %02x, got %02x\n", c.key, c.mods, c.code, code)
+		}
+	}
+}
+
+func TestKeyQueue(t *testing.T) {
+	a := newApple2()
+	kb := a.kb
+
+	kb.PushKeyEvent(hostKeyA, true)
+	kb.PushKeyEvent(hostKeyA, false)
+	kb.PushKeyEvent(hostKeyA+1, true)
+	kb.PushKeyEvent(hostKeyA+1, false)
+
+	kb.Update()
+	if v := a.mmu.LoadByte(0xc000); v != 0x80|'A' {
+		t.Errorf("Expected first key C1, got %02x\n", v)
+	}
+
+	// The second key waits until the strobe is cleared.
+	kb.Update()
+	if v := a.mmu.LoadByte(0xc000); v != 0x80|'A' {
+		t.Errorf("Expected first key to remain C1, got %02x\n", v)
+	}
+
+	a.mmu.LoadByte(0xc010)
+	kb.Update()
+	if v := a.mmu.LoadByte(0xc000); v != 0x80|'B' {
+		t.Errorf("Expected second key C2, got %02x\n", v)
+	}
+}
+
+func TestKeyRepeat(t *testing.T) {
+	a := newApple2()
+	kb := a.kb
+
+	kb.PushKeyEvent(hostKeySpace, true)
+	kb.Update()
+	a.mmu.LoadByte(0xc010)
+
+	a.cpu.Cycles += keyRepeatDelay - 1
+	kb.Update()
+	if v := a.mmu.LoadByte(0xc000); v&keyStrobe != 0 {
+		t.Errorf("Unexpected repeat before delay\n")
+	}
+
+	a.cpu.Cycles++
+	kb.Update()
+	if v := a.mmu.LoadByte(0xc000); v != 0x80|' ' {
+		t.Errorf("Expected repeat after delay, got %02x\n", v)
+	}
+
+	if v := a.mmu.LoadByte(0xc010); v&0x80 == 0 {
+		t.Errorf("Expected any-key-down flag while key held\n")
+	}
+}
+
+func TestPasteText(t *testing.T) {
+	a := newApple2()
+	kb := a.kb
+	kb.SetPasteOptions(pasteOptions{rate: 1000, uppercase: true, waitForPrompt: true})
+	kb.PasteText("a\r\nb")
+
+	readKey := func() byte {
+		a.cpu.Cycles += 1021
+		v := a.mmu.LoadByte(0xc000)
+		if v&keyStrobe != 0 {
+			a.mmu.LoadByte(0xc010)
+		}
+		return v
+	}
+
+	if v := readKey(); v != 0x80|'A' {
+		t.Errorf("Expected C1, got %02x\n", v)
+	}
+	if v := readKey(); v != 0x80|keyCodeReturn {
+		t.Errorf("Expected 8D, got %02x\n", v)
+	}
+
+	// After a CR, keys are held until the keyboard is polled from KEYIN.
+	if v := readKey(); v&keyStrobe != 0 {
+		t.Errorf("Unexpected key %02x before prompt\n", v)
+	}
+	a.cpu.LastPC = promptKeyInStart
+	if v := readKey(); v != 0x80|'B' {
+		t.Errorf("Expected C2 at prompt, got %02x\n", v)
+	}
+	if kb.Pasting() {
+		t.Errorf("Expected paste to be complete\n")
+	}
+}
+
+func TestKeyStrobeC01x(t *testing.T) {
+	cases := []struct {
+		model machineModel
+		addr  uint16
+		write bool
+		clear bool
+	}{
+		{modelIIPlus, 0xc010, false, true},
+		{modelIIPlus, 0xc011, false, true},
+		{modelIIPlus, 0xc01f, false, true},
+		{modelIIPlus, 0xc010, true, true},
+		{modelIIPlus, 0xc018, true, true},
+		{modelIIPlus, 0xc01f, true, true},
+		{modelII, 0xc01c, false, true},
+		{modelII, 0xc015, true, true},
+		{modelIIe, 0xc010, false, true},
+		{modelIIe, 0xc011, false, false},
+		{modelIIe, 0xc01f, false, false},
+		{modelIIe, 0xc010, true, true},
+	}
+
+	for _, c := range cases {
+		a := newApple2Model(c.model)
+		a.kb.PushKeyEvent(hostKeyA, true)
+		a.kb.Update()
+		if c.write {
+			a.mmu.StoreByte(c.addr, 0)
+		} else {
+			a.mmu.LoadByte(c.addr)
+		}
+		cleared := a.mmu.LoadByte(0xc000)&keyStrobe == 0
+		if cleared != c.clear {
+			t.Errorf("%v: access to %04x (write %v): expected strobe cleared %v, got %v\n", c.model, c.addr, c.write, c.clear, cleared)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+//go:build libretro
+
+package main
+
+/*
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+// The parts of libretro.h that the core uses.
+
+#define RETRO_API_VERSION 1
+#define RETRO_REGION_NTSC 0
+
+#define RETRO_DEVICE_JOYPAD 1
+#define RETRO_DEVICE_ANALOG 5
+
+#define RETRO_DEVICE_ID_JOYPAD_B     0
+#define RETRO_DEVICE_ID_JOYPAD_UP    4
+#define RETRO_DEVICE_ID_JOYPAD_DOWN  5
+#define RETRO_DEVICE_ID_JOYPAD_LEFT  6
+#define RETRO_DEVICE_ID_JOYPAD_RIGHT 7
+#define RETRO_DEVICE_ID_JOYPAD_A     8
+
+#define RETRO_DEVICE_INDEX_ANALOG_LEFT 0
+#define RETRO_DEVICE_ID_ANALOG_X       0
+#define RETRO_DEVICE_ID_ANALOG_Y       1
+
+#define RETRO_ENVIRONMENT_GET_SYSTEM_DIRECTORY  9
+#define RETRO_ENVIRONMENT_SET_PIXEL_FORMAT      10
+#define RETRO_ENVIRONMENT_SET_KEYBOARD_CALLBACK 12
+
+#define RETRO_PIXEL_FORMAT_XRGB8888 1
+
+typedef bool (*retro_environment_t)(unsigned cmd, void *data);
+typedef void (*retro_video_refresh_t)(const void *data, unsigned width, unsigned height, size_t pitch);
+typedef void (*retro_audio_sample_t)(int16_t left, int16_t right);
+typedef size_t (*retro_audio_sample_batch_t)(const int16_t *data, size_t frames);
+typedef void (*retro_input_poll_t)(void);
+typedef int16_t (*retro_input_state_t)(unsigned port, unsigned device, unsigned index, unsigned id);
+typedef void (*retro_keyboard_event_t)(bool down, unsigned keycode, uint32_t character, uint16_t key_modifiers);
+
+struct retro_keyboard_callback {
+	retro_keyboard_event_t callback;
+};
+
+struct retro_system_info {
+	const char *library_name;
+	const char *library_version;
+	const char *valid_extensions;
+	bool need_fullpath;
+	bool block_extract;
+};
+
+struct retro_game_geometry {
+	unsigned base_width;
+	unsigned base_height;
+	unsigned max_width;
+	unsigned max_height;
+	float aspect_ratio;
+};
+
+struct retro_system_timing {
+	double fps;
+	double sample_rate;
+};
+
+struct retro_system_av_info {
+	struct retro_game_geometry geometry;
+	struct retro_system_timing timing;
+};
+
+struct retro_game_info {
+	const char *path;
+	const void *data;
+	size_t size;
+	const char *meta;
+};
+
+// Go can't call C function pointers, so these call them for it.
+
+static inline bool call_environment(retro_environment_t cb, unsigned cmd, void *data) {
+	return cb(cmd, data);
+}
+
+static inline void call_video_refresh(retro_video_refresh_t cb, const void *data, unsigned width, unsigned height, size_t pitch) {
+	cb(data, width, height, pitch);
+}
+
+static inline size_t call_audio_sample_batch(retro_audio_sample_batch_t cb, const int16_t *data, size_t frames) {
+	return cb(data, frames);
+}
+
+static inline void call_input_poll(retro_input_poll_t cb) {
+	cb();
+}
+
+static inline int16_t call_input_state(retro_input_state_t cb, unsigned port, unsigned device, unsigned index, unsigned id) {
+	return cb(port, device, index, id);
+}
+
+extern void apple2goKeyboardEvent(bool down, unsigned keycode, uint32_t character, uint16_t key_modifiers);
+
+static inline bool set_keyboard_callback(retro_environment_t cb) {
+	struct retro_keyboard_callback kb = { apple2goKeyboardEvent };
+	return cb(RETRO_ENVIRONMENT_SET_KEYBOARD_CALLBACK, &kb);
+}
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// The libretro core runs the machine in RetroArch and the other libretro
+// front ends. Build it as a shared library with
+//
+//	go build -buildmode=c-shared -tags libretro -o apple2go_libretro.so
+//
+// The core runs an enhanced IIe with a Disk II controller in slot 6,
+// using the ROM images in the apple2go directory, or apple2go.zip, in the
+// front end's system directory. The content it loads is a disk image,
+// inserted in drive 1; changes written to the disk are saved to the
+// image file, as the run command does.
+
+// A retroCore holds the state of the libretro core. The front end calls
+// the core from one thread, except for keyboard events.
+type retroCore struct {
+	apple2 *apple2
+
+	environment      C.retro_environment_t
+	videoRefresh     C.retro_video_refresh_t
+	audioSampleBatch C.retro_audio_sample_batch_t
+	inputPoll        C.retro_input_poll_t
+	inputState       C.retro_input_state_t
+
+	info    C.struct_retro_system_info
+	frame   []byte  // the display as XRGB8888 pixels
+	samples []int16 // mono samples
+	stereo  []int16 // interleaved stereo samples
+	pad     retroJoypad
+}
+
+var core retroCore
+
+//export retro_api_version
+func retro_api_version() C.unsigned {
+	return C.RETRO_API_VERSION
+}
+
+//export retro_set_environment
+func retro_set_environment(cb C.retro_environment_t) {
+	core.environment = cb
+}
+
+//export retro_set_video_refresh
+func retro_set_video_refresh(cb C.retro_video_refresh_t) {
+	core.videoRefresh = cb
+}
+
+//export retro_set_audio_sample
+func retro_set_audio_sample(cb C.retro_audio_sample_t) {}
+
+//export retro_set_audio_sample_batch
+func retro_set_audio_sample_batch(cb C.retro_audio_sample_batch_t) {
+	core.audioSampleBatch = cb
+}
+
+//export retro_set_input_poll
+func retro_set_input_poll(cb C.retro_input_poll_t) {
+	core.inputPoll = cb
+}
+
+//export retro_set_input_state
+func retro_set_input_state(cb C.retro_input_state_t) {
+	core.inputState = cb
+}
+
+//export retro_set_controller_port_device
+func retro_set_controller_port_device(port, device C.unsigned) {}
+
+//export retro_init
+func retro_init() {
+	// The strings live as long as the library.
+	if core.info.library_name != nil {
+		return
+	}
+	core.info = C.struct_retro_system_info{
+		library_name:     C.CString("apple2go"),
+		library_version:  C.CString("1"),
+		valid_extensions: C.CString("dsk|do|po|nib|d13|woz"),
+		need_fullpath:    true,
+	}
+}
+
+//export retro_deinit
+func retro_deinit() {}
+
+//export retro_get_system_info
+func retro_get_system_info(info *C.struct_retro_system_info) {
+	retro_init()
+	*info = core.info
+}
+
+//export retro_get_system_av_info
+func retro_get_system_av_info(info *C.struct_retro_system_av_info) {
+	// The display's CRT options give the size of its frames, which is
+	// at most that of a 4:3 frame.
+	w, h := displayWidth, displayWidth*3/4
+	if core.apple2 != nil {
+		w, h = core.apple2.ds.CRTOptions().outputSize()
+	}
+	*info = C.struct_retro_system_av_info{
+		geometry: C.struct_retro_game_geometry{
+			base_width:   C.unsigned(w),
+			base_height:  C.unsigned(h),
+			max_width:    displayWidth,
+			max_height:   displayWidth * 3 / 4,
+			aspect_ratio: 4.0 / 3.0,
+		},
+		timing: C.struct_retro_system_timing{
+			fps:         cpuClockRate / cyclesPerFrame,
+			sample_rate: audioSampleRate,
+		},
+	}
+}
+
+//export retro_get_region
+func retro_get_region() C.unsigned {
+	return C.RETRO_REGION_NTSC
+}
+
+//export retro_load_game
+func retro_load_game(game *C.struct_retro_game_info) C.bool {
+	format := C.unsigned(C.RETRO_PIXEL_FORMAT_XRGB8888)
+	if !C.call_environment(core.environment, C.RETRO_ENVIRONMENT_SET_PIXEL_FORMAT, unsafe.Pointer(&format)) {
+		fmt.Fprintln(os.Stderr, "apple2go: the front end doesn't support XRGB8888 pixels")
+		return false
+	}
+	C.set_keyboard_callback(core.environment)
+
+	var dir *C.char
+	C.call_environment(core.environment, C.RETRO_ENVIRONMENT_GET_SYSTEM_DIRECTORY, unsafe.Pointer(&dir))
+	romDir := filepath.Join(C.GoString(dir), "apple2go")
+	if _, err := os.Stat(romDir); err != nil {
+		romDir += ".zip"
+	}
+
+	file := ""
+	if game != nil && game.path != nil {
+		file = C.GoString(game.path)
+	}
+	a, err := retroMachine(romDir, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apple2go: %v\n", err)
+		return false
+	}
+	core.apple2 = a
+	return true
+}
+
+// retroMachine builds the core's machine from the ROM images in a
+// directory or zip file, with a disk image file in drive 1 unless file is
+// "".
+func retroMachine(romDir, file string) (*apple2, error) {
+	a := newApple2Model(modelIIeEnhanced)
+	roms, err := loadROMSet(romDir)
+	if err == nil {
+		err = roms.Validate(modelIIeEnhanced, true)
+	}
+	if err == nil {
+		err = a.LoadROMSet(roms)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rom, err := roms.DiskIIROM()
+	if err != nil {
+		return nil, err
+	}
+	a.sm.Insert(6, newDiskII(a, rom))
+	a.FillRAM()
+	if file != "" {
+		if err := a.InsertDisk(1, file); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+//export retro_load_game_special
+func retro_load_game_special(typ C.unsigned, info *C.struct_retro_game_info, num C.size_t) C.bool {
+	return false
+}
+
+//export retro_unload_game
+func retro_unload_game() {
+	if core.apple2 == nil {
+		return
+	}
+	if d, err := core.apple2.diskController(); err == nil {
+		if err := d.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "apple2go: %v\n", err)
+		}
+	}
+	core.apple2 = nil
+}
+
+//export retro_reset
+func retro_reset() {
+	if core.apple2 != nil {
+		core.apple2.ColdReset()
+	}
+}
+
+//export retro_run
+func retro_run() {
+	a := core.apple2
+	C.call_input_poll(core.inputPoll)
+	core.pollJoypad()
+	a.RunFrame()
+
+	img := a.ds.Frame()
+	if len(core.frame) != len(img.Pix) {
+		core.frame = make([]byte, len(img.Pix))
+	}
+	for i := 0; i < len(img.Pix); i += 4 {
+		p := img.Pix[i : i+4]
+		binary.LittleEndian.PutUint32(core.frame[i:], uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2]))
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	C.call_video_refresh(core.videoRefresh, unsafe.Pointer(&core.frame[0]), C.unsigned(w), C.unsigned(h), C.size_t(img.Stride))
+
+	n := a.au.buf.Buffered()
+	if n == 0 {
+		return
+	}
+	if cap(core.samples) < n {
+		core.samples, core.stereo = make([]int16, n), make([]int16, 2*n)
+	}
+	samples, stereo := core.samples[:n], core.stereo[:2*n]
+	a.au.ReadSamples(samples)
+	for i, s := range samples {
+		stereo[2*i], stereo[2*i+1] = s, s
+	}
+	C.call_audio_sample_batch(core.audioSampleBatch, (*C.int16_t)(unsafe.Pointer(&stereo[0])), C.size_t(n))
+}
+
+// pollJoypad reads the first joypad's state for the machine.
+func (c *retroCore) pollJoypad() {
+	state := func(device, index, id C.unsigned) int16 {
+		return int16(C.call_input_state(c.inputState, 0, device, index, id))
+	}
+	pressed := func(id C.unsigned) bool {
+		return state(C.RETRO_DEVICE_JOYPAD, 0, id) != 0
+	}
+	c.pad.Update(c.apple2, retroInput{
+		analog: [2]int16{
+			state(C.RETRO_DEVICE_ANALOG, C.RETRO_DEVICE_INDEX_ANALOG_LEFT, C.RETRO_DEVICE_ID_ANALOG_X),
+			state(C.RETRO_DEVICE_ANALOG, C.RETRO_DEVICE_INDEX_ANALOG_LEFT, C.RETRO_DEVICE_ID_ANALOG_Y),
+		},
+		dpad: [2][2]bool{
+			{pressed(C.RETRO_DEVICE_ID_JOYPAD_LEFT), pressed(C.RETRO_DEVICE_ID_JOYPAD_RIGHT)},
+			{pressed(C.RETRO_DEVICE_ID_JOYPAD_UP), pressed(C.RETRO_DEVICE_ID_JOYPAD_DOWN)},
+		},
+		buttons: [2]bool{pressed(C.RETRO_DEVICE_ID_JOYPAD_B), pressed(C.RETRO_DEVICE_ID_JOYPAD_A)},
+	})
+}
+
+//export apple2goKeyboardEvent
+func apple2goKeyboardEvent(down C.bool, keycode C.unsigned, character C.uint32_t, mods C.uint16_t) {
+	if k, ok := retroKeys[uint(keycode)]; ok && core.apple2 != nil {
+		core.apple2.im.KeyEvent(k, bool(down))
+	}
+}
+
+//export retro_serialize_size
+func retro_serialize_size() C.size_t {
+	return C.size_t(retroStateSize(core.apple2))
+}
+
+//export retro_serialize
+func retro_serialize(data unsafe.Pointer, size C.size_t) C.bool {
+	return C.bool(retroSaveState(core.apple2, unsafe.Slice((*byte)(data), int(size))))
+}
+
+//export retro_unserialize
+func retro_unserialize(data unsafe.Pointer, size C.size_t) C.bool {
+	return C.bool(retroLoadState(core.apple2, unsafe.Slice((*byte)(data), int(size))))
+}
+
+//export retro_cheat_reset
+func retro_cheat_reset() {}
+
+//export retro_cheat_set
+func retro_cheat_set(index C.unsigned, enabled C.bool, code *C.char) {}
+
+//export retro_get_memory_data
+func retro_get_memory_data(id C.unsigned) unsafe.Pointer {
+	return nil
+}
+
+//export retro_get_memory_size
+func retro_get_memory_size(id C.unsigned) C.size_t {
+	return 0
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// A logComponent is a part of the machine whose diagnostic messages are
+// logged at a level of their own.
+type logComponent int
+
+const (
+	logMMU   logComponent = iota // system ROM and aux memory bank selection
+	logIOU                       // soft switches
+	logDisk                      // disk drives: disks, motor, head and saves
+	logVideo                     // display modes
+	logAudio                     // audio output
+	logCards                     // peripheral cards and their host connections
+
+	logComponents
+)
+
+var logComponentNames = [logComponents]string{
+	"mmu", "iou", "disk", "video", "audio", "cards",
+}
+
+func (c logComponent) String() string {
+	return logComponentNames[c]
+}
+
+// parseLogComponent returns the component with a name.
+func parseLogComponent(s string) (logComponent, bool) {
+	for c, name := range logComponentNames {
+		if strings.EqualFold(s, name) {
+			return logComponent(c), true
+		}
+	}
+	return 0, false
+}
+
+// Besides slog's levels, components log at levelTrace the messages that
+// are too many to want even when debugging, such as one for every soft
+// switch change. A component at levelOff logs nothing.
+const (
+	levelTrace = slog.LevelDebug - 4
+	levelOff   = slog.LevelError + 4
+)
+
+var logLevels = []struct {
+	name  string
+	level slog.Level
+}{
+	{"trace", levelTrace},
+	{"debug", slog.LevelDebug},
+	{"info", slog.LevelInfo},
+	{"warn", slog.LevelWarn},
+	{"error", slog.LevelError},
+	{"off", levelOff},
+}
+
+func parseLogLevel(s string) (slog.Level, bool) {
+	for _, l := range logLevels {
+		if strings.EqualFold(s, l.name) {
+			return l.level, true
+		}
+	}
+	return 0, false
+}
+
+func logLevelName(level slog.Level) string {
+	for _, l := range logLevels {
+		if l.level == level {
+			return l.name
+		}
+	}
+	return level.String()
+}
+
+// logSpecUsage describes the settings parsed by machineLog.Configure.
+var logSpecUsage = "a level (" + logLevelNames() + ") for all components, or comma-separated component=level settings for the components " + strings.Join(logComponentNames[:], ", ")
+
+func logLevelNames() string {
+	var names []string
+	for _, l := range logLevels {
+		names = append(names, l.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+var errLogSpec = errors.New("bad log setting")
+
+// A machineLog holds a logger for each component of the machine. The
+// loggers write to the same handler, but each filters its messages by a
+// level that can be changed while the machine runs.
+type machineLog struct {
+	levels  [logComponents]slog.LevelVar
+	loggers [logComponents]*slog.Logger
+}
+
+// newMachineLog returns a machineLog whose components log messages at
+// the info level and above to a handler.
+func newMachineLog(h slog.Handler) *machineLog {
+	l := &machineLog{}
+	l.SetHandler(h)
+	return l
+}
+
+// newLogHandler returns a handler that writes messages to w as lines of
+// key=value pairs, starting with the time if times is true.
+func newLogHandler(w io.Writer, times bool) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: levelTrace,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch {
+			case len(groups) > 0:
+			case a.Key == slog.TimeKey && !times:
+				return slog.Attr{}
+			case a.Key == slog.LevelKey:
+				a.Value = slog.StringValue(strings.ToUpper(logLevelName(a.Value.Any().(slog.Level))))
+			}
+			return a
+		},
+	})
+}
+
+// SetHandler sends the messages of all components to a handler.
+func (l *machineLog) SetHandler(h slog.Handler) {
+	for c := range l.loggers {
+		h := &componentHandler{Handler: h, level: &l.levels[c]}
+		l.loggers[c] = slog.New(h).With("component", logComponent(c).String())
+	}
+}
+
+// Logger returns the logger of a component.
+func (l *machineLog) Logger(c logComponent) *slog.Logger {
+	return l.loggers[c]
+}
+
+// Enabled reports whether a component logs messages at a level, so that
+// callers in busy paths can skip describing messages nobody reads.
+func (l *machineLog) Enabled(c logComponent, level slog.Level) bool {
+	return level >= l.levels[c].Level()
+}
+
+// Level returns the level of a component.
+func (l *machineLog) Level(c logComponent) slog.Level {
+	return l.levels[c].Level()
+}
+
+// SetLevel sets the level of a component.
+func (l *machineLog) SetLevel(c logComponent, level slog.Level) {
+	l.levels[c].Set(level)
+}
+
+// Configure sets levels as described by a setting such as "debug", which
+// sets the level of every component, or "disk=debug,iou=trace", which
+// sets the levels of some. The component "all" names every component.
+func (l *machineLog) Configure(spec string) error {
+	var levels [logComponents]slog.Level
+	for c := range levels {
+		levels[c] = l.Level(logComponent(c))
+	}
+	for _, s := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(s), "=")
+		if !ok {
+			name, value = "all", name
+		}
+		level, ok := parseLogLevel(value)
+		if !ok {
+			return fmt.Errorf("%w '%s': unknown level '%s'", errLogSpec, s, value)
+		}
+		if strings.EqualFold(name, "all") {
+			for c := range levels {
+				levels[c] = level
+			}
+			continue
+		}
+		c, ok := parseLogComponent(name)
+		if !ok {
+			return fmt.Errorf("%w '%s': unknown component '%s'", errLogSpec, s, name)
+		}
+		levels[c] = level
+	}
+	for c, level := range levels {
+		l.SetLevel(logComponent(c), level)
+	}
+	return nil
+}
+
+// String lists the level of each component.
+func (l *machineLog) String() string {
+	var s []string
+	for c := logComponent(0); c < logComponents; c++ {
+		s = append(s, c.String()+"="+logLevelName(l.Level(c)))
+	}
+	return strings.Join(s, ",")
+}
+
+// A componentHandler passes a component's messages at or above its
+// level on to the handler shared by all components.
+type componentHandler struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &componentHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMachineLog(t *testing.T) {
+	a := newApple2()
+	var buf bytes.Buffer
+	a.log.SetHandler(newLogHandler(&buf, false))
+
+	// Messages below a component's level are dropped.
+	a.mmu.LoadByte(0xc051) // TEXT
+	a.sm.Insert(6, newDiskII(a, nil))
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected messages:\n%s", buf.String())
+	}
+
+	if err := a.log.Configure("iou=trace, cards=debug"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a.log.String(), "mmu=info,iou=trace,disk=info,video=info,audio=info,cards=debug"; got != want {
+		t.Errorf("Expected levels %s, got %s\n", want, got)
+	}
+	a.mmu.LoadByte(0xc050) // GR
+	a.sm.Remove(6)
+	want := []string{
+		`level=TRACE msg="soft switch changed" component=iou switch=TEXT on=false pc=0000`,
+		`level=DEBUG msg="card removed" component=cards slot=6 card="Disk II controller"`,
+	}
+	if got := strings.TrimSpace(buf.String()); got != strings.Join(want, "\n") {
+		t.Errorf("Expected messages:\n%s\ngot:\n%s\n", strings.Join(want, "\n"), got)
+	}
+
+	if err := a.log.Configure("off"); err != nil || a.log.Enabled(logCards, levelOff-1) {
+		t.Errorf("Expected all components off: %v %s\n", err, a.log)
+	}
+	for _, spec := range []string{"loud", "tape=debug", "disk=chatty"} {
+		if err := a.log.Configure(spec); !errors.Is(err, errLogSpec) {
+			t.Errorf("%s: expected %v, got %v\n", spec, errLogSpec, err)
+		}
+	}
+}
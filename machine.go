@@ -0,0 +1,159 @@
+package main
+
+import "github.com/beevik/go6502/cpu"
+
+// A machineModel identifies a member of the Apple2 family.
+type machineModel byte
+
+const (
+	modelII          machineModel = iota // Apple ][
+	modelIIPlus                          // Apple ][+
+	modelIIe                             // Apple //e
+	modelIIeEnhanced                     // Enhanced Apple //e
+	modelIIc                             // Apple //c
+
+	machineModels
+)
+
+// A machineConfig describes the hardware differences between models.
+type machineConfig struct {
+	model      machineModel
+	name       string           // short name used to select the model
+	desc       string           // human-readable model name
+	arch       cpu.Architecture // CPU variant
+	romFile    string           // default system ROM file
+	romSize    int              // size of the system ROM in bytes
+	romBanks   int              // maximum number of ROM banks selected by $C028
+	iie        bool             // true = IIe-style MMU, soft switches and keyboard
+	enhanced   bool             // true = enhanced firmware and MouseText characters
+	lowercase  bool             // true = keyboard can generate lowercase letters
+	autoRepeat bool             // true = keyboard auto-repeats held keys
+	slots      bool             // true = expansion slots present
+	builtins   bool             // true = built-in serial, mouse and disk ports
+	cassette   bool             // true = cassette interface present
+	auxCard    auxCardType      // default aux slot card
+}
+
+var machineConfigs = []machineConfig{
+	{
+		model:    modelII,
+		name:     "ii",
+		desc:     "Apple ][",
+		arch:     cpu.NMOS,
+		romFile:  "apple2.rom",
+		romSize:  12 * 1024,
+		romBanks: 1,
+		slots:    true,
+		cassette: true,
+		auxCard:  auxCardNone,
+	},
+	{
+		model:    modelIIPlus,
+		name:     "iiplus",
+		desc:     "Apple ][+",
+		arch:     cpu.NMOS,
+		romFile:  "apple2plus.rom",
+		romSize:  12 * 1024,
+		romBanks: 1,
+		slots:    true,
+		cassette: true,
+		auxCard:  auxCardNone,
+	},
+	{
+		model:      modelIIe,
+		name:       "iie",
+		desc:       "Apple //e",
+		arch:       cpu.NMOS,
+		romFile:    "apple2e-original.rom",
+		romSize:    16 * 1024,
+		romBanks:   1,
+		iie:        true,
+		lowercase:  true,
+		autoRepeat: true,
+		slots:      true,
+		cassette:   true,
+		auxCard:    auxCardExt80Col,
+	},
+	{
+		model:      modelIIeEnhanced,
+		name:       "iie-enhanced",
+		desc:       "Enhanced Apple //e",
+		arch:       cpu.CMOS,
+		romFile:    "apple2e.rom",
+		romSize:    16 * 1024,
+		romBanks:   1,
+		iie:        true,
+		enhanced:   true,
+		lowercase:  true,
+		autoRepeat: true,
+		slots:      true,
+		cassette:   true,
+		auxCard:    auxCardExt80Col,
+	},
+	{
+		model:      modelIIc,
+		name:       "iic",
+		desc:       "Apple //c",
+		arch:       cpu.CMOS,
+		romFile:    "apple2c.rom",
+		romSize:    16 * 1024,
+		romBanks:   2,
+		iie:        true,
+		enhanced:   true,
+		lowercase:  true,
+		autoRepeat: true,
+		slots:      false,
+		builtins:   true,
+		auxCard:    auxCardExt80Col,
+	},
+}
+
+func (m machineModel) String() string {
+	return machineConfigs[m].desc
+}
+
+// parseMachineModel converts a short model name into a machineModel.
+func parseMachineModel(s string) (machineModel, bool) {
+	for _, c := range machineConfigs {
+		if s == c.name {
+			return c.model, true
+		}
+	}
+	return 0, false
+}
+
+// machineModelNames returns the short names of all models.
+func machineModelNames() []string {
+	var names []string
+	for _, c := range machineConfigs {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// identifyROM examines the monitor ID bytes at $FBB3 and $FBC0 of a
+// system ROM image, which ends at $FFFF, to determine which model it was
+// written for.
+func identifyROM(rom []byte) (machineModel, bool) {
+	if len(rom) < 12*1024 || len(rom) > 16*1024 {
+		return 0, false
+	}
+	base := 0x10000 - len(rom)
+
+	switch rom[0xfbb3-base] {
+	case 0x38:
+		return modelII, true
+	case 0xea:
+		return modelIIPlus, true
+	case 0x06:
+		switch rom[0xfbc0-base] {
+		case 0xea:
+			return modelIIe, true
+		case 0xe0:
+			return modelIIeEnhanced, true
+		case 0x00:
+			return modelIIc, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+func TestParseMachineModel(t *testing.T) {
+	for _, name := range machineModelNames() {
+		m, ok := parseMachineModel(name)
+		if !ok {
+			t.Fatalf("Model %q not recognized\n", name)
+		}
+		if machineConfigs[m].name != name {
+			t.Errorf("Model %q: parsed as %q\n", name, machineConfigs[m].name)
+		}
+	}
+	if _, ok := parseMachineModel("iigs"); ok {
+		t.Errorf("Unknown model recognized\n")
+	}
+}
+
+func TestModelSoftSwitches(t *testing.T) {
+	cases := []struct {
+		model machineModel
+		arch  cpu.Architecture
+		aux   bool
+	}{
+		{modelIIPlus, cpu.NMOS, false},
+		{modelIIe, cpu.NMOS, true},
+		{modelIIeEnhanced, cpu.CMOS, true},
+	}
+
+	for _, c := range cases {
+		a := newApple2Model(c.model)
+		if a.cfg.arch != c.arch {
+			t.Errorf("Model %v: wrong CPU architecture\n", c.model)
+		}
+
+		// RAMWRT is honored only by models with auxiliary memory.
+		a.mmu.StoreByte(0xc005, 0)
+		a.mmu.StoreByte(0x0800, 0x5a)
+		a.mmu.StoreByte(0xc004, 0)
+		aux := a.mmu.mainRAM[0x0800] != 0x5a
+		if aux != c.aux {
+			t.Errorf("Model %v: expected aux write %v, got %v\n", c.model, c.aux, aux)
+		}
+	}
+}
+
+func TestIdentifyROM(t *testing.T) {
+	rom, err := os.ReadFile("resources/apple2e.rom")
+	if err != nil {
+		t.Skip(err)
+	}
+	if m, ok := identifyROM(rom); !ok || m != modelIIeEnhanced {
+		t.Errorf("Expected %v ROM, got %v (%v)\n", modelIIeEnhanced, m, ok)
+	}
+
+	a := newApple2Model(modelIIe)
+	if err := a.LoadROM("resources/apple2e.rom"); err == nil {
+		t.Errorf("Enhanced ROM accepted by the %v\n", modelIIe)
+	}
+}
+
+func TestMouseText(t *testing.T) {
+	cases := []struct {
+		model machineModel
+		alt   bool
+		code  byte
+		ch    byte
+		attr  textAttr
+	}{
+		{modelIIeEnhanced, false, 0x41, 'A', textFlash},
+		{modelIIeEnhanced, true, 0x41, 0x01, textMouse},
+		{modelIIeEnhanced, true, 0x61, 'a', textInverse},
+		{modelIIe, true, 0x41, 'A', textInverse},
+		{modelIIe, false, 0xe1, 'a', textNormal},
+		{modelIIPlus, false, 0xe1, '!', textNormal},
+		{modelIIPlus, false, 0x01, 'A', textInverse},
+	}
+
+	for _, c := range cases {
+		a := newApple2Model(c.model)
+		a.iou.setSoftSwitch(ioSwitchALTCHARSET, c.alt)
+		ch, attr := a.ds.TextGlyph(c.code)
+		if ch != c.ch || attr != c.attr {
+			t.Errorf("Model %v code %02x alt %v: expected %02x/%d, got %02x/%d\n",
+				c.model, c.code, c.alt, c.ch, c.attr, ch, attr)
+		}
+	}
+}
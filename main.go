@@ -1,36 +1,105 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/beevik/go6502/cpu"
 )
 
 type apple2 struct {
+	cfg machineConfig
 	mmu *mmu
 	iou *iou
 	kb  *keyboard
 	sp  *speaker
 	gi  *gameIO
+	ds  *display
+	vs  *videoScanner
+	au  *audio
+	sm  *slotManager
+	cas *cassette
+	im  *inputMapper
+	in  *inputLog
 	cpu *cpu.CPU
+
+	sched *scheduler    // timed device events
+	ints  *interrupts   // device interrupt lines
+	speed *speedControl // real-time pacing
+	ev    *eventBus     // machine event observers
+	log   *machineLog   // diagnostic messages of each component
+	calls *callQueue    // functions run for other goroutines
+
+	metrics *perfMetrics // performance counters
+
+	mouse    *iicMouse       // IIc built-in mouse interface, if present
+	zip      *zipChip        // accelerator, if installed
+	rewind   *rewindBuffer   // rewind history, if enabled
+	inputRec *inputRecording // input recording in progress, if any
+	dbg      *debugger       // attached debugger, if any
+	cheats   []*cheat        // cheats applied at the end of each frame
+	remote   *remoteControl  // HTTP remote control, if served
+	stream   *displayStream  // streaming front end, if served
+	vnc      *vncServer      // VNC front end, if served
+	trace    *tracer         // instruction tracer, if enabled
+	cover    *coverage       // code coverage, if recording
+	prof     *profiler       // cycle profile, if profiling
+	traps    *trapSet        // Go functions trapping instructions, if any
+	video    *videoRecorder  // display recording in progress, if any
+
+	ramFill ramFill // power-up memory contents
+	ramSeed int64   // seed for random memory contents
 }
 
 func newApple2() *apple2 {
-	apple2 := &apple2{}
+	return newApple2Model(modelIIeEnhanced)
+}
 
+func newApple2Model(model machineModel) *apple2 {
+	apple2 := &apple2{cfg: machineConfigs[model]}
+
+	apple2.sched = newScheduler(apple2)
+	apple2.ints = newInterrupts(apple2)
+	apple2.speed = newSpeedControl(apple2)
+	apple2.ev = newEventBus(apple2)
+	apple2.log = newMachineLog(newLogHandler(os.Stderr, false))
+	apple2.calls = newCallQueue()
+	apple2.metrics = newPerfMetrics(apple2)
 	apple2.mmu = newMMU(apple2)
 	apple2.iou = newIOU(apple2)
 	apple2.kb = newKeyboard(apple2)
 	apple2.sp = newSpeaker(apple2)
 	apple2.gi = newGameIO(apple2)
-	apple2.cpu = cpu.NewCPU(cpu.NMOS, apple2.mmu)
+	apple2.ds = newDisplay(apple2)
+	apple2.vs = newVideoScanner(apple2)
+	apple2.au = newAudio(apple2)
+	apple2.sm = newSlotManager(apple2)
+	apple2.cas = newCassette(apple2)
+	apple2.im = newInputMapper(apple2)
+	apple2.in = newInputLog(apple2)
+	apple2.cpu = cpu.NewCPU(apple2.cfg.arch, apple2.mmu)
 
 	apple2.mmu.Init()
 	apple2.iou.Init()
 	apple2.kb.Init()
 	apple2.sp.Init()
 	apple2.gi.Init()
+	apple2.ds.Init()
+	apple2.vs.Init()
+	apple2.au.Init()
+	apple2.sm.Init()
+	apple2.cas.Init()
+	apple2.im.Init()
+	apple2.in.Init()
+
+	apple2.mmu.SetAuxCard(apple2.cfg.auxCard)
+	if apple2.cfg.builtins {
+		apple2.installBuiltinPorts()
+	}
 
 	return apple2
 }
@@ -42,17 +111,788 @@ func (a *apple2) LoadROM(filename string) error {
 	}
 	defer file.Close()
 
-	return a.mmu.LoadSystemROM(file)
+	if err := a.mmu.LoadSystemROM(file); err != nil {
+		return err
+	}
+
+	// Firmware written for one model generally fails on another; the
+	// enhanced IIe ROM, for instance, uses 65C02 instructions.
+	if m, ok := identifyROM(a.mmu.systemROM); ok && m != a.cfg.model {
+		return fmt.Errorf("%s: ROM is for the %v, not the %v", filename, m, a.cfg.model)
+	}
+	return nil
+}
+
+// RunFrame runs the CPU for the duration of one video field and then
+// updates the devices that produce output for the front end. If an
+// attached debugger stops the CPU, or a trap ends the frame, the rest of
+// the frame is skipped. The functions passed to Do run first, and the
+// clients of the streaming and VNC front ends are sent the last frame,
+// so every loop that runs the machine serves them.
+func (a *apple2) RunFrame() {
+	a.runCalls()
+	a.sendFrame()
+	m := a.metrics
+	t := m.now()
+	a.in.BeginFrame()
+	t = m.lap(phaseInput, t)
+	end := a.cpu.Cycles + cyclesPerFrame
+	for a.cpu.Cycles < end {
+		if a.dbg != nil && a.dbg.check() {
+			break
+		}
+		if a.traps != nil && a.traps.check() {
+			break
+		}
+		if a.trace != nil {
+			a.trace.step()
+		}
+		a.step()
+	}
+	t = m.lap(phaseCPU, t)
+	if a.cheats != nil {
+		a.applyCheats()
+	}
+	a.im.Update()
+	a.in.UpdateKeyboard()
+	t = m.lap(phaseInput, t)
+	a.ds.Render()
+	if a.video != nil {
+		a.video.frame(a.ds.Framebuffer())
+	}
+	t = m.lap(phaseVideo, t)
+	a.au.Update()
+	t = m.lap(phaseAudio, t)
+	a.cas.Update()
+	t = m.lap(phaseCassette, t)
+	if d, err := a.diskController(); err == nil {
+		d.EndFrame()
+	}
+	t = m.lap(phaseDisk, t)
+	if a.rewind != nil {
+		a.rewind.EndFrame()
+	}
+	m.lap(phaseRewind, t)
+	a.ev.EndFrame()
+	m.EndFrame()
+}
+
+// step executes a single instruction, runs the device events that have
+// come due, and then takes any pending interrupt.
+func (a *apple2) step() {
+	start, pc := a.cpu.Cycles, a.cpu.Reg.PC
+	if h := a.mmu.heat; h != nil {
+		h.execs[a.cpu.Reg.PC]++
+	}
+	if dg := a.mmu.diag; dg != nil {
+		dg.exec(a.cpu.Reg.PC)
+	}
+	if c := a.cover; c != nil {
+		c.exec(a.cpu.Reg.PC)
+	}
+	a.cpu.Step()
+	if p := a.prof; p != nil {
+		p.add(pc, a.cpu.Cycles-start)
+	}
+	if a.zip != nil {
+		a.zip.accelerate(start)
+	}
+	a.sched.Run()
+	a.ints.service()
 }
 
-func main() {
-	apple := newApple2()
+// RecordAudio starts recording the audio output to a WAV file. The
+// returned function stops the recording and closes the file.
+func (a *apple2) RecordAudio(filename string) (stop func() error, err error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.au.StartRecording(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	stop = func() error {
+		err := a.au.StopRecording()
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return stop, nil
+}
+
+// LoadTape loads a WAV recording of a cassette tape and starts playing
+// it into the cassette input.
+func (a *apple2) LoadTape(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := a.cas.LoadTape(file); err != nil {
+		return err
+	}
+	a.cas.Play()
+	return nil
+}
+
+// SaveTape writes the memory in the range [start, end] to a WAV file in
+// the cassette tape format, so that it can be read back with the
+// monitor's R command.
+func (a *apple2) SaveTape(filename string, start, end uint16) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data := make([]byte, int(end)-int(start)+1)
+	a.mmu.LoadBytes(start, data)
+	return writeTape(file, data)
+}
+
+// runMachine runs a machine command: it builds a machine as described by
+// the command's flags and arguments, then runs it until it's done. It
+// returns the exit status.
+func runMachine(cmd string, args []string) int {
+	fs := flag.NewFlagSet("apple2go "+cmd, flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile(), "read settings from a configuration `file`, which flags override")
+	wavFile := fs.String("wav", "", "record audio output to a WAV `file`")
+	mbSlot := fs.Int("mockingboard", 0, "install a Mockingboard in `slot` (0 = none)")
+	mbSpeech := fs.Bool("speech", false, "add an SSI-263 speech chip to the Mockingboard")
+	sscSlot := fs.Int("ssc", 0, "install a Super Serial Card in `slot` (0 = none)")
+	serialSpec := fs.String("serial", "", "connect the Super Serial Card, or the IIc's modem port, to a host `endpoint`: "+serialUsage)
+	adtpro := fs.String("adtpro", "", "connect the Super Serial Card, or the IIc's modem port, to an ADTPro server through a host serial port `device` or endpoint: "+serialUsage)
+	adtproBaud := fs.Int("adtprobaud", adtproDefaultBaud, "ADTPro line speed in `baud`")
+	adtproHandshake := fs.Bool("adtprohandshake", true, "use hardware handshaking on the ADTPro line")
+	adtproPace := fs.Duration("adtpropace", 0, "pause for `duration` after each character sent to the ADTPro server")
+	mouseSlot := fs.Int("mouse", 0, "install an AppleMouse card in `slot` (0 = none)")
+	smartPortSlot := fs.Int("smartport", 0, "install a SmartPort card with a network device in `slot` (0 = none)")
+	var spImages smartPortImages
+	fs.Var(&spImages, "spimage", "attach a disk image `file` or http(s) URL to the SmartPort card (repeatable)")
+	ramDiskSpec := fs.String("ramdisk", "", "attach a RAM disk of `size` K, or M with an M suffix, to the SmartPort card, optionally saved to a file given after a comma")
+	cffaSlot := fs.Int("cffa", 0, "install a CFFA CompactFlash card in `slot` (0 = none)")
+	cfImageFile := fs.String("cfimage", "", "attach a CompactFlash image `file` to the CFFA card")
+	uthernetSlot := fs.Int("uthernet", 0, "install an Uthernet II network card in `slot` (0 = none)")
+	clockSlot := fs.Int("clock", 0, "install a ThunderClock card in `slot` (0 = none)")
+	printerSlot := fs.Int("printer", 0, "install a parallel printer card in `slot` (0 = none)")
+	printFile := fs.String("printfile", "printout.txt", "capture printer output to a text, PDF or PNG `file`")
+	speed := fs.String("speed", "1x", "run the machine at a `speed` (1x, 2x, 4x or warp)")
+	autoWarp := fs.Bool("autowarp", false, "run in warp mode while a disk drive motor is on")
+	diskSound := fs.Bool("disksound", true, "play the sounds of the disk drive motor and head")
+	zipChip := fs.Bool("zipchip", false, "install a ZIP CHIP accelerator")
+	fastTrapsOn := fs.Bool("fasttraps", false, "run the monitor's WAIT delay and the DOS 3.3 RWTS instantly instead of emulating them, trading accuracy for speed")
+	ramFillName := fs.String("ramfill", "pattern", "fill memory at power-up with a `pattern` (pattern, zero or random)")
+	ramSeed := fs.Int64("ramseed", 0, "`seed` for random memory contents (0 = use the time, and log the seed used)")
+	tapeFile := fs.String("tape", "", "play a WAV cassette tape `file` into the cassette input")
+	model := fs.String("model", "iie-enhanced", "machine `model` ("+strings.Join(machineModelNames(), ", ")+")")
+	romDir := fs.String("romdir", "./resources", "`directory` or zip file containing ROM images")
+	auxCard := fs.String("aux", "", "aux slot `card` (none, 80col, ext80col, ramworks)")
+	auxBanks := fs.Int("auxbanks", defaultRamWorksBanks, "number of 64K `banks` on a RamWorks card")
+	disk1 := fs.String("disk1", "", "insert a disk image `file` into slot 6, drive 1 (add ,ro to discard changes or ,cow to save them to an overlay)")
+	disk2 := fs.String("disk2", "", "insert a disk image `file` into slot 6, drive 2 (add ,ro or ,cow as for -disk1)")
+	diskII := fs.Bool("diskii", false, "install a Disk II controller in slot 6 even with no disk inserted")
+	diskII13 := fs.Bool("diskii13", false, "give the Disk II controller the 13-sector boot ROM, for DOS 3.2 disks (the default when -disk1 is a .d13 image)")
+	joyKeys := fs.String("joykeys", "", "move the joystick with a `set` of keys (arrows or wasd)")
+	var keyButtons keyButtonMaps
+	fs.Var(&keyButtons, "keybutton", "press a joystick button with a host key, as in `key=buttonN` (repeatable)")
+	loadState := fs.String("loadstate", "", "restore a machine snapshot `file` at startup")
+	saveState := fs.String("savestate", "", "save a machine snapshot to `file` on exit")
+	rewind := fs.Int("rewind", 0, "keep `seconds` of rewind history (0 = none)")
+	recordInput := fs.String("record", "", "record host input to `file` until exit")
+	debug := fs.Bool("debug", false, "run the machine under the debugger console")
+	scriptFile := fs.String("script", "", "run headlessly under the control of a script `file`, then exit with its status")
+	starlarkFile := fs.String("starlark", "", "run headlessly under the control of a Starlark program `file`, then exit with its status (needs a build with -tags starlark)")
+	gdbAddr := fs.String("gdb", "", "serve a GDB remote debugging client on TCP `address`, such as :1234")
+	symbols := fs.String("symbols", "", "load debugger symbols from a `file` or assembler listing")
+	replayInput := fs.String("replay", "", "replay an input recording `file`")
+	traceFile := fs.String("trace", "", "write a trace of executed instructions to `file`")
+	traceRanges := fs.String("tracerange", "", "trace only instructions in the address `ranges`, such as 0300-03FF,C600")
+	switchLogFile := fs.String("switchlog", "", "log accesses to the $C0xx soft switches to `file`")
+	switchChanges := fs.Bool("switchchanges", false, "log only soft switch accesses that change a switch")
+	var cheats cheatSpecs
+	fs.Var(&cheats, "cheat", "freeze a byte of memory, as in `addr=value`, or with ,once patch it once, and with ,if=value wait until it holds a value first (repeatable)")
+	var loads binaryLoads
+	fs.Var(&loads, "load", "load a binary `file,addr` into memory, with ,run to start it there (repeatable)")
+	basicFile := fs.String("basic", "", "load an Applesoft BASIC program listing `file` into memory")
+	screenshot := fs.String("screenshot", "", "save a PNG screenshot to `file` on exit")
+	diagnose := fs.String("diagnose", "", "report suspicious writes to ROM or recently executed code; `kinds` is rom, smc or all")
+	heatmapFile := fs.String("heatmap", "", "count memory accesses and save them to a CSV or PNG `file` on exit")
+	profileFile := fs.String("profile", "", "count the CPU cycles spent in each routine, named by the -symbols, and save them to `file` on exit")
+	coverageFile := fs.String("coverage", "", "record the code executed and save its address ranges, or with a .cdl extension code/data logs, to `file` on exit")
+	scale := fs.Int("scale", 1, "scale screenshots and video by an integer `factor`")
+	videoFile := fs.String("video", "", "record the display to an animated GIF `file`, or a raw RGBA frame stream (- = stdout)")
+	videoEvery := fs.Int("videoevery", 1, "record every `n`th frame")
+	mono := fs.Bool("mono", false, "render graphics in monochrome")
+	traceBRK := fs.Int("tracebrk", 0, "print the last `n` instructions executed whenever a BRK executes")
+	crashDir := fs.String("crashdir", ".", "if the emulator crashes, write a report of the machine's state to a new directory in `dir`")
+	crashTrace := fs.Int("crashtrace", 0, "keep the last `n` instructions executed for crash reports")
+	display := fs.String("display", "none", "display `backend`: none, text to show the text screen in the terminal, or tui to run the machine in the terminal, typing its keys and drawing graphics with block characters")
+	frames := fs.Int("frames", 0, "stop after running `n` video frames (0 = run until interrupted)")
+	logSpec := fs.String("log", "", "set the `levels` of logged messages: "+logSpecUsage)
+	vncAddr := fs.String("vnc", "", "serve the display to VNC clients, which can type and move the paddles, on TCP `address`, such as :5900")
+	streamAddr := fs.String("stream", "", "serve a page that shows the display in a browser and takes its input, over a WebSocket, on TCP `address`, such as :8080")
+	remoteAddr := fs.String("remote", "", "serve an HTTP API that controls the machine on TCP `address`, such as localhost:6502")
+	metricsAddr := fs.String("metrics", "", "serve performance counters for expvar at /debug/vars and Prometheus at /metrics on TCP `address`, such as :9100")
+	logFile := fs.String("logfile", "", "write logged messages, with their times, to `file` instead of standard error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: apple2go %s [flags] %s\n", cmd, machineCommands[cmd])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if *configFile != "" {
+		if err := applyConfigFile(fs, *configFile, set["config"]); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+
+	// The argument of a command names the program to boot or the file
+	// to capture to. Only run's argument is optional.
+	arg := fs.Arg(0)
+	want := 0
+	if machineCommands[cmd] != "" {
+		want = 1
+	}
+	if fs.NArg() > want || fs.NArg() < want && cmd != "run" {
+		fs.Usage()
+		return 2
+	}
+	runFile := ""
+	capture := false
+	switch cmd {
+	case "run":
+		runFile = arg
+	case "debug":
+		*debug = true
+	case "screenshot":
+		*screenshot, capture = arg, true
+	case "record":
+		*videoFile, capture = arg, true
+	case "snapshot save":
+		*saveState, capture = arg, true
+	case "snapshot load":
+		*loadState = arg
+	}
+	if capture && !set["frames"] {
+		*frames = defaultCaptureFrames
+	}
+	if !isDisplayBackend(*display) {
+		fmt.Printf("ERROR: unknown display '%s'\n", *display)
+		return 1
+	}
+	useDisk := *diskII || *diskII13 || *disk1 != "" || *disk2 != "" || runFile != ""
+	if f, _ := parseDiskSpec(*disk1); f != "" {
+		format, _ := diskFormatFromName(f)
+		*diskII13 = *diskII13 || format == diskFormatD13
+	}
+
+	m, ok := parseMachineModel(*model)
+	if !ok {
+		fmt.Printf("ERROR: unknown machine model '%s'\n", *model)
+		return 1
+	}
+	apple := newApple2Model(m)
+	if *logFile != "" {
+		f, err := os.Create(*logFile)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		apple.log.SetHandler(newLogHandler(f, true))
+	}
+	if *logSpec != "" {
+		if err := apple.log.Configure(*logSpec); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+
+	sm, ok := parseSpeedMode(*speed)
+	if !ok {
+		fmt.Printf("ERROR: unknown speed '%s'\n", *speed)
+		return 1
+	}
+	apple.speed.SetSpeed(sm)
+	apple.speed.SetAutoWarp(*autoWarp)
+
+	if *zipChip {
+		if err := apple.EnableZipChip(); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+
+	if *auxCard != "" {
+		t, ok := parseAuxCardType(*auxCard)
+		if !ok || !apple.cfg.iie {
+			fmt.Printf("ERROR: aux card '%s' not supported by the %v\n", *auxCard, m)
+			return 1
+		}
+		apple.mmu.SetAuxCard(t)
+		if t == auxCardRamWorks {
+			apple.mmu.SetRamWorksBanks(*auxBanks)
+		}
+	}
+
+	fill, ok := parseRAMFill(*ramFillName)
+	if !ok {
+		fmt.Printf("ERROR: unknown memory fill '%s'\n", *ramFillName)
+		return 1
+	}
+	if *ramSeed == 0 {
+		*ramSeed = time.Now().UnixNano()
+		if fill == ramFillRandom {
+			// Log the seed so that a run on the same contents can be
+			// repeated with -ramseed.
+			apple.log.Logger(logMMU).Info("random memory fill", "ramseed", *ramSeed)
+		}
+	}
+	apple.SetRAMFill(fill, *ramSeed)
+	apple.FillRAM()
+
+	if *mbSlot > 0 && *mbSlot < numSlots {
+		mb := newMockingboard(apple)
+		if *mbSpeech {
+			mb.AddSpeech(nil)
+		}
+		apple.sm.Insert(*mbSlot, mb)
+	}
 
-	err := apple.LoadROM("./resources/apple2e.rom")
+	roms, err := loadROMSet(*romDir)
+	if err == nil {
+		err = roms.Validate(m, useDisk && !*diskII13)
+	}
+	if err == nil {
+		err = apple.LoadROMSet(roms)
+	}
 	if err != nil {
 		fmt.Printf("ERROR: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+
+	if useDisk && apple.cfg.slots {
+		diskROM := roms.DiskIIROM
+		if *diskII13 {
+			diskROM = roms.DiskII13ROM
+		}
+		rom, err := diskROM()
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		apple.sm.Insert(6, newDiskII(apple, rom))
+	}
+	if d, err := apple.diskController(); err == nil {
+		d.SetSound(*diskSound)
+	}
+	if *adtpro != "" && *sscSlot == 0 {
+		*sscSlot = adtproDefaultSlot
+	}
+	if *sscSlot > 0 && *sscSlot < numSlots && apple.cfg.slots {
+		rom, err := roms.SSCROM()
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		apple.sm.Insert(*sscSlot, newSuperSerialCard(apple, rom))
+	}
+	if *mouseSlot > 0 && *mouseSlot < numSlots && apple.cfg.slots {
+		apple.sm.Insert(*mouseSlot, newAppleMouseCard(apple, *mouseSlot))
+	}
+	if *ramDiskSpec != "" && *smartPortSlot == 0 {
+		*smartPortSlot = ramDiskDefaultSlot
+	}
+	if *smartPortSlot > 0 && *smartPortSlot < numSlots && apple.cfg.slots {
+		c := newSmartPortCard(apple, *smartPortSlot)
+		for _, name := range spImages {
+			b, err := loadBlockImage(name)
+			if err != nil {
+				fmt.Printf("ERROR: %v\n", err)
+				return 1
+			}
+			c.Attach(b)
+		}
+		if *ramDiskSpec != "" {
+			blocks, file, err := parseRAMDiskSpec(*ramDiskSpec)
+			var r *ramDisk
+			if err == nil {
+				r, err = newRAMDisk(fmt.Sprintf("RAM%d", *smartPortSlot), blocks, file)
+			}
+			if err != nil {
+				fmt.Printf("ERROR: %v\n", err)
+				return 1
+			}
+			c.Attach(r)
+		}
+		c.Attach(newNetDevice())
+		apple.sm.Insert(*smartPortSlot, c)
+		defer c.Close()
+	}
+	if *cffaSlot > 0 && *cffaSlot < numSlots && apple.cfg.slots {
+		rom, err := roms.CFFAROM()
+		var img *cfImage
+		if err == nil {
+			img, err = loadCFImage(*cfImageFile)
+		}
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		c := newCFFACard(apple, *cffaSlot, rom, img)
+		apple.sm.Insert(*cffaSlot, c)
+		defer c.Close()
+	}
+	if *uthernetSlot > 0 && *uthernetSlot < numSlots && apple.cfg.slots {
+		c := newUthernetCard(apple)
+		apple.sm.Insert(*uthernetSlot, c)
+		defer c.Close()
+	}
+	if *clockSlot > 0 && *clockSlot < numSlots && apple.cfg.slots {
+		apple.sm.Insert(*clockSlot, newThunderClock(apple, *clockSlot))
+	}
+	if *printerSlot > 0 && *printerSlot < numSlots && apple.cfg.slots {
+		out, err := openPrintout(*printFile)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		p := newParallelPrinterCard(apple, *printerSlot, out)
+		apple.sm.Insert(*printerSlot, p)
+		defer p.Close()
+	}
+	if *serialSpec != "" {
+		slot := *sscSlot
+		if !apple.cfg.slots {
+			slot = 2
+		}
+		name, c, err := apple.ConnectSerial(slot, *serialSpec)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer c.Close()
+		apple.log.Logger(logCards).Info("serial port connected", "endpoint", name)
+	}
+	if *adtpro != "" {
+		slot := *sscSlot
+		if !apple.cfg.slots {
+			slot = 2
+		}
+		opts := adtproOptions{baud: *adtproBaud, handshake: *adtproHandshake, pace: *adtproPace}
+		name, c, err := apple.ConnectADTPro(slot, *adtpro, opts)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer c.Close()
+		apple.log.Logger(logCards).Info("ADTPro line connected", "endpoint", name)
+	}
+	for i, spec := range []string{*disk1, *disk2} {
+		if spec == "" {
+			continue
+		}
+		file, mode := parseDiskSpec(spec)
+		if err := apple.InsertDiskMode(i+1, file, mode); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+	if runFile != "" {
+		file, addr, err := parseRunFile(runFile)
+		if err == nil {
+			err = apple.BootFile(roms, file, addr)
+		}
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+	if d, err := apple.diskController(); err == nil {
+		defer d.Flush()
+	}
+
+	// Snapshots don't include the machine's configuration, so the same
+	// model, ROMs and cards must be specified when one is restored.
+	if *loadState != "" {
+		if err := apple.LoadStateFile(*loadState); err != nil {
+			fmt.Printf("ERROR: %s: %v\n", *loadState, err)
+			return 1
+		}
+	}
+	if *replayInput != "" {
+		if err := apple.ReplayInputFile(*replayInput); err != nil {
+			fmt.Printf("ERROR: %s: %v\n", *replayInput, err)
+			return 1
+		}
+	}
+	if *recordInput != "" {
+		if err := apple.StartInputRecording(); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := apple.SaveInputRecordingFile(*recordInput); err != nil {
+				fmt.Printf("ERROR: %s: %v\n", *recordInput, err)
+			}
+		}()
+	}
+	if *rewind > 0 {
+		apple.EnableRewind(*rewind)
+	}
+	if *saveState != "" {
+		defer func() {
+			if err := apple.SaveStateFile(*saveState); err != nil {
+				fmt.Printf("ERROR: %s: %v\n", *saveState, err)
+			}
+		}()
 	}
 
-	os.Exit(0)
+	for _, c := range cheats {
+		apple.AddCheat(c)
+	}
+	if *fastTrapsOn {
+		apple.EnableFastTraps()
+	}
+	for _, l := range loads {
+		if err := apple.LoadBinaryFile(l.filename, l.addr, l.run); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+	if *basicFile != "" {
+		if err := apple.LoadApplesoftFile(*basicFile); err != nil {
+			fmt.Printf("ERROR: %s: %v\n", *basicFile, err)
+			return 1
+		}
+	}
+
+	if *tapeFile != "" {
+		if err := apple.LoadTape(*tapeFile); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+	}
+
+	if *wavFile != "" {
+		stop, err := apple.RecordAudio(*wavFile)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer stop()
+	}
+
+	if *traceRanges != "" {
+		ranges, err := parseTraceRanges(*traceRanges)
+		if err != nil {
+			fmt.Printf("ERROR: -tracerange: %v\n", err)
+			return 1
+		}
+		t := apple.EnableTrace()
+		for _, r := range ranges {
+			t.AddRange(r.start, r.end)
+		}
+	}
+	if *traceBRK > 0 {
+		t := apple.EnableTrace()
+		t.SetRingSize(*traceBRK)
+		t.DumpOnBRK(os.Stdout)
+	}
+	if *crashTrace > *traceBRK {
+		apple.EnableTrace().SetRingSize(*crashTrace)
+	}
+	if *traceFile != "" {
+		stop, err := apple.TraceToFile(*traceFile)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer stop()
+	}
+
+	if *switchLogFile != "" {
+		file, err := os.Create(*switchLogFile)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		w := bufio.NewWriter(file)
+		apple.iou.StartSwitchLog(w, *switchChanges)
+		defer func() {
+			apple.iou.StopSwitchLog()
+			w.Flush()
+			file.Close()
+		}()
+	}
+
+	if *joyKeys != "" {
+		if !apple.im.MapKeysToJoystick(*joyKeys) {
+			fmt.Printf("ERROR: unknown joystick keys '%s'\n", *joyKeys)
+			return 1
+		}
+	}
+	for _, m := range keyButtons {
+		apple.im.MapKeyToButton(m.key, m.button)
+	}
+	if *joyKeys != "" || len(keyButtons) > 0 {
+		apple.im.EnableKeyJoystick(true)
+	}
+
+	if *mono {
+		apple.ds.SetMonochrome(true)
+	}
+	if *screenshot != "" {
+		opts := screenshotOptions{scale: *scale, mono: *mono}
+		defer func() {
+			if err := apple.SaveScreenshot(*screenshot, opts); err != nil {
+				fmt.Printf("ERROR: %s: %v\n", *screenshot, err)
+			}
+		}()
+	}
+
+	if *diagnose != "" {
+		kinds, ok := parseDiagKind(*diagnose)
+		if !ok {
+			fmt.Printf("ERROR: unknown diagnostics '%s'\n", *diagnose)
+			return 1
+		}
+		apple.mmu.StartDiagnostics(os.Stderr, kinds, 0)
+	}
+
+	if *heatmapFile != "" {
+		h := apple.mmu.EnableHeatmap()
+		defer func() {
+			if err := h.Save(*heatmapFile, false); err != nil {
+				fmt.Printf("ERROR: %s: %v\n", *heatmapFile, err)
+			}
+		}()
+	}
+
+	if *profileFile != "" {
+		syms := newSymbolTable()
+		if *symbols != "" {
+			if _, err := syms.LoadFile(*symbols); err != nil {
+				fmt.Printf("ERROR: %v\n", err)
+				return 1
+			}
+		}
+		p := apple.EnableProfile()
+		defer func() {
+			if err := p.Save(*profileFile, syms); err != nil {
+				fmt.Printf("ERROR: %s: %v\n", *profileFile, err)
+			}
+		}()
+	}
+
+	if *coverageFile != "" {
+		c := apple.EnableCoverage()
+		defer func() {
+			if err := c.Save(*coverageFile); err != nil {
+				fmt.Printf("ERROR: %s: %v\n", *coverageFile, err)
+			}
+		}()
+	}
+
+	if *videoFile != "" {
+		stop, err := apple.RecordVideo(*videoFile, videoOptions{every: *videoEvery, scale: *scale})
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer stop()
+	}
+
+	if *metricsAddr != "" {
+		l, err := serveMetrics(apple, *metricsAddr)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer l.Close()
+	}
+	if *remoteAddr != "" {
+		l, err := serveRemote(apple, *remoteAddr)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer l.Close()
+	}
+	if *streamAddr != "" {
+		l, err := serveStream(apple, *streamAddr)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer l.Close()
+	}
+	if *vncAddr != "" {
+		l, err := serveVNC(apple, *vncAddr)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		defer l.Close()
+	}
+
+	defer crashGuard{apple2: apple, dir: *crashDir}.Recover()
+
+	if *scriptFile != "" {
+		status, err := apple.RunScriptFile(*scriptFile, os.Stdout)
+		if err != nil {
+			fmt.Printf("ERROR: %s: %v\n", *scriptFile, err)
+		}
+		return status
+	}
+	if *starlarkFile != "" {
+		status, err := apple.RunStarlarkFile(*starlarkFile, os.Stdout)
+		if err != nil {
+			fmt.Printf("ERROR: %s: %v\n", *starlarkFile, err)
+		}
+		return status
+	}
+
+	if *gdbAddr != "" {
+		if err := serveGDB(apple, *gdbAddr); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if *debug {
+		d := apple.AttachDebugger()
+		if *symbols != "" {
+			if _, err := d.symbols.LoadFile(*symbols); err != nil {
+				fmt.Printf("ERROR: %v\n", err)
+				return 1
+			}
+		}
+		d.Console(os.Stdin, os.Stdout)
+		return 0
+	}
+
+	if *display == "tui" && !capture {
+		if err := apple.runTUI(os.Stdin, os.Stdout, *frames); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	apple.runHeadless(*display, *frames, !capture, os.Stdout)
+	return 0
 }
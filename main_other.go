@@ -0,0 +1,9 @@
+//go:build !js
+
+package main
+
+import "os"
+
+func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdout))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	errMemRange   = errors.New("memory range extends past $FFFF")
+	errMemIO      = errors.New("memory range includes the I/O space at $C000..$C0FF")
+	errHexDump    = errors.New("invalid hex dump line")
+	errHexDumpGap = errors.New("hex dump is not contiguous")
+)
+
+// A memView selects the memory that ReadMemory and WriteMemory access:
+// either memory as currently mapped by the soft switches, or one of the
+// physical memories.
+type memView struct {
+	phys  bool
+	space memSpace // physical memory, if phys is true
+	bank  int
+}
+
+// parseMemAddr parses an address, optionally prefixed with a physical
+// memory as in parsePhysAddr. The remainder, such as a hexadecimal address
+// or a range, is returned unparsed.
+func parseMemAddr(s string) (memView, string, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return memView{}, s, nil
+	}
+	p, err := parsePhysAddr(s[:i+1] + "0")
+	if err != nil {
+		return memView{}, "", err
+	}
+	return memView{phys: true, space: p.space, bank: p.bank}, s[i+1:], nil
+}
+
+// memViewString formats an address in a memory view, as parseMemAddr
+// parses it.
+func memViewString(v memView, addr uint16) string {
+	if v.phys {
+		return physAddr{v.space, v.bank, addr}.String()
+	}
+	return fmt.Sprintf("%04X", addr)
+}
+
+// parseHexOption parses the optional "hex" argument of the memory file
+// commands, which selects a hex dump rather than raw binary.
+func parseHexOption(args []string) (bool, error) {
+	switch {
+	case len(args) == 0:
+		return false, nil
+	case len(args) == 1 && strings.EqualFold(args[0], "hex"):
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected %q; expected hex", strings.Join(args, " "))
+	}
+}
+
+// ReadMemory reads n bytes starting at addr without triggering soft
+// switches or watchpoints. Through the current mapping, the I/O page
+// reads as zeros.
+func (a *apple2) ReadMemory(v memView, addr uint16, n int) ([]byte, error) {
+	if int(addr)+n > 0x10000 {
+		return nil, errMemRange
+	}
+	data := make([]byte, n)
+	for i := range data {
+		if !v.phys {
+			data[i] = a.mmu.Peek(addr + uint16(i))
+			continue
+		}
+		b, err := a.mmu.PeekPhys(physAddr{v.space, v.bank, addr + uint16(i)})
+		if err != nil {
+			return nil, err
+		}
+		data[i] = b
+	}
+	return data, nil
+}
+
+// WriteMemory writes data starting at addr without triggering
+// watchpoints or counting in the heatmap. Through the current mapping, the data may not overlap the
+// I/O page, whose soft switches would be triggered.
+func (a *apple2) WriteMemory(v memView, addr uint16, data []byte) error {
+	end := int(addr) + len(data)
+	if end > 0x10000 {
+		return errMemRange
+	}
+	if v.phys {
+		for i, b := range data {
+			if err := a.mmu.PokePhys(physAddr{v.space, v.bank, addr + uint16(i)}, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if int(addr) < 0xc100 && end > 0xc000 {
+		return errMemIO
+	}
+	m := a.mmu
+	w, h := m.watch, m.heat
+	m.watch, m.heat = nil, nil
+	m.StoreBytes(addr, data)
+	m.watch, m.heat = w, h
+	return nil
+}
+
+// SaveMemoryFile writes n bytes of memory starting at addr to a file, as
+// raw binary or as a hex dump.
+func (a *apple2) SaveMemoryFile(filename string, v memView, addr uint16, n int, hexDump bool) error {
+	data, err := a.ReadMemory(v, addr, n)
+	if err != nil {
+		return err
+	}
+	if !hexDump {
+		return os.WriteFile(filename, data, 0644)
+	}
+	var buf bytes.Buffer
+	writeHexDump(&buf, addr, data)
+	return os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// LoadMemoryFile writes the contents of a raw binary or hex dump file to
+// memory starting at addr, returning the number of bytes written. The
+// addresses in a hex dump only need to be contiguous; the data is written
+// at addr regardless of where it was dumped from.
+func (a *apple2) LoadMemoryFile(filename string, v memView, addr uint16, hexDump bool) (int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	if hexDump {
+		if data, err = readHexDump(bytes.NewReader(data)); err != nil {
+			return 0, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	if err := a.WriteMemory(v, addr, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// writeHexDump writes data as lines of up to 16 bytes, each preceded by
+// its address, in the format of the monitor's memory dumps.
+func writeHexDump(w io.Writer, addr uint16, data []byte) {
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		fmt.Fprintf(w, "%04X-% X\n", int(addr)+i, data[i:end])
+	}
+}
+
+// readHexDump reads the data of a hex dump written by writeHexDump. Blank
+// lines are ignored.
+func readHexDump(r io.Reader) ([]byte, error) {
+	var data []byte
+	next := -1
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, '-')
+		if i < 0 {
+			return nil, errHexDump
+		}
+		addr, err := parseDebugValue(line[:i], 0xffff)
+		if err != nil {
+			return nil, errHexDump
+		}
+		if next >= 0 && addr != next {
+			return nil, errHexDumpGap
+		}
+		b, err := hex.DecodeString(strings.Join(strings.Fields(line[i+1:]), ""))
+		if err != nil {
+			return nil, errHexDump
+		}
+		data = append(data, b...)
+		next = addr + len(b)
+	}
+	return data, sc.Err()
+}
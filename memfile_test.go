@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryFiles(t *testing.T) {
+	a := newApple2()
+	d := a.AttachDebugger()
+	exec := func(cmd string) string {
+		var out bytes.Buffer
+		if err := d.Exec(cmd, &out); err != nil {
+			t.Fatalf("%s: %v\n", cmd, err)
+		}
+		return out.String()
+	}
+	dir := t.TempDir()
+	bin, hexFile := filepath.Join(dir, "mem.bin"), filepath.Join(dir, "mem.hex")
+
+	for i := 0; i < 0x20; i++ {
+		a.mmu.auxRAM[0x2000+i] = byte(i)
+	}
+	if out := exec("ms aux:2000-201f " + bin); out != "32 bytes saved\n" {
+		t.Errorf("Unexpected output %q\n", out)
+	}
+	exec("ms aux:2000-2011 " + hexFile + " hex")
+	if b, _ := os.ReadFile(hexFile); !strings.HasPrefix(string(b), "2000-00 01 02") || strings.Count(string(b), "\n") != 2 {
+		t.Errorf("Unexpected hex dump %q\n", b)
+	}
+
+	// Files load back into any view, at any address.
+	exec("ml 3000 " + bin)
+	exec("ml lc1:d000 " + hexFile + " hex")
+	for i := 0; i < 0x20; i++ {
+		if v := a.mmu.Peek(0x3000 + uint16(i)); v != byte(i) {
+			t.Fatalf("Expected $%02X at $%04X, got $%02X\n", i, 0x3000+i, v)
+		}
+	}
+	if a.mmu.mainRAM[0xc011] != 0x11 || a.mmu.mainRAM[0xc012] == 0x12 {
+		t.Errorf("Expected the hex dump loaded into bank 1\n")
+	}
+
+	// The I/O page can't be loaded through the current mapping.
+	if err := d.Exec("ml bff0 "+bin, &bytes.Buffer{}); err != errMemIO {
+		t.Errorf("Expected an I/O space error, got %v\n", err)
+	}
+
+	if _, err := readHexDump(strings.NewReader("2000-01 02\n2010-03\n")); err != errHexDumpGap {
+		t.Errorf("Expected a gap error, got %v\n", err)
+	}
+	if _, err := readHexDump(strings.NewReader("2000-0g\n")); err != errHexDump {
+		t.Errorf("Expected a syntax error, got %v\n", err)
+	}
+}
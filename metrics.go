@@ -0,0 +1,207 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A metricPhase is a part of the work done to run a frame, whose host
+// time is counted separately.
+type metricPhase int
+
+const (
+	phaseCPU      metricPhase = iota // instructions and the device events they cause
+	phaseInput                       // input mapping and replay
+	phaseVideo                       // rendering and recording the display
+	phaseAudio                       // mixing the audio sources
+	phaseCassette                    // the cassette tape
+	phaseDisk                        // disk drive sounds and changes
+	phaseRewind                      // rewind snapshots
+
+	metricPhases
+)
+
+var metricPhaseNames = [metricPhases]string{
+	"cpu", "input", "video", "audio", "cassette", "disk", "rewind",
+}
+
+func (p metricPhase) String() string {
+	return metricPhaseNames[p]
+}
+
+// metricsWindow is the host time over which the rates are measured.
+const metricsWindow = time.Second
+
+// A metricsSnapshot holds the performance counters of a machine at the
+// end of a frame.
+type metricsSnapshot struct {
+	cycles     uint64                      // CPU cycles executed
+	frames     uint64                      // video frames run
+	underruns  uint64                      // audio reads that ran out of samples
+	fps        float64                     // frames run per second of host time
+	ratio      float64                     // speed relative to a real Apple II
+	phaseTimes [metricPhases]time.Duration // host time spent in each phase
+}
+
+func (s metricsSnapshot) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cycles %d, frames %d, audio underruns %d\n", s.cycles, s.frames, s.underruns)
+	fmt.Fprintf(&b, "host fps %.1f, speed %.2fx\n", s.fps, s.ratio)
+	var total time.Duration
+	for _, t := range s.phaseTimes {
+		total += t
+	}
+	for p, t := range s.phaseTimes {
+		pct := 0.0
+		if total > 0 {
+			pct = 100 * float64(t) / float64(total)
+		}
+		fmt.Fprintf(&b, "%-8s %12v %5.1f%%\n", metricPhase(p), t.Round(time.Microsecond), pct)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// vars returns the counters as a map for expvar.
+func (s metricsSnapshot) vars() map[string]any {
+	times := make(map[string]float64)
+	for p, t := range s.phaseTimes {
+		times[metricPhase(p).String()] = t.Seconds()
+	}
+	return map[string]any{
+		"cycles":          s.cycles,
+		"frames":          s.frames,
+		"audio_underruns": s.underruns,
+		"host_fps":        s.fps,
+		"speed_ratio":     s.ratio,
+		"phase_seconds":   times,
+	}
+}
+
+// writePrometheus writes the counters in the Prometheus text format.
+func (s metricsSnapshot) writePrometheus(w io.Writer) {
+	metric := func(name, typ, help string, v any) {
+		fmt.Fprintf(w, "# HELP apple2go_%s %s\n# TYPE apple2go_%s %s\napple2go_%s %v\n", name, help, name, typ, name, v)
+	}
+	metric("cycles_total", "counter", "CPU cycles executed.", s.cycles)
+	metric("frames_total", "counter", "Video frames run.", s.frames)
+	metric("audio_underruns_total", "counter", "Audio reads that ran out of samples.", s.underruns)
+	metric("host_fps", "gauge", "Frames run per second of host time.", s.fps)
+	metric("speed_ratio", "gauge", "Emulation speed relative to a real Apple II.", s.ratio)
+	fmt.Fprintf(w, "# HELP apple2go_phase_seconds_total Host time spent in each phase of running frames.\n")
+	fmt.Fprintf(w, "# TYPE apple2go_phase_seconds_total counter\n")
+	for p, t := range s.phaseTimes {
+		fmt.Fprintf(w, "apple2go_phase_seconds_total{phase=%q} %v\n", metricPhase(p), t.Seconds())
+	}
+}
+
+// A perfMetrics counts the work the machine does and the host time it
+// takes. The machine's goroutine updates the counters as it runs frames,
+// and publishes them at the end of each frame so that other goroutines
+// can read them.
+type perfMetrics struct {
+	apple2 *apple2
+	now    func() time.Time
+
+	frames     uint64
+	phaseTimes [metricPhases]time.Duration
+	window     time.Time // host time at which the current window began
+	winFrames  uint64    // frames at the start of the window
+	winCycles  uint64    // cycles at the start of the window
+	fps, ratio float64   // rates over the last complete window
+
+	mu        sync.Mutex
+	published metricsSnapshot
+}
+
+func newPerfMetrics(apple2 *apple2) *perfMetrics {
+	return &perfMetrics{
+		apple2: apple2,
+		now:    time.Now,
+	}
+}
+
+// lap adds the host time since start to a phase, and returns the time
+// at which the next phase starts.
+func (m *perfMetrics) lap(p metricPhase, start time.Time) time.Time {
+	now := m.now()
+	m.phaseTimes[p] += now.Sub(start)
+	return now
+}
+
+// EndFrame counts a frame, updates the rates once a window of host time
+// has passed, and publishes the counters.
+func (m *perfMetrics) EndFrame() {
+	m.frames++
+	now, cycles := m.now(), m.apple2.cpu.Cycles
+	switch elapsed := now.Sub(m.window); {
+	case m.window.IsZero() || elapsed > 2*metricsWindow:
+		// The machine was stopped or just started; start a new window
+		// rather than measure the pause.
+		m.window, m.winFrames, m.winCycles = now, m.frames, cycles
+	case elapsed >= metricsWindow:
+		m.fps = float64(m.frames-m.winFrames) / elapsed.Seconds()
+		m.ratio = float64(cycles-m.winCycles) / elapsed.Seconds() / cpuClockRate
+		m.window, m.winFrames, m.winCycles = now, m.frames, cycles
+	}
+
+	m.mu.Lock()
+	m.published = metricsSnapshot{
+		cycles:     cycles,
+		frames:     m.frames,
+		fps:        m.fps,
+		ratio:      m.ratio,
+		phaseTimes: m.phaseTimes,
+	}
+	m.mu.Unlock()
+}
+
+// Metrics returns the machine's performance counters as of the end of
+// the last frame. It is safe to call from any goroutine.
+func (a *apple2) Metrics() metricsSnapshot {
+	m := a.metrics
+	m.mu.Lock()
+	s := m.published
+	m.mu.Unlock()
+	s.underruns = a.au.Underruns()
+	return s
+}
+
+// expvar variables live for the life of the process, so the one
+// published reads the counters of the machine most recently served.
+var (
+	expvarOnce    sync.Once
+	expvarMachine atomic.Pointer[apple2]
+)
+
+// serveMetrics serves a machine's performance counters over HTTP on a TCP
+// address: as JSON at /debug/vars, along with the rest of expvar's
+// variables, and in the Prometheus text format at /metrics. It returns
+// the listener, which stops the server when closed.
+func serveMetrics(a *apple2, addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	expvarMachine.Store(a)
+	expvarOnce.Do(func() {
+		expvar.Publish("apple2go", expvar.Func(func() any {
+			return expvarMachine.Load().Metrics().vars()
+		}))
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		a.Metrics().writePrometheus(w)
+	})
+	go http.Serve(l, mux)
+	return l, nil
+}
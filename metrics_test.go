@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	a := newApple2()
+	now := time.Unix(0, 0)
+	a.metrics.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+
+	// Each frame takes 10 ms of host time, 1 ms for each lap and the end
+	// of the frame, so the machine runs at 100 frames per second.
+	for i := 0; i < 120; i++ {
+		a.RunFrame()
+	}
+	m := a.Metrics()
+	if m.frames != 120 || m.cycles != a.cpu.Cycles {
+		t.Errorf("Expected 120 frames and %d cycles, got %d and %d\n", a.cpu.Cycles, m.frames, m.cycles)
+	}
+	if m.fps != 100 {
+		t.Errorf("Expected 100 fps, got %v\n", m.fps)
+	}
+	if want := 100 * cyclesPerFrame / cpuClockRate; m.ratio < want*0.99 || m.ratio > want*1.01 {
+		t.Errorf("Expected speed ratio %.3f, got %.3f\n", want, m.ratio)
+	}
+	if m.phaseTimes[phaseCPU] != 120*time.Millisecond || m.phaseTimes[phaseInput] != 240*time.Millisecond {
+		t.Errorf("Unexpected phase times %v\n", m.phaseTimes)
+	}
+
+	l, err := serveMetrics(a, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	get := func(path string) string {
+		resp, err := http.Get("http://" + l.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	if body := get("/metrics"); !strings.Contains(body, "apple2go_frames_total 120\n") ||
+		!strings.Contains(body, `apple2go_phase_seconds_total{phase="video"} 0.12`) {
+		t.Errorf("Unexpected Prometheus metrics:\n%s\n", body)
+	}
+	var vars struct {
+		Apple2go struct {
+			Frames uint64 `json:"frames"`
+		} `json:"apple2go"`
+	}
+	if err := json.Unmarshal([]byte(get("/debug/vars")), &vars); err != nil || vars.Apple2go.Frames != 120 {
+		t.Errorf("Unexpected expvar metrics: %v %+v\n", err, vars)
+	}
+}
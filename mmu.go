@@ -1,12 +1,17 @@
 package main
 
-import "io"
+import (
+	"io"
+	"log/slog"
+	"math/bits"
+)
 
 type bankID byte
 
 const (
 	bankSystemCXROM    bankID = iota // $C100..$CFFF (Cx ROM)
 	bankSystemDEFROM                 // $D000..$FFFF (DEF ROM)
+	bankSystemC3ROM                  // $C300..$C3FF (Internal C3 ROM)
 	bankZeroStackRAM                 // $0000..$01FF (ZeroPage+Stack)
 	bankMainRAM                      // $0200..$BFFF (Lower 48K)
 	bankLangCardDX1RAM               // $D000..$DFFF (Dx Bank 1)
@@ -39,6 +44,22 @@ type bank struct {
 	baseAddr uint16 // base virtual address
 	mem      []byte // memory slice assigned to bank
 	accessor bankAccessor
+	direct   access // accesses that may use mem directly, bypassing accessor
+}
+
+// setAccessor sets a bank's accessor, and the accesses that may bypass it:
+// reads of RAM and ROM, whose reads have no side effects, and writes of
+// plain RAM, since ROM ignores writes and video memory records them.
+func (b *bank) setAccessor(a bankAccessor) {
+	b.accessor = a
+	switch a.(type) {
+	case *ramBankAccessor:
+		b.direct = read | write
+	case *romBankAccessor, *displayBankAccessor, *hiResBankAccessor:
+		b.direct = read
+	default:
+		b.direct = 0
+	}
 }
 
 // A bankAccessor handles the reading and writing of bytes in a memory
@@ -52,10 +73,62 @@ type bankAccessor interface {
 }
 
 // Each memory page holds 256 bytes and can be mapped to a bank for reads
-// and a bank for writes.
+// and a bank for writes. Pages of plain RAM or ROM also hold the page's
+// memory, so most accesses bypass the bank's accessor.
 type page struct {
-	read  *bank // memory bank used for this page's reads
-	write *bank // memory bank used for this page's writes
+	read  *bank        // memory bank used for this page's reads
+	write *bank        // memory bank used for this page's writes
+	rmem  *[0x100]byte // memory read directly, or nil to use the read accessor
+	wmem  *[0x100]byte // memory written directly, or nil to use the write accessor
+}
+
+// An auxCardType identifies the card installed in the IIe auxiliary slot.
+type auxCardType byte
+
+const (
+	auxCardNone     auxCardType = iota // no aux card; aux memory reads float
+	auxCard80Col                       // 80-column text card with 1K of aux RAM
+	auxCardExt80Col                    // extended 80-column card with 64K of aux RAM
+	auxCardRamWorks                    // RamWorks III with multiple 64K aux banks
+)
+
+const (
+	defaultRamWorksBanks = 16  // 1MB
+	maxRamWorksBanks     = 128 // 8MB
+)
+
+var auxCardNames = []string{
+	/* auxCardNone     */ "none",
+	/* auxCard80Col    */ "80col",
+	/* auxCardExt80Col */ "ext80col",
+	/* auxCardRamWorks */ "ramworks",
+}
+
+func (t auxCardType) String() string {
+	return auxCardNames[t]
+}
+
+// parseAuxCardType converts an aux card name into an auxCardType.
+func parseAuxCardType(s string) (auxCardType, bool) {
+	for i, name := range auxCardNames {
+		if s == name {
+			return auxCardType(i), true
+		}
+	}
+	return 0, false
+}
+
+// auxRAMBanks lists the banks backed by aux memory.
+var auxRAMBanks = []bankID{
+	bankZeroStackRAM,
+	bankMainRAM,
+	bankDisplayPage1,
+	bankDisplayPage2,
+	bankHiRes1,
+	bankHiRes2,
+	bankLangCardDX1RAM,
+	bankLangCardDX2RAM,
+	bankLangCardEFRAM,
 }
 
 // The access bit mask is used to indicate a type of memory access.
@@ -71,12 +144,25 @@ const (
 type mmu struct {
 	apple2 *apple2
 
-	mainRAM   []byte // entire physical 64K main RAM address space
-	auxRAM    []byte // entire physical 64K aux RAM address space
-	systemROM []byte // Holds 16K of Apple II CD/EF ROMs
+	mainRAM   []byte      // entire physical 64K main RAM address space
+	auxRAM    []byte      // entire physical 64K aux RAM address space of the selected aux bank
+	auxBanks  [][]byte    // all 64K aux banks; bank 0 is the one displayed by the video hardware
+	auxBank   int         // index of the selected aux bank
+	systemROM []byte      // Holds 16K of Apple II CD/EF ROMs from the selected ROM bank
+	romBanks  [][]byte    // all 16K system ROM banks
+	romBank   int         // index of the selected ROM bank
+	auxCard   auxCardType // card installed in the aux slot
 
 	banks [bankTypes][bankIDs]bank // all known memory banks
 	pages [256]page                // virtual 64K address space broken into 256-byte pages
+	stale [4]uint64                // pages whose banks changed during a remap
+	remap int                      // nesting depth of BeginRemap calls
+
+	watch     *watchList // watchpoints checked on each access, or nil if none are set
+	watchList *watchList // watchpoint list, created on first use
+	heat      *heatmap   // access counts, or nil if not counting
+
+	diag *codeDiagnostics // write diagnostics, or nil if not diagnosing
 }
 
 func newMMU(apple2 *apple2) *mmu {
@@ -88,32 +174,26 @@ func newMMU(apple2 *apple2) *mmu {
 func (m *mmu) Init() {
 	m.mainRAM = make([]byte, 64*1024)
 	m.auxRAM = make([]byte, 64*1024)
-	m.systemROM = make([]byte, 16*1024)
+	m.auxBanks = [][]byte{m.auxRAM}
+	m.romBanks = [][]byte{make([]byte, 16*1024)}
 
 	m.addIOBank(bankIOSwitches, 0x0100, 0xc000)
 	m.addIOBank(bankSlotROM, 0x0700, 0xc100)
 	m.addIOBank(bankExpansionROM, 0x800, 0xc800)
 
-	m.addROMBank(bankSystemCXROM, m.systemROM[0x0100:0x1000], 0xc100)
-	m.addROMBank(bankSystemDEFROM, m.systemROM[0x1000:0x4000], 0xd000)
+	m.SelectROMBank(0)
 
 	m.addRAMBank(bankZeroStackRAM, bankTypeMain, m.mainRAM[0x0000:0x0200], 0x0000)
 	m.addRAMBank(bankMainRAM, bankTypeMain, m.mainRAM[0x0200:0xc000], 0x0200)
 	m.addRAMBank(bankDisplayPage1, bankTypeMain, m.mainRAM[0x0400:0x0800], 0x0400)
 	m.addRAMBank(bankDisplayPage2, bankTypeMain, m.mainRAM[0x0800:0x0c00], 0x0800)
 	m.addRAMBank(bankHiRes1, bankTypeMain, m.mainRAM[0x2000:0x4000], 0x2000)
-	m.addRAMBank(bankHiRes2, bankTypeMain, m.mainRAM[0x4000:0x8000], 0x4000)
+	m.addRAMBank(bankHiRes2, bankTypeMain, m.mainRAM[0x4000:0x6000], 0x4000)
 	m.addRAMBank(bankLangCardDX1RAM, bankTypeMain, m.mainRAM[0xc000:0xd000], 0xd000)
 	m.addRAMBank(bankLangCardDX2RAM, bankTypeMain, m.mainRAM[0xd000:0xe000], 0xd000)
 	m.addRAMBank(bankLangCardEFRAM, bankTypeMain, m.mainRAM[0xe000:], 0xe000)
 
-	m.addRAMBank(bankZeroStackRAM, bankTypeAux, m.mainRAM[0x0000:0x0200], 0x0000)
-	m.addRAMBank(bankMainRAM, bankTypeAux, m.auxRAM[0x0200:0xc000], 0x0200)
-	m.addRAMBank(bankDisplayPage1, bankTypeAux, m.auxRAM[0x0400:0x0800], 0x0400)
-	m.addRAMBank(bankHiRes1, bankTypeAux, m.auxRAM[0x2000:0x4000], 0x2000)
-	m.addRAMBank(bankLangCardDX1RAM, bankTypeAux, m.auxRAM[0xc000:0xd000], 0xd000)
-	m.addRAMBank(bankLangCardDX2RAM, bankTypeAux, m.auxRAM[0xd000:0xe000], 0xd000)
-	m.addRAMBank(bankLangCardEFRAM, bankTypeAux, m.auxRAM[0xe000:], 0xe000)
+	m.SetAuxCard(auxCardExt80Col)
 
 	// Activate initial memory banks.
 	m.ActivateBank(bankZeroStackRAM, bankTypeMain, read|write)
@@ -124,21 +204,95 @@ func (m *mmu) Init() {
 	m.ActivateBank(bankIOSwitches, bankTypeMain, read|write)
 }
 
-// LoadSystemROM loads the system ROM memory from a reader.
+// LoadSystemROM loads the system ROM memory from a reader. Models with a
+// 12K ROM have no internal $C100..$CFFF ROM, so their ROM is loaded at
+// $D000. Models with banked ROMs accept images holding one or more
+// banks.
 func (m *mmu) LoadSystemROM(r io.Reader) error {
-	_, err := io.ReadFull(r, m.systemROM)
-	return err
+	size := m.apple2.cfg.romSize
+
+	var banks [][]byte
+	for len(banks) < m.apple2.cfg.romBanks {
+		rom := make([]byte, 16*1024)
+		_, err := io.ReadFull(r, rom[len(rom)-size:])
+		if err == io.EOF && len(banks) > 0 {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		banks = append(banks, rom)
+	}
+
+	m.romBanks = banks
+	m.SelectROMBank(0)
+	return nil
+}
+
+// ROMBanks returns the number of system ROM banks loaded.
+func (m *mmu) ROMBanks() int {
+	return len(m.romBanks)
+}
+
+// SelectROMBank maps one of the system ROM banks into $C100..$FFFF.
+func (m *mmu) SelectROMBank(n int) {
+	m.romBank = n
+	m.systemROM = m.romBanks[n]
+
+	m.addROMBank(bankSystemCXROM, m.systemROM[0x0100:0x1000], 0xc100)
+	m.addROMBank(bankSystemDEFROM, m.systemROM[0x1000:0x4000], 0xd000)
+	m.addROMBank(bankSystemC3ROM, m.systemROM[0x0300:0x0400], 0xc300)
+	m.banks[bankTypeMain][bankSystemC3ROM].setAccessor(&internalC3ROMBankAccessor{
+		romBankAccessor: romBankAccessor{mem: m.systemROM[0x0300:0x0400]},
+		sm:              m.apple2.sm,
+	})
+	m.updatePages()
+	m.apple2.log.Logger(logMMU).Debug("system ROM bank selected", "bank", n)
 }
 
 // LoadByte loads a byte from the provided address.
 func (m *mmu) LoadByte(addr uint16) byte {
-	b := m.pages[addr>>8].read
+	p := &m.pages[addr>>8]
+	if p.rmem != nil && m.watch == nil {
+		return p.rmem[addr&0xff]
+	}
+
+	b := p.read
 	if b == nil {
 		return 0
 	}
 
 	paddr := addr - b.baseAddr
-	return b.accessor.LoadByte(paddr)
+	v := b.accessor.LoadByte(paddr)
+	if m.heat != nil {
+		m.heat.reads[addr]++
+	}
+	if m.watch != nil {
+		m.watch.check(addr, watchRead, v)
+	}
+	return v
+}
+
+// Peek reads memory without triggering soft switches or watchpoints, or
+// counting the access in the heatmap. It returns 0 for addresses in the
+// I/O page.
+func (m *mmu) Peek(addr uint16) byte {
+	if addr >= 0xc000 && addr < 0xc100 {
+		return 0
+	}
+	expansion, intC8ROM := m.apple2.sm.expansion, m.apple2.sm.intC8ROM
+	w, h := m.watch, m.heat
+	m.watch, m.heat = nil, nil
+	v := m.LoadByte(addr)
+	m.watch, m.heat = w, h
+
+	// Reads of $C100..$CFFF select expansion ROMs, so restore the
+	// selection.
+	if addr >= 0xc100 && addr < 0xd000 {
+		sm := m.apple2.sm
+		sm.expansion, sm.intC8ROM = expansion, intC8ROM
+	}
+	return v
 }
 
 // LoadBytes loads a group of bytes from the provided address into the
@@ -164,18 +318,41 @@ func (m *mmu) LoadAddress(addr uint16) uint16 {
 	} else {
 		hi = b.accessor.LoadByte(paddr + 1)
 	}
+	if m.heat != nil {
+		m.heat.reads[addr]++
+		m.heat.reads[addr&0xff00|(addr+1)&0xff]++
+	}
+	if m.watch != nil {
+		m.watch.check(addr, watchRead, lo)
+		m.watch.check(addr&0xff00|(addr+1)&0xff, watchRead, hi)
+	}
 	return uint16(lo) | uint16(hi)<<8
 }
 
 // StoreByte stores a single byte to the provided address.
 func (m *mmu) StoreByte(addr uint16, v byte) {
-	b := m.pages[addr>>8].write
+	p := &m.pages[addr>>8]
+	if p.wmem != nil && m.watch == nil {
+		p.wmem[addr&0xff] = v
+		return
+	}
+
+	b := p.write
 	if b == nil {
 		return
 	}
 
 	paddr := addr - b.baseAddr
 	b.accessor.StoreByte(paddr, v)
+	if m.heat != nil {
+		m.heat.writes[addr]++
+	}
+	if m.diag != nil {
+		m.diag.write(addr, v, isROMAccessor(b.accessor))
+	}
+	if m.watch != nil {
+		m.watch.check(addr, watchWrite, v)
+	}
 }
 
 // StoreByte stores a group of bytes to the provided address.
@@ -200,6 +377,89 @@ func (m *mmu) StoreAddress(addr, v uint16) {
 	} else {
 		b.accessor.StoreByte(paddr+1, byte(v>>8))
 	}
+	if m.heat != nil {
+		m.heat.writes[addr]++
+		m.heat.writes[addr&0xff00|(addr+1)&0xff]++
+	}
+	if m.watch != nil {
+		m.watch.check(addr, watchWrite, byte(v))
+		m.watch.check(addr&0xff00|(addr+1)&0xff, watchWrite, byte(v>>8))
+	}
+}
+
+// SetAuxCard selects the card installed in the aux slot, which determines
+// how much aux memory is present. A RamWorks card is installed with its
+// default number of banks; use SetRamWorksBanks to change it.
+func (m *mmu) SetAuxCard(t auxCardType) {
+	m.auxCard = t
+	if t == auxCardRamWorks {
+		m.SetRamWorksBanks(defaultRamWorksBanks)
+		return
+	}
+	m.auxBanks = m.auxBanks[:1]
+	m.SelectAuxBank(0)
+}
+
+// SetRamWorksBanks sets the number of 64K aux banks installed on a RamWorks
+// card. The contents of bank 0 are preserved.
+func (m *mmu) SetRamWorksBanks(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if n > maxRamWorksBanks {
+		n = maxRamWorksBanks
+	}
+
+	banks := make([][]byte, n)
+	copy(banks, m.auxBanks)
+	for i := range banks {
+		if banks[i] == nil {
+			banks[i] = make([]byte, 64*1024)
+		}
+	}
+	m.auxBanks = banks
+	m.SelectAuxBank(0)
+}
+
+// SelectAuxBank makes one of the 64K aux banks accessible through the aux
+// memory address space. Bank numbers beyond the installed memory wrap
+// around, which is how software detects the amount of memory installed.
+func (m *mmu) SelectAuxBank(n int) {
+	m.auxBank = n % len(m.auxBanks)
+	m.auxRAM = m.auxBanks[m.auxBank]
+
+	// Re-point the aux banks at the selected memory. Pages keep pointers
+	// to the bank structures, so current mappings remain valid.
+	m.addRAMBank(bankZeroStackRAM, bankTypeAux, m.auxRAM[0x0000:0x0200], 0x0000)
+	m.addRAMBank(bankMainRAM, bankTypeAux, m.auxRAM[0x0200:0xc000], 0x0200)
+	m.addRAMBank(bankDisplayPage1, bankTypeAux, m.auxRAM[0x0400:0x0800], 0x0400)
+	m.addRAMBank(bankDisplayPage2, bankTypeAux, m.auxRAM[0x0800:0x0c00], 0x0800)
+	m.addRAMBank(bankHiRes1, bankTypeAux, m.auxRAM[0x2000:0x4000], 0x2000)
+	m.addRAMBank(bankHiRes2, bankTypeAux, m.auxRAM[0x4000:0x6000], 0x4000)
+	m.addRAMBank(bankLangCardDX1RAM, bankTypeAux, m.auxRAM[0xc000:0xd000], 0xd000)
+	m.addRAMBank(bankLangCardDX2RAM, bankTypeAux, m.auxRAM[0xd000:0xe000], 0xd000)
+	m.addRAMBank(bankLangCardEFRAM, bankTypeAux, m.auxRAM[0xe000:], 0xe000)
+
+	if m.auxCard == auxCardNone || m.auxCard == auxCard80Col {
+		for _, id := range auxRAMBanks {
+			b := &m.banks[bankTypeAux][id]
+
+			// The bank's memory is a slice of auxRAM, so its capacity
+			// reveals its starting offset within aux memory.
+			offset := uint16(len(m.auxRAM) - cap(b.mem))
+			b.setAccessor(&auxBankAccessor{mmu: m, offset: offset})
+		}
+	}
+	m.updatePages()
+	if log := m.apple2.log; log.Enabled(logMMU, slog.LevelDebug) {
+		log.Logger(logMMU).Debug("aux bank selected", "bank", m.auxBank)
+	}
+}
+
+// AuxVideoRAM returns the aux memory read by the video hardware, which is
+// always bank 0 regardless of the selected aux bank.
+func (m *mmu) AuxVideoRAM() []byte {
+	return m.auxBanks[0]
 }
 
 // GetBank returns a pointer to the requested memory bank.
@@ -208,16 +468,22 @@ func (m *mmu) GetBank(id bankID, typ bankType) *bank {
 }
 
 // GetBankAccess returns the current access (read and/or write) allowed to the
-// requested bank.
+// requested bank. Access is reported only if it applies to every page of
+// the bank, since other banks may overlay part of its address range.
 func (m *mmu) GetBankAccess(id bankID, typ bankType) access {
 	b := &m.banks[typ][id]
-	p := m.pages[b.baseAddr>>8]
-	var a access
-	if p.read == b {
-		a |= read
-	}
-	if p.write == b {
-		a |= write
+	p0 := b.baseAddr >> 8
+	pn := p0 + b.size>>8
+
+	a := read | write
+	for p := p0; p < pn && a != 0; p++ {
+		page := &m.pages[p]
+		if page.read != b {
+			a &^= read
+		}
+		if page.write != b {
+			a &^= write
+		}
 	}
 	return a
 }
@@ -227,35 +493,34 @@ func (m *mmu) GetBankAccess(id bankID, typ bankType) access {
 // by the bank's accessor. Read and write access may be activated
 // independently.
 func (m *mmu) ActivateBank(id bankID, typ bankType, access access) {
-	if m.GetBankAccess(id, typ) == access {
-		return
-	}
-
 	enableReads := (access & read) != 0
 	enableWrites := (access & write) != 0
 
 	b := &m.banks[typ][id]
 	p0 := b.baseAddr >> 8
 	pn := p0 + b.size>>8
+
+	// Only pages whose banks change are updated.
 	for p := p0; p < pn; p++ {
 		page := &m.pages[p]
-		if enableReads {
+		if enableReads && page.read != b {
 			page.read = b
+			m.stale[p>>6] |= 1 << (p & 63)
 		}
-		if enableWrites {
+		if enableWrites && page.write != b {
 			page.write = b
+			m.stale[p>>6] |= 1 << (p & 63)
 		}
 	}
+	if m.remap == 0 {
+		m.updateStalePages()
+	}
 }
 
 // DeactivateBank deactivates all the pages within a bank's range of virtual
 // addresses so that accesses to addresses within that range are no longer
 // handled by the bank. Read and write access may be deactivated independently.
 func (m *mmu) DeactivateBank(id bankID, typ bankType, access access) {
-	if m.GetBankAccess(id, typ) == ^access {
-		return
-	}
-
 	disableReads := (access & read) != 0
 	disableWrites := (access & write) != 0
 
@@ -266,23 +531,117 @@ func (m *mmu) DeactivateBank(id bankID, typ bankType, access access) {
 		page := &m.pages[p]
 		if disableReads && page.read == b {
 			page.read = nil
+			m.stale[p>>6] |= 1 << (p & 63)
 		}
 		if disableWrites && page.write == b {
 			page.write = nil
+			m.stale[p>>6] |= 1 << (p & 63)
 		}
 	}
+	if m.remap == 0 {
+		m.updateStalePages()
+	}
+}
+
+// BeginRemap defers the updates of pages whose banks change until the
+// matching EndRemap, so that a page remapped more than once, as happens
+// when the soft switches reapply the memory map, is updated once.
+func (m *mmu) BeginRemap() {
+	m.remap++
+}
+
+// EndRemap ends a remap begun by BeginRemap, updating the pages whose
+// banks changed.
+func (m *mmu) EndRemap() {
+	if m.remap--; m.remap == 0 {
+		m.updateStalePages()
+	}
+}
+
+// updateStalePages updates the pages whose banks changed.
+func (m *mmu) updateStalePages() {
+	for i, w := range m.stale {
+		for w != 0 {
+			n := bits.TrailingZeros64(w)
+			w &^= 1 << n
+			m.updatePage(i<<6 | n)
+		}
+		m.stale[i] = 0
+	}
+}
+
+// updatePages updates the direct memory of every page. It is called after
+// banks are replaced or their accessors change.
+func (m *mmu) updatePages() {
+	for p := range m.pages {
+		m.updatePage(p)
+	}
+}
+
+// updatePage updates the direct memory of a page from the banks mapped to
+// it, as each bank's direct accesses allow. Nothing is direct while the
+// heatmap counts accesses, and writes aren't while diagnostics check them.
+func (m *mmu) updatePage(p int) {
+	page := &m.pages[p]
+	page.rmem, page.wmem = nil, nil
+	if m.heat != nil {
+		return
+	}
+	if b := page.read; b != nil && b.direct&read != 0 {
+		page.rmem = b.pageMem(p)
+	}
+	if b := page.write; b != nil && b.direct&write != 0 && m.diag == nil {
+		page.wmem = b.pageMem(p)
+	}
+}
+
+// isROMAccessor returns true if an accessor is for system ROM, which
+// ignores writes.
+func isROMAccessor(a bankAccessor) bool {
+	switch a.(type) {
+	case *romBankAccessor, *internalC3ROMBankAccessor:
+		return true
+	}
+	return false
+}
+
+// pageMem returns the 256 bytes of a bank's memory mapped at a page.
+func (b *bank) pageMem(p int) *[0x100]byte {
+	return (*[0x100]byte)(b.mem[p<<8-int(b.baseAddr):])
 }
 
 // addRAMBank is a helper function that initializes a RAM memory bank and
 // creates an accessor for it.
 func (m *mmu) addRAMBank(id bankID, typ bankType, mem []byte, baseAddr uint16) {
-	m.banks[typ][id] = bank{
+	b := bank{
 		id:       id,
 		size:     uint16(len(mem)),
 		baseAddr: baseAddr,
 		mem:      mem,
-		accessor: &ramBankAccessor{mem: mem},
 	}
+	b.setAccessor(m.ramBankAccessor(id, typ, mem))
+	m.banks[typ][id] = b
+}
+
+// ramBankAccessor returns an accessor for a RAM bank. The video pages of
+// main memory and aux bank 0, which the video hardware displays, get
+// accessors that record writes so the display can redraw only what
+// changed.
+func (m *mmu) ramBankAccessor(id bankID, typ bankType, mem []byte) bankAccessor {
+	if typ == bankTypeMain || m.auxBank == 0 {
+		dirty := &m.apple2.ds.dirty
+		switch id {
+		case bankDisplayPage1:
+			return &displayBankAccessor{mem: mem, dirty: dirty, page: 0}
+		case bankDisplayPage2:
+			return &displayBankAccessor{mem: mem, dirty: dirty, page: 1}
+		case bankHiRes1:
+			return &hiResBankAccessor{mem: mem, dirty: dirty, page: 0}
+		case bankHiRes2:
+			return &hiResBankAccessor{mem: mem, dirty: dirty, page: 1}
+		}
+	}
+	return &ramBankAccessor{mem: mem}
 }
 
 // addROMBank is a helper function that initializes a ROM memory bank and
@@ -293,8 +652,8 @@ func (m *mmu) addROMBank(id bankID, mem []byte, baseAddr uint16) {
 		size:     uint16(len(mem)),
 		baseAddr: baseAddr,
 		mem:      mem,
-		accessor: &romBankAccessor{mem: mem},
 	}
+	b.setAccessor(&romBankAccessor{mem: mem})
 	m.banks[bankTypeMain][id] = b
 	m.banks[bankTypeAux][id] = b
 }
@@ -344,3 +703,33 @@ func (a *romBankAccessor) StoreByte(addr uint16, v byte) {
 func (a *romBankAccessor) CopyBytes(b []byte) {
 	copy(a.mem, b)
 }
+
+// auxBankAccessor handles accesses to aux memory banks when less than 64K
+// of aux memory is installed. With no aux card, nothing drives the data bus
+// and reads float. The 1K 80-column card decodes only the low 10 address
+// bits, so its memory repeats throughout the aux address space.
+type auxBankAccessor struct {
+	mmu    *mmu
+	offset uint16 // aux address corresponding to the start of the bank
+}
+
+func (a *auxBankAccessor) LoadByte(addr uint16) byte {
+	if a.mmu.auxCard == auxCardNone {
+		return a.mmu.apple2.vs.FloatingBus()
+	}
+	return a.mmu.auxRAM[0x400|(a.offset+addr)&0x3ff]
+}
+
+func (a *auxBankAccessor) StoreByte(addr uint16, v byte) {
+	if a.mmu.auxCard == auxCardNone {
+		return
+	}
+	a.mmu.auxRAM[0x400|(a.offset+addr)&0x3ff] = v
+
+	// The card's memory is the aux half of text page 1.
+	a.mmu.apple2.ds.dirty.markText(0, (a.offset+addr)&0x3ff)
+}
+
+func (a *auxBankAccessor) CopyBytes(b []byte) {
+	// Do nothing
+}
@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestWriteC00xSwitches(t *testing.T) {
 	a := newApple2()
@@ -35,6 +40,27 @@ func TestWriteC00xSwitches(t *testing.T) {
 	}
 }
 
+func TestWriteToggleSwitches(t *testing.T) {
+	a := newApple2()
+	f, err := os.Create(filepath.Join(t.TempDir(), "cassette.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := a.cas.StartRecording(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// A store toggles the speaker and cassette output once, as a load does.
+	for _, addr := range []uint16{0xc030, 0xc020, 0xc02f} {
+		sp, cas := len(a.sp.toggles), len(a.cas.toggles)
+		a.mmu.StoreByte(addr, 0)
+		if n := len(a.sp.toggles) - sp + len(a.cas.toggles) - cas; n != 1 {
+			t.Errorf("Wrote %04x, expected 1 toggle, got %d\n", addr, n)
+		}
+	}
+}
+
 func TestReadC08xSwitches(t *testing.T) {
 	a := newApple2()
 
@@ -65,8 +91,11 @@ func TestReadC08xSwitches(t *testing.T) {
 		{0xc08f, true, false, read | write, read | write, 0, 0},
 	}
 
+	// Odd switches are read twice, since writing is enabled only by the
+	// second of two consecutive reads.
 	for _, c := range cases {
 		a.mmu.LoadByte(c.setAddr)
+		a.mmu.LoadByte(c.setAddr)
 
 		rdlcram := (a.iou.getSoftSwitchBit7(ioSwitchLCRAMRD) & 0x80) != 0
 		rdbnk2 := (a.iou.getSoftSwitchBit7(ioSwitchLCBANK2) & 0x80) != 0
@@ -96,3 +125,547 @@ func TestReadC08xSwitches(t *testing.T) {
 		}
 	}
 }
+
+func TestLanguageCardPreWrite(t *testing.T) {
+	a := newApple2()
+	writable := func() bool {
+		return a.mmu.GetBankAccess(bankLangCardEFRAM, bankTypeMain)&write != 0
+	}
+
+	// A single read of an odd switch doesn't enable writing.
+	a.mmu.LoadByte(0xc082)
+	a.mmu.LoadByte(0xc081)
+	if writable() {
+		t.Errorf("Expected writing disabled after one read\n")
+	}
+
+	// A second consecutive read does, even of a different odd switch.
+	a.mmu.LoadByte(0xc083)
+	if !writable() {
+		t.Errorf("Expected writing enabled after two reads\n")
+	}
+
+	// Further odd reads and writes leave writing enabled.
+	a.mmu.LoadByte(0xc08b)
+	a.mmu.StoreByte(0xc08b, 0)
+	if !writable() || !a.iou.testSoftSwitch(ioSwitchLCRAMRD) || a.iou.testSoftSwitch(ioSwitchLCBANK2) {
+		t.Errorf("Expected bank 1 read and write enabled\n")
+	}
+
+	// An even switch disables writing and resets the pre-write latch.
+	a.mmu.LoadByte(0xc081)
+	a.mmu.LoadByte(0xc080)
+	a.mmu.LoadByte(0xc081)
+	if writable() {
+		t.Errorf("Expected writing disabled after an even switch\n")
+	}
+
+	// A write between two reads resets the pre-write latch.
+	a.mmu.LoadByte(0xc082)
+	a.mmu.LoadByte(0xc081)
+	a.mmu.StoreByte(0xc081, 0)
+	a.mmu.LoadByte(0xc081)
+	if writable() {
+		t.Errorf("Expected a write to reset the pre-write latch\n")
+	}
+	a.mmu.LoadByte(0xc081)
+	if !writable() {
+		t.Errorf("Expected writing enabled after two more reads\n")
+	}
+}
+
+// TestC08xAccessSequences checks the language card switches against the
+// bank switching tables of Understanding the Apple IIe, for sequences of
+// reads (R) and writes (W).
+func TestC08xAccessSequences(t *testing.T) {
+	type access struct {
+		write bool
+		addr  uint16
+	}
+	R := func(addr uint16) access { return access{false, addr} }
+	W := func(addr uint16) access { return access{true, addr} }
+
+	cases := []struct {
+		name     string
+		accesses []access
+		readRAM  bool
+		writeRAM bool
+		bank2    bool
+	}{
+		{"R C080", []access{R(0xc080)}, true, false, true},
+		{"RR C081", []access{R(0xc081), R(0xc081)}, false, true, true},
+		{"R C081", []access{R(0xc081)}, false, false, true},
+		{"RR C082", []access{R(0xc082), R(0xc082)}, false, false, true},
+		{"RR C083", []access{R(0xc083), R(0xc083)}, true, true, true},
+		{"R C088", []access{R(0xc088)}, true, false, false},
+		{"RR C089", []access{R(0xc089), R(0xc089)}, false, true, false},
+		{"RR C08A", []access{R(0xc08a), R(0xc08a)}, false, false, false},
+		{"RR C08B", []access{R(0xc08b), R(0xc08b)}, true, true, false},
+		{"R C081 R C089", []access{R(0xc081), R(0xc089)}, false, true, false},
+		{"R C081 R C000 R C081", []access{R(0xc081), R(0xc000), R(0xc081)}, false, true, true},
+		{"WW C081", []access{W(0xc081), W(0xc081)}, false, false, true},
+		{"WW C08B", []access{W(0xc08b), W(0xc08b)}, true, false, false},
+		{"R C081 W C081 R C081", []access{R(0xc081), W(0xc081), R(0xc081)}, false, false, true},
+		{"W C081 RR C081", []access{W(0xc081), R(0xc081), R(0xc081)}, false, true, true},
+		{"RR C083 W C080", []access{R(0xc083), R(0xc083), W(0xc080)}, true, false, true},
+		{"RR C08B W C083", []access{R(0xc08b), R(0xc08b), W(0xc083)}, true, true, true},
+		{"RR C083 R C082", []access{R(0xc083), R(0xc083), R(0xc082)}, false, false, true},
+	}
+
+	for _, c := range cases {
+		a := newApple2()
+		for _, acc := range c.accesses {
+			if acc.write {
+				a.mmu.StoreByte(acc.addr, 0)
+			} else {
+				a.mmu.LoadByte(acc.addr)
+			}
+		}
+
+		readRAM := a.iou.testSoftSwitch(ioSwitchLCRAMRD)
+		writeRAM := a.iou.testSoftSwitch(ioSwitchLCRAMWRT)
+		bank2 := a.iou.testSoftSwitch(ioSwitchLCBANK2)
+		if readRAM != c.readRAM || writeRAM != c.writeRAM || bank2 != c.bank2 {
+			t.Errorf("%s: expected read RAM %v, write RAM %v, bank 2 %v; got %v, %v, %v\n",
+				c.name, c.readRAM, c.writeRAM, c.bank2, readRAM, writeRAM, bank2)
+		}
+	}
+}
+
+func TestAuxCard(t *testing.T) {
+	a := newApple2()
+
+	cases := []struct {
+		card     auxCardType
+		addr     uint16
+		mirror   uint16
+		present  bool
+		mirrored bool
+	}{
+		{auxCardExt80Col, 0x0400, 0x0800, true, false},
+		{auxCardExt80Col, 0x2000, 0x2400, true, false},
+		{auxCard80Col, 0x0400, 0x0800, true, true},
+		{auxCard80Col, 0x2000, 0x2400, true, true},
+		{auxCardNone, 0x0400, 0x0800, false, false},
+	}
+
+	for _, c := range cases {
+		a.mmu.SetAuxCard(c.card)
+		for i := range a.mmu.auxRAM {
+			a.mmu.auxRAM[i] = 0
+		}
+
+		// Write to aux memory and read it back.
+		a.mmu.StoreByte(0xc005, 0)
+		a.mmu.StoreByte(c.addr, 0x5a)
+		a.mmu.StoreByte(0xc004, 0)
+
+		a.mmu.StoreByte(0xc003, 0)
+		a.cpu.Cycles = 0
+		present := a.mmu.LoadByte(c.addr) == 0x5a
+		mirrored := a.mmu.LoadByte(c.mirror) == 0x5a
+		a.mmu.StoreByte(0xc002, 0)
+
+		if present != c.present {
+			t.Errorf("Card %v addr %04x: expected present %v, got %v\n", c.card, c.addr, c.present, present)
+		}
+		if mirrored != c.mirrored {
+			t.Errorf("Card %v addr %04x: expected mirrored %v, got %v\n", c.card, c.addr, c.mirrored, mirrored)
+		}
+	}
+}
+
+func TestRamWorksBanks(t *testing.T) {
+	a := newApple2()
+	a.mmu.SetAuxCard(auxCardRamWorks)
+	a.mmu.SetRamWorksBanks(4)
+
+	// Write the bank number to $1000 of each aux bank, selecting banks
+	// beyond the installed memory to verify that they wrap.
+	a.mmu.StoreByte(0xc005, 0)
+	for bank := 0; bank < 8; bank++ {
+		a.mmu.StoreByte(0xc073, byte(bank))
+		a.mmu.StoreByte(0x1000, byte(bank))
+	}
+	a.mmu.StoreByte(0xc004, 0)
+
+	a.mmu.StoreByte(0xc003, 0)
+	for bank := 0; bank < 4; bank++ {
+		a.mmu.StoreByte(0xc073, byte(bank))
+		if v := a.mmu.LoadByte(0x1000); v != byte(bank+4) {
+			t.Errorf("Bank %d: expected %02x, got %02x\n", bank, bank+4, v)
+		}
+	}
+	a.mmu.StoreByte(0xc002, 0)
+
+	if v := a.mmu.AuxVideoRAM()[0x1000]; v != 4 {
+		t.Errorf("Expected video to read bank 0, got %02x\n", v)
+	}
+	if v := a.mmu.LoadByte(0x1000); v != 0 {
+		t.Errorf("Expected main memory unaffected, got %02x\n", v)
+	}
+}
+
+// romCard is a card whose I/O and expansion ROMs read as fixed values.
+type romCard struct {
+	id        byte
+	stored    byte
+	romWrites int
+}
+
+func (c *romCard) LoadIO(addr uint16) byte               { return 0 }
+func (c *romCard) StoreIO(addr uint16, v byte)           {}
+func (c *romCard) LoadROM(addr uint16) byte              { return c.id }
+func (c *romCard) StoreROM(addr uint16, v byte)          { c.romWrites++ }
+func (c *romCard) LoadExpansionROM(addr uint16) byte     { return c.id | 0x80 }
+func (c *romCard) StoreExpansionROM(addr uint16, v byte) { c.stored = v }
+
+func TestExpansionROM(t *testing.T) {
+	a := newApple2()
+	c1, c2 := &romCard{id: 1}, &romCard{id: 2}
+	a.sm.Insert(1, c1)
+	a.sm.Insert(2, c2)
+	a.sm.Insert(4, newDiskII(a, nil))
+	a.mmu.systemROM[0x0800] = 0x5a
+
+	steps := []struct {
+		addr uint16 // address accessed before reading $C800
+		c800 byte   // expected value at $C800, or 0 if floating
+		slot int    // expected selection
+	}{
+		{0xc100, 0x81, 1},
+		{0xc250, 0x82, 2},
+		{0xcfff, 0, 0},
+		{0xc400, 0, 4},     // card without an expansion ROM
+		{0xc300, 0x5a, -1}, // internal C3 ROM selects internal C8 ROM
+		{0xc100, 0x5a, -1}, // until $CFFF is accessed
+		{0xcfff, 0, 0},
+		{0xc100, 0x81, 1},
+	}
+	for i, s := range steps {
+		a.mmu.LoadByte(s.addr)
+		if sel := a.sm.ExpansionROM(); sel != s.slot {
+			t.Errorf("Step %d: expected slot %d selected, got %d\n", i, s.slot, sel)
+		}
+		if s.c800 != 0 {
+			if v := a.mmu.LoadByte(0xc800); v != s.c800 {
+				t.Errorf("Step %d: expected $C800 = %02X, got %02X\n", i, s.c800, v)
+			}
+		}
+	}
+
+	a.mmu.StoreByte(0xc900, 0x33)
+	if c1.stored != 0x33 {
+		t.Errorf("Write to expansion ROM not passed to the selected card\n")
+	}
+	a.mmu.Peek(0xcfff)
+	if sel := a.sm.ExpansionROM(); sel != 1 {
+		t.Errorf("Peek changed the expansion ROM selection to %d\n", sel)
+	}
+
+	// INTCXROM maps the internal ROM regardless of the selection.
+	a.mmu.StoreByte(0xc007, 0)
+	if v := a.mmu.LoadByte(0xc800); v != 0x5a {
+		t.Errorf("Expected internal ROM with INTCXROM, got %02X\n", v)
+	}
+	a.mmu.StoreByte(0xc006, 0)
+	if v := a.mmu.LoadByte(0xc800); v != 0x81 {
+		t.Errorf("Expected slot 1 expansion ROM after INTCXROM off, got %02X\n", v)
+	}
+}
+
+func TestSlotC3ROM(t *testing.T) {
+	a := newApple2()
+	c1, c3 := &romCard{id: 1}, &romCard{id: 3}
+	a.sm.Insert(1, c1)
+	a.sm.Insert(3, c3)
+	a.mmu.systemROM[0x0100] = 0xc1
+	a.mmu.systemROM[0x0300] = 0xc3
+	a.mmu.systemROM[0x0800] = 0x5a
+
+	cases := []struct {
+		cxrom, c3rom bool
+		c100, c300   byte
+		c800         byte // $C800 after reading $C300, or 0 if floating
+		c3writes     int  // writes to $C300 seen by the slot 3 card
+	}{
+		{false, false, 0x01, 0xc3, 0x5a, 0},
+		{false, true, 0x01, 0x03, 0x83, 1},
+		{true, false, 0xc1, 0xc3, 0x5a, 0},
+		{true, true, 0xc1, 0xc3, 0, 0},
+	}
+	for _, c := range cases {
+		a.mmu.LoadByte(0xcfff)
+		c3.romWrites = 0
+		a.mmu.StoreByte(0xc006+boolAddr(c.cxrom), 0)
+		a.mmu.StoreByte(0xc00a+boolAddr(c.c3rom), 0)
+
+		if v := a.mmu.LoadByte(0xc015)&0x80 != 0; v != c.cxrom {
+			t.Errorf("CXROM=%v C3ROM=%v: $C015 reports %v\n", c.cxrom, c.c3rom, v)
+		}
+		if v := a.mmu.LoadByte(0xc017)&0x80 != 0; v != c.c3rom {
+			t.Errorf("CXROM=%v C3ROM=%v: $C017 reports %v\n", c.cxrom, c.c3rom, v)
+		}
+		if v := a.mmu.LoadByte(0xc100); v != c.c100 {
+			t.Errorf("CXROM=%v C3ROM=%v: $C100 = %02X, expected %02X\n", c.cxrom, c.c3rom, v, c.c100)
+		}
+		if v := a.mmu.LoadByte(0xc300); v != c.c300 {
+			t.Errorf("CXROM=%v C3ROM=%v: $C300 = %02X, expected %02X\n", c.cxrom, c.c3rom, v, c.c300)
+		}
+		a.mmu.StoreByte(0xc300, 0)
+		if c3.romWrites != c.c3writes {
+			t.Errorf("CXROM=%v C3ROM=%v: slot 3 card saw %d writes, expected %d\n", c.cxrom, c.c3rom, c3.romWrites, c.c3writes)
+		}
+
+		// INTC8ROM stays set after INTCXROM is turned off.
+		a.mmu.StoreByte(0xc006, 0)
+		if v := a.mmu.LoadByte(0xc800); c.c800 != 0 && v != c.c800 {
+			t.Errorf("CXROM=%v C3ROM=%v: $C800 = %02X, expected %02X\n", c.cxrom, c.c3rom, v, c.c800)
+		}
+	}
+}
+
+func boolAddr(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// disableDirectPages makes every access go through the bank accessors, as
+// before pages held their memory directly.
+func disableDirectPages(m *mmu) {
+	for i := range m.pages {
+		m.pages[i].rmem, m.pages[i].wmem = nil, nil
+	}
+}
+
+func TestDirectPages(t *testing.T) {
+	a := newApple2()
+	m := a.mmu
+
+	// Plain RAM is direct, video memory only for reads, ROM only for
+	// reads, and I/O not at all.
+	cases := []struct {
+		addr             uint16
+		rdirect, wdirect bool
+	}{
+		{0x0300, true, true},
+		{0x0400, true, false},
+		{0xd000, true, false},
+		{0xc000, false, false},
+	}
+	for _, c := range cases {
+		p := &m.pages[c.addr>>8]
+		if (p.rmem != nil) != c.rdirect || (p.wmem != nil) != c.wdirect {
+			t.Errorf("$%04X: expected direct reads %v, writes %v\n", c.addr, c.rdirect, c.wdirect)
+		}
+	}
+
+	// Direct pages follow bank switching.
+	m.StoreByte(0xc005, 0) // RAMWRT: aux
+	m.StoreByte(0x0300, 0x55)
+	m.StoreByte(0xc004, 0) // RAMWRT: main
+	if m.LoadByte(0x0300) == 0x55 || m.auxRAM[0x300] != 0x55 {
+		t.Errorf("Expected the write to go to aux memory\n")
+	}
+	m.SetAuxCard(auxCardRamWorks)
+	m.SelectAuxBank(1)
+	m.StoreByte(0xc003, 0) // RAMRD: aux
+	if m.LoadByte(0x0300) == 0x55 {
+		t.Errorf("Expected reads from aux bank 1\n")
+	}
+	m.SelectAuxBank(0)
+	if m.LoadByte(0x0300) != 0x55 {
+		t.Errorf("Expected reads from aux bank 0\n")
+	}
+	m.StoreByte(0xc002, 0) // RAMRD: main
+}
+
+// benchmarkProgram copies a page of memory in a loop, so that the CPU
+// spends its time fetching instructions and accessing RAM.
+var benchmarkProgram = []byte{
+	0xa2, 0x00, // LDX #$00
+	0xbd, 0x00, 0x20, // LDA $2000,X
+	0x8d, 0x00, 0x60, // STA $6000
+	0xe8,       // INX
+	0xd0, 0xf7, // BNE $0302
+	0x4c, 0x00, 0x03, // JMP $0300
+}
+
+// benchmarkIOProgram flips the display page and polls the keyboard in a
+// loop, as games do, so that the CPU spends its time on soft switches.
+var benchmarkIOProgram = []byte{
+	0xad, 0x54, 0xc0, // LDA $C054
+	0xad, 0x57, 0xc0, // LDA $C057
+	0xad, 0x55, 0xc0, // LDA $C055
+	0xad, 0x56, 0xc0, // LDA $C056
+	0xad, 0x00, 0xc0, // LDA $C000
+	0x4c, 0x00, 0x03, // JMP $0300
+}
+
+func BenchmarkMMU(b *testing.B) {
+	for _, direct := range []bool{true, false} {
+		name := "direct"
+		if !direct {
+			name = "accessor"
+		}
+		b.Run(name, func(b *testing.B) {
+			a := newApple2()
+			if !direct {
+				disableDirectPages(a.mmu)
+			}
+			m := a.mmu
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				addr := uint16(0x0200 + i&0x7fff)
+				m.StoreByte(addr, m.LoadByte(addr^0x4000))
+			}
+		})
+	}
+}
+
+// benchmarkSwitches are the sets of soft switches BenchmarkSoftSwitch
+// accesses in turn: the keyboard and status flags, which change nothing;
+// the video switches, whose PAGE2 and HIRES reapply the display pages'
+// mapping; a mix of the two; and RAMRD and RAMWRT, which remap the lower
+// 48K.
+var benchmarkSwitches = []struct {
+	name  string
+	write bool
+	addrs []uint16
+}{
+	{"status", false, []uint16{0xc000, 0xc010, 0xc013, 0xc018, 0xc01a, 0xc01f, 0xc061, 0xc064, 0xc070}},
+	{"video", false, []uint16{0xc050, 0xc051, 0xc052, 0xc053, 0xc054, 0xc055, 0xc056, 0xc057}},
+	{"mixed", false, []uint16{
+		0xc000, 0xc010, 0xc013, 0xc018, 0xc01a, 0xc01f,
+		0xc050, 0xc051, 0xc052, 0xc053, 0xc054, 0xc055, 0xc056, 0xc057,
+		0xc061, 0xc064, 0xc070,
+	}},
+	{"memmap", true, []uint16{0xc002, 0xc003, 0xc004, 0xc005}},
+}
+
+// BenchmarkSoftSwitch accesses the I/O page, dispatching each access to
+// the soft switch or device it addresses.
+func BenchmarkSoftSwitch(b *testing.B) {
+	for _, c := range benchmarkSwitches {
+		b.Run(c.name, func(b *testing.B) {
+			a := newApple2()
+			m := a.mmu
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if addr := c.addrs[i%len(c.addrs)]; c.write {
+					m.StoreByte(addr, 0)
+				} else {
+					m.LoadByte(addr)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRunFrame runs video fields of a memory-bound program, and of
+// one bound by soft switches. The MHz metric is the emulated clock rate in
+// warp mode, and load is the share of the host's time taken to run at the
+// authentic 1.023 MHz.
+func BenchmarkRunFrame(b *testing.B) {
+	cases := []struct {
+		name    string
+		program []byte
+		direct  bool
+	}{
+		{"direct", benchmarkProgram, true},
+		{"accessor", benchmarkProgram, false},
+		{"io", benchmarkIOProgram, true},
+	}
+	for _, c := range cases {
+		direct := c.direct
+		b.Run(c.name, func(b *testing.B) {
+			a := newApple2()
+			a.mmu.StoreBytes(0x300, c.program)
+			a.cpu.SetPC(0x300)
+			if !direct {
+				disableDirectPages(a.mmu)
+			}
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				a.RunFrame()
+			}
+			elapsed := time.Since(start)
+			b.ReportMetric(float64(b.N)*cyclesPerFrame/elapsed.Seconds()/1e6, "MHz")
+			b.ReportMetric(100*elapsed.Seconds()/(float64(b.N)*framePeriod.Seconds()), "%load")
+		})
+	}
+}
+
+func TestPhysicalAccess(t *testing.T) {
+	a := newApple2()
+	m := a.mmu
+	m.SetAuxCard(auxCardRamWorks)
+	m.SetRamWorksBanks(4)
+
+	// Language card bank 1 is reachable while bank 2 is mapped.
+	a.mmu.LoadByte(0xc08b)
+	a.mmu.LoadByte(0xc08b)
+	m.StoreByte(0xd000, 0x11)
+	a.mmu.LoadByte(0xc083)
+	a.mmu.LoadByte(0xc083)
+	m.StoreByte(0xd000, 0x22)
+	for _, c := range []struct {
+		s string
+		v byte
+	}{
+		{"lc1:d000", 0x11},
+		{"main:d000", 0x22},
+	} {
+		p, err := parsePhysAddr(c.s)
+		if err != nil {
+			t.Fatalf("%s: %v\n", c.s, err)
+		}
+		if v, err := m.PeekPhys(p); err != nil || v != c.v {
+			t.Errorf("%s: expected $%02X, got $%02X (%v)\n", c.s, c.v, v, err)
+		}
+	}
+
+	// Pokes bypass the soft switches and reach unmapped banks.
+	switches := a.iou.switches
+	p, _ := parsePhysAddr("aux.3:c000")
+	if err := m.PokePhys(p, 1); err != errPhysAddr {
+		t.Errorf("Expected the I/O space rejected, got %v\n", err)
+	}
+	p, _ = parsePhysAddr("aux.3:0300")
+	if err := m.PokePhys(p, 0x33); err != nil {
+		t.Fatalf("PokePhys: %v\n", err)
+	}
+	if m.auxBanks[3][0x300] != 0x33 || a.iou.switches != switches {
+		t.Errorf("Expected aux bank 3 written without switching\n")
+	}
+	if p, _ = parsePhysAddr("aux.4:0300"); m.PokePhys(p, 0) != errPhysBank {
+		t.Errorf("Expected a missing bank rejected\n")
+	}
+
+	// ROM patches are visible through the mapped view.
+	p, _ = parsePhysAddr("rom:f800")
+	m.PokePhys(p, 0xea)
+	m.LoadByte(0xc082)
+	if v := m.LoadByte(0xf800); v != 0xea {
+		t.Errorf("Expected patched ROM, got $%02X\n", v)
+	}
+
+	// Pokes to displayed memory mark it for redrawing.
+	a.ds.dirty = videoDirty{}
+	p, _ = parsePhysAddr("main:0400")
+	m.PokePhys(p, 0xc1)
+	if !a.ds.dirty.text[0][0] {
+		t.Errorf("Expected the text row marked dirty\n")
+	}
+
+	for _, s := range []string{"main", "ram:0400", "aux.x:0400", "lc1:e000"} {
+		if p, err := parsePhysAddr(s); err == nil {
+			if _, err = m.PeekPhys(p); err == nil {
+				t.Errorf("%s: expected an error\n", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import "errors"
+
+const mockingboardHighPassHz = 20.0
+
+// A mockingboard emulates the Sweet Micro Systems Mockingboard sound card.
+// The card has two 6522 VIAs, each driving an AY-3-8910 sound generator.
+// The VIAs are mapped into the card's I/O ROM space: the first at
+// $Cn00..$Cn0F and the second at $Cn80..$Cn8F. Both VIAs may raise IRQs.
+// Cards with the optional SSI-263 speech chip map it at $Cn40..$Cn44.
+type mockingboard struct {
+	apple2 *apple2
+
+	via     [2]*via6522
+	psg     [2]*ay38910
+	speech  *ssi263 // speech chip, or nil if not installed
+	scratch [2][]float32
+	hp      highPassFilter
+	gain    float64
+}
+
+func newMockingboard(apple2 *apple2) *mockingboard {
+	mb := &mockingboard{
+		apple2: apple2,
+		hp:     newHighPassFilter(mockingboardHighPassHz),
+		gain:   1.0,
+	}
+
+	for i := range mb.via {
+		mb.psg[i] = newAY38910(apple2.sched.Now)
+		mb.via[i] = newVIA6522(apple2.sched, mb.psg[i])
+		mb.via[i].onIRQ = mb.updateIRQ
+	}
+	return mb
+}
+
+// AddSpeech installs an SSI-263 speech chip on the card. If synth is nil,
+// a simple built-in phoneme synthesizer is used.
+func (mb *mockingboard) AddSpeech(synth speechSynth) {
+	mb.speech = newSSI263(mb.apple2.sched, mb.via[0].TriggerCA1, synth)
+}
+
+func (mb *mockingboard) LoadIO(addr uint16) byte {
+	return mb.apple2.vs.FloatingBus()
+}
+
+func (mb *mockingboard) StoreIO(addr uint16, v byte) {
+}
+
+func (mb *mockingboard) LoadROM(addr uint16) byte {
+	if mb.speech != nil && (addr&0xc0) == 0x40 {
+		return mb.speech.LoadByte(addr & 0x07)
+	}
+	return mb.via[(addr>>7)&1].LoadByte(addr & 0x0f)
+}
+
+func (mb *mockingboard) StoreROM(addr uint16, v byte) {
+	if mb.speech != nil && (addr&0xc0) == 0x40 {
+		mb.speech.StoreByte(addr&0x07, v)
+		return
+	}
+	mb.via[(addr>>7)&1].StoreByte(addr&0x0f, v)
+}
+
+// Reset resets the VIAs, which are wired to the RESET line. The sound
+// generators aren't, so they play on until software silences them, as on
+// a real card.
+func (mb *mockingboard) Reset() {
+	for _, v := range mb.via {
+		v.Reset()
+	}
+}
+
+// updateIRQ drives the card's interrupt line from the VIAs' IRQ outputs,
+// which are wired together.
+func (mb *mockingboard) updateIRQ() {
+	mb.apple2.ints.SetIRQ(mb, mb.via[0].irq || mb.via[1].irq)
+}
+
+// IRQ returns true if either VIA is asserting an interrupt request.
+func (mb *mockingboard) IRQ() bool {
+	if mb.speech != nil {
+		mb.speech.sync()
+	}
+	return mb.via[0].IRQ() || mb.via[1].IRQ()
+}
+
+// RenderSamples mixes the output of both sound generators into buf.
+func (mb *mockingboard) RenderSamples(buf []float32, start float64) {
+	for i, psg := range mb.psg {
+		if cap(mb.scratch[i]) < len(buf) {
+			mb.scratch[i] = make([]float32, len(buf))
+		}
+		mb.scratch[i] = mb.scratch[i][:len(buf)]
+		psg.render(mb.scratch[i], start)
+	}
+
+	for n := range buf {
+		v := float64(mb.scratch[0][n]+mb.scratch[1][n]) / 2
+		buf[n] += float32(mb.hp.Filter(v) * mb.gain)
+	}
+
+	if mb.speech != nil {
+		mb.speech.sync()
+		mb.speech.RenderSamples(buf, start)
+	}
+}
+
+func (mb *mockingboard) saveState(sw *stateWriter) {
+	sw.Tag("MOCK")
+	for i := range mb.via {
+		mb.via[i].saveState(sw)
+		mb.psg[i].saveState(sw)
+	}
+	sw.Bool(mb.speech != nil)
+	if mb.speech != nil {
+		mb.speech.saveState(sw)
+	}
+}
+
+func (mb *mockingboard) loadState(sr *stateReader) {
+	sr.Tag("MOCK")
+	for i := range mb.via {
+		mb.via[i].loadState(sr)
+		mb.psg[i].loadState(sr)
+	}
+	if speech := sr.Bool(); sr.Err() == nil && speech != (mb.speech != nil) {
+		sr.Fail(errors.New("snapshot has a different Mockingboard speech configuration"))
+		return
+	}
+	if mb.speech != nil {
+		mb.speech.loadState(sr)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+func TestMockingboardPSGWrite(t *testing.T) {
+	a := newApple2()
+	mb := newMockingboard(a)
+	a.sm.Insert(4, mb)
+
+	// Configure both ports as outputs, then latch register 8 and write 0x0f.
+	a.mmu.StoreByte(0xc402, 0xff)
+	a.mmu.StoreByte(0xc403, 0xff)
+	a.mmu.StoreByte(0xc401, 0x08)
+	a.mmu.StoreByte(0xc400, 0x07)
+	a.mmu.StoreByte(0xc400, 0x04)
+	a.mmu.StoreByte(0xc401, 0x0f)
+	a.mmu.StoreByte(0xc400, 0x06)
+	a.mmu.StoreByte(0xc400, 0x04)
+
+	if v := mb.psg[0].regs[8]; v != 0x0f {
+		t.Errorf("Expected PSG 1 register 8 to be 0f, got %02x\n", v)
+	}
+	if v := mb.psg[1].regs[8]; v != 0x00 {
+		t.Errorf("Expected PSG 2 register 8 to be 00, got %02x\n", v)
+	}
+
+	// Read the register back through port A.
+	a.mmu.StoreByte(0xc403, 0x00)
+	a.mmu.StoreByte(0xc400, 0x05)
+	if v := a.mmu.LoadByte(0xc401); v != 0x0f {
+		t.Errorf("Expected to read 0f from PSG 1 register 8, got %02x\n", v)
+	}
+}
+
+func TestMockingboardTimerIRQ(t *testing.T) {
+	a := newApple2()
+	mb := newMockingboard(a)
+	a.sm.Insert(4, mb)
+
+	// Enable the timer 1 interrupt in free-running mode with a period of
+	// 0x1000 cycles.
+	a.mmu.StoreByte(0xc40b, 0x40)
+	a.mmu.StoreByte(0xc40e, 0xc0)
+	a.mmu.StoreByte(0xc404, 0x00)
+	a.mmu.StoreByte(0xc405, 0x10)
+
+	if mb.IRQ() {
+		t.Errorf("Unexpected IRQ before timer expired\n")
+	}
+
+	a.cpu.Cycles += 0x1001
+	if !mb.IRQ() {
+		t.Errorf("Expected IRQ after timer expired\n")
+	}
+	if v := a.mmu.LoadByte(0xc40d); v != 0xc0 {
+		t.Errorf("Expected IFR c0, got %02x\n", v)
+	}
+
+	// Reading T1CL acknowledges the interrupt.
+	a.mmu.LoadByte(0xc404)
+	if mb.IRQ() {
+		t.Errorf("Unexpected IRQ after acknowledge\n")
+	}
+
+	// Free-running mode interrupts again after another period.
+	a.cpu.Cycles += 0x1001
+	if !mb.IRQ() {
+		t.Errorf("Expected IRQ after second period\n")
+	}
+}
+
+func TestMockingboardSpeechRequest(t *testing.T) {
+	a := newApple2()
+	mb := newMockingboard(a)
+	mb.AddSpeech(nil)
+	a.sm.Insert(4, mb)
+
+	// Enable the CA1 interrupt, then speak phoneme "E" at the fastest rate.
+	a.mmu.StoreByte(0xc40e, 0x82)
+	a.mmu.StoreByte(0xc443, 0x0f)
+	a.mmu.StoreByte(0xc442, 0xf0)
+	a.mmu.StoreByte(0xc440, 0xc1)
+
+	if v := a.mmu.LoadByte(0xc440); v&0x80 == 0 {
+		t.Errorf("Unexpected A/R request while phoneme is playing\n")
+	}
+
+	a.cpu.Cycles += mb.speech.duration()
+	if !mb.IRQ() {
+		t.Errorf("Expected IRQ when phoneme finished\n")
+	}
+	if v := a.mmu.LoadByte(0xc440); v&0x80 != 0 {
+		t.Errorf("Expected A/R request when phoneme finished\n")
+	}
+
+	// Writing the next phoneme acknowledges the request.
+	a.mmu.StoreByte(0xc401, 0)
+	a.mmu.StoreByte(0xc440, 0xc0)
+	if mb.IRQ() {
+		t.Errorf("Unexpected IRQ after acknowledge\n")
+	}
+}
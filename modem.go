@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hayes result codes, in numeric result code order.
+const (
+	modemOK = iota
+	modemConnect
+	modemRing
+	modemNoCarrier
+	modemError
+)
+
+var modemResults = []string{
+	/* modemOK        */ "OK",
+	/* modemConnect   */ "CONNECT",
+	/* modemRing      */ "RING",
+	/* modemNoCarrier */ "NO CARRIER",
+	/* modemError     */ "ERROR",
+}
+
+// Modem S registers.
+const (
+	modemRegAutoAnswer = 0 // rings before answering (0 = never)
+	modemRegRings      = 1 // rings counted
+	modemRegEscape     = 2 // escape character
+	modemRegCR         = 3 // carriage return character
+	modemRegLF         = 4 // line feed character
+	modemRegBS         = 5 // backspace character
+	modemRegs          = 16
+)
+
+const (
+	modemGuardTime   = time.Second     // silence around the +++ escape
+	modemRingTime    = 2 * time.Second // time between rings of an incoming call
+	modemDialTimeout = 30 * time.Second
+	telnetPort       = "23"
+)
+
+// A hayesModem is a virtual Hayes-compatible modem attached to a serial
+// port. It interprets AT commands typed by communications software and
+// places calls as TCP connections, treating the dialed number as a
+// host[:port] address, so that the software can reach telnet BBSes. It
+// can also accept incoming connections, which ring until answered.
+//
+// Telnet option negotiation is handled by the modem: it refuses every
+// option except the server's offers to echo and suppress go-ahead, and
+// removes telnet commands from the data passed to the Apple.
+type hayesModem struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	out    []byte // data waiting to be read by the serial port
+	closed bool
+
+	line    []byte // command line being typed
+	echo    bool   // echo commands (E)
+	verbose bool   // word result codes (V)
+	quiet   bool   // no result codes (Q)
+	regs    [modemRegs]byte
+
+	conn     net.Conn     // connection of the call in progress, or nil
+	online   bool         // data mode; otherwise command mode
+	incoming net.Conn     // incoming call ringing, or nil
+	listener net.Listener // listener for incoming calls, or nil
+
+	lastTx time.Time     // time the last character was sent by the Apple
+	plus   int           // escape characters sent after a guard time
+	escape *time.Timer   // fires when the guard time after +++ expires
+	guard  time.Duration // silence required around the escape sequence
+	now    func() time.Time
+	dial   func(addr string) (net.Conn, error)
+}
+
+func newHayesModem() *hayesModem {
+	m := &hayesModem{
+		guard: modemGuardTime,
+		now:   time.Now,
+		dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, modemDialTimeout)
+		},
+	}
+	m.cond = sync.NewCond(&m.mu)
+	m.reset()
+	return m
+}
+
+// reset restores the modem's default settings (ATZ).
+func (m *hayesModem) reset() {
+	m.echo, m.verbose, m.quiet = true, true, false
+	m.regs = [modemRegs]byte{}
+	m.regs[modemRegEscape] = '+'
+	m.regs[modemRegCR] = '\r'
+	m.regs[modemRegLF] = '\n'
+	m.regs[modemRegBS] = 0x08
+}
+
+// Listen accepts incoming calls on a TCP address.
+func (m *hayesModem) Listen(addr string) (net.Addr, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.listener = l
+	m.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			busy := m.conn != nil || m.incoming != nil
+			if !busy {
+				m.incoming = conn
+				m.regs[modemRegRings] = 0
+			}
+			m.mu.Unlock()
+			if busy {
+				conn.Close()
+				continue
+			}
+			go m.ring(conn)
+		}
+	}()
+	return l.Addr(), nil
+}
+
+// ring rings for an incoming call until it is answered, either by the
+// ATA command or automatically after the number of rings in S0.
+func (m *hayesModem) ring(conn net.Conn) {
+	for {
+		m.mu.Lock()
+		if m.incoming != conn || m.closed {
+			m.mu.Unlock()
+			return
+		}
+		m.result(modemRing)
+		m.regs[modemRegRings]++
+		if n := m.regs[modemRegAutoAnswer]; n > 0 && m.regs[modemRegRings] >= n {
+			m.answer()
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+		time.Sleep(modemRingTime)
+	}
+}
+
+// Read returns the modem's output to the serial port: echoed commands,
+// result codes and data received during a call.
+func (m *hayesModem) Read(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.out) == 0 && !m.closed {
+		m.cond.Wait()
+	}
+	if len(m.out) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, m.out)
+	m.out = m.out[n:]
+	return n, nil
+}
+
+// Write handles characters sent by the serial port: commands in command
+// mode and data during a call.
+func (m *hayesModem) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range b {
+		if m.online {
+			m.send(c)
+		} else {
+			m.command(c & 0x7f)
+		}
+	}
+	return len(b), nil
+}
+
+// Close hangs up and stops accepting calls.
+func (m *hayesModem) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hangup()
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	m.closed = true
+	m.cond.Broadcast()
+	return nil
+}
+
+// output queues characters to be read by the serial port.
+func (m *hayesModem) output(b ...byte) {
+	m.out = append(m.out, b...)
+	m.cond.Broadcast()
+}
+
+// result reports a result code.
+func (m *hayesModem) result(code int) {
+	switch {
+	case m.quiet:
+	case m.verbose:
+		cr, lf := m.regs[modemRegCR], m.regs[modemRegLF]
+		m.output(cr, lf)
+		m.output([]byte(modemResults[code])...)
+		m.output(cr, lf)
+	default:
+		m.output(byte('0'+code), m.regs[modemRegCR])
+	}
+}
+
+// send sends a character during a call, watching for the escape sequence:
+// three escape characters preceded and followed by a guard time.
+func (m *hayesModem) send(c byte) {
+	now := m.now()
+	if c == m.regs[modemRegEscape] && (m.plus > 0 || now.Sub(m.lastTx) >= m.guard) {
+		m.plus++
+		if m.plus == 3 {
+			conn := m.conn
+			m.escape = time.AfterFunc(m.guard, func() {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				if m.plus == 3 && m.conn == conn {
+					m.plus = 0
+					m.online = false
+					m.result(modemOK)
+				}
+			})
+		}
+	} else {
+		m.plus = 0
+		if m.escape != nil {
+			m.escape.Stop()
+			m.escape = nil
+		}
+	}
+	m.lastTx = now
+
+	if c == telnetIAC {
+		m.conn.Write([]byte{telnetIAC, telnetIAC})
+	} else {
+		m.conn.Write([]byte{c})
+	}
+}
+
+// command handles a character typed in command mode.
+func (m *hayesModem) command(c byte) {
+	if m.echo {
+		m.output(c)
+	}
+	switch c {
+	case m.regs[modemRegCR]:
+		line := strings.ToUpper(string(m.line))
+		m.line = m.line[:0]
+		if strings.HasPrefix(line, "AT") {
+			if code, ok := m.execute(line[2:]); ok {
+				m.result(code)
+			}
+		}
+	case m.regs[modemRegBS], 0x7f:
+		if len(m.line) > 0 {
+			m.line = m.line[:len(m.line)-1]
+		}
+	case m.regs[modemRegLF]:
+	default:
+		m.line = append(m.line, c)
+	}
+}
+
+// execute runs the commands following AT on a command line. It returns
+// the result code to report, or false if the result will be reported
+// later.
+func (m *hayesModem) execute(cmds string) (int, bool) {
+	cmds = strings.Replace(cmds, " ", "", -1)
+	for len(cmds) > 0 {
+		c := cmds[0]
+		cmds = cmds[1:]
+
+		// Most commands take an optional single digit parameter.
+		n := 0
+		if c != 'D' && c != 'S' && len(cmds) > 0 && cmds[0] >= '0' && cmds[0] <= '9' {
+			n = int(cmds[0] - '0')
+			cmds = cmds[1:]
+		}
+
+		switch c {
+		case 'D':
+			m.call(strings.TrimLeft(cmds, "TP"))
+			return 0, false
+		case 'A':
+			if m.incoming == nil {
+				return modemNoCarrier, true
+			}
+			m.answer()
+			return 0, false
+		case 'H':
+			m.hangup()
+		case 'O':
+			if m.conn == nil {
+				return modemNoCarrier, true
+			}
+			m.online = true
+			return modemConnect, true
+		case 'Z':
+			m.hangup()
+			m.reset()
+		case 'E':
+			m.echo = n != 0
+		case 'V':
+			m.verbose = n != 0
+		case 'Q':
+			m.quiet = n != 0
+		case 'S':
+			var ok bool
+			if cmds, ok = m.register(cmds); !ok {
+				return modemError, true
+			}
+		case '&':
+			// Extended commands are accepted and ignored.
+			if len(cmds) == 0 {
+				return modemError, true
+			}
+			cmds = strings.TrimLeft(cmds[1:], "0123456789")
+		case 'B', 'C', 'L', 'M', 'X', 'I':
+			// Speaker, protocol and result code options don't apply.
+		default:
+			return modemError, true
+		}
+	}
+	return modemOK, true
+}
+
+// register handles the S register commands Sn=v and Sn?, returning the
+// rest of the command line.
+func (m *hayesModem) register(cmds string) (string, bool) {
+	i := 0
+	for i < len(cmds) && cmds[i] >= '0' && cmds[i] <= '9' {
+		i++
+	}
+	r, err := strconv.Atoi(cmds[:i])
+	if err != nil || r >= modemRegs || i == len(cmds) {
+		return "", false
+	}
+	cmds = cmds[i:]
+
+	switch cmds[0] {
+	case '?':
+		m.output(m.regs[modemRegCR], m.regs[modemRegLF])
+		m.output([]byte(strconv.Itoa(int(m.regs[r])))...)
+		m.output(m.regs[modemRegCR], m.regs[modemRegLF])
+		return cmds[1:], true
+	case '=':
+		j := 1
+		for j < len(cmds) && cmds[j] >= '0' && cmds[j] <= '9' {
+			j++
+		}
+		v, err := strconv.Atoi(cmds[1:j])
+		if err != nil || v > 255 {
+			return "", false
+		}
+		m.regs[r] = byte(v)
+		return cmds[j:], true
+	}
+	return "", false
+}
+
+// call dials a host[:port] address in the background. The telnet port is
+// used if none is given. Phone numbers can't be dialed.
+func (m *hayesModem) call(number string) {
+	addr := strings.TrimSpace(number)
+	if addr != "" && !strings.Contains(addr, ":") {
+		addr = net.JoinHostPort(addr, telnetPort)
+	}
+	if addr == "" || strings.Trim(addr, "0123456789-,:") == "" {
+		m.result(modemNoCarrier)
+		return
+	}
+	go func() {
+		conn, err := m.dial(addr)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if err != nil || m.closed {
+			if conn != nil {
+				conn.Close()
+			}
+			m.result(modemNoCarrier)
+			return
+		}
+		m.connect(conn)
+	}()
+}
+
+// answer answers the ringing incoming call.
+func (m *hayesModem) answer() {
+	conn := m.incoming
+	m.incoming = nil
+	m.connect(conn)
+}
+
+// connect starts a call on a connection.
+func (m *hayesModem) connect(conn net.Conn) {
+	m.hangup()
+	m.conn, m.online, m.plus = conn, true, 0
+	m.lastTx = m.now()
+	m.result(modemConnect)
+	go m.receive(conn)
+}
+
+// hangup ends the call in progress and rejects a ringing call.
+func (m *hayesModem) hangup() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+	if m.incoming != nil {
+		m.incoming.Close()
+		m.incoming = nil
+	}
+	m.online = false
+}
+
+// receive passes data received during a call to the serial port until
+// the connection closes.
+func (m *hayesModem) receive(conn net.Conn) {
+	var t telnetFilter
+	buf := make([]byte, 256)
+	for {
+		n, err := conn.Read(buf)
+		data, reply := t.filter(buf[:n])
+		if len(reply) > 0 {
+			conn.Write(reply)
+		}
+
+		m.mu.Lock()
+		if m.conn != conn {
+			m.mu.Unlock()
+			return
+		}
+		if m.online {
+			m.output(data...)
+		}
+		if err != nil {
+			m.conn, m.online = nil, false
+			m.result(modemNoCarrier)
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Telnet protocol bytes.
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3
+)
+
+// A telnetFilter removes telnet commands from a received byte stream and
+// produces the replies to option negotiations.
+type telnetFilter struct {
+	state byte // 0, IAC, or the negotiation command awaiting its option
+	sub   bool // inside a subnegotiation
+}
+
+func (t *telnetFilter) filter(b []byte) (data, reply []byte) {
+	var out bytes.Buffer
+	var rep bytes.Buffer
+	for _, c := range b {
+		switch t.state {
+		case 0:
+			if c == telnetIAC {
+				t.state = telnetIAC
+			} else if !t.sub {
+				out.WriteByte(c)
+			}
+		case telnetIAC:
+			t.state = 0
+			switch c {
+			case telnetIAC:
+				if !t.sub {
+					out.WriteByte(c)
+				}
+			case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+				t.state = c
+			case telnetSB:
+				t.sub = true
+			case telnetSE:
+				t.sub = false
+			}
+		default:
+			switch t.state {
+			case telnetDO:
+				rep.Write([]byte{telnetIAC, telnetWONT, c})
+			case telnetWILL:
+				if c == telnetOptEcho || c == telnetOptSGA {
+					rep.Write([]byte{telnetIAC, telnetDO, c})
+				} else {
+					rep.Write([]byte{telnetIAC, telnetDONT, c})
+				}
+			}
+			t.state = 0
+		}
+	}
+	return out.Bytes(), rep.Bytes()
+}
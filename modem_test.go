@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectModem reads the modem's output until it contains want.
+func expectModem(t *testing.T, m *hayesModem, want string) {
+	t.Helper()
+	var got []byte
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 64)
+		for !bytes.Contains(got, []byte(want)) {
+			n, err := m.Read(buf)
+			if err != nil {
+				break
+			}
+			got = append(got, buf[:n]...)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		if !bytes.Contains(got, []byte(want)) {
+			t.Fatalf("Expected %q from modem, got %q\n", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		m.Close()
+		<-done
+		t.Fatalf("Timed out waiting for %q from modem, got %q\n", want, got)
+	}
+}
+
+func TestModemCommands(t *testing.T) {
+	m := newHayesModem()
+	defer m.Close()
+
+	m.Write([]byte("AT\r"))
+	expectModem(t, m, "AT\r\r\nOK\r\n")
+	m.Write([]byte("ATE0V0\r"))
+	expectModem(t, m, "0\r")
+	m.Write([]byte("ATS0=2S0?\r"))
+	expectModem(t, m, "\r\n2\r\n0\r")
+	m.Write([]byte("ATW\r"))
+	expectModem(t, m, "4\r")
+	m.Write([]byte("ATDT5551212\r"))
+	expectModem(t, m, "3\r")
+	m.Write([]byte("ATZ\r"))
+	expectModem(t, m, "\r\nOK\r\n")
+	m.Write([]byte("ATX\x08Z\r"))
+	expectModem(t, m, "OK")
+}
+
+func TestModemCall(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	m := newHayesModem()
+	m.guard = 50 * time.Millisecond
+	defer m.Close()
+	m.Write([]byte("ATE0\r"))
+	expectModem(t, m, "OK")
+	m.Write([]byte("ATDT " + strings.ToLower(l.Addr().String()) + "\r"))
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	expectModem(t, m, "CONNECT")
+
+	// Telnet negotiation is answered and removed from the data.
+	server.Write([]byte{'H', telnetIAC, telnetDO, 24, telnetIAC, telnetWILL, telnetOptEcho, 'I', telnetIAC, telnetIAC})
+	expectModem(t, m, "HI\xff")
+	reply := make([]byte, 6)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := server.Read(reply); err != nil || !bytes.Equal(reply, []byte{telnetIAC, telnetWONT, 24, telnetIAC, telnetDO, telnetOptEcho}) {
+		t.Errorf("Unexpected telnet reply % X (%v)\n", reply, err)
+	}
+
+	time.Sleep(2 * m.guard)
+	m.Write([]byte("+++"))
+	expectModem(t, m, "OK")
+	buf := make([]byte, 3)
+	server.Read(buf)
+	if string(buf) != "+++" {
+		t.Errorf("Expected the escape sequence to be sent, got %q\n", buf)
+	}
+
+	m.Write([]byte("ATO\r"))
+	expectModem(t, m, "CONNECT")
+	m.Write([]byte("X"))
+	server.Read(buf[:1])
+	if buf[0] != 'X' {
+		t.Errorf("Expected X after returning online, got %q\n", buf[:1])
+	}
+
+	server.Close()
+	expectModem(t, m, "NO CARRIER")
+}
+
+func TestModemAnswer(t *testing.T) {
+	m := newHayesModem()
+	defer m.Close()
+	addr, err := m.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Write([]byte("ATE0S0=1\r"))
+	expectModem(t, m, "OK")
+
+	client, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	expectModem(t, m, "RING\r\n\r\nCONNECT\r\n")
+	client.Write([]byte("hello"))
+	expectModem(t, m, "hello")
+}
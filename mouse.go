@@ -0,0 +1,208 @@
+package main
+
+import "sync"
+
+// A mouseInput collects host mouse motion and button presses for the
+// emulated mouse hardware. Front ends report host mouse events to it from
+// any goroutine.
+type mouseInput struct {
+	apple2 *apple2
+
+	mu     sync.Mutex
+	dx, dy int  // host motion not yet delivered
+	button bool // true = button pressed
+
+	hostDX, hostDY int // host motion since the input log last sampled it
+}
+
+// Move reports relative host mouse motion.
+func (m *mouseInput) Move(dx, dy int) {
+	if m.apple2.in.Replaying() {
+		return
+	}
+	m.mu.Lock()
+	m.dx += dx
+	m.dy += dy
+	m.hostDX += dx
+	m.hostDY += dy
+	m.mu.Unlock()
+}
+
+// SetButton reports the state of the host mouse button.
+func (m *mouseInput) SetButton(pressed bool) {
+	if m.apple2.in.Replaying() {
+		return
+	}
+	m.mu.Lock()
+	m.button = pressed
+	m.mu.Unlock()
+}
+
+// take returns and clears the motion not yet delivered, along with the
+// button state.
+func (m *mouseInput) take() (dx, dy int, button bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dx, dy = m.dx, m.dy
+	m.dx, m.dy = 0, 0
+	return dx, dy, m.button
+}
+
+// MouseInput returns the input of the IIc's built-in mouse or of an
+// AppleMouse card, or nil if the machine has no mouse.
+func (a *apple2) MouseInput() *mouseInput {
+	if a.mouse != nil {
+		return &a.mouse.mouseInput
+	}
+	for slot := 1; slot < numSlots; slot++ {
+		if c, ok := a.sm.Card(slot).(*appleMouseCard); ok {
+			return &c.mouseInput
+		}
+	}
+	return nil
+}
+
+// An iicMouse emulates the mouse interface built into the IIc's IOU. Each
+// unit of mouse motion produces an edge on the X0 or Y0 quadrature signal,
+// which may interrupt the CPU. The firmware's interrupt handler reads the
+// X1 and Y1 signals to learn the direction of motion and counts the edges
+// to track the mouse position.
+type iicMouse struct {
+	mouseInput
+
+	x1, y1     bool // direction signals; true = moving right or down
+	xyEnabled  bool // true = X0/Y0 edges cause interrupts
+	vblEnabled bool // true = vertical blanking causes interrupts
+	x0Falling  bool // true = interrupt on falling X0 edges
+	y0Falling  bool // true = interrupt on falling Y0 edges
+	xInt, yInt bool // X0 and Y0 interrupts pending
+	vblInt     bool // vertical blanking interrupt pending
+}
+
+func newIICMouse(apple2 *apple2) *iicMouse {
+	m := &iicMouse{
+		mouseInput: mouseInput{apple2: apple2},
+	}
+	apple2.sched.Every(cyclesPerFrame, vblStartCycle, m.Update)
+	return m
+}
+
+// Update delivers pending mouse motion and signals the vertical blanking
+// interrupt. It runs at the start of each vertical blanking interval.
+func (m *iicMouse) Update() {
+	if m.vblEnabled {
+		m.vblInt = true
+	}
+	m.poll()
+	m.updateIRQ()
+}
+
+// Reset disables and clears the mouse interrupts, as the RESET line does.
+func (m *iicMouse) Reset() {
+	m.xyEnabled, m.vblEnabled = false, false
+	m.x0Falling, m.y0Falling = false, false
+	m.xInt, m.yInt, m.vblInt = false, false, false
+	m.updateIRQ()
+}
+
+// updateIRQ drives the interface's interrupt line from its pending
+// interrupts.
+func (m *iicMouse) updateIRQ() {
+	m.apple2.ints.SetIRQ(m, m.xInt || m.yInt || m.vblInt)
+}
+
+// poll delivers one unit of pending motion on each axis whose previous
+// interrupt has been acknowledged.
+func (m *iicMouse) poll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dx != 0 && !m.xInt {
+		m.x1 = m.dx > 0
+		m.dx -= sign(m.dx)
+		m.xInt = m.xyEnabled
+	}
+	if m.dy != 0 && !m.yInt {
+		m.y1 = m.dy > 0
+		m.dy -= sign(m.dy)
+		m.yInt = m.xyEnabled
+	}
+}
+
+// IRQ reports whether the mouse interface is requesting an interrupt.
+func (m *iicMouse) IRQ() bool {
+	m.poll()
+	return m.xInt || m.yInt || m.vblInt
+}
+
+// ButtonBit returns the mouse button state in bit 7; the bit is clear
+// while the button is pressed.
+func (m *iicMouse) ButtonBit() byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.button {
+		return 0
+	}
+	return 0x80
+}
+
+// ReadSwitch handles a read of one of the IIc mouse soft switches. It
+// returns false if the address is not a mouse switch.
+func (m *iicMouse) ReadSwitch(addr uint16) (v byte, ok bool) {
+	m.poll()
+	defer m.updateIRQ()
+
+	switch addr {
+	case 0x15: // RSTXINT
+		v = bit7(m.xInt)
+		m.xInt = false
+	case 0x17: // RSTYINT
+		v = bit7(m.yInt)
+		m.yInt = false
+	case 0x40: // RDXYMSK
+		v = bit7(m.xyEnabled)
+	case 0x41: // RDVBLMSK
+		v = bit7(m.vblEnabled)
+	case 0x42: // RDX0EDGE
+		v = bit7(m.x0Falling)
+	case 0x43: // RDY0EDGE
+		v = bit7(m.y0Falling)
+	case 0x48: // RSTXY
+		m.xInt, m.yInt = false, false
+	case 0x63: // RDMOUBTN
+		v = m.ButtonBit()
+	case 0x66: // RDMOUX1
+		v = bit7(m.x1)
+	case 0x67: // RDMOUY1
+		v = bit7(m.y1)
+	case 0x70: // PTRIG also resets the VBL interrupt
+		m.vblInt = false
+		return 0, false
+	default:
+		return 0, false
+	}
+	return v, true
+}
+
+// WriteSwitch handles an access to one of the $C058..$C05F mouse interrupt
+// control switches.
+func (m *iicMouse) WriteSwitch(addr uint16) {
+	switch addr {
+	case 0x58:
+		m.xyEnabled = false
+	case 0x59:
+		m.xyEnabled = true
+	case 0x5a:
+		m.vblEnabled = false
+	case 0x5b:
+		m.vblEnabled = true
+	case 0x5c:
+		m.x0Falling = false
+	case 0x5d:
+		m.x0Falling = true
+	case 0x5e:
+		m.y0Falling = false
+	case 0x5f:
+		m.y0Falling = true
+	}
+}
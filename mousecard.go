@@ -0,0 +1,300 @@
+package main
+
+// AppleMouse firmware calls, in the order of the firmware's table of entry
+// points at $Cn12..$Cn19. Each entry point passes the accumulator to the
+// card by storing it at the call's offset in the slot I/O space.
+const (
+	mouseSet   = iota // SETMOUSE: set the mode to A
+	mouseServe        // SERVEMOUSE: find the source of an interrupt
+	mouseRead         // READMOUSE: update the screen holes
+	mouseClear        // CLEARMOUSE: move the mouse to 0,0
+	mousePos          // POSMOUSE: move the mouse to the screen hole position
+	mouseClamp        // CLAMPMOUSE: clamp axis A to the bounds in the slot 0 holes
+	mouseHome         // HOMEMOUSE: move the mouse to the top left of its bounds
+	mouseInit         // INITMOUSE: reset the mouse
+	numMouseCommands
+
+	mouseResult = 0xf // I/O offset of the result of the last command
+)
+
+// AppleMouse mode bits, as passed to SETMOUSE.
+const (
+	mouseModeOn        = 1 << 0 // the mouse is on
+	mouseModeMoveInt   = 1 << 1 // interrupt when the mouse moves
+	mouseModeButtonInt = 1 << 2 // interrupt when the button changes
+	mouseModeVBLInt    = 1 << 3 // interrupt on vertical blanking
+)
+
+// AppleMouse status bits, as stored in the status screen hole.
+const (
+	mouseStatusMoveInt   = 1 << 1 // movement caused the interrupt
+	mouseStatusButtonInt = 1 << 2 // the button caused the interrupt
+	mouseStatusVBLInt    = 1 << 3 // vertical blanking caused the interrupt
+	mouseStatusMoved     = 1 << 5 // the mouse moved since the last read
+	mouseStatusLast      = 1 << 6 // the button was down at the last read
+	mouseStatusButton    = 1 << 7 // the button is down
+)
+
+// Screen holes holding the state of the mouse in slot n, at the hole
+// address plus n. The clamp bounds passed to CLAMPMOUSE are in the X
+// holes of slot 0: the lower bound in the low and high X holes, and the
+// upper bound in the low and high Y holes.
+const (
+	mouseHoleXLow   = 0x0478
+	mouseHoleYLow   = 0x04f8
+	mouseHoleXHigh  = 0x0578
+	mouseHoleYHigh  = 0x05f8
+	mouseHoleStatus = 0x0778
+	mouseHoleMode   = 0x07f8
+)
+
+// An appleMouseCard emulates the AppleMouse II interface card. In place
+// of the card's microcontroller and its firmware, which drives it through
+// a 6821 PIA, the card has built-in firmware whose entry points pass each
+// call to the emulation. The card tracks the mouse position within its
+// clamping bounds, updating it at each vertical blanking, and reports the
+// position, button and interrupt status to the caller through the slot's
+// screen holes, as the real firmware does.
+type appleMouseCard struct {
+	mouseInput
+
+	slot     int
+	firmware [256]byte
+	x, y     int // mouse position
+	minX     int // clamping bounds
+	maxX     int
+	minY     int
+	maxY     int
+	mode     byte // SETMOUSE mode bits
+	down     bool // button state at the last vertical blanking
+	last     bool // button state at the last READMOUSE
+	moved    bool // the mouse moved since the last READMOUSE
+	irq      byte // pending interrupt status bits
+	result   byte // carry returned by the last command
+}
+
+func newAppleMouseCard(apple2 *apple2, slot int) *appleMouseCard {
+	c := &appleMouseCard{
+		mouseInput: mouseInput{apple2: apple2},
+		slot:       slot,
+	}
+	c.init()
+	apple2.sched.Every(cyclesPerFrame, vblStartCycle, c.vbl)
+
+	// The firmware's entry points each store the accumulator to their
+	// command's I/O offset, then return the command's result in the
+	// carry.
+	io := byte(0x80 + slot<<4)
+	fw := c.firmware[:]
+	copy(fw, []byte{
+		0x18, 0x60, // $Cn00: CLC; RTS (PR#n and IN#n are not supported)
+	})
+	copy(fw[0x05:], []byte{0x38, 0x00, 0x18})
+	copy(fw[0x0b:], []byte{0x01, 0x20})
+	entry := 0x20
+	for cmd := 0; cmd < numMouseCommands; cmd++ {
+		fw[0x12+cmd] = byte(entry)
+		entry += copy(fw[entry:], []byte{
+			0x8d, io + byte(cmd), 0xc0, // STA $C0nc
+			0xad, io + mouseResult, 0xc0, // LDA $C0nF
+			0x4a, // LSR A
+			0x60, // RTS
+		})
+	}
+	fw[0xfb] = 0xd6
+	return c
+}
+
+// init restores the state set by INITMOUSE.
+func (c *appleMouseCard) init() {
+	c.x, c.y = 0, 0
+	c.minX, c.maxX = 0, 1023
+	c.minY, c.maxY = 0, 1023
+	c.mode = 0
+	c.last, c.moved = false, false
+	c.irq = 0
+}
+
+func (c *appleMouseCard) LoadIO(addr uint16) byte {
+	if addr == mouseResult {
+		return c.result
+	}
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *appleMouseCard) StoreIO(addr uint16, v byte) {
+	if addr < numMouseCommands {
+		c.result = c.command(int(addr), v)
+		c.updateIRQ()
+	}
+}
+
+func (c *appleMouseCard) LoadROM(addr uint16) byte {
+	return c.firmware[addr]
+}
+
+func (c *appleMouseCard) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// IRQ reports whether the card is requesting an interrupt.
+func (c *appleMouseCard) IRQ() bool {
+	return c.irq != 0
+}
+
+// vbl updates the mouse position and button state and raises the
+// enabled interrupts. It runs at the start of each vertical blanking
+// interval.
+func (c *appleMouseCard) vbl() {
+	defer c.updateIRQ()
+
+	dx, dy, button := c.take()
+	if c.mode&mouseModeOn == 0 {
+		c.down = button
+		return
+	}
+	if dx != 0 || dy != 0 {
+		c.x = clamp(c.x+dx, c.minX, c.maxX)
+		c.y = clamp(c.y+dy, c.minY, c.maxY)
+		c.moved = true
+		if c.mode&mouseModeMoveInt != 0 {
+			c.irq |= mouseStatusMoveInt
+		}
+	}
+	if button != c.down && c.mode&mouseModeButtonInt != 0 {
+		c.irq |= mouseStatusButtonInt
+	}
+	c.down = button
+	if c.mode&mouseModeVBLInt != 0 {
+		c.irq |= mouseStatusVBLInt
+	}
+}
+
+// Reset turns the mouse off, as the card's microcontroller does when the
+// RESET line is asserted.
+func (c *appleMouseCard) Reset() {
+	c.init()
+	c.updateIRQ()
+}
+
+// updateIRQ drives the card's interrupt line from its pending interrupts.
+func (c *appleMouseCard) updateIRQ() {
+	c.apple2.ints.SetIRQ(c, c.irq != 0)
+}
+
+// command runs a firmware call and returns the carry it returns; 1
+// indicates an error, or for SERVEMOUSE, an interrupt the card didn't
+// cause.
+func (c *appleMouseCard) command(cmd int, a byte) byte {
+	switch cmd {
+	case mouseSet:
+		if a > 0x0f {
+			return 1
+		}
+		c.mode = a
+		if c.mode&mouseModeOn == 0 {
+			c.irq = 0
+		}
+		c.storeHole(mouseHoleMode, a)
+	case mouseServe:
+		status := c.loadHole(mouseHoleStatus)&^(mouseStatusMoveInt|mouseStatusButtonInt|mouseStatusVBLInt) | c.irq
+		c.storeHole(mouseHoleStatus, status)
+		irq := c.irq
+		c.irq = 0
+		if irq == 0 {
+			return 1
+		}
+	case mouseRead:
+		status := c.loadHole(mouseHoleStatus) & (mouseStatusMoveInt | mouseStatusButtonInt | mouseStatusVBLInt)
+		if c.down {
+			status |= mouseStatusButton
+		}
+		if c.last {
+			status |= mouseStatusLast
+		}
+		if c.moved {
+			status |= mouseStatusMoved
+		}
+		c.storeHole(mouseHoleStatus, status)
+		c.storePosition()
+		c.last, c.moved = c.down, false
+	case mouseClear:
+		c.x, c.y = 0, 0
+		c.storePosition()
+	case mousePos:
+		c.x = int(int16(uint16(c.loadHole(mouseHoleXHigh))<<8 | uint16(c.loadHole(mouseHoleXLow))))
+		c.y = int(int16(uint16(c.loadHole(mouseHoleYHigh))<<8 | uint16(c.loadHole(mouseHoleYLow))))
+	case mouseClamp:
+		mem := c.apple2.mmu
+		lo := int(int16(uint16(mem.LoadByte(mouseHoleXHigh))<<8 | uint16(mem.LoadByte(mouseHoleXLow))))
+		hi := int(int16(uint16(mem.LoadByte(mouseHoleYHigh))<<8 | uint16(mem.LoadByte(mouseHoleYLow))))
+		switch a {
+		case 0:
+			c.minX, c.maxX = lo, hi
+			c.x = clamp(c.x, lo, hi)
+		case 1:
+			c.minY, c.maxY = lo, hi
+			c.y = clamp(c.y, lo, hi)
+		default:
+			return 1
+		}
+	case mouseHome:
+		c.x, c.y = c.minX, c.minY
+		c.storePosition()
+	case mouseInit:
+		c.init()
+		c.storeHole(mouseHoleStatus, 0)
+		c.storeHole(mouseHoleMode, 0)
+		c.storePosition()
+	}
+	return 0
+}
+
+// storePosition stores the mouse position in the screen holes.
+func (c *appleMouseCard) storePosition() {
+	c.storeHole(mouseHoleXLow, byte(c.x))
+	c.storeHole(mouseHoleXHigh, byte(c.x>>8))
+	c.storeHole(mouseHoleYLow, byte(c.y))
+	c.storeHole(mouseHoleYHigh, byte(c.y>>8))
+}
+
+func (c *appleMouseCard) loadHole(hole uint16) byte {
+	return c.apple2.mmu.LoadByte(hole + uint16(c.slot))
+}
+
+func (c *appleMouseCard) storeHole(hole uint16, v byte) {
+	c.apple2.mmu.StoreByte(hole+uint16(c.slot), v)
+}
+
+func (c *appleMouseCard) saveState(sw *stateWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sw.Tag("AMOU")
+	for _, v := range []int{c.dx, c.dy, c.x, c.y, c.minX, c.maxX, c.minY, c.maxY} {
+		sw.Int(v)
+	}
+	for _, b := range []bool{c.button, c.down, c.last, c.moved} {
+		sw.Bool(b)
+	}
+	sw.Byte(c.mode)
+	sw.Byte(c.irq)
+	sw.Byte(c.result)
+}
+
+func (c *appleMouseCard) loadState(sr *stateReader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sr.Tag("AMOU")
+	for _, v := range []*int{&c.dx, &c.dy, &c.x, &c.y, &c.minX, &c.maxX, &c.minY, &c.maxY} {
+		*v = sr.Int()
+	}
+	for _, b := range []*bool{&c.button, &c.down, &c.last, &c.moved} {
+		*b = sr.Bool()
+	}
+	c.mode = sr.Byte()
+	c.irq = sr.Byte()
+	c.result = sr.Byte()
+	c.updateIRQ()
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+// callMouse makes a firmware call to the mouse card in slot 4 and returns
+// the carry it returns.
+func callMouse(a *apple2, cmd int, acc byte) byte {
+	a.mmu.StoreByte(0xc0c0+uint16(cmd), acc)
+	return a.mmu.LoadByte(0xc0c0+mouseResult) & 1
+}
+
+func TestAppleMouseCard(t *testing.T) {
+	a := newApple2()
+	c := newAppleMouseCard(a, 4)
+	a.sm.Insert(4, c)
+	if a.MouseInput() != &c.mouseInput {
+		t.Fatalf("Expected the card to take mouse input\n")
+	}
+
+	// The firmware identifies a mouse and its entry points store to the
+	// card's I/O space.
+	if a.mmu.LoadByte(0xc40c) != 0x20 || a.mmu.LoadByte(0xc4fb) != 0xd6 {
+		t.Errorf("Expected AppleMouse signature bytes\n")
+	}
+	entry := 0xc400 + uint16(a.mmu.LoadByte(0xc412+mouseRead))
+	if a.mmu.LoadByte(entry) != 0x8d || a.mmu.LoadByte(entry+1) != 0xc0+mouseRead {
+		t.Errorf("Expected READMOUSE to store to $C0C%X\n", mouseRead)
+	}
+
+	callMouse(a, mouseInit, 0)
+	if callMouse(a, mouseSet, 0x10) != 1 {
+		t.Errorf("Expected an invalid mode to fail\n")
+	}
+	callMouse(a, mouseSet, mouseModeOn|mouseModeVBLInt)
+	if v := a.mmu.LoadByte(mouseHoleMode + 4); v != 0x09 {
+		t.Errorf("Expected mode 09 in the screen hole, got %02X\n", v)
+	}
+
+	// Clamp X to 10..20.
+	a.mmu.StoreByte(mouseHoleXLow, 10)
+	a.mmu.StoreByte(mouseHoleXHigh, 0)
+	a.mmu.StoreByte(mouseHoleYLow, 20)
+	a.mmu.StoreByte(mouseHoleYHigh, 0)
+	callMouse(a, mouseClamp, 0)
+
+	// Motion is delivered at the next vertical blanking, which interrupts.
+	c.Move(50, 3)
+	c.SetButton(true)
+	if c.IRQ() {
+		t.Errorf("Unexpected interrupt before vertical blanking\n")
+	}
+	a.cpu.Cycles += cyclesPerFrame
+	a.sched.Run()
+	if !c.IRQ() || !a.ints.Asserting(c) {
+		t.Fatalf("Expected a VBL interrupt\n")
+	}
+	if callMouse(a, mouseServe, 0) != 0 || c.IRQ() || a.ints.IRQ() {
+		t.Errorf("Expected SERVEMOUSE to claim the interrupt\n")
+	}
+	callMouse(a, mouseRead, 0)
+	if x, y := a.mmu.LoadByte(mouseHoleXLow+4), a.mmu.LoadByte(mouseHoleYLow+4); x != 20 || y != 3 {
+		t.Errorf("Expected position 20,3, got %d,%d\n", x, y)
+	}
+	want := byte(mouseStatusButton | mouseStatusMoved | mouseStatusVBLInt)
+	if v := a.mmu.LoadByte(mouseHoleStatus + 4); v != want {
+		t.Errorf("Expected status %02X, got %02X\n", want, v)
+	}
+	if callMouse(a, mouseServe, 0) != 1 {
+		t.Errorf("Expected SERVEMOUSE to reject a foreign interrupt\n")
+	}
+
+	callMouse(a, mouseHome, 0)
+	if x, y := a.mmu.LoadByte(mouseHoleXLow+4), a.mmu.LoadByte(mouseHoleYLow+4); x != 10 || y != 0 {
+		t.Errorf("Expected home position 10,0, got %d,%d\n", x, y)
+	}
+	a.mmu.StoreByte(mouseHoleXLow+4, 15)
+	a.mmu.StoreByte(mouseHoleYHigh+4, 1)
+	callMouse(a, mousePos, 0)
+	if c.x != 15 || c.y != 256 {
+		t.Errorf("Expected POSMOUSE to move to 15,256, got %d,%d\n", c.x, c.y)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Network device CONTROL and STATUS codes, as used by FujiNet.
+const (
+	netControlOpen  = 'O' // open the URL in the control list
+	netControlClose = 'C' // close the connection
+	netStatus       = 'S' // bytes waiting, connected flag and error
+)
+
+// Network device error codes, as reported by the status call.
+const (
+	netErrOK         = 1
+	netErrNotOpen    = 133
+	netErrEOF        = 136
+	netErrConnection = 146
+)
+
+// netDialTimeout limits the time taken to open a connection.
+const netDialTimeout = 10 * time.Second
+
+// A netDevice is a SmartPort character device in the spirit of FujiNet's
+// network device, through which Apple II software fetches data over the
+// host's network. A CONTROL call with code 'O' opens a URL given as the
+// mode byte, a translation byte and a null-terminated devicespec, such as
+// "N:HTTP://EXAMPLE.COM/" or "N:TCP://HOST:PORT". HTTP and HTTPS URLs are
+// fetched with a GET request, and TCP URLs connect a stream. READ calls
+// return the data received so far, WRITE calls send data over TCP, and a
+// STATUS call with code 'S' returns the number of bytes waiting, whether
+// the connection is still open, and the last error.
+type netDevice struct {
+	mu        sync.Mutex
+	gen       int // incremented on close, to retire the connection's goroutines
+	open      bool
+	conn      net.Conn
+	rx        []byte
+	connected bool
+	err       byte
+}
+
+func newNetDevice() *netDevice {
+	return &netDevice{err: netErrNotOpen}
+}
+
+func (d *netDevice) Name() string     { return "NETWORK" }
+func (d *netDevice) DeviceType() byte { return spTypeNetwork }
+
+func (d *netDevice) GeneralStatus() byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := byte(spStatusRead | spStatusWrite | spStatusOnline)
+	if d.open {
+		s |= spStatusOpen
+	}
+	return s
+}
+
+func (d *netDevice) Status(code byte) ([]byte, byte) {
+	if code != netStatus {
+		return nil, spErrBadCtl
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.rx)
+	if n > 0xffff {
+		n = 0xffff
+	}
+	return []byte{byte(n), byte(n >> 8), byte(boolInt(d.connected)), d.err}, spErrOK
+}
+
+func (d *netDevice) Control(code byte, data []byte) byte {
+	switch code {
+	case netControlOpen:
+		if len(data) < 3 {
+			return spErrBadCtl
+		}
+		spec := string(data[2:])
+		if i := strings.IndexByte(spec, 0); i >= 0 {
+			spec = spec[:i]
+		}
+		return d.Open(spec)
+	case netControlClose:
+		d.Close()
+		return spErrOK
+	}
+	return spErrBadCtl
+}
+
+// Open opens a devicespec, closing any previous connection.
+func (d *netDevice) Open(spec string) byte {
+	d.Close()
+
+	// Strip the device name, such as N: or N1:.
+	if i := strings.IndexByte(spec, ':'); i > 0 && i <= 2 && (spec[0] == 'N' || spec[0] == 'n') {
+		spec = spec[i+1:]
+	}
+	scheme := ""
+	if i := strings.Index(spec, "://"); i > 0 {
+		scheme = strings.ToLower(spec[:i])
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	gen := d.gen
+	switch scheme {
+	case "http", "https":
+		url := scheme + spec[len(scheme):]
+		go d.fetch(gen, url)
+	case "tcp":
+		go d.dial(gen, spec[len("tcp://"):])
+	default:
+		return spErrBadCtl
+	}
+	d.open, d.connected, d.err = true, true, netErrOK
+	return spErrOK
+}
+
+// Close closes the connection.
+func (d *netDevice) Close() {
+	d.mu.Lock()
+	conn := d.conn
+	d.gen++
+	d.open, d.connected, d.conn, d.rx, d.err = false, false, nil, nil, netErrNotOpen
+	d.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// fetch reads the body of a URL into the receive buffer.
+func (d *netDevice) fetch(gen int, url string) {
+	client := http.Client{Timeout: netDialTimeout}
+	resp, err := client.Get(url)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		d.finish(gen, netErrConnection)
+		return
+	}
+	defer resp.Body.Close()
+	d.receive(gen, resp.Body)
+}
+
+// dial connects to a TCP address and receives from it.
+func (d *netDevice) dial(gen int, addr string) {
+	conn, err := net.DialTimeout("tcp", addr, netDialTimeout)
+	if err != nil {
+		d.finish(gen, netErrConnection)
+		return
+	}
+	d.mu.Lock()
+	if d.gen != gen {
+		d.mu.Unlock()
+		conn.Close()
+		return
+	}
+	d.conn = conn
+	d.mu.Unlock()
+	d.receive(gen, conn)
+}
+
+// receive appends the data read from r to the receive buffer until the
+// end of the stream.
+func (d *netDevice) receive(gen int, r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		d.mu.Lock()
+		if d.gen != gen {
+			d.mu.Unlock()
+			return
+		}
+		d.rx = append(d.rx, buf[:n]...)
+		d.mu.Unlock()
+		if err != nil {
+			d.finish(gen, netErrEOF)
+			return
+		}
+	}
+}
+
+// finish marks the connection closed with an error, unless it has been
+// replaced.
+func (d *netDevice) finish(gen int, err byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gen == gen {
+		d.connected, d.err = false, err
+	}
+}
+
+func (d *netDevice) Read(n int) ([]byte, byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.open {
+		return nil, spErrOffline
+	}
+	if n > len(d.rx) {
+		n = len(d.rx)
+	}
+	data := append([]byte(nil), d.rx[:n]...)
+	d.rx = d.rx[n:]
+	return data, spErrOK
+}
+
+func (d *netDevice) Write(data []byte) byte {
+	d.mu.Lock()
+	conn := d.conn
+	d.mu.Unlock()
+	if conn == nil {
+		return spErrOffline
+	}
+	conn.SetWriteDeadline(time.Now().Add(netDialTimeout))
+	if _, err := conn.Write(data); err != nil {
+		return spErrIO
+	}
+	return spErrOK
+}
@@ -0,0 +1,143 @@
+package main
+
+import "strings"
+
+const (
+	defaultPasteRate    = 60.0                // default paste rate in characters per second
+	pastePromptTimeout  = 2 * cpuClockRate    // cycles to wait for an input prompt after a CR
+	promptKeyInStart    = uint16(0xfd1b)      // start of the monitor's KEYIN routine
+	promptKeyInEnd      = uint16(0xfd30)      // end of the monitor's KEYIN routine
+	promptFirmwareStart = uint16(0xc100)      // start of the slot firmware address range
+	promptFirmwareEnd   = uint16(0xd000)      // end of the slot firmware address range
+	pasteCR             = byte(keyCodeReturn) // key code that ends an input line
+)
+
+// pasteOptions control how pasted text is typed into the machine.
+type pasteOptions struct {
+	rate          float64 // characters per second
+	uppercase     bool    // true = convert lowercase letters to uppercase
+	waitForPrompt bool    // true = after each CR, wait until the machine is reading input again
+}
+
+func defaultPasteOptions() pasteOptions {
+	return pasteOptions{
+		rate:          defaultPasteRate,
+		uppercase:     true,
+		waitForPrompt: true,
+	}
+}
+
+// pasteState tracks the progress of a paste operation.
+type pasteState struct {
+	opts    pasteOptions
+	codes   []byte // key codes waiting to be typed
+	next    uint64 // earliest CPU cycle at which the next key may be typed
+	waiting bool   // true while waiting for an input prompt
+	waitEnd uint64 // CPU cycle at which waiting for a prompt times out
+}
+
+// PasteText types the string into the machine as though it were entered
+// at the keyboard. Line endings are converted to carriage returns, and
+// characters with no Apple2 equivalent are dropped. It is safe to call
+// from any goroutine.
+func (kb *keyboard) PasteText(s string) {
+	if kb.apple2.in.Replaying() {
+		return
+	}
+
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	if kb.paste.opts.uppercase {
+		s = strings.ToUpper(s)
+	}
+	s = strings.Replace(s, "\r\n", "\n", -1)
+
+	var codes []byte
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\r':
+			codes = append(codes, pasteCR)
+		case r == '\t':
+			codes = append(codes, keyCodeTab)
+		case r >= 0x20 && r < 0x7f:
+			codes = append(codes, byte(r))
+		}
+	}
+	kb.paste.codes = append(kb.paste.codes, codes...)
+	kb.pasted = append(kb.pasted, codes...)
+}
+
+// SetPasteOptions changes how subsequently typed pasted characters are
+// delivered.
+func (kb *keyboard) SetPasteOptions(opts pasteOptions) {
+	if opts.rate <= 0 {
+		opts.rate = defaultPasteRate
+	}
+
+	kb.mu.Lock()
+	kb.paste.opts = opts
+	kb.mu.Unlock()
+}
+
+// CancelPaste discards any pasted text that hasn't been typed yet.
+func (kb *keyboard) CancelPaste() {
+	kb.mu.Lock()
+	kb.paste.codes = kb.paste.codes[:0]
+	kb.paste.waiting = false
+	kb.mu.Unlock()
+}
+
+// Pasting returns true if pasted text is still being typed.
+func (kb *keyboard) Pasting() bool {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	return len(kb.paste.codes) > 0
+}
+
+// updatePaste types the next pasted character if the previous key has been
+// read and enough time has elapsed. When polled is true, the keyboard data
+// register is being read by software, which indicates whether the machine
+// is waiting for input.
+func (kb *keyboard) updatePaste(polled bool) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	p := &kb.paste
+	if len(p.codes) == 0 || (kb.keydata&keyStrobe) != 0 {
+		return
+	}
+
+	now := kb.apple2.cpu.Cycles
+	if now < p.next {
+		return
+	}
+
+	// After a CR, the program may take a long time to process the line.
+	// Hold further input until the machine is back in an input routine.
+	if p.waiting {
+		if !(polled && kb.atInputPrompt()) && now < p.waitEnd {
+			return
+		}
+		p.waiting = false
+	}
+
+	v := p.codes[0]
+	p.codes = p.codes[:copy(p.codes, p.codes[1:])]
+	kb.SetKey(v)
+	p.next = now + uint64(cpuClockRate/p.opts.rate)
+
+	if v == pasteCR && p.opts.waitForPrompt {
+		p.waiting = true
+		p.waitEnd = now + pastePromptTimeout
+	}
+}
+
+// atInputPrompt returns true if the instruction currently accessing the
+// keyboard is part of the monitor's KEYIN routine or slot firmware input
+// routines such as those of the 80-column card.
+func (kb *keyboard) atInputPrompt() bool {
+	pc := kb.apple2.cpu.LastPC
+	return (pc >= promptKeyInStart && pc < promptKeyInEnd) ||
+		(pc >= promptFirmwareStart && pc < promptFirmwareEnd)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writePDFFile writes printed pages to a PDF file, one US letter page per
+// image, each image scaled to fill its page.
+func writePDFFile(filename string, pages []*image.Gray) error {
+	var b bytes.Buffer
+	var offsets []int
+	object := func(format string, args ...interface{}) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\nendobj\n")
+	}
+
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 3+i*3))
+	}
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	for i, page := range pages {
+		n := 3 + i*3
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>", n+2, n+1)
+
+		content := "q 612 0 0 792 0 0 cm /Im0 Do Q"
+		object("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		r := page.Bounds()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := page.PixOffset(r.Min.X, y)
+			zw.Write(page.Pix[i : i+r.Dx()])
+		}
+		zw.Close()
+		object("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+			r.Dx(), r.Dy(), z.Len(), z.Bytes())
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return os.WriteFile(filename, b.Bytes(), 0644)
+}
+
+// writePNGPages writes each printed page to its own PNG file, named by
+// adding the page number to the filename, as in printout-1.png.
+func writePNGPages(filename string, pages []*image.Gray) error {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	for i, page := range pages {
+		file, err := os.Create(fmt.Sprintf("%s-%d%s", base, i+1, ext))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(file, page)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
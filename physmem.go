@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A memSpace names a physical memory that PeekPhys and PokePhys access
+// regardless of how the soft switches map it into the CPU's address space.
+type memSpace int
+
+const (
+	memSpaceMain   memSpace = iota // main RAM, with language card bank 2 at $D000..$DFFF
+	memSpaceLC1                    // main language card bank 1 at $D000..$DFFF
+	memSpaceAux                    // aux RAM, with language card bank 2 at $D000..$DFFF
+	memSpaceAuxLC1                 // aux language card bank 1 at $D000..$DFFF
+	memSpaceROM                    // system ROM at $C000..$FFFF
+)
+
+var memSpaceNames = []string{"main", "lc1", "aux", "auxlc1", "rom"}
+
+func (s memSpace) String() string {
+	return memSpaceNames[s]
+}
+
+// A physAddr is an address within a physical memory. The bank selects a
+// RamWorks bank for the aux spaces and a ROM bank for the ROM space.
+type physAddr struct {
+	space memSpace
+	bank  int
+	addr  uint16
+}
+
+func (p physAddr) String() string {
+	if p.bank != 0 {
+		return fmt.Sprintf("%s.%d:%04X", p.space, p.bank, p.addr)
+	}
+	return fmt.Sprintf("%s:%04X", p.space, p.addr)
+}
+
+var (
+	errPhysSpace = errors.New("unknown memory space")
+	errPhysBank  = errors.New("no such memory bank")
+	errPhysAddr  = errors.New("address outside the memory space")
+)
+
+// parsePhysAddr parses a physical address of the form space[.bank]:addr,
+// such as "aux.3:0400" or "rom:F800". The address is hexadecimal.
+func parsePhysAddr(s string) (physAddr, error) {
+	var p physAddr
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return p, errPhysSpace
+	}
+	name, addr := strings.ToLower(s[:i]), s[i+1:]
+	if j := strings.IndexByte(name, '.'); j >= 0 {
+		bank, err := strconv.Atoi(name[j+1:])
+		if err != nil || bank < 0 {
+			return p, errPhysBank
+		}
+		name, p.bank = name[:j], bank
+	}
+	found := false
+	for sp, n := range memSpaceNames {
+		if name == n {
+			p.space, found = memSpace(sp), true
+		}
+	}
+	if !found {
+		return p, errPhysSpace
+	}
+	a, err := parseDebugValue(addr, 0xffff)
+	if err != nil {
+		return p, err
+	}
+	p.addr = uint16(a)
+	return p, nil
+}
+
+// physByte returns a pointer to the byte of physical memory at an address.
+// The I/O space at $C000..$CFFF holds no RAM, and the bank 1 spaces cover
+// only $D000..$DFFF.
+func (m *mmu) physByte(p physAddr) (*byte, error) {
+	var ram []byte
+	switch p.space {
+	case memSpaceMain, memSpaceLC1:
+		if p.bank != 0 {
+			return nil, errPhysBank
+		}
+		ram = m.mainRAM
+	case memSpaceAux, memSpaceAuxLC1:
+		if p.bank >= len(m.auxBanks) {
+			return nil, errPhysBank
+		}
+		ram = m.auxBanks[p.bank]
+	case memSpaceROM:
+		if p.bank >= len(m.romBanks) {
+			return nil, errPhysBank
+		}
+		if p.addr < 0xc000 {
+			return nil, errPhysAddr
+		}
+		return &m.romBanks[p.bank][p.addr-0xc000], nil
+	default:
+		return nil, errPhysSpace
+	}
+
+	switch p.space {
+	case memSpaceLC1, memSpaceAuxLC1:
+		// Bank 1 is held in the otherwise unused $C000..$CFFF.
+		if p.addr < 0xd000 || p.addr >= 0xe000 {
+			return nil, errPhysAddr
+		}
+		return &ram[p.addr-0x1000], nil
+	default:
+		if p.addr >= 0xc000 && p.addr < 0xd000 {
+			return nil, errPhysAddr
+		}
+		return &ram[p.addr], nil
+	}
+}
+
+// PeekPhys reads physical memory without triggering soft switches or
+// watchpoints, whether or not the memory is currently mapped.
+func (m *mmu) PeekPhys(p physAddr) (byte, error) {
+	b, err := m.physByte(p)
+	if err != nil {
+		return 0, err
+	}
+	return *b, nil
+}
+
+// PokePhys writes physical memory without triggering soft switches or
+// watchpoints, whether or not the memory is currently mapped. Writes to
+// ROM patch it until the ROM is reloaded.
+func (m *mmu) PokePhys(p physAddr, v byte) error {
+	b, err := m.physByte(p)
+	if err != nil {
+		return err
+	}
+	if *b == v {
+		return nil
+	}
+	*b = v
+
+	// Only main memory and aux bank 0 are displayed.
+	if p.space == memSpaceMain || (p.space == memSpaceAux && p.bank == 0) {
+		dirty := &m.apple2.ds.dirty
+		switch {
+		case p.addr >= 0x0400 && p.addr < 0x0c00:
+			dirty.markText(int(p.addr-0x0400)>>10, p.addr&0x3ff)
+		case p.addr >= 0x2000 && p.addr < 0x6000:
+			dirty.markHiRes(int(p.addr-0x2000)>>13, p.addr&0x1fff)
+		}
+	}
+	return nil
+}
+
+// physReadAddr returns the physical address from which the CPU reads the
+// byte at addr as the soft switches currently map it, or false if the CPU
+// reads the I/O page or a card's ROM there.
+func (m *mmu) physReadAddr(addr uint16) (physAddr, bool) {
+	b := m.pages[addr>>8].read
+	if b == nil {
+		return physAddr{}, false
+	}
+	aux := b == &m.banks[bankTypeAux][b.id]
+	switch b.id {
+	case bankSystemCXROM, bankSystemDEFROM, bankSystemC3ROM:
+		return physAddr{memSpaceROM, m.romBank, addr}, true
+	case bankIOSwitches, bankSlotROM, bankExpansionROM:
+		return physAddr{}, false
+	case bankLangCardDX1RAM:
+		if aux {
+			return physAddr{memSpaceAuxLC1, m.auxBank, addr}, true
+		}
+		return physAddr{memSpaceLC1, 0, addr}, true
+	}
+	if aux {
+		return physAddr{memSpaceAux, m.auxBank, addr}, true
+	}
+	return physAddr{memSpaceMain, 0, addr}, true
+}
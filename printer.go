@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A parallelPrinterCard emulates a parallel printer interface card. Each
+// byte written to its data register at offset 0 of the slot I/O space is
+// sent to the printer, which is never busy.
+//
+// The card's firmware is built in: PR#n or a call to $Cn00 sends the
+// character in the accumulator to the printer and then echoes it to the
+// screen through the monitor's COUT1 routine, as the Apple Parallel
+// Interface card does.
+type parallelPrinterCard struct {
+	apple2   *apple2
+	out      io.WriteCloser
+	firmware [256]byte
+}
+
+func newParallelPrinterCard(apple2 *apple2, slot int, out io.WriteCloser) *parallelPrinterCard {
+	c := &parallelPrinterCard{apple2: apple2, out: out}
+	data := uint16(0xc080 + slot<<4)
+	copy(c.firmware[:], []byte{
+		0x48,                              // PHA
+		0x8d, byte(data), byte(data >> 8), // STA $C0n0
+		0x68,             // PLA
+		0x4c, 0xf0, 0xfd, // JMP COUT1
+	})
+	return c
+}
+
+func (c *parallelPrinterCard) LoadIO(addr uint16) byte {
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *parallelPrinterCard) StoreIO(addr uint16, v byte) {
+	if addr == 0 && c.out != nil {
+		c.out.Write([]byte{v})
+	}
+}
+
+func (c *parallelPrinterCard) LoadROM(addr uint16) byte {
+	return c.firmware[addr]
+}
+
+func (c *parallelPrinterCard) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// Close finishes the printout.
+func (c *parallelPrinterCard) Close() error {
+	if c.out == nil {
+		return nil
+	}
+	return c.out.Close()
+}
+
+// openPrintout creates a printout file. Files with a .pdf extension hold
+// the pages rendered by an ImageWriter emulation, and files with a .png
+// extension are written once per page, with the page number added to
+// their names. All other files capture the printed text.
+func openPrintout(filename string) (io.WriteCloser, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf":
+		return newImageWriter(func(pages []*image.Gray) error {
+			return writePDFFile(filename, pages)
+		}), nil
+	case ".png":
+		return newImageWriter(func(pages []*image.Gray) error {
+			return writePNGPages(filename, pages)
+		}), nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newTextPrinter(f), nil
+}
+
+// A textPrinter captures printed text to a file. The high bit of each
+// character is ignored, carriage returns become newlines, and escape
+// sequences and other control characters are dropped.
+type textPrinter struct {
+	f   *os.File
+	w   *bufio.Writer
+	cr  bool // last character was a carriage return
+	esc bool // last character was ESC
+}
+
+func newTextPrinter(f *os.File) *textPrinter {
+	return &textPrinter{f: f, w: bufio.NewWriter(f)}
+}
+
+func (p *textPrinter) Write(b []byte) (int, error) {
+	for _, c := range b {
+		c &= 0x7f
+		cr := p.cr
+		p.cr = false
+		switch {
+		case p.esc:
+			p.esc = false
+		case c == 0x1b:
+			p.esc = true
+		case c == '\r':
+			p.w.WriteByte('\n')
+			p.cr = true
+		case c == '\n':
+			if !cr {
+				p.w.WriteByte('\n')
+			}
+		case c == '\f' || c == '\t' || c >= 0x20 && c < 0x7f:
+			p.w.WriteByte(c)
+		}
+	}
+	return len(b), nil
+}
+
+func (p *textPrinter) Close() error {
+	err := p.w.Flush()
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Printer returns the parallel printer card in a slot, or nil if there is
+// none.
+func (a *apple2) Printer(slot int) *parallelPrinterCard {
+	c, _ := a.sm.Card(slot).(*parallelPrinterCard)
+	return c
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParallelPrinterCard(t *testing.T) {
+	a := newApple2()
+	file := filepath.Join(t.TempDir(), "printout.txt")
+	out, err := openPrintout(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newParallelPrinterCard(a, 1, out)
+	a.sm.Insert(1, p)
+
+	// The firmware stores the character to the card's data register.
+	if v := a.mmu.LoadByte(0xc101); v != 0x8d || a.mmu.LoadByte(0xc102) != 0x90 || a.mmu.LoadByte(0xc103) != 0xc0 {
+		t.Errorf("Expected STA $C090 in the firmware, got %02X\n", v)
+	}
+
+	for _, c := range []byte("\xc8I\x8d\x8a\x1bQ\tA\r") {
+		a.mmu.StoreByte(0xc090, c)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	if string(data) != "HI\n\tA\n" {
+		t.Errorf("Expected captured text, got %q\n", data)
+	}
+	if a.Printer(1) != p || a.Printer(2) != nil {
+		t.Errorf("Printer not found in its slot\n")
+	}
+}
+
+func TestImageWriter(t *testing.T) {
+	var pages []*image.Gray
+	w := newImageWriter(func(p []*image.Gray) error {
+		pages = p
+		return nil
+	})
+
+	// A line of text in pica, a graphics column, then a second page.
+	w.Write([]byte("I\r"))
+	w.Write([]byte("\x1bG0002\x01\x80\f\x1bR003-"))
+	w.Close()
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %d\n", len(pages))
+	}
+
+	// The 'I' has its vertical stroke in the middle of the cell.
+	cell := printerDPI / 10
+	if pages[0].GrayAt(cell/2-1, 4).Y != 0 || pages[0].GrayAt(0, 4).Y != 0xff {
+		t.Errorf("Expected the stroke of an I\n")
+	}
+
+	// The graphics columns are on the second line, the first with its top
+	// dot printed and the second its bottom dot.
+	y := printerDPI / 6
+	if pages[0].GrayAt(0, y).Y != 0 || pages[0].GrayAt(3, y+14).Y != 0 || pages[0].GrayAt(0, y+4).Y != 0xff {
+		t.Errorf("Expected graphics dots\n")
+	}
+
+	// The repeated dashes are on the second page, following the graphics.
+	if pages[1].GrayAt(cell*5/2+1, 6).Y != 0 {
+		t.Errorf("Expected a third dash\n")
+	}
+
+	dir := t.TempDir()
+	if err := writePDFFile(filepath.Join(dir, "out.pdf"), pages); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "out.pdf"))
+	if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.Contains(data, []byte("/Count 2")) {
+		t.Errorf("Invalid PDF output\n")
+	}
+	if err := writePNGPages(filepath.Join(dir, "out.png"), pages); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"out-1.png", "out-2.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected page file %s\n", name)
+		}
+	}
+}
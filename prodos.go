@@ -0,0 +1,718 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProDOS volume layout.
+const (
+	prodosBlockSize      = 512
+	prodosVolumeDirBlock = 2    // key block of the volume directory
+	prodosVolumeDirSize  = 4    // blocks in the volume directory
+	prodosEntryLength    = 0x27 // bytes per directory entry
+	prodosEntriesPerBlk  = 0x0d // directory entries per block
+	prodosNameLength     = 15   // maximum characters in a file name
+	prodosIndexPointers  = 256  // block pointers in an index block
+	prodosAccess         = 0xc3 // destroy, rename, write and read enabled
+	prodosBitsPerBlock   = prodosBlockSize * 8
+)
+
+// ProDOS storage types, stored in the high nibble of the first byte of a
+// directory entry.
+const (
+	prodosDeleted    = 0x0
+	prodosSeedling   = 0x1 // one data block
+	prodosSapling    = 0x2 // an index block of up to 256 data blocks
+	prodosTree       = 0x3 // a master index block of up to 128 index blocks
+	prodosSubdir     = 0xd // subdirectory file
+	prodosSubdirHead = 0xe // subdirectory header
+	prodosVolumeHead = 0xf // volume directory header
+)
+
+// Common ProDOS file types.
+const (
+	prodosTypeTXT = 0x04
+	prodosTypeBIN = 0x06
+	prodosTypeDIR = 0x0f
+	prodosTypeINT = 0xfa
+	prodosTypeBAS = 0xfc
+	prodosTypeVAR = 0xfd
+	prodosTypeREL = 0xfe
+	prodosTypeSYS = 0xff
+)
+
+var prodosTypeNames = map[byte]string{
+	prodosTypeTXT: "TXT",
+	prodosTypeBIN: "BIN",
+	prodosTypeDIR: "DIR",
+	prodosTypeINT: "INT",
+	prodosTypeBAS: "BAS",
+	prodosTypeVAR: "VAR",
+	prodosTypeREL: "REL",
+	prodosTypeSYS: "SYS",
+}
+
+// prodosTypeName returns the name of a file type, or its number in
+// hexadecimal if it has no common name.
+func prodosTypeName(t byte) string {
+	if name, ok := prodosTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("$%02X", t)
+}
+
+// parseProDOSType parses a file type name or hexadecimal number.
+func parseProDOSType(s string) (byte, bool) {
+	for t, name := range prodosTypeNames {
+		if strings.EqualFold(s, name) {
+			return t, true
+		}
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 8)
+	return byte(v), err == nil
+}
+
+var (
+	errProDOSVolume   = errors.New("not a ProDOS volume")
+	errProDOSNotFound = errors.New("file not found")
+	errProDOSExists   = errors.New("file already exists")
+	errProDOSNotDir   = errors.New("not a directory")
+	errProDOSDiskFull = errors.New("disk full")
+	errProDOSDirFull  = errors.New("directory full")
+	errProDOSName     = errors.New("invalid file name")
+	errProDOSDamaged  = errors.New("damaged file or directory")
+	errProDOSTooLarge = errors.New("file too large")
+)
+
+// A prodosEntry describes a file in a ProDOS directory.
+type prodosEntry struct {
+	name       string
+	storage    byte // storage type
+	fileType   byte
+	auxType    uint16
+	keyBlock   int
+	blocksUsed int
+	eof        int // file length in bytes
+	modified   time.Time
+
+	block, offset int // location of the entry
+}
+
+// isDir returns true if the entry is a subdirectory.
+func (e *prodosEntry) isDir() bool {
+	return e.storage == prodosSubdir
+}
+
+// String returns a catalog line for the entry, in the manner of the
+// ProDOS CATALOG command.
+func (e prodosEntry) String() string {
+	mod := "<NO DATE>"
+	if !e.modified.IsZero() {
+		mod = strings.ToUpper(e.modified.Format("02-Jan-06 15:04"))
+	}
+	return fmt.Sprintf(" %-15s %-4s %6d  %-15s %8d  $%04X",
+		e.name, prodosTypeName(e.fileType), e.blocksUsed, mod, e.eof, e.auxType)
+}
+
+// A prodosVolume provides access to the files on a ProDOS volume. It works
+// on an image of the volume's blocks, such as a .po or .hdv file or the
+// sectors of a 5.25" disk read in ProDOS order, and changes are made to
+// the image in place.
+type prodosVolume struct {
+	data []byte
+	now  func() time.Time // clock for new files' dates
+}
+
+// openProDOS checks that an image of blocks holds a ProDOS volume.
+func openProDOS(data []byte) (*prodosVolume, error) {
+	if len(data) < (prodosVolumeDirBlock+1)*prodosBlockSize || len(data)%prodosBlockSize != 0 {
+		return nil, errProDOSVolume
+	}
+	v := &prodosVolume{data: data, now: time.Now}
+	hdr := v.block(prodosVolumeDirBlock)[4:]
+	if hdr[0]>>4 != prodosVolumeHead || hdr[0]&0x0f == 0 ||
+		hdr[0x1f] != prodosEntryLength || hdr[0x20] != prodosEntriesPerBlk ||
+		int(le16(hdr[0x25:])) > v.numBlocks() {
+		return nil, errProDOSVolume
+	}
+	bitmapBlocks := (v.TotalBlocks() + prodosBlockSize*8 - 1) / (prodosBlockSize * 8)
+	if le16(hdr[0x23:])+bitmapBlocks > v.numBlocks() {
+		return nil, errProDOSVolume
+	}
+	return v, nil
+}
+
+// formatProDOS returns an image of a newly formatted ProDOS volume with
+// the given name and number of blocks, and an empty volume directory.
+// The boot blocks are left blank.
+func formatProDOS(name string, blocks int) ([]byte, error) {
+	if !validProDOSName(name) {
+		return nil, errProDOSName
+	}
+	if blocks < 16 || blocks > 0xffff {
+		return nil, errProDOSVolume
+	}
+	v := &prodosVolume{data: make([]byte, blocks*prodosBlockSize), now: time.Now}
+
+	for i := 0; i < prodosVolumeDirSize; i++ {
+		b := v.block(prodosVolumeDirBlock + i)
+		if i > 0 {
+			put16(b[0:], prodosVolumeDirBlock+i-1)
+		}
+		if i < prodosVolumeDirSize-1 {
+			put16(b[2:], prodosVolumeDirBlock+i+1)
+		}
+	}
+
+	bitmap := prodosVolumeDirBlock + prodosVolumeDirSize
+	bitmapBlocks := (blocks + prodosBitsPerBlock - 1) / prodosBitsPerBlock
+	hdr := v.block(prodosVolumeDirBlock)[4:]
+	hdr[0] = prodosVolumeHead<<4 | byte(len(name))
+	copy(hdr[1:16], strings.ToUpper(name))
+	v.putDate(hdr[0x18:])
+	hdr[0x1e] = prodosAccess
+	hdr[0x1f] = prodosEntryLength
+	hdr[0x20] = prodosEntriesPerBlk
+	put16(hdr[0x23:], bitmap)
+	put16(hdr[0x25:], blocks)
+
+	for b := bitmap + bitmapBlocks; b < blocks; b++ {
+		v.setFree(b, true)
+	}
+	return v.data, nil
+}
+
+func le16(b []byte) int {
+	return int(b[0]) | int(b[1])<<8
+}
+
+func put16(b []byte, v int) {
+	b[0], b[1] = byte(v), byte(v>>8)
+}
+
+func (v *prodosVolume) numBlocks() int {
+	return len(v.data) / prodosBlockSize
+}
+
+func (v *prodosVolume) block(n int) []byte {
+	return v.data[n*prodosBlockSize : (n+1)*prodosBlockSize]
+}
+
+func (v *prodosVolume) header() []byte {
+	return v.block(prodosVolumeDirBlock)[4:]
+}
+
+// Name returns the volume name.
+func (v *prodosVolume) Name() string {
+	hdr := v.header()
+	return string(hdr[1 : 1+hdr[0]&0x0f])
+}
+
+// TotalBlocks returns the number of blocks on the volume.
+func (v *prodosVolume) TotalBlocks() int {
+	return le16(v.header()[0x25:])
+}
+
+func (v *prodosVolume) bitmapByte(b int) *byte {
+	bitmap := le16(v.header()[0x23:])
+	return &v.data[bitmap*prodosBlockSize+b/8]
+}
+
+func (v *prodosVolume) isFree(b int) bool {
+	return *v.bitmapByte(b)&(0x80>>uint(b&7)) != 0
+}
+
+func (v *prodosVolume) setFree(b int, free bool) {
+	p := v.bitmapByte(b)
+	if free {
+		*p |= 0x80 >> uint(b&7)
+	} else {
+		*p &^= 0x80 >> uint(b&7)
+	}
+}
+
+// FreeBlocks returns the number of unused blocks on the volume.
+func (v *prodosVolume) FreeBlocks() int {
+	n := 0
+	for b := 0; b < v.TotalBlocks(); b++ {
+		if v.isFree(b) {
+			n++
+		}
+	}
+	return n
+}
+
+// allocate reserves the first free block and clears it.
+func (v *prodosVolume) allocate() (int, error) {
+	for b := 0; b < v.TotalBlocks(); b++ {
+		if v.isFree(b) {
+			v.setFree(b, false)
+			blk := v.block(b)
+			for i := range blk {
+				blk[i] = 0
+			}
+			return b, nil
+		}
+	}
+	return 0, errProDOSDiskFull
+}
+
+// validProDOSName reports whether a file name is valid: 1 to 15 letters,
+// digits and periods, beginning with a letter.
+func validProDOSName(name string) bool {
+	if name == "" || len(name) > prodosNameLength {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := upper(name[i])
+		switch {
+		case c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// prodosDate decodes a ProDOS date and time. Years below 40 are taken to
+// be in the 2000s.
+func prodosDate(b []byte) time.Time {
+	date, tm := le16(b), le16(b[2:])
+	if date == 0 {
+		return time.Time{}
+	}
+	year := date >> 9
+	if year < 40 {
+		year += 100
+	}
+	return time.Date(1900+year, time.Month(date>>5&0x0f), date&0x1f, tm>>8&0x1f, tm&0x3f, 0, 0, time.Local)
+}
+
+func (v *prodosVolume) putDate(b []byte) {
+	t := v.now()
+	put16(b, (t.Year()%100)<<9|int(t.Month())<<5|t.Day())
+	put16(b[2:], t.Hour()<<8|t.Minute())
+}
+
+// A prodosDir locates a directory by its key block.
+type prodosDir struct {
+	key int
+}
+
+// dirBlocks calls fn for each block of a directory, in order, until fn
+// returns false.
+func (v *prodosVolume) dirBlocks(dir prodosDir, fn func(b int, blk []byte) bool) error {
+	for b, n := dir.key, 0; b != 0; n++ {
+		if b >= v.numBlocks() || n > v.numBlocks() {
+			return errProDOSDamaged
+		}
+		blk := v.block(b)
+		if !fn(b, blk) {
+			return nil
+		}
+		b = le16(blk[2:])
+	}
+	return nil
+}
+
+// entries returns the active entries of a directory.
+func (v *prodosVolume) entries(dir prodosDir) ([]prodosEntry, error) {
+	var entries []prodosEntry
+	err := v.dirBlocks(dir, func(b int, blk []byte) bool {
+		for i := 0; i < prodosEntriesPerBlk; i++ {
+			off := 4 + i*prodosEntryLength
+			e := blk[off : off+prodosEntryLength]
+			storage := e[0] >> 4
+			if storage == prodosDeleted || storage == prodosSubdirHead || storage == prodosVolumeHead {
+				continue
+			}
+			entries = append(entries, prodosEntry{
+				name:       string(e[1 : 1+e[0]&0x0f]),
+				storage:    storage,
+				fileType:   e[0x10],
+				keyBlock:   le16(e[0x11:]),
+				blocksUsed: le16(e[0x13:]),
+				eof:        le16(e[0x15:]) | int(e[0x17])<<16,
+				auxType:    uint16(le16(e[0x1f:])),
+				modified:   prodosDate(e[0x21:]),
+				block:      b,
+				offset:     off,
+			})
+		}
+		return true
+	})
+	return entries, err
+}
+
+// lookup finds the entry of a file given its path, a list of names
+// separated by slashes relative to the volume directory. It also returns
+// the directory containing the file.
+func (v *prodosVolume) lookup(path string) (prodosEntry, prodosDir, error) {
+	dir := prodosDir{prodosVolumeDirBlock}
+	names := splitProDOSPath(path)
+	if len(names) == 0 {
+		return prodosEntry{}, dir, errProDOSNotFound
+	}
+	for i, name := range names {
+		entries, err := v.entries(dir)
+		if err != nil {
+			return prodosEntry{}, dir, err
+		}
+		found := false
+		for _, e := range entries {
+			if !strings.EqualFold(e.name, name) {
+				continue
+			}
+			if i == len(names)-1 {
+				return e, dir, nil
+			}
+			if !e.isDir() {
+				return prodosEntry{}, dir, errProDOSNotDir
+			}
+			dir, found = prodosDir{e.keyBlock}, true
+			break
+		}
+		if !found {
+			break
+		}
+	}
+	return prodosEntry{}, dir, errProDOSNotFound
+}
+
+// splitProDOSPath splits a path into names, ignoring the volume name if
+// the path begins with a slash.
+func splitProDOSPath(path string) []string {
+	if strings.HasPrefix(path, "/") {
+		path = strings.TrimPrefix(path, "/")
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			path = path[i+1:]
+		} else {
+			path = ""
+		}
+	}
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// openDir returns the directory at a path, which is the volume directory
+// if the path is empty.
+func (v *prodosVolume) openDir(path string) (prodosDir, error) {
+	if len(splitProDOSPath(path)) == 0 {
+		return prodosDir{prodosVolumeDirBlock}, nil
+	}
+	e, _, err := v.lookup(path)
+	if err != nil {
+		return prodosDir{}, err
+	}
+	if !e.isDir() {
+		return prodosDir{}, errProDOSNotDir
+	}
+	return prodosDir{e.keyBlock}, nil
+}
+
+// ReadDir returns the files in the directory at a path. The volume
+// directory's path is "" or "/".
+func (v *prodosVolume) ReadDir(path string) ([]prodosEntry, error) {
+	dir, err := v.openDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return v.entries(dir)
+}
+
+// dataBlocks returns the data blocks of a file in order, with 0 for the
+// unallocated blocks of a sparse file, and the index blocks holding them.
+func (v *prodosVolume) dataBlocks(e prodosEntry) (data, index []int, err error) {
+	valid := func(b int) bool { return b < v.numBlocks() }
+	readIndex := func(b int) []int {
+		blk := v.block(b)
+		ptrs := make([]int, prodosIndexPointers)
+		for i := range ptrs {
+			ptrs[i] = int(blk[i]) | int(blk[prodosIndexPointers+i])<<8
+		}
+		return ptrs
+	}
+
+	if !valid(e.keyBlock) {
+		return nil, nil, errProDOSDamaged
+	}
+	switch e.storage {
+	case prodosSeedling:
+		data = []int{e.keyBlock}
+	case prodosSapling:
+		index = []int{e.keyBlock}
+		data = readIndex(e.keyBlock)
+	case prodosTree:
+		index = []int{e.keyBlock}
+		for _, ib := range readIndex(e.keyBlock)[:128] {
+			if ib == 0 {
+				data = append(data, make([]int, prodosIndexPointers)...)
+				continue
+			}
+			if !valid(ib) {
+				return nil, nil, errProDOSDamaged
+			}
+			index = append(index, ib)
+			data = append(data, readIndex(ib)...)
+		}
+	case prodosSubdir:
+		err := v.dirBlocks(prodosDir{e.keyBlock}, func(b int, _ []byte) bool {
+			data = append(data, b)
+			return true
+		})
+		return data, nil, err
+	default:
+		return nil, nil, errProDOSDamaged
+	}
+
+	for _, b := range data {
+		if !valid(b) {
+			return nil, nil, errProDOSDamaged
+		}
+	}
+	return data, index, nil
+}
+
+// ReadFile returns the contents of the file at a path, and its entry.
+// The unallocated blocks of a sparse file read as zeros.
+func (v *prodosVolume) ReadFile(path string) ([]byte, prodosEntry, error) {
+	e, _, err := v.lookup(path)
+	if err != nil {
+		return nil, e, err
+	}
+	if e.isDir() {
+		return nil, e, errProDOSNotDir
+	}
+	blocks, _, err := v.dataBlocks(e)
+	if err != nil {
+		return nil, e, err
+	}
+
+	data := make([]byte, e.eof)
+	for i := 0; i*prodosBlockSize < e.eof && i < len(blocks); i++ {
+		if blocks[i] != 0 {
+			copy(data[i*prodosBlockSize:], v.block(blocks[i]))
+		}
+	}
+	return data, e, nil
+}
+
+// newEntry finds a free entry in a directory, extending a subdirectory by
+// a block if it is full. It returns the entry and its location.
+func (v *prodosVolume) newEntry(dir prodosDir) (e []byte, block, offset int, err error) {
+	last := 0
+	err = v.dirBlocks(dir, func(b int, blk []byte) bool {
+		last = b
+		for i := 0; i < prodosEntriesPerBlk; i++ {
+			off := 4 + i*prodosEntryLength
+			if blk[off]>>4 == prodosDeleted {
+				e, block, offset = blk[off:off+prodosEntryLength], b, off
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil || e != nil {
+		return e, block, offset, err
+	}
+	if dir.key == prodosVolumeDirBlock {
+		return nil, 0, 0, errProDOSDirFull
+	}
+
+	b, err := v.allocate()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	put16(v.block(last)[2:], b)
+	put16(v.block(b)[0:], last)
+
+	// Account for the new block in the subdirectory's own entry.
+	hdr := v.block(dir.key)[4:]
+	parent := v.block(le16(hdr[0x23:]))
+	pe := parent[4+(int(hdr[0x25])-1)*prodosEntryLength:]
+	put16(pe[0x13:], le16(pe[0x13:])+1)
+	eof := le16(pe[0x15:]) + prodosBlockSize
+	put16(pe[0x15:], eof)
+	pe[0x17] = byte(eof >> 16)
+
+	return v.block(b)[4 : 4+prodosEntryLength], b, 4, nil
+}
+
+// create adds an entry for a new file to the directory holding path, and
+// returns it.
+func (v *prodosVolume) create(path string, storage, fileType byte) ([]byte, int, int, error) {
+	names := splitProDOSPath(path)
+	if len(names) == 0 || !validProDOSName(names[len(names)-1]) {
+		return nil, 0, 0, errProDOSName
+	}
+	name := strings.ToUpper(names[len(names)-1])
+	dir, err := v.openDir(strings.Join(names[:len(names)-1], "/"))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if _, _, err := v.lookup(strings.Join(names, "/")); err == nil {
+		return nil, 0, 0, errProDOSExists
+	} else if err != errProDOSNotFound {
+		return nil, 0, 0, err
+	}
+
+	e, block, offset, err := v.newEntry(dir)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for i := range e {
+		e[i] = 0
+	}
+	e[0] = storage<<4 | byte(len(name))
+	copy(e[1:16], name)
+	e[0x10] = fileType
+	v.putDate(e[0x18:])
+	e[0x1e] = prodosAccess
+	v.putDate(e[0x21:])
+	put16(e[0x25:], dir.key)
+
+	hdr := v.block(dir.key)[4:]
+	put16(hdr[0x21:], le16(hdr[0x21:])+1)
+	return e, block, offset, nil
+}
+
+// WriteFile creates a file at a path holding data. Blocks of zeros after
+// the first are left unallocated, making a sparse file.
+func (v *prodosVolume) WriteFile(path string, fileType byte, auxType uint16, data []byte) error {
+	nblocks := (len(data) + prodosBlockSize - 1) / prodosBlockSize
+	if nblocks == 0 {
+		nblocks = 1
+	}
+	if nblocks > 128*prodosIndexPointers {
+		return errProDOSTooLarge
+	}
+
+	// Count the blocks needed before allocating any.
+	zero := func(i int) bool {
+		if i == 0 {
+			return false
+		}
+		end := (i + 1) * prodosBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		for _, c := range data[i*prodosBlockSize : end] {
+			if c != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	storage, need := byte(prodosSeedling), 0
+	var indexUsed [128]bool
+	for i := 0; i < nblocks; i++ {
+		if !zero(i) {
+			need++
+			indexUsed[i/prodosIndexPointers] = true
+		}
+	}
+	switch {
+	case nblocks > prodosIndexPointers:
+		storage = prodosTree
+		need++
+		for _, used := range indexUsed {
+			if used {
+				need++
+			}
+		}
+	case nblocks > 1:
+		storage = prodosSapling
+		need++
+	}
+	// Allow for a block to extend the directory.
+	if need+1 > v.FreeBlocks() {
+		return errProDOSDiskFull
+	}
+
+	e, _, _, err := v.create(path, storage, fileType)
+	if err != nil {
+		return err
+	}
+
+	writeData := func(i int) int {
+		if zero(i) {
+			return 0
+		}
+		b, _ := v.allocate()
+		copy(v.block(b), data[i*prodosBlockSize:])
+		return b
+	}
+	setPointer := func(index, i, b int) {
+		blk := v.block(index)
+		blk[i] = byte(b)
+		blk[prodosIndexPointers+i] = byte(b >> 8)
+	}
+
+	var key int
+	switch storage {
+	case prodosSeedling:
+		key = writeData(0)
+	case prodosSapling:
+		key, _ = v.allocate()
+		for i := 0; i < nblocks; i++ {
+			setPointer(key, i, writeData(i))
+		}
+	case prodosTree:
+		key, _ = v.allocate()
+		for i := 0; i < nblocks; i += prodosIndexPointers {
+			if !indexUsed[i/prodosIndexPointers] {
+				continue
+			}
+			index, _ := v.allocate()
+			setPointer(key, i/prodosIndexPointers, index)
+			for j := i; j < nblocks && j < i+prodosIndexPointers; j++ {
+				setPointer(index, j-i, writeData(j))
+			}
+		}
+	}
+
+	put16(e[0x11:], key)
+	put16(e[0x13:], need)
+	put16(e[0x15:], len(data))
+	e[0x17] = byte(len(data) >> 16)
+	put16(e[0x1f:], int(auxType))
+	return nil
+}
+
+// CreateDir creates an empty subdirectory at a path.
+func (v *prodosVolume) CreateDir(path string) error {
+	if v.FreeBlocks() < 2 {
+		return errProDOSDiskFull
+	}
+	e, block, offset, err := v.create(path, prodosSubdir, prodosTypeDIR)
+	if err != nil {
+		return err
+	}
+	key, _ := v.allocate()
+	put16(e[0x11:], key)
+	put16(e[0x13:], 1)
+	put16(e[0x15:], prodosBlockSize)
+
+	hdr := v.block(key)[4:]
+	hdr[0] = prodosSubdirHead<<4 | e[0]&0x0f
+	copy(hdr[1:16], e[1:16])
+	hdr[0x10] = 0x75
+	v.putDate(hdr[0x18:])
+	hdr[0x1e] = prodosAccess
+	hdr[0x1f] = prodosEntryLength
+	hdr[0x20] = prodosEntriesPerBlk
+	put16(hdr[0x23:], block)
+	hdr[0x25] = byte((offset-4)/prodosEntryLength + 1)
+	hdr[0x26] = prodosEntryLength
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProDOSFiles(t *testing.T) {
+	data, err := formatProDOS("test.vol", 1600)
+	if err != nil {
+		t.Fatalf("Unable to format: %v\n", err)
+	}
+	v, err := openProDOS(data)
+	if err != nil {
+		t.Fatalf("Unable to open a formatted volume: %v\n", err)
+	}
+	v.now = func() time.Time { return time.Date(2024, 3, 5, 14, 30, 0, 0, time.Local) }
+	if v.Name() != "TEST.VOL" || v.TotalBlocks() != 1600 || v.FreeBlocks() != 1600-7 {
+		t.Errorf("Unexpected volume %s with %d of %d blocks free\n", v.Name(), v.FreeBlocks(), v.TotalBlocks())
+	}
+
+	fill := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i*13 + i>>9)
+		}
+		return b
+	}
+	sparse := fill(5 * prodosBlockSize)
+	for i := 2 * prodosBlockSize; i < 4*prodosBlockSize; i++ {
+		sparse[i] = 0
+	}
+	cases := []struct {
+		path    string
+		data    []byte
+		storage byte
+		blocks  int
+	}{
+		{"SEED", fill(100), prodosSeedling, 1},
+		{"EMPTY", nil, prodosSeedling, 1},
+		{"SAPLING", fill(10 * prodosBlockSize), prodosSapling, 11},
+		{"SPARSE", sparse, prodosSapling, 4},
+		{"TREE", fill(300*prodosBlockSize + 7), prodosTree, 301 + 2 + 1},
+	}
+	for _, c := range cases {
+		if err := v.WriteFile(c.path, prodosTypeBIN, 0x2000, c.data); err != nil {
+			t.Fatalf("%s: unexpected error %v\n", c.path, err)
+		}
+		data, e, err := v.ReadFile(strings.ToLower(c.path))
+		if err != nil || !bytes.Equal(data, c.data) {
+			t.Errorf("%s: read back incorrectly (%v)\n", c.path, err)
+		}
+		if e.storage != c.storage || e.blocksUsed != c.blocks || e.auxType != 0x2000 {
+			t.Errorf("%s: unexpected storage %d with %d blocks\n", c.path, e.storage, e.blocksUsed)
+		}
+	}
+	if err := v.WriteFile("SEED", prodosTypeTXT, 0, nil); err != errProDOSExists {
+		t.Errorf("Expected an existing file error, got %v\n", err)
+	}
+	if err := v.WriteFile("1BAD", prodosTypeTXT, 0, nil); err != errProDOSName {
+		t.Errorf("Expected an invalid name error, got %v\n", err)
+	}
+
+	// Subdirectories grow as files are added.
+	if err := v.CreateDir("SUB"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	for i := 0; i < 20; i++ {
+		name := "/TEST.VOL/SUB/F" + string(rune('A'+i))
+		if err := v.WriteFile(name, prodosTypeTXT, 0, []byte{byte(i)}); err != nil {
+			t.Fatalf("%s: unexpected error %v\n", name, err)
+		}
+	}
+	entries, err := v.ReadDir("SUB")
+	if err != nil || len(entries) != 20 {
+		t.Fatalf("Expected 20 entries, got %d (%v)\n", len(entries), err)
+	}
+	if data, _, err := v.ReadFile("SUB/FT"); err != nil || !bytes.Equal(data, []byte{19}) {
+		t.Errorf("Unable to read a file in a subdirectory (%v)\n", err)
+	}
+	root, _ := v.ReadDir("/")
+	if len(root) != len(cases)+1 {
+		t.Fatalf("Expected %d entries, got %d\n", len(cases)+1, len(root))
+	}
+	sub := root[len(root)-1]
+	if !sub.isDir() || sub.blocksUsed != 2 || sub.eof != 2*prodosBlockSize {
+		t.Errorf("Unexpected subdirectory entry %+v\n", sub)
+	}
+	if hdr := v.header(); le16(hdr[0x21:]) != len(cases)+1 {
+		t.Errorf("Unexpected file count %d\n", le16(hdr[0x21:]))
+	}
+	if s := root[0].String(); s != " SEED            BIN       1  05-MAR-24 14:30      100  $2000" {
+		t.Errorf("Unexpected catalog line %q\n", s)
+	}
+}
+
+func TestProDOSDiskCommands(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "test.hdv")
+	data, _ := formatProDOS("HARD", 4096)
+	os.WriteFile(image, data, 0644)
+	bin := filepath.Join(dir, "prog.bin")
+	os.WriteFile(bin, []byte{0xa9, 0x00, 0x60}, 0644)
+
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		status, ok := runDiskCommand(args, &out)
+		if !ok || status != 0 {
+			t.Fatalf("%v: status %d: %s\n", args, status, out.String())
+		}
+		return out.String()
+	}
+	run("put", "-a", "300", image, bin)
+	run("put", "-t", "sys", image, bin, "LOADER.SYSTEM")
+	out := run("ls", image)
+	if !strings.Contains(out, "/HARD\n") || !strings.Contains(out, " PROG            BIN       1") ||
+		!strings.Contains(out, " LOADER.SYSTEM   SYS       1") {
+		t.Errorf("Unexpected catalog:\n%s", out)
+	}
+
+	dst := filepath.Join(dir, "prog.out")
+	if s := run("get", image, "PROG", dst); !strings.Contains(s, "$0300") {
+		t.Errorf("Expected the load address, got %q\n", s)
+	}
+	if data, _ := os.ReadFile(dst); !bytes.Equal(data, []byte{0xa9, 0x00, 0x60}) {
+		t.Errorf("Unexpected file contents % X\n", data)
+	}
+
+	// 5.25" images hold ProDOS volumes in any sector order.
+	dsk := filepath.Join(dir, "test.dsk")
+	data, _ = formatProDOS("FLOPPY", 280)
+	disk := &diskImage{format: diskFormatDOS, volume: defaultVolume}
+	disk.WriteSectors(data, &prodosSectorOrder)
+	saveDiskFile(dsk, disk)
+	run("put", dsk, bin)
+	if out := run("ls", dsk); !strings.Contains(out, "/FLOPPY\n") || !strings.Contains(out, " PROG ") {
+		t.Errorf("Unexpected catalog:\n%s", out)
+	}
+}
+
+// FuzzProDOS checks that reading a ProDOS volume holding anything fails
+// rather than panicking or looping.
+func FuzzProDOS(f *testing.F) {
+	data, _ := formatProDOS("FUZZ", 16)
+	v, _ := openProDOS(data)
+	v.CreateDir("DIR")
+	v.WriteFile("DIR/HELLO", 0x04, 0, bytes.Repeat([]byte("HELLO\r"), 200))
+	f.Add(data)
+	bad := append([]byte(nil), data...)
+	put16(bad[prodosVolumeDirBlock*prodosBlockSize+4+0x23:], 20) // a bitmap past the end
+	f.Add(bad)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := openProDOS(data)
+		if err != nil {
+			return
+		}
+		v.Name()
+		v.FreeBlocks()
+		var walk func(dir string, depth int)
+		walk = func(dir string, depth int) {
+			entries, err := v.ReadDir(dir)
+			if err != nil || depth > 8 {
+				return
+			}
+			for _, e := range entries {
+				path := e.name
+				if dir != "" {
+					path = dir + "/" + e.name
+				}
+				if e.isDir() {
+					walk(path, depth+1)
+				} else {
+					v.ReadFile(path)
+				}
+			}
+		}
+		walk("", 0)
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A profiler counts the CPU cycles spent executing the instruction at
+// each address, so that the routines a program spends its time in can be
+// found. Counts are by address as currently mapped, as in the heatmap,
+// and are of 6502 cycles, regardless of any accelerator.
+type profiler struct {
+	cycles [0x10000]uint64
+	total  uint64
+}
+
+// A profileEntry holds the cycles spent in a routine or address range.
+type profileEntry struct {
+	name   string
+	cycles uint64
+}
+
+// EnableProfile starts profiling, returning the profiler.
+func (a *apple2) EnableProfile() *profiler {
+	if a.prof == nil {
+		a.prof = &profiler{}
+	}
+	return a.prof
+}
+
+// DisableProfile stops profiling and discards the counts.
+func (a *apple2) DisableProfile() {
+	a.prof = nil
+}
+
+// Clear resets all counts to zero.
+func (p *profiler) Clear() {
+	*p = profiler{}
+}
+
+// add counts the cycles spent executing the instruction at pc.
+func (p *profiler) add(pc uint16, cycles uint64) {
+	p.cycles[pc] += cycles
+	p.total += cycles
+}
+
+// Routines returns the cycles spent in each routine, hottest first. Each
+// address is counted in the routine of the nearest symbol at or below it,
+// or if there is none within a page, in the address's page.
+func (p *profiler) Routines(syms *symbolTable) []profileEntry {
+	var addrs []uint16
+	if syms != nil {
+		addrs = syms.Sorted()
+	}
+	byName := make(map[string]uint64)
+	i := 0
+	for addr := range p.cycles {
+		for i < len(addrs) && int(addrs[i]) <= addr {
+			i++
+		}
+		if p.cycles[addr] == 0 {
+			continue
+		}
+		name := fmt.Sprintf("%04X-%04X", addr&0xff00, addr|0xff)
+		if i > 0 && addr-int(addrs[i-1]) < 0x100 {
+			name, _ = syms.Name(addrs[i-1])
+		}
+		byName[name] += p.cycles[addr]
+	}
+	entries := make([]profileEntry, 0, len(byName))
+	for name, cycles := range byName {
+		entries = append(entries, profileEntry{name, cycles})
+	}
+	sortProfile(entries)
+	return entries
+}
+
+// Ranges returns the cycles spent in each of a list of address ranges,
+// hottest first.
+func (p *profiler) Ranges(ranges []traceRange) []profileEntry {
+	entries := make([]profileEntry, len(ranges))
+	for i, r := range ranges {
+		entries[i].name = fmt.Sprintf("%04X-%04X", r.start, r.end)
+		for addr := int(r.start); addr <= int(r.end); addr++ {
+			entries[i].cycles += p.cycles[addr]
+		}
+	}
+	sortProfile(entries)
+	return entries
+}
+
+// sortProfile sorts entries hottest first, and by name when they tie.
+func sortProfile(entries []profileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].cycles != entries[j].cycles {
+			return entries[i].cycles > entries[j].cycles
+		}
+		return entries[i].name < entries[j].name
+	})
+}
+
+// Write writes up to n entries, or all of them if n is 0, with the
+// share of all cycles profiled that each took.
+func (p *profiler) Write(w io.Writer, entries []profileEntry, n int) {
+	if n == 0 || n > len(entries) {
+		n = len(entries)
+	}
+	fmt.Fprintf(w, "%12s %6s  %s\n", "cycles", "%", "routine")
+	for _, e := range entries[:n] {
+		share := 0.0
+		if p.total > 0 {
+			share = 100 * float64(e.cycles) / float64(p.total)
+		}
+		fmt.Fprintf(w, "%12d %5.1f%%  %s\n", e.cycles, share, e.name)
+	}
+}
+
+// Save writes the cycles spent in every routine to a file.
+func (p *profiler) Save(filename string, syms *symbolTable) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(file)
+	p.Write(bw, p.Routines(syms), 0)
+	err = bw.Flush()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
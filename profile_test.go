@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x300, []byte{
+		0x20, 0x10, 0x03, // JSR $0310    6
+		0x4c, 0x00, 0x03, // JMP $0300    3
+	})
+	a.mmu.StoreBytes(0x310, []byte{
+		0xa2, 0x10, // LDX #$10           2
+		0xca,       // DEX                2 x 16
+		0xd0, 0xfd, // BNE $0312          3 x 15 + 2
+		0x60, // RTS                6
+	})
+	a.cpu.SetPC(0x300)
+	p := a.EnableProfile()
+	a.RunFrame()
+
+	syms := newSymbolTable()
+	syms.Add(0x300, "MAIN")
+	syms.Add(0x310, "LOOP")
+	entries := p.Routines(syms)
+	if len(entries) != 2 || entries[0].name != "LOOP" || entries[1].name != "MAIN" {
+		t.Fatalf("Unexpected routines %v\n", entries)
+	}
+	// Each call of LOOP takes 87 cycles and each pass through MAIN 9.
+	if r := float64(entries[0].cycles) / float64(entries[1].cycles); r < 9.5 || r > 9.8 {
+		t.Errorf("Expected LOOP to take 87/9 times the cycles of MAIN, got %v\n", entries)
+	}
+	if entries[0].cycles+entries[1].cycles != p.total || p.total < cyclesPerFrame {
+		t.Errorf("Expected all %d cycles to be counted, got %v\n", p.total, entries)
+	}
+	if entries := p.Routines(nil); len(entries) != 1 || entries[0].name != "0300-03FF" {
+		t.Errorf("Expected the page to be counted, got %v\n", entries)
+	}
+
+	var out bytes.Buffer
+	d := newDebugger(a)
+	if err := d.Exec("pf ranges 0310-0315,0300-0305", &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[1], "%  0310-0315") || !strings.HasSuffix(lines[2], "%  0300-0305") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+	d.Exec("pf off", &out)
+	if err := d.Exec("pf", &out); err != errProfileOff {
+		t.Errorf("Expected the profiler to be off, got %v\n", err)
+	}
+}
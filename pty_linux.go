@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY creates a pseudo-terminal and returns its master side and the
+// name of its slave device, which a host terminal program opens. The
+// slave is kept open in raw mode, so that output isn't echoed back and
+// terminal programs can come and go without closing the master.
+func openPTY() (io.ReadWriteCloser, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var n uint32
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var t syscall.Termios
+	if err := ioctl(slave, syscall.TCGETS, unsafe.Pointer(&t)); err == nil {
+		setRaw(&t)
+		ioctl(slave, syscall.TCSETS, unsafe.Pointer(&t))
+	}
+	return &ptyConn{File: master, slave: slave}, name, nil
+}
+
+// setRaw changes terminal settings to pass bytes through unchanged: no
+// echo, line editing, signal keys or output processing.
+func setRaw(t *syscall.Termios) {
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+}
+
+// makeRaw puts a terminal in raw mode, so that keys are read as they're
+// typed, and returns a function that restores its previous mode.
+func makeRaw(f *os.File) (restore func(), err error) {
+	var old syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	t := old
+	setRaw(&t)
+	t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(f, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// A ptyConn is the master side of a pseudo-terminal, holding its slave
+// open.
+type ptyConn struct {
+	*os.File
+	slave *os.File
+}
+
+func (p *ptyConn) Close() error {
+	p.slave.Close()
+	return p.File.Close()
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// openPTY reports that pseudo-terminals aren't supported on this
+// platform.
+func openPTY() (io.ReadWriteCloser, string, error) {
+	return nil, "", errors.New("pseudo-terminals are not supported on this platform")
+}
+
+// makeRaw reports that raw terminal mode isn't supported on this
+// platform.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ramDiskDefaultSlot is the slot of the SmartPort card installed for a
+// RAM disk when no SmartPort slot is given.
+const ramDiskDefaultSlot = 5
+
+// RAM disk sizes, in 512-byte blocks.
+const (
+	ramDiskMinBlocks = 16
+	ramDiskMaxBlocks = 0xffff // the largest ProDOS volume
+)
+
+var (
+	errRAMDiskSize = errors.New("RAM disk size must be from 8K to 32M")
+	errRAMDiskFile = errors.New("RAM disk file has a different size")
+)
+
+// A ramDisk is a SmartPort block device held in host memory, like the
+// RAM cards that ProDOS uses as large RAM disks. A new RAM disk is
+// formatted as an empty ProDOS volume named RAMn. If it's backed by a
+// file, its contents are loaded from the file, if there is one, and saved
+// back to it when flushed, so they last from one session to the next.
+//
+// The IIe's /RAM volume in auxiliary memory needs no device of its own:
+// ProDOS installs it when the machine has an extended 80-column card.
+type ramDisk struct {
+	name  string
+	file  string // backing file, or "" if none
+	data  []byte
+	dirty bool
+}
+
+// newRAMDisk creates a RAM disk with a number of blocks, backed by a file
+// unless file is "".
+func newRAMDisk(name string, blocks int, file string) (*ramDisk, error) {
+	if blocks < ramDiskMinBlocks || blocks > ramDiskMaxBlocks {
+		return nil, errRAMDiskSize
+	}
+	r := &ramDisk{name: name, file: file}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		switch {
+		case err == nil && len(data) != blocks*512:
+			return nil, fmt.Errorf("%s: %w", file, errRAMDiskFile)
+		case err == nil:
+			r.data = data
+			return r, nil
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	data, err := formatProDOS(name, blocks)
+	if err != nil {
+		return nil, err
+	}
+	r.data = data
+	r.dirty = file != ""
+	return r, nil
+}
+
+// parseRAMDiskSpec parses a RAM disk size in kilobytes, or in megabytes
+// with an M suffix, followed by an optional backing file, as in "512" or
+// "8M,ram.po". It returns the size in blocks.
+func parseRAMDiskSpec(s string) (blocks int, file string, err error) {
+	size := s
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		size, file = s[:i], s[i+1:]
+	}
+	blocks, err = parseDiskSize(size)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid RAM disk size %q", s)
+	}
+	if blocks < ramDiskMinBlocks || blocks > ramDiskMaxBlocks {
+		return 0, "", errRAMDiskSize
+	}
+	return blocks, file, nil
+}
+
+func (r *ramDisk) Name() string     { return r.name }
+func (r *ramDisk) DeviceType() byte { return spTypeHardDisk }
+func (r *ramDisk) Blocks() int      { return len(r.data) / 512 }
+
+func (r *ramDisk) GeneralStatus() byte {
+	return spStatusBlock | spStatusRead | spStatusWrite | spStatusOnline
+}
+
+func (r *ramDisk) Status(code byte) ([]byte, byte) {
+	return nil, spErrBadCtl
+}
+
+func (r *ramDisk) Control(code byte, data []byte) byte {
+	return spErrBadCtl
+}
+
+func (r *ramDisk) ReadBlock(block int, buf []byte) byte {
+	if block >= r.Blocks() {
+		return spErrBadBlock
+	}
+	copy(buf, r.data[block*512:])
+	return spErrOK
+}
+
+func (r *ramDisk) WriteBlock(block int, buf []byte) byte {
+	if block >= r.Blocks() {
+		return spErrBadBlock
+	}
+	copy(r.data[block*512:], buf)
+	r.dirty = true
+	return spErrOK
+}
+
+// Flush saves the RAM disk to its backing file if it was written.
+func (r *ramDisk) Flush() error {
+	if !r.dirty || r.file == "" {
+		return nil
+	}
+	r.dirty = false
+	return os.WriteFile(r.file, r.data, 0644)
+}
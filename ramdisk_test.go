@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRAMDisk(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ram.po")
+	blocks, name, err := parseRAMDiskSpec("1M," + file)
+	if err != nil || blocks != 2048 || name != file {
+		t.Fatalf("Unexpected spec %d %s %v\n", blocks, name, err)
+	}
+
+	// A new RAM disk is an empty ProDOS volume.
+	r, err := newRAMDisk("RAM5", blocks, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := openProDOS(r.data)
+	if err != nil || v.Name() != "RAM5" || v.TotalBlocks() != 2048 {
+		t.Fatalf("Expected a RAM5 volume: %v\n", err)
+	}
+
+	// Its contents last from one session to the next.
+	a := newApple2()
+	c := newSmartPortCard(a, 5)
+	c.Attach(r)
+	a.sm.Insert(5, c)
+	a.mmu.StoreByte(0x2000, 0x5a)
+	if e, _ := spCall(t, a, spCmdWriteBlock, 3, 1, 0x00, 0x20, 100, 0, 0); e != 0 {
+		t.Fatalf("SmartPort write failed with %02X\n", e)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = newRAMDisk("RAM5", blocks, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.data[100*512] != 0x5a {
+		t.Errorf("RAM disk contents not saved\n")
+	}
+	if _, err := newRAMDisk("RAM5", 1024, file); !errors.Is(err, errRAMDiskFile) {
+		t.Errorf("Expected %v, got %v\n", errRAMDiskFile, err)
+	}
+
+	for _, s := range []string{"4", "64M", "big", "-1K"} {
+		if _, _, err := parseRAMDiskSpec(s); err == nil {
+			t.Errorf("%s: expected an error\n", s)
+		}
+	}
+	if blocks, _, _ := parseRAMDiskSpec("32M"); blocks != ramDiskMaxBlocks {
+		t.Errorf("Expected a 32M RAM disk to have %d blocks, got %d\n", ramDiskMaxBlocks, blocks)
+	}
+}
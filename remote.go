@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// remoteMaxBody is the largest request body the remote control accepts,
+// which is enough for any disk image or snapshot.
+const remoteMaxBody = 64 << 20
+
+var errRemoteDrive = errors.New("drive must be 1 or 2")
+
+// A remoteControl serves an HTTP API that controls the machine, for test
+// harnesses and other programs that drive it. Each request runs on the
+// goroutine that runs the machine, through Do, and is replied with
+// JSON unless it fetches an image or a snapshot:
+//
+//	GET    /status              the model, whether paused, and the cycle count
+//	POST   /pause               stop running the machine
+//	POST   /resume              resume running it
+//	POST   /reset?kind=k        reset, or with k cold or power, force a cold
+//	                            start or power-cycle
+//	POST   /disk?drive=n&file=f insert a disk image file into drive 1 or 2
+//	POST   /disk?drive=n&name=f insert the disk image sent in the body, in
+//	                            the format given by the name's extension
+//	DELETE /disk?drive=n        eject the disk from drive 1 or 2
+//	POST   /type                type the text sent in the body
+//	GET    /screen              the text on the screen
+//	GET    /screenshot?scale=n&mono
+//	                            the display as a PNG image
+//	GET    /state               a snapshot of the machine
+//	PUT    /state               restore a snapshot sent in the body
+//
+// The requests that change the machine reply with its status. A request
+// that fails is replied with status 400 and an object whose error member
+// holds the message.
+type remoteControl struct {
+	apple2 *apple2
+	routes map[string]remoteRequest
+	paused bool
+}
+
+// A remoteRequest handles a request with its body. It returns a value to
+// reply with as JSON, or bytes to reply with as they are.
+type remoteRequest func(r *http.Request, body []byte) (any, error)
+
+func newRemoteControl(apple2 *apple2) *remoteControl {
+	rc := &remoteControl{
+		apple2: apple2,
+	}
+	rc.routes = map[string]remoteRequest{
+		"GET /status":     rc.status,
+		"POST /pause":     rc.pause,
+		"POST /resume":    rc.resume,
+		"POST /reset":     rc.reset,
+		"POST /disk":      rc.insertDisk,
+		"DELETE /disk":    rc.ejectDisk,
+		"POST /type":      rc.typeText,
+		"GET /screen":     rc.screen,
+		"GET /screenshot": rc.screenshot,
+		"GET /state":      rc.saveState,
+		"PUT /state":      rc.loadState,
+	}
+	return rc
+}
+
+// ServeHTTP runs a request on the machine's goroutine and replies with
+// its result.
+func (rc *remoteControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn, ok := rc.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, remoteMaxBody))
+	if err != nil {
+		replyJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var v any
+	if err := rc.apple2.Do(r.Context(), func() { v, err = fn(r, body) }); err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		replyJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		replyJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if b, ok := v.([]byte); ok {
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+		w.Write(b)
+		return
+	}
+	replyJSON(w, http.StatusOK, v)
+}
+
+// replyJSON replies to a request with a value encoded as JSON.
+func replyJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// remoteStatus is the reply to the status request and to the requests
+// that change the machine.
+type remoteStatus struct {
+	Model  string `json:"model"`
+	Paused bool   `json:"paused"`
+	Cycles uint64 `json:"cycles"`
+}
+
+func (rc *remoteControl) status(r *http.Request, body []byte) (any, error) {
+	a := rc.apple2
+	return remoteStatus{Model: a.cfg.name, Paused: rc.paused, Cycles: a.cpu.Cycles}, nil
+}
+
+func (rc *remoteControl) pause(r *http.Request, body []byte) (any, error) {
+	rc.paused = true
+	return rc.status(r, body)
+}
+
+func (rc *remoteControl) resume(r *http.Request, body []byte) (any, error) {
+	rc.paused = false
+	return rc.status(r, body)
+}
+
+func (rc *remoteControl) reset(r *http.Request, body []byte) (any, error) {
+	a := rc.apple2
+	switch kind := r.URL.Query().Get("kind"); kind {
+	case "", "warm":
+		a.Reset()
+	case "cold":
+		a.ColdReset()
+	case "power":
+		a.PowerCycle()
+	default:
+		return nil, fmt.Errorf("unknown reset kind '%s'", kind)
+	}
+	return rc.status(r, body)
+}
+
+// remoteDrive returns the drive number of a request.
+func remoteDrive(r *http.Request) (int, error) {
+	drive, err := strconv.Atoi(r.URL.Query().Get("drive"))
+	if err != nil || drive < 1 || drive > 2 {
+		return 0, errRemoteDrive
+	}
+	return drive, nil
+}
+
+func (rc *remoteControl) insertDisk(r *http.Request, body []byte) (any, error) {
+	drive, err := remoteDrive(r)
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	if file := q.Get("file"); file != "" {
+		err = rc.apple2.InsertDisk(drive, file)
+	} else {
+		err = rc.apple2.InsertDiskData(drive, q.Get("name"), body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rc.status(r, body)
+}
+
+func (rc *remoteControl) ejectDisk(r *http.Request, body []byte) (any, error) {
+	drive, err := remoteDrive(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := rc.apple2.EjectDisk(drive); err != nil {
+		return nil, err
+	}
+	return rc.status(r, body)
+}
+
+func (rc *remoteControl) typeText(r *http.Request, body []byte) (any, error) {
+	rc.apple2.kb.PasteText(string(body))
+	return rc.status(r, body)
+}
+
+func (rc *remoteControl) screen(r *http.Request, body []byte) (any, error) {
+	d := rc.apple2.ds
+	return map[string]any{"columns": d.TextColumns(), "text": d.Text()}, nil
+}
+
+func (rc *remoteControl) screenshot(r *http.Request, body []byte) (any, error) {
+	var args []string
+	q := r.URL.Query()
+	if s := q.Get("scale"); s != "" {
+		args = append(args, s)
+	}
+	if q.Has("mono") {
+		args = append(args, "mono")
+	}
+	opts, err := parseScreenshotOptions(args)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, rc.apple2.ds.Screenshot(opts)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (rc *remoteControl) saveState(r *http.Request, body []byte) (any, error) {
+	var buf bytes.Buffer
+	if err := rc.apple2.SaveState(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (rc *remoteControl) loadState(r *http.Request, body []byte) (any, error) {
+	if err := rc.apple2.LoadState(bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	return rc.status(r, body)
+}
+
+// serveRemote serves the remote control API of a machine over HTTP on a
+// TCP address. It returns the listener, which stops the server when
+// closed. RunFrame runs the requests, in whichever loop runs the machine.
+func serveRemote(a *apple2, addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	a.remote = newRemoteControl(a)
+	go http.Serve(l, a.remote)
+	return l, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteControl(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc051) // TEXT
+	for addr := 0x400; addr < 0x800; addr++ {
+		a.mmu.mainRAM[addr] = 0xa0
+	}
+	for i, c := range "HELLO" {
+		a.mmu.mainRAM[0x400+i] = byte(c) | 0x80
+	}
+	a.remote = newRemoteControl(a)
+	a.speed.sleep = func(time.Duration) { time.Sleep(time.Millisecond) }
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				a.RunPaced()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	srv := httptest.NewServer(a.remote)
+	defer srv.Close()
+
+	// request sends a request and returns the reply's status and body.
+	request := func(method, path string, body []byte) (int, []byte) {
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, b
+	}
+	status := func(method, path string) remoteStatus {
+		code, b := request(method, path, nil)
+		var s remoteStatus
+		if code != http.StatusOK || json.Unmarshal(b, &s) != nil {
+			t.Fatalf("%s %s: unexpected reply %d %s\n", method, path, code, b)
+		}
+		return s
+	}
+
+	// While paused, the machine doesn't run.
+	s1 := status("POST", "/pause")
+	time.Sleep(20 * time.Millisecond)
+	s2 := status("GET", "/status")
+	if !s1.Paused || s2.Cycles != s1.Cycles || s2.Model != a.cfg.name {
+		t.Errorf("Expected the paused machine to stay at cycle %d, got %+v\n", s1.Cycles, s2)
+	}
+
+	code, b := request("GET", "/screen", nil)
+	var screen struct {
+		Columns int
+		Text    string
+	}
+	if code != http.StatusOK || json.Unmarshal(b, &screen) != nil ||
+		screen.Columns != 40 || !strings.HasPrefix(screen.Text, "HELLO") {
+		t.Errorf("Unexpected screen reply %d %s\n", code, b)
+	}
+
+	code, b = request("GET", "/screenshot?scale=2", nil)
+	if img, err := png.Decode(bytes.NewReader(b)); code != http.StatusOK || err != nil ||
+		img.Bounds().Dx() != 2*displayWidth || img.Bounds().Dy() != 4*displayHeight {
+		t.Errorf("Expected a 2x screenshot, got %d %v\n", code, err)
+	}
+
+	// A snapshot restores the machine as it was.
+	code, snapshot := request("GET", "/state", nil)
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected snapshot reply %d %s\n", code, snapshot)
+	}
+	status("POST", "/reset?kind=power")
+	if code, b := request("PUT", "/state", snapshot); code != http.StatusOK {
+		t.Errorf("Unexpected restore reply %d %s\n", code, b)
+	}
+	if s := status("GET", "/status"); s.Cycles != s1.Cycles {
+		t.Errorf("Expected the restored machine at cycle %d, got %d\n", s1.Cycles, s.Cycles)
+	}
+
+	s3 := status("POST", "/resume")
+	for i := 0; i < 100 && status("GET", "/status").Cycles == s3.Cycles; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s := status("GET", "/status"); s.Paused || s.Cycles == s3.Cycles {
+		t.Errorf("Expected the resumed machine to run, got %+v\n", s)
+	}
+
+	for _, r := range []struct {
+		method, path string
+		code         int
+	}{
+		{"POST", "/reset?kind=hard", http.StatusBadRequest},
+		{"POST", "/disk?drive=3&name=x.dsk", http.StatusBadRequest},
+		{"PUT", "/state", http.StatusBadRequest},
+		{"GET", "/pause", http.StatusNotFound},
+	} {
+		if code, b := request(r.method, r.path, nil); code != r.code {
+			t.Errorf("%s %s: expected status %d, got %d %s\n", r.method, r.path, r.code, code, b)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// vectorReset is the 6502 reset vector.
+const vectorReset = 0xfffc
+
+// The reset handler of the autostart firmware warm starts through the
+// soft entry vector at $3F2 if the powered-up byte at $3F4 holds the
+// vector's high byte exclusive-ORed with $A5. Otherwise it cold starts,
+// clearing the screen and booting from the first disk controller it finds.
+const (
+	softEntryVector = 0x3f2
+	powerUpByte     = 0x3f4
+	powerUpCheck    = 0xa5
+)
+
+// resetHotkey is the host key that acts as the Reset key. Like the real
+// key, it only resets the machine while Control is held. Holding
+// open-Apple as well forces a cold start, and holding Shift instead
+// power-cycles the machine.
+const resetHotkey = hostKeyF12
+
+// Reset asserts the RESET line, as pressing Ctrl-Reset does. The soft
+// switches and cards are reset and the CPU jumps through the reset vector,
+// but memory is left untouched, so the firmware warm starts through the
+// soft entry vector if the powered-up byte is valid.
+func (a *apple2) Reset() {
+	a.reset(resetWarm)
+}
+
+// reset asserts the RESET line and tells the event observers how the
+// machine was reset.
+func (a *apple2) reset(kind resetKind) {
+	a.iou.Reset()
+	a.mmu.SelectROMBank(0)
+	a.sm.Reset()
+	if a.mouse != nil {
+		a.mouse.Reset()
+	}
+	if a.zip != nil {
+		a.zip.Reset()
+	}
+	a.kb.ResetKeyStrobe()
+
+	// The 6502 takes seven cycles to reset. The stack pointer moves as
+	// though the program counter and status were pushed, but the writes
+	// are suppressed.
+	c := a.cpu
+	c.Reg.SP -= 3
+	c.Reg.InterruptDisable = true
+	if a.cfg.arch == cpu.CMOS {
+		c.Reg.Decimal = false
+	}
+	c.Reg.PC = a.mmu.LoadAddress(vectorReset)
+	c.Cycles += cyclesPerInterrupt
+
+	if a.ev.active() {
+		a.ev.send(machineEvent{kind: eventReset, reset: kind})
+		a.ev.checkDisplayMode()
+	}
+}
+
+// ColdReset resets the machine and forces the firmware to cold start, as
+// pressing open-Apple-Ctrl-Reset does on the IIe and IIc. The powered-up
+// byte is invalidated, which has the same effect on every model with
+// autostart firmware.
+func (a *apple2) ColdReset() {
+	ram := a.mmu.mainRAM
+	ram[powerUpByte] = ^(ram[softEntryVector+1] ^ powerUpCheck)
+	a.reset(resetCold)
+}
+
+// PowerCycle turns the machine off and on again. Memory loses its
+// contents and is filled as selected by SetRAMFill, and all soft switches
+// are cleared before the machine is reset.
+func (a *apple2) PowerCycle() {
+	a.FillRAM()
+	a.mmu.SelectAuxBank(0)
+
+	a.iou.switches = 0
+	a.kb.SetKey(0)
+	a.kb.ResetKeyStrobe()
+	a.cpu.Reg.A, a.cpu.Reg.X, a.cpu.Reg.Y = 0, 0, 0
+	a.reset(resetPower)
+}
+
+// A ramFill selects the contents of memory at power-up. Some software
+// behaves differently when memory isn't clear, so the pattern of real
+// RAM chips is the default.
+type ramFill int
+
+const (
+	ramFillPattern ramFill = iota // alternating pages of $00 and $FF
+	ramFillZero                   // all zeros
+	ramFillRandom                 // random bytes from a seed
+)
+
+var ramFillNames = []string{"pattern", "zero", "random"}
+
+func (f ramFill) String() string {
+	return ramFillNames[f]
+}
+
+// parseRAMFill converts a fill name, such as "random", into a ramFill.
+func parseRAMFill(s string) (ramFill, bool) {
+	for f, name := range ramFillNames {
+		if s == name {
+			return ramFill(f), true
+		}
+	}
+	return 0, false
+}
+
+// SetRAMFill selects how FillRAM and PowerCycle fill memory. The seed
+// determines the random contents, so a run can be reproduced.
+func (a *apple2) SetRAMFill(f ramFill, seed int64) {
+	a.ramFill, a.ramSeed = f, seed
+}
+
+// FillRAM fills main and aux memory, including all RamWorks banks, as
+// selected by SetRAMFill.
+func (a *apple2) FillRAM() {
+	m := a.mmu
+	rnd := rand.New(rand.NewSource(a.ramSeed))
+	for _, ram := range append([][]byte{m.mainRAM}, m.auxBanks...) {
+		switch a.ramFill {
+		case ramFillPattern:
+			fillPowerUpPattern(ram)
+		case ramFillZero:
+			for i := range ram {
+				ram[i] = 0
+			}
+		case ramFillRandom:
+			rnd.Read(ram)
+		}
+	}
+	a.ds.Invalidate()
+}
+
+// fillPowerUpPattern fills memory with alternating pages of $00 and $FF.
+func fillPowerUpPattern(ram []byte) {
+	for i := range ram {
+		if (i>>8)&1 == 0 {
+			ram[i] = 0x00
+		} else {
+			ram[i] = 0xff
+		}
+	}
+}
+
+// resetKey handles the Reset key. The modifiers select the kind of reset;
+// without Control, the key does nothing.
+func (a *apple2) resetKey(mods keyModifiers) {
+	switch {
+	case !mods.ctrl:
+	case mods.shift:
+		a.PowerCycle()
+	case mods.openApple:
+		a.ColdReset()
+	default:
+		a.Reset()
+	}
+}
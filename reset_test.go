@@ -0,0 +1,130 @@
+package main
+
+import "testing"
+
+func TestReset(t *testing.T) {
+	a := newApple2()
+	rom := a.mmu.romBanks[0]
+	rom[vectorReset-0xc000] = 0x62
+	rom[vectorReset-0xc000+1] = 0xfa
+	d := newDiskII(a, make([]byte, 256))
+	a.sm.Insert(6, d)
+
+	// Valid soft entry vector and powered-up byte.
+	a.mmu.StoreByte(0x300, 0x42)
+	a.mmu.StoreAddress(softEntryVector, 0x300)
+	a.mmu.StoreByte(powerUpByte, 0x03^powerUpCheck)
+
+	setup := func() {
+		a.cpu.SetPC(0x300)
+		a.cpu.Reg.SP = 0xff
+		a.cpu.Reg.InterruptDisable = false
+		for _, addr := range []uint16{0xc001, 0xc005, 0xc009} {
+			a.mmu.StoreByte(addr, 0) // 80STORE, RAMWRT, ALTZP
+		}
+		a.mmu.LoadByte(0xc051) // TEXT
+		a.mmu.LoadByte(0xc08b) // LC RAM read and write, bank 1
+		a.mmu.LoadByte(0xc0e9) // disk motor on
+	}
+
+	check := func(what string) {
+		t.Helper()
+		if a.cpu.Reg.PC != 0xfa62 || a.cpu.Reg.SP != 0xfc || !a.cpu.Reg.InterruptDisable {
+			t.Errorf("%s: expected a jump through the reset vector, got PC=$%04X SP=$%02X\n",
+				what, a.cpu.Reg.PC, a.cpu.Reg.SP)
+		}
+		for _, sw := range []ioSwitch{ioSwitch80STORE, ioSwitchAUXRAMWRT, ioSwitchALTZP, ioSwitchLCRAMRD} {
+			if a.iou.testSoftSwitch(sw) {
+				t.Errorf("%s: expected switch %d cleared\n", what, sw)
+			}
+		}
+		if !a.iou.testSoftSwitch(ioSwitchLCRAMWRT) || !a.iou.testSoftSwitch(ioSwitchLCBANK2) {
+			t.Errorf("%s: expected language card RAM bank 2 write-enabled\n", what)
+		}
+		if d.MotorOn() {
+			t.Errorf("%s: expected the disk motor off\n", what)
+		}
+	}
+
+	// Ctrl-Reset keeps memory and the video mode.
+	setup()
+	a.Reset()
+	check("Reset")
+	if !a.iou.testSoftSwitch(ioSwitchTEXT) {
+		t.Errorf("Expected the video mode kept\n")
+	}
+	if a.mmu.LoadByte(0x300) != 0x42 || a.mmu.LoadByte(powerUpByte) != 0x03^powerUpCheck {
+		t.Errorf("Expected memory kept\n")
+	}
+
+	// The Reset key needs Control.
+	setup()
+	a.kb.PushKeyEvent(resetHotkey, true)
+	a.kb.PushKeyEvent(resetHotkey, false)
+	a.kb.Update()
+	if a.cpu.Reg.PC != 0x300 {
+		t.Errorf("Expected no reset without Control\n")
+	}
+	a.kb.PushKeyEvent(hostKeyLeftCtrl, true)
+	a.kb.PushKeyEvent(resetHotkey, true)
+	a.kb.PushKeyEvent(resetHotkey, false)
+	a.kb.Update()
+	check("Ctrl-Reset key")
+	if a.kb.IsKeyDown() {
+		t.Errorf("Expected the Reset key not typed\n")
+	}
+
+	// Open-Apple-Ctrl-Reset invalidates the powered-up byte.
+	setup()
+	a.kb.PushKeyEvent(hostKeyLeftAlt, true)
+	a.kb.PushKeyEvent(resetHotkey, true)
+	a.kb.Update()
+	check("Cold reset")
+	if a.mmu.LoadByte(powerUpByte) == 0x03^powerUpCheck || a.mmu.LoadByte(0x300) != 0x42 {
+		t.Errorf("Expected only the powered-up byte invalidated\n")
+	}
+
+	// A power cycle fills memory with the power-up pattern.
+	setup()
+	a.PowerCycle()
+	check("Power cycle")
+	if a.iou.testSoftSwitch(ioSwitchTEXT) {
+		t.Errorf("Expected all switches cleared\n")
+	}
+	if a.mmu.LoadByte(0x200) != 0x00 || a.mmu.LoadByte(0x300) != 0xff || a.mmu.auxRAM[0x300] != 0xff {
+		t.Errorf("Expected alternating $00 and $FF pages\n")
+	}
+}
+
+func TestRAMFill(t *testing.T) {
+	a := newApple2()
+	a.mmu.SetAuxCard(auxCardRamWorks)
+	a.mmu.SetRamWorksBanks(2)
+
+	a.FillRAM()
+	a.SetRAMFill(ramFillZero, 0)
+	a.FillRAM()
+	for _, ram := range append([][]byte{a.mmu.mainRAM}, a.mmu.auxBanks...) {
+		if ram[0x100] != 0 || ram[0xbfff] != 0 {
+			t.Errorf("Expected zeroed memory\n")
+		}
+	}
+
+	// Random contents are reproducible from the seed.
+	random := func(seed int64) []byte {
+		a.SetRAMFill(ramFillRandom, seed)
+		a.FillRAM()
+		return append([]byte(nil), a.mmu.auxBanks[1]...)
+	}
+	r1, r2, r3 := random(1), random(1), random(2)
+	if string(r1) != string(r2) || string(r1) == string(r3) {
+		t.Errorf("Expected random contents determined by the seed\n")
+	}
+
+	if f, ok := parseRAMFill("random"); !ok || f != ramFillRandom || f.String() != "random" {
+		t.Errorf("Expected to parse the random fill\n")
+	}
+	if _, ok := parseRAMFill("dirty"); ok {
+		t.Errorf("Expected an unknown fill to fail\n")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// The parts of the libretro core that don't need cgo, which libretro.go
+// calls with the front end's input and buffers.
+
+// retroKeys maps the libretro key codes, which are SDL 1.2 key symbols,
+// to host keys.
+var retroKeys = func() map[uint]hostKey {
+	m := map[uint]hostKey{
+		8:   hostKeyBackspace,
+		9:   hostKeyTab,
+		13:  hostKeyReturn,
+		27:  hostKeyEscape,
+		32:  hostKeySpace,
+		39:  hostKeyApostrophe,
+		44:  hostKeyComma,
+		45:  hostKeyMinus,
+		46:  hostKeyPeriod,
+		47:  hostKeySlash,
+		48:  hostKey0,
+		59:  hostKeySemicolon,
+		61:  hostKeyEqual,
+		91:  hostKeyLeftBracket,
+		92:  hostKeyBackslash,
+		93:  hostKeyRightBracket,
+		96:  hostKeyGrave,
+		127: hostKeyDelete,
+		273: hostKeyUp,
+		274: hostKeyDown,
+		275: hostKeyRight,
+		276: hostKeyLeft,
+		292: hostKeyF11,
+		293: hostKeyF12,
+		301: hostKeyCapsLock,
+		302: hostKeyScrollLock,
+		303: hostKeyRightShift,
+		304: hostKeyLeftShift,
+		305: hostKeyRightCtrl,
+		306: hostKeyLeftCtrl,
+		307: hostKeyRightAlt,
+		308: hostKeyLeftAlt,
+		311: hostKeyLeftGUI,
+		312: hostKeyRightGUI,
+	}
+	for c := byte('1'); c <= '9'; c++ {
+		m[uint(c)] = hostKey1 + hostKey(c-'1')
+	}
+	for c := byte('a'); c <= 'z'; c++ {
+		m[uint(c)] = hostKeyLetter(c - 0x20)
+	}
+	return m
+}()
+
+// A retroInput is the state of the first joypad at a poll.
+type retroInput struct {
+	analog  [2]int16   // left analog stick's x and y, -32768..32767
+	dpad    [2][2]bool // d-pad directions pressed, by axis and direction
+	buttons [2]bool    // B and A
+}
+
+// The d-pad directions of each axis.
+const (
+	retroDpadLess = 0 // left or up
+	retroDpadMore = 1 // right or down
+)
+
+// A retroJoypad moves the joystick with the left analog stick, or the
+// d-pad, of the first joypad, whose B and A buttons are the push buttons.
+type retroJoypad struct {
+	buttons [2]bool // buttons pressed at the last poll
+}
+
+// Update applies the joypad's state at a poll to the machine. The d-pad
+// overrides the analog stick, and buttons are sent only as they change.
+func (p *retroJoypad) Update(a *apple2, in retroInput) {
+	for axis := range in.analog {
+		v := float64(in.analog[axis]) / 32768
+		switch {
+		case in.dpad[axis][retroDpadLess]:
+			v = -1
+		case in.dpad[axis][retroDpadMore]:
+			v = 1
+		}
+		a.gi.SetAxis(axis, v)
+	}
+	for button, down := range in.buttons {
+		if down != p.buttons[button] {
+			a.im.GamepadButton(button, down)
+			p.buttons[button] = down
+		}
+	}
+}
+
+// retroStateSlack is the room left for the machine's state to grow
+// between the front end asking for the snapshot size and saving one, as
+// it does when text is pasted.
+const retroStateSlack = 64 * 1024
+
+// Snapshots are saved with their length first, since the front end's
+// buffer may be larger than the snapshot.
+
+// retroStateSize returns the size of the buffer the front end should
+// provide for snapshots, or 0 if the machine can't save one. Since the
+// size of a compressed snapshot varies with what memory holds, it's the
+// largest the snapshot can be, so memory filling up with data that
+// doesn't compress doesn't make it outgrow the buffer.
+func retroStateSize(a *apple2) int {
+	if a == nil {
+		return 0
+	}
+	n, err := a.maxStateSize()
+	if err != nil {
+		return 0
+	}
+	return 4 + n + retroStateSlack
+}
+
+// retroSaveState saves a snapshot into the front end's buffer, and
+// returns false if it doesn't fit.
+func retroSaveState(a *apple2, dst []byte) bool {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if a == nil || a.SaveState(&buf) != nil || buf.Len() > len(dst) {
+		return false
+	}
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint32(b, uint32(len(b)-4))
+	copy(dst, b)
+	return true
+}
+
+// retroLoadState loads a snapshot saved by retroSaveState.
+func retroLoadState(a *apple2, b []byte) bool {
+	if a == nil || len(b) < 4 {
+		return false
+	}
+	n := binary.LittleEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return false
+	}
+	return a.LoadState(bytes.NewReader(b[4:4+n])) == nil
+}
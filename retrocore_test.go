@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRetroJoypad(t *testing.T) {
+	a := newApple2()
+	var pad retroJoypad
+
+	cases := []struct {
+		in      retroInput
+		paddles [2]byte
+		buttons [2]byte
+	}{
+		{retroInput{}, [2]byte{128, 128}, [2]byte{0, 0}},
+		{retroInput{analog: [2]int16{-32768, 32767}}, [2]byte{0, 255}, [2]byte{0, 0}},
+		{retroInput{analog: [2]int16{32767, 32767}, dpad: [2][2]bool{{true, false}, {true, false}}}, [2]byte{0, 0}, [2]byte{0, 0}},
+		{retroInput{dpad: [2][2]bool{{false, true}, {false, true}}}, [2]byte{255, 255}, [2]byte{0, 0}},
+		{retroInput{buttons: [2]bool{true, false}}, [2]byte{128, 128}, [2]byte{0x80, 0}},
+		{retroInput{buttons: [2]bool{true, true}}, [2]byte{128, 128}, [2]byte{0x80, 0x80}},
+		{retroInput{buttons: [2]bool{false, true}}, [2]byte{128, 128}, [2]byte{0, 0x80}},
+	}
+
+	for i, c := range cases {
+		pad.Update(a, c.in)
+		for n := range c.paddles {
+			if v := a.gi.paddles[n]; v != c.paddles[n] {
+				t.Errorf("Case %d: expected paddle %d at %d, got %d\n", i, n, c.paddles[n], v)
+			}
+			if v := a.gi.ButtonBit(n); v != c.buttons[n] {
+				t.Errorf("Case %d: expected button %d bit %02x, got %02x\n", i, n, c.buttons[n], v)
+			}
+		}
+	}
+
+	// Buttons are only sent as they change, so a button held on the
+	// joypad doesn't undo one pressed elsewhere.
+	a.gi.SetButton(0, true)
+	pad.Update(a, retroInput{buttons: [2]bool{false, true}})
+	if a.gi.ButtonBit(0) == 0 {
+		t.Error("Expected button 0 to stay pressed\n")
+	}
+}
+
+func TestRetroKeys(t *testing.T) {
+	cases := []struct {
+		code uint
+		key  hostKey
+	}{
+		{'a', hostKeyLetter('A')},
+		{'z', hostKeyLetter('Z')},
+		{'0', hostKey0},
+		{'1', hostKey1},
+		{'9', hostKey1 + 8},
+		{13, hostKeyReturn},
+		{27, hostKeyEscape},
+		{273, hostKeyUp},
+		{276, hostKeyLeft},
+		{304, hostKeyLeftShift},
+	}
+	for _, c := range cases {
+		if k, ok := retroKeys[c.code]; !ok || k != c.key {
+			t.Errorf("Key code %d: expected %v, got %v\n", c.code, c.key, k)
+		}
+	}
+	if _, ok := retroKeys['A']; ok {
+		t.Error("Expected no mapping for key code 'A'\n")
+	}
+}
+
+func TestRetroState(t *testing.T) {
+	a := newApple2()
+	a.mmu.SetAuxCard(auxCardRamWorks)
+	a.mmu.StoreBytes(0x300, []byte{0xe6, 0x20, 0x4c, 0x00, 0x03}) // INC $20; JMP $0300
+	a.cpu.SetPC(0x300)
+	for i := 0; i < 5; i++ {
+		a.RunFrame()
+	}
+
+	size := retroStateSize(a)
+	if size == 0 {
+		t.Fatal("Expected a snapshot size\n")
+	}
+	count := a.mmu.LoadByte(0x20)
+	buf := make([]byte, size)
+	if !retroSaveState(a, buf) {
+		t.Fatalf("Snapshot didn't fit in %d bytes\n", size)
+	}
+
+	// Fill main memory and every RamWorks bank with noise, which doesn't
+	// compress, and the snapshot must still fit in the size reported
+	// before.
+	rng := rand.New(rand.NewSource(1))
+	rng.Read(a.mmu.mainRAM[0x800:])
+	for _, bank := range a.mmu.auxBanks {
+		rng.Read(bank)
+	}
+	if !retroSaveState(a, make([]byte, size)) {
+		t.Errorf("Snapshot grew beyond the %d bytes reported\n", size)
+	}
+	if retroSaveState(a, make([]byte, 16)) {
+		t.Error("Expected a snapshot not to fit in 16 bytes\n")
+	}
+
+	b := newApple2()
+	if !retroLoadState(b, buf) {
+		t.Fatal("Snapshot didn't load\n")
+	}
+	if v := b.mmu.LoadByte(0x20); v != count {
+		t.Errorf("Expected $20 restored as %02x, got %02x\n", count, v)
+	}
+	if retroLoadState(b, buf[:3]) {
+		t.Error("Expected a truncated snapshot to fail\n")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+)
+
+const (
+	framesPerSecond = cpuClockRate / cyclesPerFrame
+	rewindInterval  = 60 // frames between rewind snapshots
+)
+
+var errRewindDisabled = errors.New("rewind is not enabled")
+
+// A rewindSnapshot is a compressed machine snapshot in the rewind
+// history.
+type rewindSnapshot struct {
+	frame uint64 // frame count at which the snapshot was taken
+	input int    // number of input events recorded before the snapshot
+	data  []byte // snapshot written by SaveState
+}
+
+// A rewindBuffer keeps a history of recent emulation from which the
+// machine can be returned to an earlier moment. Snapshots are taken
+// periodically and kept in a ring, along with the host input applied
+// since the oldest of them. To rewind, the machine loads the last
+// snapshot taken before the target moment and runs forward to it,
+// replaying the recorded input.
+type rewindBuffer struct {
+	apple2 *apple2
+
+	snaps     []rewindSnapshot // oldest first
+	maxSnaps  int              // number of snapshots kept
+	inputs    []inputEvent     // input recorded since the oldest snapshot
+	inputBase int              // number of input events discarded with old snapshots
+	frame     uint64           // frames run since the history began
+}
+
+func newRewindBuffer(apple2 *apple2, seconds int) *rewindBuffer {
+	n := int(float64(seconds)*framesPerSecond/rewindInterval) + 1
+	if n < 2 {
+		n = 2
+	}
+	rw := &rewindBuffer{
+		apple2:   apple2,
+		maxSnaps: n,
+	}
+	rw.Reset()
+	return rw
+}
+
+// Reset discards the rewind history and starts a new one at the current
+// state of the machine.
+func (rw *rewindBuffer) Reset() {
+	rw.snaps = rw.snaps[:0]
+	rw.inputs = rw.inputs[:0]
+	rw.inputBase = 0
+	rw.frame = 0
+	rw.snapshot()
+}
+
+// RecordInput adds an input event to the history.
+func (rw *rewindBuffer) RecordInput(e inputEvent) {
+	rw.inputs = append(rw.inputs, e)
+}
+
+// EndFrame is called after each frame, and takes a snapshot whenever the
+// snapshot interval has elapsed.
+func (rw *rewindBuffer) EndFrame() {
+	rw.frame++
+	if rw.frame%rewindInterval == 0 {
+		rw.snapshot()
+	}
+}
+
+// Seconds returns the length of the history currently available.
+func (rw *rewindBuffer) Seconds() float64 {
+	return float64(rw.frame-rw.snaps[0].frame) / framesPerSecond
+}
+
+func (rw *rewindBuffer) snapshot() {
+	var buf bytes.Buffer
+	if err := rw.apple2.SaveState(&buf); err != nil {
+		return
+	}
+
+	if len(rw.snaps) == rw.maxSnaps {
+		drop := rw.snaps[1].input - rw.inputBase
+		rw.inputs = rw.inputs[:copy(rw.inputs, rw.inputs[drop:])]
+		rw.inputBase += drop
+		rw.snaps = rw.snaps[:copy(rw.snaps, rw.snaps[1:])]
+	}
+	rw.snaps = append(rw.snaps, rewindSnapshot{
+		frame: rw.frame,
+		input: rw.inputBase + len(rw.inputs),
+		data:  buf.Bytes(),
+	})
+}
+
+// Rewind returns the machine to the state it was in the given number of
+// seconds ago, or to the oldest state in the history. The history after
+// that moment is discarded.
+func (rw *rewindBuffer) Rewind(seconds float64) error {
+	frames := uint64(seconds*framesPerSecond + 0.5)
+	target := rw.snaps[0].frame
+	if rw.frame-target > frames {
+		target = rw.frame - frames
+	}
+
+	i := len(rw.snaps) - 1
+	for rw.snaps[i].frame > target {
+		i--
+	}
+	s := rw.snaps[i]
+
+	a := rw.apple2
+	if err := a.restoreState(bytes.NewReader(s.data)); err != nil {
+		return err
+	}
+	rw.snaps = rw.snaps[:i+1]
+	rw.frame = s.frame
+
+	// Run forward to the target frame with the sound muted, replaying the
+	// recorded input. Replayed input is recorded again as it is applied.
+	start := s.input - rw.inputBase
+	replay := append([]inputEvent(nil), rw.inputs[start:]...)
+	rw.inputs = rw.inputs[:start]
+
+	// Breakpoints don't apply while the history is re-run.
+	dbg := a.dbg
+	a.dbg = nil
+	a.in.StartReplay(replay, math.MaxUint64)
+	a.au.discard = true
+	for rw.frame < target {
+		a.RunFrame()
+	}
+	a.au.discard = false
+	a.dbg = dbg
+	a.in.StopReplay()
+	return nil
+}
+
+// EnableRewind starts keeping a history of the given number of seconds of
+// emulation, so that the machine can be rewound.
+func (a *apple2) EnableRewind(seconds int) {
+	a.DisableRewind()
+	a.rewind = newRewindBuffer(a, seconds)
+	a.in.AddRecorder(a.rewind)
+}
+
+// DisableRewind stops keeping a rewind history.
+func (a *apple2) DisableRewind() {
+	if a.rewind != nil {
+		a.in.RemoveRecorder(a.rewind)
+		a.rewind = nil
+	}
+}
+
+// Rewind returns the machine to the state it was in the given number of
+// seconds ago. Rewinding further than the history allows returns the
+// machine to the oldest state available.
+func (a *apple2) Rewind(seconds float64) error {
+	if a.rewind == nil {
+		return errRewindDisabled
+	}
+	return a.rewind.Rewind(seconds)
+}
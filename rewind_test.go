@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestRewind(t *testing.T) {
+	a := newApple2()
+	if err := a.Rewind(1); err != errRewindDisabled {
+		t.Errorf("Expected errRewindDisabled, got %v\n", err)
+	}
+	a.EnableRewind(10)
+
+	// Spin at $0300, so the CPU doesn't touch the keyboard.
+	a.mmu.StoreBytes(0x0300, []byte{0x4c, 0x00, 0x03})
+	a.cpu.SetPC(0x0300)
+
+	type frameState struct {
+		cycles  uint64
+		paddle  byte
+		keydata byte
+	}
+	var states []frameState
+
+	for f := 0; f < 300; f++ {
+		switch f {
+		case 50:
+			a.gi.SetPaddle(0, 10)
+		case 100:
+			a.kb.PushKeyEvent(hostKeyA, true)
+		case 110:
+			a.kb.PushKeyEvent(hostKeyA, false)
+		case 150:
+			a.gi.SetPaddle(0, 200)
+		}
+		a.RunFrame()
+		states = append(states, frameState{a.cpu.Cycles, a.gi.paddles[0], a.kb.keydata})
+	}
+
+	// Frame 200 follows the last snapshot before it, at frame 180, so the
+	// rewind replays 20 frames.
+	if err := a.Rewind(100 / framesPerSecond); err != nil {
+		t.Fatal(err)
+	}
+	expected := states[199]
+	if a.cpu.Cycles != expected.cycles {
+		t.Errorf("Expected cycle %d, got %d\n", expected.cycles, a.cpu.Cycles)
+	}
+
+	// Rewind to frame 120, which replays the key release and paddle moves.
+	if err := a.Rewind(80 / framesPerSecond); err != nil {
+		t.Fatal(err)
+	}
+	expected = states[119]
+	if a.cpu.Cycles != expected.cycles || a.gi.paddles[0] != expected.paddle || a.kb.keydata != expected.keydata {
+		t.Errorf("Expected %+v, got cycle %d, paddle %d, key %02x\n", expected, a.cpu.Cycles, a.gi.paddles[0], a.kb.keydata)
+	}
+	if a.kb.keydata != 'A'|keyStrobe || a.kb.keydown {
+		t.Errorf("Key press not replayed\n")
+	}
+
+	// Rewind beyond the history to the first snapshot.
+	if err := a.Rewind(60); err != nil {
+		t.Fatal(err)
+	}
+	if a.rewind.frame != 0 || a.gi.paddles[0] != 0x80 || a.kb.keydata != 0 {
+		t.Errorf("Expected the initial state after rewinding past the history\n")
+	}
+}
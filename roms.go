@@ -0,0 +1,380 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A romKind identifies the purpose of a ROM image.
+type romKind byte
+
+const (
+	romUnknown      romKind = iota // unrecognized image
+	romSystem                      // system ROM holding the monitor and BASIC
+	romCharacter                   // video character generator ROM
+	romDiskIIBoot16                // Disk II P5 boot ROM for 16-sector disks (341-0027)
+	romDiskIIBoot13                // Disk II P5 boot ROM for 13-sector disks (341-0009)
+	romDiskIISeq                   // Disk II P6 logic state sequencer ROM (341-0028)
+	romSSC                         // Super Serial Card firmware (341-0065)
+	romCFFA                        // CFFA CompactFlash interface card firmware
+)
+
+var romKindNames = []string{
+	/* romUnknown      */ "unrecognized ROM",
+	/* romSystem       */ "system ROM",
+	/* romCharacter    */ "character ROM",
+	/* romDiskIIBoot16 */ "Disk II 16-sector boot ROM",
+	/* romDiskIIBoot13 */ "Disk II 13-sector boot ROM",
+	/* romDiskIISeq    */ "Disk II sequencer ROM",
+	/* romSSC          */ "Super Serial Card ROM",
+	/* romCFFA         */ "CFFA firmware",
+}
+
+func (k romKind) String() string {
+	return romKindNames[k]
+}
+
+// A romImage is an identified ROM image from a ROM set.
+type romImage struct {
+	name  string       // file name within the set
+	kind  romKind      // purpose of the ROM
+	model machineModel // model of a system or character ROM
+	data  []byte       // ROM contents
+	crc   uint32       // CRC-32 checksum of the contents
+
+	verified bool // true = identified as a standard dump by its checksum
+}
+
+func (img *romImage) String() string {
+	verified := ""
+	if !img.verified && img.kind != romUnknown {
+		verified = ", unverified"
+	}
+	switch img.kind {
+	case romSystem, romCharacter:
+		return fmt.Sprintf("%s: %v %v (%d bytes, crc %08x%s)", img.name, img.model, img.kind, len(img.data), img.crc, verified)
+	default:
+		return fmt.Sprintf("%s: %v (%d bytes, crc %08x%s)", img.name, img.kind, len(img.data), img.crc, verified)
+	}
+}
+
+// A knownROM is the purpose of a standard ROM dump.
+type knownROM struct {
+	kind  romKind
+	model machineModel
+}
+
+// knownROMs are the CRC-32 checksums of standard dumps of the ROMs.
+var knownROMs = map[uint32]knownROM{
+	0x1d70b193: {romSystem, modelIIeEnhanced},    // 342-0303 and 342-0304
+	0x64f415c6: {romCharacter, modelIIPlus},      // 341-0036
+	0xb081df66: {romCharacter, modelIIe},         // 342-0133
+	0x2651014d: {romCharacter, modelIIeEnhanced}, // 342-0265
+	0xce7144f6: {romDiskIIBoot16, 0},             // 341-0027
+	0xd34eb2ff: {romDiskIIBoot13, 0},             // 341-0009
+	0xb72a2c70: {romDiskIISeq, 0},                // 341-0028
+}
+
+// A romSet is a collection of ROM images loaded from a directory or a zip
+// file. Images are identified by the checksums of standard dumps, or
+// failing that, by their size and contents, rather than their file names,
+// since ROM dumps circulate under many names. Images identified by their
+// contents are reported as unverified.
+type romSet struct {
+	source string
+	images []*romImage
+}
+
+// loadROMSet loads all ROM images in a directory or zip file.
+func loadROMSet(path string) (*romSet, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readROMSet(path, &zr.Reader, true)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return readROMSet(path, os.DirFS(path), false)
+}
+
+// readROMSet loads all ROM images in a file system, and if deep is true,
+// in its subdirectories. Front ends with no host file system, such as the
+// browser's, read the images from a zip file held in memory.
+func readROMSet(source string, fsys fs.FS, deep bool) (*romSet, error) {
+	rs := &romSet{source: source}
+	err := fs.WalkDir(fsys, ".", func(name string, e fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case e.IsDir() && name != "." && !deep:
+			return fs.SkipDir
+		case e.IsDir():
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		rs.add(path.Base(name), data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rs.images, func(i, j int) bool {
+		return rs.images[i].name < rs.images[j].name
+	})
+	return rs, nil
+}
+
+// add identifies a ROM image and adds it to the set.
+func (rs *romSet) add(name string, data []byte) {
+	img := &romImage{
+		name: name,
+		data: data,
+		crc:  crc32.ChecksumIEEE(data),
+	}
+	if k, ok := knownROMs[img.crc]; ok {
+		img.kind, img.model, img.verified = k.kind, k.model, true
+	} else {
+		img.identify()
+	}
+	rs.images = append(rs.images, img)
+}
+
+// identify identifies a ROM image that isn't a standard dump by its size
+// and contents.
+func (img *romImage) identify() {
+	data := img.data
+	switch len(data) {
+	case 12 * 1024, 16 * 1024, 32 * 1024:
+		// Banked IIc ROMs are identified by their main bank.
+		if m, ok := identifyROM(data[:min16K(len(data))]); ok {
+			img.kind, img.model = romSystem, m
+		}
+
+	case 512, 2 * 1024:
+		// The Super Serial Card firmware's slot ROM page is the last 256
+		// bytes of its 2K image, and holds the Pascal 1.1 firmware
+		// signature with the serial card ID byte $31.
+		if len(data) == 2*1024 && bytes.Equal([]byte{data[0x705], data[0x707], data[0x70b], data[0x70c]}, sscSignature) {
+			img.kind = romSSC
+			break
+		}
+
+		// The II+ character generator holds 8-byte cells of 5x7 glyphs,
+		// which leave bit 7 the same throughout each cell; code and data
+		// in other ROMs of the same size don't.
+		if isGlyphROM(data) {
+			img.kind, img.model = romCharacter, modelIIPlus
+		}
+
+	case 4 * 1024:
+		// The CFFA firmware identifies itself by name, in plain or
+		// high-bit ASCII.
+		if bytes.Contains(data, []byte("CFFA")) || bytes.Contains(data, []byte{0xc3, 0xc6, 0xc6, 0xc1}) {
+			img.kind = romCFFA
+			break
+		}
+
+		// The alternate character set occupies the upper 2K. On the
+		// enhanced IIe and the IIc, characters $40..$5F of that set are
+		// MouseText glyphs; otherwise they repeat the inverse uppercase
+		// characters at $00..$1F.
+		img.kind, img.model = romCharacter, modelIIe
+		alt := data[0x800:]
+		if !bytes.Equal(alt[0x40*8:0x60*8], alt[:0x20*8]) {
+			img.model = modelIIeEnhanced
+		}
+
+	case 256:
+		// The boot ROMs read the disk with LDA $C08C,X and look for
+		// D5 AA 96 (16-sector) or D5 AA B5 (13-sector) address fields.
+		// The P6 sequencer ROM holds state tables with nothing to
+		// recognize, so only its standard dump is identified.
+		switch {
+		case !bytes.Contains(data, []byte{0xbd, 0x8c, 0xc0}):
+		case bytes.Contains(data, []byte{0xc9, 0x96}):
+			img.kind = romDiskIIBoot16
+		case bytes.Contains(data, []byte{0xc9, 0xb5}):
+			img.kind = romDiskIIBoot13
+		}
+	}
+}
+
+// isGlyphROM returns true if every 8-byte cell of a ROM has the same bit 7
+// in all its bytes, as a character generator's glyphs do.
+func isGlyphROM(data []byte) bool {
+	for i := 0; i < len(data); i += 8 {
+		for _, b := range data[i+1 : i+8] {
+			if (b^data[i])&0x80 != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func min16K(n int) int {
+	if n > 16*1024 {
+		return 16 * 1024
+	}
+	return n
+}
+
+// find returns the image of the given kind that best suits a model. For
+// system ROMs, an image whose name matches the model's default ROM file
+// is preferred when several match.
+func (rs *romSet) find(kind romKind, model machineModel) *romImage {
+	var found *romImage
+	for _, img := range rs.images {
+		if img.kind != kind || !romSuitsModel(img, model) {
+			continue
+		}
+		if found == nil || img.name == machineConfigs[model].romFile {
+			found = img
+		}
+	}
+	return found
+}
+
+// romSuitsModel reports whether a system or character ROM works with a
+// model. The II and II+ share a character generator, as do the enhanced
+// IIe and the IIc.
+func romSuitsModel(img *romImage, model machineModel) bool {
+	switch img.kind {
+	case romSystem:
+		return img.model == model
+	case romCharacter:
+		switch model {
+		case modelII, modelIIPlus:
+			return img.model == modelIIPlus
+		case modelIIc:
+			return img.model == modelIIeEnhanced
+		default:
+			return img.model == model
+		}
+	}
+	return true
+}
+
+// SystemROM returns the system ROM for a model.
+func (rs *romSet) SystemROM(model machineModel) ([]byte, error) {
+	img := rs.find(romSystem, model)
+	if img == nil {
+		return nil, rs.missing(fmt.Sprintf("the %v system ROM (a %dK image)", model, machineConfigs[model].romSize/1024))
+	}
+	return img.data, nil
+}
+
+// CharacterROM returns the character generator ROM for a model, if the
+// set has one.
+func (rs *romSet) CharacterROM(model machineModel) ([]byte, bool) {
+	if img := rs.find(romCharacter, model); img != nil {
+		return img.data, true
+	}
+	return nil, false
+}
+
+// DiskIIROM returns the Disk II controller's 16-sector boot ROM.
+func (rs *romSet) DiskIIROM() ([]byte, error) {
+	img := rs.find(romDiskIIBoot16, 0)
+	if img == nil {
+		return nil, rs.missing("the Disk II 16-sector boot ROM (a 256-byte P5 image, 341-0027)")
+	}
+	return img.data, nil
+}
+
+// DiskII13ROM returns the Disk II controller's 13-sector boot ROM, which
+// boots DOS 3.2 and earlier disks.
+func (rs *romSet) DiskII13ROM() ([]byte, error) {
+	img := rs.find(romDiskIIBoot13, 0)
+	if img == nil {
+		return nil, rs.missing("the Disk II 13-sector boot ROM (a 256-byte P5 image, 341-0009)")
+	}
+	return img.data, nil
+}
+
+// SSCROM returns the Super Serial Card firmware.
+func (rs *romSet) SSCROM() ([]byte, error) {
+	img := rs.find(romSSC, 0)
+	if img == nil {
+		return nil, rs.missing("the Super Serial Card ROM (a 2K image, 341-0065)")
+	}
+	return img.data, nil
+}
+
+// CFFAROM returns the CFFA CompactFlash interface card firmware.
+func (rs *romSet) CFFAROM() ([]byte, error) {
+	img := rs.find(romCFFA, 0)
+	if img == nil {
+		return nil, rs.missing("the CFFA firmware (a 4K EPROM image)")
+	}
+	return img.data, nil
+}
+
+// Validate checks that the set holds every ROM needed to run a model,
+// optionally with a Disk II controller card.
+func (rs *romSet) Validate(model machineModel, diskII bool) error {
+	if _, err := rs.SystemROM(model); err != nil {
+		return err
+	}
+	if diskII && machineConfigs[model].slots {
+		if _, err := rs.DiskIIROM(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missing returns an error describing a ROM missing from the set and the
+// images that were found instead.
+func (rs *romSet) missing(what string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s does not contain %s; add it to the ROM set", rs.source, what)
+	if len(rs.images) == 0 {
+		b.WriteString("; it contains no files")
+	} else {
+		b.WriteString("; found:")
+		for _, img := range rs.images {
+			fmt.Fprintf(&b, "\n  %v", img)
+		}
+	}
+	return errors.New(b.String())
+}
+
+// LoadROMSet loads the system ROM and, if the set has one, the character
+// ROM for the machine's model from a ROM set.
+func (a *apple2) LoadROMSet(rs *romSet) error {
+	rom, err := rs.SystemROM(a.cfg.model)
+	if err != nil {
+		return err
+	}
+
+	// Some dumps of 12K ROMs include the unused $C000..$CFFF space.
+	if a.cfg.romBanks == 1 && len(rom) > a.cfg.romSize {
+		rom = rom[len(rom)-a.cfg.romSize:]
+	}
+	if err := a.mmu.LoadSystemROM(bytes.NewReader(rom)); err != nil {
+		return err
+	}
+
+	if rom, ok := rs.CharacterROM(a.cfg.model); ok {
+		return a.ds.LoadCharROM(rom)
+	}
+	return nil
+}
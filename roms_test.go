@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testSystemROM(size int, id1, id2 byte) []byte {
+	rom := make([]byte, size)
+	base := 0x10000 - size
+	rom[0xfbb3-base] = id1
+	rom[0xfbc0-base] = id2
+	return rom
+}
+
+func testBootROM() []byte {
+	rom := make([]byte, 256)
+	copy(rom[0x10:], []byte{0xbd, 0x8c, 0xc0, 0x10, 0xfb, 0xc9, 0x96})
+	return rom
+}
+
+func testBoot13ROM() []byte {
+	rom := make([]byte, 256)
+	copy(rom[0x10:], []byte{0xbd, 0x8c, 0xc0, 0x10, 0xfb, 0xc9, 0xb5})
+	return rom
+}
+
+func TestROMSetIdentify(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a.rom":     testSystemROM(16*1024, 0x06, 0xe0),
+		"b.rom":     testSystemROM(12*1024, 0xea, 0x00),
+		"c.bin":     append(testSystemROM(16*1024, 0x06, 0x00), make([]byte, 16*1024)...),
+		"p5.bin":    testBootROM(),
+		"p5-13.bin": testBoot13ROM(),
+		"video.rom": make([]byte, 4096),
+		"cffa.bin":  append([]byte{0xc3, 0xc6, 0xc6, 0xc1}, make([]byte, 4092)...),
+		"junk.txt":  []byte("hello"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs, err := loadROMSet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []machineModel{modelIIeEnhanced, modelIIPlus, modelIIc} {
+		if err := rs.Validate(m, true); err != nil {
+			t.Errorf("Model %v: %v\n", m, err)
+		}
+	}
+	if rom, err := rs.DiskII13ROM(); err != nil || rom[0x16] != 0xb5 {
+		t.Errorf("Expected a 13-sector boot ROM: %v\n", err)
+	}
+	if _, err := rs.CFFAROM(); err != nil {
+		t.Errorf("Expected the CFFA firmware: %v\n", err)
+	}
+	if _, ok := rs.CharacterROM(modelIIe); !ok {
+		t.Errorf("Expected a IIe character ROM\n")
+	}
+
+	err = rs.Validate(modelIIe, false)
+	if err == nil || !strings.Contains(err.Error(), "a.rom: Enhanced Apple //e system ROM") {
+		t.Errorf("Expected a descriptive error, got %v\n", err)
+	}
+}
+
+func TestROMSetChecksums(t *testing.T) {
+	card := make([]byte, 2*1024)
+	glyphs := make([]byte, 2*1024)
+	seq := make([]byte, 256)
+	for i := range card {
+		card[i] = byte(i * 7)
+		glyphs[i] = byte(i/8) & 0x1f // 5-bit rows, bit 7 clear
+	}
+	for i := range seq {
+		seq[i] = byte(i*13) | 0x08
+	}
+
+	// Only the sequencer's standard dump is known, here the test image.
+	knownROMs[crc32.ChecksumIEEE(seq)] = knownROM{romDiskIISeq, 0}
+	defer delete(knownROMs, crc32.ChecksumIEEE(seq))
+
+	rs, err := readROMSet("mem", fstest.MapFS{
+		"card.bin":   {Data: card},
+		"glyphs.bin": {Data: glyphs},
+		"p6.bin":     {Data: append([]byte(nil), seq[:255]...)},
+		"seq.bin":    {Data: seq},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		kind     romKind
+		verified bool
+	}{
+		{"card.bin", romUnknown, false},
+		{"glyphs.bin", romCharacter, false},
+		{"p6.bin", romUnknown, false},
+		{"seq.bin", romDiskIISeq, true},
+	}
+	for i, c := range cases {
+		img := rs.images[i]
+		if img.name != c.name || img.kind != c.kind || img.verified != c.verified {
+			t.Errorf("%s: expected %v (verified %v), got %v\n", c.name, c.kind, c.verified, img)
+		}
+	}
+	if s := rs.images[1].String(); !strings.HasSuffix(s, ", unverified)") {
+		t.Errorf("Expected an unverified image, got %q\n", s)
+	}
+}
+
+func TestROMSetZip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "roms.zip")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("roms/apple2plus.rom")
+	w.Write(testSystemROM(12*1024, 0xea, 0x00))
+	zw.Close()
+	f.Close()
+
+	rs, err := loadROMSet(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newApple2Model(modelIIPlus)
+	if err := a.LoadROMSet(rs); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.mmu.LoadByte(0xfbb3); v != 0xea {
+		t.Errorf("Expected ROM ID byte ea, got %02x\n", v)
+	}
+
+	if err := rs.Validate(modelIIPlus, true); err == nil {
+		t.Errorf("Expected missing Disk II ROM error\n")
+	}
+}
+
+func TestROMSetFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"apple2plus.rom": {Data: testSystemROM(12*1024, 0xea, 0x00)},
+		"old/p5.bin":     {Data: testBootROM()},
+	}
+	rs, err := readROMSet("mem", fsys, false)
+	if err != nil || len(rs.images) != 1 {
+		t.Fatalf("Expected 1 image: %v\n", err)
+	}
+	if rs, _ = readROMSet("mem", fsys, true); len(rs.images) != 2 || rs.Validate(modelIIPlus, true) != nil {
+		t.Errorf("Expected the images in subdirectories\n")
+	}
+}
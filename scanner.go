@@ -0,0 +1,105 @@
+package main
+
+// Video timing constants for the NTSC Apple2.
+const (
+	cyclesPerLine  = 65                            // CPU cycles per scanline
+	linesPerFrame  = 262                           // scanlines per video field
+	cyclesPerFrame = cyclesPerLine * linesPerFrame // CPU cycles per video field
+	visibleLines   = 192                           // scanlines displayed per field
+	visibleColumns = 40                            // bytes fetched per visible scanline
+	vblStartCycle  = cyclesPerLine * visibleLines  // cycle within a field at which vertical blanking begins
+)
+
+// A videoScanner models the horizontal and vertical counters of the video
+// circuitry. The video hardware fetches a byte from main memory on every
+// CPU cycle, even during blanking. The last byte fetched remains on the data
+// bus, so reads from addresses that don't drive the bus return it. This is
+// known as the "floating bus".
+type videoScanner struct {
+	apple2 *apple2
+
+	// apple2 convenience accessors
+	iou *iou
+	mmu *mmu
+}
+
+func newVideoScanner(apple2 *apple2) *videoScanner {
+	return &videoScanner{
+		apple2: apple2,
+	}
+}
+
+func (vs *videoScanner) Init() {
+	vs.iou = vs.apple2.iou
+	vs.mmu = vs.apple2.mmu
+}
+
+// Position returns the current horizontal (0..64) and vertical (0..261)
+// position of the video scanner. Horizontal positions 25..64 and vertical
+// positions 0..191 are visible.
+func (vs *videoScanner) Position() (h, v int) {
+	c := int(vs.apple2.cpu.Cycles % cyclesPerFrame)
+	return c % cyclesPerLine, c / cyclesPerLine
+}
+
+// InVBL returns true if the video scanner is currently in the vertical
+// blanking interval.
+func (vs *videoScanner) InVBL() bool {
+	_, v := vs.Position()
+	return v >= visibleLines
+}
+
+// Address returns the main memory address currently being fetched by the
+// video scanner.
+func (vs *videoScanner) Address() uint16 {
+	h, v := vs.Position()
+	return vs.scanAddress(h, v)
+}
+
+// FloatingBus returns the value left on the data bus by the most recent
+// video memory fetch.
+func (vs *videoScanner) FloatingBus() byte {
+	return vs.mmu.mainRAM[vs.Address()]
+}
+
+// scanAddress computes the video fetch address for a scanner position
+// using the same adder as the video hardware, so that addresses generated
+// during horizontal and vertical blanking match real hardware.
+func (vs *videoScanner) scanAddress(h, v int) uint16 {
+	// The horizontal counter holds 0x00 during the first cycle of a line,
+	// then counts from 0x40 to 0x7f. Only its low 6 bits reach the adder.
+	var hcount int
+	if h > 0 {
+		hcount = h - 1
+	}
+
+	// The vertical counter counts 0x100..0x1ff and then 0x1fa..0x1ff, so
+	// the final 6 lines of a field repeat the addresses of lines 250..255.
+	line := v
+	if line >= 256 {
+		line -= 6
+	}
+
+	// Low 7 address bits: 0x68 + H + 40*(row / 8), with the offset chosen
+	// so that visible column 0 maps to the start of the row.
+	low := uint16(0x68+hcount+40*((line>>6)&3)) & 0x7f
+	row := uint16((line>>3)&7) << 7
+
+	page2 := vs.iou.testSoftSwitch(ioSwitchPAGE2) && !vs.iou.testSoftSwitch(ioSwitch80STORE)
+	text := vs.iou.testSoftSwitch(ioSwitchTEXT) ||
+		(vs.iou.testSoftSwitch(ioSwitchMIXED) && (line&0xa0) == 0xa0)
+
+	if text || !vs.iou.testSoftSwitch(ioSwitchHIRES) {
+		base := uint16(0x0400)
+		if page2 {
+			base = 0x0800
+		}
+		return base | row | low
+	}
+
+	base := uint16(0x2000)
+	if page2 {
+		base = 0x4000
+	}
+	return base | uint16(line&7)<<10 | row | low
+}
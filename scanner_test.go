@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestScannerAddress(t *testing.T) {
+	a := newApple2()
+
+	cases := []struct {
+		switchAddr uint16
+		h, v       int
+		addr       uint16
+	}{
+		{0xc051, 25, 0, 0x0400},   // text, first visible byte
+		{0xc051, 64, 0, 0x0427},   // text, last visible byte
+		{0xc051, 25, 8, 0x0480},   // text, row 1
+		{0xc051, 25, 64, 0x0428},  // text, row 8
+		{0xc051, 64, 191, 0x07f7}, // text, last visible byte of row 23
+		{0xc050, 25, 0, 0x0400},   // lores
+		{0xc057, 25, 1, 0x2400},   // hires
+		{0xc055, 25, 9, 0x4480},   // hires page 2
+		{0xc053, 25, 160, 0x0a50}, // mixed mode text area, page 2
+	}
+
+	for _, c := range cases {
+		a.mmu.LoadByte(c.switchAddr)
+		a.cpu.Cycles = uint64(c.v*cyclesPerLine + c.h)
+		addr := a.vs.Address()
+		if addr != c.addr {
+			t.Errorf("Switch %04x at (%d,%d): expected %04x, got %04x\n", c.switchAddr, c.h, c.v, c.addr, addr)
+		}
+	}
+}
+
+func TestReadVBL(t *testing.T) {
+	a := newApple2()
+
+	cases := []struct {
+		cycles uint64
+		value  byte
+	}{
+		{0, 0x80},
+		{visibleLines*cyclesPerLine - 1, 0x80},
+		{visibleLines * cyclesPerLine, 0x00},
+		{cyclesPerFrame - 1, 0x00},
+		{cyclesPerFrame, 0x80},
+	}
+
+	for _, c := range cases {
+		a.cpu.Cycles = c.cycles
+		v := a.mmu.LoadByte(0xc019) & 0x80
+		if v != c.value {
+			t.Errorf("Cycle %d: expected %02x, got %02x\n", c.cycles, c.value, v)
+		}
+	}
+}
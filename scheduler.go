@@ -0,0 +1,157 @@
+package main
+
+import "container/heap"
+
+// A scheduler keeps the machine's devices in step with the CPU. Devices
+// read the current CPU cycle from it, and register callbacks that run at
+// a particular cycle, such as when a timer expires, or periodically, such
+// as at the start of every vertical blanking interval. Callbacks run at
+// the first instruction boundary at or after their cycle, in cycle order,
+// with callbacks for the same cycle run in the order they were scheduled.
+type scheduler struct {
+	apple2 *apple2
+
+	events eventQueue // pending events, ordered by cycle
+	seq    uint64     // number of events scheduled so far
+}
+
+// A schedEvent is a callback registered with the scheduler.
+type schedEvent struct {
+	fn     func()
+	at     uint64 // CPU cycle at which the event fires
+	period uint64 // cycles between firings of a periodic event, or 0
+	phase  uint64 // cycle within the period at which a periodic event fires
+	seq    uint64 // orders events scheduled for the same cycle
+	index  int    // position in the event queue, or -1 if not pending
+}
+
+func newScheduler(apple2 *apple2) *scheduler {
+	return &scheduler{
+		apple2: apple2,
+	}
+}
+
+// Now returns the current CPU cycle.
+func (s *scheduler) Now() uint64 {
+	return s.apple2.cpu.Cycles
+}
+
+// At schedules fn to run once at a CPU cycle. A cycle that has already
+// passed runs fn at the next instruction boundary.
+func (s *scheduler) At(cycle uint64, fn func()) *schedEvent {
+	e := &schedEvent{fn: fn, at: cycle}
+	s.push(e)
+	return e
+}
+
+// After schedules fn to run once after a number of CPU cycles.
+func (s *scheduler) After(cycles uint64, fn func()) *schedEvent {
+	return s.At(s.Now()+cycles, fn)
+}
+
+// Every schedules fn to run every period cycles, at each cycle whose
+// offset into the period is phase. Runs missed because the CPU fell
+// behind, such as while the machine was stopped in the debugger, are
+// skipped.
+func (s *scheduler) Every(period, phase uint64, fn func()) *schedEvent {
+	e := &schedEvent{fn: fn, period: period, phase: phase % period}
+	e.at = s.nextPeriod(e)
+	s.push(e)
+	return e
+}
+
+// Cancel removes a pending event. Canceling an event that has already run
+// or been canceled, or a nil event, has no effect.
+func (s *scheduler) Cancel(e *schedEvent) {
+	if e != nil && e.index >= 0 {
+		heap.Remove(&s.events, e.index)
+	}
+}
+
+// Run runs the callbacks of all events that are due. It is called after
+// every instruction the CPU executes.
+func (s *scheduler) Run() {
+	now := s.Now()
+	for len(s.events) > 0 && s.events[0].at <= now {
+		e := s.events[0]
+		if e.period > 0 {
+			e.at += e.period * ((now-e.at)/e.period + 1)
+			e.seq = s.seq
+			s.seq++
+			heap.Fix(&s.events, 0)
+		} else {
+			heap.Pop(&s.events)
+		}
+		e.fn()
+	}
+}
+
+// Reset discards all one-shot events and realigns periodic events with
+// the current cycle. It is called when the cycle count jumps, such as when
+// a snapshot is loaded; devices then schedule their pending events again.
+func (s *scheduler) Reset() {
+	var periodic eventQueue
+	for _, e := range s.events {
+		if e.period > 0 {
+			e.at = s.nextPeriod(e)
+			periodic = append(periodic, e)
+		} else {
+			e.index = -1
+		}
+	}
+	s.events = periodic
+	for i, e := range s.events {
+		e.index = i
+	}
+	heap.Init(&s.events)
+}
+
+// nextPeriod returns the first cycle at or after the current one at which
+// a periodic event fires.
+func (s *scheduler) nextPeriod(e *schedEvent) uint64 {
+	now := s.Now()
+	at := now - now%e.period + e.phase
+	if at < now {
+		at += e.period
+	}
+	return at
+}
+
+func (s *scheduler) push(e *schedEvent) {
+	e.seq = s.seq
+	s.seq++
+	heap.Push(&s.events, e)
+}
+
+// An eventQueue is a min-heap of scheduled events.
+type eventQueue []*schedEvent
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	e := x.(*schedEvent)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
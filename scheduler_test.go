@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScheduler(t *testing.T) {
+	a := newApple2()
+	s := a.sched
+	a.cpu.Cycles = 1000
+
+	var log []string
+	add := func(name string) func() {
+		return func() { log = append(log, fmt.Sprintf("%s@%d", name, a.cpu.Cycles)) }
+	}
+	s.After(20, add("b"))
+	s.After(10, add("a"))
+	s.After(20, add("c"))
+	e := s.After(15, add("canceled"))
+	s.Every(100, 50, add("tick"))
+	s.Cancel(e)
+	s.Cancel(e)
+
+	// Events fire at the first instruction boundary at or after their
+	// cycle, in cycle order and then in the order they were scheduled.
+	for _, step := range []uint64{5, 7, 10, 50} {
+		a.cpu.Cycles += step
+		s.Run()
+	}
+	want := "[a@1012 b@1022 c@1022 tick@1072]"
+	if got := fmt.Sprint(log); got != want {
+		t.Errorf("Expected %s, got %s\n", want, got)
+	}
+
+	// A periodic event that falls behind runs once and skips ahead.
+	log = nil
+	a.cpu.Cycles = 1460
+	s.Run()
+	a.cpu.Cycles = 1549
+	s.Run()
+	a.cpu.Cycles = 1550
+	s.Run()
+	if want := "[tick@1460 tick@1550]"; fmt.Sprint(log) != want {
+		t.Errorf("Expected %s, got %s\n", want, log)
+	}
+
+	// Events may schedule further events, which run in the same pass if
+	// they are already due.
+	log = nil
+	s.After(0, func() { s.After(0, add("nested")) })
+	s.Run()
+	if want := "[nested@1550]"; fmt.Sprint(log) != want {
+		t.Errorf("Expected %s, got %s\n", want, log)
+	}
+
+	// A reset drops one-shot events and realigns periodic ones.
+	log = nil
+	s.After(10, add("dropped"))
+	a.cpu.Cycles = 5000
+	s.Reset()
+	a.cpu.Cycles = 5049
+	s.Run()
+	a.cpu.Cycles = 5050
+	s.Run()
+	if want := "[tick@5050]"; fmt.Sprint(log) != want {
+		t.Errorf("Expected %s, got %s\n", want, log)
+	}
+}
+
+func TestSchedulerVIATimer(t *testing.T) {
+	a := newApple2()
+	mb := newMockingboard(a)
+	a.sm.Insert(4, mb)
+	v := mb.via[0]
+
+	// Start timer 2 as a one-shot of 0x100 cycles.
+	a.mmu.StoreByte(0xc408, 0x00)
+	a.mmu.StoreByte(0xc409, 0x01)
+
+	// The interrupt flag is raised by the scheduled event, without any
+	// access to the chip.
+	a.cpu.Cycles += 0x100
+	a.sched.Run()
+	if v.ifr&viaIntT2 != 0 {
+		t.Errorf("Unexpected timer 2 flag before the counter passed zero\n")
+	}
+	a.cpu.Cycles++
+	a.sched.Run()
+	if v.ifr&viaIntT2 == 0 {
+		t.Errorf("Expected the timer 2 flag when the counter passed zero\n")
+	}
+	if v.timer != nil {
+		t.Errorf("Expected no further timer events after a one-shot\n")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"strconv"
+)
+
+// SaveScreenshot renders the current video display to a PNG file.
+func (a *apple2) SaveScreenshot(filename string, opts screenshotOptions) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(file, a.ds.Screenshot(opts))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// parseScreenshotOptions parses the optional arguments of the screenshot
+// commands: a scale factor, and "mono" or "color".
+func parseScreenshotOptions(args []string) (screenshotOptions, error) {
+	opts := screenshotOptions{scale: 1}
+	for _, arg := range args {
+		switch arg {
+		case "mono":
+			opts.mono = true
+		case "color":
+			opts.mono = false
+		default:
+			v, err := strconv.Atoi(arg)
+			if err != nil || v < 1 || v > 8 {
+				return opts, fmt.Errorf("invalid screenshot option %q", arg)
+			}
+			opts.scale = v
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// scriptTypeTimeout is the number of seconds the type command waits for
+// its text to be typed.
+const scriptTypeTimeout = 60
+
+var errScriptTimeout = errors.New("timed out")
+
+// A scriptExit is returned by a script that ends with the exit command.
+type scriptExit struct {
+	status int
+}
+
+func (e *scriptExit) Error() string {
+	return fmt.Sprintf("exit status %d", e.status)
+}
+
+// A script runs the machine headlessly under the control of a command
+// file, one command per line. Blank lines and lines beginning with # are
+// ignored. Arguments containing spaces are double-quoted, with Go escape
+// sequences, so that "RUN\n" types RUN followed by Return.
+//
+//	boot file             insert a disk into drive 1 and start the machine
+//	disk drive file [mode]
+//	                      insert a disk into drive 1 or 2; a mode of ro
+//	                      discards changes to it, and cow saves them to an
+//	                      overlay file beside it
+//	protect drive on|off  cover or uncover the write-protect notch
+//	eject drive           eject the disk from drive 1 or 2
+//	swap                  swap the disks in drives 1 and 2
+//	wait n[s]             run for n CPU cycles, or n seconds with an s suffix
+//	type text             type text at the keyboard, waiting until it is typed
+//	basic file            load an Applesoft BASIC program listing
+//	load file addr [run]  load a binary file at a hexadecimal address, and
+//	                      optionally start executing it there
+//	waittext text [secs]  run until text appears on the screen (default 10s)
+//	waitpc addr [secs]    run until the CPU is about to execute the
+//	                      instruction at a hexadecimal address (default 10s)
+//	expect text           fail unless text is on the screen
+//	waitdisk [secs]       run until the disk drive motors stop (default 30s)
+//	dump addr len [file] [hex]
+//	                      write memory to a binary or hex dump file, or as
+//	                      hex to the output; addr and len are hexadecimal
+//	loadmem addr file [hex]
+//	                      write a binary or hex dump file to memory at addr
+//	screenshot file [scale] [mono]
+//	                      save the display to a PNG file, scaled up by an
+//	                      integer factor, with graphics in monochrome
+//	exit [status]         end the script with an exit status (default 0)
+//
+// An addr may be prefixed with a physical memory, such as aux: or lc1:, to
+// access it whether or not it is mapped; see parsePhysAddr.
+//
+// A script that reaches its end without an exit command exits with status
+// 0. A command that fails ends the script with an error.
+type script struct {
+	apple2 *apple2
+	out    io.Writer // destination of dump output
+}
+
+// RunScript runs a script read from r, writing output to w. It returns the
+// script's exit status, or an error if a command failed.
+func (a *apple2) RunScript(r io.Reader, w io.Writer) (int, error) {
+	s := &script{apple2: a, out: w}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		args, err := splitScriptLine(sc.Text())
+		if err == nil && len(args) > 0 {
+			err = s.exec(args[0], args[1:])
+		}
+		var exit *scriptExit
+		if errors.As(err, &exit) {
+			return exit.status, nil
+		}
+		if err != nil {
+			return 1, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return 0, sc.Err()
+}
+
+// RunScriptFile runs a script from a file.
+func (a *apple2) RunScriptFile(filename string, w io.Writer) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 1, err
+	}
+	defer file.Close()
+	return a.RunScript(file, w)
+}
+
+// splitScriptLine splits a line into space-separated arguments, any of
+// which may be a double-quoted string.
+func splitScriptLine(line string) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	var args []string
+	for line != "" {
+		if line[0] == '"' {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated string")
+			}
+			arg, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			line = strings.TrimSpace(line[end+1:])
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			i = len(line)
+		}
+		args = append(args, line[:i])
+		line = strings.TrimSpace(line[i:])
+	}
+	return args, nil
+}
+
+func (s *script) exec(cmd string, args []string) error {
+	a := s.apple2
+	nargs := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			return fmt.Errorf("%s: wrong number of arguments", cmd)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(cmd) {
+	case "boot":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		if err := a.InsertDisk(1, args[0]); err != nil {
+			return err
+		}
+		a.cpu.SetPC(a.mmu.LoadAddress(0xfffc))
+
+	case "disk":
+		if err := nargs(2, 3); err != nil {
+			return err
+		}
+		drive, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid drive %q", args[0])
+		}
+		mode := diskModeWrite
+		if len(args) > 2 {
+			if mode, err = parseDiskMode(args[2]); err != nil {
+				return err
+			}
+		}
+		return a.InsertDiskMode(drive, args[1], mode)
+
+	case "protect":
+		if err := nargs(2, 2); err != nil {
+			return err
+		}
+		drive, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid drive %q", args[0])
+		}
+		switch strings.ToLower(args[1]) {
+		case "on":
+			return a.SetWriteProtect(drive, true)
+		case "off":
+			return a.SetWriteProtect(drive, false)
+		}
+		return fmt.Errorf("protect: expected on or off, got %q", args[1])
+
+	case "eject":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		drive, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid drive %q", args[0])
+		}
+		return a.EjectDisk(drive)
+
+	case "swap":
+		if err := nargs(0, 0); err != nil {
+			return err
+		}
+		return a.SwapDisks()
+
+	case "wait":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		cycles, err := parseScriptDuration(args[0])
+		if err != nil {
+			return err
+		}
+		s.run(cycles, nil)
+
+	case "type":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		a.kb.PasteText(args[0])
+		if !s.run(scriptTypeTimeout*cpuClockRate, func() bool { return !a.kb.Pasting() }) {
+			a.kb.CancelPaste()
+			return fmt.Errorf("type: %w waiting for the keyboard to be read", errScriptTimeout)
+		}
+
+	case "basic":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		return a.LoadApplesoftFile(args[0])
+
+	case "load":
+		if err := nargs(2, 3); err != nil {
+			return err
+		}
+		l, err := parseBinaryLoad(strings.Join(args, ","))
+		if err != nil {
+			return err
+		}
+		return a.LoadBinaryFile(l.filename, l.addr, l.run)
+
+	case "waittext":
+		if err := nargs(1, 2); err != nil {
+			return err
+		}
+		timeout := uint64(10 * cpuClockRate)
+		if len(args) > 1 {
+			secs, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid timeout %q", args[1])
+			}
+			timeout = uint64(secs * cpuClockRate)
+		}
+		if !s.run(timeout, func() bool { return a.ScreenContains(args[0]) }) {
+			return fmt.Errorf("waittext %q: %w", args[0], errScriptTimeout)
+		}
+
+	case "waitpc":
+		if err := nargs(1, 2); err != nil {
+			return err
+		}
+		addr, err := parseDebugValue(args[0], 0xffff)
+		if err != nil {
+			return err
+		}
+		g := runGoal{addr: addr}
+		if len(args) > 1 {
+			secs, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid timeout %q", args[1])
+			}
+			g.cycles = uint64(secs * cpuClockRate)
+		}
+		if _, err := a.RunUntil(g); err != nil {
+			return fmt.Errorf("waitpc %s: %w", args[0], errScriptTimeout)
+		}
+
+	case "waitdisk":
+		if err := nargs(0, 1); err != nil {
+			return err
+		}
+		timeout := uint64(30 * cpuClockRate)
+		if len(args) > 0 {
+			secs, err := strconv.ParseFloat(args[0], 64)
+			if err != nil {
+				return fmt.Errorf("invalid timeout %q", args[0])
+			}
+			timeout = uint64(secs * cpuClockRate)
+		}
+		if !s.run(timeout, a.DisksIdle) {
+			return fmt.Errorf("waitdisk: %w", errScriptTimeout)
+		}
+
+	case "expect":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		if !a.ScreenContains(args[0]) {
+			return fmt.Errorf("expected %q on the screen", args[0])
+		}
+
+	case "dump":
+		if err := nargs(2, 4); err != nil {
+			return err
+		}
+		v, start, err := parseMemAddr(args[0])
+		if err != nil {
+			return err
+		}
+		addr, err := parseDebugValue(start, 0xffff)
+		if err != nil {
+			return err
+		}
+		n, err := parseDebugValue(args[1], 0x10000-addr)
+		if err != nil {
+			return err
+		}
+		if len(args) > 2 {
+			hexDump, err := parseHexOption(args[3:])
+			if err != nil {
+				return err
+			}
+			return a.SaveMemoryFile(args[2], v, uint16(addr), n, hexDump)
+		}
+		data, err := a.ReadMemory(v, uint16(addr), n)
+		if err != nil {
+			return err
+		}
+		writeHexDump(s.out, uint16(addr), data)
+
+	case "loadmem":
+		if err := nargs(2, 3); err != nil {
+			return err
+		}
+		v, start, err := parseMemAddr(args[0])
+		if err != nil {
+			return err
+		}
+		addr, err := parseDebugValue(start, 0xffff)
+		if err != nil {
+			return err
+		}
+		hexDump, err := parseHexOption(args[2:])
+		if err != nil {
+			return err
+		}
+		_, err = a.LoadMemoryFile(args[1], v, uint16(addr), hexDump)
+		return err
+
+	case "screenshot":
+		if err := nargs(1, 3); err != nil {
+			return err
+		}
+		opts, err := parseScreenshotOptions(args[1:])
+		if err != nil {
+			return err
+		}
+		return a.SaveScreenshot(args[0], opts)
+
+	case "exit":
+		if err := nargs(0, 1); err != nil {
+			return err
+		}
+		status := 0
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid exit status %q", args[0])
+			}
+			status = v
+		}
+		return &scriptExit{status}
+
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// run runs the machine a frame at a time until done returns true, or for
+// the given number of cycles if done is nil. It returns false if done
+// didn't return true within the limit.
+func (s *script) run(limit uint64, done func() bool) bool {
+	a := s.apple2
+	end := a.cpu.Cycles + limit
+	for {
+		if done != nil && done() {
+			return true
+		}
+		if a.cpu.Cycles >= end {
+			return done == nil
+		}
+		a.RunFrame()
+	}
+}
+
+// parseScriptDuration parses a number of cycles, or a number of seconds
+// with an s suffix, returning cycles.
+func parseScriptDuration(s string) (uint64, error) {
+	if strings.HasSuffix(s, "s") {
+		secs, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || secs < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return uint64(secs * cpuClockRate), nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return v, nil
+}
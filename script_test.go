@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc051) // TEXT on
+	for i, c := range "HELLO" {
+		a.mmu.StoreByte(0x0400+uint16(i), byte(c)|0x80)
+	}
+
+	png := filepath.Join(t.TempDir(), "screen.png")
+	src := `# A test script
+expect HELLO
+wait 1000
+dump 400 5
+screenshot "` + png + `"
+exit 3
+expect "never reached"
+`
+	var out bytes.Buffer
+	start := a.cpu.Cycles
+	status, err := a.RunScript(strings.NewReader(src), &out)
+	if err != nil || status != 3 {
+		t.Fatalf("Expected exit status 3, got %d (%v)\n", status, err)
+	}
+	if a.cpu.Cycles-start < 1000 {
+		t.Errorf("Expected the machine to run 1000 cycles, ran %d\n", a.cpu.Cycles-start)
+	}
+	if out.String() != "0400-C8 C5 CC CC CF\n" {
+		t.Errorf("Unexpected dump %q\n", out.String())
+	}
+	if _, err := os.Stat(png); err != nil {
+		t.Errorf("Screenshot not saved: %v\n", err)
+	}
+
+	// Failures end the script with an error.
+	status, err = a.RunScript(strings.NewReader("\nwaittext \"WORLD\" 0.01\n"), &out)
+	if status != 1 || !errors.Is(err, errScriptTimeout) || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Expected timeout on line 2, got %d (%v)\n", status, err)
+	}
+	if _, err := a.RunScript(strings.NewReader("bogus"), &out); err == nil {
+		t.Errorf("Expected unknown command error\n")
+	}
+}
+
+func TestSplitScriptLine(t *testing.T) {
+	args, err := splitScriptLine(`  type "RUN\n" "a \"b\"" c `)
+	if err != nil || len(args) != 4 || args[1] != "RUN\n" || args[2] != `a "b"` || args[3] != "c" {
+		t.Errorf("Unexpected arguments %q (%v)\n", args, err)
+	}
+	if _, err := splitScriptLine(`type "RUN`); err == nil {
+		t.Errorf("Expected unterminated string error\n")
+	}
+}
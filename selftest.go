@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// selfTestTimeout is the number of cycles the built-in self-test is given
+// to finish. The RAM test alone takes about half a minute on a real
+// machine.
+const selfTestTimeout = 60 * cpuClockRate
+
+var (
+	errSelfTestModel   = errors.New("the model has no built-in self-test")
+	errSelfTestTimeout = errors.New("self-test didn't finish")
+)
+
+// The messages the self-test leaves on the screen. The IIe reports success
+// with "KERNEL OK", and the enhanced IIe and the IIc with "System OK". A
+// failure names the part
+// that failed, such as "RAM:" with the bad chips or "MMU FLAG E1:" with
+// the failing soft switch. Texts are matched regardless of case.
+var (
+	selfTestPass = []string{"KERNEL OK", "SYSTEM OK"}
+	selfTestFail = []string{"ROM:", "RAM:", "MMU FLAG", "IOU FLAG", "MMU:", "IOU:"}
+)
+
+// A selfTestResult is the outcome of the built-in self-test.
+type selfTestResult struct {
+	passed  bool
+	message string // the line of the screen reporting the result
+	cycles  uint64 // cycles the test ran
+}
+
+func (r selfTestResult) String() string {
+	status := "FAIL"
+	if r.passed {
+		status = "PASS"
+	}
+	return fmt.Sprintf("%s: %s (%.1f seconds)", status, r.message, float64(r.cycles)/cpuClockRate)
+}
+
+// RunSelfTest runs the built-in self-test of the IIe or IIc, which the
+// firmware starts when both Apple keys are held during a reset, and
+// returns the result it leaves on the screen.
+func (a *apple2) RunSelfTest() (selfTestResult, error) {
+	if !a.cfg.iie {
+		return selfTestResult{}, errSelfTestModel
+	}
+
+	// The buttons are wired to the Apple keys. Hold them through the
+	// reset, until the firmware has seen them.
+	a.gi.SetButton(0, true)
+	a.gi.SetButton(1, true)
+	a.Reset()
+	a.RunFrame()
+	a.gi.SetButton(0, false)
+	a.gi.SetButton(1, false)
+
+	start := a.cpu.Cycles
+	for a.cpu.Cycles-start < selfTestTimeout {
+		a.RunFrame()
+		if line, ok := a.findScreenLine(selfTestPass); ok {
+			return selfTestResult{passed: true, message: line, cycles: a.cpu.Cycles - start}, nil
+		}
+		if line, ok := a.findScreenLine(selfTestFail); ok {
+			return selfTestResult{message: line, cycles: a.cpu.Cycles - start}, nil
+		}
+	}
+	return selfTestResult{cycles: a.cpu.Cycles - start}, errSelfTestTimeout
+}
+
+// findScreenLine returns the first line of the text screen containing any
+// of the texts, ignoring case, with surrounding spaces trimmed.
+func (a *apple2) findScreenLine(texts []string) (string, bool) {
+	for _, row := range a.ds.TextRunes() {
+		line := string(row)
+		for _, text := range texts {
+			if strings.Contains(strings.ToUpper(line), text) {
+				return strings.TrimSpace(line), true
+			}
+		}
+	}
+	return "", false
+}
+
+// runSelfTestCommand runs the "selftest" subcommand, which runs the
+// built-in self-test of a model with ROMs from a directory and reports
+// the result. The exit status is 0 if the test passed.
+func runSelfTestCommand(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.SetOutput(w)
+	model := fs.String("model", "iie-enhanced", "machine `model`")
+	romDir := fs.String("romdir", "./resources", "`directory` or zip file containing ROM images")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fmt.Fprintf(w, "usage: apple2go selftest [-model model] [-romdir dir]\n")
+		return 2
+	}
+
+	m, ok := parseMachineModel(*model)
+	if !ok {
+		fmt.Fprintf(w, "ERROR: unknown machine model '%s'\n", *model)
+		return 1
+	}
+	a := newApple2Model(m)
+	roms, err := loadROMSet(*romDir)
+	if err == nil {
+		err = roms.Validate(m, false)
+	}
+	if err == nil {
+		err = a.LoadROMSet(roms)
+	}
+	if err == nil {
+		var r selfTestResult
+		if r, err = a.RunSelfTest(); err == nil {
+			fmt.Fprintln(w, r)
+			if !r.passed {
+				return 1
+			}
+			return 0
+		}
+	}
+	fmt.Fprintf(w, "ERROR: %v\n", err)
+	return 1
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestSelfTest(t *testing.T) {
+	a := newApple2Model(modelIIPlus)
+	if _, err := a.RunSelfTest(); err != errSelfTestModel {
+		t.Errorf("Expected %v, got %v\n", errSelfTestModel, err)
+	}
+
+	a = newApple2()
+	if err := a.LoadROM("resources/apple2e.rom"); err != nil {
+		t.Skip(err)
+	}
+	r, err := a.RunSelfTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.passed || r.message != "System OK" {
+		t.Errorf("Expected the self-test to pass, got %v\n", r)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// serialUsage describes the host endpoints a serial port can be connected
+// to.
+const serialUsage = "tcp:host:port, listen:[host]:port, file:path, pty or modem[:[host]:port]"
+
+var errSerialSpec = fmt.Errorf("invalid serial connection; expected %s", serialUsage)
+
+// ConnectSerial connects the serial line of the Super Serial Card or
+// built-in serial port in a slot to a host endpoint:
+//
+//	tcp:host:port     connect to a TCP server, such as a telnet BBS
+//	listen:host:port  accept TCP connections, each replacing the last
+//	file:path         write the output to a file; there is no input
+//	pty               create a pseudo-terminal for a host terminal program
+//	modem             attach a virtual Hayes modem that dials TCP addresses
+//	modem:host:port   attach a modem that also answers TCP connections
+//
+// It returns a description of the endpoint, such as the name of the
+// pseudo-terminal's device, and a closer that shuts it down.
+func (a *apple2) ConnectSerial(slot int, spec string) (string, io.Closer, error) {
+	acia := a.SerialCard(slot)
+	if acia == nil {
+		return "", nil, fmt.Errorf("no serial card in slot %d", slot)
+	}
+
+	f := strings.SplitN(spec, ":", 2)
+	kind, arg := f[0], ""
+	if len(f) > 1 {
+		arg = f[1]
+	}
+	switch kind {
+	case "tcp":
+		conn, err := net.Dial("tcp", arg)
+		if err != nil {
+			return "", nil, err
+		}
+		acia.Connect(conn)
+		return conn.RemoteAddr().String(), closerFunc(acia.Disconnect), nil
+
+	case "listen":
+		l, err := net.Listen("tcp", arg)
+		if err != nil {
+			return "", nil, err
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				acia.Connect(conn)
+			}
+		}()
+		return l.Addr().String(), closerFunc(func() {
+			l.Close()
+			acia.Disconnect()
+		}), nil
+
+	case "file":
+		if arg == "" {
+			return "", nil, errSerialSpec
+		}
+		f, err := os.Create(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		acia.Connect(newWriteOnlyConn(f))
+		return arg, closerFunc(acia.Disconnect), nil
+
+	case "modem":
+		m := newHayesModem()
+		desc := "modem"
+		if arg != "" {
+			addr, err := m.Listen(arg)
+			if err != nil {
+				return "", nil, err
+			}
+			desc = "modem answering on " + addr.String()
+		}
+		acia.Connect(m)
+		return desc, closerFunc(acia.Disconnect), nil
+
+	case "pty":
+		if arg != "" {
+			return "", nil, errSerialSpec
+		}
+		conn, name, err := openPTY()
+		if err != nil {
+			return "", nil, err
+		}
+		acia.Connect(conn)
+		return name, closerFunc(acia.Disconnect), nil
+	}
+	return "", nil, errSerialSpec
+}
+
+// A closerFunc adapts a function to the io.Closer interface.
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+// A writeOnlyConn is a serial connection that writes to a file and never
+// receives anything. Reads block until the connection is closed.
+type writeOnlyConn struct {
+	w    io.WriteCloser
+	once sync.Once
+	done chan struct{}
+}
+
+func newWriteOnlyConn(w io.WriteCloser) *writeOnlyConn {
+	return &writeOnlyConn{w: w, done: make(chan struct{})}
+}
+
+func (c *writeOnlyConn) Read(b []byte) (int, error) {
+	<-c.done
+	return 0, io.EOF
+}
+
+func (c *writeOnlyConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func (c *writeOnlyConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		err = c.w.Close()
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux termios control flags missing from the syscall package.
+const (
+	termiosCBAUD   = 0x100f     // baud rate mask, including CBAUDEX
+	termiosCRTSCTS = 0x80000000 // RTS/CTS hardware flow control
+)
+
+// termiosBaudRates maps baud rates to their termios speed codes.
+var termiosBaudRates = map[int]uint32{
+	300:    syscall.B300,
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+}
+
+// openSerialDevice opens a host serial port, such as /dev/ttyUSB0, in raw
+// mode with eight data bits, no parity and one stop bit at a baud rate,
+// optionally with RTS/CTS hardware flow control.
+func openSerialDevice(name string, baud int, rtscts bool) (io.ReadWriteCloser, error) {
+	speed, ok := termiosBaudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a serial port: %v", name, err)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | termiosCBAUD | termiosCRTSCTS
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	if rtscts {
+		t.Cflag |= termiosCRTSCTS
+	}
+	t.Ispeed, t.Ospeed = speed, speed
+	t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// openSerialDevice reports that host serial ports aren't supported on
+// this platform.
+func openSerialDevice(name string, baud int, rtscts bool) (io.ReadWriteCloser, error) {
+	return nil, errors.New("host serial ports are not supported on this platform")
+}
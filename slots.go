@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A card is a peripheral card installed in one of the Apple2's expansion
+// slots. Each slot n has 16 bytes of device I/O space at $C0n0..$C0nF
+// (for n >= 1, starting at $C090) and 256 bytes of I/O ROM space at
+// $Cn00..$CnFF. Addresses passed to a card are offsets within the space
+// being accessed.
+type card interface {
+	LoadIO(addr uint16) byte
+	StoreIO(addr uint16, v byte)
+	LoadROM(addr uint16) byte
+	StoreROM(addr uint16, v byte)
+}
+
+// An expansionROMCard is a card with an expansion ROM, which it maps into
+// $C800..$CFFF while selected. A card is selected by an access to its I/O
+// ROM space, and all cards are deselected by an access to $CFFF. Addresses
+// passed to a card are offsets from $C800.
+type expansionROMCard interface {
+	LoadExpansionROM(addr uint16) byte
+	StoreExpansionROM(addr uint16, v byte)
+}
+
+// A resetCard is a card that responds to the RESET line.
+type resetCard interface {
+	Reset()
+}
+
+const numSlots = 8
+
+var (
+	errNoSlots  = errors.New("the model has no expansion slots")
+	errSlot     = errors.New("no such slot")
+	errNoCard   = errors.New("no card in slot")
+	errSlotFull = errors.New("slot is occupied")
+)
+
+// The slotManager tracks the cards installed in the expansion slots and
+// dispatches accesses to their I/O and ROM spaces.
+type slotManager struct {
+	apple2 *apple2
+
+	// apple2 convenience accessors
+	iou *iou
+	vs  *videoScanner
+
+	cards    [numSlots]card
+	disabled [numSlots]card // cards pulled out by Disable
+
+	expansion int  // slot whose expansion ROM is selected, or 0 if none
+	intC8ROM  bool // IIe internal ROM selected at $C800..$CFFF (INTC8ROM)
+}
+
+func newSlotManager(apple2 *apple2) *slotManager {
+	return &slotManager{
+		apple2: apple2,
+	}
+}
+
+func (sm *slotManager) Init() {
+	sm.iou = sm.apple2.iou
+	sm.vs = sm.apple2.vs
+
+	b := sm.apple2.mmu.GetBank(bankSlotROM, bankTypeMain)
+	b.setAccessor(&slotROMBankAccessor{sm: sm})
+	b = sm.apple2.mmu.GetBank(bankExpansionROM, bankTypeMain)
+	b.setAccessor(&expansionROMBankAccessor{sm: sm})
+}
+
+// Card returns the card installed in a slot, or nil if the slot is empty.
+func (sm *slotManager) Card(slot int) card {
+	return sm.cards[slot]
+}
+
+// Insert installs a card into a slot, replacing any card already there.
+func (sm *slotManager) Insert(slot int, c card) {
+	sm.Remove(slot)
+	sm.disabled[slot] = nil
+	sm.cards[slot] = c
+	if src, ok := c.(audioSource); ok {
+		sm.apple2.au.AddSource(src)
+	}
+	sm.apple2.log.Logger(logCards).Debug("card inserted", "slot", slot, "card", cardName(c))
+}
+
+// Remove removes the card installed in a slot.
+func (sm *slotManager) Remove(slot int) {
+	c := sm.cards[slot]
+	if c == nil {
+		return
+	}
+	if src, ok := c.(audioSource); ok {
+		sm.apple2.au.RemoveSource(src)
+	}
+	sm.cards[slot] = nil
+	if sm.expansion == slot {
+		sm.expansion = 0
+	}
+	sm.apple2.log.Logger(logCards).Debug("card removed", "slot", slot, "card", cardName(c))
+}
+
+// Disable pulls the card out of a slot, keeping it so that Enable can put
+// it back. Pulling a card cuts its power, so it's reset, which releases
+// its interrupt line.
+func (sm *slotManager) Disable(slot int) {
+	c := sm.cards[slot]
+	if c == nil {
+		return
+	}
+	sm.Remove(slot)
+	if r, ok := c.(resetCard); ok {
+		r.Reset()
+	}
+	sm.apple2.ints.SetIRQ(c, false)
+	sm.disabled[slot] = c
+}
+
+// Enable puts back the card pulled out of a slot by Disable. It returns
+// false if there is none.
+func (sm *slotManager) Enable(slot int) bool {
+	c := sm.disabled[slot]
+	if c == nil || sm.cards[slot] != nil {
+		return false
+	}
+	sm.Insert(slot, c)
+	return true
+}
+
+// Disabled returns the card pulled out of a slot, or nil if there is none.
+func (sm *slotManager) Disabled(slot int) card {
+	return sm.disabled[slot]
+}
+
+// Reset deselects the expansion ROMs and asserts the RESET line of each
+// installed card.
+func (sm *slotManager) Reset() {
+	sm.deselectExpansionROM()
+	for _, c := range sm.cards {
+		if r, ok := c.(resetCard); ok {
+			r.Reset()
+		}
+	}
+}
+
+// cardName returns the name of a kind of card.
+func cardName(c card) string {
+	switch c.(type) {
+	case *diskII:
+		return "Disk II controller"
+	case *mockingboard:
+		return "Mockingboard"
+	case *superSerialCard:
+		return "Super Serial Card"
+	case *appleMouseCard:
+		return "AppleMouse card"
+	case *smartPortCard:
+		return "SmartPort card"
+	case *uthernetCard:
+		return "Uthernet II card"
+	case *thunderClock:
+		return "ThunderClock"
+	case *parallelPrinterCard:
+		return "parallel printer card"
+	case *iicSerialPort:
+		return "built-in serial port"
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+// checkSlot returns an error if the machine has no expansion slot n.
+func (a *apple2) checkSlot(slot int) error {
+	switch {
+	case !a.cfg.slots:
+		return errNoSlots
+	case slot < 1 || slot >= numSlots:
+		return errSlot
+	}
+	return nil
+}
+
+// DisableCard pulls the card out of a slot while the machine runs. The
+// slot is empty until EnableCard puts the card back. A disk controller
+// saves its modified disks first, after any write in progress.
+func (a *apple2) DisableCard(slot int) error {
+	if err := a.checkSlot(slot); err != nil {
+		return err
+	}
+	c := a.sm.Card(slot)
+	if c == nil {
+		return errNoCard
+	}
+	if d, ok := c.(*diskII); ok {
+		return d.change(func() error {
+			if err := d.Flush(); err != nil {
+				return err
+			}
+			a.sm.Disable(slot)
+			return nil
+		})
+	}
+	a.sm.Disable(slot)
+	return nil
+}
+
+// EnableCard puts back the card pulled out of a slot by DisableCard.
+func (a *apple2) EnableCard(slot int) error {
+	if err := a.checkSlot(slot); err != nil {
+		return err
+	}
+	switch {
+	case a.sm.Card(slot) != nil:
+		return errSlotFull
+	case !a.sm.Enable(slot):
+		return errNoCard
+	}
+	return nil
+}
+
+// ExpansionROM returns the slot whose expansion ROM is selected at
+// $C800..$CFFF, 0 if none is, or -1 if the IIe's internal ROM is.
+func (sm *slotManager) ExpansionROM() int {
+	if sm.intC8ROM {
+		return -1
+	}
+	return sm.expansion
+}
+
+// expansionCard returns the card whose expansion ROM is selected, or nil
+// if none is or the selected card has no expansion ROM.
+func (sm *slotManager) expansionCard() expansionROMCard {
+	if sm.intC8ROM || sm.expansion == 0 {
+		return nil
+	}
+	c, _ := sm.cards[sm.expansion].(expansionROMCard)
+	return c
+}
+
+// selectExpansionROM selects the expansion ROM of the card in a slot, as
+// an access to the slot's I/O ROM space does.
+func (sm *slotManager) selectExpansionROM(slot int) {
+	if slot != sm.expansion && sm.apple2.log.Enabled(logCards, levelTrace) {
+		sm.apple2.log.Logger(logCards).Log(context.Background(), levelTrace, "expansion ROM selected", "slot", slot)
+	}
+	sm.expansion = slot
+}
+
+// selectInternalC8ROM selects the IIe's internal ROM at $C800..$CFFF, as
+// an access to the internal $C3 ROM does. The 80-column firmware relies
+// on this to run its routines in the internal expansion ROM.
+func (sm *slotManager) selectInternalC8ROM() {
+	sm.intC8ROM = true
+}
+
+// deselectExpansionROM deselects all expansion ROMs, as an access to
+// $CFFF does.
+func (sm *slotManager) deselectExpansionROM() {
+	sm.expansion = 0
+	sm.intC8ROM = false
+}
+
+// LoadIO handles a read from the device I/O space ($C090..$C0FF). The
+// address is relative to $C000.
+func (sm *slotManager) LoadIO(addr uint16) byte {
+	slot := (addr >> 4) - 8
+	sm.slotAccess(int(slot))
+	if c := sm.cards[slot]; c != nil {
+		return c.LoadIO(addr & 0x0f)
+	}
+	return sm.vs.FloatingBus()
+}
+
+// StoreIO handles a write to the device I/O space ($C090..$C0FF). The
+// address is relative to $C000.
+func (sm *slotManager) StoreIO(addr uint16, v byte) {
+	slot := (addr >> 4) - 8
+	sm.slotAccess(int(slot))
+	if c := sm.cards[slot]; c != nil {
+		c.StoreIO(addr&0x0f, v)
+	}
+}
+
+// slotAccess tells an accelerator that a slot's I/O space was accessed,
+// so it can slow down for cards that need 1 MHz timing.
+func (sm *slotManager) slotAccess(slot int) {
+	if z := sm.apple2.zip; z != nil {
+		z.SlotAccess(slot)
+	}
+}
+
+// slotROMBankAccessor dispatches accesses to $C100..$C7FF to the I/O ROM
+// space of the installed cards.
+type slotROMBankAccessor struct {
+	sm *slotManager
+}
+
+func (a *slotROMBankAccessor) LoadByte(addr uint16) byte {
+	slot := (addr >> 8) + 1
+	a.sm.selectExpansionROM(int(slot))
+	if c := a.sm.cards[slot]; c != nil {
+		return c.LoadROM(addr & 0xff)
+	}
+	return a.sm.vs.FloatingBus()
+}
+
+func (a *slotROMBankAccessor) StoreByte(addr uint16, v byte) {
+	slot := (addr >> 8) + 1
+	a.sm.selectExpansionROM(int(slot))
+	if c := a.sm.cards[slot]; c != nil {
+		c.StoreROM(addr&0xff, v)
+	}
+}
+
+func (a *slotROMBankAccessor) CopyBytes(b []byte) {
+	// Do nothing
+}
+
+// expansionROMBankAccessor dispatches accesses to $C800..$CFFF to the
+// selected expansion ROM. Reads float when no expansion ROM is selected.
+type expansionROMBankAccessor struct {
+	sm *slotManager
+}
+
+func (a *expansionROMBankAccessor) LoadByte(addr uint16) byte {
+	var v byte
+	if a.sm.intC8ROM {
+		v = a.sm.apple2.mmu.systemROM[0x0800+addr]
+	} else if c := a.sm.expansionCard(); c != nil {
+		v = c.LoadExpansionROM(addr)
+	} else {
+		v = a.sm.vs.FloatingBus()
+	}
+	if addr == 0x07ff {
+		a.sm.deselectExpansionROM()
+	}
+	return v
+}
+
+func (a *expansionROMBankAccessor) StoreByte(addr uint16, v byte) {
+	if c := a.sm.expansionCard(); c != nil {
+		c.StoreExpansionROM(addr, v)
+	}
+	if addr == 0x07ff {
+		a.sm.deselectExpansionROM()
+	}
+}
+
+func (a *expansionROMBankAccessor) CopyBytes(b []byte) {
+	// Do nothing
+}
+
+// internalC3ROMBankAccessor handles accesses to the IIe's internal $C3
+// ROM, which select its internal expansion ROM.
+type internalC3ROMBankAccessor struct {
+	romBankAccessor
+	sm *slotManager
+}
+
+func (a *internalC3ROMBankAccessor) LoadByte(addr uint16) byte {
+	a.sm.selectInternalC8ROM()
+	return a.mem[addr]
+}
+
+func (a *internalC3ROMBankAccessor) StoreByte(addr uint16, v byte) {
+	a.sm.selectInternalC8ROM()
+}
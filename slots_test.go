@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestCardHotSwap(t *testing.T) {
+	a := newApple2()
+	mb := newMockingboard(a)
+	a.sm.Insert(4, mb)
+
+	// Let timer 1 interrupt.
+	a.mmu.StoreByte(0xc40e, 0xc0)
+	a.mmu.StoreByte(0xc404, 0x00)
+	a.mmu.StoreByte(0xc405, 0x10)
+	a.cpu.Cycles += 0x1001
+	a.sched.Run()
+	if !a.ints.IRQ() {
+		t.Fatalf("Expected the timer to interrupt\n")
+	}
+
+	// Pulling the card releases its interrupt line.
+	if err := a.DisableCard(4); err != nil {
+		t.Fatal(err)
+	}
+	if a.sm.Card(4) != nil || a.sm.Disabled(4) != mb {
+		t.Errorf("Card not pulled out\n")
+	}
+	if a.ints.IRQ() {
+		t.Errorf("Pulled card still interrupting\n")
+	}
+
+	if err := a.EnableCard(4); err != nil || a.sm.Card(4) != mb {
+		t.Errorf("Card not put back: %v\n", err)
+	}
+	if mb.IRQ() {
+		t.Errorf("Card not reset when pulled out\n")
+	}
+
+	errs := []struct {
+		err  error
+		want error
+	}{
+		{a.EnableCard(4), errSlotFull},
+		{a.DisableCard(5), errNoCard},
+		{a.EnableCard(5), errNoCard},
+		{a.DisableCard(8), errSlot},
+		{newApple2Model(modelIIc).DisableCard(6), errNoSlots},
+	}
+	for i, e := range errs {
+		if e.err != e.want {
+			t.Errorf("Case %d: expected %v, got %v\n", i, e.want, e.err)
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SmartPort commands. ProDOS block driver calls use the first four.
+const (
+	spCmdStatus     = 0x00
+	spCmdReadBlock  = 0x01
+	spCmdWriteBlock = 0x02
+	spCmdFormat     = 0x03
+	spCmdControl    = 0x04
+	spCmdInit       = 0x05
+	spCmdOpen       = 0x06
+	spCmdClose      = 0x07
+	spCmdRead       = 0x08
+	spCmdWrite      = 0x09
+)
+
+// SmartPort and ProDOS error codes.
+const (
+	spErrOK         = 0x00
+	spErrBadCmd     = 0x01
+	spErrBadPCnt    = 0x04
+	spErrBadUnit    = 0x11
+	spErrBadCtl     = 0x21
+	spErrIO         = 0x27
+	spErrNoDrive    = 0x28
+	spErrNoWrite    = 0x2b
+	spErrBadBlock   = 0x2d
+	spErrOffline    = 0x2f
+	spErrDeviceBase = 0x30 // device-specific errors are $30..$3F
+)
+
+// SmartPort general status bits, returned by STATUS code 0 and in the
+// device information block.
+const (
+	spStatusBlock     = 1 << 7 // block device
+	spStatusWrite     = 1 << 6 // write allowed
+	spStatusRead      = 1 << 5 // read allowed
+	spStatusOnline    = 1 << 4 // device online or disk in drive
+	spStatusProtected = 1 << 2 // write protected
+	spStatusOpen      = 1 << 0 // character device open
+)
+
+// SmartPort device types, as reported in device information blocks.
+const (
+	spTypeHardDisk = 0x02
+	spTypeNetwork  = 0x10
+)
+
+// I/O offsets through which the card's firmware passes calls to the
+// emulation and returns their results.
+const (
+	spIOProDOS    = 0 // write: ProDOS block driver call
+	spIOError     = 1 // read: error code
+	spIOXReg      = 2 // read: X register result
+	spIOYReg      = 3 // read: Y register result
+	spIOSmartPort = 4 // write: SmartPort call
+	spIOBoot      = 5 // write: read block 0 of unit 1 to $0800
+)
+
+// ProDOS block driver parameters in the zero page.
+const (
+	prodosCommand = 0x42
+	prodosUnit    = 0x43
+	prodosBuffer  = 0x44
+	prodosBlock   = 0x46
+)
+
+// A smartPortDevice is a device attached to a SmartPort card. STATUS
+// calls with codes 0 and 3 are answered by the card from the device's
+// name, type and general status; other codes go to the device.
+type smartPortDevice interface {
+	Name() string
+	DeviceType() byte
+	GeneralStatus() byte
+	Status(code byte) ([]byte, byte)
+	Control(code byte, data []byte) byte
+}
+
+// A smartPortBlockDevice is a SmartPort device holding 512-byte blocks,
+// which ProDOS can use as a volume.
+type smartPortBlockDevice interface {
+	smartPortDevice
+	Blocks() int
+	ReadBlock(block int, buf []byte) byte
+	WriteBlock(block int, buf []byte) byte
+}
+
+// A smartPortCharDevice is a SmartPort device that transfers streams of
+// bytes.
+type smartPortCharDevice interface {
+	smartPortDevice
+	Read(n int) ([]byte, byte)
+	Write(data []byte) byte
+}
+
+// A smartPortCard emulates a SmartPort interface card. In place of a bus
+// to real drives, its devices are emulated, and its built-in firmware
+// hands ProDOS block driver and SmartPort calls to the emulation, which
+// reads the call's parameters from memory. The card boots from its first
+// block device.
+type smartPortCard struct {
+	apple2   *apple2
+	slot     int
+	devices  []smartPortDevice
+	firmware [256]byte
+	err      byte // results of the last call
+	x, y     byte
+}
+
+func newSmartPortCard(apple2 *apple2, slot int) *smartPortCard {
+	c := &smartPortCard{apple2: apple2, slot: slot}
+
+	// $Cn00: LDX #$20; LDY #$00; LDX #$03; LDX #$00 (SmartPort signature)
+	// $Cn08: STA $C0n5; LDA $C0n1; BNE $Cn15; LDX #$n0; JMP $0801
+	// $Cn15: JMP $E000
+	// $Cn20: JMP $Cn30 (ProDOS entry)
+	// $Cn23: JMP $Cn40 (SmartPort entry)
+	// $Cn30: STA $C0n0; JMP $Cn43
+	// $Cn40: STA $C0n4
+	// $Cn43: LDX $C0n2; LDY $C0n3; LDA $C0n1; CMP #$01; RTS
+	io := byte(0x80 + slot<<4)
+	page := byte(0xc0 + slot)
+	fw := c.firmware[:]
+	copy(fw, []byte{
+		0xa2, 0x20, 0xa0, 0x00, 0xa2, 0x03, 0xa2, 0x00,
+		0x8d, io + spIOBoot, 0xc0, 0xad, io + spIOError, 0xc0, 0xd0, 0x05, 0xa2, byte(slot << 4), 0x4c, 0x01, 0x08,
+		0x4c, 0x00, 0xe0,
+	})
+	copy(fw[0x20:], []byte{0x4c, 0x30, page, 0x4c, 0x40, page})
+	copy(fw[0x30:], []byte{0x8d, io + spIOProDOS, 0xc0, 0x4c, 0x43, page})
+	copy(fw[0x40:], []byte{
+		0x8d, io + spIOSmartPort, 0xc0,
+		0xae, io + spIOXReg, 0xc0, 0xac, io + spIOYReg, 0xc0, 0xad, io + spIOError, 0xc0, 0xc9, 0x01, 0x60,
+	})
+	fw[0xfc], fw[0xfd] = 0, 0 // block count from STATUS
+	fw[0xfe] = 0x97           // removable, 2 volumes, read, write and status
+	fw[0xff] = 0x20           // ProDOS entry
+	return c
+}
+
+// Attach adds a device to the card as the next SmartPort unit.
+func (c *smartPortCard) Attach(d smartPortDevice) {
+	c.devices = append(c.devices, d)
+}
+
+// Device returns the device of a SmartPort unit, or nil if there is none.
+func (c *smartPortCard) Device(unit int) smartPortDevice {
+	if unit < 1 || unit > len(c.devices) {
+		return nil
+	}
+	return c.devices[unit-1]
+}
+
+// blockDevice returns the nth block device, which ProDOS sees as drive
+// n+1.
+func (c *smartPortCard) blockDevice(n int) smartPortBlockDevice {
+	for _, d := range c.devices {
+		if b, ok := d.(smartPortBlockDevice); ok {
+			if n == 0 {
+				return b
+			}
+			n--
+		}
+	}
+	return nil
+}
+
+func (c *smartPortCard) LoadIO(addr uint16) byte {
+	switch addr {
+	case spIOError:
+		return c.err
+	case spIOXReg:
+		return c.x
+	case spIOYReg:
+		return c.y
+	}
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *smartPortCard) StoreIO(addr uint16, v byte) {
+	c.x, c.y = 0, 0
+	switch addr {
+	case spIOProDOS:
+		c.err = c.prodosCall()
+	case spIOSmartPort:
+		c.err = c.smartPortCall()
+	case spIOBoot:
+		c.err = c.readBlock(c.blockDevice(0), 0, 0x0800)
+	}
+}
+
+func (c *smartPortCard) LoadROM(addr uint16) byte {
+	return c.firmware[addr]
+}
+
+func (c *smartPortCard) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// Close saves the block devices that were written.
+func (c *smartPortCard) Close() error {
+	var err error
+	for _, d := range c.devices {
+		if b, ok := d.(interface{ Flush() error }); ok {
+			if serr := b.Flush(); err == nil {
+				err = serr
+			}
+		}
+	}
+	return err
+}
+
+func (c *smartPortCard) load16(addr uint16) uint16 {
+	m := c.apple2.mmu
+	return uint16(m.LoadByte(addr)) | uint16(m.LoadByte(addr+1))<<8
+}
+
+// prodosCall runs a ProDOS block driver call.
+func (c *smartPortCard) prodosCall() byte {
+	m := c.apple2.mmu
+	cmd := m.LoadByte(prodosCommand)
+	d := c.blockDevice(int(m.LoadByte(prodosUnit) >> 7))
+	if d == nil {
+		return spErrNoDrive
+	}
+	buf := c.load16(prodosBuffer)
+	block := int(c.load16(prodosBlock))
+
+	switch cmd {
+	case spCmdStatus:
+		n := d.Blocks()
+		c.x, c.y = byte(n), byte(n>>8)
+		if d.GeneralStatus()&spStatusProtected != 0 {
+			return spErrNoWrite
+		}
+	case spCmdReadBlock:
+		return c.readBlock(d, block, buf)
+	case spCmdWriteBlock:
+		return c.writeBlock(d, block, buf)
+	case spCmdFormat:
+	default:
+		return spErrBadCmd
+	}
+	return spErrOK
+}
+
+func (c *smartPortCard) readBlock(d smartPortBlockDevice, block int, buf uint16) byte {
+	if d == nil {
+		return spErrNoDrive
+	}
+	data := make([]byte, 512)
+	if err := d.ReadBlock(block, data); err != spErrOK {
+		return err
+	}
+	for i, b := range data {
+		c.apple2.mmu.StoreByte(buf+uint16(i), b)
+	}
+	return spErrOK
+}
+
+func (c *smartPortCard) writeBlock(d smartPortBlockDevice, block int, buf uint16) byte {
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = c.apple2.mmu.LoadByte(buf + uint16(i))
+	}
+	return d.WriteBlock(block, data)
+}
+
+// smartPortCall runs a SmartPort call. The command and parameter list
+// address follow the caller's JSR, and the return address on the stack is
+// moved past them.
+func (c *smartPortCard) smartPortCall() byte {
+	m := c.apple2.mmu
+	sp := uint16(c.apple2.cpu.Reg.SP)
+	ret := uint16(m.LoadByte(0x100+(sp+1)&0xff)) | uint16(m.LoadByte(0x100+(sp+2)&0xff))<<8
+	cmd := m.LoadByte(ret + 1)
+	params := c.load16(ret + 2)
+	ret += 3
+	m.StoreByte(0x100+(sp+1)&0xff, byte(ret))
+	m.StoreByte(0x100+(sp+2)&0xff, byte(ret>>8))
+
+	// Extended calls, with 32-bit addresses, aren't supported.
+	if cmd&0x40 != 0 {
+		return spErrBadCmd
+	}
+	counts := [...]byte{3, 3, 3, 1, 3, 1, 1, 1, 4, 4}
+	if int(cmd) >= len(counts) {
+		return spErrBadCmd
+	}
+	if m.LoadByte(params) != counts[cmd] {
+		return spErrBadPCnt
+	}
+	unit := int(m.LoadByte(params + 1))
+	buf := c.load16(params + 2)
+
+	if unit == 0 {
+		if cmd != spCmdStatus || m.LoadByte(params+4) != 0 {
+			return spErrBadUnit
+		}
+		// The SmartPort's status: the number of devices, no interrupts,
+		// and vendor, version and reserved words.
+		return c.transfer(buf, []byte{byte(len(c.devices)), 0, 0, 0, 0, 0, 0, 0})
+	}
+	d := c.Device(unit)
+	if d == nil {
+		return spErrBadUnit
+	}
+
+	switch cmd {
+	case spCmdStatus:
+		code := m.LoadByte(params + 4)
+		switch code {
+		case 0:
+			return c.transfer(buf, c.generalStatus(d))
+		case 3:
+			return c.transfer(buf, c.deviceInfo(d))
+		}
+		data, err := d.Status(code)
+		if err != spErrOK {
+			return err
+		}
+		return c.transfer(buf, data)
+	case spCmdReadBlock, spCmdWriteBlock:
+		b, ok := d.(smartPortBlockDevice)
+		if !ok {
+			return spErrBadCmd
+		}
+		block := int(c.load16(params+4)) | int(m.LoadByte(params+6))<<16
+		if cmd == spCmdReadBlock {
+			return c.readBlock(b, block, buf)
+		}
+		return c.writeBlock(b, block, buf)
+	case spCmdControl:
+		n := c.load16(buf)
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = m.LoadByte(buf + 2 + uint16(i))
+		}
+		return d.Control(m.LoadByte(params+4), data)
+	case spCmdRead, spCmdWrite:
+		ch, ok := d.(smartPortCharDevice)
+		if !ok {
+			return spErrBadCmd
+		}
+		n := int(c.load16(params + 4))
+		if cmd == spCmdRead {
+			data, err := ch.Read(n)
+			if err != spErrOK {
+				return err
+			}
+			return c.transfer(buf, data)
+		}
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = m.LoadByte(buf + uint16(i))
+		}
+		c.x, c.y = byte(n), byte(n>>8)
+		return ch.Write(data)
+	}
+	return spErrOK
+}
+
+// transfer copies the data a call returns to memory, and returns its
+// length in the X and Y registers.
+func (c *smartPortCard) transfer(buf uint16, data []byte) byte {
+	for i, b := range data {
+		c.apple2.mmu.StoreByte(buf+uint16(i), b)
+	}
+	c.x, c.y = byte(len(data)), byte(len(data)>>8)
+	return spErrOK
+}
+
+// generalStatus returns a device's status and block count.
+func (c *smartPortCard) generalStatus(d smartPortDevice) []byte {
+	n := 0
+	if b, ok := d.(smartPortBlockDevice); ok {
+		n = b.Blocks()
+	}
+	return []byte{d.GeneralStatus(), byte(n), byte(n >> 8), byte(n >> 16)}
+}
+
+// deviceInfo returns a device's information block.
+func (c *smartPortCard) deviceInfo(d smartPortDevice) []byte {
+	name := strings.ToUpper(d.Name())
+	if len(name) > 16 {
+		name = name[:16]
+	}
+	dib := c.generalStatus(d)
+	dib = append(dib, byte(len(name)))
+	dib = append(dib, fmt.Sprintf("%-16s", name)...)
+	return append(dib, d.DeviceType(), 0, 0, 0)
+}
+
+// A blockImage is a SmartPort block device holding a disk image, loaded
+// from a file or an http or https URL. Images loaded from URLs are write
+// protected; others are saved back to their files when flushed.
+type blockImage struct {
+	file      string
+	name      string
+	data      []byte
+	protected bool
+	dirty     bool
+	save      func(data []byte) error // writes the image back, or nil
+}
+
+var errBlockImageSize = errors.New("disk image size is not a multiple of 512 bytes")
+
+// loadBlockImage loads a disk image in any of the supported formats and
+// presents it as a sequence of blocks. 5.25" disk images are read in
+// ProDOS sector order.
+func loadBlockImage(name string) (*blockImage, error) {
+	remote := strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+	var data []byte
+	var err error
+	if remote {
+		data, err = fetchURL(name)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blockImage{file: name, name: strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)), protected: remote}
+	ext := strings.ToLower(filepath.Ext(name))
+	switch format, ok := diskFormatFromName(name); {
+	case ext == ".2mg":
+		err = b.load2MG(data)
+	case ok:
+		err = b.loadDisk(data, format)
+	default:
+		b.data = data
+		b.save = func(data []byte) error { return os.WriteFile(name, data, 0644) }
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(b.data) == 0 || len(b.data)%512 != 0 {
+		return nil, fmt.Errorf("%s: %w", name, errBlockImageSize)
+	}
+	if remote {
+		b.save = nil
+	}
+	return b, nil
+}
+
+// fetchURL returns the body of a successful HTTP GET request.
+func fetchURL(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// loadDisk loads a 5.25" disk image.
+func (b *blockImage) loadDisk(data []byte, format diskFormat) error {
+	d, err := loadDiskImage(bytes.NewReader(data), format)
+	if err != nil {
+		return err
+	}
+	if b.data, err = d.ReadSectors(&prodosSectorOrder); err != nil {
+		return err
+	}
+	b.save = func(data []byte) error {
+		if err := d.WriteSectors(data, &prodosSectorOrder); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := d.Save(&buf); err != nil {
+			return err
+		}
+		return os.WriteFile(b.file, buf.Bytes(), 0644)
+	}
+	return nil
+}
+
+// 2IMG header fields.
+const (
+	twoMGHeaderSize = 64
+	twoMGFormat     = 0x0c // image format: 0 = DOS order, 1 = ProDOS order
+	twoMGFlags      = 0x10
+	twoMGDataOffset = 0x18
+	twoMGDataLength = 0x1c
+	twoMGLocked     = 1 << 31
+)
+
+// load2MG loads a 2IMG universal disk image.
+func (b *blockImage) load2MG(data []byte) error {
+	if len(data) < twoMGHeaderSize || string(data[:4]) != "2IMG" {
+		return errDiskFormat
+	}
+	format := binary.LittleEndian.Uint32(data[twoMGFormat:])
+	offset := binary.LittleEndian.Uint32(data[twoMGDataOffset:])
+	length := binary.LittleEndian.Uint32(data[twoMGDataLength:])
+	if uint64(offset)+uint64(length) > uint64(len(data)) {
+		return errDiskSize
+	}
+	body := data[offset : offset+length]
+	b.protected = binary.LittleEndian.Uint32(data[twoMGFlags:])&twoMGLocked != 0
+
+	switch format {
+	case 0:
+		return b.loadDisk(body, diskFormatDOS)
+	case 1:
+		b.data = append([]byte(nil), body...)
+	default:
+		return errDiskFormat
+	}
+	b.save = func(img []byte) error {
+		out := append([]byte(nil), data[:offset]...)
+		out = append(out, img...)
+		out = append(out, data[offset+length:]...)
+		return os.WriteFile(b.file, out, 0644)
+	}
+	return nil
+}
+
+func (b *blockImage) Name() string     { return b.name }
+func (b *blockImage) DeviceType() byte { return spTypeHardDisk }
+func (b *blockImage) Blocks() int      { return len(b.data) / 512 }
+
+func (b *blockImage) GeneralStatus() byte {
+	s := byte(spStatusBlock | spStatusRead | spStatusWrite | spStatusOnline)
+	if b.protected {
+		s |= spStatusProtected
+	}
+	return s
+}
+
+func (b *blockImage) Status(code byte) ([]byte, byte) {
+	return nil, spErrBadCtl
+}
+
+func (b *blockImage) Control(code byte, data []byte) byte {
+	return spErrBadCtl
+}
+
+func (b *blockImage) ReadBlock(block int, buf []byte) byte {
+	if block >= b.Blocks() {
+		return spErrBadBlock
+	}
+	copy(buf, b.data[block*512:])
+	return spErrOK
+}
+
+func (b *blockImage) WriteBlock(block int, buf []byte) byte {
+	switch {
+	case b.protected:
+		return spErrNoWrite
+	case block >= b.Blocks():
+		return spErrBadBlock
+	}
+	copy(b.data[block*512:], buf)
+	b.dirty = true
+	return spErrOK
+}
+
+// Flush saves the image if it was written.
+func (b *blockImage) Flush() error {
+	if !b.dirty || b.save == nil {
+		return nil
+	}
+	b.dirty = false
+	return b.save(b.data)
+}
+
+// smartPortImages is a flag value holding the disk images given by
+// repeated -spimage flags.
+type smartPortImages []string
+
+func (l *smartPortImages) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *smartPortImages) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// spCall makes a SmartPort call to the card in slot 5 as if from a JSR at
+// $0300 with the parameter list at $0310, and returns the error code and
+// the byte count in X and Y.
+func spCall(t *testing.T, a *apple2, cmd byte, params ...byte) (byte, int) {
+	t.Helper()
+	for i, b := range []byte{0x20, 0x23, 0xc5, cmd, 0x10, 0x03} {
+		a.mmu.StoreByte(0x300+uint16(i), b)
+	}
+	for i, b := range params {
+		a.mmu.StoreByte(0x310+uint16(i), b)
+	}
+	a.cpu.Reg.SP = 0xfd
+	a.mmu.StoreByte(0x1fe, 0x02)
+	a.mmu.StoreByte(0x1ff, 0x03)
+	a.mmu.StoreByte(0xc0d4, 0)
+	if ret := uint16(a.mmu.LoadByte(0x1fe)) | uint16(a.mmu.LoadByte(0x1ff))<<8; ret != 0x305 {
+		t.Fatalf("Expected return address 0305, got %04X\n", ret)
+	}
+	return a.mmu.LoadByte(0xc0d1), int(a.mmu.LoadByte(0xc0d2)) | int(a.mmu.LoadByte(0xc0d3))<<8
+}
+
+func TestSmartPortBlocks(t *testing.T) {
+	data, err := formatProDOS("TEST", 280)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "test.po")
+	os.WriteFile(file, data, 0644)
+	b, err := loadBlockImage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newApple2()
+	c := newSmartPortCard(a, 5)
+	c.Attach(b)
+	c.Attach(newNetDevice())
+	a.sm.Insert(5, c)
+
+	for addr, v := range map[uint16]byte{0xc501: 0x20, 0xc503: 0x00, 0xc505: 0x03, 0xc507: 0x00, 0xc5ff: 0x20} {
+		if got := a.mmu.LoadByte(addr); got != v {
+			t.Errorf("Expected %02X at $%04X, got %02X\n", v, addr, got)
+		}
+	}
+
+	// A ProDOS block driver call reads the volume directory.
+	for addr, v := range map[uint16]byte{0x42: spCmdReadBlock, 0x43: 0x50, 0x44: 0x00, 0x45: 0x20, 0x46: 2, 0x47: 0} {
+		a.mmu.StoreByte(addr, v)
+	}
+	a.mmu.StoreByte(0xc0d0, 0)
+	if e := a.mmu.LoadByte(0xc0d1); e != 0 {
+		t.Fatalf("ProDOS read failed with %02X\n", e)
+	}
+	if a.mmu.LoadByte(0x2004)&0x0f != 4 || a.mmu.LoadByte(0x2005) != 'T' {
+		t.Errorf("Expected the TEST volume header\n")
+	}
+
+	// Write block 7 through SmartPort and save the image.
+	a.mmu.StoreByte(0x2000, 0xa5)
+	if e, _ := spCall(t, a, spCmdWriteBlock, 3, 1, 0x00, 0x20, 7, 0, 0); e != 0 {
+		t.Fatalf("SmartPort write failed with %02X\n", e)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(file)
+	if saved[7*512] != 0xa5 {
+		t.Errorf("Expected the written block to be saved\n")
+	}
+
+	// The SmartPort has two devices, and unit 2 is the network device.
+	if e, n := spCall(t, a, spCmdStatus, 3, 0, 0x00, 0x20, 0); e != 0 || n != 8 || a.mmu.LoadByte(0x2000) != 2 {
+		t.Errorf("Expected 2 devices, got error %02X\n", e)
+	}
+	if e, n := spCall(t, a, spCmdStatus, 3, 1, 0x00, 0x20, 0); e != 0 || n != 4 || a.mmu.LoadByte(0x2001) != 0x18 || a.mmu.LoadByte(0x2002) != 0x01 {
+		t.Errorf("Expected 280 blocks in the status of unit 1\n")
+	}
+	spCall(t, a, spCmdStatus, 3, 2, 0x00, 0x20, 3)
+	var name []byte
+	for i := uint16(0); i < uint16(a.mmu.LoadByte(0x2004)); i++ {
+		name = append(name, a.mmu.LoadByte(0x2005+i))
+	}
+	if string(name) != "NETWORK" {
+		t.Errorf("Expected the NETWORK device, got %q\n", name)
+	}
+	if e, _ := spCall(t, a, spCmdStatus, 3, 3, 0x00, 0x20, 0); e != spErrBadUnit {
+		t.Errorf("Expected a bad unit error, got %02X\n", e)
+	}
+}
+
+func TestSmartPortNetwork(t *testing.T) {
+	data, _ := formatProDOS("WEB", 280)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/disk.po" {
+			w.Write(data)
+			return
+		}
+		w.Write([]byte("HELLO, APPLE"))
+	}))
+	defer srv.Close()
+
+	// Disk images can be mounted from URLs, and are write protected.
+	b, err := loadBlockImage(srv.URL + "/disk.po")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Blocks() != 280 || b.WriteBlock(0, make([]byte, 512)) != spErrNoWrite {
+		t.Errorf("Expected a write-protected 280 block image\n")
+	}
+
+	a := newApple2()
+	c := newSmartPortCard(a, 5)
+	c.Attach(newNetDevice())
+	a.sm.Insert(5, c)
+
+	// Open the URL with a CONTROL call.
+	spec := append([]byte{0, 0, 4, 0}, "N:"+srv.URL+"/\x00"...)
+	spec[0] = byte(len(spec) - 2)
+	for i, v := range spec {
+		a.mmu.StoreByte(0x2000+uint16(i), v)
+	}
+	if e, _ := spCall(t, a, spCmdControl, 3, 1, 0x00, 0x20, netControlOpen); e != 0 {
+		t.Fatalf("Open failed with %02X\n", e)
+	}
+
+	// Wait for the whole response.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		spCall(t, a, spCmdStatus, 3, 1, 0x00, 0x21, netStatus)
+		if a.mmu.LoadByte(0x2102) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the response\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n, err := a.mmu.LoadByte(0x2100), a.mmu.LoadByte(0x2103); n != 12 || err != netErrEOF {
+		t.Errorf("Expected 12 bytes waiting at end of file, got %d (error %d)\n", n, err)
+	}
+
+	e, n := spCall(t, a, spCmdRead, 4, 1, 0x00, 0x22, 0x00, 0x01, 0, 0, 0)
+	var got []byte
+	for i := 0; i < n; i++ {
+		got = append(got, a.mmu.LoadByte(0x2200+uint16(i)))
+	}
+	if e != 0 || !bytes.Equal(got, []byte("HELLO, APPLE")) {
+		t.Errorf("Expected the response body, got %q (error %02X)\n", got, e)
+	}
+	spCall(t, a, spCmdControl, 3, 1, 0x00, 0x20, netControlClose)
+}
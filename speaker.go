@@ -1,7 +1,23 @@
 package main
 
+import "math"
+
+const (
+	speakerLowPassHz  = 8000.0 // cutoff of the speaker's high-frequency rolloff
+	speakerHighPassHz = 20.0   // cutoff of the diaphragm's return to center
+)
+
+// The speaker is a 1-bit device: each access to $C030 flips the position
+// of the diaphragm. The speaker records the CPU cycle of every toggle and
+// later reconstructs the waveform, filtering and resampling it to the
+// audio output rate.
 type speaker struct {
 	apple2 *apple2
+
+	level   bool           // current diaphragm position
+	toggles []uint64       // CPU cycles of toggles not yet rendered
+	lp      float64        // low-pass filter state
+	hp      highPassFilter // removes DC offset as the diaphragm recenters
 }
 
 func newSpeaker(apple2 *apple2) *speaker {
@@ -11,8 +27,46 @@ func newSpeaker(apple2 *apple2) *speaker {
 }
 
 func (s *speaker) Init() {
+	s.hp = newHighPassFilter(speakerHighPassHz)
 }
 
 func (s *speaker) Toggle() {
-	// toggle speaker diaphragm
+	s.toggles = append(s.toggles, s.apple2.sched.Now())
+}
+
+// RenderSamples mixes the speaker waveform for the span of cycles starting
+// at 'start' into buf.
+func (s *speaker) RenderSamples(buf []float32, start float64) {
+	lpAlpha := onePoleAlpha(speakerLowPassHz)
+
+	i := 0
+	t0 := start
+	for n := range buf {
+		t1 := t0 + cyclesPerSample
+
+		// Integrate the square wave over the sample period, which acts as
+		// an anti-aliasing box filter.
+		var high float64
+		t := t0
+		for ; i < len(s.toggles) && float64(s.toggles[i]) < t1; i++ {
+			tt := math.Max(t, float64(s.toggles[i]))
+			if s.level {
+				high += tt - t
+			}
+			t = tt
+			s.level = !s.level
+		}
+		if s.level {
+			high += t1 - t
+		}
+		v := 2*high/cyclesPerSample - 1
+
+		// The speaker cone can't follow fast transitions, and it drifts
+		// back to center when not being driven.
+		s.lp += lpAlpha * (v - s.lp)
+		buf[n] += float32(s.hp.Filter(s.lp))
+		t0 = t1
+	}
+
+	s.toggles = s.toggles[:copy(s.toggles, s.toggles[i:])]
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A speedMode selects how fast the machine runs compared to a real Apple
+// II.
+type speedMode int
+
+const (
+	speedNormal speedMode = iota // 1.023 MHz, paced to the video field rate
+	speedDouble                  // two video fields per field period
+	speedQuad                    // four video fields per field period
+	speedWarp                    // as fast as the host can run
+
+	speedModes
+)
+
+var speedModeNames = [speedModes]string{"1x", "2x", "4x", "warp"}
+
+// speedMultipliers holds the number of video fields run per field period
+// in each paced mode.
+var speedMultipliers = [speedModes]int{1, 2, 4, 0}
+
+func (m speedMode) String() string {
+	return speedModeNames[m]
+}
+
+// parseSpeedMode converts a speed name, such as "2x" or "warp", into a
+// speedMode.
+func parseSpeedMode(s string) (speedMode, bool) {
+	for m, name := range speedModeNames {
+		if s == name {
+			return speedMode(m), true
+		}
+	}
+	return 0, false
+}
+
+// framePeriod is the host time taken by one video field at normal speed.
+const framePeriod = time.Second * cyclesPerFrame / time.Duration(cpuClockRate)
+
+// maxPacingLag is how far the machine may fall behind the host clock
+// before it gives up catching up, as happens when the host is busy.
+const maxPacingLag = 100 * time.Millisecond
+
+// speedControl paces the machine against the host clock. At normal speed,
+// one video field runs per field period, so the machine runs at its
+// authentic 1.023 MHz. The fixed multiples run two or four fields per
+// period, and warp mode runs fields for the whole period without
+// waiting. With automatic warp, the machine runs in warp mode while a
+// Disk II drive motor is on, so disks load quickly. The speed may be
+// changed from any goroutine.
+type speedControl struct {
+	apple2 *apple2
+
+	mu       sync.Mutex
+	mode     speedMode
+	autoWarp bool
+
+	next  time.Time // host time at which the next field period begins
+	sent  time.Time // host time at which the front ends were last sent a frame
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newSpeedControl(apple2 *apple2) *speedControl {
+	return &speedControl{
+		apple2: apple2,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// SetSpeed selects the speed mode.
+func (sc *speedControl) SetSpeed(m speedMode) {
+	sc.mu.Lock()
+	sc.mode = m
+	sc.mu.Unlock()
+}
+
+// Speed returns the selected speed mode.
+func (sc *speedControl) Speed() speedMode {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.mode
+}
+
+// CycleSpeed selects the next faster speed mode, wrapping around from
+// warp to normal speed, and returns it.
+func (sc *speedControl) CycleSpeed() speedMode {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.mode = (sc.mode + 1) % speedModes
+	return sc.mode
+}
+
+// SetAutoWarp enables or disables automatic warp during disk access.
+func (sc *speedControl) SetAutoWarp(enable bool) {
+	sc.mu.Lock()
+	sc.autoWarp = enable
+	sc.mu.Unlock()
+}
+
+// AutoWarp reports whether automatic warp is enabled.
+func (sc *speedControl) AutoWarp() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.autoWarp
+}
+
+// Effective returns the speed mode in effect, which is warp while
+// automatic warp is enabled and a disk is spinning.
+func (sc *speedControl) Effective() speedMode {
+	sc.mu.Lock()
+	mode, auto := sc.mode, sc.autoWarp
+	sc.mu.Unlock()
+
+	if auto {
+		if d, err := sc.apple2.diskController(); err == nil && d.MotorOn() {
+			return speedWarp
+		}
+	}
+	return mode
+}
+
+// wait sleeps until the next field period begins.
+func (sc *speedControl) wait() {
+	now := sc.now()
+	if sc.next.IsZero() || now.Sub(sc.next) > maxPacingLag {
+		sc.next = now
+	}
+	sc.next = sc.next.Add(framePeriod)
+	if d := sc.next.Sub(now); d > 0 {
+		sc.sleep(d)
+	}
+}
+
+// RunPaced runs the machine for one video field period of host time at
+// the effective speed. Front ends call it in a loop in place of RunFrame
+// to run the machine in real time. Audio is muted while the machine runs
+// faster than normal. Requests of the remote control are run first, so
+// they're served even while a debugger holds the machine stopped.
+func (a *apple2) RunPaced() {
+	a.runCalls()
+	a.sendFrame()
+	sc := a.speed
+	mode := sc.Effective()
+	a.au.discard = mode != speedNormal
+
+	if mode == speedWarp && !a.stopped() {
+		start := sc.now()
+		for !a.stopped() {
+			a.RunFrame()
+			if sc.now().Sub(start) >= framePeriod {
+				break
+			}
+		}
+		sc.next = time.Time{}
+		return
+	}
+
+	for i := 0; i < speedMultipliers[mode] && !a.stopped(); i++ {
+		a.RunFrame()
+	}
+	sc.wait()
+}
+
+// sendFrame sends the last frame to the clients of the streaming and VNC
+// front ends, no more than once per field period of host time, so that
+// the machine running faster than normal doesn't flood them.
+func (a *apple2) sendFrame() {
+	if a.stream == nil && a.vnc == nil {
+		return
+	}
+	sc := a.speed
+	now := sc.now()
+	if !sc.sent.IsZero() && now.Sub(sc.sent) < framePeriod {
+		return
+	}
+	sc.sent = now
+	if a.stream != nil {
+		a.stream.Send()
+	}
+	if a.vnc != nil {
+		a.vnc.Send()
+	}
+}
+
+// stopped reports whether an attached debugger has stopped the machine,
+// or the remote control has paused it.
+func (a *apple2) stopped() bool {
+	return a.dbg != nil && a.dbg.Stopped() || a.remote != nil && a.remote.paused
+}
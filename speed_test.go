@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpeedControl(t *testing.T) {
+	a := newApple2()
+	sc := a.speed
+	host := time.Unix(0, 0)
+	var slept time.Duration
+	sc.now = func() time.Time { return host }
+	sc.sleep = func(d time.Duration) { slept += d; host = host.Add(d) }
+
+	// run runs one field period and returns the number of video fields
+	// run.
+	run := func() int {
+		start := a.cpu.Cycles
+		a.RunPaced()
+		return int((a.cpu.Cycles - start) / cyclesPerFrame)
+	}
+
+	// Normal speed runs one field per field period, with audio.
+	if n := run(); n != 1 || a.au.discard {
+		t.Errorf("Expected one field with audio at normal speed, got %d\n", n)
+	}
+	slept = 0
+	host = host.Add(framePeriod / 4)
+	run()
+	if slept != framePeriod-framePeriod/4 {
+		t.Errorf("Expected to wait for the rest of the field period, waited %v\n", slept)
+	}
+
+	// The fixed multiples run more fields per period, without audio.
+	sc.SetSpeed(speedQuad)
+	if n := run(); n != 4 || !a.au.discard {
+		t.Errorf("Expected four muted fields at 4x, got %d\n", n)
+	}
+
+	// Warp mode runs fields for a whole field period without waiting.
+	sc.SetSpeed(speedWarp)
+	sc.now = func() time.Time {
+		host = host.Add(framePeriod/8 + 1)
+		return host
+	}
+	slept = 0
+	if n := run(); n != 8 || slept != 0 {
+		t.Errorf("Expected eight fields without waiting in warp mode, got %d (waited %v)\n", n, slept)
+	}
+	sc.now = func() time.Time { return host }
+
+	// The hotkey cycles from warp back to normal speed, and isn't typed.
+	a.im.KeyEvent(speedHotkey, true)
+	a.im.KeyEvent(speedHotkey, false)
+	if sc.Speed() != speedNormal || a.kb.IsKeyDown() {
+		t.Errorf("Expected the hotkey to select normal speed, got %v\n", sc.Speed())
+	}
+
+	// Automatic warp runs in warp mode while a disk motor is on.
+	a.sm.Insert(6, newDiskII(a, nil))
+	sc.SetAutoWarp(true)
+	if sc.Effective() != speedNormal {
+		t.Errorf("Expected normal speed with the motor off\n")
+	}
+	a.mmu.LoadByte(0xc0e9)
+	if sc.Effective() != speedWarp {
+		t.Errorf("Expected warp mode with the motor on\n")
+	}
+
+	if m, ok := parseSpeedMode("2x"); !ok || m != speedDouble {
+		t.Errorf("Expected to parse 2x\n")
+	}
+	if _, ok := parseSpeedMode("3x"); ok {
+		t.Errorf("Unexpected speed 3x\n")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+// sscSignature holds the Pascal 1.1 firmware signature bytes at $Cn05,
+// $Cn07, $Cn0B and $Cn0C of the Super Serial Card ROM. The last byte
+// identifies a serial card.
+var sscSignature = []byte{0x38, 0x18, 0x01, 0x31}
+
+// Super Serial Card DIP switch settings, as the firmware reads them from
+// $C0n1 and $C0n2. Switch 1 holds the baud rate, encoded as in the 6551
+// control register, in its upper four bits and the firmware mode in its
+// low two bits. Switch 2 selects the stop bits, data bits, parity, line
+// feed generation and interrupts; all off selects one stop bit, eight
+// data bits, no parity, no line feeds and no interrupts.
+const (
+	sscModePrinter        = 1
+	sscModeCommunications = 2
+
+	sscDefaultSW1 = 0x0e<<4 | sscModeCommunications // 9600 baud
+	sscDefaultSW2 = 0x00
+)
+
+// A superSerialCard emulates the Apple Super Serial Card, a 6551 ACIA
+// with 2K of firmware. The ACIA's registers appear at offsets 8..B of the
+// slot I/O space and the DIP switches at offsets 1 and 2. The last page
+// of the firmware is the card's slot ROM, and all of it is the card's
+// expansion ROM.
+type superSerialCard struct {
+	apple2 *apple2
+	acia   *acia6551
+	rom    []byte // 2K firmware, or nil if not loaded
+	sw1    byte
+	sw2    byte
+}
+
+func newSuperSerialCard(apple2 *apple2, rom []byte) *superSerialCard {
+	return &superSerialCard{
+		apple2: apple2,
+		acia:   newACIA6551(apple2),
+		rom:    rom,
+		sw1:    sscDefaultSW1,
+		sw2:    sscDefaultSW2,
+	}
+}
+
+// ACIA returns the card's 6551, through which its serial line is
+// connected to the host.
+func (c *superSerialCard) ACIA() *acia6551 {
+	return c.acia
+}
+
+func (c *superSerialCard) LoadIO(addr uint16) byte {
+	switch {
+	case addr == 1:
+		return c.sw1
+	case addr == 2:
+		return c.sw2
+	case addr >= 8 && addr <= 0xb:
+		return c.acia.LoadByte(addr - 8)
+	}
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *superSerialCard) StoreIO(addr uint16, v byte) {
+	if addr >= 8 && addr <= 0xb {
+		c.acia.StoreByte(addr-8, v)
+	}
+}
+
+func (c *superSerialCard) LoadROM(addr uint16) byte {
+	if c.rom == nil {
+		return c.apple2.vs.FloatingBus()
+	}
+	return c.rom[0x700+addr]
+}
+
+func (c *superSerialCard) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+func (c *superSerialCard) LoadExpansionROM(addr uint16) byte {
+	if c.rom == nil {
+		return c.apple2.vs.FloatingBus()
+	}
+	return c.rom[addr]
+}
+
+func (c *superSerialCard) StoreExpansionROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// IRQ reports whether the card's ACIA is requesting an interrupt.
+func (c *superSerialCard) IRQ() bool {
+	return c.acia.IRQ()
+}
+
+// Reset resets the card's ACIA, which is wired to the RESET line.
+func (c *superSerialCard) Reset() {
+	c.acia.Reset()
+	c.acia.updateIRQ()
+}
+
+func (c *superSerialCard) saveState(sw *stateWriter) { c.acia.saveState(sw) }
+func (c *superSerialCard) loadState(sr *stateReader) { c.acia.loadState(sr) }
+
+// SerialCard returns the ACIA of the Super Serial Card in a slot, or of
+// one of the IIc's built-in serial ports, or nil if there is none.
+func (a *apple2) SerialCard(slot int) *acia6551 {
+	if c, ok := a.sm.Card(slot).(*superSerialCard); ok {
+		return c.acia
+	}
+	return a.SerialPort(slot)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSSCROM() []byte {
+	rom := make([]byte, 2*1024)
+	for i := range rom {
+		rom[i] = byte(i >> 8)
+	}
+	copy(rom[0x705:], []byte{0x38, 0, 0x18, 0, 0, 0, 0x01, 0x31})
+	return rom
+}
+
+func TestSuperSerialCard(t *testing.T) {
+	a := newApple2()
+	a.sm.Insert(2, newSuperSerialCard(a, testSSCROM()))
+
+	if v := a.mmu.LoadByte(0xc205); v != 0x38 {
+		t.Errorf("Expected slot ROM from the last page of the firmware, got %02X\n", v)
+	}
+	if v := a.mmu.LoadByte(0xc900); v != 0x01 {
+		t.Errorf("Expected expansion ROM at $C800, got %02X\n", v)
+	}
+	if v := a.mmu.LoadByte(0xc0a1); v != sscDefaultSW1 {
+		t.Errorf("Expected SW1 %02X, got %02X\n", sscDefaultSW1, v)
+	}
+
+	a.mmu.StoreByte(0xc0ab, 0x1e)
+	if v := a.mmu.LoadByte(0xc0ab); v != 0x1e {
+		t.Errorf("Expected ACIA control register at $C0AB, got %02X\n", v)
+	}
+
+	rs := &romSet{}
+	rs.add("ssc.rom", testSSCROM())
+	if _, err := rs.SSCROM(); err != nil {
+		t.Errorf("SSC ROM not identified: %v\n", err)
+	}
+}
+
+// waitSerial runs the machine's ACIA until it receives a character.
+func waitSerial(t *testing.T, a *apple2, status uint16) {
+	deadline := time.Now().Add(time.Second)
+	for a.mmu.LoadByte(status)&aciaStatusRDRF == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for serial data")
+		}
+		a.cpu.Cycles += 1000
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSerialBackends(t *testing.T) {
+	a := newApple2()
+	a.sm.Insert(2, newSuperSerialCard(a, nil))
+	a.mmu.StoreByte(0xc0aa, 0x0b) // DTR on, receiver interrupts off
+	a.mmu.StoreByte(0xc0ab, 0x1e) // 9600 baud
+
+	if _, _, err := a.ConnectSerial(1, "pty"); err == nil {
+		t.Errorf("Expected an error connecting an empty slot\n")
+	}
+	if _, _, err := a.ConnectSerial(2, "bogus:1"); err == nil {
+		t.Errorf("Expected an error for an invalid endpoint\n")
+	}
+
+	// Connect to a TCP server.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, c, err := a.ConnectSerial(2, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v\n", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Write([]byte("A"))
+	waitSerial(t, a, 0xc0a9)
+	if v := a.mmu.LoadByte(0xc0a8); v != 'A' {
+		t.Errorf("Expected 'A', got %02X\n", v)
+	}
+	a.mmu.StoreByte(0xc0a8, 'B')
+	buf := make([]byte, 1)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := server.Read(buf); err != nil || buf[0] != 'B' {
+		t.Errorf("Expected 'B' from serial port, got %q (%v)\n", buf, err)
+	}
+	c.Close()
+	server.Close()
+
+	// Accept connections.
+	addr, c, err := a.ConnectSerial(2, "listen:127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v\n", err)
+	}
+	client, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("C"))
+	a.cpu.Cycles += 10000
+	waitSerial(t, a, 0xc0a9)
+	if v := a.mmu.LoadByte(0xc0a8); v != 'C' {
+		t.Errorf("Expected 'C', got %02X\n", v)
+	}
+	a.cpu.Cycles += 10000
+	a.mmu.StoreByte(0xc0a8, 'D')
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if s, err := bufio.NewReader(client).ReadByte(); err != nil || s != 'D' {
+		t.Errorf("Expected 'D' from serial port, got %q (%v)\n", s, err)
+	}
+	c.Close()
+	client.Close()
+
+	// Write to a file.
+	file := filepath.Join(t.TempDir(), "serial.txt")
+	_, c, err = a.ConnectSerial(2, "file:"+file)
+	if err != nil {
+		t.Fatalf("Unable to open file: %v\n", err)
+	}
+	for _, b := range []byte("HI") {
+		a.cpu.Cycles += 10000
+		a.mmu.StoreByte(0xc0a8, b)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(file)
+		if string(data) == "HI" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected HI in file, got %q\n", data)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+}
+
+func TestADTProConnection(t *testing.T) {
+	a := newApple2()
+	a.sm.Insert(2, newSuperSerialCard(a, nil))
+
+	if _, _, err := a.ConnectADTPro(2, "tcp:127.0.0.1:1", adtproOptions{baud: 1000}); err == nil {
+		t.Errorf("Expected an error for an unsupported baud rate\n")
+	}
+	if _, _, err := a.ConnectADTPro(2, "/nonexistent/tty", adtproOptions{baud: 19200}); err == nil {
+		t.Errorf("Expected an error opening a missing serial device\n")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, c, err := a.ConnectADTPro(2, "tcp:"+l.Addr().String(), adtproOptions{baud: 115200, handshake: true})
+	if err != nil {
+		t.Fatalf("Unable to connect: %v\n", err)
+	}
+	defer c.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// The DIP switches select the external clock for 115200 baud.
+	if v := a.mmu.LoadByte(0xc0a1); v != sscModeCommunications {
+		t.Errorf("Expected SW1 %02X, got %02X\n", sscModeCommunications, v)
+	}
+	a.mmu.StoreByte(0xc0aa, 0x0b)
+	a.mmu.StoreByte(0xc0ab, a.mmu.LoadByte(0xc0a1)>>4|0x10)
+
+	// With handshaking, characters wait until the previous one is read.
+	server.Write([]byte("AB"))
+	waitSerial(t, a, 0xc0a9)
+	time.Sleep(10 * time.Millisecond)
+	a.cpu.Cycles += 100000
+	if v := a.mmu.LoadByte(0xc0a9); v&aciaStatusOverrun != 0 {
+		t.Errorf("Expected no overrun with handshaking\n")
+	}
+	for _, want := range []byte("AB") {
+		waitSerial(t, a, 0xc0a9)
+		if v := a.mmu.LoadByte(0xc0a8); v != want {
+			t.Errorf("Expected %q, got %02X\n", want, v)
+		}
+	}
+}
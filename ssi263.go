@@ -0,0 +1,259 @@
+package main
+
+import "math"
+
+// SSI-263 register offsets.
+const (
+	ssiDurPhon = 0 // duration mode (bits 7-6) and phoneme (bits 5-0)
+	ssiInflect = 1 // inflection bits 10-3
+	ssiRateInf = 2 // rate (bits 7-4) and inflection bits 11, 2-0
+	ssiCtlArt  = 3 // control (bit 7), articulation (bits 6-4) and amplitude (bits 3-0)
+	ssiFilter  = 4 // filter frequency
+)
+
+// Phoneme names, indexed by phoneme code.
+var ssiPhonemes = [64]string{
+	"PA", "E", "E1", "Y", "YI", "AY", "IE", "I",
+	"A", "AI", "EH", "EH1", "AE", "AE1", "AH", "AH1",
+	"AW", "O", "OU", "OO", "IU", "IU1", "U", "U1",
+	"UH", "UH1", "UH2", "UH3", "ER", "R", "R1", "R2",
+	"L", "L1", "LF", "W", "B", "D", "KV", "P",
+	"T", "K", "HV", "HVC", "HF", "HFC", "HN", "Z",
+	"S", "J", "SCH", "V", "F", "THV", "TH", "M",
+	"N", "NG", ":A", ":OH", ":U", ":UH", "E2", "LB",
+}
+
+// A speechSynth converts SSI-263 phonemes into audio. The default
+// synthesizer produces a rough approximation of each phoneme; other
+// backends may be installed to produce more intelligible speech.
+type speechSynth interface {
+	// Sample returns the output (-1..1) for a phoneme at time t seconds
+	// after the phoneme began, spoken at the given pitch in Hz.
+	Sample(phoneme byte, t, pitch float64) float64
+}
+
+// An ssiEvent is a change to the speech output that takes effect at a
+// particular CPU cycle.
+type ssiEvent struct {
+	cycle     uint64
+	phoneme   byte
+	pitch     float64
+	amplitude float64
+}
+
+// An ssi263 emulates the Silicon Systems SSI-263 phoneme speech
+// synthesizer found on the Mockingboard. When a phoneme finishes playing,
+// the chip signals the A/R (acknowledge/request) line, which is wired to
+// CA1 of the Mockingboard's first VIA so that software may be interrupted
+// to supply the next phoneme.
+type ssi263 struct {
+	sched *scheduler
+	ar    func()      // called when the chip requests the next phoneme
+	done  *schedEvent // pending end of the current phoneme, if any
+	synth speechSynth
+
+	regs      [5]byte
+	end       uint64 // CPU cycle at which the current phoneme finishes
+	playing   bool   // true while a phoneme is in progress
+	requested bool   // true when the A/R line is asserted
+	events    []ssiEvent
+
+	// Sound generation state.
+	cur  ssiEvent
+	t    float64 // seconds since the current phoneme began
+	lp   float64 // low-pass filter state
+	hp   highPassFilter
+	gain float64
+}
+
+func newSSI263(sched *scheduler, ar func(), synth speechSynth) *ssi263 {
+	if synth == nil {
+		synth = simpleSpeechSynth{}
+	}
+	return &ssi263{
+		sched: sched,
+		ar:    ar,
+		synth: synth,
+		hp:    newHighPassFilter(mockingboardHighPassHz),
+		gain:  0.5,
+	}
+}
+
+func (s *ssi263) LoadByte(reg uint16) byte {
+	s.sync()
+
+	// Bit 7 reflects the A/R line, which is active low.
+	if s.requested {
+		return 0x00
+	}
+	return 0x80
+}
+
+func (s *ssi263) StoreByte(reg uint16, v byte) {
+	s.sync()
+
+	if reg > ssiFilter {
+		return
+	}
+	s.regs[reg] = v
+
+	switch reg {
+	case ssiDurPhon:
+		s.requested = false
+		s.start()
+	case ssiCtlArt:
+		if (v & 0x80) != 0 {
+			// Power down.
+			s.playing = false
+			s.requested = false
+			s.sched.Cancel(s.done)
+		}
+		s.queue()
+	default:
+		s.queue()
+	}
+}
+
+// sync asserts the A/R line if the current phoneme has finished.
+func (s *ssi263) sync() {
+	if s.playing && s.sched.Now() >= s.end {
+		s.playing = false
+		s.requested = true
+		s.ar()
+	}
+}
+
+// start begins playing the phoneme in the duration/phoneme register.
+func (s *ssi263) start() {
+	if (s.regs[ssiCtlArt] & 0x80) != 0 {
+		return
+	}
+	s.playing = true
+	s.end = s.sched.Now() + s.duration()
+	s.sched.Cancel(s.done)
+	s.done = s.sched.At(s.end, s.sync)
+	s.queue()
+}
+
+// duration returns the length of the current phoneme in CPU cycles. It
+// depends on the rate and the duration mode.
+func (s *ssi263) duration() uint64 {
+	rate := uint64(s.regs[ssiRateInf] >> 4)
+	mode := uint64(s.regs[ssiDurPhon] >> 6)
+	ms := 8 * (17 - rate) * (4 - mode) / 4
+	return ms * uint64(cpuClockRate) / 1000
+}
+
+// pitch returns the current inflection as a frequency in Hz.
+func (s *ssi263) pitch() float64 {
+	inflection := uint16(s.regs[ssiInflect])<<3 |
+		uint16(s.regs[ssiRateInf]&0x07) |
+		uint16(s.regs[ssiRateInf]&0x08)<<8
+	return 60 + 240*float64(inflection)/4096
+}
+
+// queue records the current output parameters for the renderer.
+func (s *ssi263) queue() {
+	e := ssiEvent{
+		cycle:     s.sched.Now(),
+		phoneme:   s.regs[ssiDurPhon] & 0x3f,
+		pitch:     s.pitch(),
+		amplitude: float64(s.regs[ssiCtlArt]&0x0f) / 15,
+	}
+	if (s.regs[ssiCtlArt] & 0x80) != 0 {
+		e.amplitude = 0
+	}
+	s.events = append(s.events, e)
+}
+
+// RenderSamples mixes the speech output for the span of cycles starting
+// at 'start' into buf.
+func (s *ssi263) RenderSamples(buf []float32, start float64) {
+	cutoff := 500 + 7500*float64(s.regs[ssiFilter])/255
+	alpha := onePoleAlpha(cutoff)
+
+	e := 0
+	t := start
+	for n := range buf {
+		t += cyclesPerSample
+		for ; e < len(s.events) && float64(s.events[e].cycle) < t; e++ {
+			if s.events[e].phoneme != s.cur.phoneme {
+				s.t = 0
+			}
+			s.cur = s.events[e]
+		}
+
+		var v float64
+		if s.cur.amplitude > 0 && s.cur.phoneme != 0 {
+			v = s.synth.Sample(s.cur.phoneme, s.t, s.cur.pitch) * s.cur.amplitude
+		}
+		s.t += 1.0 / audioSampleRate
+
+		s.lp += alpha * (v - s.lp)
+		buf[n] += float32(s.hp.Filter(s.lp) * s.gain)
+	}
+
+	s.events = s.events[:copy(s.events, s.events[e:])]
+}
+
+// simpleSpeechSynth approximates phonemes with a glottal pulse train for
+// voiced sounds, noise for unvoiced sounds, and a mix of both for voiced
+// fricatives.
+type simpleSpeechSynth struct{}
+
+func (simpleSpeechSynth) Sample(phoneme byte, t, pitch float64) float64 {
+	name := ssiPhonemes[phoneme&0x3f]
+
+	var voiced, noise float64
+	switch name {
+	case "P", "T", "K", "HF", "HFC", "S", "SCH", "F", "TH":
+		noise = 1
+	case "Z", "J", "V", "THV":
+		voiced, noise = 0.6, 0.4
+	case "B", "D", "KV", "HVC":
+		voiced = 0.5
+	default:
+		voiced = 1
+	}
+
+	var v float64
+	if voiced > 0 {
+		// A decaying pulse at the pitch frequency with a formant-like
+		// resonance whose frequency depends on the phoneme.
+		phase := math.Mod(t*pitch, 1)
+		formant := 300 + 40*float64(phoneme&0x1f)
+		v += voiced * math.Exp(-6*phase) * math.Sin(2*math.Pi*formant*phase/pitch)
+	}
+	if noise > 0 {
+		// Deterministic white noise derived from the sample time.
+		x := uint32(t*audioSampleRate) * 2654435761
+		x ^= x >> 15
+		v += noise * (float64(x&0xffff)/0x8000 - 1)
+	}
+	return v
+}
+
+// saveState saves the registers of the chip and the timing of the
+// phoneme in progress. Phonemes not yet rendered are discarded when a
+// snapshot is loaded.
+func (s *ssi263) saveState(sw *stateWriter) {
+	sw.Tag("SSI ")
+	sw.FixedBytes(s.regs[:])
+	sw.Uint64(s.end)
+	sw.Bool(s.playing)
+	sw.Bool(s.requested)
+}
+
+func (s *ssi263) loadState(sr *stateReader) {
+	sr.Tag("SSI ")
+	sr.FixedBytes(s.regs[:])
+	s.end = sr.Uint64()
+	s.playing = sr.Bool()
+	s.requested = sr.Bool()
+	s.events = s.events[:0]
+
+	s.sched.Cancel(s.done)
+	if s.playing {
+		s.done = s.sched.At(s.end, s.sync)
+	}
+}
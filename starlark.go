@@ -0,0 +1,349 @@
+//go:build starlark
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// A starlarkScript runs a Starlark program that controls the machine. The
+// program runs the machine by calling run, and can react to it running
+// through callbacks. Beyond Starlark's built-ins, it can call:
+//
+//	peek(addr)             read memory without triggering soft switches; an
+//	                       addr string such as "aux:2000" reads a physical
+//	                       memory (see parsePhysAddr)
+//	poke(addr, value)      write memory
+//	reg(name)              read register a, x, y, sp, pc or p
+//	set_reg(name, value)   set a register
+//	cycles()               the number of CPU cycles run
+//	run(frames)            run the machine for a number of video frames,
+//	                       calling the callbacks as it runs
+//	breakpoint(addr, fn)   call fn(addr) whenever the CPU is about to
+//	                       execute the instruction at addr, or with fn None,
+//	                       stop calling it
+//	on_frame(fn)           call fn(frame) at the end of every frame, or with
+//	                       fn None, stop calling it
+//	type(text)             type text at the keyboard
+//	paddle(n, value)       set paddle n to a value from 0 to 255
+//	button(n, pressed)     press or release pushbutton n
+//	screen()               the text on the screen
+//	exit(status)           end the program with an exit status
+//
+// print writes to the script's output. Unlike Starlark configuration
+// files, programs may use if, for and while statements at top level, and
+// reassign global variables.
+type starlarkScript struct {
+	apple2      *apple2
+	dbg         *debugger
+	thread      *starlark.Thread
+	breakpoints map[uint16]starlark.Callable
+	onFrame     starlark.Callable
+	frames      int // frames run
+}
+
+// A starlarkExit is returned by exit to unwind the program.
+type starlarkExit struct {
+	status int
+}
+
+func (e *starlarkExit) Error() string {
+	return fmt.Sprintf("exit status %d", e.status)
+}
+
+// RunStarlarkFile runs a Starlark program file that controls the machine,
+// writing its output to w. It returns the program's exit status, or an
+// error if the program failed.
+func (a *apple2) RunStarlarkFile(filename string, w io.Writer) (int, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return 1, err
+	}
+	s := &starlarkScript{
+		apple2:      a,
+		dbg:         a.AttachDebugger(),
+		breakpoints: make(map[uint16]starlark.Callable),
+	}
+	defer a.DetachDebugger()
+	s.dbg.Continue()
+	s.thread = &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Fprintln(w, msg) },
+	}
+
+	builtins := starlark.StringDict{}
+	for name, fn := range map[string]func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error){
+		"peek":       s.peek,
+		"poke":       s.poke,
+		"reg":        s.reg,
+		"set_reg":    s.setReg,
+		"cycles":     s.cycles,
+		"run":        s.run,
+		"breakpoint": s.breakpoint,
+		"on_frame":   s.setOnFrame,
+		"type":       s.typeText,
+		"paddle":     s.paddle,
+		"button":     s.button,
+		"screen":     s.screen,
+		"exit":       s.exit,
+	} {
+		builtins[name] = starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			v, err := fn(args, kwargs)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", b.Name(), err)
+			}
+			return v, nil
+		})
+	}
+
+	_, err = starlark.ExecFileOptions(starlarkOptions, s.thread, filename, src, builtins)
+	var exit *starlarkExit
+	switch {
+	case errors.As(err, &exit):
+		return exit.status, nil
+	case err != nil:
+		var eval *starlark.EvalError
+		if errors.As(err, &eval) {
+			return 1, errors.New(eval.Backtrace())
+		}
+		return 1, err
+	}
+	return 0, nil
+}
+
+// starlarkOptions are the dialect of Starlark programs, which are scripts
+// rather than configuration.
+var starlarkOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// starlarkAddr converts a Starlark address, an integer or a physical
+// address string, to a physical address, or if phys is false, to an
+// address in the CPU's address space.
+func starlarkAddr(v starlark.Value) (p physAddr, addr uint16, phys bool, err error) {
+	switch v := v.(type) {
+	case starlark.String:
+		p, err = parsePhysAddr(string(v))
+		return p, 0, true, err
+	case starlark.Int:
+		n, ok := v.Int64()
+		if !ok || n < 0 || n > 0xffff {
+			return p, 0, false, fmt.Errorf("address %v out of range", v)
+		}
+		return p, uint16(n), false, nil
+	}
+	return p, 0, false, fmt.Errorf("address must be an int or string, not %s", v.Type())
+}
+
+func (s *starlarkScript) peek(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr starlark.Value
+	if err := starlark.UnpackPositionalArgs("peek", args, kwargs, 1, &addr); err != nil {
+		return nil, err
+	}
+	p, a, phys, err := starlarkAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !phys {
+		return starlark.MakeInt(int(s.dbg.peek(a))), nil
+	}
+	v, err := s.apple2.mmu.PeekPhys(p)
+	return starlark.MakeInt(int(v)), err
+}
+
+func (s *starlarkScript) poke(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr starlark.Value
+	var v int
+	if err := starlark.UnpackPositionalArgs("poke", args, kwargs, 2, &addr, &v); err != nil {
+		return nil, err
+	}
+	if v < 0 || v > 0xff {
+		return nil, fmt.Errorf("value %d out of range", v)
+	}
+	p, a, phys, err := starlarkAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !phys {
+		s.dbg.poke(a, byte(v))
+		return starlark.None, nil
+	}
+	return starlark.None, s.apple2.mmu.PokePhys(p, byte(v))
+}
+
+func (s *starlarkScript) reg(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs("reg", args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	r := &s.apple2.cpu.Reg
+	switch strings.ToLower(name) {
+	case "a":
+		return starlark.MakeInt(int(r.A)), nil
+	case "x":
+		return starlark.MakeInt(int(r.X)), nil
+	case "y":
+		return starlark.MakeInt(int(r.Y)), nil
+	case "sp":
+		return starlark.MakeInt(int(r.SP)), nil
+	case "pc":
+		return starlark.MakeInt(int(r.PC)), nil
+	case "p":
+		return starlark.MakeInt(int(r.SavePS(false))), nil
+	}
+	return nil, fmt.Errorf("unknown register '%s'", name)
+}
+
+func (s *starlarkScript) setReg(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var v int
+	if err := starlark.UnpackPositionalArgs("set_reg", args, kwargs, 2, &name, &v); err != nil {
+		return nil, err
+	}
+	return starlark.None, s.dbg.setRegister(fmt.Sprintf("%s=%X", name, v))
+}
+
+func (s *starlarkScript) cycles(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs("cycles", args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return starlark.MakeUint64(s.apple2.cpu.Cycles), nil
+}
+
+// run runs the machine for a number of frames. The debugger stops the
+// CPU at each breakpoint, and the frame continues once the breakpoint's
+// function returns.
+func (s *starlarkScript) run(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var frames int
+	if err := starlark.UnpackPositionalArgs("run", args, kwargs, 1, &frames); err != nil {
+		return nil, err
+	}
+	a := s.apple2
+	end := a.cpu.Cycles + uint64(frames)*cyclesPerFrame
+	for a.cpu.Cycles < end {
+		a.RunFrame()
+		if s.dbg.Stopped() {
+			pc := a.cpu.Reg.PC
+			s.dbg.Continue()
+			if fn := s.breakpoints[pc]; fn != nil {
+				if _, err := starlark.Call(s.thread, fn, starlark.Tuple{starlark.MakeInt(int(pc))}, nil); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		s.frames++
+		if s.onFrame != nil {
+			if _, err := starlark.Call(s.thread, s.onFrame, starlark.Tuple{starlark.MakeInt(s.frames)}, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return starlark.None, nil
+}
+
+// starlarkCallback unpacks a function argument that may be None.
+func starlarkCallback(v starlark.Value) (starlark.Callable, error) {
+	if v == starlark.None {
+		return nil, nil
+	}
+	fn, ok := v.(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s is not callable", v.Type())
+	}
+	return fn, nil
+}
+
+func (s *starlarkScript) breakpoint(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr int
+	var fnv starlark.Value
+	if err := starlark.UnpackPositionalArgs("breakpoint", args, kwargs, 2, &addr, &fnv); err != nil {
+		return nil, err
+	}
+	if addr < 0 || addr > 0xffff {
+		return nil, fmt.Errorf("address %d out of range", addr)
+	}
+	fn, err := starlarkCallback(fnv)
+	if err != nil {
+		return nil, err
+	}
+	if fn == nil {
+		delete(s.breakpoints, uint16(addr))
+	} else {
+		s.breakpoints[uint16(addr)] = fn
+	}
+	s.dbg.SetBreakpoint(uint16(addr), fn != nil)
+	return starlark.None, nil
+}
+
+func (s *starlarkScript) setOnFrame(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var fnv starlark.Value
+	if err := starlark.UnpackPositionalArgs("on_frame", args, kwargs, 1, &fnv); err != nil {
+		return nil, err
+	}
+	fn, err := starlarkCallback(fnv)
+	if err != nil {
+		return nil, err
+	}
+	s.onFrame = fn
+	return starlark.None, nil
+}
+
+func (s *starlarkScript) typeText(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text string
+	if err := starlark.UnpackPositionalArgs("type", args, kwargs, 1, &text); err != nil {
+		return nil, err
+	}
+	s.apple2.kb.PasteText(text)
+	return starlark.None, nil
+}
+
+func (s *starlarkScript) paddle(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n, v int
+	if err := starlark.UnpackPositionalArgs("paddle", args, kwargs, 2, &n, &v); err != nil {
+		return nil, err
+	}
+	if n < 0 || n > 3 || v < 0 || v > 255 {
+		return nil, errors.New("paddle must be 0 to 3, and value 0 to 255")
+	}
+	s.apple2.gi.SetPaddle(n, byte(v))
+	return starlark.None, nil
+}
+
+func (s *starlarkScript) button(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n int
+	var pressed bool
+	if err := starlark.UnpackPositionalArgs("button", args, kwargs, 2, &n, &pressed); err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= numButtons {
+		return nil, fmt.Errorf("button must be 0 to %d", numButtons-1)
+	}
+	s.apple2.gi.SetButton(n, pressed)
+	return starlark.None, nil
+}
+
+func (s *starlarkScript) screen(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs("screen", args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return starlark.String(s.apple2.ds.Text()), nil
+}
+
+func (s *starlarkScript) exit(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	status := 0
+	if err := starlark.UnpackPositionalArgs("exit", args, kwargs, 0, &status); err != nil {
+		return nil, err
+	}
+	return nil, &starlarkExit{status: status}
+}
@@ -0,0 +1,16 @@
+//go:build !starlark
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errNoStarlark = errors.New("this build can't run Starlark programs; build with -tags starlark")
+
+// RunStarlarkFile fails, because Starlark is only built in with the
+// starlark build tag.
+func (a *apple2) RunStarlarkFile(filename string, w io.Writer) (int, error) {
+	return 1, errNoStarlark
+}
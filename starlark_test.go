@@ -0,0 +1,72 @@
+//go:build starlark
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runStarlark runs a Starlark program on a machine, returning its exit
+// status and output.
+func runStarlark(t *testing.T, a *apple2, src string) (int, string, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "test.star")
+	if err := os.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	status, err := a.RunStarlarkFile(filename, &out)
+	return status, out.String(), err
+}
+
+func TestStarlark(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x300, []byte{
+		0xe6, 0x20, // INC $20
+		0xea,             // NOP
+		0x4c, 0x00, 0x03, // JMP $0300
+	})
+	a.cpu.SetPC(0x300)
+
+	status, out, err := runStarlark(t, a, `
+hits = []
+
+def on_nop(addr):
+    hits.append(addr)
+    if len(hits) == 3:
+        breakpoint(addr, None)
+
+poke(0x20, 0)
+breakpoint(0x302, on_nop)
+start = cycles()
+run(2)
+
+if hits != [0x302, 0x302, 0x302]:
+    fail("breakpoint hits: %s" % hits)
+if peek(0x20) == 0:
+    fail("program didn't run")
+if cycles() - start < 2 * 17030:
+    fail("ran %d cycles" % (cycles() - start))
+if reg("pc") < 0x300 or reg("pc") > 0x305:
+    fail("pc %x" % reg("pc"))
+set_reg("a", 0x42)
+print("a=%x" % reg("a"))
+exit(3)
+fail("exit returned")
+`)
+	if err != nil || status != 3 {
+		t.Fatalf("Expected exit status 3, got %d: %v\n%s", status, err, out)
+	}
+	if out != "a=42\n" {
+		t.Errorf("Unexpected output %q\n", out)
+	}
+
+	// A failing program returns a backtrace.
+	status, _, err = runStarlark(t, a, "poke(0x20)\n")
+	if err == nil || status != 1 || !strings.Contains(err.Error(), "poke") {
+		t.Errorf("Expected an error, got %d: %v\n", status, err)
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// Machine snapshots are stored in a versioned binary format: an
+// uncompressed header holding a magic string, the format version and the
+// machine model, followed by a gzip-compressed body. The body holds the
+// state of each component in a fixed order. Each component's state begins
+// with a four-character tag so that a corrupt or mismatched snapshot is
+// detected rather than silently misread.
+const (
+	stateMagic   = "A2GOSNAP"
+	stateVersion = 6
+
+	// stateMaxBody is the largest uncompressed body a snapshot may have,
+	// which bounds the memory a corrupt or hostile snapshot can take.
+	stateMaxBody = 256 << 20
+)
+
+var (
+	errStateMagic   = errors.New("not an apple2go snapshot")
+	errStateVersion = errors.New("unsupported snapshot version")
+	errStateCorrupt = errors.New("snapshot is corrupt")
+)
+
+// A stateSaver is a component or card whose state is included in
+// snapshots.
+type stateSaver interface {
+	saveState(sw *stateWriter)
+	loadState(sr *stateReader)
+}
+
+// A stateWriter writes snapshot values. Errors are sticky: after the first
+// failure, writes are ignored and the error is reported by Err.
+type stateWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *stateWriter) write(v interface{}) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.w, binary.LittleEndian, v)
+	}
+}
+
+func (sw *stateWriter) Tag(tag string)      { sw.write([]byte(tag)) }
+func (sw *stateWriter) Byte(v byte)         { sw.write(v) }
+func (sw *stateWriter) Bool(v bool)         { sw.write(v) }
+func (sw *stateWriter) Uint16(v uint16)     { sw.write(v) }
+func (sw *stateWriter) Uint32(v uint32)     { sw.write(v) }
+func (sw *stateWriter) Uint64(v uint64)     { sw.write(v) }
+func (sw *stateWriter) Int(v int)           { sw.write(int64(v)) }
+func (sw *stateWriter) Err() error          { return sw.err }
+func (sw *stateWriter) String(s string)     { sw.Bytes([]byte(s)) }
+func (sw *stateWriter) Bytes(b []byte)      { sw.Uint32(uint32(len(b))); sw.write(b) }
+func (sw *stateWriter) FixedBytes(b []byte) { sw.write(b) }
+
+// A stateReader reads snapshot values. Errors are sticky: after the first
+// failure, reads return zero values and the error is reported by Err.
+type stateReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *stateReader) read(v interface{}) {
+	if sr.err == nil {
+		sr.err = binary.Read(sr.r, binary.LittleEndian, v)
+		if sr.err == io.EOF || sr.err == io.ErrUnexpectedEOF {
+			sr.err = errStateCorrupt
+		}
+	}
+}
+
+// Tag verifies that the next value is the expected component tag.
+func (sr *stateReader) Tag(tag string) {
+	b := make([]byte, len(tag))
+	sr.read(b)
+	if sr.err == nil && string(b) != tag {
+		sr.err = fmt.Errorf("%w: expected %s state, found %q", errStateCorrupt, tag, b)
+	}
+}
+
+func (sr *stateReader) Byte() (v byte)      { sr.read(&v); return }
+func (sr *stateReader) Bool() (v bool)      { sr.read(&v); return }
+func (sr *stateReader) Uint16() (v uint16)  { sr.read(&v); return }
+func (sr *stateReader) Uint32() (v uint32)  { sr.read(&v); return }
+func (sr *stateReader) Uint64() (v uint64)  { sr.read(&v); return }
+func (sr *stateReader) Int() int            { var v int64; sr.read(&v); return int(v) }
+func (sr *stateReader) Err() error          { return sr.err }
+func (sr *stateReader) String() string      { return string(sr.Bytes()) }
+func (sr *stateReader) FixedBytes(b []byte) { sr.read(b) }
+
+// Fail records an error found while loading a component's state, unless
+// an earlier error was already recorded.
+func (sr *stateReader) Fail(err error) {
+	if sr.err == nil {
+		sr.err = err
+	}
+}
+
+// Bytes reads a length-prefixed byte slice. Lengths beyond the largest
+// value any component saves are treated as corruption.
+func (sr *stateReader) Bytes() []byte {
+	n := sr.Uint32()
+	if sr.err != nil {
+		return nil
+	}
+	if n > 16<<20 {
+		sr.err = errStateCorrupt
+		return nil
+	}
+	b := make([]byte, n)
+	sr.read(b)
+	return b
+}
+
+// SaveState writes a snapshot of the entire machine.
+func (a *apple2) SaveState(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	hdr := &stateWriter{w: bw}
+	hdr.FixedBytes([]byte(stateMagic))
+	hdr.Uint16(stateVersion)
+	hdr.String(a.cfg.name)
+	if hdr.Err() != nil {
+		return hdr.Err()
+	}
+
+	zw := gzip.NewWriter(bw)
+	sw := &stateWriter{w: zw}
+	a.saveState(sw)
+	if sw.Err() != nil {
+		return sw.Err()
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// maxStateSize returns an upper bound on the size of a snapshot of the
+// machine as it's configured now, whatever its memory holds. Data that
+// doesn't compress is stored by deflate in blocks of at least 16K, each
+// with a 5-byte header, so the body never grows by more than that over
+// its uncompressed size, plus gzip's own header and trailer.
+func (a *apple2) maxStateSize() (int, error) {
+	var body bytes.Buffer
+	sw := &stateWriter{w: &body}
+	a.saveState(sw)
+	if sw.Err() != nil {
+		return 0, sw.Err()
+	}
+	hdr := len(stateMagic) + 2 + 4 + len(a.cfg.name)
+	n := body.Len()
+	return hdr + n + 5*(n/(16<<10)+2) + 64, nil
+}
+
+// LoadState restores a snapshot written by SaveState. The machine must
+// have the same model, ROMs and cards as the one that was saved. The
+// snapshot is decompressed and checked before the machine is touched, and
+// if it still can't be applied, the machine is put back as it was. The
+// rewind history is discarded once a snapshot is loaded.
+func (a *apple2) LoadState(r io.Reader) error {
+	body, err := a.readState(r)
+	if err != nil {
+		return err
+	}
+	if err := a.checkState(body); err != nil {
+		return err
+	}
+
+	// A mismatch later in the body, such as a different card, is only
+	// found as the snapshot is applied.
+	var backup bytes.Buffer
+	a.saveState(&stateWriter{w: &backup})
+	if err := a.applyState(body); err != nil {
+		a.applyState(backup.Bytes())
+		return err
+	}
+	if a.rewind != nil {
+		a.rewind.Reset()
+	}
+	return nil
+}
+
+// restoreState restores a snapshot without the checks of LoadState, for
+// snapshots that the machine saved itself.
+func (a *apple2) restoreState(r io.Reader) error {
+	body, err := a.readState(r)
+	if err != nil {
+		return err
+	}
+	return a.applyState(body)
+}
+
+// readState reads a snapshot's header and returns its decompressed body.
+func (a *apple2) readState(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	hdr := &stateReader{r: br}
+	magic := make([]byte, len(stateMagic))
+	hdr.FixedBytes(magic)
+	if hdr.Err() != nil || string(magic) != stateMagic {
+		return nil, errStateMagic
+	}
+	if v := hdr.Uint16(); v != stateVersion {
+		return nil, fmt.Errorf("%w %d", errStateVersion, v)
+	}
+	if name := hdr.String(); hdr.Err() == nil && name != a.cfg.name {
+		return nil, fmt.Errorf("snapshot is of a different model (%s)", name)
+	}
+	if hdr.Err() != nil {
+		return nil, hdr.Err()
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, errStateCorrupt
+	}
+	body, err := io.ReadAll(io.LimitReader(zr, stateMaxBody+1))
+	if err != nil || len(body) > stateMaxBody {
+		return nil, errStateCorrupt
+	}
+	return body, nil
+}
+
+// checkState checks that a snapshot's body was saved by a machine with
+// the same system ROM and aux memory as this one.
+func (a *apple2) checkState(body []byte) error {
+	sr := &stateReader{r: bytes.NewReader(body)}
+	sr.Tag("CPU ")
+	sr.FixedBytes(make([]byte, 7)) // registers
+	sr.Uint64()                    // cycle count
+	a.mmu.checkState(sr)
+	return sr.Err()
+}
+
+// applyState loads a snapshot's body into the machine.
+func (a *apple2) applyState(body []byte) error {
+	sr := &stateReader{r: bytes.NewReader(body)}
+	a.loadState(sr)
+	return sr.Err()
+}
+
+// SaveStateFile writes a snapshot of the machine to a file.
+func (a *apple2) SaveStateFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = a.SaveState(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LoadStateFile restores a snapshot of the machine from a file.
+func (a *apple2) LoadStateFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return a.LoadState(file)
+}
+
+func (a *apple2) saveState(sw *stateWriter) {
+	sw.Tag("CPU ")
+	sw.Byte(a.cpu.Reg.A)
+	sw.Byte(a.cpu.Reg.X)
+	sw.Byte(a.cpu.Reg.Y)
+	sw.Byte(a.cpu.Reg.SP)
+	sw.Uint16(a.cpu.Reg.PC)
+	sw.Byte(a.cpu.Reg.SavePS(false))
+	sw.Uint64(a.cpu.Cycles)
+
+	a.mmu.saveState(sw)
+	a.iou.saveState(sw)
+	a.kb.saveState(sw)
+	a.gi.saveState(sw)
+	a.sp.saveState(sw)
+	if a.mouse != nil {
+		a.mouse.saveState(sw)
+	}
+	sw.Bool(a.zip != nil)
+	if a.zip != nil {
+		a.zip.saveState(sw)
+	}
+
+	sw.Tag("SLOT")
+	sw.Int(a.sm.expansion)
+	sw.Bool(a.sm.intC8ROM)
+	for _, c := range a.sm.cards {
+		sw.String(cardTypeName(c))
+		if s, ok := c.(stateSaver); ok {
+			s.saveState(sw)
+		}
+	}
+}
+
+func (a *apple2) loadState(sr *stateReader) {
+	sr.Tag("CPU ")
+	a.cpu.Reg.A = sr.Byte()
+	a.cpu.Reg.X = sr.Byte()
+	a.cpu.Reg.Y = sr.Byte()
+	a.cpu.Reg.SP = sr.Byte()
+	a.cpu.Reg.PC = sr.Uint16()
+	a.cpu.Reg.RestorePS(sr.Byte())
+	a.cpu.Cycles = sr.Uint64()
+
+	// Devices schedule their pending events and assert their interrupt
+	// lines again as they are restored.
+	a.sched.Reset()
+	a.ints.Reset()
+
+	a.mmu.loadState(sr)
+	a.iou.loadState(sr)
+	a.kb.loadState(sr)
+	a.gi.loadState(sr)
+	a.sp.loadState(sr)
+	if a.mouse != nil {
+		a.mouse.loadState(sr)
+	}
+	if zip := sr.Bool(); sr.Err() == nil && zip != (a.zip != nil) {
+		sr.Fail(errors.New("snapshot has a different accelerator configuration"))
+		return
+	}
+	if a.zip != nil {
+		a.zip.loadState(sr)
+	}
+
+	sr.Tag("SLOT")
+	a.sm.expansion = sr.Int()
+	a.sm.intC8ROM = sr.Bool()
+	if sr.Err() == nil && (a.sm.expansion < 0 || a.sm.expansion >= numSlots) {
+		sr.Fail(errStateCorrupt)
+		return
+	}
+	for slot, c := range a.sm.cards {
+		if name := sr.String(); sr.Err() == nil && name != cardTypeName(c) {
+			sr.Fail(fmt.Errorf("snapshot has a different card in slot %d (%s)", slot, name))
+			return
+		}
+		if s, ok := c.(stateSaver); ok {
+			s.loadState(sr)
+		}
+	}
+
+	// Audio rendering resumes from the restored cycle count.
+	a.au.cycle = float64(a.cpu.Cycles)
+	a.cas.cycle = float64(a.cpu.Cycles)
+	a.cas.toggles = a.cas.toggles[:0]
+
+	// Host input resumes from the restored input state.
+	a.in.sync()
+
+	// Video memory was restored without the MMU noticing.
+	a.ds.Invalidate()
+}
+
+// cardTypeName returns a name identifying the type of a card, or "" for
+// an empty slot.
+func cardTypeName(c card) string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+func (m *mmu) saveState(sw *stateWriter) {
+	sw.Tag("MMU ")
+	sw.Uint32(crc32.ChecksumIEEE(m.romBanks[0]))
+	sw.Int(m.romBank)
+	sw.FixedBytes(m.mainRAM)
+	sw.Byte(byte(m.auxCard))
+	sw.Int(len(m.auxBanks))
+	for _, b := range m.auxBanks {
+		sw.FixedBytes(b)
+	}
+	sw.Int(m.auxBank)
+}
+
+// checkState reads the MMU's state without loading it, and fails if the
+// snapshot was made with a different system ROM or has aux memory that
+// can't be installed.
+func (m *mmu) checkState(sr *stateReader) {
+	sr.Tag("MMU ")
+	if crc := sr.Uint32(); sr.Err() == nil && crc != crc32.ChecksumIEEE(m.romBanks[0]) {
+		sr.Fail(errors.New("snapshot was made with a different system ROM"))
+		return
+	}
+	romBank := sr.Int()
+	sr.FixedBytes(make([]byte, len(m.mainRAM)))
+	auxCard := auxCardType(sr.Byte())
+	banks := sr.Int()
+	if sr.Err() != nil {
+		return
+	}
+	switch {
+	case auxCard > auxCardRamWorks, banks < 1, banks > maxRamWorksBanks:
+		sr.Fail(errStateCorrupt)
+	case auxCard != auxCardRamWorks && banks != 1:
+		sr.Fail(errStateCorrupt)
+	case romBank < 0 || romBank >= len(m.romBanks):
+		sr.Fail(errStateCorrupt)
+	}
+}
+
+func (m *mmu) loadState(sr *stateReader) {
+	sr.Tag("MMU ")
+	if crc := sr.Uint32(); sr.Err() == nil && crc != crc32.ChecksumIEEE(m.romBanks[0]) {
+		sr.Fail(errors.New("snapshot was made with a different system ROM"))
+		return
+	}
+	romBank := sr.Int()
+	sr.FixedBytes(m.mainRAM)
+
+	auxCard := auxCardType(sr.Byte())
+	banks := sr.Int()
+	if sr.Err() != nil {
+		return
+	}
+	if auxCard > auxCardRamWorks || banks < 1 || banks > maxRamWorksBanks || romBank >= len(m.romBanks) {
+		sr.Fail(errStateCorrupt)
+		return
+	}
+	m.SetAuxCard(auxCard)
+	if auxCard == auxCardRamWorks {
+		m.SetRamWorksBanks(banks)
+	}
+	if banks != len(m.auxBanks) {
+		sr.Fail(errStateCorrupt)
+		return
+	}
+	for _, b := range m.auxBanks {
+		sr.FixedBytes(b)
+	}
+	m.SelectAuxBank(sr.Int())
+	m.SelectROMBank(romBank)
+}
+
+func (iou *iou) saveState(sw *stateWriter) {
+	sw.Tag("IOU ")
+	sw.Uint32(iou.switches)
+}
+
+func (iou *iou) loadState(sr *stateReader) {
+	sr.Tag("IOU ")
+	iou.switches = sr.Uint32()
+	iou.updates = updateSystemRAM | updateZPSRAM | updateLCRAM | updateSlotROM
+	iou.applySwitchUpdates()
+}
+
+// Keyboard snapshots include the modifier and held keys and any pasted
+// text not yet typed, so that input replayed after loading a snapshot
+// has the same effect it originally did.
+func (kb *keyboard) saveState(sw *stateWriter) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	sw.Tag("KBD ")
+	sw.Byte(kb.keydata)
+	sw.Bool(kb.keydown)
+	for _, b := range []bool{kb.mods.shift, kb.mods.ctrl, kb.mods.capsLock, kb.mods.openApple, kb.mods.closedApple} {
+		sw.Bool(b)
+	}
+
+	held := make([]int, 0, len(kb.held))
+	for k := range kb.held {
+		held = append(held, int(k))
+	}
+	sort.Ints(held)
+	sw.Int(len(held))
+	for _, k := range held {
+		sw.Uint16(uint16(k))
+	}
+	sw.Uint16(uint16(kb.repeatKey))
+	sw.Uint64(kb.repeatAt)
+
+	sw.Bytes(kb.paste.codes)
+	sw.Uint64(kb.paste.next)
+	sw.Bool(kb.paste.waiting)
+	sw.Uint64(kb.paste.waitEnd)
+}
+
+func (kb *keyboard) loadState(sr *stateReader) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	sr.Tag("KBD ")
+	kb.keydata = sr.Byte()
+	kb.keydown = sr.Bool()
+	for _, b := range []*bool{&kb.mods.shift, &kb.mods.ctrl, &kb.mods.capsLock, &kb.mods.openApple, &kb.mods.closedApple} {
+		*b = sr.Bool()
+	}
+
+	n := sr.Int()
+	if n < 0 || n > 256 {
+		sr.Fail(errStateCorrupt)
+		return
+	}
+	kb.held = make(map[hostKey]bool)
+	for i := 0; i < n; i++ {
+		kb.held[hostKey(sr.Uint16())] = true
+	}
+	kb.repeatKey = hostKey(sr.Uint16())
+	kb.repeatAt = sr.Uint64()
+
+	kb.paste.codes = sr.Bytes()
+	kb.paste.next = sr.Uint64()
+	kb.paste.waiting = sr.Bool()
+	kb.paste.waitEnd = sr.Uint64()
+	kb.events = kb.events[:0]
+}
+
+func (g *gameIO) saveState(sw *stateWriter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sw.Tag("GAME")
+	sw.FixedBytes(g.paddles[:])
+	for _, b := range g.buttons {
+		sw.Bool(b)
+	}
+	sw.Uint64(g.trigger)
+}
+
+func (g *gameIO) loadState(sr *stateReader) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sr.Tag("GAME")
+	sr.FixedBytes(g.paddles[:])
+	for i := range g.buttons {
+		g.buttons[i] = sr.Bool()
+	}
+	g.trigger = sr.Uint64()
+}
+
+func (s *speaker) saveState(sw *stateWriter) {
+	sw.Tag("SPKR")
+	sw.Bool(s.level)
+}
+
+func (s *speaker) loadState(sr *stateReader) {
+	sr.Tag("SPKR")
+	s.level = sr.Bool()
+	s.toggles = s.toggles[:0]
+}
+
+func (m *iicMouse) saveState(sw *stateWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sw.Tag("MOUS")
+	sw.Int(m.dx)
+	sw.Int(m.dy)
+	sw.Bool(m.button)
+	for _, b := range []bool{m.x1, m.y1, m.xyEnabled, m.vblEnabled, m.x0Falling, m.y0Falling, m.xInt, m.yInt, m.vblInt} {
+		sw.Bool(b)
+	}
+}
+
+func (m *iicMouse) loadState(sr *stateReader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sr.Tag("MOUS")
+	m.dx = sr.Int()
+	m.dy = sr.Int()
+	m.button = sr.Bool()
+	for _, b := range []*bool{&m.x1, &m.y1, &m.xyEnabled, &m.vblEnabled, &m.x0Falling, &m.y0Falling, &m.xInt, &m.yInt, &m.vblInt} {
+		*b = sr.Bool()
+	}
+	m.updateIRQ()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.dsk")
+	if err := os.WriteFile(filename, testDiskData(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newMachine := func() *apple2 {
+		a := newApple2()
+		a.mmu.SetAuxCard(auxCardRamWorks)
+		a.mmu.SetRamWorksBanks(4)
+		a.sm.Insert(4, newMockingboard(a))
+		a.sm.Insert(6, newDiskII(a, nil))
+		return a
+	}
+
+	a := newMachine()
+	if err := a.InsertDisk(1, filename); err != nil {
+		t.Fatal(err)
+	}
+	a.cpu.Reg.A, a.cpu.Reg.X, a.cpu.Reg.PC = 0x12, 0x34, 0x5678
+	a.cpu.Reg.Carry = true
+	a.cpu.Cycles = 123456
+	a.mmu.StoreByte(0x0300, 0xaa)
+	a.mmu.LoadByte(0xc083) // read and write LC bank 2 RAM
+	a.mmu.LoadByte(0xc083)
+	a.mmu.StoreByte(0xd000, 0xbb)
+	a.mmu.StoreByte(0xc073, 2) // RamWorks bank 2
+	a.mmu.StoreByte(0xc005, 0) // RAMWRT
+	a.mmu.StoreByte(0x0300, 0xcc)
+	a.mmu.StoreByte(0xc404, 0x55) // Mockingboard VIA timer 1 latch low
+	a.mmu.LoadByte(0xc0e9)        // Disk II motor on
+
+	var buf bytes.Buffer
+	if err := a.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newMachine()
+	if err := b.LoadState(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.cpu.Reg.A != 0x12 || b.cpu.Reg.X != 0x34 || b.cpu.Reg.PC != 0x5678 || !b.cpu.Reg.Carry {
+		t.Errorf("CPU registers not restored: %+v\n", b.cpu.Reg)
+	}
+	if b.cpu.Cycles != 123456 {
+		t.Errorf("Expected 123456 cycles, got %d\n", b.cpu.Cycles)
+	}
+	if b.iou.switches != a.iou.switches {
+		t.Errorf("Expected switches %08x, got %08x\n", a.iou.switches, b.iou.switches)
+	}
+	if v := b.mmu.LoadByte(0x0300); v != 0xaa {
+		t.Errorf("Main RAM: expected aa, got %02x\n", v)
+	}
+	if v := b.mmu.LoadByte(0xd000); v != 0xbb {
+		t.Errorf("LC RAM: expected bb, got %02x\n", v)
+	}
+	if b.mmu.auxBank != 2 || b.mmu.auxBanks[2][0x0300] != 0xcc {
+		t.Errorf("RamWorks bank 2 not restored\n")
+	}
+	if v := b.mmu.LoadByte(0xc406); v != 0x55 {
+		t.Errorf("VIA latch: expected 55, got %02x\n", v)
+	}
+	d, _ := b.diskController()
+	if !d.MotorOn() || d.drives[0].disk == nil || d.drives[0].filename != filename {
+		t.Fatalf("Disk drive not restored\n")
+	}
+	if !bytes.Equal(d.drives[0].disk.tracks[5], a.sm.Card(6).(*diskII).drives[0].disk.tracks[5]) {
+		t.Errorf("Disk contents not restored\n")
+	}
+}
+
+func TestStateMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newApple2().SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if err := newApple2Model(modelIIPlus).LoadState(bytes.NewReader(data)); err == nil {
+		t.Errorf("Expected an error loading a snapshot into a different model\n")
+	}
+
+	a := newApple2()
+	a.sm.Insert(4, newMockingboard(a))
+	if err := a.LoadState(bytes.NewReader(data)); err == nil {
+		t.Errorf("Expected an error loading a snapshot with different cards\n")
+	}
+
+	bad := append([]byte{}, data...)
+	bad[len(stateMagic)] = 99
+	if err := newApple2().LoadState(bytes.NewReader(bad)); !errors.Is(err, errStateVersion) {
+		t.Errorf("Expected errStateVersion, got %v\n", err)
+	}
+
+	if err := newApple2().LoadState(bytes.NewReader(data[:len(data)/2])); err == nil {
+		t.Errorf("Expected an error loading a truncated snapshot\n")
+	}
+}
+
+// TestStateLoadFailure checks that a snapshot that fails to load leaves
+// the machine and its rewind history as they were.
+func TestStateLoadFailure(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newApple2().SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	a := newApple2()
+	a.sm.Insert(4, newMockingboard(a))
+	a.EnableRewind(10)
+	a.mmu.StoreBytes(0x0300, []byte{0x4c, 0x00, 0x03})
+	a.cpu.SetPC(0x0300)
+	for i := 0; i < 60; i++ {
+		a.RunFrame()
+	}
+	a.mmu.StoreByte(0x2000, 0x5a)
+	cycles, seconds := a.cpu.Cycles, a.rewind.Seconds()
+
+	// A snapshot made with a different ROM, which is found before the
+	// machine is touched, unlike the card in slot 4.
+	rom := newApple2()
+	rom.sm.Insert(4, newMockingboard(rom))
+	rom.mmu.romBanks[0] = append([]byte{}, rom.mmu.romBanks[0]...)
+	rom.mmu.romBanks[0][0] ^= 0xff
+	var rbuf bytes.Buffer
+	if err := rom.SaveState(&rbuf); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"different cards", data},
+		{"truncated", data[:len(data)-10]},
+		{"different ROM", rbuf.Bytes()},
+	}
+	for _, c := range cases {
+		if err := a.LoadState(bytes.NewReader(c.data)); err == nil {
+			t.Errorf("%s: expected an error\n", c.name)
+		}
+		if a.cpu.Cycles != cycles || a.cpu.Reg.PC < 0x0300 || a.cpu.Reg.PC > 0x0302 {
+			t.Errorf("%s: CPU changed, cycle %d, PC %04X\n", c.name, a.cpu.Cycles, a.cpu.Reg.PC)
+		}
+		if v := a.mmu.LoadByte(0x2000); v != 0x5a {
+			t.Errorf("%s: expected $5A at $2000, got $%02X\n", c.name, v)
+		}
+		if s := a.rewind.Seconds(); s != seconds {
+			t.Errorf("%s: rewind history changed from %.2fs to %.2fs\n", c.name, seconds, s)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// The streaming front end serves a page that shows the display of a
+// headless machine in a browser, plays its audio, and sends back the
+// browser's keyboard, mouse and gamepad input, over a WebSocket at /ws.
+//
+// When a client connects, the server sends it a text message holding a
+// JSON object with the framebuffer's width and height and the audio
+// sample rate. After that it sends binary messages whose first byte gives
+// their kind:
+//
+//	1  display update: the rest is raw DEFLATE data holding runs of
+//	   changed framebuffer rows, each a byte giving the first row, a byte
+//	   giving the number of rows, and their pixels as RGB bytes
+//	2  audio: the rest is 16-bit little-endian mono samples
+//
+// The client sends text messages holding JSON objects with a type member:
+//
+//	{"type": "key", "code": "KeyA", "down": true}   a KeyboardEvent code
+//	{"type": "axis", "axis": 0, "value": -0.5}      a joystick axis, -1..1
+//	{"type": "button", "button": 0, "down": true}   a joystick button
+//	{"type": "mouse", "dx": 3, "dy": -1, "down": false}
+//
+// Mouse messages move the IIc's mouse or an AppleMouse card, if the
+// machine has one.
+
+// Stream message kinds.
+const (
+	streamVideo = 1
+	streamAudio = 2
+)
+
+// streamQueue is the number of messages that may wait to be sent to a
+// client. When a slow client's queue is full, it misses updates until it
+// catches up, and is then sent the whole display.
+const streamQueue = 8
+
+//go:embed web/stream.html
+var streamPage []byte
+
+// A displayStream sends the machine's display and audio to the clients
+// connected to it.
+type displayStream struct {
+	apple2 *apple2
+	mouse  *mouseInput
+
+	mu      sync.Mutex
+	clients map[*streamClient]bool
+
+	rgb     []byte // the display as RGB pixels
+	last    []byte // the display as last sent
+	samples []int16
+	buf     bytes.Buffer
+	zw      *flate.Writer
+}
+
+// A streamClient is a connected client and the messages waiting to be
+// sent to it.
+type streamClient struct {
+	conn  *wsConn
+	out   chan []byte
+	done  chan struct{} // closed when the client disconnects
+	whole bool          // the client must be sent the whole display
+}
+
+// streamInput is a message from a client.
+type streamInput struct {
+	Type   string  `json:"type"`
+	Code   string  `json:"code"`
+	Down   bool    `json:"down"`
+	Axis   int     `json:"axis"`
+	Value  float64 `json:"value"`
+	Button int     `json:"button"`
+	DX     int     `json:"dx"`
+	DY     int     `json:"dy"`
+}
+
+func newDisplayStream(apple2 *apple2) *displayStream {
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &displayStream{
+		apple2:  apple2,
+		mouse:   apple2.MouseInput(),
+		clients: make(map[*streamClient]bool),
+		rgb:     make([]byte, displayWidth*displayHeight*3),
+		last:    make([]byte, displayWidth*displayHeight*3),
+		zw:      zw,
+	}
+}
+
+// Send sends the changes to the display since the last call, and the
+// audio produced since then, to the clients. The machine's goroutine
+// calls it between frames.
+func (s *displayStream) Send() {
+	s.mu.Lock()
+	clients := make([]*streamClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	if len(clients) == 0 {
+		return
+	}
+
+	fb := s.apple2.ds.Framebuffer()
+	for y := 0; y < displayHeight; y++ {
+		row := fb.Pix[y*fb.Stride:]
+		for x := 0; x < displayWidth; x++ {
+			copy(s.rgb[(y*displayWidth+x)*3:], row[x*4:x*4+3])
+		}
+	}
+	var changes, whole []byte
+	for _, c := range clients {
+		msg := &changes
+		if c.whole {
+			msg = &whole
+		}
+		if *msg == nil {
+			*msg = s.videoMessage(c.whole)
+		}
+		if len(*msg) > 1 {
+			c.whole = !s.post(c, *msg)
+		}
+	}
+	copy(s.last, s.rgb)
+
+	if n := s.apple2.au.buf.Buffered(); n > 0 {
+		if cap(s.samples) < n {
+			s.samples = make([]int16, n)
+		}
+		samples := s.samples[:n]
+		s.apple2.au.ReadSamples(samples)
+		msg := make([]byte, 1, 1+2*n)
+		msg[0] = streamAudio
+		for _, v := range samples {
+			msg = binary.LittleEndian.AppendUint16(msg, uint16(v))
+		}
+		for _, c := range clients {
+			s.post(c, msg)
+		}
+	}
+}
+
+// videoMessage returns a display update holding the rows that changed
+// since the last update, or all of them if whole is true. It holds only
+// its kind if no rows changed.
+func (s *displayStream) videoMessage(whole bool) []byte {
+	const rowBytes = displayWidth * 3
+	s.buf.Reset()
+	s.buf.WriteByte(streamVideo)
+	s.zw.Reset(&s.buf)
+	changed := false
+	for y := 0; y < displayHeight; {
+		if !whole && bytes.Equal(s.rgb[y*rowBytes:(y+1)*rowBytes], s.last[y*rowBytes:(y+1)*rowBytes]) {
+			y++
+			continue
+		}
+		end := y + 1
+		for end < displayHeight && (whole || !bytes.Equal(s.rgb[end*rowBytes:(end+1)*rowBytes], s.last[end*rowBytes:(end+1)*rowBytes])) {
+			end++
+		}
+		s.zw.Write([]byte{byte(y), byte(end - y)})
+		s.zw.Write(s.rgb[y*rowBytes : end*rowBytes])
+		changed = true
+		y = end
+	}
+	if !changed {
+		return []byte{streamVideo}
+	}
+	s.zw.Close()
+	return bytes.Clone(s.buf.Bytes())
+}
+
+// post queues a message to be sent to a client, and returns false if the
+// client's queue was full.
+func (s *displayStream) post(c *streamClient, msg []byte) bool {
+	select {
+	case c.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServeHTTP serves the page at / and the stream at /ws.
+func (s *displayStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(streamPage)
+	case "/ws":
+		s.serveClient(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveClient streams to a client until it disconnects.
+func (s *displayStream) serveClient(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	hello, _ := json.Marshal(map[string]int{
+		"width":      displayWidth,
+		"height":     displayHeight,
+		"sampleRate": audioSampleRate,
+	})
+	if err := conn.WriteMessage(wsText, hello); err != nil {
+		return
+	}
+
+	c := &streamClient{
+		conn:  conn,
+		out:   make(chan []byte, streamQueue),
+		done:  make(chan struct{}),
+		whole: true,
+	}
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		close(c.done)
+	}()
+
+	go func() {
+		for {
+			select {
+			case msg := <-c.out:
+				if conn.WriteMessage(wsBinary, msg) != nil {
+					conn.Close()
+					return
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	for {
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var in streamInput
+		if op == wsText && json.Unmarshal(data, &in) == nil {
+			s.input(in)
+		}
+	}
+}
+
+// input passes a client's input to the machine.
+func (s *displayStream) input(in streamInput) {
+	a := s.apple2
+	switch in.Type {
+	case "key":
+		if k, ok := browserKeys[in.Code]; ok {
+			a.im.KeyEvent(k, in.Down)
+		}
+	case "axis":
+		if in.Axis >= 0 && in.Axis < numPaddles {
+			a.gi.SetAxis(in.Axis, in.Value)
+		}
+	case "button":
+		if in.Button >= 0 && in.Button < numButtons {
+			a.im.GamepadButton(in.Button, in.Down)
+		}
+	case "mouse":
+		if s.mouse != nil {
+			s.mouse.Move(in.DX, in.DY)
+			s.mouse.SetButton(in.Down)
+		}
+	}
+}
+
+// serveStream serves the streaming front end of a machine over HTTP on a
+// TCP address. It returns the listener, which stops the server when
+// closed. RunFrame sends the updates, in whichever loop runs the machine.
+func serveStream(a *apple2, addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	a.stream = newDisplayStream(a)
+	go http.Serve(l, a.stream)
+	return l, nil
+}
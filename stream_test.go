@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestClient is the client end of a WebSocket connection, for tests.
+type wsTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string) *wsTestClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", url+"/ws", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Write(conn)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept value for this key is given in RFC 6455.
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake reply %s %v\n", resp.Status, resp.Header)
+	}
+	return &wsTestClient{t: t, conn: conn, br: br}
+}
+
+// write sends a masked message in two fragments.
+func (c *wsTestClient) write(op byte, data []byte) {
+	mask := []byte{1, 2, 3, 4}
+	half := len(data) / 2
+	for i, part := range [][]byte{data[:half], data[half:]} {
+		hdr := []byte{op, 0x80 | byte(len(part))}
+		if i == 1 {
+			hdr[0] = 0x80 | wsContinuation
+		}
+		masked := make([]byte, len(part))
+		for j := range part {
+			masked[j] = part[j] ^ mask[j%4]
+		}
+		c.conn.Write(append(append(hdr, mask...), masked...))
+	}
+}
+
+// read returns the opcode and data of the next message.
+func (c *wsTestClient) read() (byte, []byte) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	n := int(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(ext[0])<<8 | int(ext[1])
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(ext[4])<<24 | int(ext[5])<<16 | int(ext[6])<<8 | int(ext[7])
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		c.t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, data
+}
+
+// readVideo returns the inflated contents of the next display update.
+func (c *wsTestClient) readVideo() []byte {
+	op, data := c.read()
+	if op != wsBinary || len(data) == 0 || data[0] != streamVideo {
+		c.t.Fatalf("Expected a display update, got opcode %d %v\n", op, data[:min(len(data), 8)])
+	}
+	rows, err := io.ReadAll(flate.NewReader(bytes.NewReader(data[1:])))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return rows
+}
+
+func TestDisplayStream(t *testing.T) {
+	a := newApple2()
+	a.stream = newDisplayStream(a)
+	srv := httptest.NewServer(a.stream)
+	defer srv.Close()
+
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	op, hello := c.read()
+	var info map[string]int
+	if op != wsText || json.Unmarshal(hello, &info) != nil || info["width"] != displayWidth {
+		t.Fatalf("Unexpected hello message %d %s\n", op, hello)
+	}
+
+	// A new client is sent the whole display, and then the rows that
+	// change.
+	const rowBytes = displayWidth * 3
+	for i := 0; i < 100; i++ {
+		a.stream.mu.Lock()
+		n := len(a.stream.clients)
+		a.stream.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.mmu.LoadByte(0xc051) // TEXT
+	a.ds.Render()
+	a.stream.Send()
+	if rows := c.readVideo(); len(rows) != 2+displayHeight*rowBytes || rows[0] != 0 || rows[1] != displayHeight {
+		t.Errorf("Expected the whole display, got %d bytes starting % x\n", len(rows), rows[:2])
+	}
+	a.mmu.StoreByte(0x0480, 0x80)
+	a.ds.Render()
+	a.stream.Send()
+	if rows := c.readVideo(); len(rows) != 2+8*rowBytes || rows[0] != 8 || rows[1] != 8 {
+		t.Errorf("Expected text row 1, got %d bytes starting % x\n", len(rows), rows[:2])
+	}
+
+	// Input from the client reaches the machine.
+	c.write(wsText, []byte(`{"type": "axis", "axis": 1, "value": 1}`))
+	c.write(wsText, []byte(`{"type": "key", "code": "KeyQ", "down": true}`))
+	for i := 0; i < 100 && a.kb.GetKeyData()&0x7f != 'Q'; i++ {
+		time.Sleep(time.Millisecond)
+		a.kb.Update()
+	}
+	a.gi.mu.Lock()
+	paddle := a.gi.paddles[1]
+	a.gi.mu.Unlock()
+	if paddle != 255 || a.kb.GetKeyData()&0x7f != 'Q' {
+		t.Errorf("Expected paddle 1 at 255 and key Q, got %d $%02X\n", paddle, a.kb.GetKeyData())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ioSwitchNames = []string{
+	"AUXRAMRD",
+	"AUXRAMWRT",
+	"ALTCHARSET",
+	"TEXT",
+	"MIXED",
+	"80COL",
+	"80STORE",
+	"PAGE2",
+	"HIRES",
+	"DHIRES",
+	"IOUDIS",
+	"ALTZP",
+	"LCRAMRD",
+	"LCRAMWRT",
+	"LCBANK2",
+	"CXROM",
+	"C3ROM",
+	"VBLINT",
+	"AN0",
+	"AN1",
+	"AN2",
+	"AN3",
+	"LCPREWRITE",
+}
+
+func (sw ioSwitch) String() string {
+	if int(sw) < len(ioSwitchNames) {
+		return ioSwitchNames[sw]
+	}
+	return "INVALID"
+}
+
+// A switchLog writes a line for each access to the I/O page at
+// $C000..$C0FF, giving the CPU cycle, the address of the accessing
+// instruction, the address accessed, whether it was read (r) or written
+// (w), the value transferred, and the soft switches the access turned on
+// (+) or off (-).
+type switchLog struct {
+	w           io.Writer
+	changesOnly bool // true = log only accesses that change a switch
+}
+
+// StartSwitchLog starts logging I/O page accesses to w. If changesOnly is
+// true, only accesses that change a soft switch are logged.
+func (iou *iou) StartSwitchLog(w io.Writer, changesOnly bool) {
+	iou.log = &switchLog{w: w, changesOnly: changesOnly}
+}
+
+// StopSwitchLog stops logging I/O page accesses.
+func (iou *iou) StopSwitchLog() {
+	iou.log = nil
+}
+
+func (l *switchLog) access(iou *iou, addr uint16, write bool, v byte, before uint32) {
+	changed := before ^ iou.switches
+	if changed == 0 && l.changesOnly {
+		return
+	}
+
+	cpu := iou.apple2.cpu
+	rw := 'r'
+	if write {
+		rw = 'w'
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%10d %04X  %04X %c %02X", cpu.Cycles, cpu.LastPC, 0xc000|addr, rw, v)
+	for sw := ioSwitch(0); sw < ioSwitchINVALID; sw++ {
+		if changed&(1<<sw) == 0 {
+			continue
+		}
+		if iou.testSoftSwitch(sw) {
+			fmt.Fprintf(&b, " +%v", sw)
+		} else {
+			fmt.Fprintf(&b, " -%v", sw)
+		}
+	}
+	fmt.Fprintln(l.w, b.String())
+}
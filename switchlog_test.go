@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSwitchLog(t *testing.T) {
+	a := newApple2()
+	a.iou.setSoftSwitch(ioSwitchTEXT, true)
+	a.iou.applySwitchUpdates()
+
+	var out bytes.Buffer
+	a.iou.StartSwitchLog(&out, false)
+	a.mmu.LoadByte(0xc050)     // TEXT off
+	a.mmu.StoreByte(0xc001, 0) // 80STORE on
+	a.mmu.LoadByte(0xc050)     // no change
+	a.iou.StopSwitchLog()
+	a.mmu.LoadByte(0xc051)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 log lines, got %d:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[0], "C050 r") || !strings.HasSuffix(lines[0], " -TEXT") {
+		t.Errorf("Unexpected line %q\n", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "C001 w 00 +80STORE") {
+		t.Errorf("Unexpected line %q\n", lines[1])
+	}
+	if strings.ContainsAny(lines[2][len(lines[2])-3:], "+-") {
+		t.Errorf("Unexpected change in line %q\n", lines[2])
+	}
+
+	out.Reset()
+	a.iou.StartSwitchLog(&out, true)
+	a.mmu.LoadByte(0xc000)
+	a.mmu.LoadByte(0xc055)
+	if n := strings.Count(out.String(), "\n"); n != 1 || !strings.HasSuffix(out.String(), " +PAGE2\n") {
+		t.Errorf("Unexpected changes-only log %q\n", out.String())
+	}
+}
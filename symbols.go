@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The ProDOS machine language interface is called with a JSR to its entry
+// point, followed by a command byte and the address of a parameter list.
+const prodosMLI = 0xbf00
+
+// prodosCalls names the ProDOS MLI commands.
+var prodosCalls = map[byte]string{
+	0x40: "ALLOC_INTERRUPT",
+	0x41: "DEALLOC_INTERRUPT",
+	0x65: "QUIT",
+	0x80: "READ_BLOCK",
+	0x81: "WRITE_BLOCK",
+	0x82: "GET_TIME",
+	0xc0: "CREATE",
+	0xc1: "DESTROY",
+	0xc2: "RENAME",
+	0xc3: "SET_FILE_INFO",
+	0xc4: "GET_FILE_INFO",
+	0xc5: "ON_LINE",
+	0xc6: "SET_PREFIX",
+	0xc7: "GET_PREFIX",
+	0xc8: "OPEN",
+	0xc9: "NEWLINE",
+	0xca: "READ",
+	0xcb: "WRITE",
+	0xcc: "CLOSE",
+	0xcd: "FLUSH",
+	0xce: "SET_MARK",
+	0xcf: "GET_MARK",
+	0xd0: "SET_EOF",
+	0xd1: "GET_EOF",
+	0xd2: "SET_BUF",
+	0xd3: "GET_BUF",
+}
+
+// romSymbols names well-known monitor entry points, zero page locations,
+// vectors and soft switches shared by all models.
+var romSymbols = map[uint16]string{
+	0x0024: "CH",
+	0x0025: "CV",
+	0x0028: "BASL",
+	0x0029: "BASH",
+	0x0036: "CSWL",
+	0x0037: "CSWH",
+	0x0038: "KSWL",
+	0x0039: "KSWH",
+	0x03f0: "BRKV",
+	0x03f2: "SOFTEV",
+	0x03f4: "PWREDUP",
+	0xbf00: "MLI",
+	0xc000: "KBD",
+	0xc010: "KBDSTRB",
+	0xc030: "SPKR",
+	0xc050: "TXTCLR",
+	0xc051: "TXTSET",
+	0xc052: "MIXCLR",
+	0xc053: "MIXSET",
+	0xc054: "LOWSCR",
+	0xc055: "HISCR",
+	0xc056: "LORES",
+	0xc057: "HIRES",
+	0xc061: "BUTN0",
+	0xc062: "BUTN1",
+	0xc064: "PADDL0",
+	0xc070: "PTRIG",
+	0xf800: "PLOT",
+	0xf832: "CLRSCR",
+	0xf836: "CLRTOP",
+	0xf864: "SETCOL",
+	0xf941: "PRNTAX",
+	0xf948: "PRBLNK",
+	0xfa62: "RESET",
+	0xfb1e: "PREAD",
+	0xfb2f: "INIT",
+	0xfb39: "SETTXT",
+	0xfb40: "SETGR",
+	0xfbc1: "BASCALC",
+	0xfbdd: "BELL1",
+	0xfc22: "VTAB",
+	0xfc24: "VTABZ",
+	0xfc42: "CLREOP",
+	0xfc58: "HOME",
+	0xfc9c: "CLREOL",
+	0xfca8: "WAIT",
+	0xfd0c: "RDKEY",
+	0xfd1b: "KEYIN",
+	0xfd35: "RDCHAR",
+	0xfd67: "GETLNZ",
+	0xfd6a: "GETLN",
+	0xfd8b: "CROUT1",
+	0xfd8e: "CROUT",
+	0xfdda: "PRBYTE",
+	0xfde3: "PRHEX",
+	0xfded: "COUT",
+	0xfdf0: "COUT1",
+	0xfe80: "SETINV",
+	0xfe84: "SETNORM",
+	0xfe89: "SETKBD",
+	0xfe93: "SETVID",
+	0xff2d: "PRERR",
+	0xff3a: "BELL",
+	0xff59: "OLDRST",
+	0xff65: "MON",
+	0xff69: "MONZ",
+}
+
+// A symbolTable maps addresses to names for the disassembler and the
+// debugger's commands.
+type symbolTable struct {
+	names map[uint16]string
+	addrs map[string]uint16
+}
+
+func newSymbolTable() *symbolTable {
+	t := &symbolTable{
+		names: make(map[uint16]string),
+		addrs: make(map[string]uint16),
+	}
+	for addr, name := range romSymbols {
+		t.Add(addr, name)
+	}
+	return t
+}
+
+// Add adds a symbol, replacing any symbol with the same address or name.
+func (t *symbolTable) Add(addr uint16, name string) {
+	if old, ok := t.addrs[name]; ok {
+		delete(t.names, old)
+	}
+	if old, ok := t.names[addr]; ok {
+		delete(t.addrs, old)
+	}
+	t.names[addr] = name
+	t.addrs[name] = addr
+}
+
+// Name returns the symbol for an address.
+func (t *symbolTable) Name(addr uint16) (string, bool) {
+	name, ok := t.names[addr]
+	return name, ok
+}
+
+// Format returns the symbol for an address, or the address in hex with
+// the given number of digits if it has none. The table may be nil.
+func (t *symbolTable) Format(addr uint16, digits int) string {
+	if t != nil {
+		if name, ok := t.names[addr]; ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("$%0*X", digits, addr)
+}
+
+// Addr returns the address of a symbol. Symbols are matched without
+// regard to case if there's no exact match.
+func (t *symbolTable) Addr(name string) (uint16, bool) {
+	if addr, ok := t.addrs[name]; ok {
+		return addr, true
+	}
+	for n, addr := range t.addrs {
+		if strings.EqualFold(n, name) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// Sorted returns the addresses of all symbols in ascending order.
+func (t *symbolTable) Sorted() []uint16 {
+	addrs := make([]uint16, 0, len(t.names))
+	for addr := range t.names {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Load reads symbols and returns the number read. It accepts plain symbol
+// files holding an address and a name on each line, and the equates and
+// symbol tables of Merlin and LISA assembly listings:
+//
+//	FDED COUT
+//	COUT EQU $FDED
+//	CH   EPZ $24
+//	COUT =FDED   HOME =FC58
+//	COUT  FDED
+//
+// Lines that don't define a symbol are ignored.
+func (t *symbolTable) Load(r io.Reader) (int, error) {
+	n := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		for _, sym := range parseSymbolLine(s.Text()) {
+			t.Add(sym.addr, sym.name)
+			n++
+		}
+	}
+	return n, s.Err()
+}
+
+// LoadFile reads symbols from a file.
+func (t *symbolTable) LoadFile(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return t.Load(file)
+}
+
+type symbol struct {
+	addr uint16
+	name string
+}
+
+// parseSymbolLine returns the symbols defined on a line of a symbol file
+// or listing.
+func parseSymbolLine(line string) []symbol {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	f := strings.Fields(line)
+	if len(f) == 0 || strings.HasPrefix(f[0], "*") {
+		return nil
+	}
+
+	// Equates: NAME EQU value, NAME = value, NAME =value, NAME=value.
+	var syms []symbol
+	add := func(name, value string, bareHex bool) {
+		name = strings.TrimPrefix(name, "?")
+		addr, ok := parseSymbolValue(value, bareHex)
+		if ok && isSymbolName(name) {
+			syms = append(syms, symbol{addr, name})
+		}
+	}
+	for i := 0; i < len(f); i++ {
+		switch tok := strings.ToUpper(f[i]); {
+		case tok == "EQU" || tok == "EPZ" || tok == "=":
+			if i > 0 && i+1 < len(f) {
+				add(f[i-1], f[i+1], false)
+				i++
+			}
+		case strings.HasPrefix(tok, "="):
+			if i > 0 {
+				add(f[i-1], tok[1:], true)
+			}
+		case strings.Contains(tok, "="):
+			j := strings.IndexByte(tok, '=')
+			add(f[i][:j], f[i][j+1:], true)
+		}
+	}
+	if syms != nil || len(f) != 2 {
+		return syms
+	}
+
+	// Plain symbol files hold ADDR NAME; LISA symbol tables hold NAME ADDR.
+	// A mnemonic and its operand, such as JMP $0300, is neither.
+	if _, ok := parseSymbolValue(f[0], true); ok && isSymbolName(f[1]) {
+		add(f[1], f[0], true)
+	} else if !isMnemonic(f[0]) {
+		add(f[0], f[1], true)
+	}
+	return syms
+}
+
+// parseSymbolValue parses an address in assembler notation: hexadecimal
+// with a $ prefix, binary with a % prefix, or otherwise decimal. If bareHex
+// is true, numbers without a prefix are hexadecimal.
+func parseSymbolValue(s string, bareHex bool) (uint16, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(s, "%"):
+		s, base = s[1:], 2
+	case bareHex:
+		base = 16
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	return uint16(v), err == nil
+}
+
+var mnemonics = strings.Fields(`ADC AND ASL BBR BBS BCC BCS BEQ BIT BMI BNE
+	BPL BRA BRK BVC BVS CLC CLD CLI CLV CMP CPX CPY DEC DEX DEY EOR INC INX INY
+	JMP JSR LDA LDX LDY LSR NOP ORA PHA PHP PHX PHY PLA PLP PLX PLY RMB ROL ROR
+	RTI RTS SBC SEC SED SEI SMB STA STP STX STY STZ TAX TAY TRB TSB TSX TXA TXS
+	TYA WAI`)
+
+// isMnemonic returns true if s is a 6502 or 65C02 instruction mnemonic.
+func isMnemonic(s string) bool {
+	s = strings.ToUpper(s)
+	for _, m := range mnemonics {
+		if s == m {
+			return true
+		}
+	}
+	return false
+}
+
+// isSymbolName returns true if s is a global label. Merlin's local (:) and
+// variable (]) labels aren't symbols.
+func isSymbolName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
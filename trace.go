@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const opcodeBRK = 0x00
+
+// A traceEntry records an executed instruction and the state of the
+// registers before it ran.
+type traceEntry struct {
+	pc      uint16
+	bytes   [3]byte
+	a, x, y byte
+	sp, ps  byte
+	cycle   uint64
+}
+
+// A traceRange is an inclusive range of instruction addresses to trace.
+type traceRange struct {
+	start, end uint16
+}
+
+// A tracer records the instructions the CPU executes. Each instruction
+// can be written to a stream as it executes, kept in a ring buffer of the
+// most recent instructions, or both. The ring buffer is dumped on demand,
+// and optionally whenever the CPU executes a BRK instruction. If address
+// ranges are set, only instructions within them are traced.
+type tracer struct {
+	apple2 *apple2
+
+	w       io.Writer    // stream destination, or nil
+	ranges  []traceRange // addresses traced; all if empty
+	ring    []traceEntry // most recent instructions
+	ringPos int          // index of the next entry written to the ring
+	ringLen int          // number of valid entries in the ring
+	brkDump io.Writer    // destination of ring dumps on BRK, or nil
+}
+
+func newTracer(apple2 *apple2) *tracer {
+	return &tracer{apple2: apple2}
+}
+
+// EnableTrace returns the machine's tracer, creating it if necessary.
+// Tracing slows emulation, so the tracer should be disabled when it is no
+// longer needed.
+func (a *apple2) EnableTrace() *tracer {
+	if a.trace == nil {
+		a.trace = newTracer(a)
+	}
+	return a.trace
+}
+
+// DisableTrace stops tracing instructions.
+func (a *apple2) DisableTrace() {
+	a.trace = nil
+}
+
+// SetOutput sets the stream to which each traced instruction is written,
+// or stops streaming if w is nil.
+func (t *tracer) SetOutput(w io.Writer) {
+	t.w = w
+}
+
+// SetRingSize sets the number of recent instructions kept in the ring
+// buffer, discarding its contents. A size of 0 disables the ring buffer.
+func (t *tracer) SetRingSize(n int) {
+	t.ring = make([]traceEntry, n)
+	t.ringPos, t.ringLen = 0, 0
+}
+
+// DumpOnBRK sets the writer to which the ring buffer is dumped whenever
+// the CPU executes a BRK instruction, or disables dumping if w is nil.
+func (t *tracer) DumpOnBRK(w io.Writer) {
+	t.brkDump = w
+}
+
+// AddRange limits tracing to instructions in the address range [start,
+// end], along with any other ranges added.
+func (t *tracer) AddRange(start, end uint16) {
+	t.ranges = append(t.ranges, traceRange{start, end})
+}
+
+// ClearRanges removes the address ranges, so that all instructions are
+// traced.
+func (t *tracer) ClearRanges() {
+	t.ranges = t.ranges[:0]
+}
+
+// Dump writes the last n instructions in the ring buffer, oldest first.
+// If n is 0 or exceeds the number of instructions held, the whole buffer
+// is written.
+func (t *tracer) Dump(w io.Writer, n int) {
+	if n <= 0 || n > t.ringLen {
+		n = t.ringLen
+	}
+	for i := n; i > 0; i-- {
+		j := (t.ringPos - i + len(t.ring)) % len(t.ring)
+		fmt.Fprintln(w, t.format(&t.ring[j]))
+	}
+}
+
+// step is called before the CPU executes each instruction.
+func (t *tracer) step() {
+	cpu := t.apple2.cpu
+	pc := cpu.Reg.PC
+	if !t.traced(pc) {
+		return
+	}
+
+	m := t.apple2.mmu
+	e := traceEntry{
+		pc:    pc,
+		bytes: [3]byte{m.Peek(pc), m.Peek(pc + 1), m.Peek(pc + 2)},
+		a:     cpu.Reg.A,
+		x:     cpu.Reg.X,
+		y:     cpu.Reg.Y,
+		sp:    cpu.Reg.SP,
+		ps:    cpu.Reg.SavePS(false),
+		cycle: cpu.Cycles,
+	}
+	if t.w != nil {
+		fmt.Fprintln(t.w, t.format(&e))
+	}
+	if len(t.ring) > 0 {
+		t.ring[t.ringPos] = e
+		t.ringPos = (t.ringPos + 1) % len(t.ring)
+		if t.ringLen < len(t.ring) {
+			t.ringLen++
+		}
+		if e.bytes[0] == opcodeBRK && t.brkDump != nil {
+			fmt.Fprintf(t.brkDump, "BRK at %04X; last %d instructions:\n", pc, t.ringLen)
+			t.Dump(t.brkDump, 0)
+		}
+	}
+}
+
+func (t *tracer) traced(pc uint16) bool {
+	if len(t.ranges) == 0 {
+		return true
+	}
+	for _, r := range t.ranges {
+		if pc >= r.start && pc <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// format returns a line describing a traced instruction. Symbols are
+// shown if a debugger is attached.
+func (t *tracer) format(e *traceEntry) string {
+	var syms *symbolTable
+	if d := t.apple2.dbg; d != nil {
+		syms = d.symbols
+	}
+	inst := t.apple2.cpu.InstSet.Lookup(e.bytes[0])
+	return fmt.Sprintf("%-36s A=%02X X=%02X Y=%02X SP=%02X P=%02X CYC=%d",
+		formatInstruction(inst, e.pc, e.bytes, syms), e.a, e.x, e.y, e.sp, e.ps, e.cycle)
+}
+
+// parseTraceRanges parses a comma-separated list of hexadecimal addresses
+// and address ranges, such as "0300-03FF,C600".
+func parseTraceRanges(s string) ([]traceRange, error) {
+	var ranges []traceRange
+	for _, f := range strings.Split(s, ",") {
+		start, end := f, f
+		if i := strings.IndexByte(f, '-'); i >= 0 {
+			start, end = f[:i], f[i+1:]
+		}
+		s, err := parseDebugValue(strings.TrimSpace(start), 0xffff)
+		if err != nil {
+			return nil, err
+		}
+		e, err := parseDebugValue(strings.TrimSpace(end), 0xffff)
+		if err != nil {
+			return nil, err
+		}
+		if e < s {
+			return nil, fmt.Errorf("invalid range %q", f)
+		}
+		ranges = append(ranges, traceRange{uint16(s), uint16(e)})
+	}
+	return ranges, nil
+}
+
+// TraceToFile streams traced instructions to a file. The returned
+// function stops streaming and closes the file.
+func (a *apple2) TraceToFile(filename string) (stop func() error, err error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(file)
+	t := a.EnableTrace()
+	t.SetOutput(bw)
+	stop = func() error {
+		t.SetOutput(nil)
+		err := bw.Flush()
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return stop, nil
+}
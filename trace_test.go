@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0xa2, 0x00, //       0300: LDX #$00
+		0x20, 0x10, 0x03, // 0302: JSR $0310
+		0x00, //             0305: BRK
+	})
+	a.mmu.StoreBytes(0x0310, []byte{
+		0xe8, // 0310: INX
+		0x60, // 0311: RTS
+	})
+	a.cpu.SetPC(0x0300)
+
+	var stream, brk bytes.Buffer
+	tr := a.EnableTrace()
+	tr.SetOutput(&stream)
+	tr.SetRingSize(3)
+	tr.DumpOnBRK(&brk)
+	for a.cpu.Reg.PC != 0x0306 {
+		tr.step()
+		a.cpu.Step()
+	}
+
+	lines := strings.Split(strings.TrimSpace(stream.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 traced instructions, got %d\n", len(lines))
+	}
+	if !strings.HasPrefix(lines[2], "0310- E8        INX") || !strings.Contains(lines[2], "A=00 X=00 Y=00 SP=FD") {
+		t.Errorf("Unexpected trace line %q\n", lines[2])
+	}
+
+	// The BRK dumps the last three instructions, including itself.
+	dump := strings.Split(strings.TrimSpace(brk.String()), "\n")
+	if len(dump) != 4 || dump[0] != "BRK at 0305; last 3 instructions:" ||
+		!strings.HasPrefix(dump[1], "0310-") || !strings.HasPrefix(dump[3], "0305-") {
+		t.Errorf("Unexpected BRK dump:\n%s", brk.String())
+	}
+
+	// Only instructions in the filtered range are traced.
+	stream.Reset()
+	tr.AddRange(0x0310, 0x031f)
+	a.cpu.SetPC(0x0300)
+	for a.cpu.Reg.PC != 0x0305 {
+		tr.step()
+		a.cpu.Step()
+	}
+	if n := strings.Count(stream.String(), "\n"); n != 2 {
+		t.Errorf("Expected 2 filtered instructions, got %d\n", n)
+	}
+
+	var out bytes.Buffer
+	tr.Dump(&out, 1)
+	if !strings.HasPrefix(out.String(), "0311- 60        RTS") {
+		t.Errorf("Unexpected dump %q\n", out.String())
+	}
+}
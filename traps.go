@@ -0,0 +1,100 @@
+package main
+
+import "github.com/beevik/go6502/cpu"
+
+// A trapFunc handles a trap. It's called before the CPU executes the
+// instruction at pc, and returns true to end the frame there. The
+// instruction then runs when the machine next runs, without trapping
+// again. A trapFunc may change the registers, as to return from a
+// routine it runs in Go, in which case the traps at the new PC apply in
+// turn.
+type trapFunc func(pc uint16) (stop bool)
+
+// A trapSet holds the Go functions called when the CPU executes a BRK or
+// an illegal opcode, or enters an address, so that test harnesses can
+// tell when a program finishes or fails, as by entering the monitor at
+// $FF69.
+type trapSet struct {
+	apple2  *apple2
+	brk     trapFunc
+	illegal trapFunc
+	addrs   map[uint16]trapFunc
+	resume  bool // true = the last trap ended the frame
+}
+
+// enableTraps returns the machine's traps, creating them if necessary.
+func (a *apple2) enableTraps() *trapSet {
+	if a.traps == nil {
+		a.traps = &trapSet{apple2: a, addrs: make(map[uint16]trapFunc)}
+	}
+	return a.traps
+}
+
+// TrapBRK sets the function called when the CPU executes a BRK, or with
+// fn nil, removes it.
+func (a *apple2) TrapBRK(fn trapFunc) {
+	a.enableTraps().brk = fn
+}
+
+// TrapIllegal sets the function called when the CPU executes an opcode
+// its instruction set doesn't define, such as the NMOS 6502's JAM
+// opcodes that halt a real CPU, or with fn nil, removes it.
+func (a *apple2) TrapIllegal(fn trapFunc) {
+	a.enableTraps().illegal = fn
+}
+
+// TrapAddr sets the function called when the CPU is about to execute the
+// instruction at addr, or with fn nil, removes it.
+func (a *apple2) TrapAddr(addr uint16, fn trapFunc) {
+	t := a.enableTraps()
+	if fn == nil {
+		delete(t.addrs, addr)
+	} else {
+		t.addrs[addr] = fn
+	}
+}
+
+// ClearTraps removes all traps.
+func (a *apple2) ClearTraps() {
+	a.traps = nil
+}
+
+// illegalOpcode returns true if an instruction isn't defined by the
+// CPU's instruction set.
+func illegalOpcode(inst *cpu.Instruction) bool {
+	return inst.Name == "???"
+}
+
+// check calls the functions of the traps on the instruction at the PC,
+// and returns true if one of them ends the frame.
+func (t *trapSet) check() bool {
+	if t.resume {
+		t.resume = false
+		return false
+	}
+
+	c := t.apple2.cpu
+	for {
+		pc := c.Reg.PC
+		stop := false
+		if fn := t.addrs[pc]; fn != nil {
+			stop = fn(pc)
+		}
+		if t.brk != nil || t.illegal != nil {
+			inst := c.InstSet.Lookup(t.apple2.mmu.Peek(pc))
+			switch {
+			case inst.Opcode == opcodeBRK && t.brk != nil:
+				stop = t.brk(pc) || stop
+			case illegalOpcode(inst) && t.illegal != nil:
+				stop = t.illegal(pc) || stop
+			}
+		}
+		if stop {
+			t.resume = true
+			return true
+		}
+		if c.Reg.PC == pc {
+			return false
+		}
+	}
+}
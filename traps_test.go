@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestTraps(t *testing.T) {
+	a := newApple2Model(modelIIe)
+	a.mmu.StoreBytes(0x300, []byte{
+		0xea,             // NOP
+		0x20, 0x10, 0x03, // JSR $0310
+		0x02, // JAM
+		0x00, // BRK
+	})
+	a.mmu.StoreByte(0x310, 0x60) // RTS
+	a.cpu.SetPC(0x300)
+
+	var hits []uint16
+	trap := func(stop bool) trapFunc {
+		return func(pc uint16) bool {
+			hits = append(hits, pc)
+			return stop
+		}
+	}
+	a.TrapAddr(0x310, trap(true))
+	a.TrapIllegal(trap(false))
+	a.TrapBRK(trap(true))
+
+	// The frame ends at the routine's entry, and resumes from it.
+	a.RunFrame()
+	if len(hits) != 1 || a.cpu.Reg.PC != 0x310 {
+		t.Fatalf("Expected to stop at 0310, stopped at %04X with %04X\n", a.cpu.Reg.PC, hits)
+	}
+	a.RunFrame()
+	if len(hits) != 3 || hits[1] != 0x304 || hits[2] != 0x305 || a.cpu.Reg.PC != 0x305 {
+		t.Fatalf("Expected to stop at the BRK, stopped at %04X with %04X\n", a.cpu.Reg.PC, hits)
+	}
+
+	a.ClearTraps()
+	a.RunFrame()
+	if len(hits) != 3 {
+		t.Errorf("Expected no traps, got %04X\n", hits)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+)
+
+// The terminal front end runs the machine in a host terminal, such as
+// over SSH. It draws the text screen with the terminal's characters, and
+// graphics with half-block characters whose foreground and background
+// colors are those of the upper and lower halves of each character cell,
+// so that a text row shows two rows of lo-res blocks. Keys typed in the
+// terminal are typed on the Apple II keyboard.
+//
+// Terminals report characters rather than key presses and releases, so
+// each key is pressed and released at once, along with the modifiers
+// that produce its character.
+
+// tuiQuitKey is the terminal character that quits the front end: Ctrl-],
+// which Apple II software rarely uses.
+const tuiQuitKey = 0x1d
+
+// A terminalKey is a host key with the modifiers held while it's typed.
+type terminalKey struct {
+	key       hostKey
+	shift     bool
+	ctrl      bool
+	openApple bool
+}
+
+// terminalChars maps the printable characters a terminal sends to the
+// host keys that type them.
+var terminalChars = func() map[byte]terminalKey {
+	m := make(map[byte]terminalKey)
+	for k, codes := range hostKeyCodes {
+		for i, c := range codes {
+			if c >= 0x20 && c < 0x7f {
+				m[c] = terminalKey{key: k, shift: i == 1}
+			}
+		}
+	}
+	for c := byte('A'); c <= 'Z'; c++ {
+		m[c] = terminalKey{key: hostKeyLetter(c), shift: true}
+		m[c+0x20] = terminalKey{key: hostKeyLetter(c)}
+	}
+	return m
+}()
+
+// terminalSequences maps the escape sequences of special keys, after
+// their ESC, to host keys.
+var terminalSequences = map[string]terminalKey{
+	"[A":   {key: hostKeyUp},
+	"[B":   {key: hostKeyDown},
+	"[C":   {key: hostKeyRight},
+	"[D":   {key: hostKeyLeft},
+	"OA":   {key: hostKeyUp},
+	"OB":   {key: hostKeyDown},
+	"OC":   {key: hostKeyRight},
+	"OD":   {key: hostKeyLeft},
+	"[3~":  {key: hostKeyDelete},
+	"[23~": {key: swapDisksHotkey},
+	"[24~": {key: resetHotkey, ctrl: true},
+}
+
+// parseTerminalKeys converts the bytes read from a terminal into keys. An
+// ESC followed by a character, as terminals send for Alt or Meta and a
+// key, types the key with open-Apple held. It returns false if the quit
+// key was typed, ignoring the bytes that follow it.
+func parseTerminalKeys(b []byte) ([]terminalKey, bool) {
+	var keys []terminalKey
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == tuiQuitKey:
+			return keys, false
+		case c == 0x1b && i+1 < len(b) && (b[i+1] == '[' || b[i+1] == 'O'):
+			// A control sequence ends with a byte in the range @..~.
+			j := i + 2
+			for j < len(b) && (b[j] < 0x40 || b[j] > 0x7e) {
+				j++
+			}
+			if j == len(b) {
+				j--
+			}
+			if k, ok := terminalSequences[string(b[i+1:j+1])]; ok {
+				keys = append(keys, k)
+			}
+			i = j
+		case c == 0x1b && i+1 < len(b):
+			ks, _ := parseTerminalKeys(b[i+1 : i+2])
+			for _, k := range ks {
+				k.openApple = true
+				keys = append(keys, k)
+			}
+			i++
+		case c == 0x1b:
+			keys = append(keys, terminalKey{key: hostKeyEscape})
+		case c == '\r' || c == '\n':
+			keys = append(keys, terminalKey{key: hostKeyReturn})
+		case c == '\t':
+			keys = append(keys, terminalKey{key: hostKeyTab})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, terminalKey{key: hostKeyBackspace})
+		case c < 0x20:
+			if k, ok := terminalChars[c|0x40]; ok {
+				k.ctrl = true
+				keys = append(keys, k)
+			}
+		default:
+			if k, ok := terminalChars[c]; ok {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, true
+}
+
+// typeKey presses and releases a key typed in the terminal, along with
+// its modifiers. It is safe to call from any goroutine.
+func (a *apple2) typeKey(k terminalKey) {
+	var mods []hostKey
+	if k.shift {
+		mods = append(mods, hostKeyLeftShift)
+	}
+	if k.ctrl {
+		mods = append(mods, hostKeyLeftCtrl)
+	}
+	if k.openApple {
+		mods = append(mods, a.kb.openApple)
+	}
+	for _, m := range mods {
+		a.im.KeyEvent(m, true)
+	}
+	a.im.KeyEvent(k.key, true)
+	a.im.KeyEvent(k.key, false)
+	for _, m := range mods {
+		a.im.KeyEvent(m, false)
+	}
+}
+
+// runTUI runs the machine in the terminal whose input is in and output
+// is out, for a number of frames, or if frames is 0, until the quit key
+// is typed.
+func (a *apple2) runTUI(in *os.File, out io.Writer, frames int) error {
+	restore, err := makeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// Draw on the alternate screen, so that the terminal's contents
+	// come back afterward, with the cursor hidden.
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer io.WriteString(out, "\x1b[0m\x1b[?25h\x1b[?1049l")
+
+	input := make(chan []byte, 16)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				close(input)
+				return
+			}
+			input <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	var lights *driveLights
+	if d, err := a.diskController(); err == nil {
+		lights = &driveLights{}
+		d.AddObserver(lights)
+		defer d.RemoveObserver(lights)
+	}
+
+	end := a.cpu.Cycles + uint64(frames)*cyclesPerFrame
+	shown := ""
+	for frames == 0 || a.cpu.Cycles < end {
+		select {
+		case b, ok := <-input:
+			keys, more := parseTerminalKeys(b)
+			for _, k := range keys {
+				a.typeKey(k)
+			}
+			if !ok || !more {
+				return nil
+			}
+		default:
+		}
+
+		a.RunPaced()
+		status := "Ctrl-] quits, F12 resets"
+		if lights != nil {
+			status = lights.String() + "   " + status
+		}
+		if s := a.tuiScreen(status); s != shown {
+			io.WriteString(out, s)
+			shown = s
+		}
+	}
+	return nil
+}
+
+// tuiScreen returns the escape sequences and characters that draw the
+// screen, followed by a status line, from the top left of the terminal.
+func (a *apple2) tuiScreen(status string) string {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	first := a.ds.firstTextRow()
+	for row := 0; row < textRows; row++ {
+		if row < first {
+			a.tuiGraphicsRow(&b, row)
+		} else {
+			a.tuiTextRow(&b, row)
+		}
+		b.WriteString("\x1b[0m\x1b[K\r\n")
+	}
+	b.WriteString(status)
+	b.WriteString("\x1b[K")
+	return b.String()
+}
+
+// tuiTextRow draws a row of 40- or 80-column text. Inverse characters,
+// and flashing ones while they're inverse, are drawn in reverse video.
+// MouseText glyphs have no terminal equivalent and are drawn as shading.
+func (a *apple2) tuiTextRow(b *strings.Builder, row int) {
+	d := a.ds
+	addr := textRowAddress(d.textPage(), row)
+	main := a.mmu.mainRAM[addr : addr+40]
+	aux := a.mmu.AuxVideoRAM()[addr : addr+40]
+	flash := d.flashPhase()
+	col80 := d.TextColumns() == 80
+
+	inverse := false
+	put := func(code byte) {
+		ch, attr := d.TextGlyph(code)
+		inv := attr == textInverse || attr == textFlash && flash
+		switch {
+		case inv && !inverse:
+			b.WriteString("\x1b[7m")
+		case !inv && inverse:
+			b.WriteString("\x1b[27m")
+		}
+		inverse = inv
+		if attr == textMouse {
+			b.WriteRune('▒')
+			return
+		}
+		b.WriteByte(ch)
+	}
+	for c := 0; c < 40; c++ {
+		if col80 {
+			put(aux[c])
+		}
+		put(main[c])
+	}
+}
+
+// tuiGraphicsRow draws the graphics of a text row as 40 half-block
+// characters. Each half takes the color of the center of the 14 by 4 dot
+// area of the rendered frame it covers: exactly a lo-res block, and an
+// approximation of hi-res graphics.
+func (a *apple2) tuiGraphicsRow(b *strings.Builder, row int) {
+	fb := a.ds.Framebuffer()
+	var fg, bg color.RGBA
+	for c := 0; c < 40; c++ {
+		top := fb.RGBAAt(c*14+7, row*8+2)
+		bottom := fb.RGBAAt(c*14+7, row*8+6)
+		if c == 0 || top != fg {
+			fmt.Fprintf(b, "\x1b[38;2;%d;%d;%dm", top.R, top.G, top.B)
+			fg = top
+		}
+		if c == 0 || bottom != bg {
+			fmt.Fprintf(b, "\x1b[48;2;%d;%d;%dm", bottom.R, bottom.G, bottom.B)
+			bg = bottom
+		}
+		b.WriteRune('▀')
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTerminalKeys(t *testing.T) {
+	keys, more := parseTerminalKeys([]byte("aB!\r\x03\x1b[A\x1b[24~\x1bx\x7f\x1b"))
+	want := []terminalKey{
+		{key: hostKeyA},
+		{key: hostKeyLetter('B'), shift: true},
+		{key: hostKey1, shift: true},
+		{key: hostKeyReturn},
+		{key: hostKeyLetter('C'), shift: true, ctrl: true},
+		{key: hostKeyUp},
+		{key: resetHotkey, ctrl: true},
+		{key: hostKeyLetter('X'), openApple: true},
+		{key: hostKeyBackspace},
+		{key: hostKeyEscape},
+	}
+	if !more || !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected keys\n%v\ngot\n%v\n", want, keys)
+	}
+	if keys, more := parseTerminalKeys([]byte("1\x1d2")); more || len(keys) != 1 {
+		t.Errorf("Expected one key before quitting, got %v %v\n", keys, more)
+	}
+}
+
+func TestTUI(t *testing.T) {
+	a := newApple2()
+
+	// Typed keys reach the keyboard with their modifiers.
+	for _, k := range []terminalKey{{key: hostKeyLetter('Q')}, {key: hostKeyLetter('B'), ctrl: true}} {
+		a.typeKey(k)
+		a.kb.Update()
+		a.kb.Update()
+		a.kb.ResetKeyStrobe()
+	}
+	if v := a.kb.GetKeyData(); v != 0x02 {
+		t.Errorf("Expected key $02, got $%02X\n", v)
+	}
+
+	// Text is drawn as characters, inverse in reverse video.
+	a.mmu.LoadByte(0xc051) // TEXT
+	copy(a.mmu.mainRAM[0x0400:], []byte{0xc8, 0xc9, 0x01, 0xa0})
+	a.ds.Render()
+	screen := a.tuiScreen("status")
+	if !strings.HasPrefix(screen, "\x1b[HHI\x1b[7mA\x1b[27m ") || !strings.HasSuffix(screen, "\r\nstatus\x1b[K") {
+		t.Errorf("Unexpected text screen %q\n", screen[:40])
+	}
+
+	// Lo-res blocks are drawn as half blocks, two to a row.
+	a.mmu.LoadByte(0xc050) // GR
+	a.mmu.mainRAM[0x0400] = 0xd1
+	a.ds.Render()
+	screen = a.tuiScreen("")
+	if !strings.HasPrefix(screen, "\x1b[H\x1b[38;2;221;0;51m\x1b[48;2;255;255;0m▀") {
+		t.Errorf("Unexpected lo-res screen %q\n", screen[:40])
+	}
+}
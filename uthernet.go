@@ -0,0 +1,58 @@
+package main
+
+// An uthernetCard emulates the Uthernet II network card, a W5100 whose
+// indirect bus interface appears at offsets 4..7 of the slot I/O space:
+// the mode register, the high and low bytes of the address register, and
+// the data port. The card has no firmware.
+type uthernetCard struct {
+	apple2 *apple2
+	w5100  *w5100
+}
+
+func newUthernetCard(apple2 *apple2) *uthernetCard {
+	return &uthernetCard{
+		apple2: apple2,
+		w5100:  newW5100(apple2),
+	}
+}
+
+func (c *uthernetCard) LoadIO(addr uint16) byte {
+	switch addr {
+	case 4:
+		return c.w5100.LoadMode()
+	case 5:
+		return c.w5100.LoadAddr(0)
+	case 6:
+		return c.w5100.LoadAddr(1)
+	case 7:
+		return c.w5100.LoadData()
+	}
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *uthernetCard) StoreIO(addr uint16, v byte) {
+	switch addr {
+	case 4:
+		c.w5100.StoreMode(v)
+	case 5:
+		c.w5100.StoreAddr(0, v)
+	case 6:
+		c.w5100.StoreAddr(1, v)
+	case 7:
+		c.w5100.StoreData(v)
+	}
+}
+
+func (c *uthernetCard) LoadROM(addr uint16) byte {
+	return c.apple2.vs.FloatingBus()
+}
+
+func (c *uthernetCard) StoreROM(addr uint16, v byte) {
+	// Do nothing
+}
+
+// Close closes the card's host connections.
+func (c *uthernetCard) Close() error {
+	c.w5100.Reset()
+	return nil
+}
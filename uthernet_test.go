@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// w5100Port drives a W5100 through the Uthernet II's I/O registers in
+// slot 3.
+type w5100Port struct {
+	t *testing.T
+	a *apple2
+}
+
+func (p w5100Port) seek(addr uint16) {
+	p.a.mmu.StoreByte(0xc0b5, byte(addr>>8))
+	p.a.mmu.StoreByte(0xc0b6, byte(addr))
+}
+
+func (p w5100Port) write(addr uint16, data ...byte) {
+	p.seek(addr)
+	for _, b := range data {
+		p.a.mmu.StoreByte(0xc0b7, b)
+	}
+}
+
+func (p w5100Port) read(addr uint16, n int) []byte {
+	p.seek(addr)
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = p.a.mmu.LoadByte(0xc0b7)
+	}
+	return data
+}
+
+func (p w5100Port) read16(addr uint16) uint16 {
+	b := p.read(addr, 2)
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// wait polls a socket register until it has a value.
+func (p w5100Port) wait(addr uint16, mask, want byte) {
+	p.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for p.read(addr, 1)[0]&mask != want {
+		if time.Now().After(deadline) {
+			p.t.Fatalf("Timed out waiting for %02X at %04X, got %02X\n", want, addr, p.read(addr, 1)[0])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// send copies data to socket 0's TX buffer and sends it.
+func (p w5100Port) send(data []byte) {
+	wr := p.read16(0x0424)
+	p.write(w5100TXBase+wr&0x7ff, data...)
+	wr += uint16(len(data))
+	p.write(0x0424, byte(wr>>8), byte(wr))
+	p.write(0x0401, w5100CmdSend)
+}
+
+// recv reads all data received by socket 0 and acknowledges it.
+func (p w5100Port) recv() []byte {
+	size := p.read16(0x0426)
+	rd := p.read16(0x0428)
+	data := p.read(w5100RXBase+rd&0x7ff, int(size))
+	rd += size
+	p.write(0x0428, byte(rd>>8), byte(rd))
+	p.write(0x0401, w5100CmdRecv)
+	return data
+}
+
+func TestUthernetTCP(t *testing.T) {
+	a := newApple2()
+	c := newUthernetCard(a)
+	a.sm.Insert(3, c)
+	defer c.Close()
+	p := w5100Port{t, a}
+
+	p.a.mmu.StoreByte(0xc0b4, w5100ModeAI)
+	if v := p.read(w5100RMSR, 2); v[0] != 0x55 || v[1] != 0x55 {
+		t.Errorf("Expected default memory sizes, got % X\n", v)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	p.write(0x0400, w5100ProtoTCP)
+	p.write(0x0401, w5100CmdOpen)
+	p.wait(0x0403, 0xff, w5100StatusInit)
+	p.write(0x040c, 127, 0, 0, 1, byte(port>>8), byte(port))
+	p.write(0x0401, w5100CmdConnect)
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	p.wait(0x0403, 0xff, w5100StatusEstablished)
+	p.wait(0x0402, w5100IntCon, w5100IntCon)
+
+	if free := p.read16(0x0420); free != 2048 {
+		t.Errorf("Expected 2048 bytes free, got %d\n", free)
+	}
+	p.send([]byte("GET"))
+	buf := make([]byte, 3)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := server.Read(buf); err != nil || string(buf) != "GET" {
+		t.Errorf("Expected GET from the socket, got %q (%v)\n", buf, err)
+	}
+
+	server.Write([]byte("OK"))
+	p.wait(0x0402, w5100IntRecv, w5100IntRecv)
+	if data := p.recv(); string(data) != "OK" {
+		t.Errorf("Expected OK, got %q\n", data)
+	}
+	if size := p.read16(0x0426); size != 0 {
+		t.Errorf("Expected no data after RECV, got %d bytes\n", size)
+	}
+
+	server.Close()
+	p.wait(0x0403, 0xff, w5100StatusCloseWait)
+	p.write(0x0401, w5100CmdDiscon)
+	p.wait(0x0403, 0xff, w5100StatusClosed)
+}
+
+func TestUthernetUDP(t *testing.T) {
+	a := newApple2()
+	c := newUthernetCard(a)
+	a.sm.Insert(3, c)
+	defer c.Close()
+	p := w5100Port{t, a}
+	p.a.mmu.StoreByte(0xc0b4, w5100ModeAI)
+
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	port := peer.LocalAddr().(*net.UDPAddr).Port
+
+	p.write(0x0400, w5100ProtoUDP)
+	p.write(0x0404, 0, 0)
+	p.write(0x0401, w5100CmdOpen)
+	p.wait(0x0403, 0xff, w5100StatusUDP)
+	p.write(0x040c, 127, 0, 0, 1, byte(port>>8), byte(port))
+	p.send([]byte("ping"))
+
+	buf := make([]byte, 16)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := peer.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("Expected ping, got %q (%v)\n", buf[:n], err)
+	}
+	peer.WriteToUDP([]byte("pong"), from)
+	p.wait(0x0402, w5100IntRecv, w5100IntRecv)
+	data := p.recv()
+	want := []byte{127, 0, 0, 1, byte(port >> 8), byte(port), 0, 4, 'p', 'o', 'n', 'g'}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Expected % X, got % X\n", want, data)
+	}
+}
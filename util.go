@@ -3,3 +3,30 @@ package main
 func bitTest16(v, mask uint16) bool {
 	return (v & mask) != 0
 }
+
+func bit7(b bool) byte {
+	if b {
+		return 0x80
+	}
+	return 0
+}
+
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
+
+func clamp(v, lo, hi int) int {
+	switch {
+	case v < lo:
+		return lo
+	case v > hi:
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,315 @@
+package main
+
+// VIA interrupt flag bits.
+const (
+	viaIntCA2 byte = 1 << iota
+	viaIntCA1
+	viaIntSR
+	viaIntCB2
+	viaIntCB1
+	viaIntT2
+	viaIntT1
+	viaIntAny
+)
+
+// VIA register offsets.
+const (
+	viaORB  = 0x0 // output/input register B
+	viaORA  = 0x1 // output/input register A
+	viaDDRB = 0x2 // data direction register B
+	viaDDRA = 0x3 // data direction register A
+	viaT1CL = 0x4 // timer 1 counter low
+	viaT1CH = 0x5 // timer 1 counter high
+	viaT1LL = 0x6 // timer 1 latch low
+	viaT1LH = 0x7 // timer 1 latch high
+	viaT2CL = 0x8 // timer 2 counter low
+	viaT2CH = 0x9 // timer 2 counter high
+	viaSR   = 0xa // shift register
+	viaACR  = 0xb // auxiliary control register
+	viaPCR  = 0xc // peripheral control register
+	viaIFR  = 0xd // interrupt flag register
+	viaIER  = 0xe // interrupt enable register
+	viaORAN = 0xf // output/input register A without handshake
+)
+
+// A viaPeripheral is a device connected to the I/O ports of a 6522. Values
+// written to the ports include only the bits configured as outputs; input
+// bits read high.
+type viaPeripheral interface {
+	ReadPortA() byte
+	ReadPortB() byte
+	WritePortA(v byte)
+	WritePortB(v byte)
+}
+
+// A via6522 emulates the MOS 6522 Versatile Interface Adapter's ports,
+// timers, and interrupt logic. Timers are brought up to date whenever the
+// chip is accessed, and an event is scheduled for the next timer
+// interrupt so that its flag is raised on time.
+type via6522 struct {
+	sched *scheduler
+	dev   viaPeripheral // device attached to the ports
+	timer *schedEvent   // pending timer interrupt event, if any
+	onIRQ func()        // called when the IRQ output changes, if set
+	irq   bool          // state of the IRQ output
+
+	orb, ora   byte
+	ddrb, ddra byte
+	t1c, t1l   uint16 // timer 1 counter and latch
+	t1Armed    bool   // timer 1 interrupt pending in one-shot mode
+	t2c        uint16 // timer 2 counter
+	t2ll       byte   // timer 2 latch low
+	t2Armed    bool   // timer 2 interrupt pending
+	sr         byte
+	acr        byte
+	pcr        byte
+	ifr        byte
+	ier        byte
+	last       uint64 // CPU cycle of the last timer update
+}
+
+func newVIA6522(sched *scheduler, dev viaPeripheral) *via6522 {
+	v := &via6522{sched: sched, dev: dev}
+	v.Reset()
+	return v
+}
+
+// Reset clears all registers, as happens when the RESET line is asserted.
+func (v *via6522) Reset() {
+	v.sched.Cancel(v.timer)
+	*v = via6522{sched: v.sched, dev: v.dev, onIRQ: v.onIRQ, irq: v.irq, last: v.sched.Now()}
+	v.dev.WritePortA(0xff)
+	v.dev.WritePortB(0xff)
+	v.updateIRQ()
+}
+
+// IRQ returns true if the VIA is asserting its interrupt request line.
+func (v *via6522) IRQ() bool {
+	v.sync()
+	return v.irq
+}
+
+// updateIRQ updates the IRQ output after a change to the interrupt flag or
+// enable registers.
+func (v *via6522) updateIRQ() {
+	irq := (v.ifr & v.ier & 0x7f) != 0
+	if irq != v.irq {
+		v.irq = irq
+		if v.onIRQ != nil {
+			v.onIRQ()
+		}
+	}
+}
+
+func (v *via6522) LoadByte(reg uint16) byte {
+	v.sync()
+
+	switch reg {
+	case viaORB:
+		return (v.orb & v.ddrb) | (v.dev.ReadPortB() &^ v.ddrb)
+	case viaORA:
+		v.ifr &^= viaIntCA1 | viaIntCA2
+		v.updateIRQ()
+		return (v.ora & v.ddra) | (v.dev.ReadPortA() &^ v.ddra)
+	case viaORAN:
+		return (v.ora & v.ddra) | (v.dev.ReadPortA() &^ v.ddra)
+	case viaDDRB:
+		return v.ddrb
+	case viaDDRA:
+		return v.ddra
+	case viaT1CL:
+		v.ifr &^= viaIntT1
+		v.updateIRQ()
+		return byte(v.t1c)
+	case viaT1CH:
+		return byte(v.t1c >> 8)
+	case viaT1LL:
+		return byte(v.t1l)
+	case viaT1LH:
+		return byte(v.t1l >> 8)
+	case viaT2CL:
+		v.ifr &^= viaIntT2
+		v.updateIRQ()
+		return byte(v.t2c)
+	case viaT2CH:
+		return byte(v.t2c >> 8)
+	case viaSR:
+		return v.sr
+	case viaACR:
+		return v.acr
+	case viaPCR:
+		return v.pcr
+	case viaIFR:
+		if (v.ifr & v.ier & 0x7f) != 0 {
+			return v.ifr | viaIntAny
+		}
+		return v.ifr
+	case viaIER:
+		return v.ier | 0x80
+	}
+	return 0
+}
+
+func (v *via6522) StoreByte(reg uint16, b byte) {
+	v.sync()
+
+	switch reg {
+	case viaORB:
+		v.orb = b
+		v.dev.WritePortB(v.orb | ^v.ddrb)
+	case viaORA, viaORAN:
+		if reg == viaORA {
+			v.ifr &^= viaIntCA1 | viaIntCA2
+		}
+		v.ora = b
+		v.dev.WritePortA(v.ora | ^v.ddra)
+	case viaDDRB:
+		v.ddrb = b
+		v.dev.WritePortB(v.orb | ^v.ddrb)
+	case viaDDRA:
+		v.ddra = b
+		v.dev.WritePortA(v.ora | ^v.ddra)
+	case viaT1CL, viaT1LL:
+		v.t1l = v.t1l&0xff00 | uint16(b)
+	case viaT1CH:
+		v.t1l = v.t1l&0x00ff | uint16(b)<<8
+		v.t1c = v.t1l
+		v.t1Armed = true
+		v.ifr &^= viaIntT1
+	case viaT1LH:
+		v.t1l = v.t1l&0x00ff | uint16(b)<<8
+		v.ifr &^= viaIntT1
+	case viaT2CL:
+		v.t2ll = b
+	case viaT2CH:
+		v.t2c = uint16(b)<<8 | uint16(v.t2ll)
+		v.t2Armed = true
+		v.ifr &^= viaIntT2
+	case viaSR:
+		v.sr = b
+	case viaACR:
+		v.acr = b
+	case viaPCR:
+		v.pcr = b
+	case viaIFR:
+		v.ifr &^= b & 0x7f
+	case viaIER:
+		if (b & 0x80) != 0 {
+			v.ier |= b & 0x7f
+		} else {
+			v.ier &^= b & 0x7f
+		}
+	}
+	if (reg >= viaT1CL && reg <= viaT2CH) || reg == viaACR {
+		v.schedule()
+	}
+	v.updateIRQ()
+}
+
+// schedule replaces the pending timer event with one for the next timer
+// interrupt, if any.
+func (v *via6522) schedule() {
+	v.sched.Cancel(v.timer)
+	v.timer = nil
+
+	// A timer interrupts when it counts past zero.
+	var next uint64
+	if v.t1Armed || (v.acr&0x40) != 0 {
+		next = uint64(v.t1c) + 1
+	}
+	if t2 := uint64(v.t2c) + 1; v.t2Armed && (next == 0 || t2 < next) {
+		next = t2
+	}
+	if next != 0 {
+		v.timer = v.sched.After(next, v.expire)
+	}
+}
+
+// expire raises the flag of the timer whose interrupt is due.
+func (v *via6522) expire() {
+	v.timer = nil
+	v.sync()
+	v.schedule()
+}
+
+// TriggerCA1 signals an active transition on the CA1 control line.
+func (v *via6522) TriggerCA1() {
+	v.sync()
+	v.ifr |= viaIntCA1
+	v.updateIRQ()
+}
+
+// sync advances the timers to the current CPU cycle, raising interrupt
+// flags for any timers that expired in the meantime.
+func (v *via6522) sync() {
+	now := v.sched.Now()
+	elapsed := now - v.last
+	v.last = now
+	if elapsed == 0 {
+		return
+	}
+
+	// Timer 1 interrupts when it counts past zero. In free-running mode it
+	// then reloads from the latch; in one-shot mode it interrupts only once
+	// and keeps counting down.
+	if elapsed <= uint64(v.t1c) {
+		v.t1c -= uint16(elapsed)
+	} else {
+		over := elapsed - uint64(v.t1c) - 1
+		if (v.acr & 0x40) != 0 {
+			period := uint64(v.t1l) + 1
+			v.t1c = v.t1l - uint16(over%period)
+			v.ifr |= viaIntT1
+		} else {
+			if v.t1Armed {
+				v.ifr |= viaIntT1
+				v.t1Armed = false
+			}
+			v.t1c = 0xffff - uint16(over)
+		}
+	}
+
+	// Timer 2 is always one-shot.
+	if elapsed <= uint64(v.t2c) {
+		v.t2c -= uint16(elapsed)
+	} else {
+		if v.t2Armed {
+			v.ifr |= viaIntT2
+			v.t2Armed = false
+		}
+		v.t2c = 0xffff - uint16(elapsed-uint64(v.t2c)-1)
+	}
+	v.updateIRQ()
+}
+
+func (v *via6522) saveState(sw *stateWriter) {
+	sw.Tag("VIA ")
+	v.sync()
+	for _, b := range []byte{v.orb, v.ora, v.ddrb, v.ddra, v.t2ll, v.sr, v.acr, v.pcr, v.ifr, v.ier} {
+		sw.Byte(b)
+	}
+	sw.Uint16(v.t1c)
+	sw.Uint16(v.t1l)
+	sw.Uint16(v.t2c)
+	sw.Bool(v.t1Armed)
+	sw.Bool(v.t2Armed)
+}
+
+func (v *via6522) loadState(sr *stateReader) {
+	sr.Tag("VIA ")
+	for _, b := range []*byte{&v.orb, &v.ora, &v.ddrb, &v.ddra, &v.t2ll, &v.sr, &v.acr, &v.pcr, &v.ifr, &v.ier} {
+		*b = sr.Byte()
+	}
+	v.t1c = sr.Uint16()
+	v.t1l = sr.Uint16()
+	v.t2c = sr.Uint16()
+	v.t1Armed = sr.Bool()
+	v.t2Armed = sr.Bool()
+	v.last = v.sched.Now()
+	v.schedule()
+
+	// The machine's interrupt lines are released when a snapshot is
+	// loaded, so report the restored state.
+	v.irq = false
+	v.updateIRQ()
+}
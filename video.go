@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// frameRate is the number of video fields displayed per second.
+const frameRate = cpuClockRate / cyclesPerFrame
+
+// A videoFormat is a file format written by the video recorder.
+type videoFormat int
+
+const (
+	videoGIF videoFormat = iota // animated GIF
+	videoRaw                    // raw RGBA frames
+)
+
+// videoOptions control how the display is recorded.
+type videoOptions struct {
+	format videoFormat
+	every  int // record every nth frame; 1 = all frames
+	scale  int // pixel scale factor; 1 = 560x384
+}
+
+// A videoRecorder captures the frames drawn by the display.
+//
+// Raw frames are written as they are captured, each one as 560*scale by
+// 384*scale RGBA pixels, so that the stream can be piped to an encoder:
+//
+//	ffmpeg -f rawvideo -pixel_format rgba -video_size 560x384 \
+//	    -framerate 59.92 -i - out.mp4
+//
+// where the frame rate is divided by the decimation factor. GIF frames
+// are held in memory until recording stops, with repeated frames merged
+// into one longer frame. GIF timing has a resolution of 1/100 second, so
+// a decimation factor of 2 or more gives smoother playback.
+type videoRecorder struct {
+	w     io.Writer
+	opts  videoOptions
+	count int   // frames drawn since recording started
+	err   error // first error writing raw frames
+
+	gif   gif.GIF
+	times []int // start time of each GIF frame in 1/100 seconds
+}
+
+var gifPalette = func() color.Palette {
+	p := make(color.Palette, len(loResColors))
+	for i, c := range loResColors {
+		p[i] = c
+	}
+	return p
+}()
+
+// StartVideoRecording starts recording the display to w, replacing any
+// recording in progress.
+func (a *apple2) StartVideoRecording(w io.Writer, opts videoOptions) {
+	if opts.every < 1 {
+		opts.every = 1
+	}
+	if opts.scale < 1 {
+		opts.scale = 1
+	}
+	a.video = &videoRecorder{w: w, opts: opts}
+}
+
+// StopVideoRecording stops recording the display. A GIF recording is
+// encoded and written when it stops.
+func (a *apple2) StopVideoRecording() error {
+	r := a.video
+	if r == nil {
+		return nil
+	}
+	a.video = nil
+	return r.finish()
+}
+
+// RecordVideo starts recording the display to a file, or to standard
+// output if the filename is "-". Files with a .gif extension are
+// animated GIFs, and all others are raw frame streams. The returned
+// function stops the recording and closes the file.
+func (a *apple2) RecordVideo(filename string, opts videoOptions) (stop func() error, err error) {
+	opts.format = videoRaw
+	if strings.EqualFold(filepath.Ext(filename), ".gif") {
+		opts.format = videoGIF
+	}
+	if filename == "-" {
+		a.StartVideoRecording(os.Stdout, opts)
+		return a.StopVideoRecording, nil
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	a.StartVideoRecording(file, opts)
+	stop = func() error {
+		err := a.StopVideoRecording()
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return stop, nil
+}
+
+// frame is called after the display draws each frame.
+func (r *videoRecorder) frame(fb *image.RGBA) {
+	n := r.count
+	r.count++
+	if n%r.opts.every != 0 || r.err != nil {
+		return
+	}
+
+	img := scaleFrame(fb, r.opts.scale)
+	if r.opts.format == videoRaw {
+		_, r.err = r.w.Write(img.Pix)
+		return
+	}
+
+	p := palettize(img)
+	if k := len(r.gif.Image); k > 0 && bytes.Equal(p.Pix, r.gif.Image[k-1].Pix) {
+		return
+	}
+	r.gif.Image = append(r.gif.Image, p)
+	r.times = append(r.times, gifTime(n))
+}
+
+// gifTime returns the time at which a frame is displayed, in 1/100
+// seconds.
+func gifTime(frame int) int {
+	return int(math.Round(float64(frame) * 100 / frameRate))
+}
+
+func (r *videoRecorder) finish() error {
+	if r.opts.format == videoRaw || r.err != nil {
+		return r.err
+	}
+	if len(r.gif.Image) == 0 {
+		return nil
+	}
+	r.times = append(r.times, gifTime(r.count))
+	r.gif.Delay = make([]int, len(r.gif.Image))
+	for i := range r.gif.Delay {
+		r.gif.Delay[i] = r.times[i+1] - r.times[i]
+	}
+	return gif.EncodeAll(r.w, &r.gif)
+}
+
+// palettize converts a frame to the palette of colors the display draws.
+func palettize(img *image.RGBA) *image.Paletted {
+	p := image.NewPaletted(img.Rect, gifPalette)
+	index := make(map[color.RGBA]uint8, len(gifPalette))
+	for i, c := range loResColors {
+		index[c] = uint8(i)
+	}
+	for i := range p.Pix {
+		c := color.RGBA{img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3]}
+		v, ok := index[c]
+		if !ok {
+			v = uint8(gifPalette.Index(c))
+			index[c] = v
+		}
+		p.Pix[i] = v
+	}
+	return p
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+)
+
+func TestVideoRecording(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc050)                             // TEXT off
+	a.mmu.StoreBytes(0x0300, []byte{0x4c, 0x00, 0x03}) // JMP $0300
+	a.cpu.SetPC(0x0300)
+
+	// Raw frames are written as they are drawn, decimated.
+	var raw bytes.Buffer
+	a.StartVideoRecording(&raw, videoOptions{format: videoRaw, every: 2})
+	for i := 0; i < 5; i++ {
+		a.RunFrame()
+	}
+	if err := a.StopVideoRecording(); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if size := displayWidth * displayHeight * 2 * 4; raw.Len() != 3*size {
+		t.Errorf("Expected 3 frames, got %d bytes\n", raw.Len())
+	}
+
+	// Unchanged GIF frames are merged into one.
+	var out bytes.Buffer
+	a.StartVideoRecording(&out, videoOptions{format: videoGIF})
+	for i := 0; i < 60; i++ {
+		if i == 30 {
+			a.mmu.StoreByte(0x0400, 0x0f)
+		}
+		a.RunFrame()
+	}
+	a.RunFrame()
+	if err := a.StopVideoRecording(); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if a.video != nil {
+		t.Errorf("Expected recording to stop\n")
+	}
+
+	g, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatalf("Unable to decode GIF: %v\n", err)
+	}
+	if len(g.Image) != 2 {
+		t.Fatalf("Expected 2 frames, got %d\n", len(g.Image))
+	}
+	if g.Delay[0] != 50 || g.Delay[1] != 52 {
+		t.Errorf("Unexpected delays %v\n", g.Delay)
+	}
+	if c := g.Image[1].At(0, 0); c != loResColors[15] {
+		t.Errorf("Expected white, got %v\n", c)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// The VNC front end serves the machine's display to VNC clients with the
+// RFB protocol (RFC 6143), versions 3.3 to 3.8, without authentication.
+// The display is 560 by 384 pixels, each scanline drawn twice, sent with
+// the raw encoding in any true-color pixel format the client asks for.
+// Keys typed in the client are typed on the Apple II keyboard, and text
+// pasted into it is typed as well. The pointer's position across the
+// display sets paddles 0 and 1, and its left and right buttons are push
+// buttons 0 and 1, which is enough to play paddle games.
+
+const (
+	vncWidth  = displayWidth
+	vncHeight = displayHeight * 2
+)
+
+// Client message types.
+const (
+	vncSetPixelFormat = 0
+	vncSetEncodings   = 2
+	vncUpdateRequest  = 3
+	vncKeyEvent       = 4
+	vncPointerEvent   = 5
+	vncClientCutText  = 6
+)
+
+// vncMaxCutText is the longest pasted text accepted from a client.
+const vncMaxCutText = 64 * 1024
+
+var (
+	errVNCVersion = errors.New("vnc: unsupported protocol version")
+	errVNCMessage = errors.New("vnc: unknown message type")
+)
+
+// A vncPixelFormat describes how a client wants pixels encoded.
+type vncPixelFormat struct {
+	BitsPerPixel, Depth, BigEndian, TrueColor uint8
+	RedMax, GreenMax, BlueMax                 uint16
+	RedShift, GreenShift, BlueShift           uint8
+	_                                         [3]byte
+}
+
+// vncDefaultFormat is the pixel format the server offers: 32-bit
+// little-endian pixels with 8 bits per component.
+var vncDefaultFormat = vncPixelFormat{
+	BitsPerPixel: 32, Depth: 24, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+// appendPixel appends a color in the format.
+func (f *vncPixelFormat) appendPixel(b []byte, r, g, bl byte) []byte {
+	v := uint32(r)*uint32(f.RedMax)/255<<f.RedShift |
+		uint32(g)*uint32(f.GreenMax)/255<<f.GreenShift |
+		uint32(bl)*uint32(f.BlueMax)/255<<f.BlueShift
+	switch f.BitsPerPixel {
+	case 8:
+		return append(b, byte(v))
+	case 16:
+		if f.BigEndian != 0 {
+			return binary.BigEndian.AppendUint16(b, uint16(v))
+		}
+		return binary.LittleEndian.AppendUint16(b, uint16(v))
+	default:
+		if f.BigEndian != 0 {
+			return binary.BigEndian.AppendUint32(b, v)
+		}
+		return binary.LittleEndian.AppendUint32(b, v)
+	}
+}
+
+// vncKeysyms maps the X keysyms of the keys that don't type printable
+// characters to host keys.
+var vncKeysyms = map[uint32]hostKey{
+	0xff08: hostKeyBackspace,
+	0xff09: hostKeyTab,
+	0xff0d: hostKeyReturn,
+	0xff1b: hostKeyEscape,
+	0xffff: hostKeyDelete,
+	0xff51: hostKeyLeft,
+	0xff52: hostKeyUp,
+	0xff53: hostKeyRight,
+	0xff54: hostKeyDown,
+	0xff14: hostKeyScrollLock,
+	0xffc8: hostKeyF11,
+	0xffc9: hostKeyF12,
+	0xffe1: hostKeyLeftShift,
+	0xffe2: hostKeyRightShift,
+	0xffe3: hostKeyLeftCtrl,
+	0xffe4: hostKeyRightCtrl,
+	0xffe5: hostKeyCapsLock,
+	0xffe7: hostKeyLeftGUI,
+	0xffe8: hostKeyRightGUI,
+	0xffe9: hostKeyLeftAlt,
+	0xffea: hostKeyRightAlt,
+	0xffeb: hostKeyLeftGUI,
+	0xffec: hostKeyRightGUI,
+}
+
+// vncKey returns the host key of a keysym. The keysyms of printable
+// characters are the characters themselves, whose keys are found the way
+// the terminal front end finds them; the client sends the shift key
+// separately.
+func vncKey(sym uint32) (hostKey, bool) {
+	if k, ok := vncKeysyms[sym]; ok {
+		return k, true
+	}
+	if sym >= 0x20 && sym < 0x7f {
+		k, ok := terminalChars[byte(sym)]
+		return k.key, ok
+	}
+	return 0, false
+}
+
+// A vncServer serves the machine's display to VNC clients. The machine's
+// goroutine publishes each frame, and each client's goroutine sends the
+// client the rows that changed since it was last sent the display.
+type vncServer struct {
+	apple2 *apple2
+
+	mu      sync.Mutex
+	frame   []byte // the display as RGB pixels, one row per scanline
+	clients map[*vncClient]bool
+}
+
+func newVNCServer(apple2 *apple2) *vncServer {
+	return &vncServer{
+		apple2:  apple2,
+		frame:   make([]byte, displayWidth*displayHeight*3),
+		clients: make(map[*vncClient]bool),
+	}
+}
+
+// Send publishes the display to the clients. The machine's goroutine
+// calls it between frames.
+func (s *vncServer) Send() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) == 0 {
+		return
+	}
+	fb := s.apple2.ds.Framebuffer()
+	for y := 0; y < displayHeight; y++ {
+		row := fb.Pix[y*fb.Stride:]
+		for x := 0; x < displayWidth; x++ {
+			copy(s.frame[(y*displayWidth+x)*3:], row[x*4:x*4+3])
+		}
+	}
+	for c := range s.clients {
+		select {
+		case c.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// A vncClient is a connected client. Its reader goroutine handles the
+// messages the client sends, and passes the ones that affect the updates
+// it's sent to its writer goroutine.
+type vncClient struct {
+	server *vncServer
+	conn   net.Conn
+	notify chan struct{} // signaled when a frame is published
+	ctl    chan any      // pixel formats and update requests from the client
+	done   chan struct{} // closed when the writer stops
+
+	// Owned by the writer.
+	format  vncPixelFormat
+	pending *vncUpdate // update requested and not yet sent
+	frame   []byte     // copy of the published frame
+	last    []byte     // the display as last sent
+	buf     []byte
+
+	// Owned by the reader.
+	buttons byte // pointer buttons pressed
+}
+
+// A vncUpdate is a request for an update of an area of the display.
+type vncUpdate struct {
+	Incremental         uint8
+	X, Y, Width, Height uint16
+}
+
+// serve runs the protocol with a client until it disconnects.
+func (s *vncServer) serve(conn net.Conn) error {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	if err := s.handshake(conn, br); err != nil {
+		return err
+	}
+
+	c := &vncClient{
+		server: s,
+		conn:   conn,
+		notify: make(chan struct{}, 1),
+		ctl:    make(chan any, 16),
+		done:   make(chan struct{}),
+		format: vncDefaultFormat,
+		frame:  make([]byte, len(s.frame)),
+	}
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.write()
+		close(c.done)
+		conn.Close()
+	}()
+	err := c.read(br)
+	close(c.ctl)
+	if werr := <-errs; werr != nil {
+		err = werr
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// handshake agrees on a protocol version and security type with a
+// client, and exchanges the initialization messages.
+func (s *vncServer) handshake(conn net.Conn, br *bufio.Reader) error {
+	if _, err := io.WriteString(conn, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(br, version); err != nil {
+		return err
+	}
+	var minor int
+	if _, err := fmt.Sscanf(string(version), "RFB 003.%03d\n", &minor); err != nil || minor < 3 {
+		return errVNCVersion
+	}
+
+	// Version 3.3 has the server choose the security type; later ones
+	// let the client choose from a list. Only 3.8 reports the result of
+	// choosing no security.
+	if minor < 7 {
+		if _, err := conn.Write([]byte{0, 0, 0, 1}); err != nil {
+			return err
+		}
+	} else {
+		if _, err := conn.Write([]byte{1, 1}); err != nil {
+			return err
+		}
+		if _, err := br.ReadByte(); err != nil {
+			return err
+		}
+		if minor >= 8 {
+			if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The client's init message says whether to share the display, and
+	// it's always shared.
+	if _, err := br.ReadByte(); err != nil {
+		return err
+	}
+	name := "apple2go " + s.apple2.cfg.name
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, [2]uint16{vncWidth, vncHeight})
+	binary.Write(&b, binary.BigEndian, vncDefaultFormat)
+	binary.Write(&b, binary.BigEndian, uint32(len(name)))
+	b.WriteString(name)
+	_, err := conn.Write(b.Bytes())
+	return err
+}
+
+// read handles the messages from the client.
+func (c *vncClient) read(br *bufio.Reader) error {
+	a := c.server.apple2
+	for {
+		typ, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case vncSetPixelFormat:
+			var msg struct {
+				_      [3]byte
+				Format vncPixelFormat
+			}
+			if err := binary.Read(br, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			// Color maps aren't supported, so clients that ask for them
+			// keep getting true color.
+			if f := msg.Format; f.TrueColor != 0 && (f.BitsPerPixel == 8 || f.BitsPerPixel == 16 || f.BitsPerPixel == 32) {
+				c.post(f)
+			}
+		case vncSetEncodings:
+			var hdr struct {
+				_     byte
+				Count uint16
+			}
+			if err := binary.Read(br, binary.BigEndian, &hdr); err != nil {
+				return err
+			}
+			if _, err := br.Discard(4 * int(hdr.Count)); err != nil {
+				return err
+			}
+		case vncUpdateRequest:
+			var u vncUpdate
+			if err := binary.Read(br, binary.BigEndian, &u); err != nil {
+				return err
+			}
+			c.post(&u)
+		case vncKeyEvent:
+			var msg struct {
+				Down uint8
+				_    [2]byte
+				Sym  uint32
+			}
+			if err := binary.Read(br, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if k, ok := vncKey(msg.Sym); ok {
+				a.im.KeyEvent(k, msg.Down != 0)
+			}
+		case vncPointerEvent:
+			var msg struct {
+				Buttons uint8
+				X, Y    uint16
+			}
+			if err := binary.Read(br, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			c.pointer(msg.Buttons, int(msg.X), int(msg.Y))
+		case vncClientCutText:
+			var hdr struct {
+				_      [3]byte
+				Length uint32
+			}
+			if err := binary.Read(br, binary.BigEndian, &hdr); err != nil {
+				return err
+			}
+			text := make([]byte, min(hdr.Length, vncMaxCutText))
+			if _, err := io.ReadFull(br, text); err != nil {
+				return err
+			}
+			if _, err := br.Discard(int(hdr.Length) - len(text)); err != nil {
+				return err
+			}
+			// The text is Latin-1, whose ASCII characters are the only
+			// ones the keyboard types.
+			a.kb.PasteText(string(text))
+		default:
+			return fmt.Errorf("%w %d", errVNCMessage, typ)
+		}
+	}
+}
+
+// post passes a message to the writer, unless it has stopped.
+func (c *vncClient) post(msg any) {
+	select {
+	case c.ctl <- msg:
+	case <-c.done:
+	}
+}
+
+// pointer sets the paddles from the pointer's position and the push
+// buttons from its buttons.
+func (c *vncClient) pointer(buttons byte, x, y int) {
+	a := c.server.apple2
+	a.gi.SetPaddle(0, byte(min(x, vncWidth-1)*255/(vncWidth-1)))
+	a.gi.SetPaddle(1, byte(min(y, vncHeight-1)*255/(vncHeight-1)))
+	for i, mask := range []byte{1, 4} {
+		if (buttons^c.buttons)&mask != 0 {
+			a.im.GamepadButton(i, buttons&mask != 0)
+		}
+	}
+	c.buttons = buttons
+}
+
+// write sends the client the updates it requests, waiting for the
+// display to change before answering incremental requests.
+func (c *vncClient) write() error {
+	for {
+		if c.pending != nil {
+			c.server.mu.Lock()
+			copy(c.frame, c.server.frame)
+			c.server.mu.Unlock()
+			if err := c.sendUpdate(); err != nil {
+				return err
+			}
+		}
+		select {
+		case msg, ok := <-c.ctl:
+			if !ok {
+				return nil
+			}
+			switch msg := msg.(type) {
+			case vncPixelFormat:
+				c.format = msg
+			case *vncUpdate:
+				c.pending = msg
+			}
+		case <-c.notify:
+		}
+	}
+}
+
+// sendUpdate answers the pending update request, if the client hasn't
+// been sent the display yet, or it asked for all of an area, or rows of
+// the display have changed.
+func (c *vncClient) sendUpdate() error {
+	const rowBytes = displayWidth * 3
+	type rect struct{ x, y, w, h int }
+	var rects []rect
+	if u := c.pending; u.Incremental == 0 || c.last == nil {
+		x, y := min(int(u.X), vncWidth), min(int(u.Y), vncHeight)
+		w, h := min(int(u.Width), vncWidth-x), min(int(u.Height), vncHeight-y)
+		if c.last == nil {
+			x, y, w, h = 0, 0, vncWidth, vncHeight
+			c.last = make([]byte, len(c.frame))
+		}
+		rects = append(rects, rect{x, y, w, h})
+	} else {
+		for row := 0; row < displayHeight; {
+			if bytes.Equal(c.frame[row*rowBytes:(row+1)*rowBytes], c.last[row*rowBytes:(row+1)*rowBytes]) {
+				row++
+				continue
+			}
+			end := row + 1
+			for end < displayHeight && !bytes.Equal(c.frame[end*rowBytes:(end+1)*rowBytes], c.last[end*rowBytes:(end+1)*rowBytes]) {
+				end++
+			}
+			rects = append(rects, rect{0, 2 * row, vncWidth, 2 * (end - row)})
+			row = end
+		}
+		if len(rects) == 0 {
+			return nil
+		}
+	}
+	c.pending = nil
+
+	b := append(c.buf[:0], 0, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rects)))
+	for _, r := range rects {
+		for _, v := range []int{r.x, r.y, r.w, r.h} {
+			b = binary.BigEndian.AppendUint16(b, uint16(v))
+		}
+		b = append(b, 0, 0, 0, 0) // raw encoding
+		for y := r.y; y < r.y+r.h; y++ {
+			row := c.frame[y/2*rowBytes:]
+			for x := r.x; x < r.x+r.w; x++ {
+				b = c.format.appendPixel(b, row[x*3], row[x*3+1], row[x*3+2])
+			}
+		}
+	}
+	c.buf = b
+	// The rows the client now has all of are those it was sent both
+	// scanlines of, across the whole display.
+	for _, r := range rects {
+		if r.w == vncWidth {
+			first, end := (r.y+1)/2, (r.y+r.h)/2
+			copy(c.last[first*rowBytes:end*rowBytes], c.frame[first*rowBytes:end*rowBytes])
+		}
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// serveVNC serves the display of a machine to VNC clients on a TCP
+// address. It returns the listener, which stops the server when closed.
+// RunFrame publishes the frames, in whichever loop runs the machine.
+func serveVNC(a *apple2, addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	a.vnc = newVNCServer(a)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := a.vnc.serve(conn); err != nil {
+					a.log.Logger(logVideo).Warn("VNC client disconnected", "client", conn.RemoteAddr(), "error", err)
+				}
+			}()
+		}
+	}()
+	return l, nil
+}
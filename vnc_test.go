@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestVNCServer(t *testing.T) {
+	a := newApple2()
+	a.mmu.LoadByte(0xc051) // TEXT
+	for addr := uint16(0x400); addr < 0x800; addr++ {
+		a.mmu.StoreByte(addr, 0xa0)
+	}
+	a.mmu.StoreByte(0x400, 0x20) // an inverse space
+	a.ds.Render()
+	a.vnc = newVNCServer(a)
+
+	conn, server := net.Pipe()
+	defer conn.Close()
+	go a.vnc.serve(server)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	write := func(b ...byte) {
+		if _, err := conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Handshake, as version 3.8 without security.
+	if v := read(12); string(v) != "RFB 003.008\n" {
+		t.Fatalf("Unexpected version %q\n", v)
+	}
+	write([]byte("RFB 003.008\n")...)
+	if types := read(2); !bytes.Equal(types, []byte{1, 1}) {
+		t.Fatalf("Unexpected security types % x\n", types)
+	}
+	write(1)
+	if result := read(4); !bytes.Equal(result, []byte{0, 0, 0, 0}) {
+		t.Fatalf("Unexpected security result % x\n", result)
+	}
+	write(1)
+	init := read(24)
+	name := read(int(binary.BigEndian.Uint32(init[20:])))
+	if w, h := binary.BigEndian.Uint16(init), binary.BigEndian.Uint16(init[2:]); w != 560 || h != 384 || !bytes.HasPrefix(name, []byte("apple2go")) {
+		t.Fatalf("Unexpected server init %dx%d %q\n", w, h, name)
+	}
+
+	for i := 0; i < 100; i++ {
+		a.vnc.mu.Lock()
+		n := len(a.vnc.clients)
+		a.vnc.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.vnc.Send()
+
+	// readUpdate reads an update of one rectangle and returns its area
+	// and 16-bit pixels.
+	readUpdate := func() (y, h int, pixels []byte) {
+		hdr := read(16)
+		if hdr[0] != 0 || binary.BigEndian.Uint16(hdr[2:]) != 1 {
+			t.Fatalf("Expected an update of one rectangle, got % x\n", hdr)
+		}
+		y, w, h := int(binary.BigEndian.Uint16(hdr[6:])), int(binary.BigEndian.Uint16(hdr[8:])), int(binary.BigEndian.Uint16(hdr[10:]))
+		return y, h, read(w * h * 2)
+	}
+
+	// Pixels are sent in the format the client asks for: here 16-bit
+	// big-endian 5:6:5.
+	write(vncSetPixelFormat, 0, 0, 0, 16, 16, 1, 1, 0, 31, 0, 63, 0, 31, 11, 5, 0, 0, 0, 0)
+	write(vncUpdateRequest, 0, 0, 0, 0, 0, 0x02, 0x30, 0x01, 0x80)
+	y, h, pixels := readUpdate()
+	if y != 0 || h != 384 || !bytes.Equal(pixels[:2], []byte{0xff, 0xff}) || !bytes.Equal(pixels[40:42], []byte{0, 0}) {
+		t.Errorf("Unexpected first update: rows %d+%d, pixels % x\n", y, h, pixels[:42])
+	}
+
+	// Incremental updates hold the rows that changed.
+	write(vncUpdateRequest, 1, 0, 0, 0, 0, 0x02, 0x30, 0x01, 0x80)
+	a.mmu.StoreByte(0x480, 0x20)
+	a.ds.Render()
+	a.vnc.Send()
+	if y, h, _ := readUpdate(); y != 16 || h != 16 {
+		t.Errorf("Expected an update of text row 1, got rows %d+%d\n", y, h)
+	}
+
+	// Keys are typed, and the pointer moves the paddles.
+	write(vncKeyEvent, 1, 0, 0, 0, 0, 0, 'q')
+	write(vncPointerEvent, 1, 0x02, 0x2f, 0, 0)
+	write(vncSetEncodings, 0, 0, 0) // waits for the messages before it
+	for i := 0; i < 100 && a.kb.GetKeyData()&0x7f != 'Q'; i++ {
+		time.Sleep(time.Millisecond)
+		a.kb.Update()
+	}
+	a.gi.mu.Lock()
+	paddles, button := a.gi.paddles, a.gi.buttons[0]
+	a.gi.mu.Unlock()
+	if a.kb.GetKeyData()&0x7f != 'Q' || paddles[0] != 255 || paddles[1] != 0 || !button {
+		t.Errorf("Expected key Q, paddles 255 and 0 and button 0, got $%02X %v %v\n", a.kb.GetKeyData(), paddles[:2], button)
+	}
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// W5100 common registers.
+const (
+	w5100MR   = 0x0000 // mode
+	w5100IR   = 0x0015 // interrupt
+	w5100RMSR = 0x001a // RX memory size
+	w5100TMSR = 0x001b // TX memory size
+
+	w5100Sockets    = 4
+	w5100SocketBase = 0x0400 // socket registers, 0x100 bytes per socket
+	w5100TXBase     = 0x4000
+	w5100RXBase     = 0x6000
+	w5100MemSize    = 0x8000
+)
+
+// W5100 mode register bits.
+const (
+	w5100ModeReset = 1 << 7 // software reset
+	w5100ModeAI    = 1 << 1 // address auto-increment in indirect mode
+)
+
+// W5100 socket registers, as offsets from the socket's base.
+const (
+	w5100SnMR    = 0x00 // mode
+	w5100SnCR    = 0x01 // command
+	w5100SnIR    = 0x02 // interrupt
+	w5100SnSR    = 0x03 // status
+	w5100SnPORT  = 0x04 // source port
+	w5100SnDIPR  = 0x0c // destination IP address
+	w5100SnDPORT = 0x10 // destination port
+	w5100SnTXFSR = 0x20 // TX free size
+	w5100SnTXRD  = 0x22 // TX read pointer
+	w5100SnTXWR  = 0x24 // TX write pointer
+	w5100SnRXRSR = 0x26 // RX received size
+	w5100SnRXRD  = 0x28 // RX read pointer
+)
+
+// W5100 socket protocols, in the low bits of the socket mode register.
+const (
+	w5100ProtoClosed = 0
+	w5100ProtoTCP    = 1
+	w5100ProtoUDP    = 2
+	w5100ProtoIPRaw  = 3
+	w5100ProtoMACRaw = 4
+)
+
+// W5100 socket commands.
+const (
+	w5100CmdOpen    = 0x01
+	w5100CmdListen  = 0x02
+	w5100CmdConnect = 0x04
+	w5100CmdDiscon  = 0x08
+	w5100CmdClose   = 0x10
+	w5100CmdSend    = 0x20
+	w5100CmdRecv    = 0x40
+)
+
+// W5100 socket interrupt bits.
+const (
+	w5100IntCon     = 1 << 0 // connection established
+	w5100IntDiscon  = 1 << 1 // peer closed or disconnected
+	w5100IntRecv    = 1 << 2 // data received
+	w5100IntTimeout = 1 << 3 // connection or send timed out
+	w5100IntSendOK  = 1 << 4 // send complete
+)
+
+// W5100 socket status values.
+const (
+	w5100StatusClosed      = 0x00
+	w5100StatusInit        = 0x13
+	w5100StatusListen      = 0x14
+	w5100StatusSynSent     = 0x15
+	w5100StatusEstablished = 0x17
+	w5100StatusCloseWait   = 0x1c
+	w5100StatusUDP         = 0x22
+	w5100StatusIPRaw       = 0x32
+	w5100StatusMACRaw      = 0x42
+)
+
+// w5100ConnectTimeout limits the time a TCP connection attempt may take
+// before the socket reports a timeout.
+const w5100ConnectTimeout = 10 * time.Second
+
+// A w5100Socket holds the host side of one of the W5100's sockets. Its
+// fields are guarded by the chip's mutex.
+type w5100Socket struct {
+	gen      int // incremented on close, to retire the socket's goroutines
+	conn     net.Conn
+	udp      *net.UDPConn
+	listener net.Listener
+	tx       chan []byte // TCP data waiting to be sent to the host
+	rx       [][]byte    // TCP data, or UDP packets with their headers
+	ir       byte        // interrupt bits raised by the host side
+	status   byte        // status set by the host side, if statusOK
+	statusOK bool
+
+	rxWR uint16 // the chip's RX buffer write pointer
+}
+
+// A w5100 emulates the WIZnet W5100 network controller with its hardwired
+// TCP/IP stack. The chip's 32K of registers and buffer memory are accessed
+// indirectly through an address register and a data port. Sockets in TCP
+// and UDP mode are bridged to host sockets, so the connections and
+// datagrams the Apple II makes are made by the host. Sockets in raw modes
+// open, but send and receive nothing.
+type w5100 struct {
+	apple2 *apple2
+	mem    [w5100MemSize]byte
+	addr   uint16
+
+	mu      sync.Mutex
+	sockets [w5100Sockets]w5100Socket
+}
+
+func newW5100(apple2 *apple2) *w5100 {
+	w := &w5100{apple2: apple2}
+	w.Reset()
+	return w
+}
+
+// Reset performs a software reset, closing all sockets and restoring the
+// registers to their defaults.
+func (w *w5100) Reset() {
+	for n := 0; n < w5100Sockets; n++ {
+		w.close(n)
+	}
+	w.mem = [w5100MemSize]byte{}
+	w.mem[0x17], w.mem[0x18] = 0x07, 0xd0 // retry time
+	w.mem[0x19] = 0x08                    // retry count
+	w.mem[w5100RMSR] = 0x55               // 2K per socket
+	w.mem[w5100TMSR] = 0x55
+	for n := 0; n < w5100Sockets; n++ {
+		base := w.socketBase(n)
+		w.mem[base+0x12], w.mem[base+0x13] = 0xff, 0xff // maximum segment size
+		w.mem[base+0x16] = 0x80                         // time to live
+	}
+}
+
+// LoadMode returns the mode register.
+func (w *w5100) LoadMode() byte {
+	return w.mem[w5100MR]
+}
+
+// StoreMode sets the mode register, resetting the chip if the reset bit is
+// set.
+func (w *w5100) StoreMode(v byte) {
+	if v&w5100ModeReset != 0 {
+		w.Reset()
+		return
+	}
+	w.mem[w5100MR] = v
+}
+
+// StoreAddr sets the high (i = 0) or low (i = 1) byte of the indirect
+// address register.
+func (w *w5100) StoreAddr(i int, v byte) {
+	if i == 0 {
+		w.addr = w.addr&0x00ff | uint16(v)<<8
+	} else {
+		w.addr = w.addr&0xff00 | uint16(v)
+	}
+}
+
+// LoadAddr returns the high (i = 0) or low (i = 1) byte of the indirect
+// address register.
+func (w *w5100) LoadAddr(i int) byte {
+	if i == 0 {
+		return byte(w.addr >> 8)
+	}
+	return byte(w.addr)
+}
+
+// LoadData reads the byte at the indirect address.
+func (w *w5100) LoadData() byte {
+	v := w.Load(w.addr)
+	w.increment()
+	return v
+}
+
+// StoreData writes the byte at the indirect address.
+func (w *w5100) StoreData(v byte) {
+	w.Store(w.addr, v)
+	w.increment()
+}
+
+// increment advances the indirect address if auto-increment is on. The
+// address wraps at the end of the TX and RX memory.
+func (w *w5100) increment() {
+	if w.mem[w5100MR]&w5100ModeAI == 0 {
+		return
+	}
+	w.addr++
+	switch w.addr {
+	case w5100RXBase:
+		w.addr = w5100TXBase
+	case w5100MemSize:
+		w.addr = w5100RXBase
+	}
+}
+
+// Load reads a register or buffer byte.
+func (w *w5100) Load(addr uint16) byte {
+	addr &= w5100MemSize - 1
+	switch {
+	case addr == w5100IR:
+		v := w.mem[w5100IR] & 0xf0
+		for n := 0; n < w5100Sockets; n++ {
+			w.poll(n)
+			if w.mem[w.socketBase(n)+w5100SnIR] != 0 {
+				v |= 1 << uint(n)
+			}
+		}
+		return v
+	case addr >= w5100SocketBase && addr < w5100SocketBase+w5100Sockets<<8:
+		n, reg := w.socketReg(addr)
+		switch reg {
+		case w5100SnIR, w5100SnSR, w5100SnRXRSR:
+			w.poll(n)
+		case w5100SnTXFSR, w5100SnTXFSR + 1:
+			base := w.socketBase(n)
+			used := w.reg16(base+w5100SnTXWR) - w.reg16(base+w5100SnTXRD)
+			free := uint16(w.txSize(n)) - used
+			return byte(free >> (8 * (1 - uint(reg-w5100SnTXFSR))))
+		}
+	}
+	return w.mem[addr]
+}
+
+// Store writes a register or buffer byte.
+func (w *w5100) Store(addr uint16, v byte) {
+	addr &= w5100MemSize - 1
+	switch {
+	case addr == w5100MR:
+		w.StoreMode(v)
+		return
+	case addr == w5100IR:
+		w.mem[w5100IR] &^= v
+		return
+	case addr >= w5100SocketBase && addr < w5100SocketBase+w5100Sockets<<8:
+		n, reg := w.socketReg(addr)
+		switch reg {
+		case w5100SnCR:
+			w.command(n, v)
+			return
+		case w5100SnIR:
+			w.mem[addr] &^= v
+			return
+		case w5100SnSR, w5100SnTXFSR, w5100SnTXFSR + 1, w5100SnTXRD, w5100SnTXRD + 1, w5100SnRXRSR, w5100SnRXRSR + 1:
+			return // read-only
+		}
+	}
+	w.mem[addr] = v
+}
+
+func (w *w5100) socketBase(n int) uint16 {
+	return w5100SocketBase + uint16(n)<<8
+}
+
+func (w *w5100) socketReg(addr uint16) (n int, reg uint16) {
+	return int(addr>>8) & 3, addr & 0xff
+}
+
+func (w *w5100) reg16(addr uint16) uint16 {
+	return binary.BigEndian.Uint16(w.mem[addr:])
+}
+
+func (w *w5100) setReg16(addr uint16, v uint16) {
+	binary.BigEndian.PutUint16(w.mem[addr:], v)
+}
+
+// bufferSize returns the size of socket n's buffer, given the RX or TX
+// memory size register, which holds two bits per socket.
+func (w *w5100) bufferSize(msr byte, n int) int {
+	return 1024 << ((msr >> (2 * uint(n))) & 3)
+}
+
+// bufferBase returns the offset of socket n's buffer from the start of the
+// RX or TX memory. Sockets that don't fit in the 8K memory have no buffer.
+func (w *w5100) bufferBase(msr byte, n int) int {
+	base := 0
+	for i := 0; i < n; i++ {
+		base += w.bufferSize(msr, i)
+	}
+	return base
+}
+
+func (w *w5100) txSize(n int) int { return w.bufferSize(w.mem[w5100TMSR], n) }
+func (w *w5100) rxSize(n int) int { return w.bufferSize(w.mem[w5100RMSR], n) }
+
+// txByte returns the byte at a TX pointer of socket n.
+func (w *w5100) txByte(n int, ptr uint16) byte {
+	off := w.bufferBase(w.mem[w5100TMSR], n) + int(ptr)&(w.txSize(n)-1)
+	return w.mem[w5100TXBase+off&0x1fff]
+}
+
+// setRXByte stores a byte at an RX pointer of socket n.
+func (w *w5100) setRXByte(n int, ptr uint16, v byte) {
+	off := w.bufferBase(w.mem[w5100RMSR], n) + int(ptr)&(w.rxSize(n)-1)
+	w.mem[w5100RXBase+off&0x1fff] = v
+}
+
+// setStatus sets socket n's status register.
+func (w *w5100) setStatus(n int, status byte) {
+	w.mem[w.socketBase(n)+w5100SnSR] = status
+}
+
+// command executes a socket command.
+func (w *w5100) command(n int, cmd byte) {
+	base := w.socketBase(n)
+	status := w.mem[base+w5100SnSR]
+	proto := w.mem[base+w5100SnMR] & 0x0f
+
+	switch cmd {
+	case w5100CmdOpen:
+		w.close(n)
+		w.setReg16(base+w5100SnTXRD, 0)
+		w.setReg16(base+w5100SnTXWR, 0)
+		w.setReg16(base+w5100SnRXRD, 0)
+		w.setReg16(base+w5100SnRXRSR, 0)
+		switch proto {
+		case w5100ProtoTCP:
+			w.setStatus(n, w5100StatusInit)
+		case w5100ProtoUDP:
+			w.openUDP(n)
+		case w5100ProtoIPRaw:
+			w.setStatus(n, w5100StatusIPRaw)
+		case w5100ProtoMACRaw:
+			w.setStatus(n, w5100StatusMACRaw)
+		}
+	case w5100CmdListen:
+		if status == w5100StatusInit {
+			w.listen(n)
+		}
+	case w5100CmdConnect:
+		if status == w5100StatusInit {
+			w.connect(n)
+		}
+	case w5100CmdDiscon:
+		if status == w5100StatusEstablished || status == w5100StatusCloseWait {
+			w.close(n)
+			w.mem[base+w5100SnIR] |= w5100IntDiscon
+		}
+	case w5100CmdClose:
+		w.close(n)
+	case w5100CmdSend:
+		w.send(n)
+	case w5100CmdRecv:
+		// The data before the RX read pointer has been consumed.
+		w.mu.Lock()
+		rsr := w.sockets[n].rxWR - w.reg16(base+w5100SnRXRD)
+		w.mu.Unlock()
+		w.setReg16(base+w5100SnRXRSR, rsr)
+		w.poll(n)
+	}
+}
+
+// close closes socket n's host connection.
+func (w *w5100) close(n int) {
+	w.mu.Lock()
+	s := &w.sockets[n]
+	conn, udp, listener, tx := s.conn, s.udp, s.listener, s.tx
+	*s = w5100Socket{gen: s.gen + 1}
+	w.mu.Unlock()
+
+	if conn != nil {
+		close(tx)
+		conn.Close()
+	}
+	if udp != nil {
+		udp.Close()
+	}
+	if listener != nil {
+		listener.Close()
+	}
+	w.setStatus(n, w5100StatusClosed)
+}
+
+// hostEvent records an event from a socket's host goroutine, unless the
+// socket has since been closed. It reports whether the socket is still
+// current. The caller must hold the mutex.
+func (w *w5100) hostEvent(n, gen int, ir byte, status byte, setStatus bool) bool {
+	s := &w.sockets[n]
+	if s.gen != gen {
+		return false
+	}
+	s.ir |= ir
+	if setStatus {
+		s.status, s.statusOK = status, true
+	}
+	return true
+}
+
+// poll applies the events and delivers the data the host side of socket n
+// has received.
+func (w *w5100) poll(n int) {
+	base := w.socketBase(n)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := &w.sockets[n]
+	w.mem[base+w5100SnIR] |= s.ir
+	s.ir = 0
+	if s.statusOK {
+		w.setStatus(n, s.status)
+		s.statusOK = false
+	}
+
+	size := w.rxSize(n)
+	for len(s.rx) > 0 {
+		rsr := int(w.reg16(base + w5100SnRXRSR))
+		data := s.rx[0]
+		free := size - rsr
+		if free == 0 {
+			break
+		}
+		if len(data) > free {
+			// UDP packets are delivered whole, and dropped if they can't
+			// fit in the buffer.
+			if s.udp != nil {
+				if len(data) > size {
+					s.rx = s.rx[1:]
+					continue
+				}
+				break
+			}
+			s.rx[0] = data[free:]
+			data = data[:free]
+		} else {
+			s.rx = s.rx[1:]
+		}
+		for _, b := range data {
+			w.setRXByte(n, s.rxWR, b)
+			s.rxWR++
+		}
+		w.setReg16(base+w5100SnRXRSR, uint16(rsr+len(data)))
+		w.mem[base+w5100SnIR] |= w5100IntRecv
+	}
+}
+
+// destination returns socket n's destination address.
+func (w *w5100) destination(n int) string {
+	base := w.socketBase(n)
+	ip := net.IP(w.mem[base+w5100SnDIPR : base+w5100SnDIPR+4])
+	return fmt.Sprintf("%s:%d", ip, w.reg16(base+w5100SnDPORT))
+}
+
+// connect starts a TCP connection to socket n's destination.
+func (w *w5100) connect(n int) {
+	addr := w.destination(n)
+	w.setStatus(n, w5100StatusSynSent)
+	w.mu.Lock()
+	gen := w.sockets[n].gen
+	w.mu.Unlock()
+
+	go func() {
+		conn, err := net.DialTimeout("tcp", addr, w5100ConnectTimeout)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			w.hostEvent(n, gen, w5100IntTimeout, w5100StatusClosed, true)
+			return
+		}
+		if !w.hostEvent(n, gen, w5100IntCon, w5100StatusEstablished, true) {
+			conn.Close()
+			return
+		}
+		w.attach(n, gen, conn)
+	}()
+}
+
+// listen waits for a TCP connection on socket n's port.
+func (w *w5100) listen(n int) {
+	port := w.reg16(w.socketBase(n) + w5100SnPORT)
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		w.setStatus(n, w5100StatusClosed)
+		w.mem[w.socketBase(n)+w5100SnIR] |= w5100IntTimeout
+		return
+	}
+	w.setStatus(n, w5100StatusListen)
+	w.mu.Lock()
+	s := &w.sockets[n]
+	s.listener = l
+	gen := s.gen
+	w.mu.Unlock()
+
+	go func() {
+		conn, err := l.Accept()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		s := &w.sockets[n]
+		if err != nil || s.gen != gen {
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		s.listener = nil
+		l.Close()
+		w.hostEvent(n, gen, w5100IntCon, w5100StatusEstablished, true)
+		w.attach(n, gen, conn)
+	}()
+}
+
+// attach connects socket n to an established TCP connection and starts
+// the goroutines that move its data. The caller must hold the mutex.
+func (w *w5100) attach(n, gen int, conn net.Conn) {
+	s := &w.sockets[n]
+	tx := make(chan []byte, 64)
+	s.conn, s.tx = conn, tx
+
+	go func() {
+		for b := range tx {
+			if _, err := conn.Write(b); err != nil {
+				break
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n2, err := conn.Read(buf)
+			w.mu.Lock()
+			if w.sockets[n].gen != gen {
+				w.mu.Unlock()
+				return
+			}
+			if n2 > 0 {
+				w.sockets[n].rx = append(w.sockets[n].rx, append([]byte(nil), buf[:n2]...))
+			}
+			if err != nil {
+				w.hostEvent(n, gen, w5100IntDiscon, w5100StatusCloseWait, true)
+			}
+			w.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// openUDP binds socket n to its port on the host. If the port is in use or
+// privileged, the socket is bound to any free port instead.
+func (w *w5100) openUDP(n int) {
+	port := w.reg16(w.socketBase(n) + w5100SnPORT)
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		udp, err = net.ListenUDP("udp4", &net.UDPAddr{})
+	}
+	if err != nil {
+		return
+	}
+	w.setStatus(n, w5100StatusUDP)
+	w.mu.Lock()
+	s := &w.sockets[n]
+	s.udp = udp
+	gen := s.gen
+	w.mu.Unlock()
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			size, from, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			ip := from.IP.To4()
+			if ip == nil {
+				continue
+			}
+			// Each packet is preceded by the sender's address and port and
+			// the length of the data.
+			p := make([]byte, 8+size)
+			copy(p, ip)
+			binary.BigEndian.PutUint16(p[4:], uint16(from.Port))
+			binary.BigEndian.PutUint16(p[6:], uint16(size))
+			copy(p[8:], buf[:size])
+			w.mu.Lock()
+			if w.sockets[n].gen != gen {
+				w.mu.Unlock()
+				return
+			}
+			w.sockets[n].rx = append(w.sockets[n].rx, p)
+			w.mu.Unlock()
+		}
+	}()
+}
+
+// send sends the data between socket n's TX read and write pointers.
+func (w *w5100) send(n int) {
+	base := w.socketBase(n)
+	rd, wr := w.reg16(base+w5100SnTXRD), w.reg16(base+w5100SnTXWR)
+	data := make([]byte, 0, wr-rd)
+	for p := rd; p != wr; p++ {
+		data = append(data, w.txByte(n, p))
+	}
+	w.setReg16(base+w5100SnTXRD, wr)
+
+	w.mu.Lock()
+	s := &w.sockets[n]
+	tx, udp := s.tx, s.udp
+	w.mu.Unlock()
+
+	switch {
+	case tx != nil:
+		select {
+		case tx <- data:
+		default:
+			w.mem[base+w5100SnIR] |= w5100IntTimeout
+			return
+		}
+	case udp != nil:
+		addr, err := net.ResolveUDPAddr("udp4", w.destination(n))
+		if err == nil {
+			_, err = udp.WriteToUDP(data, addr)
+		}
+		if err != nil {
+			w.mem[base+w5100SnIR] |= w5100IntTimeout
+			return
+		}
+	}
+	w.mem[base+w5100SnIR] |= w5100IntSendOK
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A watchKind is a bit mask of the kinds of memory access a watchpoint
+// detects.
+type watchKind byte
+
+const (
+	watchRead watchKind = 1 << iota
+	watchWrite
+	watchExec
+)
+
+func (k watchKind) String() string {
+	const names = "rwx"
+	var b strings.Builder
+	for i := range names {
+		if k&(1<<uint(i)) != 0 {
+			b.WriteByte(names[i])
+		}
+	}
+	return b.String()
+}
+
+// parseWatchKind converts a string of r, w and x characters into a
+// watchKind.
+func parseWatchKind(s string) (watchKind, bool) {
+	var k watchKind
+	for _, c := range strings.ToLower(s) {
+		switch c {
+		case 'r':
+			k |= watchRead
+		case 'w':
+			k |= watchWrite
+		case 'x':
+			k |= watchExec
+		default:
+			return 0, false
+		}
+	}
+	return k, k != 0
+}
+
+// A watchpoint detects accesses to a range of addresses. A watchpoint
+// either stops the CPU or logs each access.
+type watchpoint struct {
+	start, end uint16 // inclusive address range
+	kind       watchKind
+	log        bool // true = log accesses instead of stopping
+}
+
+func (wp *watchpoint) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%04X", wp.start)
+	if wp.end != wp.start {
+		fmt.Fprintf(&b, "-%04X", wp.end)
+	}
+	fmt.Fprintf(&b, " %s", wp.kind)
+	if wp.log {
+		b.WriteString(" log")
+	}
+	return b.String()
+}
+
+// A watchHit describes an access that triggered a watchpoint.
+type watchHit struct {
+	addr  uint16
+	kind  watchKind
+	value byte   // value read or written
+	pc    uint16 // address of the accessing instruction
+	cycle uint64
+}
+
+func (h *watchHit) String() string {
+	switch h.kind {
+	case watchExec:
+		return fmt.Sprintf("%04X x at cycle %d", h.addr, h.cycle)
+	default:
+		return fmt.Sprintf("%04X %s %02X by %04X at cycle %d", h.addr, h.kind, h.value, h.pc, h.cycle)
+	}
+}
+
+// The watchList holds the mmu's watchpoints. Memory accesses are checked
+// against it only for pages holding watchpoints, and not at all when the
+// list is empty.
+type watchList struct {
+	apple2 *apple2
+
+	points []watchpoint
+	pages  [256]bool // true = the page holds a watchpoint
+	hit    *watchHit // access that stopped the CPU, until reported
+	log    io.Writer // destination of logged accesses
+}
+
+func newWatchList(apple2 *apple2) *watchList {
+	return &watchList{
+		apple2: apple2,
+		log:    io.Discard,
+	}
+}
+
+// Add adds a watchpoint.
+func (wl *watchList) Add(wp watchpoint) {
+	wl.points = append(wl.points, wp)
+	wl.update()
+}
+
+// Remove removes the watchpoints whose ranges include addr.
+func (wl *watchList) Remove(addr uint16) {
+	n := 0
+	for _, wp := range wl.points {
+		if addr < wp.start || addr > wp.end {
+			wl.points[n] = wp
+			n++
+		}
+	}
+	wl.points = wl.points[:n]
+	wl.update()
+}
+
+// Delete removes a watchpoint identical to wp.
+func (wl *watchList) Delete(wp watchpoint) {
+	for i, p := range wl.points {
+		if p == wp {
+			wl.points = append(wl.points[:i], wl.points[i+1:]...)
+			wl.update()
+			return
+		}
+	}
+}
+
+// Clear removes all watchpoints.
+func (wl *watchList) Clear() {
+	wl.points = wl.points[:0]
+	wl.update()
+}
+
+// SetLog sets the writer to which logged accesses are written.
+func (wl *watchList) SetLog(w io.Writer) {
+	wl.log = w
+}
+
+// update recalculates the watched pages and installs or removes the list
+// from the mmu.
+func (wl *watchList) update() {
+	wl.pages = [256]bool{}
+	for _, wp := range wl.points {
+		for p := int(wp.start >> 8); p <= int(wp.end>>8); p++ {
+			wl.pages[p] = true
+		}
+	}
+
+	m := wl.apple2.mmu
+	if len(wl.points) > 0 {
+		m.watch = wl
+	} else {
+		m.watch = nil
+	}
+}
+
+// check tests an access against the watchpoints. It returns true if the
+// access stopped the CPU.
+func (wl *watchList) check(addr uint16, kind watchKind, v byte) bool {
+	if !wl.pages[addr>>8] {
+		return false
+	}
+
+	stop := false
+	cpu := wl.apple2.cpu
+	for i := range wl.points {
+		wp := &wl.points[i]
+		if addr < wp.start || addr > wp.end || wp.kind&kind == 0 {
+			continue
+		}
+
+		hit := watchHit{addr: addr, kind: kind, value: v, pc: cpu.LastPC, cycle: cpu.Cycles}
+		if wp.log {
+			fmt.Fprintln(wl.log, hit.String())
+		} else if wl.hit == nil {
+			wl.hit = &hit
+			stop = true
+		}
+	}
+	return stop
+}
+
+// Watchpoints returns the mmu's watchpoints, creating the list if
+// necessary.
+func (m *mmu) Watchpoints() *watchList {
+	if m.watchList == nil {
+		m.watchList = newWatchList(m.apple2)
+	}
+	return m.watchList
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWatchpoints(t *testing.T) {
+	a := newApple2()
+	a.mmu.StoreBytes(0x0300, []byte{
+		0xad, 0x00, 0x04, // 0300: LDA $0400
+		0x8d, 0x01, 0x04, // 0303: STA $0401
+		0xad, 0x30, 0xc0, // 0306: LDA $C030
+		0x4c, 0x00, 0x03, // 0309: JMP $0300
+	})
+	a.cpu.SetPC(0x0300)
+
+	d := a.AttachDebugger()
+	var out bytes.Buffer
+	exec := func(cmd string) {
+		if err := d.Exec(cmd, &out); err != nil {
+			t.Fatalf("%s: %v\n", cmd, err)
+		}
+	}
+
+	// A read watchpoint stops the CPU after the reading instruction.
+	exec("w 400 r")
+	exec("g")
+	a.RunFrame()
+	if !d.Stopped() || a.cpu.Reg.PC != 0x0303 || !strings.HasPrefix(d.reason, "watchpoint: 0400 r 00 by 0300") {
+		t.Errorf("Read watch: got PC %04X %q\n", a.cpu.Reg.PC, d.reason)
+	}
+
+	// Writes don't trigger a read watchpoint.
+	exec("wc 400")
+	exec("w 3ff-401 w")
+	exec("g")
+	a.RunFrame()
+	if a.cpu.Reg.PC != 0x0306 || !strings.HasPrefix(d.reason, "watchpoint: 0401 w 00 by 0303") {
+		t.Errorf("Write watch: got PC %04X %q\n", a.cpu.Reg.PC, d.reason)
+	}
+
+	// Soft-switch accesses are logged without stopping.
+	exec("wc *")
+	exec("w c030 log")
+	exec("w 309 x")
+	out.Reset()
+	exec("g")
+	a.RunFrame()
+	if a.cpu.Reg.PC != 0x0309 || !strings.HasPrefix(d.reason, "watchpoint: 0309 x") {
+		t.Errorf("Exec watch: got PC %04X %q\n", a.cpu.Reg.PC, d.reason)
+	}
+	if !strings.HasPrefix(out.String(), "C030 r 00 by 0306") {
+		t.Errorf("Unexpected watch log %q\n", out.String())
+	}
+
+	out.Reset()
+	exec("wl")
+	if out.String() != "C030 rw log\n0309 x\n" {
+		t.Errorf("Unexpected watch list %q\n", out.String())
+	}
+
+	// Debugger memory accesses don't trigger watchpoints.
+	exec("wc *")
+	exec("w 400")
+	exec("m 400 4")
+	exec("e 400 12")
+	if a.mmu.watchList.hit != nil {
+		t.Errorf("Debugger access triggered a watchpoint\n")
+	}
+
+	exec("wc *")
+	if a.mmu.watch != nil {
+		t.Errorf("Watch list still installed after clearing\n")
+	}
+}
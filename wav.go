@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const wavHeaderSize = 44
+
+// A wavWriter writes 16-bit mono PCM samples to a WAV file. The header's
+// size fields are unknown until recording ends, so they are patched when
+// the writer is closed.
+type wavWriter struct {
+	w    io.WriteSeeker
+	n    uint32 // number of data bytes written
+	buf  []byte // scratch buffer for sample encoding
+	err  error  // first error encountered
+	rate uint32 // sample rate in Hz
+}
+
+func newWAVWriter(w io.WriteSeeker, rate int) (*wavWriter, error) {
+	ww := &wavWriter{w: w, rate: uint32(rate)}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// WriteSamples appends samples to the WAV data chunk.
+func (ww *wavWriter) WriteSamples(p []int16) error {
+	if ww.err != nil {
+		return ww.err
+	}
+
+	if cap(ww.buf) < len(p)*2 {
+		ww.buf = make([]byte, len(p)*2)
+	}
+	b := ww.buf[:len(p)*2]
+	for i, s := range p {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(s))
+	}
+
+	_, ww.err = ww.w.Write(b)
+	ww.n += uint32(len(b))
+	return ww.err
+}
+
+// Close finalizes the WAV header. It does not close the underlying writer.
+func (ww *wavWriter) Close() error {
+	if ww.err != nil {
+		return ww.err
+	}
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.writeHeader(); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (ww *wavWriter) writeHeader() error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+		blockAlign    = channels * bitsPerSample / 8
+	)
+
+	var h [wavHeaderSize]byte
+	le := binary.LittleEndian
+	copy(h[0:], "RIFF")
+	le.PutUint32(h[4:], 36+ww.n)
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	le.PutUint32(h[16:], 16) // fmt chunk size
+	le.PutUint16(h[20:], 1)  // PCM format
+	le.PutUint16(h[22:], channels)
+	le.PutUint32(h[24:], ww.rate)
+	le.PutUint32(h[28:], ww.rate*blockAlign)
+	le.PutUint16(h[32:], blockAlign)
+	le.PutUint16(h[34:], bitsPerSample)
+	copy(h[36:], "data")
+	le.PutUint32(h[40:], ww.n)
+
+	_, err := ww.w.Write(h[:])
+	return err
+}
+
+var errNotRecording = errors.New("audio recording not in progress")
+
+var (
+	errWAVFormat      = errors.New("not a RIFF/WAVE file")
+	errWAVUnsupported = errors.New("unsupported WAV encoding (only 8/16-bit PCM is supported)")
+)
+
+// wavMaxFmtSize is the largest fmt chunk readWAV accepts. The largest
+// standard one, WAVE_FORMAT_EXTENSIBLE, is 40 bytes.
+const wavMaxFmtSize = 256
+
+// readWAV decodes a PCM WAV file, mixing all channels down to a single
+// channel of samples in the range -1..1. It returns the samples and the
+// sample rate.
+func readWAV(r io.Reader) (samples []float32, rate int, err error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, 0, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, 0, errWAVFormat
+	}
+
+	le := binary.LittleEndian
+	var channels, bits int
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			return nil, 0, err
+		}
+		// Chunks are padded to an even size. The sizes come from the
+		// file, so a chunk is read only as far as the file goes, rather
+		// than allocated up front.
+		size := int64(le.Uint32(ch[4:]))
+		padded := size + size&1
+
+		switch string(ch[0:4]) {
+		case "fmt ":
+			if size < 16 || size > wavMaxFmtSize {
+				return nil, 0, errWAVFormat
+			}
+			body := make([]byte, padded)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, 0, err
+			}
+			if le.Uint16(body[0:]) != 1 {
+				return nil, 0, errWAVUnsupported
+			}
+			channels = int(le.Uint16(body[2:]))
+			rate = int(le.Uint32(body[4:]))
+			bits = int(le.Uint16(body[14:]))
+			if channels < 1 || rate == 0 || (bits != 8 && bits != 16) {
+				return nil, 0, errWAVUnsupported
+			}
+
+		case "data":
+			if channels == 0 {
+				return nil, 0, errWAVFormat
+			}
+			body, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil {
+				return nil, 0, err
+			}
+			if int64(len(body)) < size {
+				return nil, 0, io.ErrUnexpectedEOF
+			}
+			frame := channels * bits / 8
+			samples = make([]float32, len(body)/frame)
+			for i := range samples {
+				var sum float32
+				for c := 0; c < channels; c++ {
+					off := i*frame + c*bits/8
+					if bits == 8 {
+						sum += (float32(body[off]) - 128) / 128
+					} else {
+						sum += float32(int16(le.Uint16(body[off:]))) / 32768
+					}
+				}
+				samples[i] = sum / float32(channels)
+			}
+			return samples, rate, nil
+
+		default:
+			n, err := io.CopyN(io.Discard, r, padded)
+			if n < size {
+				return nil, 0, io.ErrUnexpectedEOF
+			}
+			if err != nil && err != io.EOF {
+				return nil, 0, err
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// apple2go.js runs the emulator, compiled to WebAssembly, in index.html.
+// The model can be chosen with a query parameter, as in ?model=iic.
+"use strict";
+
+const status = document.getElementById("status");
+
+// readFile resolves to the contents of the next file chosen with a file
+// input, as a Uint8Array.
+function readFile(input) {
+  return new Promise(resolve => {
+    input.addEventListener("change", async () => {
+      resolve(new Uint8Array(await input.files[0].arrayBuffer()));
+    }, { once: true });
+  });
+}
+
+// loadROMs resolves to the zip file of ROM images served as roms.zip, or
+// if there is none, to one the user chooses.
+async function loadROMs() {
+  try {
+    const resp = await fetch("roms.zip");
+    if (resp.ok) {
+      return new Uint8Array(await resp.arrayBuffer());
+    }
+  } catch (e) {
+    // Fall back to asking for the file.
+  }
+  document.getElementById("roms-picker").hidden = false;
+  status.textContent = "Choose a zip file of ROM images to start.";
+  return readFile(document.getElementById("roms"));
+}
+
+// startAudio starts playing the machine's audio. Browsers allow audio to
+// start only in response to the user, so it's called on the first key
+// press or click.
+let audio = null;
+function startAudio() {
+  if (audio) {
+    return;
+  }
+  audio = new AudioContext({ sampleRate: 44100 });
+  const node = audio.createScriptProcessor(1024, 0, 1);
+  const bytes = new Uint8Array(2 * 1024);
+  const samples = new Int16Array(bytes.buffer);
+  node.onaudioprocess = e => {
+    apple2go.audio(bytes);
+    const out = e.outputBuffer.getChannelData(0);
+    for (let i = 0; i < out.length; i++) {
+      out[i] = samples[i] / 32768;
+    }
+  };
+  node.connect(audio.destination);
+}
+
+async function main() {
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(fetch("apple2go.wasm"), go.importObject);
+  go.run(instance);
+
+  const model = new URLSearchParams(location.search).get("model") || "";
+  const err = apple2go.start(await loadROMs(), model);
+  if (err) {
+    status.textContent = err;
+    return;
+  }
+  document.getElementById("roms-picker").hidden = true;
+  status.textContent = "";
+
+  const canvas = document.getElementById("screen");
+  const ctx = canvas.getContext("2d");
+  const image = ctx.createImageData(apple2go.width, apple2go.height);
+  const pixels = new Uint8Array(image.data.buffer);
+  function draw() {
+    apple2go.frame();
+    apple2go.screen(pixels);
+    ctx.putImageData(image, 0, 0);
+    requestAnimationFrame(draw);
+  }
+  requestAnimationFrame(draw);
+
+  for (const type of ["keydown", "keyup"]) {
+    document.addEventListener(type, e => {
+      if (e.target.tagName === "INPUT") {
+        return;
+      }
+      startAudio();
+      if (apple2go.key(e.code, type === "keydown")) {
+        e.preventDefault();
+      }
+    });
+  }
+  document.addEventListener("click", startAudio);
+
+  for (const drive of [1, 2]) {
+    const input = document.getElementById("disk" + drive);
+    input.addEventListener("change", async () => {
+      const file = input.files[0];
+      const err = apple2go.insertDisk(drive, file.name, new Uint8Array(await file.arrayBuffer()));
+      status.textContent = err || "";
+      input.blur();
+    });
+  }
+  document.getElementById("reset").addEventListener("click", e => { apple2go.reset(""); e.target.blur(); });
+  document.getElementById("cold").addEventListener("click", e => { apple2go.reset("cold"); e.target.blur(); });
+}
+
+main().catch(e => { status.textContent = e.message; });
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// WOZ image layout. A WOZ file holds the bits recorded on each track
+// rather than bytes, so it can represent a disk exactly. The file header
+// is followed by chunks, each with a four-letter ID and a 32-bit length:
+// INFO describes the disk, TMAP maps each quarter track position to a
+// track in TRKS, and TRKS holds the tracks. In version 2 files, TRKS
+// begins with a table of the tracks' positions in the file, and their
+// data starts at block 3. In version 1 files, each track takes a fixed
+// 6656 bytes. The emulator doesn't run WOZ images yet; they are converted
+// to and from the other formats, for use with other emulators and disk
+// writing hardware.
+const (
+	wozHeader      = "WOZ2\xff\n\r\n"
+	woz1Header     = "WOZ1\xff\n\r\n"
+	wozInfoSize    = 60
+	wozQuarters    = 160 // entries in the quarter track map
+	wozTrackBlock  = 3   // first block of the track data
+	wozCreator     = "apple2go"
+	wozBitTiming   = 32 // 4 µs per bit, in 125 ns units
+	wozSyncPadding = 2  // zero bits after each sync byte
+	woz1TrackSize  = 6656
+	woz1BitsOffset = 6648 // offset of a version 1 track's bit count
+)
+
+var errWOZImage = errors.New("WOZ image is corrupt")
+
+// wozImage returns the WOZ image of a 16- or 13-sector 5.25" disk. The
+// FF sync bytes are written as self-syncing 10-bit nibbles; the extra
+// zero bits after the FF nibbles in data fields are skipped by the
+// controller, so they read correctly too.
+func wozImage(disk *diskImage) []byte {
+	// Whole tracks are visible a quarter track to either side, unless
+	// there is a track recorded there.
+	var tmap [wozQuarters]byte
+	for i := range tmap {
+		tmap[i] = 0xff
+	}
+	var nibs [][]byte
+	for t, nib := range disk.tracks {
+		for qt := 4*t - 1; qt <= 4*t+1; qt++ {
+			if qt >= 0 && qt < wozQuarters {
+				tmap[qt] = byte(len(nibs))
+			}
+		}
+		nibs = append(nibs, nib)
+	}
+	for _, qt := range disk.quarterTracks() {
+		if qt < wozQuarters {
+			tmap[qt] = byte(len(nibs))
+			nibs = append(nibs, disk.quarters[qt])
+		}
+	}
+
+	var tracks [][]byte
+	var bits []int
+	largest := 0
+	for _, nib := range nibs {
+		var w bitWriter
+		for _, b := range nib {
+			w.write(uint32(b), 8)
+			if b == 0xff {
+				w.write(0, wozSyncPadding)
+			}
+		}
+		tracks = append(tracks, w.data)
+		bits = append(bits, w.n)
+		if blocks := (len(w.data) + 511) / 512; blocks > largest {
+			largest = blocks
+		}
+	}
+
+	var info [wozInfoSize]byte
+	info[0] = 2 // INFO version
+	info[1] = 1 // 5.25" disk
+	info[4] = 1 // cleaned: no bits from the MC3470's random noise
+	copy(info[5:37], wozCreator+strings.Repeat(" ", 32))
+	info[37] = 1 // sides
+	info[38] = 1 // 16-sector boot sector
+	if disk.format == diskFormatD13 {
+		info[38] = 2
+	}
+	info[39] = wozBitTiming
+	binary.LittleEndian.PutUint16(info[44:], uint16(largest))
+
+	var trks bytes.Buffer
+	block := wozTrackBlock
+	for t, data := range tracks {
+		blocks := (len(data) + 511) / 512
+		binary.Write(&trks, binary.LittleEndian, uint16(block))
+		binary.Write(&trks, binary.LittleEndian, uint16(blocks))
+		binary.Write(&trks, binary.LittleEndian, uint32(bits[t]))
+		block += blocks
+	}
+	trks.Write(make([]byte, wozQuarters*8-trks.Len()))
+	for _, data := range tracks {
+		trks.Write(data)
+		trks.Write(make([]byte, -len(data)&511))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(wozHeader)
+	buf.Write(make([]byte, 4)) // CRC
+	for _, c := range []struct {
+		id   string
+		data []byte
+	}{{"INFO", info[:]}, {"TMAP", tmap[:]}, {"TRKS", trks.Bytes()}} {
+		buf.WriteString(c.id)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(c.data)))
+		buf.Write(c.data)
+	}
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint32(b[len(wozHeader):], crc32.ChecksumIEEE(b[len(wozHeader)+4:]))
+	return b
+}
+
+// loadWOZ reads a version 1 or 2 WOZ image of a 5.25" disk. The bits of
+// each track are read back into nibbles the way the controller reads
+// them, dropping the zero bits between nibbles, so timing information
+// and any flux transitions that don't form nibbles are lost. Tracks that
+// aren't at whole track positions are kept in the disk's quarters map.
+func loadWOZ(data []byte) (*diskImage, error) {
+	version := 2
+	switch {
+	case len(data) >= 12 && string(data[:8]) == woz1Header:
+		version = 1
+	case len(data) >= 12 && string(data[:8]) == wozHeader:
+	default:
+		return nil, errDiskFormat
+	}
+	if crc := binary.LittleEndian.Uint32(data[8:]); crc != 0 && crc != crc32.ChecksumIEEE(data[12:]) {
+		return nil, errWOZImage
+	}
+
+	chunks := make(map[string][]byte)
+	for p := 12; p+8 <= len(data); {
+		id, n := string(data[p:p+4]), int(binary.LittleEndian.Uint32(data[p+4:]))
+		p += 8
+		if n > len(data)-p {
+			return nil, errWOZImage
+		}
+		chunks[id] = data[p : p+n]
+		p += n
+	}
+	info, tmap, trks := chunks["INFO"], chunks["TMAP"], chunks["TRKS"]
+	if len(info) < 2 || len(tmap) < wozQuarters || trks == nil {
+		return nil, errWOZImage
+	}
+	if info[1] != 1 {
+		return nil, errors.New("WOZ image isn't a 5.25\" disk")
+	}
+
+	// track returns the bits of a track and their number.
+	track := func(i int) ([]byte, int, bool) {
+		if version == 1 {
+			if (i+1)*woz1TrackSize > len(trks) {
+				return nil, 0, false
+			}
+			t := trks[i*woz1TrackSize : (i+1)*woz1TrackSize]
+			n := int(binary.LittleEndian.Uint16(t[woz1BitsOffset:]))
+			return t, n, n <= 8*woz1BitsOffset
+		}
+		if (i+1)*8 > len(trks) {
+			return nil, 0, false
+		}
+		e := trks[i*8:]
+		start := int(binary.LittleEndian.Uint16(e)) * 512
+		size := int(binary.LittleEndian.Uint16(e[2:])) * 512
+		n := int(binary.LittleEndian.Uint32(e[4:]))
+		if start+size > len(data) || n > 8*size {
+			return nil, 0, false
+		}
+		return data[start : start+size], n, true
+	}
+
+	d := &diskImage{format: diskFormatNIB, volume: defaultVolume}
+	if len(info) > 38 && info[38] == 2 {
+		d.format = diskFormatD13
+	}
+	decoded := make(map[byte][]byte)
+	for qt := 0; qt < wozQuarters && qt <= maxQuarterTrack; qt++ {
+		i := tmap[qt]
+		if i == 0xff {
+			continue
+		}
+		nib, ok := decoded[i]
+		if !ok {
+			bits, n, ok := track(int(i))
+			if !ok {
+				return nil, errWOZImage
+			}
+			nib = wozNibbles(bits, n)
+			decoded[i] = nib
+		}
+
+		// A whole track's data also appears a quarter track to either
+		// side; only data that differs from its neighbors' is kept.
+		switch {
+		case qt%4 == 0:
+			d.tracks[qt/4] = nib
+		case qt%4 == 2 || !wozSameTrack(tmap[:], qt, i):
+			if d.quarters == nil {
+				d.quarters = make(map[int][]byte)
+			}
+			d.quarters[qt] = nib
+		}
+	}
+	for t := range d.tracks {
+		if d.tracks[t] == nil {
+			d.tracks[t] = appendSync(make([]byte, 0, nibTrackSize), nibTrackSize)
+		}
+	}
+	return d, nil
+}
+
+// wozSameTrack reports whether the track mapped to a quarter track next
+// to a whole track is that whole track's.
+func wozSameTrack(tmap []byte, qt int, i byte) bool {
+	whole := (qt + 1) / 4 * 4
+	return tmap[whole] == i
+}
+
+// wozNibbles reads the nibbles from the bits of a track, as the
+// controller's shift register would: the register fills until its high
+// bit is set, and then holds a nibble. The track is read around twice so
+// the register is in step with the nibbles by the time the nibbles of one
+// revolution, starting at the first bit, are kept.
+func wozNibbles(bits []byte, n int) []byte {
+	var nib []byte
+	var reg byte
+	for i := 0; i < 2*n; i++ {
+		j := i % n
+		bit := bits[j/8] >> uint(7-j%8) & 1
+		if reg == 0 && bit == 0 {
+			continue
+		}
+		reg = reg<<1 | bit
+		if reg&0x80 != 0 {
+			if i >= n {
+				nib = append(nib, reg)
+			}
+			reg = 0
+		}
+	}
+	return nib
+}
+
+// A bitWriter packs bits into bytes, most significant bit first.
+type bitWriter struct {
+	data []byte
+	n    int // number of bits written
+}
+
+// write writes the low count bits of v.
+func (w *bitWriter) write(v uint32, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v>>uint(i)&1 != 0 {
+			w.data[w.n/8] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import "errors"
+
+// ZIP CHIP registers, relative to $C000. They replace the annunciator
+// switches while the chip is unlocked.
+const (
+	zipRegDisable = 0x5b // write: run at 1 MHz; read: status
+	zipRegLock    = 0x5a // write: unlock sequence, lock or enable
+	zipRegSlots   = 0x5c // slots and speaker that slow the CPU
+	zipRegSpeed   = 0x5d // speed, in sixteenths of full speed below it
+	zipRegFlags   = 0x5f // option flags
+)
+
+// ZIP CHIP unlock and lock values, written to $C05A.
+const (
+	zipUnlockValue = 0x5a // written four times to unlock the registers
+	zipLockValue   = 0xa5
+	zipUnlockCount = 4
+)
+
+// ZIP CHIP status and option bits.
+const (
+	zipStatusClock    = 1 << 7 // toggles every millisecond
+	zipStatusDisabled = 1 << 5 // acceleration is off
+	zipStatusCache    = 3      // cache size code
+
+	zipFlagNoPaddleDelay = 1 << 6 // paddle reads don't slow the CPU
+
+	zipSlotSpeaker = 1 << 0 // speaker accesses slow the CPU
+)
+
+const (
+	zipMaxSpeed       = 8    // full speed, as a multiple of 1.023 MHz
+	zipDefaultSlots   = 0x41 // the speaker and slot 6, for disk timing
+	zipSlowdownCycles = 5102 // about 5 ms at 1.023 MHz
+	zipClockCycles    = 1020 // about 1 ms at 1.023 MHz
+)
+
+var errZipModel = errors.New("the ZIP CHIP requires a model with expansion slots")
+
+// A zipChip emulates a ZIP CHIP-style accelerator, which replaces the CPU
+// with a faster one and caches memory so that programs run up to eight
+// times faster. Software detects and configures it through registers at
+// $C058..$C05F, which appear after $5A is written to $C05A four times and
+// disappear again when $A5 is written there.
+//
+// Timing-sensitive hardware still needs 1 MHz timing, so accessing the
+// speaker, triggering the paddle timers or accessing the I/O space of a
+// slot selected in the slot register slows the CPU to 1 MHz for about 5
+// ms. The machine's clock counts 1 MHz cycles, so an accelerated
+// instruction advances it by a fraction of its cycle count.
+type zipChip struct {
+	apple2 *apple2
+
+	unlock    int    // $5A writes so far in the unlock sequence
+	unlocked  bool   // the registers are visible
+	enabled   bool   // the CPU runs accelerated
+	speed     byte   // speed register
+	slots     byte   // slot and speaker slowdown mask
+	flags     byte   // option flags
+	slowUntil uint64 // machine cycle at which a slowdown ends
+	frac      uint64 // accelerated cycles not yet counted on the clock
+}
+
+func newZipChip(apple2 *apple2) *zipChip {
+	z := &zipChip{apple2: apple2}
+	z.Reset()
+	return z
+}
+
+// Reset restores the power-up settings: locked and at full speed.
+func (z *zipChip) Reset() {
+	z.unlock, z.unlocked = 0, false
+	z.enabled = true
+	z.speed = 0
+	z.slots = zipDefaultSlots
+	z.flags = 0
+	z.slowUntil = 0
+	z.frac = 0
+}
+
+// Speed returns the current speed, in sixteenths of 1.023 MHz.
+func (z *zipChip) Speed() uint64 {
+	if !z.enabled || z.apple2.cpu.Cycles < z.slowUntil {
+		return 16
+	}
+	s := uint64(zipMaxSpeed * (16 - int(z.speed>>4)))
+	if s < 16 {
+		s = 16
+	}
+	return s
+}
+
+// accelerate rescales the cycles taken by the instruction that began at
+// a machine cycle, so that the clock advances at 1 MHz while the CPU
+// runs faster.
+func (z *zipChip) accelerate(start uint64) {
+	s := z.Speed()
+	if s == 16 {
+		return
+	}
+	c := z.apple2.cpu
+	total := (c.Cycles-start)*16 + z.frac
+	c.Cycles = start + total/s
+	z.frac = total % s
+}
+
+// slowDown runs the CPU at 1 MHz for a while.
+func (z *zipChip) slowDown() {
+	z.slowUntil = z.apple2.cpu.Cycles + zipSlowdownCycles
+}
+
+// SpeakerAccess slows the CPU when the speaker is toggled.
+func (z *zipChip) SpeakerAccess() {
+	if z.slots&zipSlotSpeaker != 0 {
+		z.slowDown()
+	}
+}
+
+// PaddleAccess slows the CPU when the paddle timers are triggered.
+func (z *zipChip) PaddleAccess() {
+	if z.flags&zipFlagNoPaddleDelay == 0 {
+		z.slowDown()
+	}
+}
+
+// SlotAccess slows the CPU when a selected slot's I/O space is accessed.
+func (z *zipChip) SlotAccess(slot int) {
+	if z.slots&(1<<uint(slot)) != 0 {
+		z.slowDown()
+	}
+}
+
+// LoadSwitch handles a read of $C058..$C05F. It returns false if the
+// registers are locked, so the annunciators handle it instead.
+func (z *zipChip) LoadSwitch(addr uint16) (byte, bool) {
+	if !z.unlocked {
+		return 0, false
+	}
+	switch addr {
+	case zipRegDisable:
+		v := byte(zipStatusCache)
+		if (z.apple2.cpu.Cycles/zipClockCycles)&1 != 0 {
+			v |= zipStatusClock
+		}
+		if !z.enabled {
+			v |= zipStatusDisabled
+		}
+		return v, true
+	case zipRegSlots:
+		return z.slots, true
+	case zipRegSpeed:
+		return z.speed, true
+	case zipRegFlags:
+		return z.flags, true
+	}
+	return z.apple2.vs.FloatingBus(), true
+}
+
+// StoreSwitch handles a write to $C058..$C05F. It returns false if the
+// annunciators should handle it.
+func (z *zipChip) StoreSwitch(addr uint16, v byte) bool {
+	if !z.unlocked {
+		if addr == zipRegLock {
+			if v == zipUnlockValue {
+				z.unlock++
+				z.unlocked = z.unlock == zipUnlockCount
+			} else {
+				z.unlock = 0
+			}
+		}
+		return false
+	}
+
+	switch addr {
+	case zipRegLock:
+		switch v {
+		case zipLockValue:
+			z.unlocked, z.unlock = false, 0
+		case zipUnlockValue:
+		default:
+			z.enabled = true
+		}
+	case zipRegDisable:
+		z.enabled = false
+	case zipRegSlots:
+		z.slots = v
+	case zipRegSpeed:
+		z.speed = v
+	case zipRegFlags:
+		z.flags = v
+	}
+	return true
+}
+
+// EnableZipChip installs a ZIP CHIP accelerator.
+func (a *apple2) EnableZipChip() error {
+	if !a.cfg.slots {
+		return errZipModel
+	}
+	if a.zip == nil {
+		a.zip = newZipChip(a)
+	}
+	return nil
+}
+
+func (z *zipChip) saveState(sw *stateWriter) {
+	sw.Tag("ZIP ")
+	sw.Int(z.unlock)
+	for _, b := range []bool{z.unlocked, z.enabled} {
+		sw.Bool(b)
+	}
+	for _, b := range []byte{z.speed, z.slots, z.flags} {
+		sw.Byte(b)
+	}
+	sw.Uint64(z.slowUntil)
+	sw.Uint64(z.frac)
+}
+
+func (z *zipChip) loadState(sr *stateReader) {
+	sr.Tag("ZIP ")
+	z.unlock = sr.Int()
+	for _, b := range []*bool{&z.unlocked, &z.enabled} {
+		*b = sr.Bool()
+	}
+	for _, b := range []*byte{&z.speed, &z.slots, &z.flags} {
+		*b = sr.Byte()
+	}
+	z.slowUntil = sr.Uint64()
+	z.frac = sr.Uint64()
+}
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+func TestZipChip(t *testing.T) {
+	if err := newApple2Model(modelIIc).EnableZipChip(); err == nil {
+		t.Errorf("Expected an error installing a ZIP CHIP in a IIc\n")
+	}
+
+	a := newApple2()
+	if err := a.EnableZipChip(); err != nil {
+		t.Fatalf("EnableZipChip: %v\n", err)
+	}
+	z := a.zip
+
+	// While locked, the registers are the annunciators.
+	a.mmu.StoreByte(0xc05d, 0x50)
+	if a.mmu.LoadByte(0xc05d) == 0x50 || !a.iou.testSoftSwitch(ioSwitchANNUNCIATOR2) {
+		t.Errorf("Expected the annunciators while locked\n")
+	}
+
+	// Four writes of $5A unlock the registers.
+	for i := 0; i < zipUnlockCount; i++ {
+		a.mmu.StoreByte(0xc05a, zipUnlockValue)
+	}
+	a.mmu.StoreByte(0xc05d, 0x80)
+	a.mmu.StoreByte(0xc05c, 0x01)
+	if v := a.mmu.LoadByte(0xc05d); v != 0x80 {
+		t.Errorf("Expected speed register $80, got $%02X\n", v)
+	}
+	if v := a.mmu.LoadByte(0xc05c); v != 0x01 {
+		t.Errorf("Expected slot register $01, got $%02X\n", v)
+	}
+	if v := a.mmu.LoadByte(0xc05b); v&zipStatusDisabled != 0 {
+		t.Errorf("Expected acceleration enabled, status $%02X\n", v)
+	}
+
+	// Spin at $0300. At speed $80, the CPU runs four times faster.
+	for i, b := range []byte{0x4c, 0x00, 0x03} {
+		a.mmu.StoreByte(0x300+uint16(i), b)
+	}
+	a.cpu.SetPC(0x300)
+	run := func(n int) uint64 {
+		start := a.cpu.Cycles
+		for i := 0; i < n; i++ {
+			a.step()
+		}
+		return a.cpu.Cycles - start
+	}
+	if c := run(400); c != 300 {
+		t.Errorf("Expected 300 cycles at 4x, got %d\n", c)
+	}
+
+	// Speaker access slows the CPU to 1 MHz for a while.
+	a.mmu.LoadByte(0xc030)
+	if c := run(400); c != 1200 {
+		t.Errorf("Expected 1200 cycles after speaker access, got %d\n", c)
+	}
+	run(zipSlowdownCycles / 3)
+	if c := run(400); c != 300 {
+		t.Errorf("Expected 300 cycles after the slowdown, got %d\n", c)
+	}
+
+	// Paddle access slows the CPU unless its delay is disabled.
+	a.mmu.LoadByte(0xc070)
+	if c := run(400); c != 1200 {
+		t.Errorf("Expected 1200 cycles after paddle access, got %d\n", c)
+	}
+	run(zipSlowdownCycles / 3)
+	a.mmu.StoreByte(0xc05f, zipFlagNoPaddleDelay)
+	a.mmu.LoadByte(0xc070)
+	if c := run(400); c != 300 {
+		t.Errorf("Expected 300 cycles with the paddle delay disabled, got %d\n", c)
+	}
+
+	// Slot accesses slow the CPU only for selected slots.
+	a.mmu.LoadByte(0xc0e0)
+	if c := run(400); c != 300 {
+		t.Errorf("Expected 300 cycles after unselected slot access, got %d\n", c)
+	}
+	a.mmu.StoreByte(0xc05c, 1<<6)
+	a.mmu.LoadByte(0xc0e0)
+	if c := run(400); c != 1200 {
+		t.Errorf("Expected 1200 cycles after selected slot access, got %d\n", c)
+	}
+	run(zipSlowdownCycles / 3)
+
+	// Disabling acceleration runs at 1 MHz.
+	a.mmu.StoreByte(0xc05b, 0)
+	if v := a.mmu.LoadByte(0xc05b); v&zipStatusDisabled == 0 {
+		t.Errorf("Expected acceleration disabled, status $%02X\n", v)
+	}
+	if c := run(400); c != 1200 {
+		t.Errorf("Expected 1200 cycles while disabled, got %d\n", c)
+	}
+	a.mmu.StoreByte(0xc05a, 0)
+	if z.Speed() == 16 {
+		t.Errorf("Expected acceleration enabled again\n")
+	}
+
+	// $A5 locks the registers again.
+	a.mmu.StoreByte(0xc05a, zipLockValue)
+	if z.unlocked {
+		t.Errorf("Expected the registers locked\n")
+	}
+	a.mmu.StoreByte(0xc05d, 0)
+	if z.speed != 0x80 {
+		t.Errorf("Expected writes ignored while locked\n")
+	}
+}